@@ -0,0 +1,64 @@
+// Command paz-proxy is an example reverse proxy that enforces PingAuthorize sideband policies
+// without Kong, using the pingauthorize net/http middleware.
+//
+// The config file uses the same JSON field names as the Kong plugin configuration:
+//
+//	paz-proxy -listen :8080 -upstream http://localhost:3000 -config paz.json
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+
+	"github.com/idpartners/idpartners-ping-authorize/pingauthorize"
+)
+
+func main() {
+	listen := flag.String("listen", ":8080", "address to listen on")
+	upstream := flag.String("upstream", "", "upstream base URL to proxy allowed requests to")
+	configPath := flag.String("config", "", "path to a JSON file with the plugin configuration")
+	flag.Parse()
+
+	if *upstream == "" || *configPath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	conf, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("[%s] %v", pingauthorize.PluginName, err)
+	}
+
+	target, err := url.Parse(*upstream)
+	if err != nil {
+		log.Fatalf("[%s] invalid upstream URL: %v", pingauthorize.PluginName, err)
+	}
+
+	mw, err := pingauthorize.NewMiddleware(conf)
+	if err != nil {
+		log.Fatalf("[%s] invalid configuration: %v", pingauthorize.PluginName, err)
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	log.Printf("[%s] listening on %s, proxying to %s", pingauthorize.PluginName, *listen, target)
+	log.Fatal(http.ListenAndServe(*listen, mw.Handler(proxy)))
+}
+
+// loadConfig reads the plugin configuration, starting from the plugin defaults.
+func loadConfig(path string) (*pingauthorize.Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	conf := pingauthorize.NewConfig()
+	if err := json.Unmarshal(data, conf); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+	return conf, nil
+}
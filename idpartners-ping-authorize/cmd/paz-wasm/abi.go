@@ -0,0 +1,214 @@
+//go:build wasip1 && go1.24
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"unsafe"
+)
+
+// Values of the proxy-wasm ABI 0.2.1.
+const (
+	actionContinue = 0
+	actionPause    = 1
+
+	bufferHTTPRequestBody      = 0
+	bufferHTTPCallResponseBody = 4
+	bufferPluginConfiguration  = 7
+
+	mapHTTPRequestHeaders      = 0
+	mapHTTPCallResponseHeaders = 6
+
+	streamHTTPRequest = 0
+
+	logDebug = 1
+	logInfo  = 2
+	logWarn  = 3
+	logError = 4
+
+	statusOK       = 0
+	statusNotFound = 1
+)
+
+//go:wasmimport env proxy_log
+func proxyLog(level uint32, msg unsafe.Pointer, msgSize uint32) uint32
+
+//go:wasmimport env proxy_get_buffer_bytes
+func proxyGetBufferBytes(bufferType, start, maxSize uint32, returnData *uint32, returnSize *uint32) uint32
+
+//go:wasmimport env proxy_set_buffer_bytes
+func proxySetBufferBytes(bufferType, start, size uint32, data unsafe.Pointer, dataSize uint32) uint32
+
+//go:wasmimport env proxy_get_header_map_pairs
+func proxyGetHeaderMapPairs(mapType uint32, returnData *uint32, returnSize *uint32) uint32
+
+//go:wasmimport env proxy_set_header_map_pairs
+func proxySetHeaderMapPairs(mapType uint32, data unsafe.Pointer, size uint32) uint32
+
+//go:wasmimport env proxy_get_property
+func proxyGetProperty(path unsafe.Pointer, pathSize uint32, returnData *uint32, returnSize *uint32) uint32
+
+//go:wasmimport env proxy_send_local_response
+func proxySendLocalResponse(statusCode uint32, details unsafe.Pointer, detailsSize uint32, body unsafe.Pointer, bodySize uint32,
+	headers unsafe.Pointer, headersSize uint32, grpcStatus int32) uint32
+
+//go:wasmimport env proxy_http_call
+func proxyHTTPCall(upstream unsafe.Pointer, upstreamSize uint32, headers unsafe.Pointer, headersSize uint32, body unsafe.Pointer, bodySize uint32,
+	trailers unsafe.Pointer, trailersSize uint32, timeoutMs uint32, returnToken *uint32) uint32
+
+//go:wasmimport env proxy_set_effective_context
+func proxySetEffectiveContext(contextID uint32) uint32
+
+//go:wasmimport env proxy_continue_stream
+func proxyContinueStream(streamType uint32) uint32
+
+// allocations keeps the buffers handed to the host by proxy_on_memory_allocate until the host
+// call that asked for them returns, so that the garbage collector does not free them.
+var allocations = map[uint32][]byte{}
+
+//go:wasmexport proxy_abi_version_0_2_1
+func proxyABIVersion() {}
+
+//go:wasmexport proxy_on_memory_allocate
+func proxyOnMemoryAllocate(size uint32) uint32 {
+	buf := make([]byte, max(size, 1))
+	addr := uint32(uintptr(unsafe.Pointer(&buf[0])))
+	allocations[addr] = buf
+	return addr
+}
+
+// takeAllocation returns the size bytes the host wrote to the buffer at addr, and releases it.
+func takeAllocation(addr, size uint32) []byte {
+	buf, ok := allocations[addr]
+	if !ok {
+		return nil
+	}
+	delete(allocations, addr)
+	return buf[:size]
+}
+
+// ptr returns the address of b for the host, nil for an empty b.
+func ptr(b []byte) unsafe.Pointer {
+	if len(b) == 0 {
+		return nil
+	}
+	return unsafe.Pointer(&b[0])
+}
+
+func logf(level uint32, format string, args ...interface{}) {
+	msg := []byte(fmt.Sprintf(format, args...))
+	proxyLog(level, ptr(msg), uint32(len(msg)))
+}
+
+func getBufferBytes(bufferType, size uint32) ([]byte, error) {
+	var data, n uint32
+	if status := proxyGetBufferBytes(bufferType, 0, size, &data, &n); status == statusNotFound {
+		return nil, nil
+	} else if status != statusOK {
+		return nil, fmt.Errorf("proxy_get_buffer_bytes(%d) failed with status %d", bufferType, status)
+	}
+	return takeAllocation(data, n), nil
+}
+
+func setBufferBytes(bufferType, replace uint32, body []byte) error {
+	if status := proxySetBufferBytes(bufferType, 0, replace, ptr(body), uint32(len(body))); status != statusOK {
+		return fmt.Errorf("proxy_set_buffer_bytes(%d) failed with status %d", bufferType, status)
+	}
+	return nil
+}
+
+func getHeaderMap(mapType uint32) ([][2]string, error) {
+	var data, n uint32
+	if status := proxyGetHeaderMapPairs(mapType, &data, &n); status != statusOK {
+		return nil, fmt.Errorf("proxy_get_header_map_pairs(%d) failed with status %d", mapType, status)
+	}
+	return decodePairs(takeAllocation(data, n)), nil
+}
+
+func setHeaderMap(mapType uint32, pairs [][2]string) error {
+	data := encodePairs(pairs)
+	if status := proxySetHeaderMapPairs(mapType, ptr(data), uint32(len(data))); status != statusOK {
+		return fmt.Errorf("proxy_set_header_map_pairs(%d) failed with status %d", mapType, status)
+	}
+	return nil
+}
+
+// getProperty returns the host property at path, such as source.address, or "" when the host
+// does not have it.
+func getProperty(path ...string) string {
+	var p []byte
+	for _, segment := range path {
+		p = append(append(p, segment...), 0)
+	}
+	var data, n uint32
+	if proxyGetProperty(ptr(p), uint32(len(p)), &data, &n) != statusOK {
+		return ""
+	}
+	return string(takeAllocation(data, n))
+}
+
+func sendLocalResponse(status int, header [][2]string, body []byte) error {
+	headers := encodePairs(header)
+	if code := proxySendLocalResponse(uint32(status), nil, 0, ptr(body), uint32(len(body)), ptr(headers), uint32(len(headers)), -1); code != statusOK {
+		return fmt.Errorf("proxy_send_local_response failed with status %d", code)
+	}
+	return nil
+}
+
+func httpCall(upstream string, header [][2]string, body []byte, timeoutMs uint32) (uint32, error) {
+	u := []byte(upstream)
+	headers := encodePairs(header)
+	var token uint32
+	if status := proxyHTTPCall(ptr(u), uint32(len(u)), ptr(headers), uint32(len(headers)), ptr(body), uint32(len(body)),
+		nil, 0, timeoutMs, &token); status != statusOK {
+		return 0, fmt.Errorf("proxy_http_call to %s failed with status %d", upstream, status)
+	}
+	return token, nil
+}
+
+// encodePairs serializes header pairs in the proxy-wasm map format: the number of pairs, the
+// sizes of every name and value, then the names and values, each followed by a NUL byte.
+func encodePairs(pairs [][2]string) []byte {
+	size := 4
+	for _, p := range pairs {
+		size += 8 + len(p[0]) + len(p[1]) + 2
+	}
+	buf := make([]byte, 4, size)
+	binary.LittleEndian.PutUint32(buf, uint32(len(pairs)))
+	for _, p := range pairs {
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(p[0])))
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(p[1])))
+	}
+	for _, p := range pairs {
+		buf = append(append(buf, p[0]...), 0)
+		buf = append(append(buf, p[1]...), 0)
+	}
+	return buf
+}
+
+// decodePairs reverses encodePairs, returning what could be read of a malformed map.
+func decodePairs(data []byte) [][2]string {
+	if len(data) < 4 {
+		return nil
+	}
+	n := int(binary.LittleEndian.Uint32(data))
+	if len(data) < 4+8*n {
+		return nil
+	}
+	pairs := make([][2]string, 0, n)
+	pos := 4 + 8*n
+	for i := 0; i < n; i++ {
+		nameSize := int(binary.LittleEndian.Uint32(data[4+8*i:]))
+		valueSize := int(binary.LittleEndian.Uint32(data[8+8*i:]))
+		if pos+nameSize+valueSize+2 > len(data) {
+			break
+		}
+		name := string(data[pos : pos+nameSize])
+		pos += nameSize + 1
+		value := string(data[pos : pos+valueSize])
+		pos += valueSize + 1
+		pairs = append(pairs, [2]string{name, value})
+	}
+	return pairs
+}
@@ -0,0 +1,318 @@
+//go:build wasip1 && go1.24
+
+// Command paz-wasm runs the PingAuthorize access phase as a proxy-wasm filter, for Kong data
+// planes that load WASM filters but cannot run a go-pdk plugin server. It shares payload
+// composition, MCP handling and the local access decisions with the plugin, and makes the
+// sideband call with the host's HTTP dispatch. Build it with Go 1.24 or later:
+//
+//	GOOS=wasip1 GOARCH=wasm go build -buildmode=c-shared -o paz.wasm ./cmd/paz-wasm
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/idpartners/idpartners-ping-authorize/pingauthorize"
+)
+
+// stream is the state of one request, an HTTP context of the filter.
+type stream struct {
+	id      uint32
+	rootID  uint32
+	headers [][2]string
+	// bodySize is the size of the buffered request body, replaced when the policy changes it.
+	bodySize uint32
+	r        *http.Request
+	access   *pingauthorize.AccessCall
+}
+
+var (
+	// configs holds the plugin configuration of each root context, nil until it is configured.
+	configs = map[uint32]*pingauthorize.Config{}
+	streams = map[uint32]*stream{}
+	// calls maps the tokens of pending sideband calls to their requests.
+	calls = map[uint32]*stream{}
+)
+
+//go:wasmexport proxy_on_context_create
+func proxyOnContextCreate(contextID, parentID uint32) {
+	if parentID == 0 {
+		configs[contextID] = nil
+		return
+	}
+	streams[contextID] = &stream{id: contextID, rootID: parentID}
+}
+
+//go:wasmexport proxy_on_vm_start
+func proxyOnVMStart(rootID, vmConfigurationSize uint32) uint32 {
+	return 1
+}
+
+//go:wasmexport proxy_on_configure
+func proxyOnConfigure(rootID, configurationSize uint32) uint32 {
+	data, err := getBufferBytes(bufferPluginConfiguration, configurationSize)
+	if err != nil {
+		logf(logError, "[%s] Failed to read filter configuration: %v", pingauthorize.PluginName, err)
+		return 0
+	}
+	conf, err := loadConfig(data)
+	if err != nil {
+		logf(logError, "[%s] Invalid filter configuration: %v", pingauthorize.PluginName, err)
+		return 0
+	}
+	configs[rootID] = conf
+	return 1
+}
+
+// loadConfig parses the filter configuration, the JSON of the plugin configuration, starting
+// from the plugin defaults.
+func loadConfig(data []byte) (*pingauthorize.Config, error) {
+	conf := pingauthorize.NewConfig()
+	if err := json.Unmarshal(data, conf); err != nil {
+		return nil, fmt.Errorf("failed to parse configuration: %w", err)
+	}
+	if conf.ProviderType != "" && conf.ProviderType != pingauthorize.ProviderSideband {
+		return nil, fmt.Errorf("provider_type %q is not supported by the WASM filter", conf.ProviderType)
+	}
+	// The filter has no network access of its own, only the sideband call dispatched by the host
+	if conf.JWTJWKSURL != "" || conf.TokenIntrospectionURL != "" {
+		return nil, fmt.Errorf("jwt_jwks_url and token_introspection_url are not supported by the WASM filter")
+	}
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+//go:wasmexport proxy_on_request_headers
+func proxyOnRequestHeaders(contextID, numHeaders, endOfStream uint32) uint32 {
+	s := streams[contextID]
+	if s == nil || configs[s.rootID] == nil {
+		return actionContinue
+	}
+	headers, err := getHeaderMap(mapHTTPRequestHeaders)
+	if err != nil {
+		logf(logError, "[%s] Failed to get request headers: %v", pingauthorize.PluginName, err)
+		return s.deny(http.StatusInternalServerError, nil, nil)
+	}
+	s.headers = headers
+	if endOfStream == 1 || s.skipsBody() {
+		return s.evaluate(nil)
+	}
+	// Wait for the whole body, which is part of the payload
+	return actionPause
+}
+
+//go:wasmexport proxy_on_request_body
+func proxyOnRequestBody(contextID, bodySize, endOfStream uint32) uint32 {
+	s := streams[contextID]
+	if s == nil || configs[s.rootID] == nil || s.headers == nil || s.r != nil {
+		return actionContinue
+	}
+	if endOfStream == 0 {
+		return actionPause
+	}
+	body, err := getBufferBytes(bufferHTTPRequestBody, bodySize)
+	if err != nil {
+		logf(logError, "[%s] Failed to get request body: %v", pingauthorize.PluginName, err)
+		return s.deny(http.StatusInternalServerError, nil, nil)
+	}
+	s.bodySize = uint32(len(body))
+	return s.evaluate(body)
+}
+
+//go:wasmexport proxy_on_http_call_response
+func proxyOnHTTPCallResponse(rootID, token, numHeaders, bodySize, numTrailers uint32) {
+	s := calls[token]
+	if s == nil {
+		return
+	}
+	delete(calls, token)
+	proxySetEffectiveContext(s.id)
+
+	status, body, err := 0, []byte(nil), error(nil)
+	if numHeaders == 0 {
+		err = fmt.Errorf("sideband call failed")
+	} else {
+		var headers [][2]string
+		if headers, err = getHeaderMap(mapHTTPCallResponseHeaders); err == nil {
+			for _, h := range headers {
+				if h[0] == ":status" {
+					status, _ = strconv.Atoi(h[1])
+				}
+			}
+			body, err = getBufferBytes(bufferHTTPCallResponseBody, bodySize)
+		}
+	}
+	if s.complete(status, body, err) == actionContinue {
+		proxyContinueStream(streamHTTPRequest)
+	}
+}
+
+//go:wasmexport proxy_on_done
+func proxyOnDone(contextID uint32) uint32 {
+	return 1
+}
+
+//go:wasmexport proxy_on_log
+func proxyOnLog(contextID uint32) {}
+
+//go:wasmexport proxy_on_delete
+func proxyOnDelete(contextID uint32) {
+	delete(streams, contextID)
+	delete(configs, contextID)
+}
+
+// skipsBody reports whether skip_body_methods lists the request method.
+func (s *stream) skipsBody() bool {
+	for _, h := range s.headers {
+		if h[0] == ":method" {
+			for _, m := range configs[s.rootID].SkipBodyMethods {
+				if strings.EqualFold(m, h[1]) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// evaluate composes the access payload of the request and makes the local decisions, then
+// dispatches the sideband call and pauses the request until it completes.
+func (s *stream) evaluate(body []byte) uint32 {
+	conf := configs[s.rootID]
+	r, err := s.request(body)
+	if err != nil {
+		logf(logError, "[%s] Failed to build request: %v", pingauthorize.PluginName, err)
+		return s.deny(http.StatusBadRequest, nil, nil)
+	}
+	s.r = r
+	if s.access, err = pingauthorize.NewAccessCall(r, conf); err != nil {
+		logf(logError, "[%s] Failed to compose access payload: %v", pingauthorize.PluginName, err)
+		return s.deny(http.StatusBadRequest, nil, nil)
+	}
+	if d := s.access.Denial; d != nil {
+		return s.deny(d.StatusCode, d.Header, d.Body)
+	}
+	if s.access.Call == nil {
+		return actionContinue
+	}
+
+	call := s.access.Call
+	u, err := url.Parse(call.URL)
+	if err != nil {
+		return s.complete(0, nil, err)
+	}
+	headers := [][2]string{{":method", http.MethodPost}, {":path", u.RequestURI()}, {":authority", u.Host}, {":scheme", u.Scheme},
+		{"content-length", strconv.Itoa(len(call.Body))}}
+	for name, values := range call.Header {
+		for _, v := range values {
+			headers = append(headers, [2]string{strings.ToLower(name), v})
+		}
+	}
+	timeoutMs := conf.ConnectionTimeoutMs
+	if conf.AccessTimeoutMs > 0 {
+		timeoutMs = conf.AccessTimeoutMs
+	}
+	token, err := httpCall(u.Host, headers, call.Body, uint32(timeoutMs))
+	if err != nil {
+		return s.complete(0, nil, err)
+	}
+	calls[token] = s
+	return actionPause
+}
+
+// complete applies the result of the sideband call: the request is denied, or forwarded with
+// the policy's changes.
+func (s *stream) complete(status int, body []byte, err error) uint32 {
+	denial, forwardBody := s.access.Complete(status, body, err)
+	if denial != nil {
+		return s.deny(denial.StatusCode, denial.Header, denial.Body)
+	}
+
+	r := s.r
+	headers := [][2]string{{":method", r.Method}, {":path", r.URL.RequestURI()}, {":authority", r.Host}, {":scheme", s.scheme()}}
+	for name, values := range r.Header {
+		for _, v := range values {
+			headers = append(headers, [2]string{strings.ToLower(name), v})
+		}
+	}
+	if uint32(len(forwardBody)) != s.bodySize || r.Header.Get("Content-Length") == "" && s.bodySize > 0 {
+		headers = append(headers, [2]string{"content-length", strconv.Itoa(len(forwardBody))})
+		if err := setBufferBytes(bufferHTTPRequestBody, s.bodySize, forwardBody); err != nil {
+			logf(logError, "[%s] Failed to replace request body: %v", pingauthorize.PluginName, err)
+			return s.deny(http.StatusInternalServerError, nil, nil)
+		}
+	}
+	if err := setHeaderMap(mapHTTPRequestHeaders, headers); err != nil {
+		logf(logError, "[%s] Failed to update request headers: %v", pingauthorize.PluginName, err)
+		return s.deny(http.StatusInternalServerError, nil, nil)
+	}
+	return actionContinue
+}
+
+// deny answers the request with a local response.
+func (s *stream) deny(status int, header map[string][]string, body []byte) uint32 {
+	var headers [][2]string
+	for name, values := range header {
+		for _, v := range values {
+			headers = append(headers, [2]string{strings.ToLower(name), v})
+		}
+	}
+	if err := sendLocalResponse(status, headers, body); err != nil {
+		logf(logError, "[%s] Failed to send response: %v", pingauthorize.PluginName, err)
+	}
+	return actionPause
+}
+
+// request builds the net/http request the plugin code composes the payload from.
+func (s *stream) request(body []byte) (*http.Request, error) {
+	var method, path, authority string
+	header := http.Header{}
+	for _, h := range s.headers {
+		switch h[0] {
+		case ":method":
+			method = h[1]
+		case ":path":
+			path = h[1]
+		case ":authority":
+			authority = h[1]
+		case ":scheme":
+		default:
+			header.Add(h[0], h[1])
+		}
+	}
+	r, err := http.NewRequest(method, s.scheme()+"://"+authority+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	r.Header, r.Host = header, authority
+	if s.scheme() == "https" {
+		r.TLS = &tls.ConnectionState{}
+	}
+
+	r.RemoteAddr = getProperty("source", "address")
+	if _, _, err := net.SplitHostPort(r.RemoteAddr); err != nil {
+		r.RemoteAddr = net.JoinHostPort(r.RemoteAddr, getProperty("source", "port"))
+	}
+	return r, nil
+}
+
+// scheme returns the scheme of the client request.
+func (s *stream) scheme() string {
+	for _, h := range s.headers {
+		if h[0] == ":scheme" {
+			return h[1]
+		}
+	}
+	return "http"
+}
+
+func main() {}
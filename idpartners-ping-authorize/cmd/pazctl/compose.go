@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/idpartners/idpartners-ping-authorize/pingauthorize"
+)
+
+// headerFlags collects repeated "Name: value" flags.
+type headerFlags []string
+
+func (h *headerFlags) String() string     { return strings.Join(*h, ", ") }
+func (h *headerFlags) Set(v string) error { *h = append(*h, v); return nil }
+
+// composeInput is the client request (and optional upstream response) a payload is composed for.
+type composeInput struct {
+	request *http.Request
+
+	hasResponse    bool
+	responseStatus int
+	responseHeader http.Header
+	responseBody   []byte
+}
+
+func runCompose(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("compose", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: pazctl compose [flags] <url>\n       pazctl compose [flags] -har <file>\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	configPath := fs.String("config", "", "path to a JSON file with the plugin configuration")
+	method := fs.String("X", "", "request method (default GET, or POST when -d is set)")
+	var headers headerFlags
+	fs.Var(&headers, "H", "request header \"Name: value\" (repeatable)")
+	data := fs.String("d", "", "request body; @file reads it from a file")
+	certPath := fs.String("cert", "", "PEM file with the client certificate chain (https URLs only)")
+	sourceAddr := fs.String("source", "127.0.0.1:50000", "client ip:port")
+	harPath := fs.String("har", "", "HAR file to read the request (and response) from instead of flags")
+	harEntry := fs.Int("entry", 0, "index of the HAR entry to use")
+	phase := fs.String("phase", "access", "payload to print: access, response or both")
+	responseStatus := fs.Int("response-status", 200, "upstream response status for the response payload")
+	var responseHeaders headerFlags
+	fs.Var(&responseHeaders, "response-header", "upstream response header \"Name: value\" (repeatable)")
+	responseBody := fs.String("response-body", "", "upstream response body; @file reads it from a file")
+	state := fs.String("state", "", "access phase state JSON to send instead of the original request")
+	redact := fs.Bool("redact", true, "redact redact_headers and secret_header_name values in the output")
+
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if *phase != "access" && *phase != "response" && *phase != "both" {
+		return fmt.Errorf("-phase must be access, response or both")
+	}
+	if *state != "" && !json.Valid([]byte(*state)) {
+		return fmt.Errorf("-state is not valid JSON")
+	}
+
+	conf, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+
+	var in *composeInput
+	if *harPath != "" {
+		in, err = readHAREntry(*harPath, *harEntry)
+	} else {
+		if len(positional) != 1 {
+			fs.Usage()
+			return fmt.Errorf("expected exactly one URL")
+		}
+		in, err = requestFromFlags(positional[0], *method, headers, *data, *certPath)
+		if err == nil {
+			in.hasResponse = true
+			in.responseStatus = *responseStatus
+			in.responseHeader, err = parseHeaderFlags(responseHeaders)
+		}
+		if err == nil {
+			in.responseBody, err = readDataFlag(*responseBody)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if in.request.RemoteAddr == "" {
+		in.request.RemoteAddr = *sourceAddr
+	}
+
+	access, skip, err := pingauthorize.ComposeAccessPayload(in.request, conf)
+	if err != nil {
+		return fmt.Errorf("failed to compose access payload: %w", err)
+	}
+	if skip {
+		fmt.Fprintln(stderr, "note: skip_expression matched; the plugin would not call PingAuthorize for this request")
+	}
+
+	out := map[string]interface{}{}
+	if *phase != "response" {
+		out["access"] = access
+	}
+	if *phase != "access" {
+		if !in.hasResponse {
+			return fmt.Errorf("HAR entry %d has no response", *harEntry)
+		}
+		response, err := pingauthorize.ComposeResponsePayload(in.request, conf, in.responseStatus, in.responseHeader, in.responseBody, access, json.RawMessage(*state))
+		if err != nil {
+			return fmt.Errorf("failed to compose response payload: %w", err)
+		}
+		out["response"] = response
+	}
+
+	if *redact {
+		redactPayloads(out, conf)
+	}
+
+	enc := json.NewEncoder(stdout)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	if *phase == "both" {
+		return enc.Encode(out)
+	}
+	return enc.Encode(out[*phase])
+}
+
+// parseInterspersed parses flags that may appear before or after positional arguments, as curl allows.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// requestFromFlags builds the client request from curl-like flags.
+func requestFromFlags(rawURL, method string, headers headerFlags, data, certPath string) (*composeInput, error) {
+	body, err := readDataFlag(data)
+	if err != nil {
+		return nil, err
+	}
+	if method == "" {
+		method = http.MethodGet
+		if data != "" {
+			method = http.MethodPost
+		}
+	}
+	header, err := parseHeaderFlags(headers)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []*x509.Certificate
+	if certPath != "" {
+		certs, err = readCertificates(certPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	r, err := newClientRequest(method, rawURL, "", header, body, certs)
+	if err != nil {
+		return nil, err
+	}
+	return &composeInput{request: r}, nil
+}
+
+// newClientRequest builds an inbound-style request as the plugin would receive it.
+func newClientRequest(method, rawURL, httpVersion string, header http.Header, body []byte, certs []*x509.Certificate) (*http.Request, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid URL %q", rawURL)
+	}
+	r, err := http.NewRequest(strings.ToUpper(method), u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("invalid request: %w", err)
+	}
+
+	r.Header = header
+	if host := header.Get("Host"); host != "" {
+		r.Host = host
+		header.Del("Host")
+	} else {
+		r.Host = u.Host
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "https":
+		r.TLS = &tls.ConnectionState{PeerCertificates: certs}
+	case "http":
+		if len(certs) > 0 {
+			return nil, fmt.Errorf("-cert requires an https URL")
+		}
+	default:
+		return nil, fmt.Errorf("URL scheme must be http or https, got %q", u.Scheme)
+	}
+
+	if httpVersion != "" {
+		major, minor, ok := http.ParseHTTPVersion(strings.ToUpper(httpVersion))
+		if !ok && strings.HasPrefix(strings.ToLower(httpVersion), "http/2") {
+			major, minor, ok = 2, 0, true
+		}
+		if ok {
+			r.Proto, r.ProtoMajor, r.ProtoMinor = httpVersion, major, minor
+		}
+	}
+	return r, nil
+}
+
+// parseHeaderFlags parses "Name: value" strings into a header map.
+func parseHeaderFlags(values []string) (http.Header, error) {
+	header := make(http.Header)
+	for _, v := range values {
+		name, value, ok := strings.Cut(v, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("header %q must have the form \"Name: value\"", v)
+		}
+		header.Add(name, strings.TrimSpace(value))
+	}
+	return header, nil
+}
+
+// readDataFlag returns the flag value, or the contents of the file for "@path" values.
+func readDataFlag(v string) ([]byte, error) {
+	if !strings.HasPrefix(v, "@") {
+		return []byte(v), nil
+	}
+	data, err := os.ReadFile(v[1:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", v[1:], err)
+	}
+	return data, nil
+}
+
+// readCertificates reads a PEM certificate chain.
+func readCertificates(path string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate: %w", err)
+	}
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return certs, nil
+}
+
+// harFile is the subset of the HAR 1.2 format used by compose.
+type harFile struct {
+	Log struct {
+		Entries []harEntry `json:"entries"`
+	} `json:"log"`
+}
+
+type harEntry struct {
+	Request  harRequest  `json:"request"`
+	Response harResponse `json:"response"`
+}
+
+type harRequest struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	HTTPVersion string      `json:"httpVersion"`
+	Headers     []harHeader `json:"headers"`
+	PostData    *struct {
+		Text string `json:"text"`
+	} `json:"postData"`
+}
+
+type harResponse struct {
+	Status  int         `json:"status"`
+	Headers []harHeader `json:"headers"`
+	Content struct {
+		Text     string `json:"text"`
+		Encoding string `json:"encoding"`
+	} `json:"content"`
+}
+
+type harHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// readHAREntry loads entry index from a HAR file. HTTP/2 pseudo-headers are dropped.
+func readHAREntry(path string, index int) (*composeInput, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read HAR file: %w", err)
+	}
+	var har harFile
+	if err := json.Unmarshal(data, &har); err != nil {
+		return nil, fmt.Errorf("failed to parse HAR file: %w", err)
+	}
+	if index < 0 || index >= len(har.Log.Entries) {
+		return nil, fmt.Errorf("HAR file has %d entries, -entry %d is out of range", len(har.Log.Entries), index)
+	}
+	entry := har.Log.Entries[index]
+
+	var body []byte
+	if entry.Request.PostData != nil {
+		body = []byte(entry.Request.PostData.Text)
+	}
+	r, err := newClientRequest(entry.Request.Method, entry.Request.URL, entry.Request.HTTPVersion, harHeaders(entry.Request.Headers), body, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	in := &composeInput{request: r}
+	if entry.Response.Status > 0 {
+		in.hasResponse = true
+		in.responseStatus = entry.Response.Status
+		in.responseHeader = harHeaders(entry.Response.Headers)
+		in.responseBody = []byte(entry.Response.Content.Text)
+		if entry.Response.Content.Encoding == "base64" {
+			if in.responseBody, err = base64.StdEncoding.DecodeString(entry.Response.Content.Text); err != nil {
+				return nil, fmt.Errorf("failed to decode HAR response content: %w", err)
+			}
+		}
+	}
+	return in, nil
+}
+
+func harHeaders(headers []harHeader) http.Header {
+	header := make(http.Header)
+	for _, h := range headers {
+		if strings.HasPrefix(h.Name, ":") {
+			if h.Name == ":authority" {
+				header.Set("Host", h.Value)
+			}
+			continue
+		}
+		header.Add(h.Name, h.Value)
+	}
+	return header
+}
+
+// redactPayloads replaces the values of redact_headers and secret_header_name in every payload,
+// as debug logging does.
+func redactPayloads(payloads map[string]interface{}, conf *pingauthorize.Config) {
+	redactSet := make(map[string]bool, len(conf.RedactHeaders))
+	for _, name := range conf.RedactHeaders {
+		redactSet[strings.ToLower(name)] = true
+	}
+	for _, p := range payloads {
+		switch p := p.(type) {
+		case *pingauthorize.SidebandAccessRequest:
+			redactAccessRequest(p, redactSet, conf.SecretHeaderName)
+		case *pingauthorize.SidebandResponsePayload:
+			p.Headers = pingauthorize.RedactHeaders(p.Headers, redactSet, conf.SecretHeaderName)
+			if p.Request != nil {
+				redactAccessRequest(p.Request, redactSet, conf.SecretHeaderName)
+			}
+		}
+	}
+}
+
+// redactAccessRequest redacts the headers and extracted headers of p in place.
+func redactAccessRequest(p *pingauthorize.SidebandAccessRequest, redactSet map[string]bool, secretHeaderName string) {
+	p.Headers = pingauthorize.RedactHeaders(p.Headers, redactSet, secretHeaderName)
+	for name := range p.ExtractedHeaders {
+		if redactSet[name] || name == strings.ToLower(secretHeaderName) {
+			p.ExtractedHeaders[name] = "[REDACTED]"
+		}
+	}
+}
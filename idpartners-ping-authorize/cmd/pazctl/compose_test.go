@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/idpartners/idpartners-ping-authorize/pingauthorize"
+)
+
+func writeTempFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func headerValue(headers []map[string]string, name string) string {
+	for _, h := range headers {
+		if v, ok := h[name]; ok {
+			return v
+		}
+	}
+	return ""
+}
+
+func TestCompose_FlagsAccess(t *testing.T) {
+	config := writeTempFile(t, "paz.json", `{"secret_header_name":"X-Secret","extract_headers":["x-tenant"]}`)
+
+	var stdout, stderr bytes.Buffer
+	err := runCompose([]string{
+		"https://api.example.com/mcp?x=1", "-config", config,
+		"-H", "Authorization: Bearer abc", "-H", "X-Tenant: acme",
+		"-d", `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"search"}}`,
+	}, &stdout, &stderr)
+	if err != nil {
+		t.Fatalf("runCompose() error: %v (%s)", err, stderr.String())
+	}
+
+	var payload pingauthorize.SidebandAccessRequest
+	if err := json.Unmarshal(stdout.Bytes(), &payload); err != nil {
+		t.Fatalf("output is not an access payload: %v\n%s", err, stdout.String())
+	}
+	if payload.Method != "POST" || payload.URL != "https://api.example.com:443/mcp?x=1" {
+		t.Errorf("unexpected method/url: %s %s", payload.Method, payload.URL)
+	}
+	if payload.SourceIP != "127.0.0.1" || payload.SourcePort != "50000" {
+		t.Errorf("unexpected source: %s:%s", payload.SourceIP, payload.SourcePort)
+	}
+	if got := headerValue(payload.Headers, "authorization"); got != "[REDACTED]" {
+		t.Errorf("expected authorization to be redacted, got %q", got)
+	}
+	if got := headerValue(payload.Headers, "host"); got != "api.example.com" {
+		t.Errorf("expected host header, got %q", got)
+	}
+	if payload.ExtractedHeaders["x-tenant"] != "acme" {
+		t.Errorf("expected extracted header, got %v", payload.ExtractedHeaders)
+	}
+}
+
+func TestCompose_NoRedact(t *testing.T) {
+	var stdout bytes.Buffer
+	err := runCompose([]string{"-redact=false", "-H", "Cookie: a=b", "http://api.example.com/"}, &stdout, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stdout.String(), `"cookie": "a=b"`) {
+		t.Errorf("expected unredacted cookie:\n%s", stdout.String())
+	}
+}
+
+func TestCompose_HARBoth(t *testing.T) {
+	har := writeTempFile(t, "session.har", `{"log":{"entries":[
+		{"request":{"method":"GET","url":"https://api.example.com/a","httpVersion":"HTTP/1.1","headers":[]}},
+		{"request":{"method":"POST","url":"https://api.example.com/orders","httpVersion":"http/2.0",
+			"headers":[{"name":":authority","value":"api.example.com"},{"name":"content-type","value":"application/json"}],
+			"postData":{"mimeType":"application/json","text":"{\"qty\":2}"}},
+		 "response":{"status":201,"headers":[{"name":"content-type","value":"application/json"}],
+			"content":{"text":"eyJpZCI6N30=","encoding":"base64"}}}
+	]}}`)
+
+	var stdout bytes.Buffer
+	if err := runCompose([]string{"-har", har, "-entry", "1", "-phase", "both", "-state", `{"s":1}`}, &stdout, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	var out struct {
+		Access   pingauthorize.SidebandAccessRequest   `json:"access"`
+		Response pingauthorize.SidebandResponsePayload `json:"response"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		t.Fatalf("unexpected output: %v\n%s", err, stdout.String())
+	}
+	if out.Access.Body != `{"qty":2}` || out.Access.HTTPVersion != "2" {
+		t.Errorf("unexpected access payload: %+v", out.Access)
+	}
+	if out.Response.ResponseCode != "201" || out.Response.Body != `{"id":7}` {
+		t.Errorf("unexpected response payload: %+v", out.Response)
+	}
+	var state bytes.Buffer
+	json.Compact(&state, out.Response.State)
+	if state.String() != `{"s":1}` || out.Response.Request != nil {
+		t.Errorf("expected state instead of request, got %+v", out.Response)
+	}
+}
+
+func TestCompose_HARWithoutResponse(t *testing.T) {
+	har := writeTempFile(t, "session.har", `{"log":{"entries":[{"request":{"method":"GET","url":"https://api.example.com/a","headers":[]}}]}}`)
+	err := runCompose([]string{"-har", har, "-phase", "response"}, io.Discard, io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "no response") {
+		t.Errorf("expected missing response error, got %v", err)
+	}
+}
+
+func TestCompose_InvalidInput(t *testing.T) {
+	tests := [][]string{
+		{},
+		{"ftp://example.com/"},
+		{"-H", "bad-header", "http://example.com/"},
+		{"-phase", "sideways", "http://example.com/"},
+		{"-state", "{", "http://example.com/"},
+	}
+	for _, args := range tests {
+		if err := runCompose(args, io.Discard, io.Discard); err == nil {
+			t.Errorf("expected error for %v", args)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+// Command pazctl is an operator toolkit for the idpartners-ping-authorize plugin that runs
+// without Kong.
+//
+// Usage:
+//
+//	pazctl simulate [flags]        generate synthetic sideband traffic and report latency and decisions
+//	pazctl compose [flags] <url>   print the sideband payloads the plugin would send for a request
+//	pazctl migrate-config [file]   convert 1.x (ping-auth) plugin configs to the 2.x format
+//
+// Run "pazctl <command> -h" for the flags of a command. Config files use the same JSON field
+// names as the Kong plugin configuration.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/idpartners/idpartners-ping-authorize/pingauthorize"
+)
+
+// command is a pazctl subcommand. run receives the arguments after the command name.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string, stdout, stderr io.Writer) error
+}
+
+var commands = []command{
+	{"simulate", "generate synthetic sideband traffic and report latency and decisions", runSimulate},
+	{"compose", "print the sideband payloads the plugin would send for a request", runCompose},
+	{"migrate-config", "convert 1.x (ping-auth) plugin configs to the 2.x format", runMigrateConfig},
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run dispatches to the named subcommand and returns the process exit code.
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+		usage(stderr)
+		return 2
+	}
+
+	for _, cmd := range commands {
+		if cmd.name != args[0] {
+			continue
+		}
+		if err := cmd.run(args[1:], stdout, stderr); err != nil {
+			fmt.Fprintf(stderr, "pazctl %s: %v\n", cmd.name, err)
+			return 1
+		}
+		return 0
+	}
+
+	fmt.Fprintf(stderr, "pazctl: unknown command %q\n\n", args[0])
+	usage(stderr)
+	return 2
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: pazctl <command> [flags]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-16s %s\n", cmd.name, cmd.summary)
+	}
+}
+
+// loadConfig reads the plugin configuration, starting from the plugin defaults.
+// An empty path returns the defaults.
+func loadConfig(path string) (*pingauthorize.Config, error) {
+	conf := pingauthorize.NewConfig()
+	if path == "" {
+		return conf, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	if err := json.Unmarshal(data, conf); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+	return conf, nil
+}
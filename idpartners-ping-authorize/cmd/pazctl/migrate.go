@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/idpartners/idpartners-ping-authorize/pingauthorize"
+)
+
+// legacyPluginName is the name of the 1.x Lua plugin.
+const legacyPluginName = "ping-auth"
+
+// renamedFields maps 1.x config field names to their 2.x equivalents.
+var renamedFields = map[string]string{
+	"connection_keepAlive_ms":    "connection_keepalive_ms",
+	"verify_service_certificate": "verify_service_cert",
+}
+
+// migrationNote is one change or problem found while migrating a config.
+type migrationNote struct {
+	path    string
+	message string
+}
+
+func (n migrationNote) String() string {
+	if n.path == "" {
+		return n.message
+	}
+	return n.path + ": " + n.message
+}
+
+func runMigrateConfig(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("migrate-config", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: pazctl migrate-config [flags] [file]\n\nReads stdin when no file is given.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	outPath := fs.String("o", "", "write the migrated document to this file instead of stdout")
+	check := fs.Bool("check", false, "only report; exit with an error if anything needs migrating")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return fmt.Errorf("expected at most one input file")
+	}
+
+	var data []byte
+	var err error
+	if fs.NArg() == 1 {
+		data, err = os.ReadFile(fs.Arg(0))
+	} else {
+		data, err = io.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read input: %w", err)
+	}
+
+	out, notes, err := migrateDocument(data)
+	if err != nil {
+		return err
+	}
+	for _, n := range notes {
+		fmt.Fprintln(stderr, n)
+	}
+
+	if *check {
+		if len(notes) > 0 {
+			return fmt.Errorf("%d change(s) needed", len(notes))
+		}
+		return nil
+	}
+
+	if *outPath != "" {
+		return os.WriteFile(*outPath, out, 0o644)
+	}
+	_, err = stdout.Write(out)
+	return err
+}
+
+// migrateDocument migrates every plugin configuration found in a JSON document and returns the
+// re-encoded document with the notes describing what changed.
+//
+// The document may be a bare plugin config, a plugin entity ({"name": ..., "config": {...}}), a
+// list of entities, an Admin API listing ({"data": [...]}) or a decK/declarative config in JSON
+// form; plugin entities are found at any depth. Only entities named ping-auth or
+// idpartners-ping-authorize are touched.
+func migrateDocument(data []byte) ([]byte, []migrationNote, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse input as JSON: %w", err)
+	}
+
+	m := &migrator{}
+	if obj, ok := doc.(map[string]interface{}); ok && isBareConfig(obj) {
+		m.migrateConfig("", obj)
+	} else {
+		m.walk("", doc)
+	}
+	if m.found == 0 {
+		return nil, nil, fmt.Errorf("no %s or %s plugin configuration found", legacyPluginName, pingauthorize.PluginName)
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(doc); err != nil {
+		return nil, nil, err
+	}
+	return buf.Bytes(), m.notes, nil
+}
+
+// isBareConfig reports whether obj is a plugin config rather than an entity or container.
+func isBareConfig(obj map[string]interface{}) bool {
+	_, hasURL := obj["service_url"]
+	_, hasConfig := obj["config"]
+	return hasURL && !hasConfig
+}
+
+type migrator struct {
+	notes []migrationNote
+	found int
+}
+
+func (m *migrator) note(path, format string, args ...interface{}) {
+	m.notes = append(m.notes, migrationNote{path: path, message: fmt.Sprintf(format, args...)})
+}
+
+// walk visits every object and array in v looking for plugin entities.
+func (m *migrator) walk(path string, v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if m.migrateEntity(path, v) {
+			return
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			m.walk(joinPath(path, k), v[k])
+		}
+	case []interface{}:
+		for i, item := range v {
+			m.walk(fmt.Sprintf("%s[%d]", path, i), item)
+		}
+	}
+}
+
+// migrateEntity migrates obj if it is a plugin entity for this plugin or its 1.x predecessor.
+func (m *migrator) migrateEntity(path string, obj map[string]interface{}) bool {
+	name, _ := obj["name"].(string)
+	if name != legacyPluginName && name != pingauthorize.PluginName {
+		return false
+	}
+	conf, ok := obj["config"].(map[string]interface{})
+	if !ok {
+		return false
+	}
+
+	if name == legacyPluginName {
+		obj["name"] = pingauthorize.PluginName
+		m.note(joinPath(path, "name"), "renamed plugin %s -> %s", legacyPluginName, pingauthorize.PluginName)
+	}
+	m.migrateConfig(joinPath(path, "config"), conf)
+	return true
+}
+
+// migrateConfig renames 1.x fields in place, drops fields 2.x does not know, and reports values
+// that would fail validation.
+func (m *migrator) migrateConfig(path string, conf map[string]interface{}) {
+	m.found++
+	known := knownConfigFields()
+
+	keys := make([]string, 0, len(conf))
+	for k := range conf {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if newName, ok := renamedFields[k]; ok {
+			if _, exists := conf[newName]; exists {
+				m.note(joinPath(path, k), "dropped, %s is already set", newName)
+			} else {
+				conf[newName] = conf[k]
+				m.note(joinPath(path, k), "renamed to %s", newName)
+			}
+			delete(conf, k)
+			continue
+		}
+		if !known[k] {
+			m.note(joinPath(path, k), "removed, not a %s %s field", pingauthorize.PluginName, pingauthorize.Version)
+			delete(conf, k)
+		}
+	}
+
+	m.validate(path, conf)
+}
+
+// validate decodes the migrated config over the plugin defaults and reports validation errors.
+// Kong Vault references in shared_secret are left for Kong to resolve.
+func (m *migrator) validate(path string, conf map[string]interface{}) {
+	data, err := json.Marshal(conf)
+	if err != nil {
+		return
+	}
+	c := pingauthorize.NewConfig()
+	if err := json.Unmarshal(data, c); err != nil {
+		m.note(path, "invalid value: %v", err)
+		return
+	}
+	if err := c.Validate(); err != nil {
+		m.note(path, "needs attention: %v", err)
+	}
+}
+
+// knownConfigFields returns the JSON names of the 2.x config fields.
+func knownConfigFields() map[string]bool {
+	t := reflect.TypeOf(pingauthorize.Config{})
+	fields := make(map[string]bool, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields[name] = true
+		}
+	}
+	return fields
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func notesText(notes []migrationNote) string {
+	lines := make([]string, len(notes))
+	for i, n := range notes {
+		lines[i] = n.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestMigrateDocument_BareLuaConfig(t *testing.T) {
+	in := `{
+		"service_url": "https://paz.example.com",
+		"shared_secret": "{vault://env/paz-secret}",
+		"secret_header_name": "X-Secret",
+		"connection_timeout_ms": 5000,
+		"connection_keepAlive_ms": 30000,
+		"verify_service_certificate": false,
+		"enable_debug_logging": true
+	}`
+
+	out, notes, err := migrateDocument([]byte(in))
+	if err != nil {
+		t.Fatalf("migrateDocument() error: %v", err)
+	}
+
+	var conf map[string]interface{}
+	json.Unmarshal(out, &conf)
+	if conf["connection_keepalive_ms"] != float64(30000) || conf["verify_service_cert"] != false {
+		t.Errorf("renamed fields missing: %v", conf)
+	}
+	if _, ok := conf["connection_keepAlive_ms"]; ok {
+		t.Error("old field name should be removed")
+	}
+	if conf["shared_secret"] != "{vault://env/paz-secret}" || conf["connection_timeout_ms"] != float64(5000) {
+		t.Errorf("unchanged fields altered: %v", conf)
+	}
+
+	text := notesText(notes)
+	if !strings.Contains(text, "connection_keepAlive_ms: renamed to connection_keepalive_ms") ||
+		!strings.Contains(text, "verify_service_certificate: renamed to verify_service_cert") {
+		t.Errorf("unexpected notes:\n%s", text)
+	}
+	if len(notes) != 2 {
+		t.Errorf("expected only rename notes, got:\n%s", text)
+	}
+}
+
+func TestMigrateDocument_DeclarativeConfig(t *testing.T) {
+	in := `{
+		"_format_version": "3.0",
+		"services": [{
+			"name": "orders",
+			"plugins": [{"name": "rate-limiting", "config": {"minute": 5}}],
+			"routes": [{
+				"name": "orders-route",
+				"plugins": [{"name": "ping-auth", "config": {
+					"service_url": "https://paz.example.com",
+					"shared_secret": "s",
+					"secret_header_name": "X-Secret",
+					"legacy_option": 1
+				}}]
+			}]
+		}],
+		"plugins": [{"name": "idpartners-ping-authorize", "config": {
+			"service_url": "https://paz.example.com",
+			"shared_secret": "s",
+			"secret_header_name": "X-Secret",
+			"fail_open": true
+		}}]
+	}`
+
+	out, notes, err := migrateDocument([]byte(in))
+	if err != nil {
+		t.Fatalf("migrateDocument() error: %v", err)
+	}
+
+	var doc struct {
+		Services []struct {
+			Plugins []map[string]interface{} `json:"plugins"`
+			Routes  []struct {
+				Plugins []struct {
+					Name   string                 `json:"name"`
+					Config map[string]interface{} `json:"config"`
+				} `json:"plugins"`
+			} `json:"routes"`
+		} `json:"services"`
+	}
+	if err := json.Unmarshal(out, &doc); err != nil {
+		t.Fatal(err)
+	}
+	route := doc.Services[0].Routes[0].Plugins[0]
+	if route.Name != "idpartners-ping-authorize" {
+		t.Errorf("plugin not renamed: %q", route.Name)
+	}
+	if _, ok := route.Config["legacy_option"]; ok {
+		t.Error("unknown field should be removed")
+	}
+	if doc.Services[0].Plugins[0]["name"] != "rate-limiting" {
+		t.Error("other plugins must be left alone")
+	}
+
+	text := notesText(notes)
+	for _, want := range []string{
+		"services[0].routes[0].plugins[0].name: renamed plugin ping-auth -> idpartners-ping-authorize",
+		"services[0].routes[0].plugins[0].config.legacy_option: removed",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("missing note %q in:\n%s", want, text)
+		}
+	}
+	if strings.Contains(text, "plugins[0].config.fail_open") || strings.Contains(text, "\nplugins[0]") {
+		t.Errorf("2.x config should need no changes:\n%s", text)
+	}
+}
+
+func TestMigrateDocument_ValidationProblems(t *testing.T) {
+	_, notes, err := migrateDocument([]byte(`{"service_url":"ftp://paz","shared_secret":"s","secret_header_name":"X"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(notesText(notes), "needs attention: service_url scheme") {
+		t.Errorf("expected validation note, got:\n%s", notesText(notes))
+	}
+}
+
+func TestMigrateDocument_NothingFound(t *testing.T) {
+	if _, _, err := migrateDocument([]byte(`{"plugins":[{"name":"cors","config":{}}]}`)); err == nil {
+		t.Error("expected error when no plugin config is present")
+	}
+	if _, _, err := migrateDocument([]byte(`not json`)); err == nil {
+		t.Error("expected parse error")
+	}
+}
+
+func TestRunMigrateConfig_Check(t *testing.T) {
+	path := writeTempFile(t, "plugins.json", `{"data":[{"name":"ping-auth","config":{"service_url":"https://p","shared_secret":"s","secret_header_name":"X"}}]}`)
+
+	var stderr bytes.Buffer
+	err := runMigrateConfig([]string{"-check", path}, io.Discard, &stderr)
+	if err == nil || !strings.Contains(stderr.String(), "data[0].name") {
+		t.Errorf("expected -check to fail with notes, err=%v stderr=%s", err, stderr.String())
+	}
+
+	outPath := path + ".out"
+	if err := runMigrateConfig([]string{"-o", outPath, path}, io.Discard, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	migrated, _ := os.ReadFile(outPath)
+	if err := runMigrateConfig([]string{"-check", outPath}, io.Discard, io.Discard); err != nil {
+		t.Errorf("migrated output should pass -check: %v\n%s", err, migrated)
+	}
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/idpartners/idpartners-ping-authorize/pingauthorize"
+)
+
+// simulateOptions controls the synthetic traffic generated by the simulate command.
+type simulateOptions struct {
+	Requests      int
+	Concurrency   int
+	Rate          float64 // requests per second, 0 = unlimited
+	MCPRatio      float64 // fraction of requests that are MCP tools/call requests
+	BodySize      int     // approximate request body size in bytes for POST and MCP requests
+	ErrorRate     float64 // fraction of requests sent as malformed sideband payloads
+	ResponsePhase bool    // also call /sideband/response for allowed requests
+	Seed          int64
+}
+
+func runSimulate(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	configPath := fs.String("config", "", "path to a JSON file with the plugin configuration")
+	serviceURL := fs.String("service-url", "", "PingAuthorize base URL (overrides service_url)")
+	sharedSecret := fs.String("shared-secret", "", "shared secret (overrides shared_secret)")
+	secretHeader := fs.String("secret-header-name", "", "shared secret header name (overrides secret_header_name)")
+	jsonOut := fs.Bool("json", false, "print the report as JSON")
+
+	var opts simulateOptions
+	fs.IntVar(&opts.Requests, "requests", 1000, "total number of simulated client requests")
+	fs.IntVar(&opts.Concurrency, "concurrency", 10, "number of concurrent workers")
+	fs.Float64Var(&opts.Rate, "rate", 0, "target requests per second (0 = as fast as possible)")
+	fs.Float64Var(&opts.MCPRatio, "mcp-ratio", 0, "fraction of requests that are MCP tools/call requests (0-1)")
+	fs.IntVar(&opts.BodySize, "body-size", 256, "approximate body size in bytes for POST and MCP requests")
+	fs.Float64Var(&opts.ErrorRate, "error-rate", 0, "fraction of requests sent as malformed sideband payloads (0-1)")
+	fs.BoolVar(&opts.ResponsePhase, "response-phase", false, "also call /sideband/response for allowed requests")
+	fs.Int64Var(&opts.Seed, "seed", 1, "random seed for the generated traffic")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	conf, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	if *serviceURL != "" {
+		conf.ServiceURL = *serviceURL
+	}
+	if *sharedSecret != "" {
+		conf.SharedSecret = *sharedSecret
+	}
+	if *secretHeader != "" {
+		conf.SecretHeaderName = *secretHeader
+	}
+	if err := conf.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	if err := opts.validate(); err != nil {
+		return err
+	}
+
+	report, err := simulate(context.Background(), conf, opts)
+	if err != nil {
+		return err
+	}
+
+	if *jsonOut {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	report.print(stdout)
+	return nil
+}
+
+// validate checks the option ranges.
+func (o simulateOptions) validate() error {
+	switch {
+	case o.Requests <= 0:
+		return fmt.Errorf("-requests must be > 0")
+	case o.Concurrency <= 0:
+		return fmt.Errorf("-concurrency must be > 0")
+	case o.Rate < 0:
+		return fmt.Errorf("-rate must be >= 0")
+	case o.MCPRatio < 0 || o.MCPRatio > 1:
+		return fmt.Errorf("-mcp-ratio must be between 0 and 1")
+	case o.ErrorRate < 0 || o.ErrorRate > 1:
+		return fmt.Errorf("-error-rate must be between 0 and 1")
+	case o.BodySize < 0:
+		return fmt.Errorf("-body-size must be >= 0")
+	}
+	return nil
+}
+
+// simulatedCall is the outcome of one sideband call.
+type simulatedCall struct {
+	phase   string
+	outcome string
+	latency time.Duration
+}
+
+// simulate sends opts.Requests synthetic access payloads (and optionally response payloads) to
+// the configured endpoint and aggregates the results.
+func simulate(ctx context.Context, conf *pingauthorize.Config, opts simulateOptions) (*simulateReport, error) {
+	parsedURL, err := pingauthorize.ParseURL(conf.ServiceURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse service URL: %w", err)
+	}
+	client := pingauthorize.NewSidebandHTTPClient(conf)
+
+	jobs := make(chan int)
+	results := make(chan simulatedCall, opts.Concurrency*2)
+
+	go func() {
+		defer close(jobs)
+		var tick *time.Ticker
+		if opts.Rate > 0 {
+			tick = time.NewTicker(time.Duration(float64(time.Second) / opts.Rate))
+			defer tick.Stop()
+		}
+		for i := 0; i < opts.Requests; i++ {
+			if tick != nil {
+				select {
+				case <-tick.C:
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for w := 0; w < opts.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				rng := rand.New(rand.NewSource(opts.Seed + int64(i)))
+				runSimulatedRequest(ctx, client, parsedURL, opts, rng, results)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	start := time.Now()
+	report := newSimulateReport()
+	for call := range results {
+		report.add(call)
+	}
+	report.finish(opts.Requests, time.Since(start))
+	return report, nil
+}
+
+// runSimulatedRequest sends one access payload and, when allowed and enabled, the matching
+// response payload.
+func runSimulatedRequest(ctx context.Context, client *pingauthorize.SidebandHTTPClient, parsedURL *pingauthorize.ParsedURL, opts simulateOptions, rng *rand.Rand, results chan<- simulatedCall) {
+	access := generateAccessRequest(rng, opts)
+	body, _ := json.Marshal(access)
+	if rng.Float64() < opts.ErrorRate {
+		body = body[:len(body)/2] // truncated JSON
+	}
+
+	requestURL := pingauthorize.BuildSidebandURL(parsedURL, "/sideband/request")
+	start := time.Now()
+	status, _, respBody, err := client.Execute(ctx, requestURL, body, parsedURL)
+	latency := time.Since(start)
+
+	outcome, resp := classifyAccessResult(status, respBody, err)
+	results <- simulatedCall{phase: "access", outcome: outcome, latency: latency}
+
+	if !opts.ResponsePhase || outcome != "allow" {
+		return
+	}
+
+	payload := generateResponsePayload(rng, opts, access, resp.State)
+	body, _ = json.Marshal(payload)
+	requestURL = pingauthorize.BuildSidebandURL(parsedURL, "/sideband/response")
+	start = time.Now()
+	status, _, respBody, err = client.Execute(ctx, requestURL, body, parsedURL)
+	latency = time.Since(start)
+
+	results <- simulatedCall{phase: "response", outcome: classifyResponseResult(status, respBody, err), latency: latency}
+}
+
+// classifyAccessResult maps a /sideband/request result to an outcome label:
+// "allow", "deny:<code>" or "error:<reason>".
+func classifyAccessResult(status int, body []byte, err error) (string, *pingauthorize.SidebandAccessResponse) {
+	if label, failed := classifyFailure(status, err); failed {
+		return label, nil
+	}
+	var resp pingauthorize.SidebandAccessResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return "error:decode", nil
+	}
+	if resp.Response != nil {
+		code := resp.Response.ResponseCode
+		if code == "" {
+			code = "403"
+		}
+		return "deny:" + code, &resp
+	}
+	return "allow", &resp
+}
+
+// classifyResponseResult maps a /sideband/response result to "status:<code>" or "error:<reason>".
+func classifyResponseResult(status int, body []byte, err error) string {
+	if label, failed := classifyFailure(status, err); failed {
+		return label
+	}
+	var result pingauthorize.SidebandResponseResult
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "error:decode"
+	}
+	if result.ResponseCode == "" {
+		return "status:200"
+	}
+	return "status:" + result.ResponseCode
+}
+
+// classifyFailure labels transport errors, open circuits and sideband HTTP errors.
+func classifyFailure(status int, err error) (string, bool) {
+	var cbErr *pingauthorize.CircuitBreakerOpenError
+	switch {
+	case errors.As(err, &cbErr):
+		return "error:circuit_open", true
+	case status >= 400:
+		return "error:" + strconv.Itoa(status), true
+	case err != nil:
+		return "error:transport", true
+	}
+	return "", false
+}
+
+var simulatedMethods = []string{"GET", "GET", "GET", "POST", "PUT", "DELETE"}
+
+// generateAccessRequest builds a synthetic /sideband/request payload for a REST or MCP request.
+func generateAccessRequest(rng *rand.Rand, opts simulateOptions) *pingauthorize.SidebandAccessRequest {
+	req := &pingauthorize.SidebandAccessRequest{
+		SourceIP:    fmt.Sprintf("10.%d.%d.%d", rng.Intn(256), rng.Intn(256), 1+rng.Intn(254)),
+		SourcePort:  strconv.Itoa(1024 + rng.Intn(64511)),
+		HTTPVersion: "1.1",
+		Headers: []map[string]string{
+			{"host": "api.example.com"},
+			{"accept": "application/json"},
+			{"user-agent": "pazctl-simulate"},
+			{"authorization": "Bearer simulated-" + strconv.Itoa(rng.Intn(100))},
+		},
+	}
+
+	if rng.Float64() < opts.MCPRatio {
+		req.Method = "POST"
+		req.URL = "https://api.example.com:443/mcp"
+		req.Body = mcpToolCallBody(rng, opts.BodySize)
+		req.Headers = append(req.Headers, map[string]string{"content-type": "application/json"})
+		return req
+	}
+
+	req.Method = simulatedMethods[rng.Intn(len(simulatedMethods))]
+	req.URL = fmt.Sprintf("https://api.example.com:443/accounts/%d/orders?limit=%d", rng.Intn(10000), 10+rng.Intn(90))
+	if req.Method == "POST" || req.Method == "PUT" {
+		req.Body = jsonFillerBody(rng, opts.BodySize)
+		req.Headers = append(req.Headers, map[string]string{"content-type": "application/json"})
+	}
+	return req
+}
+
+// generateResponsePayload builds the /sideband/response payload for an allowed request.
+func generateResponsePayload(rng *rand.Rand, opts simulateOptions, access *pingauthorize.SidebandAccessRequest, state json.RawMessage) *pingauthorize.SidebandResponsePayload {
+	payload := &pingauthorize.SidebandResponsePayload{
+		Method:         access.Method,
+		URL:            access.URL,
+		Body:           jsonFillerBody(rng, opts.BodySize),
+		ResponseCode:   "200",
+		ResponseStatus: "OK",
+		Headers:        []map[string]string{{"content-type": "application/json"}},
+		HTTPVersion:    access.HTTPVersion,
+	}
+	// state and request are mutually exclusive
+	if len(state) > 0 {
+		payload.State = state
+	} else {
+		payload.Request = access
+	}
+	return payload
+}
+
+var simulatedTools = []string{"search", "read_file", "write_file", "list_accounts", "transfer_funds"}
+
+// mcpToolCallBody returns a JSON-RPC 2.0 tools/call request padded to roughly size bytes.
+func mcpToolCallBody(rng *rand.Rand, size int) string {
+	body, _ := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      rng.Intn(1 << 20),
+		"method":  "tools/call",
+		"params": map[string]interface{}{
+			"name":      simulatedTools[rng.Intn(len(simulatedTools))],
+			"arguments": map[string]interface{}{"query": filler(rng, size-100)},
+		},
+	})
+	return string(body)
+}
+
+// jsonFillerBody returns a JSON object padded to roughly size bytes.
+func jsonFillerBody(rng *rand.Rand, size int) string {
+	body, _ := json.Marshal(map[string]interface{}{
+		"id":   rng.Intn(1 << 20),
+		"data": filler(rng, size-30),
+	})
+	return string(body)
+}
+
+// filler returns n random lowercase letters (none if n <= 0).
+func filler(rng *rand.Rand, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = 'a' + byte(rng.Intn(26))
+	}
+	return string(b)
+}
+
+// simulateReport aggregates the calls made by one simulate run.
+type simulateReport struct {
+	Requests      int                     `json:"requests"`
+	DurationMs    float64                 `json:"duration_ms"`
+	ThroughputRPS float64                 `json:"throughput_rps"`
+	Phases        map[string]*phaseReport `json:"phases"`
+
+	latencies map[string][]time.Duration
+}
+
+// phaseReport summarizes the calls to one sideband endpoint.
+type phaseReport struct {
+	Calls     int                `json:"calls"`
+	LatencyMs map[string]float64 `json:"latency_ms"`
+	Outcomes  map[string]int     `json:"outcomes"`
+}
+
+func newSimulateReport() *simulateReport {
+	return &simulateReport{
+		Phases:    make(map[string]*phaseReport),
+		latencies: make(map[string][]time.Duration),
+	}
+}
+
+func (r *simulateReport) add(call simulatedCall) {
+	p, ok := r.Phases[call.phase]
+	if !ok {
+		p = &phaseReport{Outcomes: make(map[string]int)}
+		r.Phases[call.phase] = p
+	}
+	p.Calls++
+	p.Outcomes[call.outcome]++
+	r.latencies[call.phase] = append(r.latencies[call.phase], call.latency)
+}
+
+// finish computes throughput and latency percentiles.
+func (r *simulateReport) finish(requests int, elapsed time.Duration) {
+	r.Requests = requests
+	r.DurationMs = durationMs(elapsed)
+	if elapsed > 0 {
+		r.ThroughputRPS = float64(requests) / elapsed.Seconds()
+	}
+	for phase, latencies := range r.latencies {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		r.Phases[phase].LatencyMs = map[string]float64{
+			"p50": durationMs(percentile(latencies, 50)),
+			"p90": durationMs(percentile(latencies, 90)),
+			"p95": durationMs(percentile(latencies, 95)),
+			"p99": durationMs(percentile(latencies, 99)),
+			"max": durationMs(latencies[len(latencies)-1]),
+		}
+	}
+}
+
+// percentile returns the nearest-rank percentile p (0-100] of sorted, which must be non-empty.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(float64(len(sorted))*p/100+0.999999) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// print writes a human-readable summary of the report.
+func (r *simulateReport) print(w io.Writer) {
+	fmt.Fprintf(w, "requests: %d in %.0f ms (%.1f req/s)\n", r.Requests, r.DurationMs, r.ThroughputRPS)
+	for _, phase := range []string{"access", "response"} {
+		p, ok := r.Phases[phase]
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(w, "\n%s phase: %d calls\n", phase, p.Calls)
+		fmt.Fprintf(w, "  latency ms  p50=%.2f p90=%.2f p95=%.2f p99=%.2f max=%.2f\n",
+			p.LatencyMs["p50"], p.LatencyMs["p90"], p.LatencyMs["p95"], p.LatencyMs["p99"], p.LatencyMs["max"])
+
+		outcomes := make([]string, 0, len(p.Outcomes))
+		for outcome := range p.Outcomes {
+			outcomes = append(outcomes, outcome)
+		}
+		sort.Strings(outcomes)
+		for _, outcome := range outcomes {
+			n := p.Outcomes[outcome]
+			fmt.Fprintf(w, "  %-20s %6d  %5.1f%%\n", outcome, n, 100*float64(n)/float64(p.Calls))
+		}
+	}
+	if len(r.Phases) == 0 {
+		fmt.Fprintln(w, "\nno calls completed")
+	}
+}
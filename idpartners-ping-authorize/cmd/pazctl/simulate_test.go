@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/idpartners/idpartners-ping-authorize/pingauthorize"
+)
+
+// mockSideband allows REST requests, denies MCP requests and rejects malformed payloads with 400.
+func mockSideband(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.URL.Path == "/sideband/response" {
+			w.Write([]byte(`{"response_code":"200","body":"ok","headers":[]}`))
+			return
+		}
+		var req pingauthorize.SidebandAccessRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"message":"bad payload"}`))
+			return
+		}
+		if pingauthorize.IsMCPRequest([]byte(req.Body)) {
+			w.Write([]byte(`{"response":{"response_code":"403","response_status":"FORBIDDEN"}}`))
+			return
+		}
+		w.Write([]byte(`{"method":"GET","url":"` + req.URL + `","headers":[],"state":{"s":1}}`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func testSimulateConfig(serviceURL string) *pingauthorize.Config {
+	conf := pingauthorize.NewConfig()
+	conf.ServiceURL = serviceURL
+	conf.SharedSecret = "secret"
+	conf.SecretHeaderName = "X-Secret"
+	conf.CircuitBreakerEnabled = false
+	return conf
+}
+
+func TestSimulate_DecisionDistribution(t *testing.T) {
+	server := mockSideband(t)
+
+	opts := simulateOptions{
+		Requests:      200,
+		Concurrency:   8,
+		MCPRatio:      0.5,
+		BodySize:      128,
+		ErrorRate:     0.1,
+		ResponsePhase: true,
+		Seed:          42,
+	}
+	report, err := simulate(context.Background(), testSimulateConfig(server.URL), opts)
+	if err != nil {
+		t.Fatalf("simulate: %v", err)
+	}
+
+	access := report.Phases["access"]
+	if access == nil || access.Calls != 200 {
+		t.Fatalf("expected 200 access calls, got %+v", access)
+	}
+	allow, deny, bad := access.Outcomes["allow"], access.Outcomes["deny:403"], access.Outcomes["error:400"]
+	if allow+deny+bad != 200 {
+		t.Errorf("unexpected outcomes: %v", access.Outcomes)
+	}
+	if allow == 0 || deny == 0 || bad == 0 {
+		t.Errorf("expected every outcome to occur: %v", access.Outcomes)
+	}
+
+	response := report.Phases["response"]
+	if response == nil || response.Calls != allow || response.Outcomes["status:200"] != allow {
+		t.Errorf("expected one response call per allowed request (%d), got %+v", allow, response)
+	}
+	if access.LatencyMs["p50"] > access.LatencyMs["p99"] || access.LatencyMs["p99"] > access.LatencyMs["max"] {
+		t.Errorf("percentiles not monotonic: %v", access.LatencyMs)
+	}
+}
+
+func TestSimulate_Deterministic(t *testing.T) {
+	server := mockSideband(t)
+	opts := simulateOptions{Requests: 50, Concurrency: 4, MCPRatio: 0.3, ErrorRate: 0.2, Seed: 7}
+
+	first, err := simulate(context.Background(), testSimulateConfig(server.URL), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := simulate(context.Background(), testSimulateConfig(server.URL), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _ := json.Marshal(first.Phases["access"].Outcomes)
+	b, _ := json.Marshal(second.Phases["access"].Outcomes)
+	if !bytes.Equal(a, b) {
+		t.Errorf("same seed produced different outcomes: %s vs %s", a, b)
+	}
+}
+
+func TestSimulate_Unreachable(t *testing.T) {
+	opts := simulateOptions{Requests: 3, Concurrency: 1, Seed: 1}
+	conf := testSimulateConfig("http://127.0.0.1:1")
+	conf.ConnectionTimeoutMs = 200
+
+	report, err := simulate(context.Background(), conf, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := report.Phases["access"].Outcomes["error:transport"]; got != 3 {
+		t.Errorf("expected 3 transport errors, got %v", report.Phases["access"].Outcomes)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var sorted []time.Duration
+	for i := 1; i <= 100; i++ {
+		sorted = append(sorted, time.Duration(i)*time.Millisecond)
+	}
+	tests := []struct {
+		p    float64
+		want time.Duration
+	}{
+		{50, 50 * time.Millisecond},
+		{99, 99 * time.Millisecond},
+		{100, 100 * time.Millisecond},
+		{0.1, 1 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		if got := percentile(sorted, tt.p); got != tt.want {
+			t.Errorf("percentile(%v) = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+	if got := percentile([]time.Duration{5}, 99); got != 5 {
+		t.Errorf("single sample percentile = %v", got)
+	}
+}
+
+func TestSimulateOptions_Validate(t *testing.T) {
+	valid := simulateOptions{Requests: 1, Concurrency: 1}
+	if err := valid.validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	for _, opts := range []simulateOptions{
+		{Requests: 0, Concurrency: 1},
+		{Requests: 1, Concurrency: 0},
+		{Requests: 1, Concurrency: 1, MCPRatio: 1.5},
+		{Requests: 1, Concurrency: 1, ErrorRate: -0.1},
+		{Requests: 1, Concurrency: 1, Rate: -1},
+	} {
+		if err := opts.validate(); err == nil {
+			t.Errorf("expected error for %+v", opts)
+		}
+	}
+}
+
+func TestRun_UnknownCommand(t *testing.T) {
+	var stderr bytes.Buffer
+	if code := run([]string{"bogus"}, io.Discard, &stderr); code != 2 {
+		t.Errorf("exit code = %d, want 2", code)
+	}
+	if !strings.Contains(stderr.String(), "simulate") {
+		t.Errorf("usage should list commands, got %q", stderr.String())
+	}
+}
@@ -0,0 +1,11 @@
+// Package e2e holds the end-to-end suite that runs the plugin inside a real Kong Gateway
+// container and drives proxied traffic through it.
+//
+// The tests need Docker and are guarded by the e2e build tag:
+//
+//	cd e2e && go test -tags e2e -v ./...
+//
+// The plugin binary is cross-compiled from the parent module. The mock PingAuthorize and the
+// upstream service run in the test process and are reached from the Kong container through
+// testcontainers host port access. Set KONG_IMAGE to test against a different Kong version.
+package e2e
@@ -0,0 +1,242 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+const (
+	pluginName       = "idpartners-ping-authorize"
+	defaultKongImage = "kong:3.6"
+	secretHeaderName = "X-Ping-Secret"
+	sharedSecret     = "e2e-secret"
+)
+
+// harness is the shared Kong container plus the in-process mock PingAuthorize and upstream.
+type harness struct {
+	proxyURL string
+	adminURL string
+	paz      *mockPAZ
+	pazPort  int
+	upstream *httptest.Server
+	upPort   int
+
+	// mu serializes scenarios because each one replaces Kong's declarative config.
+	mu sync.Mutex
+}
+
+var h *harness
+
+func TestMain(m *testing.M) {
+	ctx := context.Background()
+
+	var err error
+	var kong testcontainers.Container
+	h, kong, err = startHarness(ctx)
+	if err != nil {
+		log.Fatalf("failed to start e2e harness: %v", err)
+	}
+
+	code := m.Run()
+
+	kong.Terminate(ctx)
+	h.upstream.Close()
+	h.paz.server.Close()
+	os.Exit(code)
+}
+
+func startHarness(ctx context.Context) (*harness, testcontainers.Container, error) {
+	binary, err := buildPlugin()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	paz := newMockPAZ()
+	upstream := newUpstream()
+
+	hs := &harness{
+		paz:      paz,
+		pazPort:  serverPort(paz.server),
+		upstream: upstream,
+		upPort:   serverPort(upstream),
+	}
+
+	image := os.Getenv("KONG_IMAGE")
+	if image == "" {
+		image = defaultKongImage
+	}
+
+	pluginPath := "/usr/local/bin/" + pluginName
+	req := testcontainers.ContainerRequest{
+		Image:        image,
+		ExposedPorts: []string{"8000/tcp", "8001/tcp"},
+		Env: map[string]string{
+			"KONG_DATABASE":               "off",
+			"KONG_PROXY_LISTEN":           "0.0.0.0:8000",
+			"KONG_ADMIN_LISTEN":           "0.0.0.0:8001",
+			"KONG_NGINX_WORKER_PROCESSES": "1",
+			"KONG_PLUGINS":                "bundled," + pluginName,
+			"KONG_PLUGINSERVER_NAMES":     pluginName,
+			"KONG_PLUGINSERVER_IDPARTNERS_PING_AUTHORIZE_START_CMD": pluginPath,
+			"KONG_PLUGINSERVER_IDPARTNERS_PING_AUTHORIZE_QUERY_CMD": pluginPath + " -dump",
+		},
+		Files: []testcontainers.ContainerFile{{
+			HostFilePath:      binary,
+			ContainerFilePath: pluginPath,
+			FileMode:          0o755,
+		}},
+		HostAccessPorts: []int{hs.pazPort, hs.upPort},
+		WaitingFor:      wait.ForHTTP("/status").WithPort("8001/tcp").WithStartupTimeout(2 * time.Minute),
+	}
+
+	kong, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: req,
+		Started:          true,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to start Kong: %w", err)
+	}
+
+	host, err := kong.Host(ctx)
+	if err != nil {
+		return nil, kong, err
+	}
+	proxyPort, err := kong.MappedPort(ctx, "8000")
+	if err != nil {
+		return nil, kong, err
+	}
+	adminPort, err := kong.MappedPort(ctx, "8001")
+	if err != nil {
+		return nil, kong, err
+	}
+
+	hs.proxyURL = fmt.Sprintf("http://%s:%s", host, proxyPort.Port())
+	hs.adminURL = fmt.Sprintf("http://%s:%s", host, adminPort.Port())
+	return hs, kong, nil
+}
+
+// buildPlugin cross-compiles the plugin for the container's OS and returns the binary path.
+func buildPlugin() (string, error) {
+	dir, err := os.MkdirTemp("", "paz-e2e")
+	if err != nil {
+		return "", err
+	}
+	out := filepath.Join(dir, pluginName)
+
+	cmd := exec.Command("go", "build", "-o", out, ".")
+	cmd.Dir = ".."
+	cmd.Env = append(os.Environ(), "GOOS=linux", "GOARCH="+runtime.GOARCH, "CGO_ENABLED=0")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("failed to build plugin: %v\n%s", err, output)
+	}
+	return out, nil
+}
+
+// configure replaces Kong's declarative config with a single route to the upstream running
+// the plugin with pluginConfig. The caller must hold h.mu.
+func (hs *harness) configure(t *testing.T, pluginConfig map[string]interface{}) {
+	t.Helper()
+
+	conf := map[string]interface{}{
+		"service_url":        hs.pazURL(t.Name()),
+		"shared_secret":      sharedSecret,
+		"secret_header_name": secretHeaderName,
+		"max_retries":        0,
+	}
+	for k, v := range pluginConfig {
+		conf[k] = v
+	}
+
+	declarative := map[string]interface{}{
+		"_format_version": "3.0",
+		"services": []interface{}{map[string]interface{}{
+			"name": "upstream",
+			"url":  fmt.Sprintf("http://%s:%d", testcontainers.HostInternal, hs.upPort),
+			"routes": []interface{}{map[string]interface{}{
+				"name":       "all",
+				"paths":      []string{"/"},
+				"strip_path": false,
+			}},
+			"plugins": []interface{}{map[string]interface{}{
+				"name":   pluginName,
+				"config": conf,
+			}},
+		}},
+	}
+
+	body, _ := json.Marshal(declarative)
+	resp, err := http.Post(hs.adminURL+"/config", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to load declarative config: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		msg, _ := io.ReadAll(resp.Body)
+		t.Fatalf("failed to load declarative config: %d %s", resp.StatusCode, msg)
+	}
+}
+
+// pazURL returns the service_url for a scenario. Each scenario gets its own path prefix on the
+// mock, which also gives it its own sideband endpoint and circuit breaker inside the plugin.
+func (hs *harness) pazURL(scenario string) string {
+	return fmt.Sprintf("http://%s:%d/%s", testcontainers.HostInternal, hs.pazPort, scenarioPrefix(scenario))
+}
+
+// do sends a request through the Kong proxy.
+func (hs *harness) do(t *testing.T, method, path string, body string, headers map[string]string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, hs.proxyURL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("proxy request failed: %v", err)
+	}
+	return resp
+}
+
+// scenarioPrefix turns a test name into a URL-safe path segment.
+func scenarioPrefix(name string) string {
+	return strings.NewReplacer("/", "-", " ", "-").Replace(strings.ToLower(name))
+}
+
+func serverPort(s *httptest.Server) int {
+	_, port, _ := net.SplitHostPort(s.Listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return p
+}
+
+func readBody(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
@@ -0,0 +1,239 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAccess_AllowAndModify(t *testing.T) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.paz.handle(t, pazHandlers{
+		request: func(w http.ResponseWriter, r *http.Request) {
+			var req accessRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"method":  req.Method,
+				"url":     strings.Replace(req.URL, "/orders/original", "/orders/rewritten", 1),
+				"body":    `{"amount":10}`,
+				"headers": append(req.Headers, map[string]string{"x-authorized-user": "alice"}),
+				"state":   map[string]string{"session": "s1"},
+			})
+		},
+		response: func(w http.ResponseWriter, r *http.Request) {
+			var payload responsePayload
+			json.NewDecoder(r.Body).Decode(&payload)
+			if string(payload.State) != `{"session":"s1"}` {
+				t.Errorf("expected state from access phase, got %s", payload.State)
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"response_code": "200",
+				"body":          payload.Body,
+				"headers":       append(payload.Headers, map[string]string{"x-policy-checked": "true"}),
+			})
+		},
+	})
+	h.configure(t, nil)
+
+	resp := h.do(t, "POST", "/orders/original?page=1", `{"amount":99}`, map[string]string{"Content-Type": "application/json"})
+	body := readBody(t, resp)
+
+	if resp.StatusCode != 200 {
+		t.Fatalf("expected 200, got %d: %s", resp.StatusCode, body)
+	}
+	if resp.Header.Get("X-Policy-Checked") != "true" {
+		t.Error("expected header added in the response phase")
+	}
+
+	var echo upstreamEcho
+	if err := json.Unmarshal([]byte(body), &echo); err != nil {
+		t.Fatalf("unexpected upstream body %q: %v", body, err)
+	}
+	if echo.Path != "/orders/rewritten" || echo.Query != "page=1" {
+		t.Errorf("expected rewritten URL, upstream saw %s?%s", echo.Path, echo.Query)
+	}
+	if echo.Body != `{"amount":10}` {
+		t.Errorf("expected rewritten body, upstream saw %q", echo.Body)
+	}
+	if got := http.Header(echo.Headers).Get("X-Authorized-User"); got != "alice" {
+		t.Errorf("expected injected header, upstream saw %q", got)
+	}
+	if got := http.Header(echo.Headers).Get("Accept-Encoding"); got != "" {
+		t.Errorf("expected Accept-Encoding stripped, upstream saw %q", got)
+	}
+}
+
+func TestAccess_Deny(t *testing.T) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.paz.handle(t, pazHandlers{
+		request: func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"response": map[string]interface{}{
+					"response_code":   "403",
+					"response_status": "FORBIDDEN",
+					"body":            `{"error":"access denied"}`,
+					"headers":         []map[string]string{{"content-type": "application/json"}},
+				},
+			})
+		},
+	})
+	h.configure(t, nil)
+
+	resp := h.do(t, "GET", "/accounts/42", "", nil)
+	body := readBody(t, resp)
+
+	if resp.StatusCode != 403 || body != `{"error":"access denied"}` {
+		t.Errorf("expected policy deny, got %d %q", resp.StatusCode, body)
+	}
+	if n := h.paz.callCount(t, "response"); n != 0 {
+		t.Errorf("response phase must not run for a denied request, got %d calls", n)
+	}
+}
+
+func TestMCP(t *testing.T) {
+	const toolsCall = `{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"name":"lookup"}}`
+
+	t.Run("client certificate required", func(t *testing.T) {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		h.paz.handle(t, pazHandlers{request: allowUnchanged, response: passResponse})
+		h.configure(t, map[string]interface{}{"require_client_certificate": true})
+
+		resp := h.do(t, "POST", "/mcp", toolsCall, map[string]string{"Content-Type": "application/json"})
+		body := readBody(t, resp)
+
+		if resp.StatusCode != 401 {
+			t.Fatalf("expected 401, got %d %q", resp.StatusCode, body)
+		}
+		var rpcErr struct {
+			ID    int `json:"id"`
+			Error struct {
+				Code int `json:"code"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal([]byte(body), &rpcErr); err != nil || rpcErr.ID != 7 || rpcErr.Error.Code == 0 {
+			t.Errorf("expected JSON-RPC error carrying id 7, got %q", body)
+		}
+		if n := h.paz.callCount(t, "request"); n != 0 {
+			t.Errorf("sideband must not be called without a certificate, got %d calls", n)
+		}
+	})
+
+	t.Run("response phase mcp only", func(t *testing.T) {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		h.paz.handle(t, pazHandlers{request: allowUnchanged, response: passResponse})
+		h.configure(t, map[string]interface{}{"response_phase_mcp_only": true})
+
+		resp := h.do(t, "POST", "/mcp", toolsCall, map[string]string{"Content-Type": "application/json"})
+		body := readBody(t, resp)
+		if resp.StatusCode != 200 || !strings.Contains(body, `"id":7`) {
+			t.Errorf("expected MCP result, got %d %q", resp.StatusCode, body)
+		}
+
+		resp = h.do(t, "GET", "/plain", "", nil)
+		readBody(t, resp)
+
+		if n := h.paz.callCount(t, "request"); n != 2 {
+			t.Errorf("expected both requests evaluated in the access phase, got %d", n)
+		}
+		if n := h.paz.callCount(t, "response"); n != 1 {
+			t.Errorf("expected only the MCP request evaluated in the response phase, got %d", n)
+		}
+	})
+}
+
+func TestSSE_Passthrough(t *testing.T) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.paz.handle(t, pazHandlers{request: allowUnchanged})
+	h.configure(t, map[string]interface{}{"skip_response_phase": true})
+
+	resp := h.do(t, "GET", "/sse", "", map[string]string{"Accept": "text/event-stream"})
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		t.Fatalf("expected event stream, got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	events := 0
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), "data: ") {
+			events++
+		}
+	}
+	if events != 3 {
+		t.Errorf("expected 3 events, got %d", events)
+	}
+}
+
+func TestCircuitBreaker_RateLimited(t *testing.T) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.paz.handle(t, pazHandlers{
+		request: func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "30")
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(map[string]string{"message": "slow down"})
+		},
+	})
+	h.configure(t, map[string]interface{}{"skip_response_phase": true})
+
+	first := h.do(t, "GET", "/limited", "", nil)
+	readBody(t, first)
+
+	second := h.do(t, "GET", "/limited", "", nil)
+	body := readBody(t, second)
+
+	if second.StatusCode != 429 || !strings.Contains(body, "LIMIT_EXCEEDED") {
+		t.Errorf("expected breaker 429, got %d %q", second.StatusCode, body)
+	}
+	if second.Header.Get("Retry-After") == "" {
+		t.Error("expected Retry-After on breaker response")
+	}
+	if n := h.paz.callCount(t, "request"); n != 1 {
+		t.Errorf("expected the open breaker to short-circuit the second call, got %d sideband calls", n)
+	}
+}
+
+func TestFailOpen(t *testing.T) {
+	tests := []struct {
+		name       string
+		failOpen   bool
+		wantStatus int
+	}{
+		{"enabled", true, 200},
+		{"disabled", false, 502},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+
+			// No handlers registered: the mock answers 500 for this scenario
+			h.configure(t, map[string]interface{}{
+				"fail_open":           tt.failOpen,
+				"skip_response_phase": true,
+			})
+
+			resp := h.do(t, "GET", "/resource", "", nil)
+			body := readBody(t, resp)
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("expected %d, got %d %q", tt.wantStatus, resp.StatusCode, body)
+			}
+		})
+	}
+}
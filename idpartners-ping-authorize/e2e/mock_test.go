@@ -0,0 +1,179 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// accessRequest is the subset of the /sideband/request payload the scenarios inspect.
+type accessRequest struct {
+	Method  string              `json:"method"`
+	URL     string              `json:"url"`
+	Body    string              `json:"body"`
+	Headers []map[string]string `json:"headers"`
+}
+
+// responsePayload is the subset of the /sideband/response payload the scenarios inspect.
+type responsePayload struct {
+	ResponseCode string              `json:"response_code"`
+	Body         string              `json:"body"`
+	Headers      []map[string]string `json:"headers"`
+	State        json.RawMessage     `json:"state"`
+}
+
+// pazHandlers scripts the mock PingAuthorize for one scenario. A nil handler answers 500.
+type pazHandlers struct {
+	request  http.HandlerFunc
+	response http.HandlerFunc
+}
+
+// mockPAZ is an in-process PingAuthorize Sideband API that routes by scenario path prefix.
+type mockPAZ struct {
+	server *httptest.Server
+
+	mu       sync.Mutex
+	handlers map[string]pazHandlers
+	calls    map[string]int
+}
+
+func newMockPAZ() *mockPAZ {
+	m := &mockPAZ{
+		handlers: make(map[string]pazHandlers),
+		calls:    make(map[string]int),
+	}
+	m.server = httptest.NewServer(http.HandlerFunc(m.serveHTTP))
+	return m
+}
+
+// handle registers the handlers for the calling test's scenario.
+func (m *mockPAZ) handle(t *testing.T, handlers pazHandlers) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handlers[scenarioPrefix(t.Name())] = handlers
+}
+
+// callCount returns how many times endpoint ("request" or "response") was called for the scenario.
+func (m *mockPAZ) callCount(t *testing.T, endpoint string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.calls[scenarioPrefix(t.Name())+"/"+endpoint]
+}
+
+func (m *mockPAZ) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get(secretHeaderName) != sharedSecret {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"message": "invalid shared secret"})
+		return
+	}
+
+	prefix, endpoint, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/sideband/")
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	m.mu.Lock()
+	handlers := m.handlers[prefix]
+	m.calls[prefix+"/"+endpoint]++
+	m.mu.Unlock()
+
+	var handler http.HandlerFunc
+	switch endpoint {
+	case "request":
+		handler = handlers.request
+	case "response":
+		handler = handlers.response
+	}
+	if handler == nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"message": "no handler for " + r.URL.Path})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	handler(w, r)
+}
+
+// allowUnchanged answers /sideband/request by echoing the request back, which allows it unmodified.
+func allowUnchanged(w http.ResponseWriter, r *http.Request) {
+	var req accessRequest
+	json.NewDecoder(r.Body).Decode(&req)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"method":  req.Method,
+		"url":     req.URL,
+		"body":    req.Body,
+		"headers": req.Headers,
+	})
+}
+
+// passResponse answers /sideband/response by returning the upstream response unchanged.
+func passResponse(w http.ResponseWriter, r *http.Request) {
+	var payload responsePayload
+	json.NewDecoder(r.Body).Decode(&payload)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"response_code": payload.ResponseCode,
+		"body":          payload.Body,
+		"headers":       payload.Headers,
+	})
+}
+
+// upstreamEcho is the JSON document returned by the upstream for ordinary requests.
+type upstreamEcho struct {
+	Method  string              `json:"method"`
+	Path    string              `json:"path"`
+	Query   string              `json:"query"`
+	Headers map[string][]string `json:"headers"`
+	Body    string              `json:"body"`
+}
+
+// newUpstream starts the upstream service behind Kong. /sse streams three server-sent events,
+// /mcp answers JSON-RPC, and every other path echoes the request as JSON.
+func newUpstream() *httptest.Server {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/sse", func(w http.ResponseWriter, r *http.Request) {
+		flusher, _ := w.(http.Flusher)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		for i := 1; i <= 3; i++ {
+			fmt.Fprintf(w, "id: %d\nevent: tick\ndata: {\"n\":%d}\n\n", i, i)
+			if flusher != nil {
+				flusher.Flush()
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	})
+
+	mux.HandleFunc("/mcp", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID json.RawMessage `json:"id"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"result":{"content":[]}}`, req.ID)
+	})
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(upstreamEcho{
+			Method:  r.Method,
+			Path:    r.URL.Path,
+			Query:   r.URL.RawQuery,
+			Headers: r.Header,
+			Body:    string(body),
+		})
+	})
+
+	return httptest.NewServer(mux)
+}
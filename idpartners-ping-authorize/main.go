@@ -3,74 +3,80 @@ package main
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"os"
 
 	"github.com/Kong/go-pdk"
 	"github.com/Kong/go-pdk/server"
-)
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
 
-const (
-	PluginName = "idpartners-ping-authorize"
-	Version    = "2.0.0"
-	Priority   = 999
+	"github.com/idpartners/idpartners-ping-authorize/pingauthorize"
 )
 
+// Config is the Kong-facing plugin configuration. It shares its fields with pingauthorize.Config
+// so go-pdk generates the same flat schema; the phase handlers delegate to the library.
+type Config pingauthorize.Config
+
 // New returns a new plugin configuration instance.
 func New() interface{} {
-	return &Config{
-		// Defaults that match DESIGN.md §3.1
-		ConnectionTimeoutMs:   10000,
-		ConnectionKeepaliveMs: 60000,
-		VerifyServiceCert:     true,
-		PassthroughStatusCodes: []int{413},
-		RetryBackoffMs:        500,
-		CircuitBreakerEnabled: true,
-		StripAcceptEncoding:   true,
-		RedactHeaders:         []string{"authorization", "cookie"},
-		DebugBodyMaxBytes:     8192,
-	}
+	return (*Config)(pingauthorize.NewConfig())
 }
 
 // Access is the Kong access phase handler.
 func (conf *Config) Access(kong *pdk.PDK) {
-	defer func() {
-		if r := recover(); r != nil {
-			kong.Log.Err(fmt.Sprintf("[%s] Unexpected panic in access phase: %v", PluginName, r))
-			kong.Response.Exit(500, nil, nil)
-		}
-	}()
-	executeAccess(kong, conf)
+	(*pingauthorize.Config)(conf).Access(kong)
 }
 
 // Response is the Kong response phase handler.
 func (conf *Config) Response(kong *pdk.PDK) {
-	if conf.SkipResponsePhase {
-		return
-	}
-	defer func() {
-		if r := recover(); r != nil {
-			kong.Log.Err(fmt.Sprintf("[%s] Unexpected panic in response phase: %v", PluginName, r))
-			kong.Response.Exit(500, nil, nil)
-		}
-	}()
-	executeResponse(kong, conf)
+	(*pingauthorize.Config)(conf).Response(kong)
 }
 
 func main() {
+	ctx := context.Background()
+
+	// Optional Prometheus metrics endpoint, alongside or instead of the OTLP exporters. It is set in
+	// the environment rather than the plugin config: the listener serves the whole process and starts
+	// before Kong sends any of the per-route configs.
+	var metricReaders []sdkmetric.Reader
+	if addr := os.Getenv("PAZ_METRICS_LISTEN"); addr != "" {
+		prom := pingauthorize.NewPrometheusExporter()
+		metricReaders = append(metricReaders, prom.Reader())
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", prom)
+		go func() {
+			err := http.ListenAndServe(addr, mux)
+			fmt.Fprintf(os.Stderr, "[%s] Metrics endpoint stopped: %v\n", pingauthorize.PluginName, err)
+		}()
+	}
+
 	// Optional OTel initialization
 	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" {
-		ctx := context.Background()
-		shutdown, _, err := InitOTel(ctx)
+		shutdown, _, err := pingauthorize.InitOTel(ctx, metricReaders...)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "[%s] Failed to initialize OpenTelemetry: %v\n", PluginName, err)
+			fmt.Fprintf(os.Stderr, "[%s] Failed to initialize OpenTelemetry: %v\n", pingauthorize.PluginName, err)
 		} else if shutdown != nil {
 			defer shutdown(ctx)
 		}
+	} else if len(metricReaders) > 0 {
+		defer pingauthorize.InitMetrics(metricReaders...)(ctx)
+	}
+
+	// Optional admin API for circuit breakers
+	if addr := os.Getenv("PAZ_ADMIN_LISTEN"); addr != "" {
+		if token := os.Getenv("PAZ_ADMIN_TOKEN"); token == "" {
+			fmt.Fprintf(os.Stderr, "[%s] PAZ_ADMIN_TOKEN is required for the admin API; not starting it\n", pingauthorize.PluginName)
+		} else {
+			go func() {
+				err := http.ListenAndServe(addr, pingauthorize.AdminHandler(token))
+				fmt.Fprintf(os.Stderr, "[%s] Admin API stopped: %v\n", pingauthorize.PluginName, err)
+			}()
+		}
 	}
 
-	err := server.StartServer(New, Version, Priority)
+	err := server.StartServer(New, pingauthorize.Version, pingauthorize.Priority)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "[%s] Failed to start server: %v\n", PluginName, err)
+		fmt.Fprintf(os.Stderr, "[%s] Failed to start server: %v\n", pingauthorize.PluginName, err)
 		os.Exit(1)
 	}
 }
@@ -0,0 +1,874 @@
+package pingauthorize
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Kong/go-pdk"
+	"github.com/Kong/go-pdk/client"
+	"github.com/Kong/go-pdk/entities"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/idpartners/idpartners-ping-authorize/pingauthorize/decisionctx"
+)
+
+// executeAccess implements the access phase logic.
+func executeAccess(kong *pdk.PDK, conf *Config) {
+	logger := NewPluginLogger(kong, "access", conf.ServiceURL)
+
+	// Only the plugin may mark requests as allowed by fail-open
+	if conf.FailOpenHeader != "" {
+		kong.ServiceRequest.ClearHeader(conf.FailOpenHeader)
+	}
+
+	if bypass := conf.getBypassRules(); bypass != nil {
+		method, _ := kong.Request.GetMethod()
+		path, _ := kong.Request.GetPath()
+		if bypass.match(method, path) {
+			logger.Debug("Request bypassed by bypass_paths or bypass_methods", "method", method, "path", path)
+			kong.Ctx.SetShared("paz_skipped", "true")
+			return
+		}
+	}
+
+	parsedURL, err := ParseURL(conf.ServiceURL)
+	if err != nil {
+		logger.Err("Failed to parse service URL", "error", err.Error())
+		exitKong(kong, conf, 500, nil, nil)
+		return
+	}
+
+	payload, err := composeAccessPayload(kong, conf, parsedURL)
+	if err != nil {
+		logger.Err("Failed to compose access payload", "error", err.Error())
+		exitKong(kong, conf, 400, nil, nil)
+		return
+	}
+
+	if !sampleDebugPayloads(conf, logger, kongHeader(kong)) {
+		kong.Ctx.SetShared("paz_debug_skipped", "true")
+	}
+
+	if payload.MCPSessionID != "" {
+		kong.Ctx.SetShared("paz_mcp_session_id", payload.MCPSessionID)
+	}
+	if client := payload.MCP.initializeClient(); client != nil {
+		data, _ := json.Marshal(client)
+		kong.Ctx.SetShared("paz_mcp_initialize", string(data))
+	}
+
+	consumerIDs := kongConsumerIDs(kong)
+	record := newDecisionRecord(payload, consumerIDs, payload.MCPSessionID)
+	defer storeDecisionContext(kong, record)
+	phase := &record.Access
+	phase.Shadow = conf.ShadowMode
+	if conf.ShadowMode {
+		// Had the request been ended, there would be no response to evaluate
+		defer func() {
+			if phase.Decision == decisionctx.DecisionDeny || phase.Decision == decisionctx.DecisionError {
+				kong.Ctx.SetShared("paz_skipped", "true")
+			}
+		}()
+	}
+	defer conf.metrics().recordDecision(conf.ServiceURL, "access", phase, record.MCP, conf.MCPMetricsMaxLabelValues)
+	defer conf.publishDecision("access", record, phase, kongHeader(kong))
+	_, span := startPhaseSpan(context.Background(), conf, "access", kongTraceHeaders(kong))
+	defer func() { endPhaseSpan(span, phase) }()
+
+	host := &kongAccessHost{kong: kong, conf: conf, parsedURL: parsedURL,
+		ctx: trace.ContextWithSpan(WithConsumer(forwardHeadersContext(kong, conf), consumerIDs...), span)}
+	resp, evaluated, err := evaluateAccess(conf, host, payload, consumerIDs, phase, logger)
+	if !evaluated {
+		return
+	}
+	if err != nil {
+		// Check if it's a circuit breaker error
+		if cbErr, ok := err.(*CircuitBreakerOpenError); ok {
+			if status := handleCircuitBreakerError(kong, cbErr, conf); status != 0 {
+				phase.Decision, phase.StatusCode = decisionctx.DecisionError, status
+			} else {
+				logger.Warn("Circuit breaker open, fail-open enabled, allowing request", "fail_open", true)
+				phase.Decision, phase.FailOpen = decisionctx.DecisionAllow, true
+				markFailOpen(kong, conf)
+			}
+			return
+		}
+
+		if thErr, ok := err.(*SidebandThrottledError); ok {
+			if conf.SidebandRateLimitFailOpen {
+				logger.Warn("Sideband call throttled by sideband_rate_limit, allowing request", "fail_open", true)
+				phase.Decision, phase.FailOpen = decisionctx.DecisionAllow, true
+				markFailOpen(kong, conf)
+				storePerRequestContext(kong, conf, payload, nil)
+				return
+			}
+			body, headers := throttledResponse(thErr)
+			phase.Decision, phase.StatusCode = decisionctx.DecisionError, 429
+			exitKong(kong, conf, 429, body, headers)
+			return
+		}
+
+		// Check if it's a sideband HTTP error with passthrough status code
+		if httpErr, ok := err.(*sidebandHTTPError); ok {
+			if isPassthroughCode(httpErr.StatusCode, conf) {
+				phase.Decision, phase.StatusCode = decisionctx.DecisionError, httpErr.StatusCode
+				exitKong(kong, conf, httpErr.StatusCode, httpErr.Body,
+					map[string][]string{"Content-Type": {"application/json"}})
+				return
+			}
+			logger.Warn("Sideband request failed", "status", httpErr.StatusCode, "message", httpErr.Message, "id", httpErr.ID)
+		} else {
+			logger.Err("PingAuthorize unreachable", "error", err.Error())
+		}
+
+		if conf.failOpen() {
+			logger.Warn("PingAuthorize unreachable, fail-open enabled, allowing request", "fail_open", true)
+			phase.Decision, phase.FailOpen = decisionctx.DecisionAllow, true
+			markFailOpen(kong, conf)
+			storePerRequestContext(kong, conf, payload, nil)
+			return
+		}
+		phase.Decision, phase.StatusCode = decisionctx.DecisionError, 502
+		exitKong(kong, conf, 502, nil, nil)
+		return
+	}
+
+	DebugLogPayload(logger, "Received sideband response", resp, conf)
+	ignoreOmittedBody(payload, resp)
+	ignoreStrippedArguments(payload, resp)
+	phase.Cached = resp.FromCache
+
+	batchErrors, batchDenied := mcpBatchDenials(payload.Body, resp.Body)
+	if batchDenied {
+		logger.Info("MCP batch denied by policy provider", "status_code", 403)
+		phase.Decision, phase.StatusCode = decisionctx.DecisionDeny, 403
+		exitKong(kong, conf, 403, batchErrors, map[string][]string{"Content-Type": {"application/json"}})
+		return
+	}
+
+	state, err := handleAccessResponse(kong, conf, payload, resp, logger)
+	if err != nil {
+		// handleAccessResponse already sent a response to the client
+		phase.Decision = decisionctx.DecisionDeny
+		if phase.StatusCode, err = strconv.Atoi(resp.Response.ResponseCode); err != nil {
+			phase.StatusCode = 403
+		}
+		return
+	}
+	phase.Decision, phase.Obligations = decisionctx.DecisionAllow, requestObligations(payload, resp)
+	if batchErrors != nil && !conf.ShadowMode {
+		// Answered with the upstream response to the rest of the batch
+		kong.Ctx.SetShared("paz_mcp_batch_errors", string(batchErrors))
+	}
+
+	storePerRequestContext(kong, conf, payload, state)
+}
+
+// accessHost is where an access phase evaluation runs: Kong's PDK or the net/http middleware.
+type accessHost interface {
+	accessOutcome
+	// sidebandContext returns the context of the policy provider call, before its phase deadline.
+	sidebandContext() context.Context
+	// provider returns the policy provider to call.
+	provider() (PolicyProvider, error)
+}
+
+// accessOutcome ends or passes on the requests the access decisions do not send to the provider.
+type accessOutcome interface {
+	// deny ends the request with status, body and headers, or only records it in shadow mode.
+	deny(status int, body []byte, headers map[string][]string)
+	// skip passes the request on without calling the policy provider.
+	skip()
+}
+
+// evaluateAccess applies the decisions that precede the policy provider call in the order shared
+// by all hosts, and calls the provider when none of them denied or skipped the request. It reports
+// whether the provider was called; when it was not, host has ended or passed on the request and
+// phase holds the decision.
+func evaluateAccess(conf *Config, host accessHost, payload *SidebandAccessRequest, consumerIDs []string, phase *decisionctx.Phase, logger *PluginLogger) (*SidebandAccessResponse, bool, error) {
+	if !decideAccess(conf, host, payload, consumerIDs, phase, logger) {
+		return nil, false, nil
+	}
+
+	ctx, cancel := phaseContext(host.sidebandContext(), conf, "access", 0)
+	defer cancel()
+	finishAccessPayload(ctx, conf, payload, consumerIDs, logger)
+
+	provider, err := host.provider()
+	if err != nil {
+		logger.Err("Failed to create policy provider", "error", err.Error())
+		phase.Decision, phase.StatusCode, phase.Error = decisionctx.DecisionError, 500, err.Error()
+		host.deny(500, nil, nil)
+		return nil, false, nil
+	}
+
+	start := time.Now()
+	resp, err := provider.EvaluateRequest(ctx, payload)
+	phase.LatencyMs = latencyMs(time.Since(start))
+	if err != nil {
+		recordSidebandFailure(phase, err)
+	}
+	return resp, true, err
+}
+
+// decideAccess applies the decisions that precede the policy provider call and reports whether
+// the request is to be evaluated; when it is not, host has ended or passed on the request and
+// phase holds the decision.
+func decideAccess(conf *Config, host accessOutcome, payload *SidebandAccessRequest, consumerIDs []string, phase *decisionctx.Phase, logger *PluginLogger) bool {
+	deny := func(status int, body []byte, headers map[string][]string, reason string) bool {
+		phase.Decision, phase.Reason, phase.StatusCode = decisionctx.DecisionDeny, reason, status
+		host.deny(status, body, headers)
+		return false
+	}
+	skip := func() bool {
+		phase.Decision = decisionctx.DecisionSkip
+		host.skip()
+		return false
+	}
+
+	if conf.RequireClientCertificate && payload.ClientCertificate == nil {
+		logger.Info("Client certificate required but not presented, denying request")
+		status, body, headers := clientCertificateDenial(conf, payload)
+		return deny(status, body, headers, decisionctx.ReasonClientCertificate)
+	}
+
+	if status, body, headers, ok := toolArgumentsDenial(conf, payload); ok {
+		logger.Info("MCP tool arguments do not match the configured schema, denying request")
+		return deny(status, body, headers, decisionctx.ReasonToolArguments)
+	}
+	if status, body, headers, blocked := screenPromptInjection(conf, payload, logger); blocked {
+		return deny(status, body, headers, decisionctx.ReasonPromptInjection)
+	}
+	if status, body, headers, ok := resourceDenial(conf, payload); ok {
+		logger.Info("MCP resource URI not allowed, denying request")
+		return deny(status, body, headers, decisionctx.ReasonResource)
+	}
+
+	switch action, rule := applyEvaluationRules(conf, payload, consumerIDs, logger); action {
+	case ruleActionSkip:
+		return skip()
+	case ruleActionDeny:
+		status, body, headers := ruleDenial(conf, rule, payload)
+		return deny(status, body, headers, decisionctx.ReasonEvaluationRule)
+	}
+
+	switch applyToolPolicy(conf, payload, logger) {
+	case toolPolicyAllow:
+		return skip()
+	case toolPolicyDeny:
+		status, body, headers := toolPolicyDenial(conf, payload)
+		return deny(status, body, headers, decisionctx.ReasonToolPolicy)
+	}
+
+	if !inEvaluationPercentage(conf, payload, consumerIDs) {
+		logger.Debug("Request not selected by evaluation_percentage, passing through")
+		return skip()
+	}
+
+	skipped, err := applyExpressions(conf, payload, logger)
+	if err != nil {
+		logger.Err("Failed to compile CEL expressions", "error", err.Error())
+		phase.Decision, phase.StatusCode, phase.Error = decisionctx.DecisionError, 500, err.Error()
+		host.deny(500, nil, nil)
+		return false
+	}
+	if skipped {
+		return skip()
+	}
+	return true
+}
+
+// finishAccessPayload completes the payload of a request that is to be evaluated with what is
+// only worth resolving then: the token claims and subject, which may call out within ctx, and
+// the sampled body.
+func finishAccessPayload(ctx context.Context, conf *Config, payload *SidebandAccessRequest, consumerIDs []string, logger *PluginLogger) {
+	resolveTokenClaims(ctx, conf, payload)
+	resolveTokenSubject(ctx, conf, payload, logger)
+
+	if !summarizeMultipart(conf, payload, logger) && !applyBodyContentTypes(conf, payload, logger) {
+		applyBodySampling(conf, payload, consumerIDs, logger)
+	}
+
+	DebugLogPayload(logger, "Sending sideband request", payload, conf)
+}
+
+// kongAccessHost runs the access phase evaluation in Kong.
+type kongAccessHost struct {
+	kong      *pdk.PDK
+	conf      *Config
+	parsedURL *ParsedURL
+	ctx       context.Context
+}
+
+func (h *kongAccessHost) deny(status int, body []byte, headers map[string][]string) {
+	exitKong(h.kong, h.conf, status, body, headers)
+}
+
+func (h *kongAccessHost) skip() {
+	h.kong.Ctx.SetShared("paz_skipped", "true")
+}
+
+func (h *kongAccessHost) sidebandContext() context.Context {
+	return h.ctx
+}
+
+func (h *kongAccessHost) provider() (PolicyProvider, error) {
+	return newProvider(h.conf, h.conf.getHTTPClient(), h.parsedURL)
+}
+
+// composeAccessPayload builds the JSON payload for the /sideband/request call.
+func composeAccessPayload(kong *pdk.PDK, conf *Config, parsedURL *ParsedURL) (*SidebandAccessRequest, error) {
+	sourceIP, err := kong.Client.GetIp()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client IP: %w", err)
+	}
+
+	sourcePort, err := kong.Client.GetPort()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client port: %w", err)
+	}
+
+	method, err := kong.Request.GetMethod()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get method: %w", err)
+	}
+
+	// Reconstruct forwarded URL
+	reqURL, err := buildForwardedURL(kong)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build URL: %w", err)
+	}
+
+	// Reading the body makes Kong buffer it, so it is left alone for skip_body_methods
+	var rawBody []byte
+	if !skipsBody(conf, method) {
+		if rawBody, err = kong.Request.GetRawBody(); err != nil {
+			return nil, fmt.Errorf("failed to get request body: %w", err)
+		}
+	}
+
+	headers, err := kong.Request.GetHeaders(-1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get headers: %w", err)
+	}
+
+	rawBody, payloadHeaders, encoded, err := decodeRequestBody(conf, headers, rawBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress request body: %w", err)
+	}
+	formattedHeaders, err := FormatHeaders(payloadHeaders)
+	if err != nil {
+		return nil, err
+	}
+
+	httpVersion, err := getHTTPVersion(kong)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get HTTP version: %w", err)
+	}
+
+	req := &SidebandAccessRequest{
+		SourceIP:    sourceIP,
+		SourcePort:  strconv.Itoa(sourcePort),
+		Method:      method,
+		URL:         reqURL,
+		Body:        string(rawBody),
+		Headers:     formattedHeaders,
+		HTTPVersion: httpVersion,
+		bodyNotRead: skipsBody(conf, method),
+		encoded:     encoded,
+
+		requestHeaders: headers,
+	}
+
+	if len(conf.ExtractHeaders) > 0 {
+		req.ExtractedHeaders = ExtractHeaders(headers, conf.ExtractHeaders)
+	}
+	if len(conf.ExtractBodyFields) > 0 {
+		req.ExtractedAttrs = ExtractBodyFields(rawBody, conf.ExtractBodyFields)
+	}
+
+	enrichGraphQL(conf, req)
+	enrichSOAP(conf, req)
+	enrichGRPC(conf, req)
+	enrichLLM(conf, req)
+	enrichWebSocket(req)
+	enrichMCP(req)
+	enrichMCPBatch(req)
+	enrichMCPSession(req)
+	enrichMCPClient(req)
+	countRequestTokens(conf, req)
+	req.Consumer = kongConsumer(kong)
+	if conf.IncludeKongRoute {
+		req.Route = kongRoute(kong)
+	}
+
+	if len(conf.AttributeMappings) > 0 {
+		rawQuery, err := kong.Request.GetRawQuery()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get query: %w", err)
+		}
+		req.Attributes = ResolveAttributes(conf.AttributeMappings, &AttributeInput{
+			Headers:  headers,
+			RawQuery: rawQuery,
+			Body:     rawBody,
+		})
+	}
+
+	// Try to extract client certificate (optional, fails silently on Kong OSS)
+	certPEM, err := getClientCertPEM(kong)
+	if err != nil || certPEM == "" {
+		if certPEM, err = xfccClientCertPEM(conf, headers); err != nil {
+			return nil, fmt.Errorf("failed to parse %s header: %w", conf.ClientCertHeader, err)
+		}
+	}
+	if certPEM != "" {
+		jwk, err := ExtractClientCertJWK(certPEM, conf.IncludeFullCertChain)
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract client certificate JWK: %w", err)
+		}
+		req.ClientCertificate = jwk
+	}
+
+	return req, nil
+}
+
+// kongConsumerIDs returns the username, custom id and id of the authenticated consumer, if any.
+func kongConsumerIDs(kong *pdk.PDK) []string {
+	consumer, err := kong.Client.GetConsumer()
+	if err != nil {
+		return nil
+	}
+	return []string{consumer.Username, consumer.CustomId, consumer.Id}
+}
+
+// kongConsumer returns the consumer and credential authenticated for the request, or nil when
+// no auth plugin has identified one.
+func kongConsumer(kong *pdk.PDK) *KongConsumer {
+	consumer, _ := kong.Client.GetConsumer()
+	credential, _ := kong.Client.GetCredential()
+	return newKongConsumer(consumer, credential)
+}
+
+func newKongConsumer(consumer entities.Consumer, credential client.AuthenticatedCredential) *KongConsumer {
+	c := &KongConsumer{
+		ID:           consumer.Id,
+		Username:     consumer.Username,
+		CustomID:     consumer.CustomId,
+		CredentialID: credential.Id,
+	}
+	if *c == (KongConsumer{}) {
+		return nil
+	}
+	return c
+}
+
+// kongRoute returns the route and service that matched the request. Routes without a service
+// only have their route fields set.
+func kongRoute(kong *pdk.PDK) *KongRoute {
+	route, _ := kong.Router.GetRoute()
+	service, _ := kong.Router.GetService()
+	return newKongRoute(route, service)
+}
+
+func newKongRoute(route entities.Route, service entities.Service) *KongRoute {
+	r := &KongRoute{
+		RouteID:     route.Id,
+		RouteName:   route.Name,
+		RouteTags:   route.Tags,
+		ServiceID:   service.Id,
+		ServiceName: service.Name,
+	}
+	if r.RouteID == "" && r.ServiceID == "" {
+		return nil
+	}
+	return r
+}
+
+// buildForwardedURL reconstructs the full forwarded URL.
+func buildForwardedURL(kong *pdk.PDK) (string, error) {
+	scheme, err := kong.Request.GetForwardedScheme()
+	if err != nil {
+		return "", err
+	}
+
+	host, err := kong.Request.GetForwardedHost()
+	if err != nil {
+		return "", err
+	}
+
+	port, err := kong.Request.GetForwardedPort()
+	if err != nil {
+		return "", err
+	}
+
+	// Use GetPath since GetForwardedPath doesn't exist in the PDK
+	path, err := kong.Request.GetPath()
+	if err != nil {
+		return "", err
+	}
+
+	reqURL := fmt.Sprintf("%s://%s:%d%s", scheme, host, port, path)
+
+	// Decode and re-encode query string (max 100 args)
+	rawQuery, err := kong.Request.GetRawQuery()
+	if err != nil {
+		return "", err
+	}
+
+	if q := encodeForwardedQuery(rawQuery); q != "" {
+		reqURL = reqURL + "?" + q
+	}
+
+	return reqURL, nil
+}
+
+// encodeForwardedQuery decodes and re-encodes a raw query string, keeping at most 100 args.
+// If the query cannot be parsed it is returned as-is.
+func encodeForwardedQuery(rawQuery string) string {
+	parsedQuery, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return rawQuery
+	}
+
+	count := 0
+	limitedQuery := url.Values{}
+	for key, values := range parsedQuery {
+		for _, v := range values {
+			if count >= 100 {
+				break
+			}
+			limitedQuery.Add(key, v)
+			count++
+		}
+		if count >= 100 {
+			break
+		}
+	}
+	return limitedQuery.Encode()
+}
+
+// applyExpressions evaluates skip_expression and merges derived_attributes into the payload.
+// Returns true if the request should skip sideband evaluation. Evaluation failures are logged
+// and never cause a skip; only a compile failure is returned as an error.
+func applyExpressions(conf *Config, payload *SidebandAccessRequest, logger *PluginLogger) (bool, error) {
+	exprs, err := conf.getExpressions()
+	if err != nil || exprs == nil {
+		return false, err
+	}
+
+	skip, err := exprs.ShouldSkip(payload)
+	if err != nil {
+		logger.Warn("skip_expression evaluation failed, evaluating request", "error", err.Error())
+	} else if skip {
+		logger.Debug("Request skipped by skip_expression")
+		return true, nil
+	}
+
+	derived, errs := exprs.DeriveAttributes(payload)
+	for _, e := range errs {
+		logger.Warn("Derived attribute evaluation failed", "error", e.Error())
+	}
+	if len(derived) > 0 {
+		if payload.Attributes == nil {
+			payload.Attributes = make(map[string]interface{}, len(derived))
+		}
+		for name, val := range derived {
+			payload.Attributes[name] = val
+		}
+	}
+	return false, nil
+}
+
+// getHTTPVersion returns the HTTP version as a string (e.g., "1.1", "2").
+func getHTTPVersion(kong *pdk.PDK) (string, error) {
+	version, err := kong.Request.GetHttpVersion()
+	if err != nil {
+		return "1.1", nil // default to 1.1
+	}
+
+	// GetHttpVersion returns a float64 (e.g., 1.1, 2.0)
+	if version == 2.0 {
+		return "2", nil
+	}
+	return fmt.Sprintf("%g", version), nil
+}
+
+// getClientCertPEM attempts to get the client certificate PEM from Kong.
+func getClientCertPEM(kong *pdk.PDK) (string, error) {
+	certPEM, err := kong.Nginx.GetVar("ssl_client_raw_cert")
+	if err != nil {
+		return "", err
+	}
+	return certPEM, nil
+}
+
+// handleAccessResponse processes the response from /sideband/request.
+// Returns the state (may be nil) and any error.
+// If the request is denied, it ends the request (see exitKong) and returns an error.
+func handleAccessResponse(kong *pdk.PDK, conf *Config, payload *SidebandAccessRequest, resp *SidebandAccessResponse, logger *PluginLogger) (json.RawMessage, error) {
+	// If response field is present → DENIED
+	if resp.Response != nil {
+		deny := resp.Response
+		statusCode, err := strconv.Atoi(deny.ResponseCode)
+		if err != nil {
+			statusCode = 403
+		}
+
+		body, headers := renderDeny(conf, statusCode, deny, kongHeader(kong), logger)
+		logger.Info("Request denied by policy provider", "status_code", statusCode)
+
+		exitKong(kong, conf, statusCode, body, headers)
+		return nil, fmt.Errorf("request denied with status %d", statusCode)
+	}
+
+	// ALLOWED — apply modifications
+	if !conf.ShadowMode {
+		updateRequest(kong, conf, restoreRequestCoding(payload, resp), logger)
+	}
+
+	return resp.State, nil
+}
+
+// updateRequest applies PingAuthorize modifications to the Kong request.
+func updateRequest(kong *pdk.PDK, conf *Config, resp *SidebandAccessResponse, logger *PluginLogger) {
+	// Get current request headers for diffing
+	currentHeaders, err := kong.Request.GetHeaders(-1)
+	if err != nil {
+		logger.Warn("Failed to get current headers for diffing", "error", err.Error())
+		return
+	}
+
+	// Lowercase all current header names for comparison
+	currentFlat := make(map[string][]string)
+	for name, values := range currentHeaders {
+		currentFlat[strings.ToLower(name)] = values
+	}
+
+	// Flatten response headers
+	newFlat := FlattenHeaders(resp.Headers)
+
+	// Remove headers that are in current but not in response
+	for name := range currentFlat {
+		if _, exists := newFlat[name]; !exists {
+			kong.ServiceRequest.ClearHeader(name)
+		}
+	}
+
+	// Update/add headers from response
+	for name, values := range newFlat {
+		currentValues, exists := currentFlat[name]
+		if !exists || !stringSliceEqual(currentValues, values) {
+			kong.ServiceRequest.SetHeader(name, values[0])
+			for _, v := range values[1:] {
+				kong.ServiceRequest.AddHeader(name, v)
+			}
+		}
+	}
+
+	// Strip Accept-Encoding if configured
+	if conf.StripAcceptEncoding {
+		kong.ServiceRequest.ClearHeader("Accept-Encoding")
+	}
+
+	// Update method if changed
+	if resp.Method != "" {
+		currentMethod, _ := kong.Request.GetMethod()
+		if resp.Method != currentMethod {
+			kong.ServiceRequest.SetMethod(resp.Method)
+		}
+	}
+
+	// Update URL if changed
+	if resp.URL != "" {
+		currentURL, _ := buildForwardedURL(kong)
+		if resp.URL != currentURL {
+			updateURL(kong, resp.URL, currentURL, logger)
+		}
+	}
+
+	// Update body if changed
+	if resp.Body != nil {
+		currentBody, _ := kong.Request.GetRawBody()
+		if *resp.Body != string(currentBody) {
+			kong.ServiceRequest.SetRawBody(*resp.Body)
+		}
+	}
+}
+
+// updateURL applies URL modifications from PingAuthorize.
+func updateURL(kong *pdk.PDK, newURL, currentURL string, logger *PluginLogger) {
+	newParsed, err := url.Parse(newURL)
+	if err != nil {
+		logger.Warn("Failed to parse new URL", "url", newURL, "error", err.Error())
+		return
+	}
+
+	currentParsed, err := url.Parse(currentURL)
+	if err != nil {
+		logger.Warn("Failed to parse current URL", "url", currentURL, "error", err.Error())
+		return
+	}
+
+	// Warn about unsupported scheme change
+	if newParsed.Scheme != currentParsed.Scheme {
+		logger.Warn("Scheme change not supported", "from", currentParsed.Scheme, "to", newParsed.Scheme)
+	}
+
+	// If host or port changed, update Host header
+	if newParsed.Host != currentParsed.Host {
+		kong.ServiceRequest.SetHeader("Host", newParsed.Host)
+	}
+
+	// If path changed
+	if newParsed.Path != currentParsed.Path {
+		kong.ServiceRequest.SetPath(newParsed.Path)
+	}
+
+	// If query changed
+	if newParsed.RawQuery != currentParsed.RawQuery {
+		kong.ServiceRequest.SetRawQuery(newParsed.RawQuery)
+	}
+}
+
+// storePerRequestContext stores the original request and state in Kong's per-request context,
+// or in the state_store with only its reference in the context.
+func storePerRequestContext(kong *pdk.PDK, conf *Config, originalRequest *SidebandAccessRequest, state json.RawMessage) {
+	if store := conf.getStateStore(); store != nil {
+		key, err := store.save(context.Background(), originalRequest, state)
+		if err == nil {
+			kong.Ctx.SetShared("paz_state_key", key)
+			return
+		}
+		NewPluginLogger(kong, "access", conf.ServiceURL).Warn("Failed to store state, using Kong context", "state_store", conf.StateStore, "error", err.Error())
+	}
+	reqJSON, err := json.Marshal(originalRequest)
+	if err == nil {
+		kong.Ctx.SetShared("paz_original_request", string(reqJSON))
+	}
+	if state != nil {
+		value, err := encodeKongState(conf, state)
+		if err != nil {
+			NewPluginLogger(kong, "access", conf.ServiceURL).Err("Policy state not kept for the response phase", "error", err.Error())
+			return
+		}
+		kong.Ctx.SetShared("paz_state", value)
+	}
+}
+
+// clientCertificateDenial builds the response for a request that presented no client certificate.
+// MCP requests and batches receive JSON-RPC 2.0 errors carrying the original request ids.
+func clientCertificateDenial(conf *Config, payload *SidebandAccessRequest) (int, []byte, map[string][]string) {
+	const message = "Client certificate required"
+	headers := map[string][]string{"Content-Type": {"application/json"}}
+
+	status := conf.ClientCertificateDenyStatus
+	if status == 0 {
+		status = 401
+	}
+
+	data := newMCPDenyData(conf, payload, status, decisionctx.ReasonClientCertificate)
+	if body := mcpDenyBody(message, []byte(payload.Body), data); body != nil {
+		return status, body, headers
+	}
+
+	body := fmt.Sprintf(`{"code":"CLIENT_CERTIFICATE_REQUIRED","message":%q}`, message)
+	return status, []byte(body), headers
+}
+
+// forwardHeadersContext returns a context carrying the configured forward_headers from the client request.
+func forwardHeadersContext(kong *pdk.PDK, conf *Config) context.Context {
+	ctx := context.Background()
+	if len(conf.ForwardHeaders) == 0 {
+		return ctx
+	}
+	headers, err := kong.Request.GetHeaders(-1)
+	if err != nil {
+		return ctx
+	}
+	return WithForwardHeaders(ctx, SelectForwardHeaders(headers, conf.ForwardHeaders))
+}
+
+// exitKong ends the request with status, unless shadow_mode is set: then the request continues
+// unchanged and only the decision the plugin would have enforced is recorded.
+func exitKong(kong *pdk.PDK, conf *Config, status int, body []byte, headers map[string][]string) {
+	if conf.ShadowMode {
+		NewPluginLogger(kong, "shadow", conf.ServiceURL).Debug("Shadow mode, decision not enforced", "status_code", status)
+		return
+	}
+	kong.Response.Exit(status, body, headers)
+}
+
+// markFailOpen sets fail_open_header on the upstream request and fail_open_response_header on the
+// client response, so fail-open traffic can be told apart from requests allowed by policy.
+func markFailOpen(kong *pdk.PDK, conf *Config) {
+	if conf.FailOpenHeader != "" {
+		kong.ServiceRequest.SetHeader(conf.FailOpenHeader, "true")
+	}
+	if conf.FailOpenResponseHeader != "" {
+		kong.Response.SetHeader(conf.FailOpenResponseHeader, "true")
+	}
+}
+
+// handleCircuitBreakerError sends the appropriate response when the circuit breaker is open.
+// It returns the status sent, or 0 if fail-open let the request through.
+func handleCircuitBreakerError(kong *pdk.PDK, cbErr *CircuitBreakerOpenError, conf *Config) int {
+	if cbErr.Trigger == Trigger429 {
+		body, headers := rateLimitedResponse(cbErr)
+		exitKong(kong, conf, 429, body, headers)
+		return 429
+	}
+
+	// 5xx/timeout trigger
+	if conf.failOpen() {
+		return 0 // allow through
+	}
+	exitKong(kong, conf, 502, nil, nil)
+	return 502
+}
+
+// rateLimitedResponse builds the 429 body and headers returned while the breaker is open on a 429 trigger.
+func rateLimitedResponse(cbErr *CircuitBreakerOpenError) ([]byte, map[string][]string) {
+	return limitExceededResponse(cbErr.RemainingMs)
+}
+
+// limitExceededResponse builds a LIMIT_EXCEEDED 429 body and headers asking the client to retry
+// after remainingMs.
+func limitExceededResponse(remainingMs int64) ([]byte, map[string][]string) {
+	remainingSec := (remainingMs + 999) / 1000 // round up
+	if remainingSec < 1 {
+		remainingSec = 1
+	}
+	body := fmt.Sprintf(`{"code":"LIMIT_EXCEEDED","message":"The request exceeded the allowed rate limit. Please try after %d second."}`, remainingSec)
+	return []byte(body), map[string][]string{
+		"Content-Type": {"application/json"},
+		"Retry-After":  {strconv.FormatInt(remainingSec, 10)},
+	}
+}
+
+// isPassthroughCode checks if a status code is in the passthrough list.
+func isPassthroughCode(code int, conf *Config) bool {
+	for _, c := range conf.PassthroughStatusCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// stringSliceEqual checks if two string slices are equal (order-sensitive).
+func stringSliceEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,321 @@
+package pingauthorize
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Kong/go-pdk/client"
+	"github.com/Kong/go-pdk/entities"
+
+	"github.com/idpartners/idpartners-ping-authorize/pingauthorize/decisionctx"
+)
+
+func TestHandleAccessResponse_Denied(t *testing.T) {
+	resp := &SidebandAccessResponse{
+		Response: &DenyResponse{
+			ResponseCode:   "403",
+			ResponseStatus: "FORBIDDEN",
+			Body:           `{"error":"access denied"}`,
+			Headers: []map[string]string{
+				{"content-type": "application/json"},
+			},
+		},
+	}
+
+	// We can't test with real kong PDK, but we can verify the response parsing
+	if resp.Response == nil {
+		t.Fatal("expected deny response to be present")
+	}
+	if resp.Response.ResponseCode != "403" {
+		t.Errorf("expected 403, got %s", resp.Response.ResponseCode)
+	}
+}
+
+func TestHandleAccessResponse_Allowed(t *testing.T) {
+	stateJSON := json.RawMessage(`{"session_id":"abc123"}`)
+	body := "modified body"
+	resp := &SidebandAccessResponse{
+		Method:  "POST",
+		URL:     "https://api.example.com/resource",
+		Body:    &body,
+		Headers: []map[string]string{{"content-type": "application/json"}},
+		State:   stateJSON,
+	}
+
+	if resp.Response != nil {
+		t.Fatal("expected no deny response for allowed request")
+	}
+	if resp.State == nil {
+		t.Fatal("expected state to be present")
+	}
+	if string(resp.State) != `{"session_id":"abc123"}` {
+		t.Errorf("unexpected state: %s", string(resp.State))
+	}
+}
+
+func TestBuildForwardedURL_Format(t *testing.T) {
+	// Test URL format matches spec: scheme://host:port/path[?query]
+	// This tests the pure string construction logic
+	scheme := "https"
+	host := "api.example.com"
+	port := 443
+	path := "/resource"
+	query := "key=value"
+
+	url := scheme + "://" + host + ":" + string(rune('0'+port/100)) + string(rune('0'+(port%100)/10)) + string(rune('0'+port%10)) + path + "?" + query
+	expected := "https://api.example.com:443/resource?key=value"
+	if url != expected {
+		t.Errorf("expected %q, got %q", expected, url)
+	}
+}
+
+func TestStringSliceEqual(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []string
+		want bool
+	}{
+		{"equal", []string{"a", "b"}, []string{"a", "b"}, true},
+		{"different length", []string{"a"}, []string{"a", "b"}, false},
+		{"different values", []string{"a", "b"}, []string{"a", "c"}, false},
+		{"both nil", nil, nil, true},
+		{"both empty", []string{}, []string{}, true},
+		{"nil vs empty", nil, []string{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := stringSliceEqual(tt.a, tt.b)
+			if got != tt.want {
+				t.Errorf("stringSliceEqual(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsPassthroughCode(t *testing.T) {
+	conf := &Config{
+		PassthroughStatusCodes: []int{413, 429},
+	}
+
+	if !isPassthroughCode(413, conf) {
+		t.Error("expected 413 to be passthrough")
+	}
+	if !isPassthroughCode(429, conf) {
+		t.Error("expected 429 to be passthrough")
+	}
+	if isPassthroughCode(500, conf) {
+		t.Error("expected 500 to NOT be passthrough")
+	}
+	if isPassthroughCode(200, conf) {
+		t.Error("expected 200 to NOT be passthrough")
+	}
+}
+
+func TestSidebandAccessRequestJSON(t *testing.T) {
+	req := &SidebandAccessRequest{
+		SourceIP:    "192.168.1.100",
+		SourcePort:  "54321",
+		Method:      "GET",
+		URL:         "https://api.example.com:443/resource?key=value",
+		Body:        "",
+		Headers:     []map[string]string{{"host": "api.example.com"}},
+		HTTPVersion: "1.1",
+	}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded SidebandAccessRequest
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.SourceIP != req.SourceIP {
+		t.Errorf("source_ip: want %q, got %q", req.SourceIP, decoded.SourceIP)
+	}
+	if decoded.Method != req.Method {
+		t.Errorf("method: want %q, got %q", req.Method, decoded.Method)
+	}
+}
+
+func TestSidebandAccessResponseJSON_WithState(t *testing.T) {
+	jsonData := `{
+		"source_ip": "192.168.1.100",
+		"method": "GET",
+		"url": "https://api.example.com/resource",
+		"headers": [{"host": "api.example.com"}],
+		"state": {"session_id": "abc"}
+	}`
+
+	var resp SidebandAccessResponse
+	if err := json.Unmarshal([]byte(jsonData), &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Response != nil {
+		t.Error("expected no deny response")
+	}
+	if resp.State == nil {
+		t.Error("expected state to be present")
+	}
+}
+
+func TestSidebandAccessResponseJSON_WithDeny(t *testing.T) {
+	jsonData := `{
+		"response": {
+			"response_code": "403",
+			"response_status": "FORBIDDEN",
+			"body": "{\"error\":\"denied\"}",
+			"headers": [{"content-type": "application/json"}]
+		}
+	}`
+
+	var resp SidebandAccessResponse
+	if err := json.Unmarshal([]byte(jsonData), &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Response == nil {
+		t.Fatal("expected deny response")
+	}
+	if resp.Response.ResponseCode != "403" {
+		t.Errorf("expected 403, got %s", resp.Response.ResponseCode)
+	}
+}
+
+func TestSidebandAccessRequestJSON_ExtractedHeadersOmittedWhenEmpty(t *testing.T) {
+	data, err := json.Marshal(&SidebandAccessRequest{Method: "GET"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "extracted_headers") {
+		t.Errorf("expected extracted_headers to be omitted, got %s", data)
+	}
+
+	data, err = json.Marshal(&SidebandAccessRequest{
+		Method:           "GET",
+		ExtractedHeaders: map[string]string{"x-tenant-id": "acme"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"extracted_headers":{"x-tenant-id":"acme"}`) {
+		t.Errorf("expected extracted_headers in payload, got %s", data)
+	}
+}
+
+func TestNewKongConsumer(t *testing.T) {
+	tests := []struct {
+		name       string
+		consumer   entities.Consumer
+		credential client.AuthenticatedCredential
+		want       string
+	}{
+		{"consumer and credential", entities.Consumer{Id: "c1", Username: "alice", CustomId: "crm-42"}, client.AuthenticatedCredential{Id: "k1", ConsumerId: "c1"}, `{"id":"c1","username":"alice","custom_id":"crm-42","credential_id":"k1"}`},
+		{"credential only", entities.Consumer{}, client.AuthenticatedCredential{Id: "k1"}, `{"credential_id":"k1"}`},
+		{"anonymous", entities.Consumer{}, client.AuthenticatedCredential{}, `null`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, _ := json.Marshal(newKongConsumer(tt.consumer, tt.credential))
+			if string(data) != tt.want {
+				t.Errorf("consumer = %s, want %s", data, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewKongRoute(t *testing.T) {
+	tests := []struct {
+		name    string
+		route   entities.Route
+		service entities.Service
+		want    string
+	}{
+		{"route and service", entities.Route{Id: "r1", Name: "orders", Tags: []string{"public"}}, entities.Service{Id: "s1", Name: "orders-api"}, `{"route_id":"r1","route_name":"orders","route_tags":["public"],"service_id":"s1","service_name":"orders-api"}`},
+		{"serviceless route", entities.Route{Id: "r1"}, entities.Service{}, `{"route_id":"r1"}`},
+		{"no route", entities.Route{}, entities.Service{}, `null`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, _ := json.Marshal(newKongRoute(tt.route, tt.service))
+			if string(data) != tt.want {
+				t.Errorf("route = %s, want %s", data, tt.want)
+			}
+		})
+	}
+}
+
+// recordingAccessHost records how evaluateAccess ended the request.
+type recordingAccessHost struct {
+	status  int
+	skipped bool
+	calls   *countingProvider
+	err     error
+}
+
+func (h *recordingAccessHost) deny(status int, body []byte, headers map[string][]string) {
+	h.status = status
+}
+
+func (h *recordingAccessHost) skip() {
+	h.skipped = true
+}
+
+func (h *recordingAccessHost) sidebandContext() context.Context {
+	return context.Background()
+}
+
+func (h *recordingAccessHost) provider() (PolicyProvider, error) {
+	return h.calls, h.err
+}
+
+func TestEvaluateAccess(t *testing.T) {
+	tests := []struct {
+		name          string
+		configure     func(*Config)
+		providerErr   error
+		wantEvaluated bool
+		wantStatus    int
+		wantSkipped   bool
+		wantDecision  string
+		wantReason    string
+	}{
+		{"evaluated", func(*Config) {}, nil, true, 0, false, "", ""},
+		{"client certificate", func(c *Config) { c.RequireClientCertificate = true }, nil, false, 401, false, decisionctx.DecisionDeny, decisionctx.ReasonClientCertificate},
+		{"skip expression", func(c *Config) { c.SkipExpression = "request.method == 'GET'" }, nil, false, 0, true, decisionctx.DecisionSkip, ""},
+		{"invalid expression", func(c *Config) { c.SkipExpression = "request.method ==" }, nil, false, 500, false, decisionctx.DecisionError, ""},
+		{"provider error", func(*Config) {}, errors.New("unknown provider"), false, 500, false, decisionctx.DecisionError, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := NewConfig()
+			conf.applyDefaults()
+			tt.configure(conf)
+			host := &recordingAccessHost{calls: &countingProvider{resp: func() *SidebandAccessResponse { return &SidebandAccessResponse{} }}, err: tt.providerErr}
+			var phase decisionctx.Phase
+			payload := &SidebandAccessRequest{SourceIP: "10.0.0.1", Method: "GET", URL: "https://api.example.com/a"}
+
+			_, evaluated, err := evaluateAccess(conf, host, payload, nil, &phase, NewPluginLogger(nil, "access", ""))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if evaluated != tt.wantEvaluated || host.status != tt.wantStatus || host.skipped != tt.wantSkipped {
+				t.Errorf("evaluated=%v status=%d skipped=%v, want %v %d %v", evaluated, host.status, host.skipped,
+					tt.wantEvaluated, tt.wantStatus, tt.wantSkipped)
+			}
+			if phase.Decision != tt.wantDecision || phase.Reason != tt.wantReason {
+				t.Errorf("decision=%q reason=%q, want %q %q", phase.Decision, phase.Reason, tt.wantDecision, tt.wantReason)
+			}
+			if wantCalls := map[bool]int{true: 1}[tt.wantEvaluated]; host.calls.calls != wantCalls {
+				t.Errorf("provider calls = %d, want %d", host.calls.calls, wantCalls)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package pingauthorize
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// BreakerStatus is the state of one circuit breaker as reported by the admin API.
+type BreakerStatus struct {
+	ServiceURL  string `json:"service_url"`
+	Path        string `json:"path"`
+	Enabled     bool   `json:"enabled"`
+	State       string `json:"state"` // open or closed
+	Trigger     string `json:"trigger,omitempty"`
+	RemainingMs int64  `json:"remaining_ms,omitempty"`
+	TripCount   int64  `json:"trip_count"`
+}
+
+// triggerNames are the admin API names of circuit breaker triggers.
+var triggerNames = map[CircuitBreakerTrigger]string{
+	Trigger429:     "rate_limited",
+	Trigger5xx:     "server_error",
+	TriggerTimeout: "timeout",
+	TriggerManual:  "manual",
+}
+
+// sidebandPaths are the sideband endpoints force-opened, under the service_url's base path, when
+// the admin API request names no path.
+var sidebandPaths = []string{"/sideband/request", "/sideband/response"}
+
+// AdminHandler returns the admin API of the plugin server process, which reports and controls
+// the circuit breakers of all plugin configs in the process:
+//
+//	GET  /circuit-breakers        list breakers
+//	POST /circuit-breakers/reset  close breakers
+//	POST /circuit-breakers/open   open breakers for retry_after_sec (default 30)
+//
+// All routes accept service_url and path query parameters to select breakers. Requests must send
+// token as a bearer token; with an empty token, every request is refused.
+func AdminHandler(token string) http.Handler {
+	return newAdminHandler(globalEndpoints, token)
+}
+
+func newAdminHandler(registry *endpointRegistry, token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/circuit-breakers", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeAdminError(w, http.StatusMethodNotAllowed, "use GET")
+			return
+		}
+		writeAdminJSON(w, http.StatusOK, registry.breakerStatus(r.URL.Query().Get("service_url"), r.URL.Query().Get("path")))
+	})
+	mux.HandleFunc("/circuit-breakers/reset", func(w http.ResponseWriter, r *http.Request) {
+		adminBreakerAction(w, r, registry, false)
+	})
+	mux.HandleFunc("/circuit-breakers/open", func(w http.ResponseWriter, r *http.Request) {
+		adminBreakerAction(w, r, registry, true)
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			writeAdminError(w, http.StatusUnauthorized, "invalid or missing bearer token")
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// adminBreakerAction resets or force-opens the selected breakers and responds with their state.
+func adminBreakerAction(w http.ResponseWriter, r *http.Request, registry *endpointRegistry, open bool) {
+	if r.Method != http.MethodPost {
+		writeAdminError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+	query := r.URL.Query()
+	serviceURL, path := query.Get("service_url"), query.Get("path")
+
+	retryAfterSec := defaultRetryAfterSec
+	if v := query.Get("retry_after_sec"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeAdminError(w, http.StatusBadRequest, "retry_after_sec must be a positive integer")
+			return
+		}
+		retryAfterSec = n
+	}
+
+	enabled := false
+	matched := registry.eachBreakers(serviceURL, func(breakers *circuitBreakers) {
+		// Trip is a no-op on disabled breakers, so there is nothing to open
+		if open && !breakers.template.enabled {
+			return
+		}
+		enabled = true
+		paths := breakers.paths()
+		if path != "" {
+			paths = []string{path}
+		} else if open {
+			paths = breakers.defaultPaths()
+		}
+		for _, p := range paths {
+			if open {
+				breakers.get(p).Trip(TriggerManual, retryAfterSec)
+			} else {
+				breakers.get(p).Reset()
+			}
+		}
+	})
+	if !matched {
+		writeAdminError(w, http.StatusNotFound, "no plugin config uses service_url "+serviceURL)
+		return
+	}
+	if !enabled {
+		writeAdminError(w, http.StatusConflict, "circuit breakers are disabled for service_url "+serviceURL)
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, registry.breakerStatus(serviceURL, path))
+}
+
+// defaultPaths returns the paths of the breakers in use and of the sideband endpoints, as keyed by
+// forURL: the path of the full sideband URL, including the service_url's base path.
+func (s *circuitBreakers) defaultPaths() []string {
+	paths := s.paths()
+	parsed, err := ParseURL(s.serviceURL)
+	if err != nil {
+		return paths
+	}
+	for _, p := range sidebandPaths {
+		if p = urlPath(BuildSidebandURL(parsed, p)); !slices.Contains(paths, p) {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+// breakerStatus returns the state of the breakers of endpoints matching serviceURL and path; empty
+// values match all.
+func (r *endpointRegistry) breakerStatus(serviceURL, path string) []BreakerStatus {
+	statuses := []BreakerStatus{}
+	r.eachBreakers(serviceURL, func(breakers *circuitBreakers) {
+		for _, p := range breakers.paths() {
+			if path != "" && p != path {
+				continue
+			}
+			st := breakers.get(p).status()
+			st.ServiceURL, st.Path = breakers.serviceURL, p
+			statuses = append(statuses, st)
+		}
+	})
+	return statuses
+}
+
+// eachBreakers calls fn with the breakers of every endpoint matching serviceURL (all if empty)
+// and reports whether any matched. fn runs without the registry lock held.
+func (r *endpointRegistry) eachBreakers(serviceURL string, fn func(*circuitBreakers)) bool {
+	serviceURL = strings.TrimRight(serviceURL, "/")
+	r.mu.Lock()
+	var matched []*circuitBreakers
+	for key, entry := range r.entries {
+		if serviceURL == "" || key.serviceURL == serviceURL {
+			matched = append(matched, entry.cb)
+		}
+	}
+	r.mu.Unlock()
+
+	sort.SliceStable(matched, func(i, j int) bool { return matched[i].serviceURL < matched[j].serviceURL })
+	for _, breakers := range matched {
+		fn(breakers)
+	}
+	return len(matched) > 0
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAdminError(w http.ResponseWriter, status int, message string) {
+	writeAdminJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,154 @@
+package pingauthorize
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminHandler_CircuitBreakers(t *testing.T) {
+	r := newEndpointRegistry()
+	client, lease := r.newClient(registryTestConfig("https://paz.example.com"))
+	defer lease.Release()
+	client.cb.get("/sideband/request").Trip(Trigger5xx, 30)
+	handler := newAdminHandler(r, "admin-token")
+
+	do := func(method, target string) (int, []BreakerStatus) {
+		t.Helper()
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("Authorization", "Bearer admin-token")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		var statuses []BreakerStatus
+		json.Unmarshal(rec.Body.Bytes(), &statuses)
+		return rec.Code, statuses
+	}
+
+	code, statuses := do(http.MethodGet, "/circuit-breakers")
+	if code != 200 || len(statuses) != 1 {
+		t.Fatalf("GET = %d %+v", code, statuses)
+	}
+	st := statuses[0]
+	if st.ServiceURL != "https://paz.example.com" || st.Path != "/sideband/request" || st.State != "open" ||
+		st.Trigger != "server_error" || st.RemainingMs <= 0 || st.TripCount != 1 {
+		t.Errorf("unexpected status %+v", st)
+	}
+
+	code, statuses = do(http.MethodPost, "/circuit-breakers/reset?service_url=https://paz.example.com/")
+	if code != 200 || len(statuses) != 1 || statuses[0].State != "closed" || statuses[0].TripCount != 1 {
+		t.Errorf("reset = %d %+v", code, statuses)
+	}
+	if !client.cb.get("/sideband/request").IsClosed() {
+		t.Error("expected the breaker to be closed")
+	}
+
+	code, statuses = do(http.MethodPost, "/circuit-breakers/open?retry_after_sec=120")
+	if code != 200 || len(statuses) != 2 {
+		t.Fatalf("open = %d %+v", code, statuses)
+	}
+	for _, st := range statuses {
+		if st.State != "open" || st.Trigger != "manual" || st.RemainingMs <= 60000 {
+			t.Errorf("unexpected status after open %+v", st)
+		}
+	}
+	if ok, cbErr := client.cb.get("/sideband/response").Allow(); ok || cbErr.Trigger != TriggerManual {
+		t.Error("expected the force-opened breaker to reject calls")
+	}
+
+	code, _ = do(http.MethodGet, "/circuit-breakers?path=/sideband/response")
+	if code != 200 {
+		t.Errorf("GET by path = %d", code)
+	}
+
+	for _, tt := range []struct {
+		method, target string
+		wantCode       int
+	}{
+		{http.MethodPost, "/circuit-breakers/reset?service_url=https://other.example.com", 404},
+		{http.MethodPost, "/circuit-breakers/open?retry_after_sec=0", 400},
+		{http.MethodGet, "/circuit-breakers/reset", 405},
+		{http.MethodPost, "/circuit-breakers", 405},
+	} {
+		if code, _ := do(tt.method, tt.target); code != tt.wantCode {
+			t.Errorf("%s %s = %d, want %d", tt.method, tt.target, code, tt.wantCode)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/circuit-breakers", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without the token, got %d", rec.Code)
+	}
+}
+
+func TestAdminHandler_OpenBasePath(t *testing.T) {
+	r := newEndpointRegistry()
+	client, lease := r.newClient(registryTestConfig("https://paz.example.com/base/"))
+	defer lease.Release()
+	disabledConf := registryTestConfig("https://disabled.example.com")
+	disabledConf.CircuitBreakerEnabled = false
+	_, disabledLease := r.newClient(disabledConf)
+	defer disabledLease.Release()
+	handler := newAdminHandler(r, "admin-token")
+
+	do := func(target string) (int, []BreakerStatus) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, target, nil)
+		req.Header.Set("Authorization", "Bearer admin-token")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		var statuses []BreakerStatus
+		json.Unmarshal(rec.Body.Bytes(), &statuses)
+		return rec.Code, statuses
+	}
+
+	code, statuses := do("/circuit-breakers/open?service_url=https://paz.example.com/base")
+	if code != 200 || len(statuses) != 2 {
+		t.Fatalf("open = %d %+v", code, statuses)
+	}
+	for i, want := range []string{"/base/sideband/request", "/base/sideband/response"} {
+		if statuses[i].Path != want || statuses[i].State != "open" {
+			t.Errorf("status %d = %+v, want %s open", i, statuses[i], want)
+		}
+	}
+	parsed, _ := ParseURL("https://paz.example.com/base")
+	if ok, _ := client.cb.forURL(BuildSidebandURL(parsed, "/sideband/request")).Allow(); ok {
+		t.Error("expected the breaker used for sideband calls to be open")
+	}
+
+	if code, _ := do("/circuit-breakers/open?service_url=https://disabled.example.com"); code != http.StatusConflict {
+		t.Errorf("open of disabled breakers = %d, want 409", code)
+	}
+	if code, _ := do("/circuit-breakers/reset?service_url=https://disabled.example.com"); code != 200 {
+		t.Errorf("reset of disabled breakers = %d, want 200", code)
+	}
+}
+
+func TestAdminHandler_Token(t *testing.T) {
+	r := newEndpointRegistry()
+	tests := []struct {
+		name          string
+		token, header string
+		wantCode      int
+	}{
+		{"bearer", "admin-token", "Bearer admin-token", 200},
+		{"bare token", "admin-token", "admin-token", 401},
+		{"wrong token", "admin-token", "Bearer other", 401},
+		{"missing", "admin-token", "", 401},
+		{"no token configured", "", "Bearer ", 401},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/circuit-breakers", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			newAdminHandler(r, tt.token).ServeHTTP(rec, req)
+			if rec.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+		})
+	}
+}
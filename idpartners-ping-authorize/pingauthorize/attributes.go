@@ -0,0 +1,254 @@
+package pingauthorize
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Attribute source kinds accepted in attribute_mappings source expressions.
+const (
+	AttributeSourceHeader = "header"
+	AttributeSourceQuery  = "query"
+	AttributeSourceBody   = "body"
+	AttributeSourceClaim  = "claim"
+)
+
+// AttributeMapping defines one custom attribute added to the sideband payload.
+// Source is "<kind>:<key>", e.g. "header:x-tenant-id", "query:account",
+// "body:$.account.id" or "claim:org_id".
+type AttributeMapping struct {
+	Name   string `json:"name"`
+	Source string `json:"source"`
+}
+
+// parseAttributeSource splits a source expression into its kind and key.
+func parseAttributeSource(source string) (string, string, error) {
+	kind, key, ok := strings.Cut(source, ":")
+	if !ok || key == "" {
+		return "", "", fmt.Errorf("source %q must have the form <kind>:<key>", source)
+	}
+	kind = strings.ToLower(strings.TrimSpace(kind))
+	switch kind {
+	case AttributeSourceHeader, AttributeSourceQuery, AttributeSourceBody, AttributeSourceClaim:
+		return kind, strings.TrimSpace(key), nil
+	default:
+		return "", "", fmt.Errorf("source %q has unknown kind %q (want header, query, body or claim)", source, kind)
+	}
+}
+
+// validateAttributeMappings checks mapping names are present and unique and sources parse.
+func validateAttributeMappings(mappings []AttributeMapping) error {
+	seen := make(map[string]bool, len(mappings))
+	for _, m := range mappings {
+		if m.Name == "" {
+			return fmt.Errorf("attribute_mappings entries must have a name")
+		}
+		if seen[m.Name] {
+			return fmt.Errorf("attribute_mappings name %q is duplicated", m.Name)
+		}
+		seen[m.Name] = true
+		if _, _, err := parseAttributeSource(m.Source); err != nil {
+			return fmt.Errorf("attribute_mappings %q: %w", m.Name, err)
+		}
+	}
+	return nil
+}
+
+// AttributeInput holds the request data attribute source expressions are evaluated against.
+type AttributeInput struct {
+	Headers  map[string][]string
+	RawQuery string
+	Body     []byte
+
+	query      url.Values
+	body       interface{}
+	bodyParsed bool
+	claims     map[string]interface{}
+	claimsRead bool
+}
+
+// ResolveAttributes evaluates the mappings against the input and returns the resulting attributes.
+// Mappings whose source yields no value are omitted. Returns nil if nothing resolved.
+func ResolveAttributes(mappings []AttributeMapping, in *AttributeInput) map[string]interface{} {
+	var result map[string]interface{}
+	for _, m := range mappings {
+		kind, key, err := parseAttributeSource(m.Source)
+		if err != nil {
+			continue
+		}
+		val, ok := in.lookup(kind, key)
+		if !ok {
+			continue
+		}
+		if result == nil {
+			result = make(map[string]interface{}, len(mappings))
+		}
+		result[m.Name] = val
+	}
+	return result
+}
+
+func (in *AttributeInput) lookup(kind, key string) (interface{}, bool) {
+	switch kind {
+	case AttributeSourceHeader:
+		values := ExtractHeaders(in.Headers, []string{key})
+		val, ok := values[strings.ToLower(key)]
+		return val, ok
+	case AttributeSourceQuery:
+		if in.query == nil {
+			in.query, _ = url.ParseQuery(in.RawQuery)
+		}
+		values, ok := in.query[key]
+		if !ok || len(values) == 0 {
+			return nil, false
+		}
+		if len(values) == 1 {
+			return values[0], true
+		}
+		return values, true
+	case AttributeSourceBody:
+		if !in.bodyParsed {
+			in.bodyParsed = true
+			if len(in.Body) > 0 {
+				json.Unmarshal(in.Body, &in.body)
+			}
+		}
+		return LookupJSONPath(in.body, key)
+	case AttributeSourceClaim:
+		if !in.claimsRead {
+			in.claimsRead = true
+			in.claims = BearerTokenClaims(in.Headers)
+		}
+		return LookupJSONPath(in.claims, key)
+	}
+	return nil, false
+}
+
+// ExtractBodyFields evaluates the JSON paths against a JSON request body and returns the values
+// found, keyed by path, for the extracted_attributes payload field. Paths that yield no value are
+// omitted. Returns nil if the body is not JSON or nothing matched.
+func ExtractBodyFields(body []byte, paths []string) map[string]interface{} {
+	var doc interface{}
+	if len(paths) == 0 || json.Unmarshal(body, &doc) != nil {
+		return nil
+	}
+	var result map[string]interface{}
+	for _, path := range paths {
+		val, ok := LookupJSONPath(doc, path)
+		if !ok {
+			continue
+		}
+		if result == nil {
+			result = make(map[string]interface{}, len(paths))
+		}
+		result[path] = val
+	}
+	return result
+}
+
+// validateJSONPath checks that path is a JSON path LookupJSONPath can resolve.
+func validateJSONPath(path string) error {
+	rest := strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if rest == "" {
+		return fmt.Errorf("path %q selects no field", path)
+	}
+	for _, segment := range strings.Split(rest, ".") {
+		name, indexes, err := splitPathSegment(segment)
+		if err != nil {
+			return err
+		}
+		if name == "" && len(indexes) == 0 {
+			return fmt.Errorf("path %q has an empty segment", path)
+		}
+	}
+	return nil
+}
+
+// LookupJSONPath resolves a simple JSONPath (e.g. "$.account.id", "items[0].name")
+// against a decoded JSON document. Only dotted member access and array indexes are supported.
+func LookupJSONPath(doc interface{}, path string) (interface{}, bool) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if path == "" {
+		return doc, doc != nil
+	}
+
+	cur := doc
+	for _, segment := range strings.Split(path, ".") {
+		name, indexes, err := splitPathSegment(segment)
+		if err != nil {
+			return nil, false
+		}
+		if name != "" {
+			obj, ok := cur.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if cur, ok = obj[name]; !ok {
+				return nil, false
+			}
+		}
+		for _, idx := range indexes {
+			arr, ok := cur.([]interface{})
+			if !ok || idx < 0 || idx >= len(arr) {
+				return nil, false
+			}
+			cur = arr[idx]
+		}
+	}
+	return cur, cur != nil
+}
+
+// splitPathSegment splits "items[0][1]" into "items" and [0, 1].
+func splitPathSegment(segment string) (string, []int, error) {
+	open := strings.IndexByte(segment, '[')
+	if open < 0 {
+		return segment, nil, nil
+	}
+
+	name := segment[:open]
+	var indexes []int
+	rest := segment[open:]
+	for rest != "" {
+		end := strings.IndexByte(rest, ']')
+		if rest[0] != '[' || end < 0 {
+			return "", nil, fmt.Errorf("malformed path segment %q", segment)
+		}
+		idx, err := strconv.Atoi(rest[1:end])
+		if err != nil {
+			return "", nil, fmt.Errorf("malformed index in %q", segment)
+		}
+		indexes = append(indexes, idx)
+		rest = rest[end+1:]
+	}
+	return name, indexes, nil
+}
+
+// BearerTokenClaims decodes the claims of a JWT bearer token in the Authorization header.
+// The signature is NOT verified; claims are only used as policy input for PingAuthorize.
+// Returns nil if there is no bearer token or it is not a decodable JWT.
+func BearerTokenClaims(headers map[string][]string) map[string]interface{} {
+	auth := ExtractHeaders(headers, []string{"authorization"})["authorization"]
+	scheme, token, ok := strings.Cut(auth, " ")
+	if !ok || !strings.EqualFold(scheme, "bearer") {
+		return nil
+	}
+
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 {
+		return nil
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil
+	}
+
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil
+	}
+	return claims
+}
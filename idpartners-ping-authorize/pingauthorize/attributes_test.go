@@ -0,0 +1,200 @@
+package pingauthorize
+
+import (
+	"encoding/base64"
+	"reflect"
+	"testing"
+)
+
+func testJWT(claimsJSON string) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	payload := base64.RawURLEncoding.EncodeToString([]byte(claimsJSON))
+	return header + "." + payload + ".sig"
+}
+
+func TestParseAttributeSource(t *testing.T) {
+	tests := []struct {
+		source  string
+		kind    string
+		key     string
+		wantErr bool
+	}{
+		{"header:x-tenant-id", "header", "x-tenant-id", false},
+		{"query:account", "query", "account", false},
+		{"body:$.account.id", "body", "$.account.id", false},
+		{"CLAIM:org_id", "claim", "org_id", false},
+		{"cookie:session", "", "", true},
+		{"header:", "", "", true},
+		{"header", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			kind, key, err := parseAttributeSource(tt.source)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if kind != tt.kind || key != tt.key {
+				t.Errorf("got (%q, %q), want (%q, %q)", kind, key, tt.kind, tt.key)
+			}
+		})
+	}
+}
+
+func TestValidateAttributeMappings(t *testing.T) {
+	if err := validateAttributeMappings([]AttributeMapping{{Name: "tenant", Source: "header:x-tenant"}}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := validateAttributeMappings([]AttributeMapping{{Source: "header:x-tenant"}}); err == nil {
+		t.Error("expected error for missing name")
+	}
+	if err := validateAttributeMappings([]AttributeMapping{
+		{Name: "tenant", Source: "header:x-tenant"},
+		{Name: "tenant", Source: "query:tenant"},
+	}); err == nil {
+		t.Error("expected error for duplicate name")
+	}
+	if err := validateAttributeMappings([]AttributeMapping{{Name: "x", Source: "bogus:y"}}); err == nil {
+		t.Error("expected error for bad source")
+	}
+}
+
+func TestResolveAttributes(t *testing.T) {
+	mappings := []AttributeMapping{
+		{Name: "tenant", Source: "header:X-Tenant-Id"},
+		{Name: "account", Source: "query:account"},
+		{Name: "tags", Source: "query:tag"},
+		{Name: "owner", Source: "body:$.order.owner.id"},
+		{Name: "first_item", Source: "body:order.items[0]"},
+		{Name: "org", Source: "claim:org_id"},
+		{Name: "missing", Source: "header:x-missing"},
+	}
+
+	in := &AttributeInput{
+		Headers: map[string][]string{
+			"X-Tenant-Id":   {"acme"},
+			"Authorization": {"Bearer " + testJWT(`{"sub":"alice","org_id":"org-7"}`)},
+		},
+		RawQuery: "account=42&tag=a&tag=b",
+		Body:     []byte(`{"order":{"owner":{"id":"u-1"},"items":["sku-1","sku-2"]}}`),
+	}
+
+	attrs := ResolveAttributes(mappings, in)
+
+	if attrs["tenant"] != "acme" {
+		t.Errorf("tenant: got %v", attrs["tenant"])
+	}
+	if attrs["account"] != "42" {
+		t.Errorf("account: got %v", attrs["account"])
+	}
+	if tags, ok := attrs["tags"].([]string); !ok || len(tags) != 2 {
+		t.Errorf("tags: got %v", attrs["tags"])
+	}
+	if attrs["owner"] != "u-1" {
+		t.Errorf("owner: got %v", attrs["owner"])
+	}
+	if attrs["first_item"] != "sku-1" {
+		t.Errorf("first_item: got %v", attrs["first_item"])
+	}
+	if attrs["org"] != "org-7" {
+		t.Errorf("org: got %v", attrs["org"])
+	}
+	if _, ok := attrs["missing"]; ok {
+		t.Error("unresolved mapping should be omitted")
+	}
+}
+
+func TestResolveAttributes_NonJSONBody(t *testing.T) {
+	attrs := ResolveAttributes(
+		[]AttributeMapping{{Name: "id", Source: "body:$.id"}},
+		&AttributeInput{Body: []byte("not json")},
+	)
+	if attrs != nil {
+		t.Errorf("expected nil attributes, got %v", attrs)
+	}
+}
+
+func TestLookupJSONPath(t *testing.T) {
+	doc := map[string]interface{}{
+		"a": map[string]interface{}{
+			"b": []interface{}{
+				map[string]interface{}{"c": "deep"},
+			},
+		},
+	}
+
+	if v, ok := LookupJSONPath(doc, "$.a.b[0].c"); !ok || v != "deep" {
+		t.Errorf("got (%v, %v)", v, ok)
+	}
+	if _, ok := LookupJSONPath(doc, "$.a.b[1].c"); ok {
+		t.Error("expected out-of-range index to miss")
+	}
+	if _, ok := LookupJSONPath(doc, "$.a.x"); ok {
+		t.Error("expected missing key to miss")
+	}
+	if _, ok := LookupJSONPath(doc, "$.a.b[x]"); ok {
+		t.Error("expected malformed index to miss")
+	}
+}
+
+func TestExtractBodyFields(t *testing.T) {
+	body := []byte(`{"account":{"id":"acc-1","tenant":"acme"},"items":[{"sku":"A1"}],"note":null}`)
+	paths := []string{"$.account.id", "account.tenant", "$.items[0].sku", "$.note", "$.missing"}
+
+	got := ExtractBodyFields(body, paths)
+	want := map[string]interface{}{"$.account.id": "acc-1", "account.tenant": "acme", "$.items[0].sku": "A1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ExtractBodyFields() = %v, want %v", got, want)
+	}
+	if got := ExtractBodyFields([]byte("not json"), paths); got != nil {
+		t.Errorf("expected nil for a non-JSON body, got %v", got)
+	}
+	if got := ExtractBodyFields(body, []string{"$.missing"}); got != nil {
+		t.Errorf("expected nil when nothing matched, got %v", got)
+	}
+}
+
+func TestValidateJSONPath(t *testing.T) {
+	tests := []struct {
+		path    string
+		wantErr bool
+	}{
+		{"$.account.id", false},
+		{"items[0].sku", false},
+		{"$.matrix[0][1]", false},
+		{"$", true},
+		{"$.a..b", true},
+		{"$.items[x]", true},
+		{"$.items[0", true},
+	}
+	for _, tt := range tests {
+		if err := validateJSONPath(tt.path); (err != nil) != tt.wantErr {
+			t.Errorf("validateJSONPath(%q) error = %v, wantErr %v", tt.path, err, tt.wantErr)
+		}
+	}
+}
+
+func TestBearerTokenClaims(t *testing.T) {
+	claims := BearerTokenClaims(map[string][]string{
+		"Authorization": {"Bearer " + testJWT(`{"sub":"alice"}`)},
+	})
+	if claims["sub"] != "alice" {
+		t.Errorf("sub: got %v", claims["sub"])
+	}
+
+	if BearerTokenClaims(map[string][]string{"Authorization": {"Basic dXNlcjpwYXNz"}}) != nil {
+		t.Error("expected nil claims for basic auth")
+	}
+	if BearerTokenClaims(map[string][]string{"Authorization": {"Bearer opaque-token"}}) != nil {
+		t.Error("expected nil claims for opaque token")
+	}
+	if BearerTokenClaims(nil) != nil {
+		t.Error("expected nil claims without headers")
+	}
+}
@@ -0,0 +1,269 @@
+package pingauthorize
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Kong/go-pdk"
+
+	"github.com/idpartners/idpartners-ping-authorize/pingauthorize/decisionctx"
+)
+
+// Audit log sinks (audit_log_sink).
+const (
+	auditSinkStdout = "stdout"
+	auditSinkFile   = "file"
+	auditSinkHTTP   = "http"
+)
+
+const (
+	defaultAuditLogMaxSizeMB       = 100
+	defaultAuditLogMaxBackups      = 5
+	defaultAuditLogRequestIDHeader = "X-Request-Id"
+)
+
+// auditActionModify is the AuditEvent.Action of an allow in which the policy changed the request
+// or response.
+const auditActionModify = "modify"
+
+// AuditEvent is one line of the audit log: the decision of an access or response phase.
+type AuditEvent struct {
+	Time       time.Time `json:"time"`
+	ServiceURL string    `json:"service_url"`
+	Phase      string    `json:"phase"`
+	// Action is the decision of the phase (allow, deny, skip or error), or modify for an allow in
+	// which the policy changed the request or response.
+	Action    string              `json:"action"`
+	RequestID string              `json:"request_id,omitempty"`
+	Request   decisionctx.Request `json:"request"`
+	MCP       *decisionctx.MCP    `json:"mcp,omitempty"`
+	Decision  decisionctx.Phase   `json:"decision"`
+}
+
+// validateAuditLog checks the audit_log_* settings.
+func validateAuditLog(c *Config) error {
+	switch c.AuditLogSink {
+	case "", auditSinkStdout:
+	case auditSinkFile:
+		if c.AuditLogPath == "" {
+			return fmt.Errorf("audit_log_path is required when audit_log_sink is file")
+		}
+	case auditSinkHTTP:
+		if !validWebhookURL(c.AuditLogURL) {
+			return fmt.Errorf("audit_log_url must be an http or https URL when audit_log_sink is http, got %q", c.AuditLogURL)
+		}
+	default:
+		return fmt.Errorf("audit_log_sink must be stdout, file or http, got %q", c.AuditLogSink)
+	}
+	if c.AuditLogMaxSizeMB < 0 {
+		return fmt.Errorf("audit_log_max_size_mb must be >= 0")
+	}
+	if c.AuditLogMaxBackups < 0 {
+		return fmt.Errorf("audit_log_max_backups must be >= 0")
+	}
+	return nil
+}
+
+// auditLog writes the audit events of one config to its sink.
+type auditLog struct {
+	sink            auditSink
+	serviceURL      string
+	requestIDHeader string
+}
+
+// getAuditLog returns the lazily-created audit log of the config, or nil when audit_log_sink is
+// not set or its sink cannot be opened.
+func (c *Config) getAuditLog() *auditLog {
+	c.auditOnce.Do(func() {
+		if c.AuditLogSink == "" {
+			return
+		}
+		sink, err := globalAuditSinks.open(c)
+		if err != nil {
+			NewPluginLogger(nil, "audit", c.ServiceURL).Err("Failed to open audit log", "error", err.Error())
+			return
+		}
+		c.audit = &auditLog{sink: sink, serviceURL: c.ServiceURL, requestIDHeader: c.AuditLogRequestIDHeader}
+	})
+	return c.audit
+}
+
+// log writes the decision of phase. record may be nil when the request is unknown, and header
+// returns a request header, read only when the audit log is enabled. Safe to call on a nil log.
+func (a *auditLog) log(phaseName string, record *decisionctx.Record, phase *decisionctx.Phase, header func(string) string) {
+	if a == nil {
+		return
+	}
+	event := AuditEvent{
+		Time:       time.Now().UTC(),
+		ServiceURL: a.serviceURL,
+		Phase:      phaseName,
+		Action:     phase.Decision,
+		Decision:   *phase,
+	}
+	if phase.Decision == decisionctx.DecisionAllow && len(phase.Obligations) > 0 {
+		event.Action = auditActionModify
+	}
+	if a.requestIDHeader != "" {
+		event.RequestID = header(a.requestIDHeader)
+	}
+	if record != nil {
+		event.Request, event.MCP = record.Request, record.MCP
+	}
+	line, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	a.sink.write(append(line, '\n'))
+}
+
+// kongHeader returns a function reading headers of the client request in Kong.
+func kongHeader(kong *pdk.PDK) func(string) string {
+	return func(name string) string {
+		v, _ := kong.Request.GetHeader(name)
+		return v
+	}
+}
+
+// auditSink receives newline-terminated JSON audit events. write must not block the request.
+type auditSink interface {
+	write(line []byte)
+}
+
+// auditSinkRegistry shares sinks between configs, so configs logging to the same file append to
+// one rotating writer. Sinks stay open for the lifetime of the process.
+type auditSinkRegistry struct {
+	mu    sync.Mutex
+	sinks map[string]auditSink
+}
+
+var globalAuditSinks = &auditSinkRegistry{sinks: make(map[string]auditSink)}
+
+// open returns the sink of config, creating it on first use.
+func (r *auditSinkRegistry) open(config *Config) (auditSink, error) {
+	key := config.AuditLogSink
+	switch config.AuditLogSink {
+	case auditSinkFile:
+		key += " " + config.AuditLogPath
+	case auditSinkHTTP:
+		key += " " + config.AuditLogURL
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if sink, ok := r.sinks[key]; ok {
+		return sink, nil
+	}
+	var sink auditSink
+	switch config.AuditLogSink {
+	case auditSinkStdout:
+		sink = &writerAuditSink{w: os.Stdout}
+	case auditSinkFile:
+		file, err := newFileAuditSink(config.AuditLogPath, int64(config.AuditLogMaxSizeMB)<<20, config.AuditLogMaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		sink = file
+	case auditSinkHTTP:
+		sink = httpAuditSink{globalWebhooks.get(config.AuditLogURL, true, defaultWebhookRetries)}
+	default:
+		return nil, fmt.Errorf("unknown audit_log_sink %q", config.AuditLogSink)
+	}
+	r.sinks[key] = sink
+	return sink, nil
+}
+
+// writerAuditSink writes events to w, one line at a time.
+type writerAuditSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *writerAuditSink) write(line []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.w.Write(line)
+}
+
+// fileAuditSink appends events to a file, rotating it to path.1 ... path.<maxBackups> when it
+// would grow beyond maxSize bytes. A maxSize of 0 disables rotation.
+type fileAuditSink struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func newFileAuditSink(path string, maxSize int64, maxBackups int) (*fileAuditSink, error) {
+	s := &fileAuditSink{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := s.openFile(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *fileAuditSink) openFile() error {
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log %s: %w", s.path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open audit log %s: %w", s.path, err)
+	}
+	s.file, s.size = file, info.Size()
+	return nil
+}
+
+func (s *fileAuditSink) write(line []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file != nil && s.maxSize > 0 && s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			NewPluginLogger(nil, "audit", "").Warn("Failed to rotate audit log", "path", s.path, "error", err.Error())
+			return
+		}
+	}
+	if s.file == nil && s.openFile() != nil {
+		return // reopening after a failed rotation
+	}
+	n, _ := s.file.Write(line)
+	s.size += int64(n)
+}
+
+// rotate shifts the backups by one, dropping the oldest, and starts a new file.
+func (s *fileAuditSink) rotate() error {
+	s.file.Close()
+	s.file = nil
+	if s.maxBackups == 0 {
+		os.Remove(s.path)
+	} else {
+		os.Remove(s.backup(s.maxBackups))
+		for i := s.maxBackups - 1; i >= 1; i-- {
+			os.Rename(s.backup(i), s.backup(i+1))
+		}
+		os.Rename(s.path, s.backup(1))
+	}
+	return s.openFile()
+}
+
+func (s *fileAuditSink) backup(n int) string {
+	return s.path + "." + strconv.Itoa(n)
+}
+
+// httpAuditSink posts events as batches of newline-delimited JSON, see webhookBatcher.
+type httpAuditSink struct {
+	*webhookBatcher
+}
+
+func (s httpAuditSink) write(line []byte) {
+	s.enqueue(line)
+}
@@ -0,0 +1,151 @@
+package pingauthorize
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readAuditEvents decodes the audit events written to path.
+func readAuditEvents(t *testing.T, path string) []AuditEvent {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var events []AuditEvent
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("invalid audit line %q: %v", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestMiddleware_AuditLog(t *testing.T) {
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/sideband/request") {
+			var req SidebandAccessRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			if req.Method == http.MethodDelete {
+				json.NewEncoder(w).Encode(SidebandAccessResponse{Response: &DenyResponse{ResponseCode: "403"}})
+				return
+			}
+			req.Headers = append(req.Headers, map[string]string{"x-user": "alice"})
+			json.NewEncoder(w).Encode(SidebandAccessResponse{Method: req.Method, URL: req.URL, Headers: req.Headers})
+			return
+		}
+		json.NewEncoder(w).Encode(SidebandResponseResult{ResponseCode: "200", Body: "upstream"})
+	})
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	conf := NewConfig()
+	conf.ServiceURL = server.URL
+	conf.SharedSecret = "secret"
+	conf.SecretHeaderName = "X-Secret"
+	conf.AuditLogSink = auditSinkFile
+	conf.AuditLogPath = path
+	m, err := NewMiddleware(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := m.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("upstream"))
+	}))
+
+	mcpCall := `{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"name":"search"}}`
+	req := httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(mcpCall))
+	req.Header.Set("X-Request-Id", "req-1")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/resource", nil))
+
+	events := readAuditEvents(t, path)
+	if len(events) != 3 {
+		t.Fatalf("expected 3 audit events, got %+v", events)
+	}
+	access, response, deny := events[0], events[1], events[2]
+	if access.Phase != "access" || access.Action != auditActionModify || access.RequestID != "req-1" ||
+		access.Request.Method != http.MethodPost || access.MCP == nil || access.MCP.Tool != "search" ||
+		access.Decision.LatencyMs == nil || access.ServiceURL != server.URL {
+		t.Errorf("unexpected access event %+v", access)
+	}
+	if response.Phase != "response" || response.Action != "allow" || response.RequestID != "req-1" || response.MCP == nil {
+		t.Errorf("unexpected response event %+v", response)
+	}
+	if deny.Phase != "access" || deny.Action != "deny" || deny.Decision.StatusCode != 403 || deny.RequestID != "" {
+		t.Errorf("unexpected deny event %+v", deny)
+	}
+}
+
+func TestFileAuditSink_Rotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := newFileAuditSink(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 10 bytes per file: "three", "four" and "six" start new files, the oldest backup is dropped
+	for _, line := range []string{"one\n", "two\n", "three\n", "four\n", "five\n", "six\n"} {
+		sink.write([]byte(line))
+	}
+
+	for name, want := range map[string]string{
+		path:        "six\n",
+		path + ".1": "four\nfive\n",
+		path + ".2": "three\n",
+	} {
+		got, err := os.ReadFile(name)
+		if err != nil || string(got) != want {
+			t.Errorf("%s = %q (%v), want %q", filepath.Base(name), got, err, want)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Error("expected at most 2 backups")
+	}
+}
+
+func TestValidateAuditLog(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(c *Config)
+		wantErr string
+	}{
+		{"disabled", func(c *Config) {}, ""},
+		{"stdout", func(c *Config) { c.AuditLogSink = auditSinkStdout }, ""},
+		{"file", func(c *Config) { c.AuditLogSink, c.AuditLogPath = auditSinkFile, "/var/log/paz/audit.log" }, ""},
+		{"http", func(c *Config) { c.AuditLogSink, c.AuditLogURL = auditSinkHTTP, "https://audit.example.com/events" }, ""},
+		{"unknown sink", func(c *Config) { c.AuditLogSink = "syslog" }, "audit_log_sink"},
+		{"file without path", func(c *Config) { c.AuditLogSink = auditSinkFile }, "audit_log_path is required"},
+		{"http without url", func(c *Config) { c.AuditLogSink = auditSinkHTTP }, "audit_log_url"},
+		{"negative max size", func(c *Config) { c.AuditLogMaxSizeMB = -1 }, "audit_log_max_size_mb"},
+		{"negative max backups", func(c *Config) { c.AuditLogMaxBackups = -1 }, "audit_log_max_backups"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := NewConfig()
+			conf.ServiceURL = "https://paz.example.com"
+			conf.SharedSecret = "secret"
+			conf.SecretHeaderName = "X-Secret"
+			tt.modify(conf)
+			err := conf.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,263 @@
+package pingauthorize
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// ProviderAuthZen is the name of the built-in OpenID AuthZEN Access Evaluation API provider.
+	ProviderAuthZen = "authzen"
+
+	defaultAuthZenEvaluationPath = "/access/v1/evaluation"
+	defaultAuthZenSubjectHeader  = "x-consumer-username"
+	defaultAuthZenSubjectType    = "user"
+
+	// authZenAnonymousSubject is the subject id sent when the subject header is absent.
+	authZenAnonymousSubject = "anonymous"
+)
+
+func init() {
+	RegisterProvider(ProviderAuthZen, func(config *Config, httpClient *SidebandHTTPClient, parsedURL *ParsedURL) (PolicyProvider, error) {
+		return NewAuthZenProvider(config, httpClient, parsedURL), nil
+	})
+	RegisterProviderValidator(ProviderAuthZen, validateAuthZenConfig)
+}
+
+// validateAuthZenConfig checks the authzen_* settings.
+func validateAuthZenConfig(config *Config) error {
+	if config.AuthZenEvaluationPath != "" && !strings.HasPrefix(config.AuthZenEvaluationPath, "/") {
+		return fmt.Errorf("authzen_evaluation_path must start with /, got %q", config.AuthZenEvaluationPath)
+	}
+	return nil
+}
+
+// AuthZenEvaluationRequest is the OpenID AuthZEN Access Evaluation API request.
+type AuthZenEvaluationRequest struct {
+	Subject  AuthZenEntity          `json:"subject"`
+	Resource AuthZenEntity          `json:"resource"`
+	Action   AuthZenAction          `json:"action"`
+	Context  map[string]interface{} `json:"context,omitempty"`
+}
+
+// AuthZenEntity is an AuthZEN subject or resource.
+type AuthZenEntity struct {
+	Type       string                 `json:"type"`
+	ID         string                 `json:"id"`
+	Properties map[string]interface{} `json:"properties,omitempty"`
+}
+
+// AuthZenAction is an AuthZEN action.
+type AuthZenAction struct {
+	Name       string                 `json:"name"`
+	Properties map[string]interface{} `json:"properties,omitempty"`
+}
+
+// AuthZenEvaluationResponse is the OpenID AuthZEN Access Evaluation API response.
+type AuthZenEvaluationResponse struct {
+	Decision bool                    `json:"decision"`
+	Context  *AuthZenResponseContext `json:"context,omitempty"`
+}
+
+// AuthZenResponseContext holds the response context members the provider understands.
+// Headers are added to the upstream request on permit and to the client response on deny.
+// ReasonUser maps a language tag (optionally suffixed with a status, e.g. "en-403") to a message.
+type AuthZenResponseContext struct {
+	Headers    map[string]string `json:"headers,omitempty"`
+	ReasonUser map[string]string `json:"reason_user,omitempty"`
+}
+
+// AuthZenProvider implements PolicyProvider using the OpenID AuthZEN Access Evaluation API.
+// AuthZEN has no response evaluation, so upstream responses are passed through unchanged.
+type AuthZenProvider struct {
+	httpClient *SidebandHTTPClient
+	config     *Config
+	parsedURL  *ParsedURL
+}
+
+// NewAuthZenProvider creates a new AuthZenProvider.
+func NewAuthZenProvider(config *Config, httpClient *SidebandHTTPClient, parsedURL *ParsedURL) *AuthZenProvider {
+	return &AuthZenProvider{
+		httpClient: httpClient,
+		config:     config,
+		parsedURL:  parsedURL,
+	}
+}
+
+// EvaluateRequest maps the access phase payload to an AuthZEN evaluation, sends it to
+// authzen_evaluation_path and maps the decision back to an allow or deny.
+func (p *AuthZenProvider) EvaluateRequest(ctx context.Context, req *SidebandAccessRequest) (*SidebandAccessResponse, error) {
+	body, err := json.Marshal(buildAuthZenRequest(p.config, req))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode AuthZEN request: %w", err)
+	}
+
+	path := p.config.AuthZenEvaluationPath
+	if path == "" {
+		path = defaultAuthZenEvaluationPath
+	}
+	requestURL := BuildSidebandURL(p.parsedURL, path)
+	statusCode, _, respBody, err := p.httpClient.Execute(ctx, requestURL, body, p.parsedURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if statusCode >= 400 {
+		var errResp SidebandErrorResponse
+		json.Unmarshal(respBody, &errResp)
+		return nil, &sidebandHTTPError{
+			StatusCode: statusCode,
+			Body:       respBody,
+			Message:    errResp.Message,
+			ID:         errResp.ID,
+		}
+	}
+
+	var evaluation AuthZenEvaluationResponse
+	if err := json.Unmarshal(respBody, &evaluation); err != nil {
+		return nil, fmt.Errorf("failed to decode AuthZEN response: %w", err)
+	}
+	return authZenDecision(req, &evaluation), nil
+}
+
+// EvaluateResponse returns the upstream response unchanged without calling the PDP.
+func (p *AuthZenProvider) EvaluateResponse(ctx context.Context, req *SidebandResponsePayload) (*SidebandResponseResult, error) {
+	return &SidebandResponseResult{
+		ResponseCode: req.ResponseCode,
+		Body:         req.Body,
+		Headers:      req.Headers,
+	}, nil
+}
+
+// buildAuthZenRequest maps a sideband access payload to an AuthZEN evaluation request.
+// MCP requests are evaluated as the tool, resource or prompt they address with the JSON-RPC
+// method as the action; other requests as the URL path with the HTTP method as the action.
+func buildAuthZenRequest(conf *Config, req *SidebandAccessRequest) *AuthZenEvaluationRequest {
+	headers := FlattenHeaders(req.Headers)
+
+	subjectHeader := strings.ToLower(conf.AuthZenSubjectHeader)
+	if subjectHeader == "" {
+		subjectHeader = defaultAuthZenSubjectHeader
+	}
+	subjectType := conf.AuthZenSubjectType
+	if subjectType == "" {
+		subjectType = defaultAuthZenSubjectType
+	}
+	subject := AuthZenEntity{Type: subjectType, ID: firstValue(headers[subjectHeader])}
+	if subject.ID == "" {
+		subject.ID = authZenAnonymousSubject
+	}
+	if req.ClientCertificate != nil || req.Subject != nil {
+		subject.Properties = map[string]interface{}{}
+	}
+	if req.ClientCertificate != nil {
+		subject.Properties["client_certificate"] = req.ClientCertificate
+	}
+	if req.Subject != nil {
+		subject.Properties["active"] = req.Subject.Active
+		if len(req.Subject.Scopes) > 0 {
+			subject.Properties["scopes"] = req.Subject.Scopes
+		}
+		if req.Subject.ClientID != "" {
+			subject.Properties["client_id"] = req.Subject.ClientID
+		}
+	}
+
+	var resource AuthZenEntity
+	var action AuthZenAction
+	if mcpReq := parseMCPRequest([]byte(req.Body)); mcpReq != nil {
+		resource, action = authZenMCPTarget(mcpReq)
+	} else {
+		resource = AuthZenEntity{Type: "route", ID: "/"}
+		if u, err := url.Parse(req.URL); err == nil {
+			if u.Path != "" {
+				resource.ID = u.Path
+			}
+			resource.Properties = map[string]interface{}{"url": req.URL, "host": u.Hostname()}
+		}
+		action = AuthZenAction{Name: req.Method}
+	}
+
+	evalContext := map[string]interface{}{
+		"source_ip":    req.SourceIP,
+		"source_port":  req.SourcePort,
+		"http_version": req.HTTPVersion,
+		"headers":      headers,
+	}
+	if len(req.Attributes) > 0 {
+		evalContext["attributes"] = req.Attributes
+	}
+	if len(req.ExtractedHeaders) > 0 {
+		evalContext["extracted_headers"] = req.ExtractedHeaders
+	}
+	if req.BodyDigest != nil {
+		evalContext["body_digest"] = req.BodyDigest
+	}
+
+	return &AuthZenEvaluationRequest{Subject: subject, Resource: resource, Action: action, Context: evalContext}
+}
+
+// authZenMCPTarget maps an MCP request to its AuthZEN resource and action.
+func authZenMCPTarget(req *jsonRPCRequest) (AuthZenEntity, AuthZenAction) {
+	var params struct {
+		Name string `json:"name"`
+		URI  string `json:"uri"`
+	}
+	json.Unmarshal(req.Params, &params)
+
+	action := AuthZenAction{Name: req.Method}
+	if len(req.Params) > 0 {
+		action.Properties = map[string]interface{}{"params": req.Params}
+	}
+
+	switch req.Method {
+	case "tools/call":
+		return AuthZenEntity{Type: "mcp_tool", ID: params.Name}, action
+	case "resources/read", "resources/subscribe", "resources/unsubscribe":
+		return AuthZenEntity{Type: "mcp_resource", ID: params.URI}, action
+	case "prompts/get":
+		return AuthZenEntity{Type: "mcp_prompt", ID: params.Name}, action
+	default:
+		return AuthZenEntity{Type: "mcp_server", ID: req.Method}, action
+	}
+}
+
+// authZenDecision maps an AuthZEN decision to a sideband access response. A permit forwards the
+// request unchanged apart from any context headers; a deny returns 403 with the user reason.
+func authZenDecision(req *SidebandAccessRequest, evaluation *AuthZenEvaluationResponse) *SidebandAccessResponse {
+	var extra map[string]string
+	if evaluation.Context != nil {
+		extra = evaluation.Context.Headers
+	}
+
+	if !evaluation.Decision {
+		message := ""
+		if evaluation.Context != nil {
+			message = authZenReason(evaluation.Context.ReasonUser)
+		}
+		return policyDenyResponse(http.StatusForbidden, message, extra)
+	}
+	return policyPermitResponse(req, extra, nil)
+}
+
+// authZenReason picks the English user reason ("en" or "en-<status>"), falling back to the first
+// reason by language tag.
+func authZenReason(reasons map[string]string) string {
+	if reason, ok := reasons["en"]; ok {
+		return reason
+	}
+	keys := sortedKeys(reasons)
+	for _, key := range keys {
+		if strings.HasPrefix(key, "en-") {
+			return reasons[key]
+		}
+	}
+	if len(keys) > 0 {
+		return reasons[keys[0]]
+	}
+	return ""
+}
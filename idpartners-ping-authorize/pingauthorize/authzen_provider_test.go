@@ -0,0 +1,219 @@
+package pingauthorize
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBuildAuthZenRequest(t *testing.T) {
+	conf := NewConfig()
+
+	t.Run("HTTP", func(t *testing.T) {
+		req := &SidebandAccessRequest{
+			SourceIP: "10.0.0.1",
+			Method:   "DELETE",
+			URL:      "https://api.example.com:443/orders/42?force=true",
+			Headers:  []map[string]string{{"x-consumer-username": "alice"}},
+		}
+		got := buildAuthZenRequest(conf, req)
+		if got.Subject.Type != "user" || got.Subject.ID != "alice" {
+			t.Errorf("unexpected subject: %+v", got.Subject)
+		}
+		if got.Resource.Type != "route" || got.Resource.ID != "/orders/42" || got.Resource.Properties["host"] != "api.example.com" {
+			t.Errorf("unexpected resource: %+v", got.Resource)
+		}
+		if got.Action.Name != "DELETE" || got.Context["source_ip"] != "10.0.0.1" {
+			t.Errorf("unexpected action/context: %+v %+v", got.Action, got.Context)
+		}
+	})
+
+	t.Run("token subject", func(t *testing.T) {
+		req := &SidebandAccessRequest{
+			Method:  "GET",
+			URL:     "https://api.example.com:443/orders",
+			Subject: &TokenSubject{Active: true, Scopes: []string{"orders:read"}, ClientID: "web"},
+		}
+		props := buildAuthZenRequest(conf, req).Subject.Properties
+		if props["active"] != true || props["client_id"] != "web" || len(props["scopes"].([]string)) != 1 {
+			t.Errorf("unexpected subject properties: %+v", props)
+		}
+	})
+
+	tests := []struct {
+		body         string
+		resourceType string
+		resourceID   string
+	}{
+		{`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"search","arguments":{"q":"x"}}}`, "mcp_tool", "search"},
+		{`{"jsonrpc":"2.0","id":1,"method":"resources/read","params":{"uri":"file:///etc/hosts"}}`, "mcp_resource", "file:///etc/hosts"},
+		{`{"jsonrpc":"2.0","id":1,"method":"resources/subscribe","params":{"uri":"file:///var/log/app"}}`, "mcp_resource", "file:///var/log/app"},
+		{`{"jsonrpc":"2.0","id":1,"method":"prompts/get","params":{"name":"summarize"}}`, "mcp_prompt", "summarize"},
+		{`{"jsonrpc":"2.0","id":1,"method":"tools/list"}`, "mcp_server", "tools/list"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.resourceType, func(t *testing.T) {
+			got := buildAuthZenRequest(conf, &SidebandAccessRequest{Method: "POST", URL: "http://api.example.com:80/mcp", Body: tt.body})
+			if got.Subject.ID != authZenAnonymousSubject {
+				t.Errorf("expected anonymous subject, got %q", got.Subject.ID)
+			}
+			if got.Resource.Type != tt.resourceType || got.Resource.ID != tt.resourceID {
+				t.Errorf("resource = %+v, want %s/%s", got.Resource, tt.resourceType, tt.resourceID)
+			}
+			var mcp jsonRPCRequest
+			json.Unmarshal([]byte(tt.body), &mcp)
+			if got.Action.Name != mcp.Method {
+				t.Errorf("action = %q, want %q", got.Action.Name, mcp.Method)
+			}
+		})
+	}
+}
+
+func TestAuthZenDecision(t *testing.T) {
+	req := &SidebandAccessRequest{
+		Method:  "GET",
+		URL:     "http://api.example.com:80/",
+		Body:    "",
+		Headers: []map[string]string{{"host": "api.example.com"}, {"x-tenant": "a"}},
+	}
+
+	t.Run("permit", func(t *testing.T) {
+		resp := authZenDecision(req, &AuthZenEvaluationResponse{
+			Decision: true,
+			Context:  &AuthZenResponseContext{Headers: map[string]string{"X-Tenant": "b", "X-Authz": "ok"}},
+		})
+		if resp.Response != nil {
+			t.Fatal("permit should not produce a deny response")
+		}
+		headers := FlattenHeaders(resp.Headers)
+		if headers["host"][0] != "api.example.com" || headers["x-tenant"][0] != "b" || headers["x-authz"][0] != "ok" {
+			t.Errorf("unexpected headers: %v", headers)
+		}
+		if resp.Method != req.Method || resp.URL != req.URL || resp.Body == nil || *resp.Body != "" {
+			t.Errorf("request should be forwarded unchanged: %+v", resp)
+		}
+	})
+
+	t.Run("deny", func(t *testing.T) {
+		resp := authZenDecision(req, &AuthZenEvaluationResponse{
+			Context: &AuthZenResponseContext{
+				ReasonUser: map[string]string{"es-403": "Privilegios insuficientes", "en-403": "Insufficient privileges"},
+				Headers:    map[string]string{"WWW-Authenticate": "Bearer"},
+			},
+		})
+		if resp.Response == nil || resp.Response.ResponseCode != "403" {
+			t.Fatalf("expected 403 deny, got %+v", resp.Response)
+		}
+		if !strings.Contains(resp.Response.Body, "Insufficient privileges") {
+			t.Errorf("expected English reason in body, got %s", resp.Response.Body)
+		}
+		if FlattenHeaders(resp.Response.Headers)["www-authenticate"][0] != "Bearer" {
+			t.Errorf("expected context headers on deny response, got %v", resp.Response.Headers)
+		}
+	})
+}
+
+func TestAuthZenProvider_EvaluateRequest(t *testing.T) {
+	var gotPath string
+	var got AuthZenEvaluationRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"decision":false}`))
+	}))
+	defer server.Close()
+
+	config := &Config{
+		ServiceURL:            server.URL,
+		SharedSecret:          "secret",
+		SecretHeaderName:      "Authorization",
+		ConnectionTimeoutMs:   5000,
+		ConnectionKeepaliveMs: 60000,
+		RetryBackoffMs:        100,
+		ProviderType:          ProviderAuthZen,
+		AuthZenEvaluationPath: "/pdp/access/v1/evaluation",
+		AuthZenSubjectHeader:  "X-User",
+		AuthZenSubjectType:    "account",
+	}
+	parsed, _ := ParseURL(server.URL)
+	provider, err := newProvider(config, NewSidebandHTTPClient(config), parsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := provider.EvaluateRequest(context.Background(), &SidebandAccessRequest{
+		Method:  "GET",
+		URL:     "http://api.example.com:80/reports",
+		Headers: []map[string]string{{"x-user": "bob"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotPath != "/pdp/access/v1/evaluation" {
+		t.Errorf("unexpected path %q", gotPath)
+	}
+	if got.Subject.Type != "account" || got.Subject.ID != "bob" || got.Resource.ID != "/reports" || got.Action.Name != "GET" {
+		t.Errorf("unexpected evaluation request: %+v", got)
+	}
+	if resp.Response == nil || resp.Response.ResponseCode != "403" {
+		t.Errorf("expected deny, got %+v", resp)
+	}
+}
+
+func TestMiddleware_AuthZenProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req AuthZenEvaluationRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Resource.Type == "mcp_tool" && req.Resource.ID == "shell" {
+			w.Write([]byte(`{"decision":false,"context":{"reason_user":{"en":"shell is not allowed"}}}`))
+			return
+		}
+		w.Write([]byte(`{"decision":true,"context":{"headers":{"x-user":"` + req.Subject.ID + `"}}}`))
+	}))
+	defer server.Close()
+
+	m, err := NewMiddleware(&Config{
+		ServiceURL:       server.URL,
+		SharedSecret:     "test-secret",
+		SecretHeaderName: "X-Secret",
+		ProviderType:     ProviderAuthZen,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	h := m.Handler(upstreamEcho(t, &calls))
+
+	r := httptest.NewRequest("POST", "http://api.example.com/mcp", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"search"}}`))
+	r.Header.Set("X-Consumer-Username", "alice")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	if rec.Code != 200 || rec.Header().Get("X-Upstream-User") != "alice" || !strings.Contains(rec.Body.String(), "search") {
+		t.Errorf("expected permitted request with context header, got %d %v %s", rec.Code, rec.Header(), rec.Body.String())
+	}
+
+	r = httptest.NewRequest("POST", "http://api.example.com/mcp", strings.NewReader(`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"shell"}}`))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	if rec.Code != 403 || !strings.Contains(rec.Body.String(), "shell is not allowed") || calls != 1 {
+		t.Errorf("expected 403 deny, got %d %s (%d upstream calls)", rec.Code, rec.Body.String(), calls)
+	}
+}
+
+func TestValidateAuthZenConfig(t *testing.T) {
+	conf := NewConfig()
+	conf.ServiceURL = "https://pdp.example.com"
+	conf.SharedSecret = "secret"
+	conf.SecretHeaderName = "Authorization"
+	conf.ProviderType = ProviderAuthZen
+	if err := conf.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conf.AuthZenEvaluationPath = "access/v1/evaluation"
+	if err := conf.Validate(); err == nil {
+		t.Error("expected error for relative authzen_evaluation_path")
+	}
+}
@@ -0,0 +1,85 @@
+package pingauthorize
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const defaultCircuitBreakerSyncIntervalMs = 1000
+
+// breakerState is an open circuit as stored in the shared breaker store.
+type breakerState struct {
+	trigger       CircuitBreakerTrigger
+	retryAfterSec int
+	openedAt      time.Time
+}
+
+// openUntil returns when the circuit closes again.
+func (s *breakerState) openUntil() time.Time {
+	return s.openedAt.Add(time.Duration(s.retryAfterSec) * time.Second)
+}
+
+// redisBreakerStore shares trip and close events of circuit breakers between Kong workers and
+// nodes through Redis. An open circuit is stored under its key until it closes; a missing key
+// means closed.
+type redisBreakerStore struct {
+	client       *redisClient
+	prefix       string
+	serviceURL   string
+	syncInterval time.Duration
+}
+
+// newBreakerStore returns the shared store selected by circuit_breaker_store, or nil when breakers
+// are local to the process.
+func newBreakerStore(config *Config) *redisBreakerStore {
+	if config.CircuitBreakerStore != storeRedis {
+		return nil
+	}
+	return &redisBreakerStore{
+		client:       newRedisClient(config),
+		prefix:       config.RedisKeyPrefix,
+		serviceURL:   strings.TrimRight(config.ServiceURL, "/"),
+		syncInterval: msOrDefault(config.CircuitBreakerSyncIntervalMs, defaultCircuitBreakerSyncIntervalMs),
+	}
+}
+
+// key returns the Redis key of the breaker for a sideband path.
+func (s *redisBreakerStore) key(path string) string {
+	return s.prefix + "breaker:" + s.serviceURL + path
+}
+
+// load returns the open circuit stored under key, or nil if the circuit is closed.
+func (s *redisBreakerStore) load(ctx context.Context, key string) (*breakerState, error) {
+	data, err := s.client.get(ctx, key)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	var state breakerState
+	var openedAtMs int64
+	if _, err := fmt.Sscan(string(data), &state.trigger, &state.retryAfterSec, &openedAtMs); err != nil {
+		return nil, fmt.Errorf("invalid circuit breaker state %q", data)
+	}
+	state.openedAt = time.UnixMilli(openedAtMs)
+	if !time.Now().Before(state.openUntil()) {
+		return nil, nil
+	}
+	return &state, nil
+}
+
+// save stores an open circuit under key until it closes.
+func (s *redisBreakerStore) save(ctx context.Context, key string, state *breakerState) error {
+	value := fmt.Sprintf("%d %d %d", state.trigger, state.retryAfterSec, state.openedAt.UnixMilli())
+	return s.client.set(ctx, key, []byte(value), time.Until(state.openUntil()))
+}
+
+// clear removes the open circuit stored under key.
+func (s *redisBreakerStore) clear(ctx context.Context, key string) error {
+	return s.client.del(ctx, key)
+}
+
+// warn logs a failed store operation. Breakers keep working locally when Redis is unavailable.
+func (s *redisBreakerStore) warn(op string, err error) {
+	NewPluginLogger(nil, "circuit_breaker", s.serviceURL).Warn("Failed to "+op+" shared circuit breaker state", "error", err.Error())
+}
@@ -0,0 +1,107 @@
+package pingauthorize
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func breakerStoreTestConfig(redisAddress string) *Config {
+	conf := NewConfig()
+	conf.ServiceURL = "https://paz.example.com/"
+	conf.CircuitBreakerStore = storeRedis
+	conf.CircuitBreakerSyncIntervalMs = 1
+	conf.RedisAddress = redisAddress
+	return conf
+}
+
+func TestCircuitBreakers_SharedThroughRedis(t *testing.T) {
+	f := newFakeRedis(t, "")
+	const key = "paz:breaker:https://paz.example.com/sideband/request"
+
+	// Two workers with their own breakers
+	workerA := newCircuitBreakers(breakerStoreTestConfig(f.addr())).get("/sideband/request")
+	workerB := newCircuitBreakers(breakerStoreTestConfig(f.addr())).get("/sideband/request")
+	if ok, _ := workerB.Allow(); !ok {
+		t.Fatal("expected a closed breaker")
+	}
+
+	workerA.Trip(Trigger429, 60)
+	f.mu.Lock()
+	stored, ttl := f.data[key], f.ttls[key]
+	f.mu.Unlock()
+	if !strings.HasPrefix(stored, "1 60 ") || ttl == "" {
+		t.Fatalf("unexpected stored state %q with ttl %q", stored, ttl)
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	ok, cbErr := workerB.Allow()
+	if ok || cbErr.Trigger != Trigger429 || cbErr.RetryAfterSec != 60 {
+		t.Fatalf("expected the trip to be visible to the other worker, got %v %+v", ok, cbErr)
+	}
+
+	workerA.Reset()
+	time.Sleep(2 * time.Millisecond)
+	if ok, _ := workerB.Allow(); !ok {
+		t.Error("expected the reset to be visible to the other worker")
+	}
+
+	// A state older than its retry-after is closed even if the key has not expired
+	f.mu.Lock()
+	f.data[key] = fmt.Sprintf("2 30 %d", time.Now().Add(-time.Minute).UnixMilli())
+	f.mu.Unlock()
+	time.Sleep(2 * time.Millisecond)
+	if ok, _ := workerB.Allow(); !ok {
+		t.Error("expected an expired state to be ignored")
+	}
+}
+
+func TestCircuitBreakers_RedisUnavailable(t *testing.T) {
+	f := newFakeRedis(t, "")
+	addr := f.addr()
+	f.ln.Close()
+
+	cb := newCircuitBreakers(breakerStoreTestConfig(addr)).get("/sideband/request")
+	if ok, _ := cb.Allow(); !ok {
+		t.Fatal("expected a closed breaker")
+	}
+	cb.Trip(Trigger5xx, 30)
+	time.Sleep(2 * time.Millisecond)
+	if ok, _ := cb.Allow(); ok {
+		t.Error("expected the breaker to stay open locally")
+	}
+}
+
+func TestValidateCircuitBreakerStore(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(c *Config)
+		wantErr string
+	}{
+		{"memory", func(c *Config) {}, ""},
+		{"redis", func(c *Config) { c.CircuitBreakerStore, c.RedisAddress = storeRedis, "redis:6379" }, ""},
+		{"unknown store", func(c *Config) { c.CircuitBreakerStore = "kong" }, "circuit_breaker_store"},
+		{"redis without address", func(c *Config) { c.CircuitBreakerStore = storeRedis }, "redis_address is required"},
+		{"negative sync interval", func(c *Config) { c.CircuitBreakerSyncIntervalMs = -1 }, "circuit_breaker_sync_interval_ms"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := NewConfig()
+			conf.ServiceURL = "https://paz.example.com"
+			conf.SharedSecret = "secret"
+			conf.SecretHeaderName = "X-Secret"
+			tt.modify(conf)
+			err := conf.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package pingauthorize
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// bypassRules holds the compiled bypass_paths and bypass_methods.
+type bypassRules struct {
+	paths   []*regexp.Regexp
+	methods map[string]bool
+}
+
+// compileBypassRules compiles bypass_paths and bypass_methods. Paths starting with "~" are regular
+// expressions, as in Kong routes; others are globs where "*" matches within a path segment and
+// "**" across segments. Returns nil when there are no rules.
+func compileBypassRules(paths, methods []string) (*bypassRules, error) {
+	if len(paths) == 0 && len(methods) == 0 {
+		return nil, nil
+	}
+	compiled, err := compilePathPatterns(paths)
+	if err != nil {
+		return nil, fmt.Errorf("bypass_paths: %w", err)
+	}
+	b := &bypassRules{paths: compiled, methods: make(map[string]bool, len(methods))}
+	for _, m := range methods {
+		if m == "" {
+			return nil, fmt.Errorf("bypass_methods must not contain empty methods")
+		}
+		b.methods[strings.ToUpper(m)] = true
+	}
+	return b, nil
+}
+
+// compilePathPatterns compiles path patterns: "~" regular expressions or globs.
+func compilePathPatterns(paths []string) ([]*regexp.Regexp, error) {
+	var compiled []*regexp.Regexp
+	for _, p := range paths {
+		var re *regexp.Regexp
+		var err error
+		if expr, ok := strings.CutPrefix(p, "~"); ok {
+			re, err = regexp.Compile(expr)
+		} else if p == "" {
+			err = fmt.Errorf("empty pattern")
+		} else {
+			re, err = regexp.Compile(globToRegexp(p))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", p, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// globToRegexp translates a bypass_paths glob into an anchored regular expression.
+func globToRegexp(glob string) string {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case c == '*' && i+1 < len(glob) && glob[i+1] == '*':
+			sb.WriteString(".*")
+			i++
+		case c == '*':
+			sb.WriteString("[^/]*")
+		case c == '?':
+			sb.WriteString("[^/]")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+	return sb.String()
+}
+
+// match reports whether a request with method and path bypasses evaluation. Safe to call on nil.
+func (b *bypassRules) match(method, path string) bool {
+	if b == nil {
+		return false
+	}
+	if b.methods[strings.ToUpper(method)] {
+		return true
+	}
+	for _, re := range b.paths {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// getBypassRules returns the compiled bypass rules of the config, or nil when there are none.
+// Invalid rules are rejected by Validate, so they are treated as none here.
+func (c *Config) getBypassRules() *bypassRules {
+	c.bypassOnce.Do(func() {
+		c.bypass, _ = compileBypassRules(c.BypassPaths, c.BypassMethods)
+	})
+	return c.bypass
+}
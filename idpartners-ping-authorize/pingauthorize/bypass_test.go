@@ -0,0 +1,104 @@
+package pingauthorize
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBypassRules(t *testing.T) {
+	rules, err := compileBypassRules([]string{"/health", "/static/**", "/v1/*/status", "~^/metrics(/.*)?$"}, []string{"options"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		want   bool
+	}{
+		{"GET", "/health", true},
+		{"GET", "/healthz", false},
+		{"GET", "/static/css/site.css", true},
+		{"GET", "/static", false},
+		{"GET", "/v1/orders/status", true},
+		{"GET", "/v1/orders/42/status", false},
+		{"GET", "/metrics", true},
+		{"GET", "/metrics/detail", true},
+		{"GET", "/api/metrics", false},
+		{"OPTIONS", "/api/orders", true},
+		{"POST", "/api/orders", false},
+	}
+	for _, tt := range tests {
+		if got := rules.match(tt.method, tt.path); got != tt.want {
+			t.Errorf("match(%s %s) = %v, want %v", tt.method, tt.path, got, tt.want)
+		}
+	}
+
+	if none, _ := compileBypassRules(nil, nil); none.match("OPTIONS", "/health") {
+		t.Error("no rules should bypass nothing")
+	}
+}
+
+func TestValidateBypassRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		paths   []string
+		methods []string
+		wantErr string
+	}{
+		{"valid", []string{"/health", "~^/v[0-9]+/ping$"}, []string{"OPTIONS", "head"}, ""},
+		{"invalid regex", []string{"~^/v[0-9+/ping$"}, nil, "bypass_paths"},
+		{"empty path", []string{""}, nil, "bypass_paths"},
+		{"empty method", nil, []string{""}, "bypass_methods"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := NewConfig()
+			conf.ServiceURL = "https://paz.example.com"
+			conf.SharedSecret = "secret"
+			conf.SecretHeaderName = "X-Secret"
+			conf.BypassPaths, conf.BypassMethods = tt.paths, tt.methods
+			err := conf.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMiddleware_Bypass(t *testing.T) {
+	sidebandCalls := 0
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		sidebandCalls++
+		w.WriteHeader(http.StatusForbidden)
+	})
+	defer server.Close()
+
+	m := newTestMiddleware(t, server.URL, true)
+	m.conf.BypassPaths = []string{"/health"}
+	m.conf.BypassMethods = []string{"OPTIONS"}
+	calls := 0
+	h := m.Handler(upstreamEcho(t, &calls))
+
+	for _, r := range []*http.Request{
+		httptest.NewRequest("GET", "http://api.example.com/health", nil),
+		httptest.NewRequest("OPTIONS", "http://api.example.com/orders", nil),
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		if rec.Code != 200 {
+			t.Errorf("%s %s: expected upstream response, got %d", r.Method, r.URL.Path, rec.Code)
+		}
+	}
+	if calls != 2 || sidebandCalls != 0 {
+		t.Errorf("expected 2 upstream and no sideband calls, got %d and %d", calls, sidebandCalls)
+	}
+}
@@ -1,10 +1,11 @@
-package main
+package pingauthorize
 
 import (
 	"crypto/ecdsa"
 	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/pem"
@@ -38,10 +39,33 @@ func ExtractClientCertJWK(pemChain string, includeFullChain bool) (*JWK, error)
 	} else {
 		jwk.X5C = []string{base64.StdEncoding.EncodeToString(leaf.Raw)}
 	}
+	addCertificateDetails(jwk, leaf)
 
 	return jwk, nil
 }
 
+// addCertificateDetails sets the thumbprint, names and validity window of cert on jwk.
+func addCertificateDetails(jwk *JWK, cert *x509.Certificate) {
+	thumbprint := sha256.Sum256(cert.Raw)
+	jwk.X5TS256 = base64.RawURLEncoding.EncodeToString(thumbprint[:])
+	jwk.Subject = cert.Subject.String()
+	jwk.Issuer = cert.Issuer.String()
+
+	san := &CertificateSANs{DNS: cert.DNSNames, Email: cert.EmailAddresses}
+	for _, ip := range cert.IPAddresses {
+		san.IP = append(san.IP, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		san.URI = append(san.URI, uri.String())
+	}
+	if len(san.DNS)+len(san.Email)+len(san.IP)+len(san.URI) > 0 {
+		jwk.SAN = san
+	}
+
+	notBefore, notAfter := cert.NotBefore.UTC(), cert.NotAfter.UTC()
+	jwk.NotBefore, jwk.NotAfter = &notBefore, &notAfter
+}
+
 // parsePEMCertificates parses all certificates from a PEM-encoded chain.
 func parsePEMCertificates(pemData string) ([]*x509.Certificate, error) {
 	var certs []*x509.Certificate
@@ -1,4 +1,4 @@
-package main
+package pingauthorize
 
 import (
 	"crypto/ecdsa"
@@ -6,11 +6,14 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/base64"
 	"encoding/pem"
 	"math/big"
+	"net"
+	"net/url"
 	"testing"
 	"time"
 )
@@ -182,6 +185,46 @@ func TestExtractClientCertJWK_LeafOnly(t *testing.T) {
 	}
 }
 
+func TestExtractClientCertJWK_CertificateDetails(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	spiffe, _ := url.Parse("spiffe://cluster.local/ns/web/sa/client")
+	notBefore := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(1),
+		Subject:        pkix.Name{CommonName: "client", Organization: []string{"Acme"}},
+		NotBefore:      notBefore,
+		NotAfter:       notBefore.AddDate(1, 0, 0),
+		DNSNames:       []string{"client.example.com"},
+		IPAddresses:    []net.IP{net.ParseIP("10.0.0.1")},
+		URIs:           []*url.URL{spiffe},
+		EmailAddresses: []string{"ops@example.com"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwk, err := ExtractClientCertJWK(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	thumbprint := sha256.Sum256(der)
+	if want := base64.RawURLEncoding.EncodeToString(thumbprint[:]); jwk.X5TS256 != want {
+		t.Errorf("x5t#S256 = %q, want %q", jwk.X5TS256, want)
+	}
+	if jwk.Subject != "CN=client,O=Acme" || jwk.Issuer != "CN=client,O=Acme" {
+		t.Errorf("subject/issuer = %q/%q", jwk.Subject, jwk.Issuer)
+	}
+	san := jwk.SAN
+	if san == nil || san.DNS[0] != "client.example.com" || san.IP[0] != "10.0.0.1" || san.URI[0] != spiffe.String() || san.Email[0] != "ops@example.com" {
+		t.Errorf("unexpected san: %+v", san)
+	}
+	if !jwk.NotBefore.Equal(notBefore) || !jwk.NotAfter.Equal(notBefore.AddDate(1, 0, 0)) {
+		t.Errorf("validity = %v - %v", jwk.NotBefore, jwk.NotAfter)
+	}
+}
+
 func TestExtractClientCertJWK_NoPEM(t *testing.T) {
 	_, err := ExtractClientCertJWK("not a pem", false)
 	if err == nil {
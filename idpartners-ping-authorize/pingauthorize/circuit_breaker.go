@@ -0,0 +1,382 @@
+package pingauthorize
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultRetryAfterSec = 30
+
+// defaultCircuitBreakerMaxOpenSec caps how long a Retry-After from PingAuthorize keeps the
+// circuit open when circuit_breaker_max_open_sec is not set.
+const defaultCircuitBreakerMaxOpenSec = 300
+
+// CircuitBreakerTrigger identifies what caused the circuit to open.
+type CircuitBreakerTrigger int
+
+const (
+	TriggerNone    CircuitBreakerTrigger = iota
+	Trigger429                           // Rate limited by PingAuthorize
+	Trigger5xx                           // Server error from PingAuthorize
+	TriggerTimeout                       // Connection/read/write timeout
+	TriggerManual                        // Opened through the admin API
+)
+
+// CircuitBreakerOpenError is returned when the circuit breaker is open and rejecting traffic.
+type CircuitBreakerOpenError struct {
+	Trigger       CircuitBreakerTrigger
+	RetryAfterSec int
+	RemainingMs   int64 // milliseconds until circuit closes
+}
+
+func (e *CircuitBreakerOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker open (trigger=%d), retry after %d seconds", e.Trigger, e.RetryAfterSec)
+}
+
+// Circuit breaker modes selectable with circuit_breaker_mode.
+const (
+	CircuitBreakerModeFirstFailure = "first_failure"
+	CircuitBreakerModeErrorRate    = "error_rate"
+)
+
+const (
+	defaultCircuitBreakerWindowSize  = 50
+	defaultCircuitBreakerFailureRate = 50
+	defaultCircuitBreakerMinCalls    = 20
+)
+
+// CircuitBreaker implements a per-instance circuit breaker with mutex protection.
+type CircuitBreaker struct {
+	mu            sync.Mutex
+	enabled       bool
+	closed        bool // true = circuit is closed (allowing traffic)
+	openedAt      time.Time
+	retryAfterSec int
+	triggerType   CircuitBreakerTrigger
+	trips         int64
+
+	// Error-rate mode; with a nil window, the first recorded failure trips the breaker
+	window      []bool // ring buffer of the latest outcomes, true = failure
+	windowNext  int
+	windowCalls int
+	failures    int
+	failureRate float64 // fraction of failed calls that trips the breaker
+	minCalls    int
+
+	// Shared state (circuit_breaker_store: redis); nil store keeps the breaker local
+	store      *redisBreakerStore
+	storeKey   string
+	lastSync   time.Time
+	sharedOpen bool // the open circuit is recorded in the store
+}
+
+// NewCircuitBreaker creates a new circuit breaker. Initial state is closed (traffic flows).
+func NewCircuitBreaker(enabled bool) *CircuitBreaker {
+	return &CircuitBreaker{
+		enabled: enabled,
+		closed:  true,
+	}
+}
+
+// circuitBreakers holds one circuit breaker per sideband path of an endpoint, so that failures
+// of /sideband/response do not block /sideband/request and vice versa. It holds no reference to
+// the Config, as it outlives the configs that share it in the endpoint registry.
+type circuitBreakers struct {
+	serviceURL string
+	template   *CircuitBreaker // settings of the breakers, never used itself
+	store      *redisBreakerStore
+
+	mu     sync.Mutex
+	byPath map[string]*CircuitBreaker
+}
+
+func newCircuitBreakers(config *Config) *circuitBreakers {
+	return &circuitBreakers{
+		serviceURL: strings.TrimRight(config.ServiceURL, "/"),
+		template:   newCircuitBreaker(config),
+		store:      newBreakerStore(config),
+		byPath:     make(map[string]*CircuitBreaker),
+	}
+}
+
+// get returns the breaker for path, creating it on first use.
+func (s *circuitBreakers) get(path string) *CircuitBreaker {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cb, ok := s.byPath[path]
+	if !ok {
+		cb = NewCircuitBreaker(s.template.enabled)
+		if s.template.window != nil {
+			cb = NewErrorRateCircuitBreaker(s.template.enabled, len(s.template.window), s.template.failureRate*100, s.template.minCalls)
+		}
+		if s.store != nil {
+			cb.store, cb.storeKey = s.store, s.store.key(path)
+		}
+		s.byPath[path] = cb
+	}
+	return cb
+}
+
+// paths returns the sideband paths that have a breaker, sorted.
+func (s *circuitBreakers) paths() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	paths := make([]string, 0, len(s.byPath))
+	for path := range s.byPath {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// forURL returns the breaker for the path of requestURL.
+func (s *circuitBreakers) forURL(requestURL string) *CircuitBreaker {
+	return s.get(urlPath(requestURL))
+}
+
+// urlPath returns the path of requestURL, or requestURL itself if it cannot be parsed.
+func urlPath(requestURL string) string {
+	if u, err := url.Parse(requestURL); err == nil {
+		return u.Path
+	}
+	return requestURL
+}
+
+// NewErrorRateCircuitBreaker creates a circuit breaker that trips when more than failurePercent
+// percent of the last windowSize calls failed, once at least minCalls calls have been recorded.
+func NewErrorRateCircuitBreaker(enabled bool, windowSize int, failurePercent float64, minCalls int) *CircuitBreaker {
+	cb := NewCircuitBreaker(enabled)
+	cb.window = make([]bool, windowSize)
+	cb.failureRate = failurePercent / 100
+	cb.minCalls = minCalls
+	return cb
+}
+
+// newCircuitBreaker creates the circuit breaker selected by the circuit_breaker_* settings.
+func newCircuitBreaker(config *Config) *CircuitBreaker {
+	if config.CircuitBreakerMode != CircuitBreakerModeErrorRate {
+		return NewCircuitBreaker(config.CircuitBreakerEnabled)
+	}
+	windowSize, failureRate, minCalls := config.CircuitBreakerWindowSize, config.CircuitBreakerFailureRate, config.CircuitBreakerMinCalls
+	if windowSize <= 0 {
+		windowSize = defaultCircuitBreakerWindowSize
+	}
+	if failureRate <= 0 {
+		failureRate = defaultCircuitBreakerFailureRate
+	}
+	if minCalls <= 0 {
+		minCalls = defaultCircuitBreakerMinCalls
+	}
+	return NewErrorRateCircuitBreaker(config.CircuitBreakerEnabled, windowSize, failureRate, minCalls)
+}
+
+// Allow checks if a request can proceed. Returns true if allowed.
+// If the circuit is open but the retry timer has expired, it transitions to closed.
+func (cb *CircuitBreaker) Allow() (bool, *CircuitBreakerOpenError) {
+	if !cb.enabled {
+		return true, nil
+	}
+	cb.sync()
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.closed {
+		return true, nil
+	}
+
+	// Check if retry timer has expired
+	elapsed := time.Since(cb.openedAt)
+	retryDuration := time.Duration(cb.retryAfterSec) * time.Second
+	if elapsed >= retryDuration {
+		cb.closed = true
+		cb.triggerType = TriggerNone
+		cb.resetWindow()
+		cb.sharedOpen = false
+		return true, nil
+	}
+
+	remaining := retryDuration - elapsed
+	return false, &CircuitBreakerOpenError{
+		Trigger:       cb.triggerType,
+		RetryAfterSec: cb.retryAfterSec,
+		RemainingMs:   remaining.Milliseconds(),
+	}
+}
+
+// Trip opens the circuit breaker with the given trigger and retry-after duration.
+func (cb *CircuitBreaker) Trip(trigger CircuitBreakerTrigger, retryAfterSec int) {
+	if !cb.enabled {
+		return
+	}
+
+	cb.mu.Lock()
+	cb.trips++
+	cb.closed = false
+	cb.openedAt = time.Now()
+	cb.triggerType = trigger
+	if retryAfterSec > 0 {
+		cb.retryAfterSec = retryAfterSec
+	} else {
+		cb.retryAfterSec = defaultRetryAfterSec
+	}
+	state := &breakerState{trigger: cb.triggerType, retryAfterSec: cb.retryAfterSec, openedAt: cb.openedAt}
+	cb.mu.Unlock()
+
+	if cb.store == nil {
+		return
+	}
+	err := cb.store.save(context.Background(), cb.storeKey, state)
+	if err != nil {
+		cb.store.warn("store", err)
+	}
+	cb.mu.Lock()
+	cb.sharedOpen = err == nil && cb.openedAt.Equal(state.openedAt)
+	cb.mu.Unlock()
+}
+
+// sync applies trip and close events of other workers from the shared store, at most once per
+// circuit_breaker_sync_interval_ms. A circuit opened elsewhere opens this breaker until the same
+// time; one that was closed elsewhere closes it, unless it was opened here and could not be
+// stored. Store errors leave the local state unchanged.
+func (cb *CircuitBreaker) sync() {
+	if cb.store == nil {
+		return
+	}
+	cb.mu.Lock()
+	now := time.Now()
+	due := now.Sub(cb.lastSync) >= cb.store.syncInterval
+	if due {
+		cb.lastSync = now
+	}
+	cb.mu.Unlock()
+	if !due {
+		return
+	}
+
+	state, err := cb.store.load(context.Background(), cb.storeKey)
+	if err != nil {
+		cb.store.warn("load", err)
+		return
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	switch {
+	case state != nil:
+		cb.closed = false
+		cb.openedAt, cb.retryAfterSec, cb.triggerType = state.openedAt, state.retryAfterSec, state.trigger
+		cb.sharedOpen = true
+	case !cb.closed && cb.sharedOpen:
+		cb.closed = true
+		cb.triggerType = TriggerNone
+		cb.resetWindow()
+		cb.sharedOpen = false
+	}
+}
+
+// RecordSuccess records a call that PingAuthorize answered. It only matters in error-rate mode.
+func (cb *CircuitBreaker) RecordSuccess() {
+	if !cb.enabled || cb.window == nil {
+		return
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.record(false)
+}
+
+// RecordFailure records a call that failed after retries. In first-failure mode it trips the
+// breaker; in error-rate mode it trips it once the failure rate of the window is exceeded.
+func (cb *CircuitBreaker) RecordFailure(trigger CircuitBreakerTrigger, retryAfterSec int) {
+	if !cb.enabled {
+		return
+	}
+	if cb.window == nil {
+		cb.Trip(trigger, retryAfterSec)
+		return
+	}
+
+	cb.mu.Lock()
+	cb.record(true)
+	trip := cb.closed && cb.windowCalls >= cb.minCalls &&
+		float64(cb.failures) > cb.failureRate*float64(cb.windowCalls)
+	cb.mu.Unlock()
+
+	if trip {
+		cb.Trip(trigger, retryAfterSec)
+	}
+}
+
+// record adds an outcome to the window, dropping the oldest once it is full. Callers hold cb.mu.
+func (cb *CircuitBreaker) record(failed bool) {
+	if cb.windowCalls == len(cb.window) {
+		if cb.window[cb.windowNext] {
+			cb.failures--
+		}
+	} else {
+		cb.windowCalls++
+	}
+	cb.window[cb.windowNext] = failed
+	if failed {
+		cb.failures++
+	}
+	cb.windowNext = (cb.windowNext + 1) % len(cb.window)
+}
+
+// resetWindow forgets the recorded outcomes, so a breaker that closes again starts from a clean
+// window. Callers hold cb.mu.
+func (cb *CircuitBreaker) resetWindow() {
+	cb.windowNext, cb.windowCalls, cb.failures = 0, 0, 0
+}
+
+// Reset closes the circuit breaker (allows traffic again).
+func (cb *CircuitBreaker) Reset() {
+	if !cb.enabled {
+		return
+	}
+
+	cb.mu.Lock()
+	cb.closed = true
+	cb.triggerType = TriggerNone
+	cb.resetWindow()
+	cb.sharedOpen = false
+	cb.mu.Unlock()
+
+	if cb.store != nil {
+		if err := cb.store.clear(context.Background(), cb.storeKey); err != nil {
+			cb.store.warn("clear", err)
+		}
+	}
+}
+
+// status returns the current state of the breaker, after applying events from the shared store.
+func (cb *CircuitBreaker) status() BreakerStatus {
+	if cb.enabled {
+		cb.sync()
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	st := BreakerStatus{Enabled: cb.enabled, State: "closed", TripCount: cb.trips}
+	if cb.closed {
+		return st
+	}
+	remaining := time.Until(cb.openedAt.Add(time.Duration(cb.retryAfterSec) * time.Second))
+	if remaining > 0 {
+		st.State, st.Trigger, st.RemainingMs = "open", triggerNames[cb.triggerType], remaining.Milliseconds()
+	}
+	return st
+}
+
+// IsClosed returns true if the circuit is closed (allowing traffic).
+func (cb *CircuitBreaker) IsClosed() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.closed
+}
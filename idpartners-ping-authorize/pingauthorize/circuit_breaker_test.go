@@ -0,0 +1,285 @@
+package pingauthorize
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker_InitialState(t *testing.T) {
+	cb := NewCircuitBreaker(true)
+	ok, err := cb.Allow()
+	if !ok || err != nil {
+		t.Fatal("expected circuit to be closed initially")
+	}
+}
+
+func TestCircuitBreaker_TripAndReject(t *testing.T) {
+	cb := NewCircuitBreaker(true)
+	cb.Trip(Trigger429, 10)
+
+	ok, err := cb.Allow()
+	if ok || err == nil {
+		t.Fatal("expected circuit to be open after trip")
+	}
+	if err.Trigger != Trigger429 {
+		t.Errorf("expected trigger 429, got %d", err.Trigger)
+	}
+	if err.RetryAfterSec != 10 {
+		t.Errorf("expected retry after 10s, got %d", err.RetryAfterSec)
+	}
+}
+
+func TestCircuitBreaker_Trip5xx(t *testing.T) {
+	cb := NewCircuitBreaker(true)
+	cb.Trip(Trigger5xx, 30)
+
+	ok, err := cb.Allow()
+	if ok || err == nil {
+		t.Fatal("expected circuit to be open")
+	}
+	if err.Trigger != Trigger5xx {
+		t.Errorf("expected trigger 5xx, got %d", err.Trigger)
+	}
+}
+
+func TestCircuitBreaker_TripTimeout(t *testing.T) {
+	cb := NewCircuitBreaker(true)
+	cb.Trip(TriggerTimeout, 30)
+
+	ok, err := cb.Allow()
+	if ok || err == nil {
+		t.Fatal("expected circuit to be open")
+	}
+	if err.Trigger != TriggerTimeout {
+		t.Errorf("expected trigger timeout, got %d", err.Trigger)
+	}
+}
+
+func TestCircuitBreaker_TimerExpiry(t *testing.T) {
+	cb := NewCircuitBreaker(true)
+
+	// Trip with a very short timer
+	cb.mu.Lock()
+	cb.closed = false
+	cb.openedAt = time.Now().Add(-2 * time.Second)
+	cb.retryAfterSec = 1
+	cb.triggerType = Trigger429
+	cb.mu.Unlock()
+
+	ok, err := cb.Allow()
+	if !ok || err != nil {
+		t.Fatal("expected circuit to auto-close after timer expiry")
+	}
+
+	// Should be closed now
+	if !cb.IsClosed() {
+		t.Fatal("expected circuit to be closed")
+	}
+}
+
+func TestCircuitBreaker_Reset(t *testing.T) {
+	cb := NewCircuitBreaker(true)
+	cb.Trip(Trigger5xx, 30)
+
+	ok, _ := cb.Allow()
+	if ok {
+		t.Fatal("expected circuit to be open")
+	}
+
+	cb.Reset()
+
+	ok, err := cb.Allow()
+	if !ok || err != nil {
+		t.Fatal("expected circuit to be closed after reset")
+	}
+}
+
+func TestCircuitBreaker_Disabled(t *testing.T) {
+	cb := NewCircuitBreaker(false)
+	cb.Trip(Trigger429, 30)
+
+	ok, err := cb.Allow()
+	if !ok || err != nil {
+		t.Fatal("expected disabled circuit breaker to always allow")
+	}
+}
+
+func TestCircuitBreaker_DefaultRetryAfter(t *testing.T) {
+	cb := NewCircuitBreaker(true)
+	cb.Trip(Trigger5xx, 0)
+
+	ok, err := cb.Allow()
+	if ok {
+		t.Fatal("expected circuit to be open")
+	}
+	if err.RetryAfterSec != defaultRetryAfterSec {
+		t.Errorf("expected default retry after %d, got %d", defaultRetryAfterSec, err.RetryAfterSec)
+	}
+}
+
+func TestCircuitBreaker_ConcurrentAccess(t *testing.T) {
+	cb := NewCircuitBreaker(true)
+
+	var wg sync.WaitGroup
+	// Run concurrent trips and allows
+	for i := 0; i < 100; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			cb.Trip(Trigger429, 5)
+		}()
+		go func() {
+			defer wg.Done()
+			cb.Allow()
+		}()
+	}
+	wg.Wait()
+
+	// Should not panic; state should be consistent
+	cb.Reset()
+	ok, _ := cb.Allow()
+	if !ok {
+		t.Fatal("expected circuit to be closed after reset")
+	}
+}
+
+func TestCircuitBreaker_FirstFailureMode(t *testing.T) {
+	cb := newCircuitBreaker(NewConfig())
+	cb.RecordSuccess()
+	cb.RecordFailure(Trigger5xx, 30)
+	if cb.IsClosed() {
+		t.Error("expected the first failure to trip the breaker")
+	}
+}
+
+func TestCircuitBreaker_ErrorRateMode(t *testing.T) {
+	cb := NewErrorRateCircuitBreaker(true, 10, 50, 4)
+
+	// Below the minimum volume nothing trips
+	for i := 0; i < 3; i++ {
+		cb.RecordFailure(Trigger5xx, 30)
+	}
+	if !cb.IsClosed() {
+		t.Fatal("expected no trip below circuit_breaker_min_calls")
+	}
+
+	// 3 failures of 5 calls is over 50%
+	cb.Reset()
+	for _, failed := range []bool{false, true, false, true, true} {
+		if failed {
+			cb.RecordFailure(TriggerTimeout, 30)
+		} else {
+			cb.RecordSuccess()
+		}
+	}
+	ok, err := cb.Allow()
+	if ok || err.Trigger != TriggerTimeout {
+		t.Fatalf("expected the breaker to open on the error rate, got %v", err)
+	}
+
+	// The oldest outcomes leave the window
+	cb.Reset()
+	for i := 0; i < 10; i++ {
+		cb.RecordSuccess()
+	}
+	for i := 0; i < 5; i++ {
+		cb.RecordFailure(Trigger5xx, 30)
+	}
+	if !cb.IsClosed() {
+		t.Error("expected exactly 50% failures not to trip the breaker")
+	}
+	cb.RecordFailure(Trigger5xx, 30)
+	if cb.IsClosed() {
+		t.Error("expected 6 failures in the last 10 calls to trip the breaker")
+	}
+}
+
+func TestCircuitBreaker_ErrorRateWindowResetsOnClose(t *testing.T) {
+	cb := NewErrorRateCircuitBreaker(true, 10, 50, 2)
+	cb.RecordFailure(Trigger5xx, 1)
+	cb.RecordFailure(Trigger5xx, 1)
+	if cb.IsClosed() {
+		t.Fatal("expected the breaker to open")
+	}
+	cb.mu.Lock()
+	cb.openedAt = time.Now().Add(-2 * time.Second)
+	cb.mu.Unlock()
+	if ok, _ := cb.Allow(); !ok {
+		t.Fatal("expected the breaker to close after the retry timer")
+	}
+	cb.RecordFailure(Trigger5xx, 1)
+	if !cb.IsClosed() {
+		t.Error("expected a clean window after closing")
+	}
+}
+
+func TestValidateCircuitBreakerMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(c *Config)
+		wantErr string
+	}{
+		{"error rate", func(c *Config) { c.CircuitBreakerMode = CircuitBreakerModeErrorRate }, ""},
+		{"unknown mode", func(c *Config) { c.CircuitBreakerMode = "sliding" }, "circuit_breaker_mode"},
+		{"rate 100", func(c *Config) { c.CircuitBreakerFailureRate = 100 }, "circuit_breaker_failure_rate"},
+		{"min calls above window", func(c *Config) { c.CircuitBreakerMinCalls = 100 }, "must not exceed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := NewConfig()
+			conf.ServiceURL = "https://paz.example.com"
+			conf.SharedSecret = "secret"
+			conf.SecretHeaderName = "X-Secret"
+			tt.modify(conf)
+			err := conf.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakers_PerSidebandPath(t *testing.T) {
+	var failResponse atomic.Bool
+	failResponse.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/sideband/response" && failResponse.Load() {
+			w.WriteHeader(503)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	conf := NewConfig()
+	conf.ServiceURL = server.URL
+	conf.SharedSecret = "secret"
+	conf.SecretHeaderName = "X-Secret"
+	conf.RetryBackoffMs = 1
+	client := NewSidebandHTTPClient(conf)
+	parsed, _ := ParseURL(server.URL)
+	requestURL, responseURL := BuildSidebandURL(parsed, "/sideband/request"), BuildSidebandURL(parsed, "/sideband/response")
+
+	client.Execute(context.Background(), responseURL, []byte(`{}`), parsed)
+	if _, _, _, err := client.Execute(context.Background(), responseURL, []byte(`{}`), parsed); err == nil {
+		t.Fatal("expected the response path breaker to be open")
+	}
+	if status, _, _, err := client.Execute(context.Background(), requestURL, []byte(`{}`), parsed); err != nil || status != 200 {
+		t.Errorf("expected the request path to be unaffected, got %d %v", status, err)
+	}
+	if client.cb.forURL(requestURL+"?x=1") != client.cb.get("/sideband/request") {
+		t.Error("expected breakers to be keyed by path")
+	}
+}
@@ -0,0 +1,106 @@
+package pingauthorize
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// isInlinePEM reports whether a sideband_client_cert or sideband_client_key value is PEM rather
+// than a file path.
+func isInlinePEM(value string) bool {
+	return strings.Contains(value, "-----BEGIN")
+}
+
+// validateSidebandClientCert checks that sideband_client_cert and sideband_client_key are set
+// together and that inline PEM parses. Files are read when the first connection is made, since
+// they may only exist on the data plane.
+func validateSidebandClientCert(c *Config) error {
+	if (c.SidebandClientCert == "") != (c.SidebandClientKey == "") {
+		return fmt.Errorf("sideband_client_cert and sideband_client_key must be set together")
+	}
+	if isInlinePEM(c.SidebandClientCert) && isInlinePEM(c.SidebandClientKey) {
+		if _, err := tls.X509KeyPair([]byte(c.SidebandClientCert), []byte(c.SidebandClientKey)); err != nil {
+			return fmt.Errorf("sideband_client_cert/sideband_client_key: %w", err)
+		}
+	}
+	return nil
+}
+
+// clientCertLoader supplies the sideband client certificate for TLS handshakes. Certificates read
+// from files are reloaded when either file changes, so rotated certificates are picked up without
+// restarting Kong.
+type clientCertLoader struct {
+	cert       string
+	key        string
+	serviceURL string
+
+	mu       sync.Mutex
+	loaded   *tls.Certificate
+	certMod  time.Time
+	keyMod   time.Time
+	loggedAt time.Time
+}
+
+func newClientCertLoader(config *Config) *clientCertLoader {
+	return &clientCertLoader{cert: config.SidebandClientCert, key: config.SidebandClientKey, serviceURL: config.ServiceURL}
+}
+
+// GetClientCertificate implements tls.Config.GetClientCertificate.
+func (l *clientCertLoader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	cert, err := l.load()
+	if err != nil {
+		// Handshakes are retried on every request while the files are broken; log once a minute
+		if time.Since(l.loggedAt) > time.Minute {
+			l.loggedAt = time.Now()
+			NewPluginLogger(nil, "tls", l.serviceURL).Err("Failed to load sideband client certificate", "error", err.Error())
+		}
+		return nil, err
+	}
+	return cert, nil
+}
+
+// load returns the cached certificate, reading the PEM again if a file changed.
+func (l *clientCertLoader) load() (*tls.Certificate, error) {
+	certPEM, certMod, err := readPEMSource(l.cert)
+	if err != nil {
+		return nil, fmt.Errorf("sideband_client_cert: %w", err)
+	}
+	keyPEM, keyMod, err := readPEMSource(l.key)
+	if err != nil {
+		return nil, fmt.Errorf("sideband_client_key: %w", err)
+	}
+	if l.loaded != nil && certMod.Equal(l.certMod) && keyMod.Equal(l.keyMod) {
+		return l.loaded, nil
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("sideband_client_cert/sideband_client_key: %w", err)
+	}
+	l.loaded, l.certMod, l.keyMod = &cert, certMod, keyMod
+	return l.loaded, nil
+}
+
+// readPEMSource returns inline PEM as is, or reads the file it names along with its
+// modification time.
+func readPEMSource(value string) ([]byte, time.Time, error) {
+	if isInlinePEM(value) {
+		return []byte(value), time.Time{}, nil
+	}
+	info, err := os.Stat(value)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	data, err := os.ReadFile(value)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return data, info.ModTime(), nil
+}
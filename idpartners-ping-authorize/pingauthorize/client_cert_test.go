@@ -0,0 +1,176 @@
+package pingauthorize
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestClientCert returns a self-signed certificate and key as PEM.
+func newTestClientCert(t *testing.T, cn string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return string(certPEM), string(keyPEM)
+}
+
+func leafCN(t *testing.T, cert *tls.Certificate) string {
+	t.Helper()
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestValidateSidebandClientCert(t *testing.T) {
+	certPEM, keyPEM := newTestClientCert(t, "kong")
+	_, otherKey := newTestClientCert(t, "other")
+
+	tests := []struct {
+		name    string
+		cert    string
+		key     string
+		wantErr string
+	}{
+		{"unset", "", "", ""},
+		{"inline", certPEM, keyPEM, ""},
+		{"files", "/etc/kong/client.crt", "/etc/kong/client.key", ""},
+		{"cert only", certPEM, "", "must be set together"},
+		{"key only", "", keyPEM, "must be set together"},
+		{"mismatched key", certPEM, otherKey, "sideband_client_cert/sideband_client_key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := NewConfig()
+			conf.ServiceURL = "https://paz.example.com"
+			conf.SharedSecret = "secret"
+			conf.SecretHeaderName = "Authorization"
+			conf.SidebandClientCert, conf.SidebandClientKey = tt.cert, tt.key
+			err := conf.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestClientCertLoader_Reload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	write := func(cn string, mod time.Time) {
+		certPEM, keyPEM := newTestClientCert(t, cn)
+		for file, data := range map[string]string{certFile: certPEM, keyFile: keyPEM} {
+			if err := os.WriteFile(file, []byte(data), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			os.Chtimes(file, mod, mod)
+		}
+	}
+
+	conf := NewConfig()
+	conf.SidebandClientCert, conf.SidebandClientKey = certFile, keyFile
+	l := newClientCertLoader(conf)
+
+	if _, err := l.GetClientCertificate(nil); err == nil {
+		t.Fatal("expected an error for missing files")
+	}
+
+	write("first", time.Unix(1000, 0))
+	cert, err := l.GetClientCertificate(nil)
+	if err != nil || leafCN(t, cert) != "first" {
+		t.Fatalf("unexpected certificate: %v", err)
+	}
+	if again, _ := l.GetClientCertificate(nil); again != cert {
+		t.Error("expected the unchanged certificate to be reused")
+	}
+
+	write("rotated", time.Unix(2000, 0))
+	if cert, err := l.GetClientCertificate(nil); err != nil || leafCN(t, cert) != "rotated" {
+		t.Errorf("expected the rotated certificate, got %v", err)
+	}
+}
+
+func TestSidebandClient_MutualTLS(t *testing.T) {
+	certPEM, keyPEM := newTestClientCert(t, "kong")
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM([]byte(certPEM))
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool}
+	server.StartTLS()
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		cert    string
+		key     string
+		wantErr bool
+	}{
+		{"with client certificate", certPEM, keyPEM, false},
+		{"without client certificate", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := NewConfig()
+			conf.ServiceURL = server.URL
+			conf.VerifyServiceCert = false
+			conf.SidebandClientCert, conf.SidebandClientKey = tt.cert, tt.key
+			client := &http.Client{Transport: newSidebandTransport(conf)}
+			resp, err := client.Get(server.URL)
+			if tt.wantErr {
+				if err == nil {
+					resp.Body.Close()
+					t.Error("expected the handshake to fail")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != 200 {
+				t.Errorf("expected 200, got %d", resp.StatusCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,760 @@
+package pingauthorize
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// Config holds the plugin configuration. Kong creates one instance per plugin configuration.
+type Config struct {
+	// Required fields
+	ServiceURL       string `json:"service_url"`
+	SharedSecret     string `json:"shared_secret"`
+	SecretHeaderName string `json:"secret_header_name"`
+
+	// Shared secret rotation
+	SharedSecretSecondary string `json:"shared_secret_secondary"`
+
+	// Policy provider
+	ProviderType    string            `json:"provider_type"`
+	ProviderOptions map[string]string `json:"provider_options"`
+
+	// AuthZEN provider (provider_type: authzen)
+	AuthZenEvaluationPath string `json:"authzen_evaluation_path"`
+	AuthZenSubjectHeader  string `json:"authzen_subject_header"`
+	AuthZenSubjectType    string `json:"authzen_subject_type"`
+
+	// OPA provider (provider_type: opa)
+	OPAPackage string `json:"opa_package"`
+
+	// Fallback provider
+	FallbackProvider     string `json:"fallback_provider"`
+	FallbackServiceURL   string `json:"fallback_service_url"`
+	FallbackSharedSecret string `json:"fallback_shared_secret"`
+
+	// Local fallback policy
+	FallbackRules []FallbackRule `json:"fallback_rules"`
+
+	// Decision cache
+	DecisionCacheTTLMs        int      `json:"decision_cache_ttl_ms"`
+	DecisionCacheKey          []string `json:"decision_cache_key"`
+	DecisionCacheKeyHeaders   []string `json:"decision_cache_key_headers"`
+	DecisionCacheMaxEntries   int      `json:"decision_cache_max_entries"`
+	DecisionCacheBypassHeader string   `json:"decision_cache_bypass_header"`
+	DecisionCacheStore        string   `json:"decision_cache_store"`
+
+	// Response phase state (Kong only)
+	StateStore       string `json:"state_store"`
+	StateStoreSecret string `json:"state_store_secret"`
+	MaxStateBytes    int    `json:"max_state_bytes"`
+
+	// Redis (decision_cache_store, state_store or circuit_breaker_store: redis)
+	RedisAddress    string `json:"redis_address"`
+	RedisUsername   string `json:"redis_username"`
+	RedisPassword   string `json:"redis_password"`
+	RedisDatabase   int    `json:"redis_database"`
+	RedisTLS        bool   `json:"redis_tls"`
+	RedisTLSVerify  bool   `json:"redis_tls_verify"`
+	RedisKeyPrefix  string `json:"redis_key_prefix"`
+	RedisTimeoutMs  int    `json:"redis_timeout_ms"`
+	RedisStateTTLMs int    `json:"redis_state_ttl_ms"`
+
+	// Timeouts and connection
+	ConnectionTimeoutMs   int  `json:"connection_timeout_ms"`
+	ConnectionKeepaliveMs int  `json:"connection_keepalive_ms"`
+	AccessTimeoutMs       int  `json:"access_timeout_ms"`
+	ResponseTimeoutMs     int  `json:"response_timeout_ms"`
+	RequestBudgetMs       int  `json:"request_budget_ms"`
+	VerifyServiceCert     bool `json:"verify_service_cert"`
+	SidebandHTTP2         bool `json:"sideband_http2"`
+
+	// Sideband OAuth2 client credentials
+	SidebandOAuthTokenURL     string   `json:"sideband_oauth_token_url"`
+	SidebandOAuthClientID     string   `json:"sideband_oauth_client_id"`
+	SidebandOAuthClientSecret string   `json:"sideband_oauth_client_secret"`
+	SidebandOAuthScopes       []string `json:"sideband_oauth_scopes"`
+
+	// Sideband mutual TLS (file path or inline PEM)
+	SidebandClientCert string `json:"sideband_client_cert"`
+	SidebandClientKey  string `json:"sideband_client_key"`
+
+	// Phase control
+	SkipResponsePhase        bool     `json:"skip_response_phase"`
+	ResponsePhaseStatusCodes []string `json:"response_phase_status_codes"`
+	ResponsePhaseMCPOnly     bool     `json:"response_phase_mcp_only"`
+	SSEEventFiltering        bool     `json:"sse_event_filtering"`
+	ShadowMode               bool     `json:"shadow_mode"`
+
+	// MCP over WebSocket (net/http middleware only)
+	MCPWebSocket                  bool    `json:"mcp_websocket"`
+	WebSocketMessageSamplePercent float64 `json:"websocket_message_sample_percent"`
+
+	// Error handling
+	FailOpen               bool   `json:"fail_open"`
+	FailOpenHeader         string `json:"fail_open_header"`
+	FailOpenResponseHeader string `json:"fail_open_response_header"`
+	PassthroughStatusCodes []int  `json:"passthrough_status_codes"`
+
+	// Deny responses
+	DenyBodyTemplate       string            `json:"deny_body_template"`
+	DenyHeaders            map[string]string `json:"deny_headers"`
+	DenyContentNegotiation bool              `json:"deny_content_negotiation"`
+
+	// Retry
+	MaxRetries         int     `json:"max_retries"`
+	RetryBackoffMs     int     `json:"retry_backoff_ms"`
+	HedgeDelayMs       int     `json:"hedge_delay_ms"`
+	RetryBudgetPercent float64 `json:"retry_budget_percent"`
+
+	// Circuit breaker
+	CircuitBreakerEnabled     bool    `json:"circuit_breaker_enabled"`
+	CircuitBreakerMaxOpenSec  int     `json:"circuit_breaker_max_open_sec"`
+	CircuitBreakerMode        string  `json:"circuit_breaker_mode"`
+	CircuitBreakerWindowSize  int     `json:"circuit_breaker_window_size"`
+	CircuitBreakerFailureRate float64 `json:"circuit_breaker_failure_rate"`
+	CircuitBreakerMinCalls    int     `json:"circuit_breaker_min_calls"`
+
+	// Shared circuit breaker state (circuit_breaker_store: redis)
+	CircuitBreakerStore          string `json:"circuit_breaker_store"`
+	CircuitBreakerSyncIntervalMs int    `json:"circuit_breaker_sync_interval_ms"`
+
+	// Active health checks
+	HealthCheckPath               string `json:"health_check_path"`
+	HealthCheckIntervalMs         int    `json:"health_check_interval_ms"`
+	HealthCheckTimeoutMs          int    `json:"health_check_timeout_ms"`
+	HealthCheckUnhealthyThreshold int    `json:"health_check_unhealthy_threshold"`
+	HealthCheckHealthyThreshold   int    `json:"health_check_healthy_threshold"`
+
+	// SLO tracking
+	SLOEnabled       bool    `json:"slo_enabled"`
+	SLOTarget        float64 `json:"slo_target"`
+	SLOLatencyMs     int     `json:"slo_latency_ms"`
+	SLOWindowSeconds int     `json:"slo_window_seconds"`
+	SLOMinCalls      int     `json:"slo_min_calls"`
+	SLOWebhookURL    string  `json:"slo_webhook_url"`
+
+	// Client-side throttling
+	SidebandRateLimit         float64 `json:"sideband_rate_limit"`
+	SidebandRateBurst         int     `json:"sideband_rate_burst"`
+	SidebandRateLimitQueueMs  int     `json:"sideband_rate_limit_queue_ms"`
+	SidebandRateLimitFailOpen bool    `json:"sideband_rate_limit_fail_open"`
+
+	// Request modification
+	StripAcceptEncoding bool `json:"strip_accept_encoding"`
+
+	// Body decompression
+	DecompressRequestBody    bool `json:"decompress_request_body"`
+	DecompressResponseBody   bool `json:"decompress_response_body"`
+	MaxDecompressedBodyBytes int  `json:"max_decompressed_body_bytes"`
+	RecompressResponseBody   bool `json:"recompress_response_body"`
+
+	// Body sampling
+	BodySamplingEnabled bool     `json:"body_sampling_enabled"`
+	BodySamplePercent   float64  `json:"body_sample_percent"`
+	BodySampleConsumers []string `json:"body_sample_consumers"`
+	BodySampleTools     []string `json:"body_sample_tools"`
+	BodyContentTypes    []string `json:"body_content_types"`
+	SkipBodyMethods     []string `json:"skip_body_methods"`
+
+	// GraphQL, SOAP and gRPC enrichment
+	GraphQLEnrichment bool   `json:"graphql_enrichment"`
+	SOAPEnrichment    bool   `json:"soap_enrichment"`
+	GRPCEnrichment    bool   `json:"grpc_enrichment"`
+	GRPCDescriptorSet string `json:"grpc_descriptor_set"`
+
+	// LLM API enrichment and token counting
+	LLMEnrichmentPaths []string `json:"llm_enrichment_paths"`
+	TokenCounting      bool     `json:"token_counting"`
+
+	// OAuth token introspection (RFC 7662)
+	TokenIntrospectionURL          string `json:"token_introspection_url"`
+	TokenIntrospectionClientID     string `json:"token_introspection_client_id"`
+	TokenIntrospectionClientSecret string `json:"token_introspection_client_secret"`
+	TokenIntrospectionCacheTTLSec  int    `json:"token_introspection_cache_ttl_sec"`
+
+	// JWT claims
+	JWTClaimsToForward []string `json:"jwt_claims_to_forward"`
+	JWTJWKSURL         string   `json:"jwt_jwks_url"`
+
+	// Multipart summarization
+	SummarizeMultipartBody     bool `json:"summarize_multipart_body"`
+	MultipartTextFieldMaxBytes int  `json:"multipart_text_field_max_bytes"`
+
+	// Canary evaluation
+	EvaluationPercentage float64 `json:"evaluation_percentage"`
+	EvaluationKey        string  `json:"evaluation_key"`
+
+	// MCP tools/list drift detection
+	ToolsDriftDetection  bool   `json:"tools_drift_detection"`
+	ToolsDriftWebhookURL string `json:"tools_drift_webhook_url"`
+
+	// MCP tools/list response caching
+	ToolsListCacheTTLMs         int    `json:"tools_list_cache_ttl_ms"`
+	ToolsListCacheVersionHeader string `json:"tools_list_cache_version_header"`
+
+	// MCP response id correlation
+	BlockMCPIDMismatch bool `json:"block_mcp_id_mismatch"`
+
+	// MCP tool argument validation, JSON Schema documents by tool name; "*" applies to other tools
+	ToolArgumentSchemas map[string]string `json:"tool_argument_schemas"`
+
+	// MCP prompt-injection screening of tools/call and prompts/get arguments
+	PromptInjectionScreening  bool     `json:"prompt_injection_screening"`
+	PromptInjectionPatterns   []string `json:"prompt_injection_patterns"`
+	PromptInjectionKeywords   []string `json:"prompt_injection_keywords"`
+	PromptInjectionAction     string   `json:"prompt_injection_action"`
+	PromptInjectionBlockScore int      `json:"prompt_injection_block_score"`
+
+	// MCP resources/read restrictions: allowed URI schemes and path globs
+	MCPResourceSchemes []string `json:"mcp_resource_schemes"`
+	MCPResourcePaths   []string `json:"mcp_resource_paths"`
+
+	// MCP per-tool actions by tool name or glob: allow, deny, require_sideband or strip_arguments
+	MCPToolPolicies map[string]string `json:"mcp_tool_policies"`
+
+	// Sideband request headers
+	ForwardHeaders []string `json:"forward_headers"`
+
+	// Payload enrichment
+	ExtractHeaders        []string           `json:"extract_headers"`
+	ExtractBodyFields     []string           `json:"extract_body_fields"`
+	AttributeMappings     []AttributeMapping `json:"attribute_mappings"`
+	IncludeUpstreamTiming bool               `json:"include_upstream_timing"`
+	IncludeKongRoute      bool               `json:"include_kong_route"`
+
+	// CEL expressions
+	SkipExpression    string             `json:"skip_expression"`
+	DerivedAttributes []DerivedAttribute `json:"derived_attributes"`
+
+	// Bypass rules
+	BypassPaths   []string `json:"bypass_paths"`
+	BypassMethods []string `json:"bypass_methods"`
+
+	// Conditional evaluation
+	EvaluationRules        []EvaluationRule `json:"evaluation_rules"`
+	EvaluationRulesDefault string           `json:"evaluation_rules_default"`
+
+	// Client certificate
+	IncludeFullCertChain        bool   `json:"include_full_cert_chain"`
+	RequireClientCertificate    bool   `json:"require_client_certificate"`
+	ClientCertificateDenyStatus int    `json:"client_certificate_deny_status"`
+	ClientCertHeader            string `json:"client_cert_header"`
+
+	// Debug and observability
+	EnableDebugLogging bool     `json:"enable_debug_logging"`
+	EnableOtel         bool     `json:"enable_otel"`
+	RedactHeaders      []string `json:"redact_headers"`
+	DebugBodyMaxBytes  int      `json:"debug_body_max_bytes"`
+	DebugSampleRate    float64  `json:"debug_sample_rate"`
+	DebugTriggerHeader string   `json:"debug_trigger_header"`
+
+	// Cardinality limit of the method and tool labels of the MCP metrics
+	MCPMetricsMaxLabelValues int `json:"mcp_metrics_max_label_values"`
+
+	// Audit log
+	AuditLogSink            string `json:"audit_log_sink"`
+	AuditLogPath            string `json:"audit_log_path"`
+	AuditLogMaxSizeMB       int    `json:"audit_log_max_size_mb"`
+	AuditLogMaxBackups      int    `json:"audit_log_max_backups"`
+	AuditLogURL             string `json:"audit_log_url"`
+	AuditLogRequestIDHeader string `json:"audit_log_request_id_header"`
+
+	// Deny and circuit breaker event webhook
+	DenyWebhookURL     string `json:"deny_webhook_url"`
+	DenyWebhookRetries int    `json:"deny_webhook_retries"`
+
+	// Lazy-initialized fields
+	httpClientOnce sync.Once
+	httpClient     *SidebandHTTPClient
+	endpointLease  *endpointLease
+	otelOnce       sync.Once
+	otelShutdown   func()
+	exprOnce       sync.Once
+	exprs          *ExpressionSet
+	exprErr        error
+	bypassOnce     sync.Once
+	bypass         *bypassRules
+	rulesOnce      sync.Once
+	rules          *ruleSet
+	fallbackOnce   sync.Once
+	fallbackConfig *Config
+
+	fallbackRulesOnce sync.Once
+	fallbackRules     []compiledFallbackRule
+	denyTemplateOnce  sync.Once
+	denyTemplate      *template.Template
+	toolSchemasOnce   sync.Once
+	toolSchemas       map[string]*jsonSchema
+
+	promptInjectionOnce  sync.Once
+	promptInjectionRules []promptInjectionRule
+	resourcePathsOnce    sync.Once
+	resourcePaths        []*regexp.Regexp
+	toolPoliciesOnce     sync.Once
+	toolPolicies         *toolPolicies
+	toolsListCacheOnce   sync.Once
+	toolsListCache       *toolsListCache
+	llmPathsOnce         sync.Once
+	llmPaths             []*regexp.Regexp
+
+	decisionCacheOnce sync.Once
+	decisionCache     decisionStore
+	stateStoreOnce    sync.Once
+	stateStore        stateStore
+	redisOnce         sync.Once
+	redis             *redisClient
+	auditOnce         sync.Once
+	audit             *auditLog
+	denyWebhookOnce   sync.Once
+	denyWebhook       *webhookBatcher
+	grpcOnce          sync.Once
+	grpcDescriptors   *protoregistry.Files
+	jwksOnce          sync.Once
+	jwks              *jwksCache
+	introspectionOnce sync.Once
+	introspector      *tokenIntrospector
+}
+
+// Validate performs custom validation on the config beyond what Kong schema validation provides.
+func (c *Config) Validate() error {
+	// service_url: must be valid http or https
+	if c.ServiceURL == "" {
+		return fmt.Errorf("service_url is required")
+	}
+	if err := validateServiceURL("service_url", c.ServiceURL); err != nil {
+		return err
+	}
+
+	if err := validateSidebandClientCert(c); err != nil {
+		return err
+	}
+	if err := validateSidebandOAuth(c); err != nil {
+		return err
+	}
+	if err := validateTokenIntrospection(c); err != nil {
+		return err
+	}
+	for _, claim := range c.JWTClaimsToForward {
+		if claim == "" {
+			return fmt.Errorf("jwt_claims_to_forward must not contain empty claim names")
+		}
+	}
+	if c.JWTJWKSURL != "" {
+		if err := validateServiceURL("jwt_jwks_url", c.JWTJWKSURL); err != nil {
+			return err
+		}
+		if len(c.JWTClaimsToForward) == 0 {
+			return fmt.Errorf("jwt_claims_to_forward is required with jwt_jwks_url")
+		}
+	}
+
+	if err := validateProvider(c); err != nil {
+		return err
+	}
+	if err := validateFallback(c); err != nil {
+		return err
+	}
+	if _, err := compileFallbackRules(c.FallbackRules); err != nil {
+		return err
+	}
+	if _, err := compileDenyTemplate(c.DenyBodyTemplate); err != nil {
+		return err
+	}
+	if _, err := compileToolArgumentSchemas(c.ToolArgumentSchemas); err != nil {
+		return err
+	}
+	if err := validatePromptInjection(c); err != nil {
+		return err
+	}
+	if err := validateMCPResources(c); err != nil {
+		return err
+	}
+	if _, err := compilePathPatterns(c.LLMEnrichmentPaths); err != nil {
+		return fmt.Errorf("llm_enrichment_paths: %w", err)
+	}
+	if _, err := compileToolPolicies(c.MCPToolPolicies); err != nil {
+		return err
+	}
+	if err := validateDecisionCache(c); err != nil {
+		return err
+	}
+	if err := validateRedis(c); err != nil {
+		return err
+	}
+	if err := validateHealthCheck(c); err != nil {
+		return err
+	}
+	if err := validateAuditLog(c); err != nil {
+		return err
+	}
+
+	// With OAuth the shared secret is optional; without it, it is the only sideband credential
+	if c.SharedSecret == "" && c.SidebandOAuthTokenURL == "" {
+		return fmt.Errorf("shared_secret is required")
+	}
+	if c.SecretHeaderName == "" && c.SharedSecret != "" {
+		return fmt.Errorf("secret_header_name is required")
+	}
+	if err := validateSecretRef("shared_secret", c.SharedSecret); err != nil {
+		return err
+	}
+	if err := validateSecretRef("fallback_shared_secret", c.FallbackSharedSecret); err != nil {
+		return err
+	}
+	if c.SharedSecretSecondary != "" && c.SharedSecret == "" {
+		return fmt.Errorf("shared_secret_secondary requires shared_secret")
+	}
+	if err := validateSecretRef("shared_secret_secondary", c.SharedSecretSecondary); err != nil {
+		return err
+	}
+	if c.ConnectionTimeoutMs <= 0 {
+		return fmt.Errorf("connection_timeout_ms must be > 0")
+	}
+	if c.ConnectionKeepaliveMs <= 0 {
+		return fmt.Errorf("connection_keepalive_ms must be > 0")
+	}
+	if c.MaxRetries < 0 {
+		return fmt.Errorf("max_retries must be >= 0")
+	}
+	if c.RetryBackoffMs <= 0 {
+		return fmt.Errorf("retry_backoff_ms must be > 0")
+	}
+	if c.RetryBudgetPercent < 0 || c.RetryBudgetPercent > 100 {
+		return fmt.Errorf("retry_budget_percent must be between 0 and 100, got %g", c.RetryBudgetPercent)
+	}
+	if c.CircuitBreakerMaxOpenSec < 0 {
+		return fmt.Errorf("circuit_breaker_max_open_sec must be >= 0")
+	}
+	switch c.CircuitBreakerMode {
+	case "", CircuitBreakerModeFirstFailure, CircuitBreakerModeErrorRate:
+	default:
+		return fmt.Errorf("circuit_breaker_mode must be first_failure or error_rate, got %q", c.CircuitBreakerMode)
+	}
+	if c.CircuitBreakerWindowSize < 0 || c.CircuitBreakerMinCalls < 0 {
+		return fmt.Errorf("circuit_breaker_window_size and circuit_breaker_min_calls must be >= 0")
+	}
+	if c.CircuitBreakerMinCalls > c.CircuitBreakerWindowSize && c.CircuitBreakerWindowSize > 0 {
+		return fmt.Errorf("circuit_breaker_min_calls must not exceed circuit_breaker_window_size")
+	}
+	if c.CircuitBreakerFailureRate < 0 || c.CircuitBreakerFailureRate >= 100 {
+		return fmt.Errorf("circuit_breaker_failure_rate must be between 0 and 100 (exclusive), got %g", c.CircuitBreakerFailureRate)
+	}
+	if c.CircuitBreakerSyncIntervalMs < 0 {
+		return fmt.Errorf("circuit_breaker_sync_interval_ms must be >= 0")
+	}
+	if c.HedgeDelayMs < 0 || (c.HedgeDelayMs > 0 && c.HedgeDelayMs >= c.ConnectionTimeoutMs) {
+		return fmt.Errorf("hedge_delay_ms must be >= 0 and less than connection_timeout_ms, got %d", c.HedgeDelayMs)
+	}
+	if c.SLOEnabled && (c.SLOTarget <= 0 || c.SLOTarget >= 1) {
+		return fmt.Errorf("slo_target must be between 0 and 1 (exclusive), got %g", c.SLOTarget)
+	}
+	if c.SLOLatencyMs < 0 {
+		return fmt.Errorf("slo_latency_ms must be >= 0")
+	}
+	if c.SLOWindowSeconds < 0 {
+		return fmt.Errorf("slo_window_seconds must be >= 0")
+	}
+	if c.SLOMinCalls < 0 {
+		return fmt.Errorf("slo_min_calls must be >= 0")
+	}
+	if c.SLOWebhookURL != "" && !validWebhookURL(c.SLOWebhookURL) {
+		return fmt.Errorf("slo_webhook_url must be an http or https URL, got %q", c.SLOWebhookURL)
+	}
+	if c.SidebandRateLimit < 0 {
+		return fmt.Errorf("sideband_rate_limit must be >= 0")
+	}
+	if c.SidebandRateBurst < 0 {
+		return fmt.Errorf("sideband_rate_burst must be >= 0")
+	}
+	if c.SidebandRateLimitQueueMs < 0 {
+		return fmt.Errorf("sideband_rate_limit_queue_ms must be >= 0")
+	}
+	for _, code := range c.PassthroughStatusCodes {
+		if code < 400 || code > 599 {
+			return fmt.Errorf("passthrough_status_codes must be in range 400-599, got %d", code)
+		}
+	}
+	for _, pattern := range c.ResponsePhaseStatusCodes {
+		if !validStatusCodePattern(pattern) {
+			return fmt.Errorf("response_phase_status_codes entries must be a status code (e.g. 200) or class (e.g. 2xx), got %q", pattern)
+		}
+	}
+	if c.ClientCertificateDenyStatus != 0 && c.ClientCertificateDenyStatus != 401 && c.ClientCertificateDenyStatus != 403 {
+		return fmt.Errorf("client_certificate_deny_status must be 401 or 403, got %d", c.ClientCertificateDenyStatus)
+	}
+	if c.MaxDecompressedBodyBytes < 0 {
+		return fmt.Errorf("max_decompressed_body_bytes must be >= 0")
+	}
+	if c.MultipartTextFieldMaxBytes < 0 {
+		return fmt.Errorf("multipart_text_field_max_bytes must be >= 0")
+	}
+	if c.BodySamplePercent < 0 || c.BodySamplePercent > 100 {
+		return fmt.Errorf("body_sample_percent must be between 0 and 100, got %g", c.BodySamplePercent)
+	}
+	for _, ct := range c.BodyContentTypes {
+		if !strings.Contains(ct, "/") {
+			return fmt.Errorf("body_content_types entries must be media types like application/json or ranges like image/*, got %q", ct)
+		}
+	}
+	if c.EvaluationPercentage < 0 || c.EvaluationPercentage > 100 {
+		return fmt.Errorf("evaluation_percentage must be between 0 and 100, got %g", c.EvaluationPercentage)
+	}
+	if c.WebSocketMessageSamplePercent < 0 || c.WebSocketMessageSamplePercent > 100 {
+		return fmt.Errorf("websocket_message_sample_percent must be between 0 and 100, got %g", c.WebSocketMessageSamplePercent)
+	}
+	if c.EvaluationKey != "" && c.EvaluationKey != evaluationKeyClientIP && c.EvaluationKey != evaluationKeyConsumer {
+		return fmt.Errorf("evaluation_key must be client_ip or consumer, got %q", c.EvaluationKey)
+	}
+	if c.ToolsDriftWebhookURL != "" && !validWebhookURL(c.ToolsDriftWebhookURL) {
+		return fmt.Errorf("tools_drift_webhook_url must be an http or https URL, got %q", c.ToolsDriftWebhookURL)
+	}
+	if c.ToolsListCacheTTLMs < 0 {
+		return fmt.Errorf("tools_list_cache_ttl_ms must be >= 0, got %d", c.ToolsListCacheTTLMs)
+	}
+	if c.DenyWebhookURL != "" && !validWebhookURL(c.DenyWebhookURL) {
+		return fmt.Errorf("deny_webhook_url must be an http or https URL, got %q", c.DenyWebhookURL)
+	}
+	if c.DenyWebhookRetries < 0 {
+		return fmt.Errorf("deny_webhook_retries must be >= 0")
+	}
+	if c.DebugBodyMaxBytes < 0 {
+		return fmt.Errorf("debug_body_max_bytes must be >= 0")
+	}
+	if c.DebugSampleRate < 0 || c.DebugSampleRate > 1 {
+		return fmt.Errorf("debug_sample_rate must be between 0 and 1, got %g", c.DebugSampleRate)
+	}
+	if c.MCPMetricsMaxLabelValues < 0 {
+		return fmt.Errorf("mcp_metrics_max_label_values must be >= 0, got %d", c.MCPMetricsMaxLabelValues)
+	}
+	if err := validateTimeouts(c); err != nil {
+		return err
+	}
+	if c.MaxStateBytes < 0 {
+		return fmt.Errorf("max_state_bytes must be >= 0, got %d", c.MaxStateBytes)
+	}
+	for _, name := range c.ExtractHeaders {
+		if name == "" {
+			return fmt.Errorf("extract_headers must not contain empty header names")
+		}
+	}
+	for _, path := range c.ExtractBodyFields {
+		if err := validateJSONPath(path); err != nil {
+			return fmt.Errorf("extract_body_fields: %w", err)
+		}
+	}
+	if err := validateAttributeMappings(c.AttributeMappings); err != nil {
+		return err
+	}
+	if _, err := CompileExpressions(c.SkipExpression, c.DerivedAttributes); err != nil {
+		return err
+	}
+	if _, err := compileBypassRules(c.BypassPaths, c.BypassMethods); err != nil {
+		return err
+	}
+	if _, err := compileEvaluationRules(c.EvaluationRules, c.EvaluationRulesDefault); err != nil {
+		return err
+	}
+	for _, name := range c.ForwardHeaders {
+		if name == "" {
+			return fmt.Errorf("forward_headers must not contain empty header names")
+		}
+		lower := strings.ToLower(name)
+		if reservedSidebandHeaders[lower] || lower == strings.ToLower(c.SecretHeaderName) ||
+			(lower == "authorization" && c.SidebandOAuthTokenURL != "") {
+			return fmt.Errorf("forward_headers must not contain reserved header %q", name)
+		}
+	}
+
+	return nil
+}
+
+// validateServiceURL checks that rawURL, the value of field, is an http or https URL with a host.
+func validateServiceURL(field, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("%s is not a valid URL: %w", field, err)
+	}
+	scheme := strings.ToLower(u.Scheme)
+	if scheme != "http" && scheme != "https" {
+		return fmt.Errorf("%s scheme must be http or https, got %q", field, u.Scheme)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("%s must have a host", field)
+	}
+	return nil
+}
+
+// getHTTPClient returns the lazily-initialized HTTP client.
+// Configs targeting the same endpoint share a transport and circuit breaker via globalEndpoints.
+func (c *Config) getHTTPClient() *SidebandHTTPClient {
+	c.httpClientOnce.Do(func() {
+		c.httpClient, c.endpointLease = globalEndpoints.newClient(c)
+	})
+	return c.httpClient
+}
+
+// getExpressions returns the lazily-compiled CEL expressions (nil if none are configured).
+func (c *Config) getExpressions() (*ExpressionSet, error) {
+	c.exprOnce.Do(func() {
+		c.exprs, c.exprErr = CompileExpressions(c.SkipExpression, c.DerivedAttributes)
+	})
+	return c.exprs, c.exprErr
+}
+
+// applyDefaults sets default values for fields that Kong would normally default.
+// This is used for testing and when running outside Kong's config system.
+func (c *Config) applyDefaults() {
+	if c.ConnectionTimeoutMs == 0 {
+		c.ConnectionTimeoutMs = 10000
+	}
+	if c.ConnectionKeepaliveMs == 0 {
+		c.ConnectionKeepaliveMs = 60000
+	}
+	if c.RetryBackoffMs == 0 {
+		c.RetryBackoffMs = 500
+	}
+	if c.CircuitBreakerMaxOpenSec == 0 {
+		c.CircuitBreakerMaxOpenSec = defaultCircuitBreakerMaxOpenSec
+	}
+	if c.CircuitBreakerMode == "" {
+		c.CircuitBreakerMode = CircuitBreakerModeFirstFailure
+	}
+	if c.CircuitBreakerWindowSize == 0 {
+		c.CircuitBreakerWindowSize = defaultCircuitBreakerWindowSize
+	}
+	if c.CircuitBreakerFailureRate == 0 {
+		c.CircuitBreakerFailureRate = defaultCircuitBreakerFailureRate
+	}
+	if c.CircuitBreakerMinCalls == 0 {
+		c.CircuitBreakerMinCalls = defaultCircuitBreakerMinCalls
+	}
+	if c.CircuitBreakerStore == "" {
+		c.CircuitBreakerStore = storeMemory
+	}
+	if c.CircuitBreakerSyncIntervalMs == 0 {
+		c.CircuitBreakerSyncIntervalMs = defaultCircuitBreakerSyncIntervalMs
+	}
+	if c.PassthroughStatusCodes == nil {
+		c.PassthroughStatusCodes = []int{413}
+	}
+	if c.SkipBodyMethods == nil {
+		c.SkipBodyMethods = []string{"GET", "HEAD"}
+	}
+	if c.RedactHeaders == nil {
+		c.RedactHeaders = []string{"authorization", "cookie"}
+	}
+	if c.AuditLogMaxSizeMB == 0 {
+		c.AuditLogMaxSizeMB = defaultAuditLogMaxSizeMB
+	}
+	if c.AuditLogMaxBackups == 0 {
+		c.AuditLogMaxBackups = defaultAuditLogMaxBackups
+	}
+	if c.AuditLogRequestIDHeader == "" {
+		c.AuditLogRequestIDHeader = defaultAuditLogRequestIDHeader
+	}
+	if c.DenyWebhookRetries == 0 {
+		c.DenyWebhookRetries = defaultWebhookRetries
+	}
+	if c.DebugSampleRate == 0 {
+		c.DebugSampleRate = 1
+	}
+	if c.EvaluationPercentage == 0 {
+		c.EvaluationPercentage = 100
+	}
+	if c.WebSocketMessageSamplePercent == 0 {
+		c.WebSocketMessageSamplePercent = 100
+	}
+	if c.PromptInjectionPatterns == nil {
+		c.PromptInjectionPatterns = defaultPromptInjectionPatterns
+	}
+	if c.PromptInjectionAction == "" {
+		c.PromptInjectionAction = PromptInjectionActionAnnotate
+	}
+	if c.PromptInjectionBlockScore == 0 {
+		c.PromptInjectionBlockScore = 1
+	}
+	if c.EvaluationKey == "" {
+		c.EvaluationKey = evaluationKeyClientIP
+	}
+	if c.DebugBodyMaxBytes == 0 {
+		c.DebugBodyMaxBytes = 8192
+	}
+	if c.ClientCertificateDenyStatus == 0 {
+		c.ClientCertificateDenyStatus = 401
+	}
+	if c.MaxDecompressedBodyBytes == 0 {
+		c.MaxDecompressedBodyBytes = defaultMaxDecompressedBodyBytes
+	}
+	if c.TokenIntrospectionCacheTTLSec == 0 {
+		c.TokenIntrospectionCacheTTLSec = defaultTokenIntrospectionCacheTTLSec
+	}
+	if c.MultipartTextFieldMaxBytes == 0 {
+		c.MultipartTextFieldMaxBytes = defaultMultipartTextFieldMaxBytes
+	}
+	if c.SLOTarget == 0 {
+		c.SLOTarget = defaultSLOTarget
+	}
+	if c.HealthCheckIntervalMs == 0 {
+		c.HealthCheckIntervalMs = defaultHealthCheckIntervalMs
+	}
+	if c.HealthCheckTimeoutMs == 0 {
+		c.HealthCheckTimeoutMs = defaultHealthCheckTimeoutMs
+	}
+	if c.HealthCheckUnhealthyThreshold == 0 {
+		c.HealthCheckUnhealthyThreshold = defaultHealthCheckUnhealthyThreshold
+	}
+	if c.HealthCheckHealthyThreshold == 0 {
+		c.HealthCheckHealthyThreshold = defaultHealthCheckHealthyThreshold
+	}
+	if c.SLOWindowSeconds == 0 {
+		c.SLOWindowSeconds = defaultSLOWindowSeconds
+	}
+	if c.SLOMinCalls == 0 {
+		c.SLOMinCalls = defaultSLOMinCalls
+	}
+	if c.AuthZenEvaluationPath == "" {
+		c.AuthZenEvaluationPath = defaultAuthZenEvaluationPath
+	}
+	if c.AuthZenSubjectHeader == "" {
+		c.AuthZenSubjectHeader = defaultAuthZenSubjectHeader
+	}
+	if c.AuthZenSubjectType == "" {
+		c.AuthZenSubjectType = defaultAuthZenSubjectType
+	}
+	if c.OPAPackage == "" {
+		c.OPAPackage = defaultOPAPackage
+	}
+	if c.DecisionCacheKey == nil {
+		c.DecisionCacheKey = defaultDecisionCacheKey
+	}
+	if c.DecisionCacheKeyHeaders == nil {
+		c.DecisionCacheKeyHeaders = defaultDecisionCacheKeyHeaders
+	}
+	if c.DecisionCacheMaxEntries == 0 {
+		c.DecisionCacheMaxEntries = defaultDecisionCacheMaxEntries
+	}
+	if c.MCPMetricsMaxLabelValues == 0 {
+		c.MCPMetricsMaxLabelValues = defaultMCPMetricsMaxLabelValues
+	}
+	if c.DecisionCacheStore == "" {
+		c.DecisionCacheStore = storeMemory
+	}
+	if c.StateStore == "" {
+		c.StateStore = storeKong
+	}
+	if c.RedisKeyPrefix == "" {
+		c.RedisKeyPrefix = defaultRedisKeyPrefix
+	}
+	if c.RedisTimeoutMs == 0 {
+		c.RedisTimeoutMs = defaultRedisTimeoutMs
+	}
+	if c.RedisStateTTLMs == 0 {
+		c.RedisStateTTLMs = defaultRedisStateTTLMs
+	}
+}
@@ -0,0 +1,103 @@
+package pingauthorize
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"github.com/Kong/go-pdk"
+
+	"github.com/idpartners/idpartners-ping-authorize/pingauthorize/decisionctx"
+)
+
+// newDecisionRecord starts the decision context of a request from its access payload. It must be
+// called before body sampling so the MCP fields are read from the full body.
+func newDecisionRecord(payload *SidebandAccessRequest, consumerIDs []string, session string) *decisionctx.Record {
+	record := &decisionctx.Record{
+		Version: decisionctx.SchemaVersion,
+		Request: decisionctx.Request{
+			Method:   payload.Method,
+			URL:      payload.URL,
+			SourceIP: payload.SourceIP,
+			Consumer: consumerName(consumerIDs),
+		},
+	}
+	if req := parseMCPRequest([]byte(payload.Body)); req != nil {
+		record.MCP = &decisionctx.MCP{Method: req.Method, ID: req.ID, Tool: mcpToolName(req), Session: session}
+	}
+	return record
+}
+
+// recordSidebandFailure notes why a sideband call failed in phase.
+func recordSidebandFailure(phase *decisionctx.Phase, err error) {
+	switch err.(type) {
+	case *CircuitBreakerOpenError:
+		phase.CircuitBreakerOpen = true
+	case *SidebandThrottledError:
+		phase.Throttled = true
+	}
+	phase.Error = err.Error()
+}
+
+// requestObligations lists the parts of the request the policy changed.
+func requestObligations(payload *SidebandAccessRequest, resp *SidebandAccessResponse) []string {
+	var changed []string
+	if resp.Method != "" && resp.Method != payload.Method {
+		changed = append(changed, "method")
+	}
+	if resp.URL != "" && resp.URL != payload.URL {
+		changed = append(changed, "url")
+	}
+	if resp.Headers != nil && !reflect.DeepEqual(FlattenHeaders(resp.Headers), FlattenHeaders(payload.Headers)) {
+		changed = append(changed, "headers")
+	}
+	if resp.Body != nil && *resp.Body != payload.Body {
+		changed = append(changed, "body")
+	}
+	return changed
+}
+
+// responseObligations lists the parts of the upstream response the policy changed.
+func responseObligations(payload *SidebandResponsePayload, result *SidebandResponseResult) []string {
+	var changed []string
+	if result.ResponseCode != "" && result.ResponseCode != payload.ResponseCode {
+		changed = append(changed, "status")
+	}
+	if !reflect.DeepEqual(FlattenHeaders(result.Headers), FlattenHeaders(payload.Headers)) {
+		changed = append(changed, "headers")
+	}
+	if result.Body != payload.Body {
+		changed = append(changed, "body")
+	}
+	return changed
+}
+
+// latencyMs converts d to fractional milliseconds.
+func latencyMs(d time.Duration) *float64 {
+	ms := float64(d) / float64(time.Millisecond)
+	return &ms
+}
+
+// publishDecision writes the decision of phase to the audit log and, for denials, to the deny
+// webhook, when they are configured.
+func (c *Config) publishDecision(phaseName string, record *decisionctx.Record, phase *decisionctx.Phase, header func(string) string) {
+	c.getAuditLog().log(phaseName, record, phase, header)
+	c.publishDeny(phaseName, record, phase)
+}
+
+// storeDecisionContext publishes record in kong.ctx.shared for logging plugins.
+func storeDecisionContext(kong *pdk.PDK, record *decisionctx.Record) {
+	data, err := json.Marshal(record)
+	if err == nil {
+		kong.Ctx.SetShared(decisionctx.SharedKey, string(data))
+	}
+}
+
+// loadDecisionContext returns the record stored by the access phase, or nil.
+func loadDecisionContext(kong *pdk.PDK) *decisionctx.Record {
+	record, err := decisionctx.FromKong(kong)
+	if err != nil {
+		return nil
+	}
+	return record
+}
@@ -0,0 +1,400 @@
+package pingauthorize
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const defaultDecisionCacheMaxEntries = 10000
+
+// Fields that can make up the decision cache key (decision_cache_key).
+const (
+	cacheKeyMethod    = "method"
+	cacheKeyPath      = "path"
+	cacheKeyQuery     = "query"
+	cacheKeyConsumer  = "consumer"
+	cacheKeyBody      = "body"
+	cacheKeyMCPMethod = "mcp_method"
+	cacheKeyMCPTool   = "mcp_tool"
+)
+
+var (
+	defaultDecisionCacheKey        = []string{cacheKeyMethod, cacheKeyPath, cacheKeyQuery, cacheKeyConsumer, cacheKeyMCPMethod, cacheKeyMCPTool}
+	defaultDecisionCacheKeyHeaders = []string{"authorization"}
+)
+
+// validateDecisionCache checks the decision_cache_* settings.
+func validateDecisionCache(c *Config) error {
+	if c.DecisionCacheTTLMs < 0 {
+		return fmt.Errorf("decision_cache_ttl_ms must be >= 0, got %d", c.DecisionCacheTTLMs)
+	}
+	if c.DecisionCacheMaxEntries < 0 {
+		return fmt.Errorf("decision_cache_max_entries must be >= 0, got %d", c.DecisionCacheMaxEntries)
+	}
+	for _, field := range c.DecisionCacheKey {
+		switch field {
+		case cacheKeyMethod, cacheKeyPath, cacheKeyQuery, cacheKeyConsumer, cacheKeyBody, cacheKeyMCPMethod, cacheKeyMCPTool:
+		default:
+			return fmt.Errorf("decision_cache_key: unknown field %q", field)
+		}
+	}
+	return nil
+}
+
+// cachedDecision is an access decision kept as what the policy did rather than the response it
+// returned, since the sideband response echoes the complete request: a deny response, or the
+// method and header changes of an allow.
+type cachedDecision struct {
+	Deny          *DenyResponse       `json:"deny,omitempty"`
+	Method        string              `json:"method,omitempty"`
+	SetHeaders    map[string][]string `json:"set_headers,omitempty"`
+	RemoveHeaders []string            `json:"remove_headers,omitempty"`
+	State         json.RawMessage     `json:"state,omitempty"`
+	Expires       time.Time           `json:"expires"`
+}
+
+// decisionStore holds cached decisions until they expire.
+type decisionStore interface {
+	// get returns the unexpired decision stored under key, or nil.
+	get(ctx context.Context, key string) (*cachedDecision, error)
+	// put stores d under key until d.Expires.
+	put(ctx context.Context, key string, d *cachedDecision) error
+}
+
+// decisionCache is the in-memory decisionStore of one plugin configuration.
+type decisionCache struct {
+	mu         sync.Mutex
+	entries    map[string]*cachedDecision
+	maxEntries int
+	now        func() time.Time
+}
+
+func newDecisionCache(maxEntries int) *decisionCache {
+	if maxEntries <= 0 {
+		maxEntries = defaultDecisionCacheMaxEntries
+	}
+	return &decisionCache{entries: make(map[string]*cachedDecision), maxEntries: maxEntries, now: time.Now}
+}
+
+// get implements decisionStore.
+func (c *decisionCache) get(ctx context.Context, key string) (*cachedDecision, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	d, ok := c.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	if !c.now().Before(d.Expires) {
+		delete(c.entries, key)
+		return nil, nil
+	}
+	return d, nil
+}
+
+// put implements decisionStore. When the cache is full, expired entries are dropped first and
+// then an arbitrary one.
+func (c *decisionCache) put(ctx context.Context, key string, d *cachedDecision) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.maxEntries {
+		now := c.now()
+		for k, e := range c.entries {
+			if !now.Before(e.Expires) {
+				delete(c.entries, k)
+			}
+		}
+		for k := range c.entries {
+			if len(c.entries) < c.maxEntries {
+				break
+			}
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = d
+	return nil
+}
+
+// redisDecisionStore keeps cached decisions in Redis so that they are shared by all Kong nodes.
+// Keys are scoped to the service URL and provider so that plugin configs do not share decisions
+// by accident.
+type redisDecisionStore struct {
+	client *redisClient
+	prefix string
+	scope  string
+}
+
+func newRedisDecisionStore(config *Config) *redisDecisionStore {
+	scope := sha256.Sum256([]byte(config.ServiceURL + "\n" + config.ProviderType))
+	return &redisDecisionStore{
+		client: config.getRedisClient(),
+		prefix: config.RedisKeyPrefix,
+		scope:  hex.EncodeToString(scope[:8]),
+	}
+}
+
+func (s *redisDecisionStore) key(key string) string {
+	return s.prefix + "decision:" + s.scope + ":" + key
+}
+
+// get implements decisionStore.
+func (s *redisDecisionStore) get(ctx context.Context, key string) (*cachedDecision, error) {
+	data, err := s.client.get(ctx, s.key(key))
+	if err != nil || data == nil {
+		return nil, err
+	}
+	var d cachedDecision
+	if err := json.Unmarshal(data, &d); err != nil {
+		return nil, fmt.Errorf("failed to decode cached decision: %w", err)
+	}
+	if !time.Now().Before(d.Expires) {
+		return nil, nil
+	}
+	return &d, nil
+}
+
+// put implements decisionStore.
+func (s *redisDecisionStore) put(ctx context.Context, key string, d *cachedDecision) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	return s.client.set(ctx, s.key(key), data, time.Until(d.Expires))
+}
+
+// cachingProvider serves repeated access decisions from a decisionStore. Response evaluation is
+// never cached.
+type cachingProvider struct {
+	PolicyProvider
+	store  decisionStore
+	config *Config
+}
+
+// EvaluateRequest implements PolicyProvider.
+func (p *cachingProvider) EvaluateRequest(ctx context.Context, req *SidebandAccessRequest) (*SidebandAccessResponse, error) {
+	key, ok := decisionCacheKey(p.config, req, consumerFromContext(ctx))
+	if !ok {
+		return p.PolicyProvider.EvaluateRequest(ctx, req)
+	}
+
+	if p.bypass(req) {
+		p.count("bypass")
+	} else if d, err := p.store.get(ctx, key); err != nil {
+		p.count("error")
+		NewPluginLogger(nil, "access", p.config.ServiceURL).Warn("Decision cache lookup failed", "error", err.Error())
+	} else if d != nil {
+		p.count("hit")
+		return d.apply(req), nil
+	} else {
+		p.count("miss")
+	}
+
+	resp, err := p.PolicyProvider.EvaluateRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	ttl := time.Duration(p.config.DecisionCacheTTLMs) * time.Millisecond
+	if resp.CacheTTL != nil {
+		ttl = *resp.CacheTTL
+	}
+	if ttl > 0 {
+		if d := newCachedDecision(req, resp); d != nil {
+			d.Expires = time.Now().Add(ttl)
+			if err := p.store.put(ctx, key, d); err != nil {
+				NewPluginLogger(nil, "access", p.config.ServiceURL).Warn("Failed to store decision in cache", "error", err.Error())
+			}
+		}
+	}
+	return resp, nil
+}
+
+// bypass reports whether the request carries decision_cache_bypass_header.
+func (p *cachingProvider) bypass(req *SidebandAccessRequest) bool {
+	name := strings.ToLower(p.config.DecisionCacheBypassHeader)
+	return name != "" && firstValue(FlattenHeaders(req.Headers)[name]) != ""
+}
+
+// count records a cache lookup when enable_otel is set.
+func (p *cachingProvider) count(result string) {
+	if p.config.EnableOtel {
+		decisionCacheCounter().Add(context.Background(), 1, metric.WithAttributes(attribute.String("result", result)))
+	}
+}
+
+var (
+	decisionCacheCounterOnce sync.Once
+	decisionCacheTotal       metric.Int64Counter
+)
+
+// decisionCacheCounter returns the decision cache lookup counter, created on first use.
+func decisionCacheCounter() metric.Int64Counter {
+	decisionCacheCounterOnce.Do(func() {
+		decisionCacheTotal, _ = otel.Meter(PluginName).Int64Counter("ping_authorize_decision_cache_total",
+			metric.WithDescription("Decision cache lookups by result: hit, miss, bypass or error"))
+	})
+	return decisionCacheTotal
+}
+
+// decisionCacheKey hashes the decision_cache_key fields and decision_cache_key_headers of req.
+// It returns false if the key cannot be built because the body was left out by body sampling.
+func decisionCacheKey(conf *Config, req *SidebandAccessRequest, consumerIDs []string) (string, bool) {
+	var mcpReq *jsonRPCRequest
+	var u *url.URL
+	h := sha256.New()
+	for _, field := range conf.DecisionCacheKey {
+		var value string
+		switch field {
+		case cacheKeyMethod:
+			value = req.Method
+		case cacheKeyPath, cacheKeyQuery:
+			if u == nil {
+				parsed, err := url.Parse(req.URL)
+				if err != nil {
+					return "", false
+				}
+				u = parsed
+			}
+			// The origin keeps a config serving several hosts from replaying one host's decisions on another
+			value = u.Scheme + "://" + u.Host + u.Path
+			if field == cacheKeyQuery {
+				value = u.RawQuery
+			}
+		case cacheKeyConsumer:
+			for _, id := range consumerIDs {
+				if id != "" {
+					value = id
+					break
+				}
+			}
+		case cacheKeyBody:
+			value = req.Body
+			if req.BodyDigest != nil {
+				value = req.BodyDigest.SHA256
+			}
+		case cacheKeyMCPMethod, cacheKeyMCPTool:
+			if req.BodyDigest != nil {
+				return "", false
+			}
+			if mcpReq == nil {
+				mcpReq = parseMCPRequest([]byte(req.Body))
+			}
+			if mcpReq != nil {
+				value = mcpReq.Method
+				if field == cacheKeyMCPTool {
+					value = mcpToolName(mcpReq)
+				}
+			}
+		}
+		fmt.Fprintf(h, "%s=%d:%s\n", field, len(value), value)
+	}
+
+	headers := FlattenHeaders(req.Headers)
+	for _, name := range conf.DecisionCacheKeyHeaders {
+		name = strings.ToLower(name)
+		value := strings.Join(headers[name], ",")
+		fmt.Fprintf(h, "header %s=%d:%s\n", name, len(value), value)
+	}
+	return hex.EncodeToString(h.Sum(nil)), true
+}
+
+// newCachedDecision returns the cacheable form of resp, or nil if the policy rewrote the URL or
+// body, which depend on the request rather than the key.
+func newCachedDecision(req *SidebandAccessRequest, resp *SidebandAccessResponse) *cachedDecision {
+	if resp.Response != nil {
+		return &cachedDecision{Deny: resp.Response}
+	}
+	if resp.URL != "" && resp.URL != req.URL {
+		return nil
+	}
+	if resp.Body != nil && *resp.Body != req.Body && req.BodyDigest == nil {
+		return nil
+	}
+
+	d := &cachedDecision{State: resp.State}
+	if resp.Method != "" && resp.Method != req.Method {
+		d.Method = resp.Method
+	}
+	if resp.Headers != nil {
+		before, after := FlattenHeaders(req.Headers), FlattenHeaders(resp.Headers)
+		for name, values := range after {
+			if strings.Join(before[name], "\x00") != strings.Join(values, "\x00") {
+				if d.SetHeaders == nil {
+					d.SetHeaders = make(map[string][]string)
+				}
+				d.SetHeaders[name] = values
+			}
+		}
+		for name := range before {
+			if _, ok := after[name]; !ok {
+				d.RemoveHeaders = append(d.RemoveHeaders, name)
+			}
+		}
+	}
+	return d
+}
+
+// apply builds the access response of req from the cached decision.
+func (d *cachedDecision) apply(req *SidebandAccessRequest) *SidebandAccessResponse {
+	if d.Deny != nil {
+		return &SidebandAccessResponse{Response: d.Deny, FromCache: true}
+	}
+
+	headers := req.Headers
+	if len(d.SetHeaders) > 0 || len(d.RemoveHeaders) > 0 {
+		flat := FlattenHeaders(req.Headers)
+		for _, name := range d.RemoveHeaders {
+			delete(flat, name)
+		}
+		for name, values := range d.SetHeaders {
+			flat[name] = values
+		}
+		headers, _ = FormatHeaders(flat)
+	}
+	method := req.Method
+	if d.Method != "" {
+		method = d.Method
+	}
+	body := req.Body
+	return &SidebandAccessResponse{
+		SourceIP:   req.SourceIP,
+		SourcePort: req.SourcePort,
+		Method:     method,
+		URL:        req.URL,
+		Body:       &body,
+		Headers:    headers,
+		State:      d.State,
+		FromCache:  true,
+	}
+}
+
+// getDecisionStore returns the lazily-created decision store selected by decision_cache_store.
+func (c *Config) getDecisionStore() decisionStore {
+	c.decisionCacheOnce.Do(func() {
+		if c.DecisionCacheStore == storeRedis {
+			c.decisionCache = newRedisDecisionStore(c)
+			return
+		}
+		c.decisionCache = newDecisionCache(c.DecisionCacheMaxEntries)
+	})
+	return c.decisionCache
+}
+
+// withDecisionCache wraps provider with the decision cache when decision_cache_ttl_ms is set.
+func withDecisionCache(config *Config, provider PolicyProvider) PolicyProvider {
+	if config.DecisionCacheTTLMs <= 0 {
+		return provider
+	}
+	return &cachingProvider{PolicyProvider: provider, store: config.getDecisionStore(), config: config}
+}
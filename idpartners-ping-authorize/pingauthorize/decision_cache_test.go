@@ -0,0 +1,236 @@
+package pingauthorize
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/idpartners/idpartners-ping-authorize/pingauthorize/decisionctx"
+)
+
+func TestDecisionCacheKey(t *testing.T) {
+	conf := NewConfig()
+	base := &SidebandAccessRequest{
+		Method:  "POST",
+		URL:     "https://api.example.com:443/mcp?v=1",
+		Body:    `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"search","arguments":{"q":"a"}}}`,
+		Headers: []map[string]string{{"authorization": "Bearer a"}, {"x-request-id": "1"}},
+	}
+	baseKey, ok := decisionCacheKey(conf, base, []string{"alice"})
+	if !ok {
+		t.Fatal("expected a key")
+	}
+
+	tests := []struct {
+		name      string
+		modify    func(r *SidebandAccessRequest)
+		consumer  string
+		sameAsKey bool
+	}{
+		{"unkeyed header and arguments", func(r *SidebandAccessRequest) {
+			r.Headers = []map[string]string{{"authorization": "Bearer a"}, {"x-request-id": "2"}}
+			r.Body = `{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"search","arguments":{"q":"b"}}}`
+		}, "alice", true},
+		{"consumer", func(r *SidebandAccessRequest) {}, "bob", false},
+		{"method", func(r *SidebandAccessRequest) { r.Method = "PUT" }, "alice", false},
+		{"query", func(r *SidebandAccessRequest) { r.URL = "https://api.example.com:443/mcp?v=2" }, "alice", false},
+		{"host", func(r *SidebandAccessRequest) { r.URL = "https://other.example.com:443/mcp?v=1" }, "alice", false},
+		{"port", func(r *SidebandAccessRequest) { r.URL = "https://api.example.com:8443/mcp?v=1" }, "alice", false},
+		{"keyed header", func(r *SidebandAccessRequest) {
+			r.Headers = []map[string]string{{"authorization": "Bearer b"}}
+		}, "alice", false},
+		{"tool", func(r *SidebandAccessRequest) {
+			r.Body = `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"shell"}}`
+		}, "alice", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := *base
+			tt.modify(&req)
+			key, ok := decisionCacheKey(conf, &req, []string{tt.consumer})
+			if !ok {
+				t.Fatal("expected a key")
+			}
+			if (key == baseKey) != tt.sameAsKey {
+				t.Errorf("same key = %v, want %v", key == baseKey, tt.sameAsKey)
+			}
+		})
+	}
+
+	t.Run("sampled body", func(t *testing.T) {
+		req := *base
+		req.Body, req.BodyDigest = "", &BodyDigest{SHA256: "abc", Length: 3}
+		if _, ok := decisionCacheKey(conf, &req, nil); ok {
+			t.Error("MCP key fields need the body")
+		}
+	})
+}
+
+func TestCachedDecision(t *testing.T) {
+	req := &SidebandAccessRequest{
+		Method:  "GET",
+		URL:     "http://api.example.com:80/orders",
+		Headers: []map[string]string{{"x-request-id": "1"}, {"cookie": "s=1"}},
+	}
+	changed := "changed"
+	resp := &SidebandAccessResponse{
+		Method:  "GET",
+		URL:     req.URL,
+		Body:    new(string),
+		Headers: []map[string]string{{"x-request-id": "1"}, {"x-user": "alice"}},
+		State:   json.RawMessage(`{"s":1}`),
+	}
+	d := newCachedDecision(req, resp)
+	if d == nil {
+		t.Fatal("expected cacheable decision")
+	}
+
+	next := &SidebandAccessRequest{
+		Method:  "GET",
+		URL:     "http://api.example.com:80/orders",
+		Headers: []map[string]string{{"x-request-id": "2"}, {"cookie": "s=2"}},
+	}
+	got := d.apply(next)
+	headers := FlattenHeaders(got.Headers)
+	if headers["x-request-id"][0] != "2" || headers["x-user"][0] != "alice" || headers["cookie"] != nil {
+		t.Errorf("cached changes not applied to the new request: %v", headers)
+	}
+	if !got.FromCache || string(got.State) != `{"s":1}` || got.URL != next.URL {
+		t.Errorf("unexpected cached response: %+v", got)
+	}
+
+	rewritten := *resp
+	rewritten.URL = "http://api.example.com:80/v2/orders"
+	if newCachedDecision(req, &rewritten) != nil {
+		t.Error("URL rewrites should not be cached")
+	}
+	rewritten = *resp
+	rewritten.Body = &changed
+	if newCachedDecision(req, &rewritten) != nil {
+		t.Error("body rewrites should not be cached")
+	}
+}
+
+func TestDecisionCache_Eviction(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1000, 0)
+	c := newDecisionCache(2)
+	c.now = func() time.Time { return now }
+
+	c.put(ctx, "a", &cachedDecision{Expires: now.Add(time.Second)})
+	c.put(ctx, "b", &cachedDecision{Expires: now.Add(time.Minute)})
+	now = now.Add(2 * time.Second)
+	if d, _ := c.get(ctx, "a"); d != nil {
+		t.Error("expected expired entry to be gone")
+	}
+	c.put(ctx, "a", &cachedDecision{Expires: now.Add(time.Second)})
+	c.put(ctx, "c", &cachedDecision{Expires: now.Add(time.Minute)})
+	if d, _ := c.get(ctx, "c"); len(c.entries) != 2 || d == nil {
+		t.Errorf("expected the newest entry within the limit, got %d entries", len(c.entries))
+	}
+}
+
+func TestMiddleware_DecisionCache(t *testing.T) {
+	sidebandCalls := 0
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		sidebandCalls++
+		var req SidebandAccessRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if strings.Contains(req.URL, "/admin") {
+			w.Write([]byte(`{"response":{"response_code":"403","response_status":"FORBIDDEN","body":"no"}}`))
+			return
+		}
+		headers := append(req.Headers, map[string]string{"x-user": fmt.Sprint("call-", sidebandCalls)})
+		json.NewEncoder(w).Encode(SidebandAccessResponse{Method: req.Method, URL: req.URL, Body: &req.Body, Headers: headers})
+	})
+	defer server.Close()
+
+	m, err := NewMiddleware(&Config{
+		ServiceURL:                server.URL,
+		SharedSecret:              "test-secret",
+		SecretHeaderName:          "X-Secret",
+		SkipResponsePhase:         true,
+		DecisionCacheTTLMs:        60000,
+		DecisionCacheBypassHeader: "X-PAZ-Cache-Bypass",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	h := m.Handler(upstreamEcho(t, &calls))
+	serve := func(path string, header ...string) (*httptest.ResponseRecorder, *decisionctx.Record) {
+		r := httptest.NewRequest("GET", "http://api.example.com"+path, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		ctx, record := decisionctx.NewContext(r.Context())
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r.WithContext(ctx))
+		return rec, record
+	}
+
+	rec, record := serve("/orders")
+	if rec.Header().Get("X-Upstream-User") != "call-1" || record.Access.Cached {
+		t.Fatalf("expected first request evaluated, got %v %+v", rec.Header(), record.Access)
+	}
+	rec, record = serve("/orders")
+	if rec.Header().Get("X-Upstream-User") != "call-1" || !record.Access.Cached || sidebandCalls != 1 {
+		t.Errorf("expected cached decision, got %v (%d sideband calls)", rec.Header(), sidebandCalls)
+	}
+
+	rec, _ = serve("/orders", "X-PAZ-Cache-Bypass", "1")
+	if rec.Header().Get("X-Upstream-User") != "call-2" || sidebandCalls != 2 {
+		t.Errorf("expected bypass to call the sideband, got %v (%d sideband calls)", rec.Header(), sidebandCalls)
+	}
+
+	serve("/orders", "Authorization", "Bearer other")
+	if sidebandCalls != 3 {
+		t.Errorf("expected a different authorization header to miss, got %d sideband calls", sidebandCalls)
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec, _ := serve("/admin"); rec.Code != 403 {
+			t.Errorf("expected cached deny, got %d", rec.Code)
+		}
+	}
+	if sidebandCalls != 4 || calls != 4 {
+		t.Errorf("expected deny to be cached, got %d sideband and %d upstream calls", sidebandCalls, calls)
+	}
+}
+
+func TestCachingProvider_TTLHint(t *testing.T) {
+	hint := time.Duration(0)
+	inner := &countingProvider{resp: func() *SidebandAccessResponse {
+		return &SidebandAccessResponse{Method: "GET", URL: "http://a/", CacheTTL: &hint}
+	}}
+	conf := NewConfig()
+	conf.DecisionCacheTTLMs = 60000
+	p := withDecisionCache(conf, inner)
+
+	req := &SidebandAccessRequest{Method: "GET", URL: "http://a/"}
+	p.EvaluateRequest(context.Background(), req)
+	p.EvaluateRequest(context.Background(), req)
+	if inner.calls != 2 {
+		t.Errorf("a zero TTL hint should not be cached, got %d calls", inner.calls)
+	}
+}
+
+// countingProvider returns resp() for every access request and counts the calls.
+type countingProvider struct {
+	resp  func() *SidebandAccessResponse
+	calls int
+}
+
+func (p *countingProvider) EvaluateRequest(ctx context.Context, req *SidebandAccessRequest) (*SidebandAccessResponse, error) {
+	p.calls++
+	return p.resp(), nil
+}
+
+func (p *countingProvider) EvaluateResponse(ctx context.Context, req *SidebandResponsePayload) (*SidebandResponseResult, error) {
+	return &SidebandResponseResult{ResponseCode: req.ResponseCode, Body: req.Body, Headers: req.Headers}, nil
+}
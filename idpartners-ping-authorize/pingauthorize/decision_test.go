@@ -0,0 +1,151 @@
+package pingauthorize
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/idpartners/idpartners-ping-authorize/pingauthorize/decisionctx"
+)
+
+func TestNewDecisionRecord(t *testing.T) {
+	payload := &SidebandAccessRequest{
+		Method:   "POST",
+		URL:      "http://api.example.com:80/mcp",
+		SourceIP: "10.0.0.1",
+		Body:     `{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"search"}}`,
+	}
+	record := newDecisionRecord(payload, []string{"", "cust-1", "uuid"}, "sess")
+	if record.Version != decisionctx.SchemaVersion || record.Request.Consumer != "cust-1" {
+		t.Errorf("unexpected record: %+v", record)
+	}
+	want := &decisionctx.MCP{Method: "tools/call", ID: json.RawMessage("3"), Tool: "search", Session: "sess"}
+	if !reflect.DeepEqual(record.MCP, want) {
+		t.Errorf("MCP = %+v, want %+v", record.MCP, want)
+	}
+
+	payload.Body = "plain"
+	if record := newDecisionRecord(payload, nil, ""); record.MCP != nil {
+		t.Errorf("non-MCP body should not produce MCP fields: %+v", record.MCP)
+	}
+}
+
+func TestRequestObligations(t *testing.T) {
+	payload := &SidebandAccessRequest{
+		Method:  "GET",
+		URL:     "http://api.example.com:80/a",
+		Body:    "",
+		Headers: []map[string]string{{"host": "api.example.com"}},
+	}
+	body := "new"
+	tests := []struct {
+		name string
+		resp *SidebandAccessResponse
+		want []string
+	}{
+		{"unchanged", &SidebandAccessResponse{Method: "GET", URL: payload.URL, Headers: payload.Headers, Body: &payload.Body}, nil},
+		{"omitted fields", &SidebandAccessResponse{}, nil},
+		{"everything", &SidebandAccessResponse{
+			Method:  "POST",
+			URL:     "http://api.example.com:80/b",
+			Headers: []map[string]string{{"host": "api.example.com"}, {"x-user": "alice"}},
+			Body:    &body,
+		}, []string{"method", "url", "headers", "body"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := requestObligations(payload, tt.resp); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResponseObligations(t *testing.T) {
+	payload := &SidebandResponsePayload{ResponseCode: "200", Body: "ok", Headers: []map[string]string{{"content-type": "text/plain"}}}
+	if got := responseObligations(payload, &SidebandResponseResult{ResponseCode: "200", Body: "ok", Headers: payload.Headers}); got != nil {
+		t.Errorf("unchanged response: got %v", got)
+	}
+	got := responseObligations(payload, &SidebandResponseResult{ResponseCode: "403", Body: "no"})
+	if !reflect.DeepEqual(got, []string{"status", "headers", "body"}) {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestMiddleware_DecisionContext(t *testing.T) {
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/sideband/response" {
+			w.Write([]byte(`{"response_code":"200","body":"filtered","headers":[]}`))
+			return
+		}
+		var req SidebandAccessRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if strings.Contains(req.Body, "shell") {
+			w.Write([]byte(`{"response":{"response_code":"403","response_status":"FORBIDDEN"}}`))
+			return
+		}
+		req.Headers = append(req.Headers, map[string]string{"x-user": "alice"})
+		json.NewEncoder(w).Encode(SidebandAccessResponse{Method: req.Method, URL: req.URL, Body: &req.Body, Headers: req.Headers})
+	})
+	defer server.Close()
+
+	m := newTestMiddleware(t, server.URL, true)
+	calls := 0
+	h := m.Handler(upstreamEcho(t, &calls))
+
+	serve := func(body string) *decisionctx.Record {
+		r := httptest.NewRequest("POST", "http://api.example.com/mcp", strings.NewReader(body))
+		r.Header.Set("Mcp-Session-Id", "s1")
+		ctx, record := decisionctx.NewContext(WithConsumer(r.Context(), "alice"))
+		h.ServeHTTP(httptest.NewRecorder(), r.WithContext(ctx))
+		return record
+	}
+
+	record := serve(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"search"}}`)
+	if record.Access.Decision != decisionctx.DecisionAllow || !reflect.DeepEqual(record.Access.Obligations, []string{"headers"}) || record.Access.LatencyMs == nil {
+		t.Errorf("unexpected access phase: %+v", record.Access)
+	}
+	if record.Request.Consumer != "alice" || record.MCP == nil || record.MCP.Tool != "search" || record.MCP.Session != "s1" {
+		t.Errorf("unexpected request summary: %+v %+v", record.Request, record.MCP)
+	}
+	if record.Response == nil || record.Response.Decision != decisionctx.DecisionAllow || record.Response.StatusCode != 200 ||
+		!reflect.DeepEqual(record.Response.Obligations, []string{"headers", "body"}) {
+		t.Errorf("unexpected response phase: %+v", record.Response)
+	}
+
+	record = serve(`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"shell"}}`)
+	if record.Access.Decision != decisionctx.DecisionDeny || record.Access.StatusCode != 403 || record.Response != nil {
+		t.Errorf("unexpected denied record: %+v %+v", record.Access, record.Response)
+	}
+}
+
+func TestMiddleware_DecisionContextFailOpen(t *testing.T) {
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(500)
+	})
+	defer server.Close()
+
+	conf := &Config{
+		ServiceURL:        server.URL,
+		SharedSecret:      "test-secret",
+		SecretHeaderName:  "X-Secret",
+		SkipResponsePhase: true,
+		FailOpen:          true,
+	}
+	m, err := NewMiddleware(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	r := httptest.NewRequest("GET", "http://api.example.com/", nil)
+	ctx, record := decisionctx.NewContext(r.Context())
+	m.Handler(upstreamEcho(t, &calls)).ServeHTTP(httptest.NewRecorder(), r.WithContext(ctx))
+
+	if record.Access.Decision != decisionctx.DecisionAllow || !record.Access.FailOpen || record.Access.Error == "" {
+		t.Errorf("unexpected fail-open record: %+v", record.Access)
+	}
+}
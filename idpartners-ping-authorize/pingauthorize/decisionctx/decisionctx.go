@@ -0,0 +1,142 @@
+// Package decisionctx defines the authorization record the idpartners-ping-authorize plugin
+// publishes for every request it evaluates, so that logging plugins and middleware can ship a
+// complete authorization record without re-implementing extraction.
+//
+// In Kong the record is stored as a JSON string under the kong.ctx.shared key SharedKey. Lua
+// logging plugins can decode it, for example with http-log custom_fields_by_lua:
+//
+//	ping_authorize = "return require('cjson').decode(kong.ctx.shared.paz_decision_context or 'null')"
+//
+// Go plugins running after the access or response phase use FromKong. With the net/http
+// middleware, NewContext attaches a Record to the request context that the middleware fills in.
+package decisionctx
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/Kong/go-pdk"
+)
+
+const (
+	// SharedKey is the kong.ctx.shared key holding the JSON-encoded Record.
+	SharedKey = "paz_decision_context"
+
+	// SchemaVersion is the version of the Record format. Fields may be added within a version;
+	// renaming or removing a field, or changing its meaning, increments it.
+	SchemaVersion = 1
+)
+
+// Decisions reported in Phase.Decision.
+const (
+	// DecisionAllow means the request or response was passed on, possibly modified by the policy
+	// or let through by fail-open.
+	DecisionAllow = "allow"
+	// DecisionDeny means the policy, or a local policy such as require_client_certificate, rejected it.
+	DecisionDeny = "deny"
+	// DecisionSkip means the plugin did not evaluate it (skip_expression or response phase filters).
+	DecisionSkip = "skip"
+	// DecisionError means the plugin ended the request because evaluation failed.
+	DecisionError = "error"
+)
+
+// Reasons reported in Phase.Reason for requests and responses the plugin denied without asking
+// the policy.
+const (
+	ReasonClientCertificate  = "client_certificate"
+	ReasonToolArguments      = "tool_arguments"
+	ReasonPromptInjection    = "prompt_injection"
+	ReasonResource           = "resource"
+	ReasonEvaluationRule     = "evaluation_rule"
+	ReasonToolPolicy         = "tool_policy"
+	ReasonResponseIDMismatch = "response_id_mismatch"
+)
+
+// Record is the authorization context of one request.
+type Record struct {
+	Version  int     `json:"version"`
+	Request  Request `json:"request"`
+	MCP      *MCP    `json:"mcp,omitempty"`
+	Access   Phase   `json:"access"`
+	Response *Phase  `json:"response,omitempty"`
+}
+
+// Request summarizes the client request as it was sent to the access phase.
+type Request struct {
+	Method   string `json:"method"`
+	URL      string `json:"url"`
+	SourceIP string `json:"source_ip"`
+	Consumer string `json:"consumer,omitempty"`
+}
+
+// MCP holds the JSON-RPC fields of an MCP request.
+type MCP struct {
+	Method  string          `json:"method"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Tool    string          `json:"tool,omitempty"`
+	Session string          `json:"session,omitempty"`
+}
+
+// Phase is the outcome of the access or response phase.
+type Phase struct {
+	Decision string `json:"decision"`
+	// Reason names the local check that denied the request or response, one of the Reason
+	// constants. It is empty for decisions of the policy.
+	Reason string `json:"reason,omitempty"`
+	// StatusCode is the status sent to the client when the plugin ended the request, or the
+	// final response status in the response phase.
+	StatusCode int `json:"status_code,omitempty"`
+	// Obligations lists what the policy changed: "method", "url", "headers" and "body" for
+	// requests, "status", "headers" and "body" for responses.
+	Obligations []string `json:"obligations,omitempty"`
+	// LatencyMs is the duration of the sideband call, including retries and throttling waits.
+	LatencyMs *float64 `json:"latency_ms,omitempty"`
+
+	// Cached is set when the access decision was served from the decision cache.
+	Cached bool `json:"cached,omitempty"`
+	// Shadow is set when shadow_mode recorded the decision without enforcing it.
+	Shadow bool `json:"shadow,omitempty"`
+
+	CircuitBreakerOpen bool   `json:"circuit_breaker_open,omitempty"`
+	Throttled          bool   `json:"throttled,omitempty"`
+	FailOpen           bool   `json:"fail_open,omitempty"`
+	Error              string `json:"error,omitempty"`
+}
+
+// Parse decodes a Record, rejecting records from a newer schema version.
+func Parse(data []byte) (*Record, error) {
+	var r Record
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("failed to parse decision context: %w", err)
+	}
+	if r.Version < 1 || r.Version > SchemaVersion {
+		return nil, fmt.Errorf("unsupported decision context version %d", r.Version)
+	}
+	return &r, nil
+}
+
+// FromKong reads the Record of the current request from kong.ctx.shared. It returns nil and no
+// error when the plugin has not evaluated the request.
+func FromKong(kong *pdk.PDK) (*Record, error) {
+	data, err := kong.Ctx.GetSharedString(SharedKey)
+	if err != nil || data == "" {
+		return nil, nil
+	}
+	return Parse([]byte(data))
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying an empty Record for the net/http middleware to fill in.
+// Read the Record after the middleware's handler returns.
+func NewContext(ctx context.Context) (context.Context, *Record) {
+	r := &Record{}
+	return context.WithValue(ctx, contextKey{}, r), r
+}
+
+// FromContext returns the Record attached by NewContext, or nil.
+func FromContext(ctx context.Context) *Record {
+	r, _ := ctx.Value(contextKey{}).(*Record)
+	return r
+}
@@ -0,0 +1,60 @@
+package decisionctx
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{"current version", `{"version":1,"request":{"method":"GET"},"access":{"decision":"allow"}}`, false},
+		{"unknown fields", `{"version":1,"added_later":true,"access":{"decision":"deny"}}`, false},
+		{"newer version", `{"version":2,"access":{"decision":"allow"}}`, true},
+		{"missing version", `{"access":{"decision":"allow"}}`, true},
+		{"not JSON", `nope`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRecord_RoundTrip(t *testing.T) {
+	latency := 12.5
+	record := &Record{
+		Version: SchemaVersion,
+		Request: Request{Method: "POST", URL: "http://api.example.com:80/mcp", SourceIP: "10.0.0.1", Consumer: "alice"},
+		MCP:     &MCP{Method: "tools/call", ID: json.RawMessage(`7`), Tool: "search"},
+		Access:  Phase{Decision: DecisionAllow, Obligations: []string{"headers"}, LatencyMs: &latency},
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.MCP.Tool != "search" || string(got.MCP.ID) != "7" || *got.Access.LatencyMs != latency || got.Response != nil {
+		t.Errorf("record did not round-trip: %s", data)
+	}
+}
+
+func TestNewContext(t *testing.T) {
+	if FromContext(context.Background()) != nil {
+		t.Error("expected no record on a plain context")
+	}
+	ctx, record := NewContext(context.Background())
+	if FromContext(ctx) != record {
+		t.Error("FromContext should return the record attached by NewContext")
+	}
+}
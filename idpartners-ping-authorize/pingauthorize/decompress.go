@@ -0,0 +1,262 @@
+package pingauthorize
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// defaultMaxDecompressedBodyBytes is the decompressed size cap used when max_decompressed_body_bytes is unset.
+const defaultMaxDecompressedBodyBytes = 8 << 20
+
+// contentDecoders maps Content-Encoding tokens to decoders for request and response bodies.
+// The returned ReadCloser is closed once the body has been read. deflate is the zlib format of
+// RFC 9110, not raw deflate.
+var contentDecoders = map[string]func(r io.Reader, maxBytes int) (io.ReadCloser, error){
+	"gzip": func(r io.Reader, _ int) (io.ReadCloser, error) {
+		return gzip.NewReader(r)
+	},
+	"deflate": func(r io.Reader, _ int) (io.ReadCloser, error) {
+		return zlib.NewReader(r)
+	},
+	"br": func(r io.Reader, _ int) (io.ReadCloser, error) {
+		return io.NopCloser(brotli.NewReader(r)), nil
+	},
+	"zstd": func(r io.Reader, maxBytes int) (io.ReadCloser, error) {
+		dec, err := zstd.NewReader(r,
+			zstd.WithDecoderConcurrency(1),
+			zstd.WithDecoderMaxMemory(uint64(maxBytes)+1),
+		)
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	},
+}
+
+// DecodeContentEncoding decodes body according to a Content-Encoding header value. Multiple
+// codings are undone in reverse order of application. Returns the body unchanged and
+// decoded=false for identity, empty or unsupported codings. Decoding stops with an error once
+// the output exceeds maxBytes.
+func DecodeContentEncoding(body []byte, contentEncoding string, maxBytes int) (decoded []byte, ok bool, err error) {
+	codings := parseCodings(contentEncoding)
+	for _, c := range codings {
+		if _, supported := contentDecoders[c]; !supported {
+			return body, false, nil
+		}
+	}
+	if len(codings) == 0 || len(body) == 0 {
+		return body, false, nil
+	}
+
+	decoded = body
+	for i := len(codings) - 1; i >= 0; i-- {
+		decoded, err = decodeOne(decoded, codings[i], maxBytes)
+		if err != nil {
+			return body, false, err
+		}
+	}
+	return decoded, true, nil
+}
+
+// decodeOne undoes a single content coding, reading at most maxBytes of output.
+func decodeOne(body []byte, coding string, maxBytes int) ([]byte, error) {
+	rc, err := contentDecoders[coding](bytes.NewReader(body), maxBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s decoder: %w", coding, err)
+	}
+	defer rc.Close()
+
+	out, err := io.ReadAll(io.LimitReader(rc, int64(maxBytes)+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s body: %w", coding, err)
+	}
+	if len(out) > maxBytes {
+		return nil, fmt.Errorf("decoded %s body exceeds %d bytes", coding, maxBytes)
+	}
+	return out, nil
+}
+
+// contentEncoders maps Content-Encoding tokens to encoders used to restore the original coding
+// of a policy-modified body.
+var contentEncoders = map[string]func(w io.Writer) (io.WriteCloser, error){
+	"gzip": func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriter(w), nil
+	},
+	"deflate": func(w io.Writer) (io.WriteCloser, error) {
+		return zlib.NewWriter(w), nil
+	},
+	"br": func(w io.Writer) (io.WriteCloser, error) {
+		return brotli.NewWriter(w), nil
+	},
+	"zstd": func(w io.Writer) (io.WriteCloser, error) {
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	},
+}
+
+// encodedBody records a body that was decompressed for the sideband payload.
+type encodedBody struct {
+	contentEncoding string
+	codings         []string // in order of application
+	raw             []byte
+	decoded         []byte
+}
+
+// decodeResponseBody decompresses an upstream response body for the response phase payload when
+// decompress_response_body is enabled. When the body is decoded, the returned headers omit
+// Content-Encoding and Content-Length so PingAuthorize sees a consistent identity response, and
+// the returned encodedBody allows the coding to be restored toward the client.
+// On failure the original body and headers are returned with the error.
+func decodeResponseBody(conf *Config, headers map[string][]string, body []byte) ([]byte, map[string][]string, *encodedBody, error) {
+	if !conf.DecompressResponseBody {
+		return body, headers, nil, nil
+	}
+	return decodeBody(conf, headers, body)
+}
+
+// decodeRequestBody decompresses a client request body for the access payload when
+// decompress_request_body is enabled, like decodeResponseBody.
+func decodeRequestBody(conf *Config, headers map[string][]string, body []byte) ([]byte, map[string][]string, *encodedBody, error) {
+	if !conf.DecompressRequestBody {
+		return body, headers, nil, nil
+	}
+	return decodeBody(conf, headers, body)
+}
+
+func decodeBody(conf *Config, headers map[string][]string, body []byte) ([]byte, map[string][]string, *encodedBody, error) {
+	var contentEncoding []string
+	for name, values := range headers {
+		if strings.EqualFold(name, "Content-Encoding") {
+			contentEncoding = append(contentEncoding, values...)
+		}
+	}
+	if len(contentEncoding) == 0 {
+		return body, headers, nil, nil
+	}
+
+	maxBytes := conf.MaxDecompressedBodyBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxDecompressedBodyBytes
+	}
+	joined := strings.Join(contentEncoding, ",")
+	decoded, ok, err := DecodeContentEncoding(body, joined, maxBytes)
+	if err != nil || !ok {
+		return body, headers, nil, err
+	}
+
+	stripped := make(map[string][]string, len(headers))
+	for name, values := range headers {
+		lower := strings.ToLower(name)
+		if lower == "content-encoding" || lower == "content-length" {
+			continue
+		}
+		stripped[name] = values
+	}
+	enc := &encodedBody{contentEncoding: joined, codings: parseCodings(joined), raw: body, decoded: decoded}
+	return decoded, stripped, enc, nil
+}
+
+// parseCodings splits a Content-Encoding value into lowercased codings, dropping identity.
+func parseCodings(contentEncoding string) []string {
+	var codings []string
+	for _, c := range strings.Split(contentEncoding, ",") {
+		c = strings.ToLower(strings.TrimSpace(c))
+		if c != "" && c != "identity" {
+			codings = append(codings, c)
+		}
+	}
+	return codings
+}
+
+// restore re-applies the upstream content coding to the body returned by the policy provider when
+// recompress_response_body is enabled. An unmodified body is replaced by the original upstream
+// bytes; a modified body is re-compressed. Responses whose headers already set Content-Encoding,
+// and bodies that cannot be re-encoded, are returned unchanged (identity).
+func (e *encodedBody) restore(conf *Config, body []byte, headers map[string][]string) ([]byte, map[string][]string) {
+	if e == nil || !conf.RecompressResponseBody {
+		return body, headers
+	}
+	return e.reapply(body, headers)
+}
+
+// reapply re-applies the original content coding to body, see restore. The returned headers
+// omit Content-Length.
+func (e *encodedBody) reapply(body []byte, headers map[string][]string) ([]byte, map[string][]string) {
+	for name := range headers {
+		if strings.EqualFold(name, "Content-Encoding") {
+			return body, headers
+		}
+	}
+
+	out := e.raw
+	if !bytes.Equal(body, e.decoded) {
+		encoded, err := encodeContent(body, e.codings)
+		if err != nil {
+			return body, headers
+		}
+		out = encoded
+	}
+
+	restored := make(map[string][]string, len(headers)+1)
+	for name, values := range headers {
+		if !strings.EqualFold(name, "Content-Length") {
+			restored[name] = values
+		}
+	}
+	restored["content-encoding"] = []string{e.contentEncoding}
+	return out, restored
+}
+
+// restoreRequestCoding returns the request the policy provider returned with the client's content
+// coding re-applied, when the body was decompressed for the access payload. An unmodified or
+// omitted body is replaced by the client's bytes and a modified one is re-compressed, keeping
+// Content-Encoding and setting Content-Length. A body that cannot be re-encoded is forwarded as
+// identity. resp itself, which may be cached, is not changed.
+func restoreRequestCoding(payload *SidebandAccessRequest, resp *SidebandAccessResponse) *SidebandAccessResponse {
+	if payload.encoded == nil {
+		return resp
+	}
+	body := payload.encoded.decoded
+	if resp.Body != nil {
+		body = []byte(*resp.Body)
+	}
+	out, headers := payload.encoded.reapply(body, FlattenHeaders(resp.Headers))
+	headers["content-length"] = []string{strconv.Itoa(len(out))}
+	formatted, err := FormatHeaders(headers)
+	if err != nil {
+		return resp
+	}
+	restored, restoredBody := *resp, string(out)
+	restored.Body, restored.Headers = &restoredBody, formatted
+	return &restored
+}
+
+// encodeContent applies codings to body in order.
+func encodeContent(body []byte, codings []string) ([]byte, error) {
+	for _, coding := range codings {
+		newEncoder, ok := contentEncoders[coding]
+		if !ok {
+			return nil, fmt.Errorf("no encoder for %s", coding)
+		}
+		var buf bytes.Buffer
+		w, err := newEncoder(&buf)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(body); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		body = buf.Bytes()
+	}
+	return body, nil
+}
@@ -0,0 +1,353 @@
+package pingauthorize
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+func brotliEncode(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := brotli.NewWriter(&buf)
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+func zstdEncode(t *testing.T, data []byte) []byte {
+	t.Helper()
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+	return enc.EncodeAll(data, nil)
+}
+
+func gzipEncode(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+func deflateEncode(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+func TestDecodeContentEncoding(t *testing.T) {
+	plain := []byte(`{"items":[1,2,3]}`)
+
+	tests := []struct {
+		name     string
+		body     []byte
+		encoding string
+		want     []byte
+		decoded  bool
+	}{
+		{"brotli", brotliEncode(t, plain), "br", plain, true},
+		{"zstd", zstdEncode(t, plain), "zstd", plain, true},
+		{"gzip", gzipEncode(t, plain), "gzip", plain, true},
+		{"deflate", deflateEncode(t, plain), "deflate", plain, true},
+		{"case and spaces", zstdEncode(t, plain), " ZSTD ", plain, true},
+		{"stacked", zstdEncode(t, brotliEncode(t, plain)), "br, zstd", plain, true},
+		{"identity", plain, "identity", plain, false},
+		{"empty", plain, "", plain, false},
+		{"unsupported", []byte("xyz"), "compress", []byte("xyz"), false},
+		{"unsupported in chain", []byte("xyz"), "br, compress", []byte("xyz"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, decoded, err := DecodeContentEncoding(tt.body, tt.encoding, 1024)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if decoded != tt.decoded || !bytes.Equal(got, tt.want) {
+				t.Errorf("got (%q, %v), want (%q, %v)", got, decoded, tt.want, tt.decoded)
+			}
+		})
+	}
+}
+
+func TestDecodeContentEncoding_SizeCap(t *testing.T) {
+	plain := bytes.Repeat([]byte("a"), 4096)
+	for _, tc := range []struct {
+		encoding string
+		body     []byte
+	}{
+		{"br", brotliEncode(t, plain)},
+		{"zstd", zstdEncode(t, plain)},
+	} {
+		got, decoded, err := DecodeContentEncoding(tc.body, tc.encoding, 1000)
+		if err == nil || decoded {
+			t.Errorf("%s: expected size cap error, got decoded=%v err=%v", tc.encoding, decoded, err)
+		}
+		if !bytes.Equal(got, tc.body) {
+			t.Errorf("%s: expected original body on error", tc.encoding)
+		}
+		if _, _, err := DecodeContentEncoding(tc.body, tc.encoding, 4096); err != nil {
+			t.Errorf("%s: body at the cap should decode: %v", tc.encoding, err)
+		}
+	}
+}
+
+func TestDecodeContentEncoding_Corrupt(t *testing.T) {
+	for _, encoding := range []string{"gzip", "deflate", "br", "zstd"} {
+		if _, _, err := DecodeContentEncoding([]byte("not compressed"), encoding, 1024); err == nil {
+			t.Errorf("%s: expected error for corrupt body", encoding)
+		}
+	}
+}
+
+func TestDecodeResponseBody(t *testing.T) {
+	plain := []byte("hello")
+	headers := map[string][]string{
+		"Content-Encoding": {"br"},
+		"Content-Length":   {"9"},
+		"Content-Type":     {"text/plain"},
+	}
+
+	body, out, enc, err := decodeResponseBody(&Config{DecompressResponseBody: true}, headers, brotliEncode(t, plain))
+	if err != nil || string(body) != "hello" || enc == nil {
+		t.Fatalf("got %q, %v", body, err)
+	}
+	if _, ok := out["Content-Encoding"]; ok {
+		t.Error("Content-Encoding should be removed")
+	}
+	if _, ok := out["Content-Length"]; ok {
+		t.Error("Content-Length should be removed")
+	}
+	if out["Content-Type"][0] != "text/plain" {
+		t.Error("other headers should be kept")
+	}
+
+	compressed := brotliEncode(t, plain)
+	body, out, enc, _ = decodeResponseBody(&Config{DecompressResponseBody: false}, headers, compressed)
+	if !bytes.Equal(body, compressed) || len(out) != 3 || enc != nil {
+		t.Error("disabled decompression should leave body and headers unchanged")
+	}
+}
+
+func TestMiddleware_DecompressesResponseForPolicy(t *testing.T) {
+	var seen SidebandResponsePayload
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/sideband/response") {
+			json.NewDecoder(r.Body).Decode(&seen)
+			json.NewEncoder(w).Encode(SidebandResponseResult{ResponseCode: "200", Body: seen.Body, Headers: seen.Headers})
+			return
+		}
+		var req SidebandAccessRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(SidebandAccessResponse{Method: req.Method, URL: req.URL, Headers: req.Headers})
+	})
+	defer server.Close()
+
+	conf := &Config{
+		ServiceURL:             server.URL,
+		SharedSecret:           "test-secret",
+		SecretHeaderName:       "X-Secret",
+		DecompressResponseBody: true,
+	}
+	m, err := NewMiddleware(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := []byte(`{"ok":true}`)
+
+	tests := []struct {
+		encoding string
+		body     []byte
+	}{
+		{"gzip", gzipEncode(t, plain)},
+		{"deflate", deflateEncode(t, plain)},
+		{"br", brotliEncode(t, plain)},
+		{"zstd", zstdEncode(t, plain)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.encoding, func(t *testing.T) {
+			seen = SidebandResponsePayload{}
+			upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Encoding", tt.encoding)
+				w.Write(tt.body)
+			})
+
+			rec := httptest.NewRecorder()
+			m.Handler(upstream).ServeHTTP(rec, httptest.NewRequest("GET", "http://api.example.com/", nil))
+
+			if seen.Body != `{"ok":true}` {
+				t.Errorf("expected decompressed body in payload, got %q", seen.Body)
+			}
+			for _, h := range seen.Headers {
+				if _, ok := h["content-encoding"]; ok {
+					t.Error("payload should not carry content-encoding for a decoded body")
+				}
+			}
+			if rec.Body.String() != `{"ok":true}` || rec.Header().Get("Content-Encoding") != "" {
+				t.Errorf("unexpected client response: %q %v", rec.Body.String(), rec.Header())
+			}
+		})
+	}
+}
+
+func TestEncodedBodyRestore(t *testing.T) {
+	plain := []byte(`{"ok":true}`)
+	raw := zstdEncode(t, brotliEncode(t, plain))
+	enc := &encodedBody{contentEncoding: "br, zstd", codings: []string{"br", "zstd"}, raw: raw, decoded: plain}
+	on := &Config{RecompressResponseBody: true}
+
+	body, headers := enc.restore(on, plain, map[string][]string{"Content-Length": {"11"}, "Content-Type": {"application/json"}})
+	if !bytes.Equal(body, raw) {
+		t.Error("unmodified body should be replaced by the upstream bytes")
+	}
+	if headers["content-encoding"][0] != "br, zstd" || headers["Content-Length"] != nil || headers["Content-Type"] == nil {
+		t.Errorf("unexpected headers: %v", headers)
+	}
+
+	modified := []byte(`{"ok":false}`)
+	body, headers = enc.restore(on, modified, map[string][]string{})
+	got, decoded, err := DecodeContentEncoding(body, headers["content-encoding"][0], 1024)
+	if err != nil || !decoded || !bytes.Equal(got, modified) {
+		t.Errorf("modified body should be re-encoded, got %q decoded=%v err=%v", got, decoded, err)
+	}
+
+	policySet := map[string][]string{"Content-Encoding": {"identity"}}
+	if body, _ := enc.restore(on, modified, policySet); !bytes.Equal(body, modified) {
+		t.Error("body should be left alone when the policy sets Content-Encoding")
+	}
+	if body, headers := enc.restore(&Config{}, modified, map[string][]string{}); !bytes.Equal(body, modified) || len(headers) != 0 {
+		t.Error("restore should be a no-op when recompress_response_body is disabled")
+	}
+	var none *encodedBody
+	if body, _ := none.restore(on, modified, nil); !bytes.Equal(body, modified) {
+		t.Error("nil encodedBody should be a no-op")
+	}
+}
+
+func TestMiddleware_RecompressesResponseForClient(t *testing.T) {
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/sideband/response") {
+			var seen SidebandResponsePayload
+			json.NewDecoder(r.Body).Decode(&seen)
+			json.NewEncoder(w).Encode(SidebandResponseResult{ResponseCode: "200", Body: seen.Body, Headers: seen.Headers})
+			return
+		}
+		var req SidebandAccessRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(SidebandAccessResponse{Method: req.Method, URL: req.URL, Headers: req.Headers})
+	})
+	defer server.Close()
+
+	conf := &Config{
+		ServiceURL:             server.URL,
+		SharedSecret:           "test-secret",
+		SecretHeaderName:       "X-Secret",
+		DecompressResponseBody: true,
+		RecompressResponseBody: true,
+	}
+	m, err := NewMiddleware(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed := brotliEncode(t, []byte(`{"ok":true}`))
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "br")
+		w.Write(compressed)
+	})
+
+	req := httptest.NewRequest("GET", "http://api.example.com/", nil)
+	req.Header.Set("Accept-Encoding", "br")
+	rec := httptest.NewRecorder()
+	m.Handler(upstream).ServeHTTP(rec, req)
+
+	if !bytes.Equal(rec.Body.Bytes(), compressed) || rec.Header().Get("Content-Encoding") != "br" {
+		t.Errorf("expected the upstream br body, got %q %v", rec.Body.String(), rec.Header())
+	}
+}
+
+func TestMiddleware_DecompressesRequestForPolicy(t *testing.T) {
+	var seen SidebandAccessRequest
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		seen = SidebandAccessRequest{}
+		json.NewDecoder(r.Body).Decode(&seen)
+		body := seen.Body
+		if strings.Contains(body, "secret") {
+			body = `{"card":"****"}`
+		}
+		json.NewEncoder(w).Encode(SidebandAccessResponse{Method: seen.Method, URL: seen.URL, Body: &body, Headers: seen.Headers})
+	})
+	defer server.Close()
+
+	conf := NewConfig()
+	conf.ServiceURL = server.URL
+	conf.SharedSecret = "test-secret"
+	conf.SecretHeaderName = "X-Secret"
+	conf.SkipResponsePhase = true
+	m, err := NewMiddleware(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var upstreamBody []byte
+	var upstreamEncoding string
+	h := m.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamBody, _ = io.ReadAll(r.Body)
+		upstreamEncoding = r.Header.Get("Content-Encoding")
+	}))
+
+	tests := []struct {
+		name         string
+		body         []byte
+		wantCode     int
+		wantSideband string
+		wantUpstream string
+		wantRaw      bool // the client's bytes are forwarded as is
+	}{
+		{"unmodified", gzipEncode(t, []byte(`{"card":"4111"}`)), 200, `{"card":"4111"}`, `{"card":"4111"}`, true},
+		{"modified", gzipEncode(t, []byte(`{"card":"secret"}`)), 200, `{"card":"secret"}`, `{"card":"****"}`, false},
+		{"corrupt", []byte("not gzip"), 400, "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen, upstreamBody, upstreamEncoding = SidebandAccessRequest{}, nil, ""
+			r := httptest.NewRequest("POST", "http://api.example.com/", bytes.NewReader(tt.body))
+			r.Header.Set("Content-Encoding", "gzip")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+
+			if rec.Code != tt.wantCode || seen.Body != tt.wantSideband {
+				t.Fatalf("got %d with sideband body %q, want %d with %q", rec.Code, seen.Body, tt.wantCode, tt.wantSideband)
+			}
+			for _, h := range seen.Headers {
+				if _, ok := h["content-encoding"]; ok {
+					t.Error("payload should not carry content-encoding for a decoded body")
+				}
+			}
+			if tt.wantUpstream == "" {
+				return
+			}
+			decoded, ok, err := DecodeContentEncoding(upstreamBody, upstreamEncoding, 1024)
+			if !ok || err != nil || string(decoded) != tt.wantUpstream {
+				t.Errorf("upstream received %q as %q, want gzip of %q", upstreamBody, upstreamEncoding, tt.wantUpstream)
+			}
+			if tt.wantRaw && !bytes.Equal(upstreamBody, tt.body) {
+				t.Error("an unmodified body should be forwarded as the client's bytes")
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package pingauthorize
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"html/template"
+	"mime"
+	"strconv"
+	"strings"
+)
+
+// Deny formats chosen by deny_content_negotiation.
+const (
+	denyFormatProblemJSON = "application/problem+json"
+	denyFormatProblemXML  = "application/problem+xml"
+	denyFormatHTML        = "text/html; charset=utf-8"
+)
+
+// denyFormats maps the media types clients accept to deny formats. "" keeps the policy body,
+// which is usually JSON already.
+var denyFormats = map[string]string{
+	"application/problem+json": denyFormatProblemJSON,
+	"application/json":         "",
+	"application/*":            "",
+	"*/*":                      "",
+	"application/problem+xml":  denyFormatProblemXML,
+	"application/xml":          denyFormatProblemXML,
+	"text/xml":                 denyFormatProblemXML,
+	"text/html":                denyFormatHTML,
+	"application/xhtml+xml":    denyFormatHTML,
+}
+
+// negotiateDenyFormat returns the deny format the Accept header prefers, or "" to keep the policy
+// body. Media types are ranked by q value, then by their order in the header.
+func negotiateDenyFormat(accept string) string {
+	best, bestQ := "", 0.0
+	for _, item := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(item))
+		if err != nil {
+			continue
+		}
+		format, ok := denyFormats[mediaType]
+		if !ok {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > bestQ {
+			best, bestQ = format, q
+		}
+	}
+	return best
+}
+
+// problemDetails is an RFC 7807 problem document, with the correlation id as an extension member.
+type problemDetails struct {
+	XMLName   xml.Name `json:"-" xml:"urn:ietf:rfc:7807 problem"`
+	Type      string   `json:"type" xml:"type"`
+	Title     string   `json:"title" xml:"title"`
+	Status    int      `json:"status" xml:"status"`
+	Detail    string   `json:"detail,omitempty" xml:"detail,omitempty"`
+	RequestID string   `json:"request_id,omitempty" xml:"request_id,omitempty"`
+}
+
+var denyHTMLTemplate = template.Must(template.New("deny").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Status}} {{.StatusText}}</title></head>
+<body>
+<h1>{{.Status}} {{.StatusText}}</h1>
+{{if .Message}}<p>{{.Message}}</p>
+{{end}}{{if .CorrelationID}}<p>Request ID: <code>{{.CorrelationID}}</code></p>
+{{end}}</body>
+</html>
+`))
+
+// formatDeny renders a deny in one of the deny formats.
+func formatDeny(format string, data DenyTemplateData) ([]byte, error) {
+	if format == denyFormatHTML {
+		var buf bytes.Buffer
+		err := denyHTMLTemplate.Execute(&buf, data)
+		return buf.Bytes(), err
+	}
+
+	problem := problemDetails{
+		Type:      "about:blank",
+		Title:     data.StatusText,
+		Status:    data.Status,
+		Detail:    data.Message,
+		RequestID: data.CorrelationID,
+	}
+	if format == denyFormatProblemXML {
+		body, err := xml.Marshal(problem)
+		return append([]byte(xml.Header), body...), err
+	}
+	return json.Marshal(problem)
+}
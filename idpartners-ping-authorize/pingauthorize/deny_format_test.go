@@ -0,0 +1,98 @@
+package pingauthorize
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateDenyFormat(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", ""},
+		{"*/*", ""},
+		{"application/json", ""},
+		{"application/problem+json", denyFormatProblemJSON},
+		{"application/xml", denyFormatProblemXML},
+		{"text/xml;q=0.9, application/problem+xml", denyFormatProblemXML},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", denyFormatHTML},
+		{"application/json;q=0.5, text/html;q=0.8", denyFormatHTML},
+		{"text/html;q=0, application/problem+json;q=0.1", denyFormatProblemJSON},
+		{"image/png", ""},
+		{"application/json, text/event-stream", ""},
+	}
+	for _, tt := range tests {
+		if got := negotiateDenyFormat(tt.accept); got != tt.want {
+			t.Errorf("negotiateDenyFormat(%q) = %q, want %q", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestFormatDeny(t *testing.T) {
+	data := DenyTemplateData{Status: 403, StatusText: "Forbidden", Message: "<script>alert(1)</script>", CorrelationID: "req-1"}
+
+	tests := []struct {
+		format string
+		want   []string
+	}{
+		{denyFormatProblemJSON, []string{`{"type":"about:blank","title":"Forbidden","status":403,"detail":"\u003cscript\u003ealert(1)\u003c/script\u003e","request_id":"req-1"}`}},
+		{denyFormatProblemXML, []string{`<?xml version="1.0"`, `<problem xmlns="urn:ietf:rfc:7807"><type>about:blank</type><title>Forbidden</title><status>403</status><detail>&lt;script&gt;alert(1)&lt;/script&gt;</detail><request_id>req-1</request_id></problem>`}},
+		{denyFormatHTML, []string{`<title>403 Forbidden</title>`, `&lt;script&gt;alert(1)&lt;/script&gt;`, `<code>req-1</code>`}},
+	}
+	for _, tt := range tests {
+		body, err := formatDeny(tt.format, data)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.format, err)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(string(body), want) {
+				t.Errorf("%s: body %s does not contain %s", tt.format, body, want)
+			}
+		}
+	}
+}
+
+func TestMiddleware_DenyContentNegotiation(t *testing.T) {
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(SidebandAccessResponse{Response: &DenyResponse{
+			ResponseCode: "403",
+			Body:         `{"code":"FORBIDDEN","message":"Not allowed"}`,
+			Headers:      []map[string]string{{"content-type": "application/json"}},
+		}})
+	})
+	defer server.Close()
+
+	tests := []struct {
+		name            string
+		negotiation     bool
+		accept          string
+		wantContentType string
+		wantBody        string
+	}{
+		{"disabled", false, "text/html", "application/json", `"message":"Not allowed"`},
+		{"json client", true, "application/json", "application/json", `"message":"Not allowed"`},
+		{"problem json", true, "application/problem+json", denyFormatProblemJSON, `"detail":"Not allowed"`},
+		{"browser", true, "text/html,*/*;q=0.8", denyFormatHTML, `<p>Not allowed</p>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestMiddleware(t, server.URL, false)
+			m.conf.DenyContentNegotiation = tt.negotiation
+			calls := 0
+			h := m.Handler(upstreamEcho(t, &calls))
+
+			req := httptest.NewRequest("GET", "http://api.example.com/orders", nil)
+			req.Header.Set("Accept", tt.accept)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != 403 || rec.Header().Get("Content-Type") != tt.wantContentType || !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("got %d %q %s", rec.Code, rec.Header().Get("Content-Type"), rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package pingauthorize
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// DenyTemplateData is the data deny_body_template is executed with.
+type DenyTemplateData struct {
+	// Status is the HTTP status of the deny and StatusText its reason phrase.
+	Status     int
+	StatusText string
+	// Code is the response_status of the policy response, e.g. FORBIDDEN.
+	Code string
+	// Message is the message field of a JSON policy body, or the body itself.
+	Message string
+	// Body is the raw body the policy provider returned.
+	Body string
+	// CorrelationID is the value of the audit_log_request_id_header request header.
+	CorrelationID string
+	// Timestamp is the time of the deny in RFC 3339 format, UTC.
+	Timestamp string
+}
+
+// denyTemplateFuncs are the functions available to deny_body_template in addition to the text/template
+// builtins. json encodes a value, so strings can be embedded in JSON bodies safely.
+var denyTemplateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// compileDenyTemplate parses deny_body_template. Returns nil when it is empty.
+func compileDenyTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	tmpl, err := template.New("deny_body_template").Funcs(denyTemplateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("deny_body_template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// getDenyTemplate returns the compiled deny_body_template, or nil when none is configured.
+// An invalid template is rejected by Validate, so it is treated as none here.
+func (c *Config) getDenyTemplate() *template.Template {
+	c.denyTemplateOnce.Do(func() {
+		c.denyTemplate, _ = compileDenyTemplate(c.DenyBodyTemplate)
+	})
+	return c.denyTemplate
+}
+
+// renderDeny returns the body and headers sent to the client for a policy deny with status. The
+// body is rendered from deny_body_template when it is set, or else in the format the Accept header
+// asks for with deny_content_negotiation. deny_headers are set over the headers of the policy
+// response. If rendering fails, the policy body is sent.
+func renderDeny(conf *Config, status int, deny *DenyResponse, header func(string) string, logger *PluginLogger) ([]byte, map[string][]string) {
+	body, headers := []byte(deny.Body), FlattenHeaders(deny.Headers)
+	data := DenyTemplateData{
+		Status:     status,
+		StatusText: http.StatusText(status),
+		Code:       deny.ResponseStatus,
+		Message:    denyMessage(deny.Body),
+		Body:       deny.Body,
+		Timestamp:  time.Now().UTC().Format(time.RFC3339),
+	}
+	if conf.AuditLogRequestIDHeader != "" {
+		data.CorrelationID = header(conf.AuditLogRequestIDHeader)
+	}
+
+	if tmpl := conf.getDenyTemplate(); tmpl != nil {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			logger.Warn("Failed to render deny_body_template, sending the policy body", "error", err.Error())
+		} else {
+			body = buf.Bytes()
+			delete(headers, "content-length")
+		}
+	} else if conf.DenyContentNegotiation {
+		if format := negotiateDenyFormat(header("Accept")); format != "" {
+			if formatted, err := formatDeny(format, data); err != nil {
+				logger.Warn("Failed to format deny response, sending the policy body", "format", format, "error", err.Error())
+			} else {
+				if headers == nil {
+					headers = make(map[string][]string)
+				}
+				body, headers["content-type"] = formatted, []string{format}
+				delete(headers, "content-length")
+			}
+		}
+	}
+
+	for name, value := range conf.DenyHeaders {
+		if headers == nil {
+			headers = make(map[string][]string, len(conf.DenyHeaders))
+		}
+		headers[strings.ToLower(name)] = []string{value}
+	}
+	return body, headers
+}
+
+// denyMessage returns the message (or error_description) of a JSON deny body, or the body itself.
+func denyMessage(body string) string {
+	var parsed struct {
+		Message          string `json:"message"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if json.Unmarshal([]byte(body), &parsed) == nil {
+		if parsed.Message != "" {
+			return parsed.Message
+		}
+		if parsed.ErrorDescription != "" {
+			return parsed.ErrorDescription
+		}
+	}
+	return body
+}
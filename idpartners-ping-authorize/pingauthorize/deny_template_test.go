@@ -0,0 +1,113 @@
+package pingauthorize
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRenderDeny(t *testing.T) {
+	deny := &DenyResponse{
+		ResponseCode:   "403",
+		ResponseStatus: "FORBIDDEN",
+		Body:           `{"code":"FORBIDDEN","message":"Scope \"orders:write\" required"}`,
+		Headers:        []map[string]string{{"content-type": "application/json"}, {"content-length": "60"}, {"x-paz": "1"}},
+	}
+	header := func(name string) string {
+		if name == "X-Request-Id" {
+			return "req-42"
+		}
+		return ""
+	}
+
+	tests := []struct {
+		name        string
+		conf        *Config
+		wantBody    string
+		wantHeaders map[string]string
+	}{
+		{
+			"no template",
+			&Config{},
+			deny.Body,
+			map[string]string{"content-type": "application/json", "content-length": "60", "x-paz": "1"},
+		},
+		{
+			"template and headers",
+			&Config{
+				DenyBodyTemplate:        `{"status":{{.Status}},"title":{{json .StatusText}},"detail":{{json .Message}},"trace":{{json .CorrelationID}},"type":{{json .Code}}}`,
+				DenyHeaders:             map[string]string{"Content-Type": "application/problem+json", "Cache-Control": "no-store"},
+				AuditLogRequestIDHeader: "X-Request-Id",
+			},
+			`{"status":403,"title":"Forbidden","detail":"Scope \"orders:write\" required","trace":"req-42","type":"FORBIDDEN"}`,
+			map[string]string{"content-type": "application/problem+json", "cache-control": "no-store", "x-paz": "1"},
+		},
+		{
+			"failing template",
+			&Config{DenyBodyTemplate: `{{.Missing}}`},
+			deny.Body,
+			map[string]string{"content-type": "application/json", "content-length": "60", "x-paz": "1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, headers := renderDeny(tt.conf, 403, deny, header, NewPluginLogger(nil, "access", ""))
+			if string(body) != tt.wantBody {
+				t.Errorf("body = %s, want %s", body, tt.wantBody)
+			}
+			if len(headers) != len(tt.wantHeaders) {
+				t.Errorf("headers = %v, want %v", headers, tt.wantHeaders)
+			}
+			for name, want := range tt.wantHeaders {
+				if got := headers[name]; len(got) != 1 || got[0] != want {
+					t.Errorf("header %s = %v, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDenyMessage(t *testing.T) {
+	tests := map[string]string{
+		`{"message":"Denied"}`: "Denied",
+		`{"error":"invalid_token","error_description":"Expired"}`: "Expired",
+		`Access denied`: "Access denied",
+		``:              "",
+	}
+	for body, want := range tests {
+		if got := denyMessage(body); got != want {
+			t.Errorf("denyMessage(%q) = %q, want %q", body, got, want)
+		}
+	}
+}
+
+func TestCompileDenyTemplate_Invalid(t *testing.T) {
+	if _, err := compileDenyTemplate(`{{.Status`); err == nil || !strings.Contains(err.Error(), "deny_body_template") {
+		t.Errorf("expected deny_body_template error, got %v", err)
+	}
+}
+
+func TestMiddleware_DenyTemplate(t *testing.T) {
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(SidebandAccessResponse{Response: &DenyResponse{ResponseCode: "401", Body: `{"message":"Token expired"}`}})
+	})
+	defer server.Close()
+
+	m := newTestMiddleware(t, server.URL, false)
+	m.conf.DenyBodyTemplate = `{"error":{"status":{{.Status}},"message":{{json .Message}},"request_id":{{json .CorrelationID}}}}`
+	m.conf.DenyHeaders = map[string]string{"Content-Type": "application/json"}
+	calls := 0
+	h := m.Handler(upstreamEcho(t, &calls))
+
+	req := httptest.NewRequest("GET", "http://api.example.com/orders", nil)
+	req.Header.Set("X-Request-Id", "abc")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	want := `{"error":{"status":401,"message":"Token expired","request_id":"abc"}}`
+	if rec.Code != 401 || rec.Body.String() != want || rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("got %d %s %v, want %s", rec.Code, rec.Body.String(), rec.Header(), want)
+	}
+}
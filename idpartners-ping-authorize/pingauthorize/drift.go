@@ -0,0 +1,193 @@
+package pingauthorize
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// maxTrackedToolSets caps the number of route/session keys the drift tracker remembers.
+const maxTrackedToolSets = 10000
+
+// ToolsDriftEvent describes a change in the tool set a tools/list response advertised for one
+// route and MCP session. It is logged as an audit record and posted to tools_drift_webhook_url.
+type ToolsDriftEvent struct {
+	Event        string    `json:"event"`
+	Route        string    `json:"route"`
+	Session      string    `json:"session,omitempty"`
+	PreviousHash string    `json:"previous_hash"`
+	Hash         string    `json:"hash"`
+	Added        []string  `json:"added,omitempty"`
+	Removed      []string  `json:"removed,omitempty"`
+	Changed      []string  `json:"changed,omitempty"`
+	Time         time.Time `json:"time"`
+}
+
+// toolSet is the digest of one tools/list result: a hash per tool name and a hash of the whole set.
+type toolSet struct {
+	hash  string
+	tools map[string]string
+}
+
+// toolsDriftTracker remembers the last tool set seen per route/session key.
+type toolsDriftTracker struct {
+	mu   sync.Mutex
+	seen map[string]toolSet
+}
+
+// globalToolsDrift is shared by all plugin configs; keys include the route so configs don't collide.
+var globalToolsDrift = &toolsDriftTracker{seen: make(map[string]toolSet)}
+
+var (
+	driftCounterOnce sync.Once
+	driftCounter     metric.Int64Counter
+)
+
+// toolsDriftCounter returns the drift counter from the global meter provider (a no-op until OTel is initialized).
+func toolsDriftCounter() metric.Int64Counter {
+	driftCounterOnce.Do(func() {
+		driftCounter, _ = otel.Meter(PluginName).Int64Counter("ping_authorize_mcp_tools_drift_total",
+			metric.WithDescription("Changes in the tool set advertised by MCP tools/list responses"))
+	})
+	return driftCounter
+}
+
+// observe records set for key and returns the previous set when it differs, or nil when the key
+// is new or the set is unchanged. Once full, an arbitrary key is evicted to make room.
+func (t *toolsDriftTracker) observe(key string, set toolSet) (previous *toolSet) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	prev, ok := t.seen[key]
+	if !ok && len(t.seen) >= maxTrackedToolSets {
+		for k := range t.seen {
+			delete(t.seen, k)
+			break
+		}
+	}
+	t.seen[key] = set
+	if !ok || prev.hash == set.hash {
+		return nil
+	}
+	return &prev
+}
+
+// parseToolsListResult digests the tools of a tools/list JSON-RPC response, which may be an SSE
+// stream. Returns false if body is not a tools/list result.
+func parseToolsListResult(body []byte, contentType string) (toolSet, bool) {
+	body = ParseSSEFinalMessage(body, contentType)
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 || trimmed[0] != '{' || jsonDepthExceeds(trimmed, maxJSONDepth) {
+		return toolSet{}, false
+	}
+
+	var resp struct {
+		Jsonrpc string `json:"jsonrpc"`
+		Result  *struct {
+			Tools []json.RawMessage `json:"tools"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(trimmed, &resp); err != nil || resp.Jsonrpc != "2.0" || resp.Result == nil {
+		return toolSet{}, false
+	}
+
+	set := toolSet{tools: make(map[string]string, len(resp.Result.Tools))}
+	for _, raw := range resp.Result.Tools {
+		var tool struct {
+			Name string `json:"name"`
+		}
+		if json.Unmarshal(raw, &tool) != nil || tool.Name == "" {
+			continue
+		}
+		var compact bytes.Buffer
+		if json.Compact(&compact, raw) != nil {
+			continue
+		}
+		sum := sha256.Sum256(compact.Bytes())
+		set.tools[tool.Name] = hex.EncodeToString(sum[:])
+	}
+
+	names := sortedKeys(set.tools)
+	h := sha256.New()
+	for _, name := range names {
+		h.Write([]byte(name + "\x00" + set.tools[name] + "\n"))
+	}
+	set.hash = hex.EncodeToString(h.Sum(nil))
+	return set, true
+}
+
+// diffToolSets lists tool names added, removed and changed between prev and cur.
+func diffToolSets(prev, cur toolSet) (added, removed, changed []string) {
+	for _, name := range sortedKeys(cur.tools) {
+		prevHash, ok := prev.tools[name]
+		switch {
+		case !ok:
+			added = append(added, name)
+		case prevHash != cur.tools[name]:
+			changed = append(changed, name)
+		}
+	}
+	for _, name := range sortedKeys(prev.tools) {
+		if _, ok := cur.tools[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	return added, removed, changed
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// checkToolsDrift compares the post-policy tools/list result for route and session with the last one
+// seen and reports a change through the log, the drift metric and tools_drift_webhook_url.
+// requestBody is the client request; other MCP methods and non-MCP traffic are ignored.
+func checkToolsDrift(conf *Config, route, session string, requestBody, responseBody []byte, contentType string, logger *PluginLogger) *ToolsDriftEvent {
+	if !conf.ToolsDriftDetection {
+		return nil
+	}
+	if req := parseMCPRequest(requestBody); req == nil || req.Method != "tools/list" {
+		return nil
+	}
+	set, ok := parseToolsListResult(responseBody, contentType)
+	if !ok {
+		return nil
+	}
+
+	prev := globalToolsDrift.observe(route+"\x00"+session, set)
+	if prev == nil {
+		return nil
+	}
+
+	event := &ToolsDriftEvent{
+		Event:        "mcp_tools_changed",
+		Route:        route,
+		Session:      session,
+		PreviousHash: prev.hash,
+		Hash:         set.hash,
+		Time:         time.Now().UTC(),
+	}
+	event.Added, event.Removed, event.Changed = diffToolSets(*prev, set)
+
+	logger.Warn("MCP tools/list changed", "audit", true, "route", route, "session", session,
+		"added", event.Added, "removed", event.Removed, "changed", event.Changed, "hash", set.hash)
+	toolsDriftCounter().Add(context.Background(), 1, metric.WithAttributes(attribute.String("route", route)))
+	if conf.ToolsDriftWebhookURL != "" {
+		go postWebhook(conf.ToolsDriftWebhookURL, event, NewPluginLogger(nil, "response", conf.ServiceURL))
+	}
+	return event
+}
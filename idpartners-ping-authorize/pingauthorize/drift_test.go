@@ -0,0 +1,115 @@
+package pingauthorize
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const toolsListRequest = `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`
+
+func toolsListResult(tools ...string) string {
+	return `{"jsonrpc":"2.0","id":1,"result":{"tools":[` + strings.Join(tools, ",") + `]}}`
+}
+
+func TestParseToolsListResult(t *testing.T) {
+	a := toolsListResult(`{"name":"search","description":"Search"}`, `{"name":"fetch"}`)
+	b := toolsListResult(`{"name":"fetch"}`, `{ "name": "search", "description": "Search" }`)
+
+	setA, ok := parseToolsListResult([]byte(a), "application/json")
+	if !ok || len(setA.tools) != 2 {
+		t.Fatalf("unexpected result: %+v %v", setA, ok)
+	}
+	setB, _ := parseToolsListResult([]byte(b), "")
+	if setA.hash != setB.hash {
+		t.Error("hash should not depend on tool order or formatting")
+	}
+
+	sse := "event: message\ndata: " + a + "\n\n"
+	if setSSE, ok := parseToolsListResult([]byte(sse), "text/event-stream"); !ok || setSSE.hash != setA.hash {
+		t.Error("SSE tools/list result should be parsed")
+	}
+
+	for _, body := range []string{"", "not json", `{"jsonrpc":"2.0","id":1,"error":{"code":-1}}`, `[1]`} {
+		if _, ok := parseToolsListResult([]byte(body), ""); ok {
+			t.Errorf("expected %q to be rejected", body)
+		}
+	}
+}
+
+func TestCheckToolsDrift(t *testing.T) {
+	conf := &Config{ToolsDriftDetection: true}
+	logger := NewPluginLogger(nil, "response", "")
+	route := t.Name()
+
+	v1 := toolsListResult(`{"name":"search"}`, `{"name":"fetch","description":"v1"}`)
+	v2 := toolsListResult(`{"name":"fetch","description":"v2"}`, `{"name":"shell"}`)
+
+	if ev := checkToolsDrift(conf, route, "s1", []byte(toolsListRequest), []byte(v1), "", logger); ev != nil {
+		t.Fatalf("first sighting should not be reported: %+v", ev)
+	}
+	if ev := checkToolsDrift(conf, route, "s1", []byte(toolsListRequest), []byte(v1), "", logger); ev != nil {
+		t.Fatalf("unchanged tool set should not be reported: %+v", ev)
+	}
+
+	ev := checkToolsDrift(conf, route, "s1", []byte(toolsListRequest), []byte(v2), "", logger)
+	if ev == nil {
+		t.Fatal("expected a drift event")
+	}
+	if !reflect.DeepEqual(ev.Added, []string{"shell"}) || !reflect.DeepEqual(ev.Removed, []string{"search"}) || !reflect.DeepEqual(ev.Changed, []string{"fetch"}) {
+		t.Errorf("unexpected diff: %+v", ev)
+	}
+	if ev.PreviousHash == ev.Hash || ev.Route != route || ev.Session != "s1" {
+		t.Errorf("unexpected event: %+v", ev)
+	}
+
+	if ev := checkToolsDrift(conf, route, "s2", []byte(toolsListRequest), []byte(v1), "", logger); ev != nil {
+		t.Error("sessions should be tracked separately")
+	}
+	toolsCall := `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"x"}}`
+	if ev := checkToolsDrift(conf, route, "s1", []byte(toolsCall), []byte(v1), "", logger); ev != nil {
+		t.Error("only tools/list requests should be tracked")
+	}
+	if ev := checkToolsDrift(&Config{}, route, "s1", []byte(toolsListRequest), []byte(v1), "", logger); ev != nil {
+		t.Error("disabled drift detection should report nothing")
+	}
+}
+
+func TestToolsDriftTracker_Bounded(t *testing.T) {
+	tracker := &toolsDriftTracker{seen: make(map[string]toolSet)}
+	for i := 0; i < maxTrackedToolSets+10; i++ {
+		tracker.observe(strconv.Itoa(i), toolSet{hash: "h"})
+	}
+	if len(tracker.seen) > maxTrackedToolSets {
+		t.Errorf("tracker grew to %d entries", len(tracker.seen))
+	}
+}
+
+func TestToolsDriftWebhook(t *testing.T) {
+	received := make(chan ToolsDriftEvent, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev ToolsDriftEvent
+		json.NewDecoder(r.Body).Decode(&ev)
+		received <- ev
+	}))
+	defer hook.Close()
+
+	conf := &Config{ToolsDriftDetection: true, ToolsDriftWebhookURL: hook.URL}
+	logger := NewPluginLogger(nil, "response", "")
+	checkToolsDrift(conf, t.Name(), "", []byte(toolsListRequest), []byte(toolsListResult(`{"name":"a"}`)), "", logger)
+	checkToolsDrift(conf, t.Name(), "", []byte(toolsListRequest), []byte(toolsListResult(`{"name":"b"}`)), "", logger)
+
+	select {
+	case ev := <-received:
+		if ev.Event != "mcp_tools_changed" || !reflect.DeepEqual(ev.Added, []string{"b"}) {
+			t.Errorf("unexpected webhook event: %+v", ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not called")
+	}
+}
@@ -0,0 +1,214 @@
+package pingauthorize
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/ext"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// DerivedAttribute defines a payload attribute computed from a CEL expression.
+type DerivedAttribute struct {
+	Name       string `json:"name"`
+	Expression string `json:"expression"`
+}
+
+// ExpressionSet holds the compiled CEL programs for one plugin configuration.
+//
+// Expressions see the following variables:
+//   - request:    map with method, url, scheme, host, path, query (map of first values),
+//     source_ip and http_version
+//   - headers:    client request headers, lowercased names, multi-values joined with ", "
+//   - body:       request body decoded as JSON, or the raw string if it is not JSON
+//   - claims:     bearer JWT claims (unverified), empty map if absent
+//   - attributes: values resolved from attribute_mappings
+type ExpressionSet struct {
+	skip    cel.Program
+	derived []derivedProgram
+}
+
+type derivedProgram struct {
+	name    string
+	program cel.Program
+}
+
+// newExpressionEnv creates the CEL environment shared by all plugin expressions, with any extra
+// variables. The CEL strings extension (split, lowerAscii, replace, ...) is enabled.
+func newExpressionEnv(extra ...cel.EnvOption) (*cel.Env, error) {
+	return cel.NewEnv(append([]cel.EnvOption{
+		ext.Strings(),
+		cel.Variable("request", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("headers", cel.MapType(cel.StringType, cel.StringType)),
+		cel.Variable("body", cel.DynType),
+		cel.Variable("claims", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("attributes", cel.MapType(cel.StringType, cel.DynType)),
+	}, extra...)...)
+}
+
+// CompileExpressions compiles the skip expression and derived attributes.
+// Returns nil (and no error) when no expressions are configured.
+func CompileExpressions(skipExpr string, derived []DerivedAttribute) (*ExpressionSet, error) {
+	if skipExpr == "" && len(derived) == 0 {
+		return nil, nil
+	}
+
+	env, err := newExpressionEnv()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CEL environment: %w", err)
+	}
+
+	set := &ExpressionSet{}
+	if skipExpr != "" {
+		prg, err := compileExpression(env, skipExpr, cel.BoolType)
+		if err != nil {
+			return nil, fmt.Errorf("skip_expression: %w", err)
+		}
+		set.skip = prg
+	}
+
+	seen := make(map[string]bool, len(derived))
+	for _, d := range derived {
+		if d.Name == "" {
+			return nil, fmt.Errorf("derived_attributes entries must have a name")
+		}
+		if seen[d.Name] {
+			return nil, fmt.Errorf("derived_attributes name %q is duplicated", d.Name)
+		}
+		seen[d.Name] = true
+		prg, err := compileExpression(env, d.Expression, nil)
+		if err != nil {
+			return nil, fmt.Errorf("derived_attributes %q: %w", d.Name, err)
+		}
+		set.derived = append(set.derived, derivedProgram{name: d.Name, program: prg})
+	}
+
+	return set, nil
+}
+
+// compileExpression compiles a single expression, optionally checking its result type.
+func compileExpression(env *cel.Env, expr string, want *cel.Type) (cel.Program, error) {
+	if expr == "" {
+		return nil, fmt.Errorf("expression must not be empty")
+	}
+	ast, iss := env.Compile(expr)
+	if iss.Err() != nil {
+		return nil, iss.Err()
+	}
+	if want != nil && !ast.OutputType().IsExactType(want) && !ast.OutputType().IsExactType(cel.DynType) {
+		return nil, fmt.Errorf("expression must evaluate to %s, got %s", want, ast.OutputType())
+	}
+	return env.Program(ast)
+}
+
+// ShouldSkip evaluates the skip expression against the access payload.
+// Returns false if no skip expression is configured.
+func (s *ExpressionSet) ShouldSkip(req *SidebandAccessRequest) (bool, error) {
+	if s == nil || s.skip == nil {
+		return false, nil
+	}
+	out, _, err := s.skip.Eval(expressionActivation(req))
+	if err != nil {
+		return false, err
+	}
+	skip, ok := out.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("skip_expression returned %s, want bool", out.Type())
+	}
+	return skip, nil
+}
+
+// DeriveAttributes evaluates the derived attribute expressions against the access payload.
+// Attributes whose expression fails (e.g. a missing key) are omitted and reported in errs.
+func (s *ExpressionSet) DeriveAttributes(req *SidebandAccessRequest) (map[string]interface{}, []error) {
+	if s == nil || len(s.derived) == 0 {
+		return nil, nil
+	}
+
+	activation := expressionActivation(req)
+	var result map[string]interface{}
+	var errs []error
+	for _, d := range s.derived {
+		out, _, err := d.program.Eval(activation)
+		if err == nil {
+			var val interface{}
+			if val, err = celValueToNative(out); err == nil {
+				if result == nil {
+					result = make(map[string]interface{}, len(s.derived))
+				}
+				result[d.name] = val
+				continue
+			}
+		}
+		errs = append(errs, fmt.Errorf("derived attribute %q: %w", d.name, err))
+	}
+	return result, errs
+}
+
+// expressionActivation builds the CEL variables for an access payload.
+// body and claims are decoded lazily since most expressions don't reference them.
+func expressionActivation(req *SidebandAccessRequest) map[string]interface{} {
+	request := map[string]interface{}{
+		"method":       req.Method,
+		"url":          req.URL,
+		"source_ip":    req.SourceIP,
+		"http_version": req.HTTPVersion,
+	}
+	query := map[string]interface{}{}
+	if u, err := url.Parse(req.URL); err == nil {
+		request["scheme"] = u.Scheme
+		request["host"] = u.Hostname()
+		request["path"] = u.Path
+		for key, values := range u.Query() {
+			if len(values) > 0 {
+				query[key] = values[0]
+			}
+		}
+	}
+	request["query"] = query
+
+	flat := FlattenHeaders(req.Headers)
+	headers := make(map[string]string, len(flat))
+	for name, values := range flat {
+		headers[name] = strings.Join(values, ", ")
+	}
+
+	attributes := req.Attributes
+	if attributes == nil {
+		attributes = map[string]interface{}{}
+	}
+
+	return map[string]interface{}{
+		"request":    request,
+		"headers":    headers,
+		"attributes": attributes,
+		"body": func() interface{} {
+			var doc interface{}
+			if err := json.Unmarshal([]byte(req.Body), &doc); err != nil {
+				return req.Body
+			}
+			return doc
+		},
+		"claims": func() interface{} {
+			claims := BearerTokenClaims(flat)
+			if claims == nil {
+				return map[string]interface{}{}
+			}
+			return claims
+		},
+	}
+}
+
+// celValueToNative converts a CEL result into plain JSON-compatible Go values.
+func celValueToNative(v ref.Val) (interface{}, error) {
+	native, err := v.ConvertToNative(reflect.TypeOf(&structpb.Value{}))
+	if err != nil {
+		return nil, err
+	}
+	return native.(*structpb.Value).AsInterface(), nil
+}
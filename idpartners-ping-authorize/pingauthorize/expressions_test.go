@@ -0,0 +1,129 @@
+package pingauthorize
+
+import (
+	"testing"
+)
+
+func testExpressionRequest() *SidebandAccessRequest {
+	return &SidebandAccessRequest{
+		SourceIP: "10.0.0.1",
+		Method:   "GET",
+		URL:      "https://api.example.com:443/accounts/42?view=full",
+		Body:     `{"amount":250,"currency":"EUR"}`,
+		Headers: []map[string]string{
+			{"host": "api.example.com"},
+			{"x-tenant-id": "acme"},
+		},
+		HTTPVersion: "1.1",
+		Attributes:  map[string]interface{}{"tenant": "acme"},
+	}
+}
+
+func TestCompileExpressions_None(t *testing.T) {
+	set, err := CompileExpressions("", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if set != nil {
+		t.Error("expected nil expression set")
+	}
+	skip, err := set.ShouldSkip(testExpressionRequest())
+	if err != nil || skip {
+		t.Errorf("nil set should never skip, got (%v, %v)", skip, err)
+	}
+}
+
+func TestCompileExpressions_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		skip    string
+		derived []DerivedAttribute
+	}{
+		{"syntax error", "request.method ==", nil},
+		{"non-bool skip", "headers['x-tenant-id']", nil},
+		{"unknown variable", "consumer.id == 'x'", nil},
+		{"derived without name", "", []DerivedAttribute{{Expression: "1"}}},
+		{"derived duplicate", "", []DerivedAttribute{{Name: "a", Expression: "1"}, {Name: "a", Expression: "2"}}},
+		{"derived empty", "", []DerivedAttribute{{Name: "a"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := CompileExpressions(tt.skip, tt.derived); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func TestExpressionSet_ShouldSkip(t *testing.T) {
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`request.method == 'GET' && !('authorization' in headers)`, true},
+		{`request.method == 'POST'`, false},
+		{`request.path.startsWith('/accounts/')`, true},
+		{`request.query.view == 'full'`, true},
+		{`headers['x-tenant-id'] == 'acme'`, true},
+		{`body.amount > 1000`, false},
+		{`attributes.tenant == 'acme'`, true},
+		{`has(claims.sub)`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			set, err := CompileExpressions(tt.expr, nil)
+			if err != nil {
+				t.Fatalf("compile error: %v", err)
+			}
+			got, err := set.ShouldSkip(testExpressionRequest())
+			if err != nil {
+				t.Fatalf("eval error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpressionSet_ShouldSkip_EvalError(t *testing.T) {
+	set, err := CompileExpressions(`headers['authorization'] == 'x'`, nil)
+	if err != nil {
+		t.Fatalf("compile error: %v", err)
+	}
+	if _, err := set.ShouldSkip(testExpressionRequest()); err == nil {
+		t.Error("expected evaluation error for missing key")
+	}
+}
+
+func TestExpressionSet_DeriveAttributes(t *testing.T) {
+	set, err := CompileExpressions("", []DerivedAttribute{
+		{Name: "is_read", Expression: `request.method in ['GET', 'HEAD']`},
+		{Name: "high_value", Expression: `body.amount > 100`},
+		{Name: "resource", Expression: `request.path.split('/')`},
+		{Name: "broken", Expression: `headers['missing']`},
+	})
+	if err != nil {
+		t.Fatalf("compile error: %v", err)
+	}
+
+	attrs, errs := set.DeriveAttributes(testExpressionRequest())
+
+	if attrs["is_read"] != true {
+		t.Errorf("is_read: got %v", attrs["is_read"])
+	}
+	if attrs["high_value"] != true {
+		t.Errorf("high_value: got %v", attrs["high_value"])
+	}
+	if parts, ok := attrs["resource"].([]interface{}); !ok || len(parts) != 3 {
+		t.Errorf("resource: got %#v", attrs["resource"])
+	}
+	if _, ok := attrs["broken"]; ok {
+		t.Error("failed expression should be omitted")
+	}
+	if len(errs) != 1 {
+		t.Errorf("expected 1 error, got %d", len(errs))
+	}
+}
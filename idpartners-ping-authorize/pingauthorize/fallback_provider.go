@@ -0,0 +1,169 @@
+package pingauthorize
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// fallbackProvider evaluates with a backup PDP when the primary one is unreachable, returns 5xx
+// or 429, or its circuit breaker is open. If the fallback fails too, the primary error is
+// returned so fail_open and passthrough handling are unchanged.
+type fallbackProvider struct {
+	primary    PolicyProvider
+	fallback   PolicyProvider
+	primaryURL string
+	config     *Config
+}
+
+// EvaluateRequest implements PolicyProvider.
+func (p *fallbackProvider) EvaluateRequest(ctx context.Context, req *SidebandAccessRequest) (*SidebandAccessResponse, error) {
+	resp, err := p.primary.EvaluateRequest(ctx, req)
+	if err == nil || !shouldFallback(ctx, err) {
+		return resp, err
+	}
+	fallbackResp, fallbackErr := p.fallback.EvaluateRequest(ctx, req)
+	p.record("access", err, fallbackErr)
+	if fallbackErr != nil {
+		return nil, err
+	}
+	return fallbackResp, nil
+}
+
+// EvaluateResponse implements PolicyProvider.
+func (p *fallbackProvider) EvaluateResponse(ctx context.Context, req *SidebandResponsePayload) (*SidebandResponseResult, error) {
+	result, err := p.primary.EvaluateResponse(ctx, req)
+	if err == nil || !shouldFallback(ctx, err) {
+		return result, err
+	}
+	fallbackResult, fallbackErr := p.fallback.EvaluateResponse(ctx, req)
+	p.record("response", err, fallbackErr)
+	if fallbackErr != nil {
+		return nil, err
+	}
+	return fallbackResult, nil
+}
+
+// record logs a fallback evaluation and counts it when enable_otel is set.
+func (p *fallbackProvider) record(phase string, primaryErr, fallbackErr error) {
+	logger := NewPluginLogger(nil, phase, p.primaryURL)
+	result := "success"
+	if fallbackErr != nil {
+		result = "error"
+		logger.Err("Fallback provider failed", "primary_error", primaryErr.Error(), "fallback_error", fallbackErr.Error(),
+			"fallback_service_url", p.config.FallbackServiceURL)
+	} else {
+		logger.Warn("Primary provider unavailable, evaluated with fallback provider", "error", primaryErr.Error(),
+			"fallback_service_url", p.config.FallbackServiceURL)
+	}
+	if p.config.EnableOtel {
+		fallbackCounter().Add(context.Background(), 1, metric.WithAttributes(
+			attribute.String("phase", phase), attribute.String("result", result)))
+	}
+}
+
+// shouldFallback reports whether err means the primary PDP could not give a decision. Client
+// errors (e.g. a rejected shared secret) and local throttling do not fall back.
+func shouldFallback(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	switch e := err.(type) {
+	case *CircuitBreakerOpenError:
+		return true
+	case *SidebandThrottledError:
+		return false
+	case *sidebandHTTPError:
+		return e.StatusCode >= 500 || e.StatusCode == 429
+	default:
+		return true
+	}
+}
+
+var (
+	fallbackCounterOnce sync.Once
+	fallbackTotal       metric.Int64Counter
+)
+
+// fallbackCounter returns the fallback evaluation counter, created on first use.
+func fallbackCounter() metric.Int64Counter {
+	fallbackCounterOnce.Do(func() {
+		fallbackTotal, _ = otel.Meter(PluginName).Int64Counter("ping_authorize_fallback_total",
+			metric.WithDescription("Evaluations sent to the fallback provider"))
+	})
+	return fallbackTotal
+}
+
+// getFallbackConfig returns the config of the fallback provider: a copy of c with the fallback
+// provider type, service URL and shared secret. It is nil when fallback_provider is not set.
+func (c *Config) getFallbackConfig() *Config {
+	c.fallbackOnce.Do(func() {
+		if c.FallbackProvider == "" {
+			return
+		}
+		c.fallbackConfig = newFallbackConfig(c)
+	})
+	return c.fallbackConfig
+}
+
+// newFallbackConfig derives the fallback config. The JSON round trip copies the settings without
+// the lazily-initialized state, so the fallback gets its own HTTP client and circuit breaker.
+func newFallbackConfig(c *Config) *Config {
+	data, _ := json.Marshal(c)
+	fc := &Config{}
+	json.Unmarshal(data, fc)
+
+	fc.ProviderType = c.FallbackProvider
+	fc.ServiceURL = c.FallbackServiceURL
+	if c.FallbackSharedSecret != "" {
+		fc.SharedSecret = c.FallbackSharedSecret
+		fc.SharedSecretSecondary = ""
+	}
+	fc.FallbackProvider = ""
+	fc.FallbackServiceURL = ""
+	fc.FallbackSharedSecret = ""
+	return fc
+}
+
+// validateFallback checks the fallback_* settings and the fallback provider's own settings.
+func validateFallback(c *Config) error {
+	if c.FallbackProvider == "" {
+		return nil
+	}
+	if c.FallbackServiceURL == "" {
+		return fmt.Errorf("fallback_service_url is required when fallback_provider is set")
+	}
+	if err := validateServiceURL("fallback_service_url", c.FallbackServiceURL); err != nil {
+		return err
+	}
+	if err := validateProvider(newFallbackConfig(c)); err != nil {
+		return fmt.Errorf("fallback_provider: %w", err)
+	}
+	return nil
+}
+
+// withFallback wraps primary with the fallback provider when fallback_provider is set.
+func withFallback(config *Config, primary PolicyProvider) (PolicyProvider, error) {
+	fc := config.getFallbackConfig()
+	if fc == nil {
+		return primary, nil
+	}
+	parsedURL, err := ParseURL(fc.ServiceURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse fallback service URL: %w", err)
+	}
+	entry, err := lookupProvider(fc.ProviderType)
+	if err != nil {
+		return nil, err
+	}
+	fallback, err := entry.factory(fc, fc.getHTTPClient(), parsedURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create fallback provider: %w", err)
+	}
+	return &fallbackProvider{primary: primary, fallback: fallback, primaryURL: config.ServiceURL, config: config}, nil
+}
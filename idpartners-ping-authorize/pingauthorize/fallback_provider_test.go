@@ -0,0 +1,203 @@
+package pingauthorize
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestShouldFallback(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  error
+		want bool
+	}{
+		{"unreachable", context.Background(), errors.New("connection refused"), true},
+		{"circuit breaker open", context.Background(), &CircuitBreakerOpenError{Trigger: Trigger5xx}, true},
+		{"server error", context.Background(), &sidebandHTTPError{StatusCode: 503}, true},
+		{"rate limited", context.Background(), &sidebandHTTPError{StatusCode: 429}, true},
+		{"client error", context.Background(), &sidebandHTTPError{StatusCode: 401}, false},
+		{"throttled", context.Background(), &SidebandThrottledError{}, false},
+		{"client went away", canceled, errors.New("context canceled"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := shouldFallback(tt.ctx, tt.err); got != tt.want {
+				t.Errorf("shouldFallback() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateFallback(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(c *Config)
+		wantErr string
+	}{
+		{"disabled", func(c *Config) {}, ""},
+		{"valid", func(c *Config) {
+			c.FallbackProvider = ProviderOPA
+			c.FallbackServiceURL = "http://localhost:8181"
+		}, ""},
+		{"missing URL", func(c *Config) { c.FallbackProvider = ProviderSideband }, "fallback_service_url is required"},
+		{"bad scheme", func(c *Config) {
+			c.FallbackProvider = ProviderSideband
+			c.FallbackServiceURL = "ftp://pdp"
+		}, "fallback_service_url scheme"},
+		{"unknown provider", func(c *Config) {
+			c.FallbackProvider = "nope"
+			c.FallbackServiceURL = "http://pdp"
+		}, "fallback_provider: unknown provider_type"},
+		{"provider settings", func(c *Config) {
+			c.FallbackProvider = ProviderAuthZen
+			c.FallbackServiceURL = "http://pdp"
+			c.AuthZenEvaluationPath = "relative"
+		}, "fallback_provider: authzen_evaluation_path"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := NewConfig()
+			conf.ServiceURL = "https://paz.example.com"
+			conf.SharedSecret = "secret"
+			conf.SecretHeaderName = "Authorization"
+			tt.modify(conf)
+			err := conf.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewFallbackConfig(t *testing.T) {
+	conf := NewConfig()
+	conf.ServiceURL = "https://paz.example.com"
+	conf.SharedSecret = "primary"
+	conf.OPAPackage = "httpapi/authz"
+	conf.FallbackProvider = ProviderOPA
+	conf.FallbackServiceURL = "http://localhost:8181"
+
+	fc := conf.getFallbackConfig()
+	if fc.ProviderType != ProviderOPA || fc.ServiceURL != "http://localhost:8181" || fc.SharedSecret != "primary" {
+		t.Errorf("unexpected fallback config: %+v", fc)
+	}
+	if fc.OPAPackage != "httpapi/authz" || fc.FallbackProvider != "" {
+		t.Errorf("fallback config should inherit settings but not chain: %+v", fc)
+	}
+	if fc.getHTTPClient() == conf.getHTTPClient() {
+		t.Error("fallback should have its own HTTP client")
+	}
+
+	conf = NewConfig()
+	conf.FallbackProvider = ProviderSideband
+	conf.FallbackServiceURL = "https://paz-dr.example.com"
+	conf.FallbackSharedSecret = "dr"
+	if fc := conf.getFallbackConfig(); fc.SharedSecret != "dr" {
+		t.Errorf("expected fallback_shared_secret, got %q", fc.SharedSecret)
+	}
+}
+
+func TestMiddleware_FallbackProvider(t *testing.T) {
+	primaryStatus := http.StatusServiceUnavailable
+	primary := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(primaryStatus)
+		w.Write([]byte(`{"code":"ERROR","message":"down"}`))
+	})
+	defer primary.Close()
+
+	opaDecision := `{"result":{"allow":true,"headers":{"x-user":"from-opa"}}}`
+	fallbackCalls := 0
+	fallback := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		fallbackCalls++
+		if r.Header.Get("X-Secret") != "opa-secret" {
+			t.Errorf("expected fallback shared secret, got %q", r.Header.Get("X-Secret"))
+		}
+		w.Write([]byte(opaDecision))
+	})
+	defer fallback.Close()
+
+	m, err := NewMiddleware(&Config{
+		ServiceURL:            primary.URL,
+		SharedSecret:          "test-secret",
+		SecretHeaderName:      "X-Secret",
+		SkipResponsePhase:     true,
+		CircuitBreakerEnabled: true,
+		FallbackProvider:      ProviderOPA,
+		FallbackServiceURL:    fallback.URL,
+		FallbackSharedSecret:  "opa-secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	h := m.Handler(upstreamEcho(t, &calls))
+	serve := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "http://api.example.com/orders", nil))
+		return rec
+	}
+
+	rec := serve()
+	if rec.Code != 200 || rec.Header().Get("X-Upstream-User") != "from-opa" || fallbackCalls != 1 {
+		t.Errorf("expected request allowed by fallback, got %d %v (%d fallback calls)", rec.Code, rec.Header(), fallbackCalls)
+	}
+
+	// The primary's circuit breaker is now open; the fallback still decides
+	opaDecision = `{"result":false}`
+	rec = serve()
+	if rec.Code != 403 || fallbackCalls != 2 {
+		t.Errorf("expected fallback deny, got %d (%d fallback calls)", rec.Code, fallbackCalls)
+	}
+
+	// Client errors from the primary are not retried against the fallback
+	m.conf.getHTTPClient().cb.get("/sideband/request").Reset()
+	primaryStatus = http.StatusUnauthorized
+	rec = serve()
+	if fallbackCalls != 2 || rec.Code != 502 {
+		t.Errorf("expected 502 without fallback on 401, got %d (%d fallback calls)", rec.Code, fallbackCalls)
+	}
+}
+
+func TestMiddleware_FallbackProviderFails(t *testing.T) {
+	primary := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	defer primary.Close()
+	fallback := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	defer fallback.Close()
+
+	m, err := NewMiddleware(&Config{
+		ServiceURL:         primary.URL,
+		SharedSecret:       "test-secret",
+		SecretHeaderName:   "X-Secret",
+		SkipResponsePhase:  true,
+		FailOpen:           true,
+		FallbackProvider:   ProviderSideband,
+		FallbackServiceURL: fallback.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	rec := httptest.NewRecorder()
+	m.Handler(upstreamEcho(t, &calls)).ServeHTTP(rec, httptest.NewRequest("GET", "http://api.example.com/orders", nil))
+	if rec.Code != 200 || calls != 1 {
+		t.Errorf("expected fail-open after both providers failed, got %d (%d upstream calls)", rec.Code, calls)
+	}
+}
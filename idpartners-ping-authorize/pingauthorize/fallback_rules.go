@@ -0,0 +1,165 @@
+package pingauthorize
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Fallback rule actions (fallback_rules[].action).
+const (
+	fallbackActionAllow = "allow"
+	fallbackActionDeny  = "deny"
+)
+
+const defaultFallbackDenyMessage = "Policy service unavailable"
+
+// FallbackRule is a locally evaluated rule applied when no policy provider can give a decision.
+// A rule matches requests whose method is one of Methods, whose path matches one of Paths (globs
+// or "~" regular expressions, as in bypass_paths) and that carry all of Headers, with "*" matching
+// any value. Empty conditions match every request.
+type FallbackRule struct {
+	Action  string            `json:"action"`
+	Methods []string          `json:"methods"`
+	Paths   []string          `json:"paths"`
+	Headers map[string]string `json:"headers"`
+	// DenyStatus and DenyMessage make up the response of deny rules, 403 "Policy service
+	// unavailable" by default.
+	DenyStatus  int    `json:"deny_status"`
+	DenyMessage string `json:"deny_message"`
+}
+
+type compiledFallbackRule struct {
+	FallbackRule
+	paths   []*regexp.Regexp
+	methods map[string]bool
+}
+
+// compileFallbackRules compiles fallback_rules. Returns nil when there are none.
+func compileFallbackRules(rules []FallbackRule) ([]compiledFallbackRule, error) {
+	var compiled []compiledFallbackRule
+	for i, rule := range rules {
+		if rule.Action != fallbackActionAllow && rule.Action != fallbackActionDeny {
+			return nil, fmt.Errorf("fallback_rules[%d]: action must be allow or deny, got %q", i, rule.Action)
+		}
+		if rule.DenyStatus != 0 && (rule.DenyStatus < 400 || rule.DenyStatus > 599) {
+			return nil, fmt.Errorf("fallback_rules[%d]: deny_status must be a 4xx or 5xx status, got %d", i, rule.DenyStatus)
+		}
+		paths, err := compilePathPatterns(rule.Paths)
+		if err != nil {
+			return nil, fmt.Errorf("fallback_rules[%d]: paths: %w", i, err)
+		}
+		c := compiledFallbackRule{FallbackRule: rule, paths: paths, methods: make(map[string]bool, len(rule.Methods))}
+		for _, m := range rule.Methods {
+			if m == "" {
+				return nil, fmt.Errorf("fallback_rules[%d]: methods must not contain empty methods", i)
+			}
+			c.methods[strings.ToUpper(m)] = true
+		}
+		for name := range rule.Headers {
+			if name == "" {
+				return nil, fmt.Errorf("fallback_rules[%d]: headers must not contain empty names", i)
+			}
+		}
+		compiled = append(compiled, c)
+	}
+	return compiled, nil
+}
+
+// matches reports whether the rule applies to req, with all of its conditions met.
+func (r *compiledFallbackRule) matches(req *SidebandAccessRequest) bool {
+	if len(r.methods) > 0 && !r.methods[strings.ToUpper(req.Method)] {
+		return false
+	}
+	if len(r.paths) > 0 {
+		u, err := url.Parse(req.URL)
+		if err != nil {
+			return false
+		}
+		matched := false
+		for _, re := range r.paths {
+			if re.MatchString(u.Path) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	headers := FlattenHeaders(req.Headers)
+	for name, want := range r.Headers {
+		values, ok := headers[strings.ToLower(name)]
+		if !ok || (want != "*" && !matchesAny(values, []string{want})) {
+			return false
+		}
+	}
+	return true
+}
+
+// getFallbackRules returns the compiled fallback_rules of the config. Invalid rules are rejected
+// by Validate, so they are treated as none here.
+func (c *Config) getFallbackRules() []compiledFallbackRule {
+	c.fallbackRulesOnce.Do(func() {
+		c.fallbackRules, _ = compileFallbackRules(c.FallbackRules)
+	})
+	return c.fallbackRules
+}
+
+// fallbackRulesProvider applies fallback_rules when the wrapped provider, including any
+// fallback_provider, cannot give a decision. Requests no rule matches keep the provider error,
+// so fail_open and passthrough handling are unchanged. Local decisions are never cached.
+type fallbackRulesProvider struct {
+	next   PolicyProvider
+	config *Config
+}
+
+// EvaluateRequest implements PolicyProvider.
+func (p *fallbackRulesProvider) EvaluateRequest(ctx context.Context, req *SidebandAccessRequest) (*SidebandAccessResponse, error) {
+	resp, err := p.next.EvaluateRequest(ctx, req)
+	if err == nil || !shouldFallback(ctx, err) {
+		return resp, err
+	}
+	rules := p.config.getFallbackRules()
+	for i := range rules {
+		if !rules[i].matches(req) {
+			continue
+		}
+		logger := NewPluginLogger(nil, "access", p.config.ServiceURL)
+		logger.Warn("Policy provider unavailable, applied fallback rule", "rule", i, "action", rules[i].Action, "error", err.Error())
+
+		var local *SidebandAccessResponse
+		if rules[i].Action == fallbackActionAllow {
+			local = policyPermitResponse(req, nil, nil)
+		} else {
+			status, message := rules[i].DenyStatus, rules[i].DenyMessage
+			if status == 0 {
+				status = 403
+			}
+			if message == "" {
+				message = defaultFallbackDenyMessage
+			}
+			local = policyDenyResponse(status, message, nil)
+		}
+		noCache := time.Duration(0)
+		local.CacheTTL = &noCache
+		return local, nil
+	}
+	return nil, err
+}
+
+// EvaluateResponse implements PolicyProvider. Fallback rules only apply to requests.
+func (p *fallbackRulesProvider) EvaluateResponse(ctx context.Context, req *SidebandResponsePayload) (*SidebandResponseResult, error) {
+	return p.next.EvaluateResponse(ctx, req)
+}
+
+// withFallbackRules wraps provider with fallback_rules when any are configured.
+func withFallbackRules(config *Config, provider PolicyProvider) PolicyProvider {
+	if len(config.getFallbackRules()) == 0 {
+		return provider
+	}
+	return &fallbackRulesProvider{next: provider, config: config}
+}
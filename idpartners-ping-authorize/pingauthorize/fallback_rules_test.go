@@ -0,0 +1,117 @@
+package pingauthorize
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFallbackRuleMatches(t *testing.T) {
+	rules, err := compileFallbackRules([]FallbackRule{
+		{Action: "allow", Methods: []string{"get", "HEAD"}, Paths: []string{"/catalog/**"}},
+		{Action: "allow", Paths: []string{"~^/internal/"}, Headers: map[string]string{"X-Internal": "true", "Authorization": "*"}},
+		{Action: "deny"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		method  string
+		url     string
+		headers []map[string]string
+		want    int
+	}{
+		{"method and path", "GET", "https://api.example.com/catalog/items?page=2", nil, 0},
+		{"wrong method", "POST", "https://api.example.com/catalog/items", nil, 2},
+		{"wrong path", "GET", "https://api.example.com/orders", nil, 2},
+		{"headers", "POST", "https://api.example.com/internal/jobs", []map[string]string{{"x-internal": "true"}, {"authorization": "Bearer x"}}, 1},
+		{"header value mismatch", "POST", "https://api.example.com/internal/jobs", []map[string]string{{"x-internal": "false"}, {"authorization": "Bearer x"}}, 2},
+		{"header missing", "POST", "https://api.example.com/internal/jobs", []map[string]string{{"x-internal": "true"}}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &SidebandAccessRequest{Method: tt.method, URL: tt.url, Headers: tt.headers}
+			got := -1
+			for i := range rules {
+				if rules[i].matches(req) {
+					got = i
+					break
+				}
+			}
+			if got != tt.want {
+				t.Errorf("matched rule %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileFallbackRules_Invalid(t *testing.T) {
+	tests := []struct {
+		rule    FallbackRule
+		wantErr string
+	}{
+		{FallbackRule{Action: "skip"}, "action must be allow or deny"},
+		{FallbackRule{Action: "deny", DenyStatus: 200}, "deny_status"},
+		{FallbackRule{Action: "allow", Paths: []string{"~("}}, "paths"},
+		{FallbackRule{Action: "allow", Methods: []string{""}}, "methods"},
+		{FallbackRule{Action: "allow", Headers: map[string]string{"": "x"}}, "headers"},
+	}
+	for _, tt := range tests {
+		_, err := compileFallbackRules([]FallbackRule{tt.rule})
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("compileFallbackRules(%+v) error = %v, want %q", tt.rule, err, tt.wantErr)
+		}
+	}
+}
+
+func TestMiddleware_FallbackRules(t *testing.T) {
+	primaryStatus := http.StatusServiceUnavailable
+	primaryCalls := 0
+	primary := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		primaryCalls++
+		w.WriteHeader(primaryStatus)
+	})
+	defer primary.Close()
+
+	m, err := NewMiddleware(&Config{
+		ServiceURL:         primary.URL,
+		SharedSecret:       "test-secret",
+		SecretHeaderName:   "X-Secret",
+		SkipResponsePhase:  true,
+		DecisionCacheTTLMs: 60000,
+		FallbackRules: []FallbackRule{
+			{Action: "allow", Methods: []string{"GET"}, Paths: []string{"/catalog/**"}},
+			{Action: "deny", Paths: []string{"/admin/**"}, DenyStatus: 503},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	h := m.Handler(upstreamEcho(t, &calls))
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, "http://api.example.com"+path, nil))
+		return rec
+	}
+
+	if rec := serve("GET", "/catalog/items"); rec.Code != 200 || calls != 1 {
+		t.Errorf("expected allow rule to forward the request, got %d (%d upstream calls)", rec.Code, calls)
+	}
+	if rec := serve("GET", "/admin/users"); rec.Code != 503 || !strings.Contains(rec.Body.String(), defaultFallbackDenyMessage) {
+		t.Errorf("expected deny rule response, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := serve("POST", "/orders"); rec.Code != 502 {
+		t.Errorf("expected unmatched request to fail closed, got %d", rec.Code)
+	}
+
+	// Local decisions are not cached, and rules do not apply to client errors
+	primaryStatus = http.StatusUnauthorized
+	before := primaryCalls
+	if rec := serve("GET", "/catalog/items"); rec.Code != 502 || primaryCalls != before+1 {
+		t.Errorf("expected primary to be called again and fail closed on 401, got %d", rec.Code)
+	}
+}
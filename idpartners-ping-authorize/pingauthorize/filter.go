@@ -0,0 +1,167 @@
+package pingauthorize
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/idpartners/idpartners-ping-authorize/pingauthorize/decisionctx"
+)
+
+// AccessCall is the access phase of a request whose host makes the sideband call itself, as
+// proxy-wasm filters must: NewAccessCall composes the payload and makes the decisions that
+// precede the call, the host sends Call, and Complete applies its result to the request.
+type AccessCall struct {
+	// Denial is set when the request is to be answered without calling PingAuthorize.
+	Denial *AccessDenial
+	// Call is the /sideband/request call to make, or nil when the request is denied or passed
+	// on unevaluated.
+	Call *SidebandCall
+
+	conf    *Config
+	r       *http.Request
+	payload *SidebandAccessRequest
+	rawBody []byte
+	logger  *PluginLogger
+}
+
+// AccessDenial is the response a host answers a request with instead of forwarding it.
+type AccessDenial struct {
+	StatusCode int
+	Body       []byte
+	Header     map[string][]string
+}
+
+// SidebandCall is a sideband HTTP call made by the host of the plugin rather than its HTTP client.
+type SidebandCall struct {
+	URL    string
+	Header http.Header
+	Body   []byte
+}
+
+// NewAccessCall composes the access payload of r and makes the decisions that precede the
+// sideband call, like the net/http middleware. It consumes r.Body, unless skip_body_methods
+// lists the request method; a body larger than max_decompressed_body_bytes is denied with 413.
+func NewAccessCall(r *http.Request, conf *Config) (*AccessCall, error) {
+	a := &AccessCall{conf: conf, r: r, logger: NewPluginLogger(nil, "access", conf.ServiceURL)}
+	if r.Body != nil && !skipsBody(conf, r.Method) {
+		var err error
+		if a.rawBody, err = readRequestBody(nil, r, conf); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				a.Denial = &AccessDenial{StatusCode: http.StatusRequestEntityTooLarge}
+				return a, nil
+			}
+			return nil, fmt.Errorf("failed to read request body: %w", err)
+		}
+	}
+
+	payload, err := composeHTTPAccessPayload(r, conf, a.rawBody)
+	if err != nil {
+		return nil, err
+	}
+	payload.bodyNotRead = skipsBody(conf, r.Method)
+	a.payload = payload
+
+	consumerIDs := consumerFromContext(r.Context())
+	if !decideAccess(conf, a, payload, consumerIDs, &decisionctx.Phase{}, a.logger) {
+		return a, nil
+	}
+	finishAccessPayload(r.Context(), conf, payload, consumerIDs, a.logger)
+
+	if a.Call, err = newSidebandCall(conf, "/sideband/request", payload); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Complete applies the result of Call to the request: status and body are the sideband response,
+// or err the failure of the call. It returns the denial to answer the client with, or nil and the
+// body to forward the request with, after the policy's header, method, URL and body changes were
+// applied to it. Failures follow fail_open and passthrough_status_codes.
+func (a *AccessCall) Complete(status int, body []byte, err error) (*AccessDenial, []byte) {
+	conf, logger := a.conf, a.logger
+	deny := func(status int, body []byte, header map[string][]string) (*AccessDenial, []byte) {
+		if conf.ShadowMode {
+			logger.Debug("Shadow mode, decision not enforced", "status_code", status)
+			return nil, a.rawBody
+		}
+		return &AccessDenial{StatusCode: status, Body: body, Header: header}, a.rawBody
+	}
+
+	var resp *SidebandAccessResponse
+	if err == nil {
+		resp, err = decodeAccessCall(status, body)
+	}
+	if err != nil {
+		if status, body, header, handled := sidebandFailure(conf, err, logger); handled {
+			return deny(status, body, header)
+		}
+		logger.Warn("PingAuthorize unreachable, fail-open enabled, allowing request", "fail_open", true)
+		if conf.FailOpenHeader != "" {
+			a.r.Header.Set(conf.FailOpenHeader, "true")
+		}
+		return nil, a.rawBody
+	}
+
+	DebugLogPayload(logger, "Received sideband response", resp, conf)
+	ignoreOmittedBody(a.payload, resp)
+	ignoreStrippedArguments(a.payload, resp)
+
+	if resp.Response != nil {
+		statusCode, err := strconv.Atoi(resp.Response.ResponseCode)
+		if err != nil {
+			statusCode = 403
+		}
+		logger.Info("Request denied by policy provider", "status_code", statusCode)
+		body, header := renderDeny(conf, statusCode, resp.Response, a.r.Header.Get, logger)
+		return deny(statusCode, body, header)
+	}
+	if batchErrors, denied := mcpBatchDenials(a.payload.Body, resp.Body); denied {
+		logger.Info("MCP batch denied by policy provider", "status_code", http.StatusForbidden)
+		return deny(http.StatusForbidden, batchErrors, map[string][]string{"Content-Type": {"application/json"}})
+	}
+
+	if conf.ShadowMode {
+		return nil, a.rawBody
+	}
+	return nil, applyHTTPRequestModifications(a.r, conf, restoreRequestCoding(a.payload, resp), a.payload, a.rawBody, logger)
+}
+
+// deny and skip make an AccessCall the accessOutcome of its decisions.
+func (a *AccessCall) deny(status int, body []byte, header map[string][]string) {
+	if a.conf.ShadowMode {
+		a.logger.Debug("Shadow mode, decision not enforced", "status_code", status)
+		return
+	}
+	a.Denial = &AccessDenial{StatusCode: status, Body: body, Header: header}
+}
+
+func (a *AccessCall) skip() {}
+
+// newSidebandCall returns the call of payload to the sideband endpoint at path, with the headers
+// and shared secret the plugin's HTTP client sends.
+func newSidebandCall(conf *Config, path string, payload interface{}) (*SidebandCall, error) {
+	parsedURL, err := ParseURL(conf.ServiceURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse service URL: %w", err)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode sideband request: %w", err)
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("User-Agent", fmt.Sprintf("Kong/%s", Version))
+	if conf.SharedSecret != "" {
+		secret, err := newSecretRef(conf.SharedSecret, conf.ServiceURL).Value()
+		if err != nil {
+			return nil, err
+		}
+		header.Set(conf.SecretHeaderName, secret)
+	}
+	return &SidebandCall{URL: BuildSidebandURL(parsedURL, path), Header: header, Body: body}, nil
+}
@@ -0,0 +1,122 @@
+package pingauthorize
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func filterTestConfig() *Config {
+	conf := NewConfig()
+	conf.ServiceURL = "https://paz.example.com:1443/base"
+	conf.SharedSecret = "secret"
+	conf.SecretHeaderName = "X-Secret"
+	return conf
+}
+
+func TestNewAccessCall(t *testing.T) {
+	conf := filterTestConfig()
+	r := httptest.NewRequest("POST", "http://api.example.com/orders", strings.NewReader(`{"id":1}`))
+	r.Header.Set("X-User", "alice")
+
+	a, err := NewAccessCall(r, conf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if a.Denial != nil || a.Call == nil {
+		t.Fatalf("expected a sideband call, got denial %+v", a.Denial)
+	}
+	if a.Call.URL != "https://paz.example.com:1443/base/sideband/request" {
+		t.Errorf("URL = %s", a.Call.URL)
+	}
+	if a.Call.Header.Get("X-Secret") != "secret" || a.Call.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("unexpected headers %v", a.Call.Header)
+	}
+	var payload SidebandAccessRequest
+	if err := json.Unmarshal(a.Call.Body, &payload); err != nil {
+		t.Fatalf("invalid payload: %v", err)
+	}
+	if payload.Method != "POST" || payload.URL != "http://api.example.com:80/orders" || payload.Body != `{"id":1}` {
+		t.Errorf("unexpected payload %+v", payload)
+	}
+}
+
+func TestNewAccessCall_Decisions(t *testing.T) {
+	tests := []struct {
+		name       string
+		configure  func(*Config)
+		body       string
+		wantCall   bool
+		wantStatus int
+	}{
+		{"evaluated", func(*Config) {}, "", true, 0},
+		{"skip expression", func(c *Config) { c.SkipExpression = "request.method == 'POST'" }, "", false, 0},
+		{"client certificate", func(c *Config) { c.RequireClientCertificate = true }, "", false, 401},
+		{"shadow mode", func(c *Config) { c.RequireClientCertificate, c.ShadowMode = true, true }, "", false, 0},
+		{"body too large", func(c *Config) { c.MaxDecompressedBodyBytes = 4 }, "12345", false, 413},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := filterTestConfig()
+			tt.configure(conf)
+			a, err := NewAccessCall(httptest.NewRequest("POST", "http://api.example.com/a", strings.NewReader(tt.body)), conf)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if (a.Call != nil) != tt.wantCall {
+				t.Errorf("call = %v, want %v", a.Call != nil, tt.wantCall)
+			}
+			status := 0
+			if a.Denial != nil {
+				status = a.Denial.StatusCode
+			}
+			if status != tt.wantStatus {
+				t.Errorf("denial status = %d, want %d", status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestAccessCall_Complete(t *testing.T) {
+	tests := []struct {
+		name       string
+		failOpen   bool
+		status     int
+		body       string
+		err        error
+		wantStatus int
+		wantUser   string
+	}{
+		{"allowed", false, 200, `{"method":"POST","url":"http://api.example.com:80/a","body":"{}","headers":[{"x-user":"bob"}]}`, nil, 0, "bob"},
+		{"denied", false, 200, `{"response":{"response_code":"403","response_status":"Forbidden"}}`, nil, 403, ""},
+		{"sideband error", false, 500, `{"message":"boom"}`, nil, 502, ""},
+		{"unreachable", false, 0, "", errors.New("connection refused"), 502, ""},
+		{"unreachable fail open", true, 0, "", errors.New("connection refused"), 0, "alice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := filterTestConfig()
+			conf.FailOpen = tt.failOpen
+			r := httptest.NewRequest("POST", "http://api.example.com/a", strings.NewReader("{}"))
+			r.Header.Set("X-User", "alice")
+			a, err := NewAccessCall(r, conf)
+			if err != nil || a.Call == nil {
+				t.Fatalf("expected a sideband call, got %v", err)
+			}
+
+			denial, _ := a.Complete(tt.status, []byte(tt.body), tt.err)
+			status := 0
+			if denial != nil {
+				status = denial.StatusCode
+			}
+			if status != tt.wantStatus {
+				t.Errorf("denial status = %d, want %d", status, tt.wantStatus)
+			}
+			if denial == nil && r.Header.Get("X-User") != tt.wantUser {
+				t.Errorf("X-User = %q, want %q", r.Header.Get("X-User"), tt.wantUser)
+			}
+		})
+	}
+}
@@ -0,0 +1,306 @@
+package pingauthorize
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/url"
+	"strings"
+)
+
+// trafficTypeGraphQL is the traffic_type of requests carrying a GraphQL operation.
+const trafficTypeGraphQL = "graphql"
+
+// maxGraphQLBodyBytes caps the request body size inspected for GraphQL operations.
+const maxGraphQLBodyBytes = 1 << 20
+
+// GraphQLContext describes the GraphQL operation of a request, sent as the graphql block of the
+// sideband payload.
+type GraphQLContext struct {
+	// OperationType is query, mutation or subscription.
+	OperationType string `json:"operation_type"`
+	OperationName string `json:"operation_name,omitempty"`
+	// Fields lists the top-level fields selected by the operation, without aliases.
+	Fields    []string               `json:"fields"`
+	Variables map[string]interface{} `json:"variables,omitempty"`
+}
+
+// graphQLRequest is a GraphQL over HTTP request body (application/json) or query string.
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// enrichGraphQL sets traffic_type and the graphql block of the payload when graphql_enrichment is
+// enabled and the request carries a GraphQL operation: a POST with an application/json body
+// holding a query, a POST with an application/graphql body, or a GET with a query parameter.
+// Requests whose document does not parse are left alone.
+func enrichGraphQL(conf *Config, payload *SidebandAccessRequest) {
+	if !conf.GraphQLEnrichment {
+		return
+	}
+	req := parseGraphQLRequest(payload)
+	if req == nil || req.Query == "" {
+		return
+	}
+	gql, err := parseGraphQLOperation(req.Query, req.OperationName)
+	if err != nil {
+		return
+	}
+	gql.Variables = req.Variables
+	payload.TrafficType = trafficTypeGraphQL
+	payload.GraphQL = gql
+}
+
+// parseGraphQLRequest extracts the GraphQL request of payload, or returns nil.
+func parseGraphQLRequest(payload *SidebandAccessRequest) *graphQLRequest {
+	switch payload.Method {
+	case "GET":
+		u, err := url.Parse(payload.URL)
+		if err != nil {
+			return nil
+		}
+		q := u.Query()
+		req := &graphQLRequest{Query: q.Get("query"), OperationName: q.Get("operationName")}
+		if v := q.Get("variables"); v != "" && json.Unmarshal([]byte(v), &req.Variables) != nil {
+			return nil
+		}
+		return req
+	case "POST":
+		if len(payload.Body) > maxGraphQLBodyBytes {
+			return nil
+		}
+		mediaType, _, _ := mime.ParseMediaType(firstValue(FlattenHeaders(payload.Headers)["content-type"]))
+		switch mediaType {
+		case "application/graphql":
+			return &graphQLRequest{Query: payload.Body}
+		case "application/json":
+			body := bytes.TrimSpace([]byte(payload.Body))
+			if len(body) == 0 || body[0] != '{' || jsonDepthExceeds(body, maxJSONDepth) {
+				return nil
+			}
+			var req graphQLRequest
+			if json.Unmarshal(body, &req) != nil {
+				return nil
+			}
+			return &req
+		}
+	}
+	return nil
+}
+
+// parseGraphQLOperation finds the operation named operationName in a GraphQL document, or its only
+// operation when operationName is empty, and returns its type, name and top-level fields. It
+// reads only as much of the document as that takes and does not validate it.
+func parseGraphQLOperation(document, operationName string) (*GraphQLContext, error) {
+	p := &graphQLParser{tokens: tokenizeGraphQL(document)}
+	var found *GraphQLContext
+	for !p.done() {
+		tok := p.next()
+		var gql *GraphQLContext
+		switch {
+		case tok == "{":
+			gql = &GraphQLContext{OperationType: "query"}
+		case tok == "query" || tok == "mutation" || tok == "subscription":
+			gql = &GraphQLContext{OperationType: tok}
+			if isGraphQLName(p.peek()) {
+				gql.OperationName = p.next()
+			}
+			if p.peek() == "(" {
+				p.skipBalanced("(", ")")
+			}
+			p.skipDirectives()
+			if p.next() != "{" {
+				return nil, fmt.Errorf("expected selection set of %s operation", tok)
+			}
+		case tok == "fragment":
+			for !p.done() && p.peek() != "{" {
+				p.next()
+			}
+			p.next()
+			p.skipRest("{", "}")
+			continue
+		default:
+			return nil, fmt.Errorf("unexpected token %q", tok)
+		}
+
+		fields, err := p.selectionFields()
+		if err != nil {
+			return nil, err
+		}
+		gql.Fields = fields
+		if operationName == "" && found != nil {
+			return nil, fmt.Errorf("operationName is required for documents with several operations")
+		}
+		if operationName == "" || gql.OperationName == operationName {
+			found = gql
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("operation %q not found", operationName)
+	}
+	return found, nil
+}
+
+// graphQLParser walks the tokens of a GraphQL document.
+type graphQLParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *graphQLParser) done() bool { return p.pos >= len(p.tokens) }
+
+func (p *graphQLParser) peek() string {
+	if p.done() {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *graphQLParser) next() string {
+	tok := p.peek()
+	p.pos++
+	return tok
+}
+
+// skipBalanced skips a group starting at the current open token up to its matching close token.
+func (p *graphQLParser) skipBalanced(open, close string) {
+	p.next()
+	p.skipRest(open, close)
+}
+
+// skipRest skips to just past the close token matching an already consumed open token.
+func (p *graphQLParser) skipRest(open, close string) {
+	for depth := 1; depth > 0 && !p.done(); {
+		switch p.next() {
+		case open:
+			depth++
+		case close:
+			depth--
+		}
+	}
+}
+
+// skipDirectives skips directives such as @include(if: $flag).
+func (p *graphQLParser) skipDirectives() {
+	for p.peek() == "@" {
+		p.next()
+		p.next()
+		if p.peek() == "(" {
+			p.skipBalanced("(", ")")
+		}
+	}
+}
+
+// selectionFields reads a selection set whose "{" was consumed and returns its field names.
+// Fields of inline fragments are included; named fragment spreads are not resolved.
+func (p *graphQLParser) selectionFields() ([]string, error) {
+	fields := []string{}
+	for {
+		tok := p.next()
+		switch {
+		case tok == "}":
+			return fields, nil
+		case tok == "...":
+			if p.peek() == "on" {
+				p.next()
+				p.next()
+			}
+			p.skipDirectives()
+			if p.peek() != "{" {
+				p.next() // named fragment spread
+				p.skipDirectives()
+				continue
+			}
+			p.next()
+			inline, err := p.selectionFields()
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, inline...)
+		case isGraphQLName(tok):
+			name := tok
+			if p.peek() == ":" {
+				p.next()
+				name = p.next()
+			}
+			fields = append(fields, name)
+			if p.peek() == "(" {
+				p.skipBalanced("(", ")")
+			}
+			p.skipDirectives()
+			if p.peek() == "{" {
+				p.skipBalanced("{", "}")
+			}
+		default:
+			return nil, fmt.Errorf("unexpected token %q in selection set", tok)
+		}
+	}
+}
+
+// isGraphQLName reports whether tok is a GraphQL name such as a field or operation name.
+func isGraphQLName(tok string) bool {
+	if tok == "" {
+		return false
+	}
+	for i, c := range tok {
+		letter := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+		if !letter && (i == 0 || c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// tokenizeGraphQL splits a GraphQL document into names, punctuators and literals, dropping
+// whitespace, commas and comments. Strings and block strings are kept as single tokens.
+func tokenizeGraphQL(doc string) []string {
+	var tokens []string
+	for i := 0; i < len(doc); {
+		c := doc[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			i++
+		case c == '#':
+			for i < len(doc) && doc[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(doc[i:], `"""`):
+			end := strings.Index(doc[i+3:], `"""`)
+			if end < 0 {
+				end = len(doc) - i - 3
+			}
+			tokens = append(tokens, doc[i:i+3+end])
+			i += end + 6
+		case c == '"':
+			j := i + 1
+			for j < len(doc) && doc[j] != '"' && doc[j] != '\n' {
+				if doc[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			tokens = append(tokens, doc[i:min(j+1, len(doc))])
+			i = j + 1
+		case strings.HasPrefix(doc[i:], "..."):
+			tokens = append(tokens, "...")
+			i += 3
+		case strings.IndexByte("{}()[]:=!$@|&", c) >= 0:
+			tokens = append(tokens, string(c))
+			i++
+		default:
+			j := i
+			for j < len(doc) && strings.IndexByte(" \t\n\r,#\"{}()[]:=!$@|&.", doc[j]) < 0 {
+				j++
+			}
+			if j == i {
+				j++
+			}
+			tokens = append(tokens, doc[i:j])
+			i = j
+		}
+	}
+	return tokens
+}
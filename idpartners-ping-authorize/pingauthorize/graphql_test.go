@@ -0,0 +1,118 @@
+package pingauthorize
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseGraphQLOperation(t *testing.T) {
+	tests := []struct {
+		name          string
+		document      string
+		operationName string
+		wantType      string
+		wantName      string
+		wantFields    []string
+		wantErr       bool
+	}{
+		{"shorthand", `{ me { id } }`, "", "query", "", []string{"me"}, false},
+		{"named query", `query GetUser($id: ID!) { user(id: $id) { name } viewer { id } }`, "", "query", "GetUser", []string{"user", "viewer"}, false},
+		{"mutation with alias", `mutation { renamed: deleteUser(id: "1, }") { ok } }`, "", "mutation", "", []string{"deleteUser"}, false},
+		{"subscription", `subscription OnEvent @live { events }`, "", "subscription", "OnEvent", []string{"events"}, false},
+		{"inline fragment", `query { ... on Query { a } ...Named b @skip(if: true) }`, "", "query", "", []string{"a", "b"}, false},
+		{"comments and block strings", "# list\nquery { search(q: \"\"\"a } b\"\"\") { id } }", "", "query", "", []string{"search"}, false},
+		{"select by name", `query A { a } mutation B { b } fragment F on T { c }`, "B", "mutation", "B", []string{"b"}, false},
+		{"ambiguous", `query A { a } query B { b }`, "", "", "", nil, true},
+		{"unknown name", `query A { a }`, "C", "", "", nil, true},
+		{"not graphql", `SELECT * FROM users`, "", "", "", nil, true},
+		{"unterminated", `query { a {`, "", "", "", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gql, err := parseGraphQLOperation(tt.document, tt.operationName)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", gql)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if gql.OperationType != tt.wantType || gql.OperationName != tt.wantName || !reflect.DeepEqual(gql.Fields, tt.wantFields) {
+				t.Errorf("got %+v, want %s %q %v", gql, tt.wantType, tt.wantName, tt.wantFields)
+			}
+		})
+	}
+}
+
+func TestEnrichGraphQL(t *testing.T) {
+	conf := &Config{GraphQLEnrichment: true}
+	jsonHeaders := []map[string]string{{"content-type": "application/json; charset=utf-8"}}
+
+	tests := []struct {
+		name     string
+		conf     *Config
+		payload  *SidebandAccessRequest
+		wantType string
+		wantVars map[string]interface{}
+	}{
+		{"json post", conf, &SidebandAccessRequest{Method: "POST", Headers: jsonHeaders,
+			Body: `{"query":"mutation Del($id: ID!) { deleteUser(id: $id) { ok } }","variables":{"id":"42"}}`}, "mutation", map[string]interface{}{"id": "42"}},
+		{"graphql post", conf, &SidebandAccessRequest{Method: "POST", Headers: []map[string]string{{"content-type": "application/graphql"}},
+			Body: `{ me { id } }`}, "query", nil},
+		{"get", conf, &SidebandAccessRequest{Method: "GET",
+			URL: "https://api.example.com/graphql?query=%7Bme%7Bid%7D%7D&variables=%7B%22a%22%3A1%7D"}, "query", map[string]interface{}{"a": float64(1)}},
+		{"json without query", conf, &SidebandAccessRequest{Method: "POST", Headers: jsonHeaders, Body: `{"name":"x"}`}, "", nil},
+		{"mcp", conf, &SidebandAccessRequest{Method: "POST", Headers: jsonHeaders, Body: `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`}, "", nil},
+		{"invalid document", conf, &SidebandAccessRequest{Method: "POST", Headers: jsonHeaders, Body: `{"query":"not graphql"}`}, "", nil},
+		{"disabled", &Config{}, &SidebandAccessRequest{Method: "POST", Headers: jsonHeaders, Body: `{"query":"{ me }"}`}, "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enrichGraphQL(tt.conf, tt.payload)
+			if tt.wantType == "" {
+				if tt.payload.TrafficType != "" || tt.payload.GraphQL != nil {
+					t.Errorf("expected no graphql enrichment, got %q %+v", tt.payload.TrafficType, tt.payload.GraphQL)
+				}
+				return
+			}
+			if tt.payload.TrafficType != trafficTypeGraphQL || tt.payload.GraphQL == nil {
+				t.Fatalf("expected graphql enrichment, got %q %+v", tt.payload.TrafficType, tt.payload.GraphQL)
+			}
+			if tt.payload.GraphQL.OperationType != tt.wantType || !reflect.DeepEqual(tt.payload.GraphQL.Variables, tt.wantVars) {
+				t.Errorf("got %+v, want %s with variables %v", tt.payload.GraphQL, tt.wantType, tt.wantVars)
+			}
+		})
+	}
+}
+
+func TestMiddleware_GraphQLEnrichment(t *testing.T) {
+	var seen map[string]json.RawMessage
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&seen)
+		json.NewEncoder(w).Encode(SidebandAccessResponse{Response: &DenyResponse{ResponseCode: "403"}})
+	})
+	defer server.Close()
+
+	m := newTestMiddleware(t, server.URL, false)
+	m.conf.GraphQLEnrichment = true
+	calls := 0
+	h := m.Handler(upstreamEcho(t, &calls))
+
+	req := httptest.NewRequest("POST", "http://api.example.com/graphql", strings.NewReader(`{"query":"mutation { deleteUser(id: 1) { ok } }"}`))
+	req.Header.Set("Content-Type", "application/json")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if string(seen["traffic_type"]) != `"graphql"` {
+		t.Errorf("traffic_type = %s, want \"graphql\"", seen["traffic_type"])
+	}
+	want := `{"operation_type":"mutation","fields":["deleteUser"]}`
+	if string(seen["graphql"]) != want {
+		t.Errorf("graphql = %s, want %s", seen["graphql"], want)
+	}
+}
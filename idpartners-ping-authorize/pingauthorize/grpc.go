@@ -0,0 +1,159 @@
+package pingauthorize
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/url"
+	"os"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// trafficTypeGRPC is the traffic_type of gRPC requests.
+const trafficTypeGRPC = "grpc"
+
+// grpcTransportHeaders are request headers of the gRPC and HTTP/2 transport, left out of the
+// metadata of the grpc block. Headers starting with grpc- are reserved by gRPC and left out too.
+var grpcTransportHeaders = map[string]bool{
+	"content-type":    true,
+	"content-length":  true,
+	"te":              true,
+	"host":            true,
+	"user-agent":      true,
+	"accept-encoding": true,
+	"connection":      true,
+}
+
+// GRPCContext describes the gRPC call of a request, sent as the grpc block of the sideband payload.
+type GRPCContext struct {
+	// Service is the fully qualified service name, e.g. bank.v1.Accounts.
+	Service string `json:"service"`
+	Method  string `json:"method"`
+	// Metadata holds the custom metadata of the call. Binary (-bin) values are base64, as sent.
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Message is the request message in protobuf JSON form, when grpc_descriptor_set describes the
+	// method and the first message is uncompressed.
+	Message json.RawMessage `json:"message,omitempty"`
+}
+
+// enrichGRPC sets traffic_type and the grpc block of the payload when grpc_enrichment is enabled
+// and the request is an application/grpc call. Messages that cannot be decoded are left out.
+func enrichGRPC(conf *Config, payload *SidebandAccessRequest) {
+	if !conf.GRPCEnrichment || payload.Method != "POST" {
+		return
+	}
+	headers := FlattenHeaders(payload.Headers)
+	mediaType, _, _ := mime.ParseMediaType(firstValue(headers["content-type"]))
+	if mediaType != "application/grpc" && mediaType != "application/grpc+proto" {
+		return
+	}
+	u, err := url.Parse(payload.URL)
+	if err != nil {
+		return
+	}
+	service, method, ok := grpcMethod(u.Path)
+	if !ok {
+		return
+	}
+
+	grpc := &GRPCContext{Service: service, Method: method}
+	for name, values := range headers {
+		if grpcTransportHeaders[name] || strings.HasPrefix(name, "grpc-") || strings.HasPrefix(name, ":") {
+			continue
+		}
+		if grpc.Metadata == nil {
+			grpc.Metadata = make(map[string]string)
+		}
+		grpc.Metadata[name] = strings.Join(values, ",")
+	}
+	if files := conf.getGRPCDescriptors(); files != nil {
+		grpc.Message, _ = decodeGRPCMessage(files, service, method, []byte(payload.Body))
+	}
+	payload.TrafficType = trafficTypeGRPC
+	payload.GRPC = grpc
+}
+
+// grpcMethod splits a gRPC path, /package.Service/Method, into the service and method. Only the
+// last two segments are used, so a route prefix in front of the path is ignored.
+func grpcMethod(path string) (service, method string, ok bool) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(segments) < 2 {
+		return "", "", false
+	}
+	service, method = segments[len(segments)-2], segments[len(segments)-1]
+	return service, method, service != "" && method != ""
+}
+
+// decodeGRPCMessage decodes the first length-prefixed message of a gRPC request body with the
+// input type of the method and returns it as protobuf JSON.
+func decodeGRPCMessage(files *protoregistry.Files, service, method string, body []byte) (json.RawMessage, error) {
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return nil, fmt.Errorf("service %s: %w", service, err)
+	}
+	svc, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a service", service)
+	}
+	md := svc.Methods().ByName(protoreflect.Name(method))
+	if md == nil {
+		return nil, fmt.Errorf("method %s not found in service %s", method, service)
+	}
+
+	if len(body) < 5 {
+		return nil, fmt.Errorf("body is not a gRPC message")
+	}
+	if body[0] != 0 {
+		return nil, fmt.Errorf("message is compressed")
+	}
+	length := binary.BigEndian.Uint32(body[1:5])
+	if uint64(length) > uint64(len(body)-5) {
+		return nil, fmt.Errorf("message is truncated")
+	}
+
+	msg := dynamicpb.NewMessage(md.Input())
+	if err := proto.Unmarshal(body[5:5+length], msg); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %s: %w", md.Input().FullName(), err)
+	}
+	return protojson.MarshalOptions{UseProtoNames: true}.Marshal(msg)
+}
+
+// loadGRPCDescriptors reads a binary FileDescriptorSet, as written by
+// protoc --include_imports --descriptor_set_out.
+func loadGRPCDescriptors(path string) (*protoregistry.Files, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse descriptor set: %w", err)
+	}
+	return protodesc.NewFiles(&set)
+}
+
+// getGRPCDescriptors returns the descriptors loaded from grpc_descriptor_set, or nil when it is
+// unset or cannot be loaded.
+func (c *Config) getGRPCDescriptors() *protoregistry.Files {
+	c.grpcOnce.Do(func() {
+		if c.GRPCDescriptorSet == "" {
+			return
+		}
+		files, err := loadGRPCDescriptors(c.GRPCDescriptorSet)
+		if err != nil {
+			NewPluginLogger(nil, "access", c.ServiceURL).Err("Failed to load gRPC descriptor set", "error", err.Error())
+			return
+		}
+		c.grpcDescriptors = files
+	})
+	return c.grpcDescriptors
+}
@@ -0,0 +1,178 @@
+package pingauthorize
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// bankDescriptorSet describes bank.v1.Accounts/Transfer taking a TransferRequest.
+func bankDescriptorSet() *descriptorpb.FileDescriptorSet {
+	field := func(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:   proto.String(name),
+			Number: proto.Int32(number),
+			Type:   typ.Enum(),
+			Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		}
+	}
+	return &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{{
+		Name:    proto.String("bank.proto"),
+		Package: proto.String("bank.v1"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("TransferRequest"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				field("from_account", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+				field("amount", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32),
+			},
+		}},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Accounts"),
+			Method: []*descriptorpb.MethodDescriptorProto{{
+				Name:       proto.String("Transfer"),
+				InputType:  proto.String(".bank.v1.TransferRequest"),
+				OutputType: proto.String(".bank.v1.TransferRequest"),
+			}},
+		}},
+	}}}
+}
+
+// writeBankDescriptorSet writes bankDescriptorSet to a file and returns its path.
+func writeBankDescriptorSet(t *testing.T) string {
+	t.Helper()
+	data, err := proto.Marshal(bankDescriptorSet())
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "bank.pb")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// grpcFrame encodes a TransferRequest as a length-prefixed gRPC message.
+func grpcFrame(t *testing.T, from string, amount int32) string {
+	t.Helper()
+	files, err := protodesc.NewFiles(bankDescriptorSet())
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc, err := files.FindDescriptorByName("bank.v1.TransferRequest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	md := desc.(protoreflect.MessageDescriptor)
+	msg := dynamicpb.NewMessage(md)
+	msg.Set(md.Fields().ByName("from_account"), protoreflect.ValueOfString(from))
+	msg.Set(md.Fields().ByName("amount"), protoreflect.ValueOfInt32(amount))
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := make([]byte, 5, 5+len(data))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(data)))
+	return string(append(frame, data...))
+}
+
+func TestGRPCMethod(t *testing.T) {
+	tests := []struct {
+		path        string
+		wantService string
+		wantMethod  string
+		wantOK      bool
+	}{
+		{"/bank.v1.Accounts/Transfer", "bank.v1.Accounts", "Transfer", true},
+		{"/grpc/bank.v1.Accounts/Transfer", "bank.v1.Accounts", "Transfer", true},
+		{"/Transfer", "", "", false},
+		{"/bank.v1.Accounts/", "", "", false},
+	}
+	for _, tt := range tests {
+		service, method, ok := grpcMethod(tt.path)
+		if service != tt.wantService || method != tt.wantMethod || ok != tt.wantOK {
+			t.Errorf("grpcMethod(%q) = %q, %q, %v", tt.path, service, method, ok)
+		}
+	}
+}
+
+func TestEnrichGRPC(t *testing.T) {
+	descriptors := writeBankDescriptorSet(t)
+	frame := grpcFrame(t, "alice", 100)
+	grpcHeaders := []map[string]string{{"content-type": "application/grpc", "te": "trailers", "grpc-timeout": "1S", "x-tenant": "acme"}}
+
+	tests := []struct {
+		name        string
+		conf        *Config
+		headers     []map[string]string
+		body        string
+		wantGRPC    bool
+		wantMessage string
+	}{
+		{"metadata only", &Config{GRPCEnrichment: true}, grpcHeaders, frame, true, ""},
+		{"decoded message", &Config{GRPCEnrichment: true, GRPCDescriptorSet: descriptors}, grpcHeaders, frame, true, `{"from_account":"alice","amount":100}`},
+		{"compressed message", &Config{GRPCEnrichment: true, GRPCDescriptorSet: descriptors}, grpcHeaders, "\x01" + frame[1:], true, ""},
+		{"missing descriptor set", &Config{GRPCEnrichment: true, GRPCDescriptorSet: filepath.Join(t.TempDir(), "missing.pb")}, grpcHeaders, frame, true, ""},
+		{"not grpc", &Config{GRPCEnrichment: true}, []map[string]string{{"content-type": "application/json"}}, "{}", false, ""},
+		{"disabled", &Config{}, grpcHeaders, frame, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := &SidebandAccessRequest{Method: "POST", URL: "https://api.example.com/bank.v1.Accounts/Transfer", Body: tt.body, Headers: tt.headers}
+			enrichGRPC(tt.conf, payload)
+			if !tt.wantGRPC {
+				if payload.TrafficType != "" || payload.GRPC != nil {
+					t.Errorf("expected no grpc enrichment, got %q %+v", payload.TrafficType, payload.GRPC)
+				}
+				return
+			}
+			if payload.TrafficType != trafficTypeGRPC || payload.GRPC == nil {
+				t.Fatalf("expected grpc enrichment, got %q %+v", payload.TrafficType, payload.GRPC)
+			}
+			grpc := payload.GRPC
+			if grpc.Service != "bank.v1.Accounts" || grpc.Method != "Transfer" || len(grpc.Metadata) != 1 || grpc.Metadata["x-tenant"] != "acme" {
+				t.Errorf("unexpected grpc block: %+v", grpc)
+			}
+			if got := strings.ReplaceAll(string(grpc.Message), " ", ""); got != tt.wantMessage {
+				t.Errorf("message = %s, want %s", got, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestMiddleware_GRPCEnrichment(t *testing.T) {
+	var seen map[string]json.RawMessage
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&seen)
+		json.NewEncoder(w).Encode(SidebandAccessResponse{Response: &DenyResponse{ResponseCode: "403"}})
+	})
+	defer server.Close()
+
+	m := newTestMiddleware(t, server.URL, false)
+	m.conf.GRPCEnrichment = true
+	calls := 0
+	h := m.Handler(upstreamEcho(t, &calls))
+
+	req := httptest.NewRequest("POST", "http://api.example.com/bank.v1.Accounts/Transfer", strings.NewReader(grpcFrame(t, "alice", 100)))
+	req.Header.Set("Content-Type", "application/grpc")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if string(seen["traffic_type"]) != `"grpc"` {
+		t.Errorf("traffic_type = %s, want \"grpc\"", seen["traffic_type"])
+	}
+	want := `{"service":"bank.v1.Accounts","method":"Transfer"}`
+	if string(seen["grpc"]) != want {
+		t.Errorf("grpc = %s, want %s", seen["grpc"], want)
+	}
+}
@@ -1,4 +1,4 @@
-package main
+package pingauthorize
 
 import (
 	"fmt"
@@ -70,3 +70,49 @@ func FlattenHeaders(headers []map[string]string) map[string][]string {
 	}
 	return result
 }
+
+// reservedSidebandHeaders are set by the plugin on every sideband call and cannot be forwarded from the client.
+var reservedSidebandHeaders = map[string]bool{
+	"host":           true,
+	"connection":     true,
+	"content-type":   true,
+	"content-length": true,
+	"user-agent":     true,
+}
+
+// SelectForwardHeaders picks the named headers from the client request for copying onto the sideband call.
+// Header names are matched case-insensitively. Headers absent from the client request are skipped.
+func SelectForwardHeaders(headers map[string][]string, names []string) map[string][]string {
+	if len(headers) == 0 || len(names) == 0 {
+		return nil
+	}
+
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[strings.ToLower(name)] = true
+	}
+
+	result := make(map[string][]string)
+	for name, values := range headers {
+		lowerName := strings.ToLower(name)
+		if wanted[lowerName] {
+			result[lowerName] = append(result[lowerName], values...)
+		}
+	}
+	return result
+}
+
+// ExtractHeaders returns the named headers as a flat name→value map for top-level payload fields.
+// Names are lowercased; multi-value headers are joined with ", ". Returns nil if nothing matched.
+func ExtractHeaders(headers map[string][]string, names []string) map[string]string {
+	selected := SelectForwardHeaders(headers, names)
+	if len(selected) == 0 {
+		return nil
+	}
+
+	result := make(map[string]string, len(selected))
+	for name, values := range selected {
+		result[name] = strings.Join(values, ", ")
+	}
+	return result
+}
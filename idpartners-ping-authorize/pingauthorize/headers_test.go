@@ -1,6 +1,8 @@
-package main
+package pingauthorize
 
 import (
+	"encoding/json"
+	"strings"
 	"testing"
 )
 
@@ -150,3 +152,92 @@ func TestFormatHeadersFromInterface_StringValues(t *testing.T) {
 		t.Fatalf("expected 3 entries, got %d", len(result))
 	}
 }
+
+func TestSelectForwardHeaders(t *testing.T) {
+	input := map[string][]string{
+		"X-Tenant-Id":   {"acme"},
+		"X-Region":      {"eu", "us"},
+		"Authorization": {"Bearer token"},
+	}
+
+	result := SelectForwardHeaders(input, []string{"x-tenant-id", "X-REGION", "x-missing"})
+
+	if len(result) != 2 {
+		t.Fatalf("expected 2 headers, got %d: %v", len(result), result)
+	}
+	if got := result["x-tenant-id"]; len(got) != 1 || got[0] != "acme" {
+		t.Errorf("x-tenant-id: got %v", got)
+	}
+	if got := result["x-region"]; len(got) != 2 {
+		t.Errorf("x-region: expected 2 values, got %v", got)
+	}
+	if _, ok := result["authorization"]; ok {
+		t.Error("authorization should not be selected")
+	}
+}
+
+func TestSelectForwardHeaders_NoNames(t *testing.T) {
+	result := SelectForwardHeaders(map[string][]string{"X-Tenant-Id": {"acme"}}, nil)
+	if result != nil {
+		t.Errorf("expected nil result, got %v", result)
+	}
+}
+
+func TestExtractHeaders(t *testing.T) {
+	input := map[string][]string{
+		"X-Tenant-Id": {"acme"},
+		"Accept":      {"application/json", "text/plain"},
+		"Cookie":      {"session=abc"},
+	}
+
+	result := ExtractHeaders(input, []string{"X-Tenant-ID", "accept"})
+
+	if len(result) != 2 {
+		t.Fatalf("expected 2 headers, got %d: %v", len(result), result)
+	}
+	if result["x-tenant-id"] != "acme" {
+		t.Errorf("x-tenant-id: got %q", result["x-tenant-id"])
+	}
+	if result["accept"] != "application/json, text/plain" {
+		t.Errorf("accept: got %q", result["accept"])
+	}
+}
+
+func TestExtractHeaders_NoMatch(t *testing.T) {
+	result := ExtractHeaders(map[string][]string{"Cookie": {"a=b"}}, []string{"x-tenant-id"})
+	if result != nil {
+		t.Errorf("expected nil result, got %v", result)
+	}
+}
+
+func FuzzFormatHeadersFromInterface(f *testing.F) {
+	f.Add([]byte(`{"Content-Type":"application/json","X-Multi":["a","b"]}`))
+	f.Add([]byte(`{"X-Nested":[["a"]]}`))
+	f.Add([]byte(`{"X-Num":1,"X-Null":null}`))
+	f.Add([]byte(`{}`))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var headers map[string]interface{}
+		if err := json.Unmarshal(data, &headers); err != nil {
+			return
+		}
+		formatted, err := FormatHeadersFromInterface(headers)
+		if err != nil {
+			return
+		}
+		for _, entry := range formatted {
+			for name := range entry {
+				if name != strings.ToLower(name) {
+					t.Fatalf("header name not lowercased: %q", name)
+				}
+			}
+		}
+		total := 0
+		for _, values := range FlattenHeaders(formatted) {
+			total += len(values)
+		}
+		if total != len(formatted) {
+			t.Fatalf("round trip lost values: %d != %d", total, len(formatted))
+		}
+	})
+}
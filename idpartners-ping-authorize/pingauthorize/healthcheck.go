@@ -0,0 +1,219 @@
+package pingauthorize
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	defaultHealthCheckIntervalMs         = 10000
+	defaultHealthCheckTimeoutMs          = 2000
+	defaultHealthCheckUnhealthyThreshold = 2
+	defaultHealthCheckHealthyThreshold   = 1
+)
+
+// SidebandEndpointDownError is returned without calling PingAuthorize when active health checks
+// have marked the endpoint down.
+type SidebandEndpointDownError struct {
+	Since     time.Time
+	LastError string
+}
+
+func (e *SidebandEndpointDownError) Error() string {
+	return fmt.Sprintf("sideband endpoint down since %s (health check: %s)", e.Since.Format(time.RFC3339), e.LastError)
+}
+
+// healthChecker probes health_check_path of a sideband endpoint in the background and tracks
+// whether the endpoint is up. An endpoint starts up and is marked down after
+// health_check_unhealthy_threshold consecutive failed probes, and up again after
+// health_check_healthy_threshold consecutive successful ones.
+type healthChecker struct {
+	url                string
+	serviceURL         string
+	client             *http.Client
+	interval           time.Duration
+	unhealthyThreshold int
+	healthyThreshold   int
+	enableOtel         bool
+
+	mu        sync.Mutex
+	up        bool
+	downSince time.Time
+	lastError string
+	failures  int
+	successes int
+
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+// newHealthChecker creates a health checker for config that probes over transport, or returns nil
+// if health_check_path is not set. Call start to begin probing.
+func newHealthChecker(config *Config, transport http.RoundTripper) *healthChecker {
+	if config.HealthCheckPath == "" {
+		return nil
+	}
+	parsed, err := ParseURL(config.ServiceURL)
+	if err != nil {
+		return nil
+	}
+	h := &healthChecker{
+		url:                BuildSidebandURL(parsed, config.HealthCheckPath),
+		serviceURL:         config.ServiceURL,
+		client:             &http.Client{Transport: transport, Timeout: msOrDefault(config.HealthCheckTimeoutMs, defaultHealthCheckTimeoutMs)},
+		interval:           msOrDefault(config.HealthCheckIntervalMs, defaultHealthCheckIntervalMs),
+		unhealthyThreshold: config.HealthCheckUnhealthyThreshold,
+		healthyThreshold:   config.HealthCheckHealthyThreshold,
+		enableOtel:         config.EnableOtel,
+		up:                 true,
+		stop:               make(chan struct{}),
+	}
+	if h.unhealthyThreshold <= 0 {
+		h.unhealthyThreshold = defaultHealthCheckUnhealthyThreshold
+	}
+	if h.healthyThreshold <= 0 {
+		h.healthyThreshold = defaultHealthCheckHealthyThreshold
+	}
+	return h
+}
+
+// msOrDefault converts ms to a duration, using def when ms is not positive.
+func msOrDefault(ms, def int) time.Duration {
+	if ms <= 0 {
+		ms = def
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// start probes the endpoint every interval until close is called.
+func (h *healthChecker) start() {
+	go func() {
+		ticker := time.NewTicker(h.interval)
+		defer ticker.Stop()
+		for {
+			h.probe()
+			select {
+			case <-h.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// close stops probing. Safe to call more than once.
+func (h *healthChecker) close() {
+	h.stopOnce.Do(func() { close(h.stop) })
+}
+
+// probe sends one GET to the health path and records the result. Any 2xx or 3xx is healthy.
+func (h *healthChecker) probe() {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, h.url, nil)
+	if err != nil {
+		h.record(err)
+		return
+	}
+	req.Header.Set("User-Agent", fmt.Sprintf("Kong/%s", Version))
+	resp, err := h.client.Do(req)
+	if err != nil {
+		h.record(err)
+		return
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		h.record(fmt.Errorf("health check returned %d", resp.StatusCode))
+		return
+	}
+	h.record(nil)
+}
+
+// record updates the endpoint state with the result of a probe.
+func (h *healthChecker) record(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	logger := NewPluginLogger(nil, "health", h.serviceURL)
+	if err != nil {
+		h.failures++
+		h.successes = 0
+		h.lastError = err.Error()
+		if h.up && h.failures >= h.unhealthyThreshold {
+			h.up, h.downSince = false, time.Now()
+			logger.Err("Sideband endpoint marked down", "url", h.url, "error", h.lastError)
+			h.recordState()
+		}
+		return
+	}
+	h.successes++
+	h.failures = 0
+	if !h.up && h.successes >= h.healthyThreshold {
+		h.up = true
+		logger.Info("Sideband endpoint marked up", "url", h.url, "down_for", time.Since(h.downSince).String())
+		h.recordState()
+	}
+}
+
+// recordState records the endpoint state gauge when enable_otel is set.
+func (h *healthChecker) recordState() {
+	if !h.enableOtel {
+		return
+	}
+	var state int64
+	if h.up {
+		state = 1
+	}
+	endpointUpGauge().Record(context.Background(), state, metric.WithAttributes(attribute.String("service_url", h.serviceURL)))
+}
+
+// check returns a *SidebandEndpointDownError if the endpoint is marked down.
+func (h *healthChecker) check() error {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.up {
+		return nil
+	}
+	return &SidebandEndpointDownError{Since: h.downSince, LastError: h.lastError}
+}
+
+var (
+	endpointUpOnce sync.Once
+	endpointUp     metric.Int64Gauge
+)
+
+// endpointUpGauge returns the endpoint health gauge, created on first use.
+func endpointUpGauge() metric.Int64Gauge {
+	endpointUpOnce.Do(func() {
+		endpointUp, _ = otel.Meter(PluginName).Int64Gauge("ping_authorize_sideband_endpoint_up",
+			metric.WithDescription("Sideband endpoint health from active health checks: 0=down, 1=up"))
+	})
+	return endpointUp
+}
+
+// validateHealthCheck checks the health_check_* settings.
+func validateHealthCheck(c *Config) error {
+	if c.HealthCheckPath == "" {
+		return nil
+	}
+	if c.HealthCheckPath[0] != '/' {
+		return fmt.Errorf("health_check_path must start with /, got %q", c.HealthCheckPath)
+	}
+	if c.HealthCheckIntervalMs < 0 || c.HealthCheckTimeoutMs < 0 {
+		return fmt.Errorf("health_check_interval_ms and health_check_timeout_ms must be >= 0")
+	}
+	if c.HealthCheckUnhealthyThreshold < 0 || c.HealthCheckHealthyThreshold < 0 {
+		return fmt.Errorf("health_check_unhealthy_threshold and health_check_healthy_threshold must be >= 0")
+	}
+	return nil
+}
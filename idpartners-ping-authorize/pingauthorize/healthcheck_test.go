@@ -0,0 +1,164 @@
+package pingauthorize
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthChecker_Thresholds(t *testing.T) {
+	conf := NewConfig()
+	conf.ServiceURL = "https://paz.example.com"
+	conf.HealthCheckPath = "/available-state"
+	conf.HealthCheckUnhealthyThreshold = 2
+	conf.HealthCheckHealthyThreshold = 2
+	h := newHealthChecker(conf, http.DefaultTransport)
+	if h.url != "https://paz.example.com:443/available-state" {
+		t.Errorf("unexpected probe URL %s", h.url)
+	}
+
+	failed := errors.New("connection refused")
+	steps := []struct {
+		err    error
+		wantUp bool
+	}{
+		{failed, true},
+		{nil, true},
+		{failed, true},
+		{failed, false},
+		{nil, false},
+		{failed, false},
+		{nil, false},
+		{nil, true},
+	}
+	for i, step := range steps {
+		h.record(step.err)
+		err := h.check()
+		if (err == nil) != step.wantUp {
+			t.Fatalf("step %d: check() = %v, want up=%v", i, err, step.wantUp)
+		}
+		var downErr *SidebandEndpointDownError
+		if err != nil && (!errors.As(err, &downErr) || downErr.LastError != "connection refused") {
+			t.Errorf("step %d: unexpected error %v", i, err)
+		}
+	}
+
+	if newHealthChecker(NewConfig(), http.DefaultTransport) != nil {
+		t.Error("expected no health checker without health_check_path")
+	}
+	var none *healthChecker
+	if none.check() != nil {
+		t.Error("a nil health checker should report up")
+	}
+}
+
+func TestExecute_SkipsEndpointMarkedDown(t *testing.T) {
+	var healthy atomic.Bool
+	healthy.Store(true)
+	var sidebandCalls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			if !healthy.Load() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+			return
+		}
+		sidebandCalls.Add(1)
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	conf := NewConfig()
+	conf.ServiceURL = server.URL
+	conf.SharedSecret = "secret"
+	conf.SecretHeaderName = "X-Secret"
+	conf.HealthCheckPath = "/health"
+	conf.HealthCheckIntervalMs = 10
+	conf.HealthCheckUnhealthyThreshold = 1
+	r := newEndpointRegistry()
+	client, lease := r.newClient(conf)
+	defer lease.Release()
+	parsed, _ := ParseURL(server.URL)
+
+	execute := func() error {
+		_, _, _, err := client.Execute(context.Background(), server.URL+"/sideband/request", []byte(`{}`), parsed)
+		return err
+	}
+	waitFor := func(cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatal("timed out waiting for the health state to change")
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	if err := execute(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	healthy.Store(false)
+	waitFor(func() bool { return client.health.check() != nil })
+	calls := sidebandCalls.Load()
+	err := execute()
+	var downErr *SidebandEndpointDownError
+	if !errors.As(err, &downErr) || !strings.Contains(err.Error(), "503") {
+		t.Errorf("expected endpoint down error, got %v", err)
+	}
+	if sidebandCalls.Load() != calls {
+		t.Error("expected no sideband call while the endpoint is down")
+	}
+	if !shouldFallback(context.Background(), err) {
+		t.Error("a down endpoint should fall back")
+	}
+
+	healthy.Store(true)
+	waitFor(func() bool { return client.health.check() == nil })
+	if err := execute(); err != nil {
+		t.Errorf("expected the endpoint to be used again, got %v", err)
+	}
+}
+
+func TestValidateHealthCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(c *Config)
+		wantErr string
+	}{
+		{"disabled", func(c *Config) {}, ""},
+		{"enabled", func(c *Config) { c.HealthCheckPath = "/available-state" }, ""},
+		{"relative path", func(c *Config) { c.HealthCheckPath = "health" }, "must start with /"},
+		{"negative interval", func(c *Config) {
+			c.HealthCheckPath, c.HealthCheckIntervalMs = "/health", -1
+		}, "health_check_interval_ms"},
+		{"negative threshold", func(c *Config) {
+			c.HealthCheckPath, c.HealthCheckHealthyThreshold = "/health", -1
+		}, "health_check_healthy_threshold"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := NewConfig()
+			conf.ServiceURL = "https://paz.example.com"
+			conf.SharedSecret = "secret"
+			conf.SecretHeaderName = "X-Secret"
+			tt.modify(conf)
+			err := conf.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
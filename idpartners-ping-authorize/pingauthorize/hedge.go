@@ -0,0 +1,96 @@
+package pingauthorize
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// hedgeResult is the outcome of one of the requests of a hedged attempt.
+type hedgeResult struct {
+	status  int
+	headers http.Header
+	body    []byte
+	err     error
+	hedge   bool
+}
+
+// answered reports whether the result is a usable answer rather than a failure that the other
+// request might still improve on.
+func (r *hedgeResult) answered() bool {
+	return r.err == nil && r.status < 500
+}
+
+// doHedgedRequest performs one attempt of a sideband call. With hedge_delay_ms set, a second
+// identical request is sent if the first has not returned within the delay, and the first answer
+// wins; the other request is cancelled. A failure is only returned once both requests have
+// failed, so the retry loop and circuit breaker see one result per attempt, as without hedging.
+// A first request that fails before the delay is not hedged; retries handle that case.
+func (c *SidebandHTTPClient) doHedgedRequest(ctx context.Context, requestURL string, body []byte, parsedURL *ParsedURL) (int, http.Header, []byte, error) {
+	if c.config.HedgeDelayMs <= 0 {
+		return c.doRequest(ctx, requestURL, body, parsedURL)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan hedgeResult, 2)
+	send := func(hedge bool) {
+		status, headers, respBody, err := c.doRequest(ctx, requestURL, body, parsedURL)
+		results <- hedgeResult{status: status, headers: headers, body: respBody, err: err, hedge: hedge}
+	}
+	go send(false)
+
+	timer := time.NewTimer(time.Duration(c.config.HedgeDelayMs) * time.Millisecond)
+	defer timer.Stop()
+
+	inFlight, hedged := 1, false
+	for {
+		select {
+		case <-timer.C:
+			hedged = true
+			inFlight++
+			go send(true)
+		case r := <-results:
+			inFlight--
+			if r.answered() || inFlight == 0 {
+				if hedged {
+					c.recordHedge(r.hedge)
+				}
+				return r.status, r.headers, r.body, r.err
+			}
+		}
+	}
+}
+
+// recordHedge counts a hedged attempt by whether the hedge request won.
+func (c *SidebandHTTPClient) recordHedge(hedgeWon bool) {
+	if !c.config.EnableOtel {
+		return
+	}
+	winner := "original"
+	if hedgeWon {
+		winner = "hedge"
+	}
+	hedgeCounter().Add(context.Background(), 1, metric.WithAttributes(
+		attribute.String("service_url", c.config.ServiceURL), attribute.String("winner", winner)))
+}
+
+var (
+	hedgeCounterOnce sync.Once
+	hedgeTotal       metric.Int64Counter
+)
+
+// hedgeCounter returns the hedged attempt counter, created on first use.
+func hedgeCounter() metric.Int64Counter {
+	hedgeCounterOnce.Do(func() {
+		hedgeTotal, _ = otel.Meter(PluginName).Int64Counter("ping_authorize_sideband_hedge_total",
+			metric.WithDescription("Sideband attempts that sent a hedge request, by the request that answered"))
+	})
+	return hedgeTotal
+}
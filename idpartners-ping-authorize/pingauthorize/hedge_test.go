@@ -0,0 +1,98 @@
+package pingauthorize
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestExecute_Hedging(t *testing.T) {
+	tests := []struct {
+		name       string
+		hedgeDelay int
+		firstDelay time.Duration
+		firstCode  int
+		wantCalls  int32
+		wantStatus int
+		maxElapsed time.Duration
+	}{
+		{"fast first request is not hedged", 50, 0, 200, 1, 200, time.Second},
+		{"slow first request loses to the hedge", 20, 2 * time.Second, 200, 2, 200, time.Second},
+		{"slow failing first request loses to the hedge", 20, 100 * time.Millisecond, 500, 2, 200, time.Second},
+		{"fast failure is retried, not hedged", 50, 0, 500, 2, 200, time.Second},
+		{"hedging disabled", 0, 100 * time.Millisecond, 200, 1, 200, time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			var cancelled atomic.Bool
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.ReadAll(r.Body)
+				if calls.Add(1) == 1 {
+					select {
+					case <-time.After(tt.firstDelay):
+					case <-r.Context().Done():
+						cancelled.Store(true)
+						return
+					}
+					w.WriteHeader(tt.firstCode)
+					return
+				}
+				w.Write([]byte(`{}`))
+			}))
+			defer server.Close()
+
+			parsed, _ := ParseURL(server.URL)
+			client := NewSidebandHTTPClient(&Config{
+				ServiceURL:            server.URL,
+				SharedSecret:          "secret",
+				SecretHeaderName:      "X-Secret",
+				ConnectionTimeoutMs:   5000,
+				ConnectionKeepaliveMs: 60000,
+				MaxRetries:            1,
+				RetryBackoffMs:        1,
+				HedgeDelayMs:          tt.hedgeDelay,
+			})
+
+			start := time.Now()
+			status, _, _, err := client.Execute(context.Background(), server.URL+"/sideband/request", []byte(`{}`), parsed)
+			elapsed := time.Since(start)
+			if err != nil || status != tt.wantStatus {
+				t.Fatalf("Execute() = %d, %v", status, err)
+			}
+			if calls.Load() != tt.wantCalls {
+				t.Errorf("expected %d sideband calls, got %d", tt.wantCalls, calls.Load())
+			}
+			if elapsed > tt.maxElapsed {
+				t.Errorf("expected the hedge to answer quickly, took %s", elapsed)
+			}
+			if tt.firstDelay > time.Second {
+				deadline := time.Now().Add(time.Second)
+				for !cancelled.Load() && time.Now().Before(deadline) {
+					time.Sleep(5 * time.Millisecond)
+				}
+				if !cancelled.Load() {
+					t.Error("expected the losing request to be cancelled")
+				}
+			}
+		})
+	}
+}
+
+func TestValidateHedgeDelay(t *testing.T) {
+	for _, delay := range []int{-1, 10000} {
+		conf := NewConfig()
+		conf.ServiceURL = "https://paz.example.com"
+		conf.SharedSecret = "secret"
+		conf.SecretHeaderName = "X-Secret"
+		conf.HedgeDelayMs = delay
+		if err := conf.Validate(); err == nil || !strings.Contains(err.Error(), "hedge_delay_ms") {
+			t.Errorf("hedge_delay_ms %d: expected an error, got %v", delay, err)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package pingauthorize
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultTokenIntrospectionCacheTTLSec is used when token_introspection_cache_ttl_sec is unset.
+	defaultTokenIntrospectionCacheTTLSec = 60
+	// maxIntrospectionCacheEntries caps the introspection results cached per plugin configuration.
+	maxIntrospectionCacheEntries = 10000
+	// maxIntrospectionResponseBytes caps the size of an introspection response.
+	maxIntrospectionResponseBytes = 1 << 20
+)
+
+// TokenSubject holds the RFC 7662 introspection result of the client's access token, sent as
+// the subject block of the sideband payload.
+type TokenSubject struct {
+	Active   bool     `json:"active"`
+	Scopes   []string `json:"scopes,omitempty"`
+	ClientID string   `json:"client_id,omitempty"`
+}
+
+// tokenIntrospector calls an RFC 7662 introspection endpoint and caches the results by token hash.
+type tokenIntrospector struct {
+	url          string
+	clientID     string
+	clientSecret string
+	ttl          time.Duration
+	client       *http.Client
+	now          func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]introspectionEntry
+}
+
+type introspectionEntry struct {
+	subject *TokenSubject
+	expires time.Time
+}
+
+// newTokenIntrospector creates an introspector for the token_introspection_* settings of config,
+// or returns nil if token_introspection_url is not set.
+func newTokenIntrospector(config *Config) *tokenIntrospector {
+	if config.TokenIntrospectionURL == "" {
+		return nil
+	}
+	ttl := config.TokenIntrospectionCacheTTLSec
+	if ttl == 0 {
+		ttl = defaultTokenIntrospectionCacheTTLSec
+	}
+	return &tokenIntrospector{
+		url:          config.TokenIntrospectionURL,
+		clientID:     config.TokenIntrospectionClientID,
+		clientSecret: config.TokenIntrospectionClientSecret,
+		ttl:          time.Duration(ttl) * time.Second,
+		client: &http.Client{
+			Timeout: time.Duration(config.ConnectionTimeoutMs) * time.Millisecond,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: !config.VerifyServiceCert},
+			},
+		},
+		now:     time.Now,
+		entries: make(map[string]introspectionEntry),
+	}
+}
+
+// introspect returns the introspection result of token, from the cache when possible. Active
+// results are cached until the token expires, at most for the cache TTL; inactive results for the
+// cache TTL. Failed calls are not cached.
+func (i *tokenIntrospector) introspect(ctx context.Context, token string) (*TokenSubject, error) {
+	sum := sha256.Sum256([]byte(token))
+	key := hex.EncodeToString(sum[:])
+
+	i.mu.Lock()
+	entry, ok := i.entries[key]
+	i.mu.Unlock()
+	if ok && i.now().Before(entry.expires) {
+		return entry.subject, nil
+	}
+
+	subject, exp, err := i.fetch(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	expires := i.now().Add(i.ttl)
+	if subject.Active && !exp.IsZero() && exp.Before(expires) {
+		expires = exp
+	}
+	i.store(key, introspectionEntry{subject: subject, expires: expires})
+	return subject, nil
+}
+
+// store caches entry under key. When the cache is full, expired entries are dropped first and
+// then an arbitrary one.
+func (i *tokenIntrospector) store(key string, entry introspectionEntry) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if _, ok := i.entries[key]; !ok && len(i.entries) >= maxIntrospectionCacheEntries {
+		now := i.now()
+		for k, e := range i.entries {
+			if !now.Before(e.expires) {
+				delete(i.entries, k)
+			}
+		}
+		for k := range i.entries {
+			if len(i.entries) < maxIntrospectionCacheEntries {
+				break
+			}
+			delete(i.entries, k)
+		}
+	}
+	i.entries[key] = entry
+}
+
+// fetch calls the introspection endpoint, authenticating with HTTP Basic (client_secret_basic).
+// Returns the token expiry from exp, or the zero time when there is none.
+func (i *tokenIntrospector) fetch(ctx context.Context, token string) (*TokenSubject, time.Time, error) {
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, i.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", fmt.Sprintf("Kong/%s", Version))
+	req.SetBasicAuth(url.QueryEscape(i.clientID), url.QueryEscape(i.clientSecret))
+
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, time.Time{}, fmt.Errorf("introspection endpoint returned %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxIntrospectionResponseBytes))
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to read introspection response: %w", err)
+	}
+
+	var result struct {
+		Active   bool   `json:"active"`
+		Scope    string `json:"scope"`
+		ClientID string `json:"client_id"`
+		Exp      int64  `json:"exp"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to parse introspection response: %w", err)
+	}
+	if !result.Active {
+		return &TokenSubject{Active: false}, time.Time{}, nil
+	}
+	subject := &TokenSubject{Active: true, Scopes: strings.Fields(result.Scope), ClientID: result.ClientID}
+	var exp time.Time
+	if result.Exp > 0 {
+		exp = time.Unix(result.Exp, 0)
+	}
+	return subject, exp, nil
+}
+
+// getTokenIntrospector returns the introspector of token_introspection_url, or nil when it is not set.
+func (c *Config) getTokenIntrospector() *tokenIntrospector {
+	c.introspectionOnce.Do(func() {
+		c.introspector = newTokenIntrospector(c)
+	})
+	return c.introspector
+}
+
+// resolveTokenSubject sets the subject of payload to the introspection result of its bearer
+// token. It runs once the request is to be evaluated rather than when the payload is composed, so
+// that skipped requests do not call token_introspection_url.
+func resolveTokenSubject(ctx context.Context, conf *Config, payload *SidebandAccessRequest, logger *PluginLogger) {
+	var err error
+	if payload.Subject, err = introspectToken(ctx, conf, payload.requestHeaders); err != nil {
+		logger.Warn("Sending request without token subject", "error", err.Error())
+	}
+}
+
+// introspectToken returns the introspection result of the bearer token in the Authorization
+// header when token_introspection_url is set. Requests without a bearer token, and failed
+// introspection calls, get no subject.
+func introspectToken(ctx context.Context, conf *Config, headers map[string][]string) (*TokenSubject, error) {
+	introspector := conf.getTokenIntrospector()
+	if introspector == nil {
+		return nil, nil
+	}
+	token := bearerToken(headers)
+	if token == "" {
+		return nil, nil
+	}
+	subject, err := introspector.introspect(ctx, token)
+	if err != nil {
+		return nil, fmt.Errorf("token introspection failed: %w", err)
+	}
+	return subject, nil
+}
+
+// validateTokenIntrospection checks the token_introspection_* settings.
+func validateTokenIntrospection(c *Config) error {
+	if c.TokenIntrospectionCacheTTLSec < 0 {
+		return fmt.Errorf("token_introspection_cache_ttl_sec must be >= 0, got %d", c.TokenIntrospectionCacheTTLSec)
+	}
+	if c.TokenIntrospectionURL == "" {
+		if c.TokenIntrospectionClientID != "" || c.TokenIntrospectionClientSecret != "" {
+			return fmt.Errorf("token_introspection_url is required when other token_introspection_* fields are set")
+		}
+		return nil
+	}
+	if err := validateServiceURL("token_introspection_url", c.TokenIntrospectionURL); err != nil {
+		return err
+	}
+	if c.TokenIntrospectionClientID == "" || c.TokenIntrospectionClientSecret == "" {
+		return fmt.Errorf("token_introspection_client_id and token_introspection_client_secret are required with token_introspection_url")
+	}
+	return nil
+}
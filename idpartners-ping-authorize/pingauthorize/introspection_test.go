@@ -0,0 +1,222 @@
+package pingauthorize
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// introspectionServer answers RFC 7662 requests with responses[token], or inactive, and counts them.
+func introspectionServer(t *testing.T, responses map[string]string, calls *atomic.Int64) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if user, pass, ok := r.BasicAuth(); !ok || user != "kong" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		r.ParseForm()
+		if r.PostForm.Get("token_type_hint") != "access_token" {
+			t.Errorf("unexpected token_type_hint %q", r.PostForm.Get("token_type_hint"))
+		}
+		resp, ok := responses[r.PostForm.Get("token")]
+		if !ok {
+			resp = `{"active":false}`
+		}
+		w.Write([]byte(resp))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func introspectionConfig(url string) *Config {
+	return &Config{
+		TokenIntrospectionURL:          url,
+		TokenIntrospectionClientID:     "kong",
+		TokenIntrospectionClientSecret: "secret",
+		ConnectionTimeoutMs:            1000,
+	}
+}
+
+func TestIntrospectToken(t *testing.T) {
+	var calls atomic.Int64
+	server := introspectionServer(t, map[string]string{
+		"good": `{"active":true,"scope":"read write","client_id":"web","sub":"alice"}`,
+	}, &calls)
+
+	tests := []struct {
+		name          string
+		conf          *Config
+		authorization string
+		want          *TokenSubject
+		wantErr       bool
+	}{
+		{"active", introspectionConfig(server.URL), "Bearer good", &TokenSubject{Active: true, Scopes: []string{"read", "write"}, ClientID: "web"}, false},
+		{"inactive", introspectionConfig(server.URL), "Bearer revoked", &TokenSubject{Active: false}, false},
+		{"no bearer token", introspectionConfig(server.URL), "Basic a29uZzpzZWNyZXQ=", nil, false},
+		{"endpoint error", &Config{TokenIntrospectionURL: server.URL, TokenIntrospectionClientID: "kong", TokenIntrospectionClientSecret: "wrong"}, "Bearer good", nil, true},
+		{"not configured", &Config{}, "Bearer good", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := introspectToken(context.Background(), tt.conf, map[string][]string{"Authorization": {tt.authorization}})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("subject = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenIntrospector_Cache(t *testing.T) {
+	now := time.Now()
+	var calls atomic.Int64
+	server := introspectionServer(t, map[string]string{
+		"long":  `{"active":true,"exp":` + jsonInt(now.Add(time.Hour).Unix()) + `}`,
+		"short": `{"active":true,"exp":` + jsonInt(now.Add(10*time.Second).Unix()) + `}`,
+	}, &calls)
+
+	conf := introspectionConfig(server.URL)
+	conf.TokenIntrospectionCacheTTLSec = 60
+	introspector := newTokenIntrospector(conf)
+	introspector.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for _, token := range []string{"long", "long", "short", "short", "revoked", "revoked"} {
+		if _, err := introspector.introspect(ctx, token); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls.Load() != 3 {
+		t.Fatalf("expected one call per token, got %d", calls.Load())
+	}
+
+	now = now.Add(30 * time.Second)
+	for _, token := range []string{"long", "short"} {
+		introspector.introspect(ctx, token)
+	}
+	if calls.Load() != 4 {
+		t.Errorf("only the token past its exp should be introspected again, got %d calls", calls.Load())
+	}
+
+	now = now.Add(time.Minute)
+	introspector.introspect(ctx, "long")
+	introspector.introspect(ctx, "revoked")
+	if calls.Load() != 6 {
+		t.Errorf("results should expire after the cache TTL, got %d calls", calls.Load())
+	}
+}
+
+func jsonInt(n int64) string {
+	data, _ := json.Marshal(n)
+	return string(data)
+}
+
+func TestValidateTokenIntrospection(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		id      string
+		secret  string
+		ttl     int
+		wantErr string
+	}{
+		{"disabled", "", "", "", 0, ""},
+		{"valid", "https://pingfederate:9031/as/introspect.oauth2", "kong", "secret", 30, ""},
+		{"missing credentials", "https://pingfederate:9031/as/introspect.oauth2", "kong", "", 0, "token_introspection_client_secret"},
+		{"credentials without url", "", "kong", "secret", 0, "token_introspection_url is required"},
+		{"bad url", "ftp://pingfederate/introspect", "kong", "secret", 0, "token_introspection_url"},
+		{"negative ttl", "", "", "", -1, "token_introspection_cache_ttl_sec"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := &Config{
+				TokenIntrospectionURL:          tt.url,
+				TokenIntrospectionClientID:     tt.id,
+				TokenIntrospectionClientSecret: tt.secret,
+				TokenIntrospectionCacheTTLSec:  tt.ttl,
+			}
+			err := validateTokenIntrospection(conf)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMiddleware_TokenIntrospection(t *testing.T) {
+	var seen SidebandAccessRequest
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		seen = SidebandAccessRequest{}
+		json.NewDecoder(r.Body).Decode(&seen)
+		json.NewEncoder(w).Encode(SidebandAccessResponse{Response: &DenyResponse{ResponseCode: "403"}})
+	})
+	defer server.Close()
+
+	var calls atomic.Int64
+	idp := introspectionServer(t, map[string]string{"good": `{"active":true,"scope":"orders:read","client_id":"web"}`}, &calls)
+
+	m := newTestMiddleware(t, server.URL, false)
+	m.conf.TokenIntrospectionURL = idp.URL
+	m.conf.TokenIntrospectionClientID = "kong"
+	m.conf.TokenIntrospectionClientSecret = "secret"
+	calls2 := 0
+	h := m.Handler(upstreamEcho(t, &calls2))
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("GET", "http://api.example.com/orders", nil)
+		req.Header.Set("Authorization", "Bearer good")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	want := &TokenSubject{Active: true, Scopes: []string{"orders:read"}, ClientID: "web"}
+	if !reflect.DeepEqual(seen.Subject, want) {
+		t.Errorf("subject = %+v, want %+v", seen.Subject, want)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("expected the introspection result to be cached, got %d calls", calls.Load())
+	}
+}
+
+func TestMiddleware_TokenIntrospectionSkipped(t *testing.T) {
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("skipped requests must not be evaluated")
+	})
+	defer server.Close()
+
+	var calls atomic.Int64
+	idp := introspectionServer(t, map[string]string{"good": `{"active":true}`}, &calls)
+
+	m := newTestMiddleware(t, server.URL, false)
+	m.conf.TokenIntrospectionURL = idp.URL
+	m.conf.TokenIntrospectionClientID = "kong"
+	m.conf.TokenIntrospectionClientSecret = "secret"
+	m.conf.SkipExpression = "request.method == 'OPTIONS'"
+	upstreamCalls := 0
+	h := m.Handler(upstreamEcho(t, &upstreamCalls))
+
+	req := httptest.NewRequest("OPTIONS", "http://api.example.com/orders", nil)
+	req.Header.Set("Authorization", "Bearer good")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if upstreamCalls != 1 {
+		t.Errorf("expected the skipped request to reach the upstream, got %d calls", upstreamCalls)
+	}
+	if calls.Load() != 0 {
+		t.Errorf("expected no introspection call for a skipped request, got %d", calls.Load())
+	}
+}
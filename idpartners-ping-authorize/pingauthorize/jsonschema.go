@@ -0,0 +1,263 @@
+package pingauthorize
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// jsonSchema is the subset of JSON Schema used to validate MCP tool arguments: type, enum, const,
+// properties, required, additionalProperties, items, the numeric bounds, minLength, maxLength,
+// pattern, minItems, maxItems, minProperties and maxProperties. Other keywords are ignored.
+type jsonSchema struct {
+	Type                 jsonSchemaTypes        `json:"type"`
+	Enum                 []interface{}          `json:"enum"`
+	Const                json.RawMessage        `json:"const"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	Required             []string               `json:"required"`
+	AdditionalProperties json.RawMessage        `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+	ExclusiveMinimum     *float64               `json:"exclusiveMinimum"`
+	ExclusiveMaximum     *float64               `json:"exclusiveMaximum"`
+	MinLength            *int                   `json:"minLength"`
+	MaxLength            *int                   `json:"maxLength"`
+	Pattern              string                 `json:"pattern"`
+	MinItems             *int                   `json:"minItems"`
+	MaxItems             *int                   `json:"maxItems"`
+	MinProperties        *int                   `json:"minProperties"`
+	MaxProperties        *int                   `json:"maxProperties"`
+
+	pattern    *regexp.Regexp
+	constValue interface{}
+	// additional is the additionalProperties schema; noAdditional is set when it is false
+	additional   *jsonSchema
+	noAdditional bool
+}
+
+// jsonSchemaTypes is the type keyword, a single type name or a list of them.
+type jsonSchemaTypes []string
+
+func (t *jsonSchemaTypes) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*t = jsonSchemaTypes{name}
+		return nil
+	}
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return fmt.Errorf("type must be a string or an array of strings")
+	}
+	*t = names
+	return nil
+}
+
+// compileJSONSchema parses a JSON Schema document and compiles its patterns.
+func compileJSONSchema(text string) (*jsonSchema, error) {
+	var schema jsonSchema
+	if err := json.Unmarshal([]byte(text), &schema); err != nil {
+		return nil, err
+	}
+	if err := schema.compile(); err != nil {
+		return nil, err
+	}
+	return &schema, nil
+}
+
+func (s *jsonSchema) compile() error {
+	for _, name := range s.Type {
+		switch name {
+		case "object", "array", "string", "number", "integer", "boolean", "null":
+		default:
+			return fmt.Errorf("unknown type %q", name)
+		}
+	}
+	if s.Pattern != "" {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", s.Pattern, err)
+		}
+		s.pattern = re
+	}
+	if len(s.Const) > 0 {
+		json.Unmarshal(s.Const, &s.constValue)
+	}
+	switch trimmed := strings.TrimSpace(string(s.AdditionalProperties)); trimmed {
+	case "", "true":
+	case "false":
+		s.noAdditional = true
+	default:
+		s.additional = &jsonSchema{}
+		if err := json.Unmarshal(s.AdditionalProperties, s.additional); err != nil {
+			return fmt.Errorf("additionalProperties: %w", err)
+		}
+	}
+
+	children := []*jsonSchema{s.Items, s.additional}
+	for _, child := range s.Properties {
+		children = append(children, child)
+	}
+	for _, child := range children {
+		if child == nil {
+			continue
+		}
+		if err := child.compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validate checks v, a value decoded by encoding/json, against the schema. path locates v in the
+// error, e.g. "arguments.query".
+func (s *jsonSchema) validate(v interface{}, path string) error {
+	if len(s.Type) > 0 && !s.hasType(v) {
+		return fmt.Errorf("%s must be of type %s", path, strings.Join(s.Type, " or "))
+	}
+	if len(s.Enum) > 0 {
+		found := false
+		for _, allowed := range s.Enum {
+			if reflect.DeepEqual(v, allowed) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s must be one of the enumerated values", path)
+		}
+	}
+	if len(s.Const) > 0 && !reflect.DeepEqual(v, s.constValue) {
+		return fmt.Errorf("%s must be %s", path, s.Const)
+	}
+
+	switch v := v.(type) {
+	case float64:
+		return s.validateNumber(v, path)
+	case string:
+		return s.validateString(v, path)
+	case []interface{}:
+		return s.validateArray(v, path)
+	case map[string]interface{}:
+		return s.validateObject(v, path)
+	}
+	return nil
+}
+
+func (s *jsonSchema) hasType(v interface{}) bool {
+	for _, name := range s.Type {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if name == "object" {
+				return true
+			}
+		case []interface{}:
+			if name == "array" {
+				return true
+			}
+		case string:
+			if name == "string" {
+				return true
+			}
+		case float64:
+			if name == "number" || (name == "integer" && v == math.Trunc(v)) {
+				return true
+			}
+		case bool:
+			if name == "boolean" {
+				return true
+			}
+		case nil:
+			if name == "null" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *jsonSchema) validateNumber(v float64, path string) error {
+	switch {
+	case s.Minimum != nil && v < *s.Minimum:
+		return fmt.Errorf("%s must be >= %g", path, *s.Minimum)
+	case s.Maximum != nil && v > *s.Maximum:
+		return fmt.Errorf("%s must be <= %g", path, *s.Maximum)
+	case s.ExclusiveMinimum != nil && v <= *s.ExclusiveMinimum:
+		return fmt.Errorf("%s must be > %g", path, *s.ExclusiveMinimum)
+	case s.ExclusiveMaximum != nil && v >= *s.ExclusiveMaximum:
+		return fmt.Errorf("%s must be < %g", path, *s.ExclusiveMaximum)
+	}
+	return nil
+}
+
+func (s *jsonSchema) validateString(v string, path string) error {
+	length := utf8.RuneCountInString(v)
+	switch {
+	case s.MinLength != nil && length < *s.MinLength:
+		return fmt.Errorf("%s must be at least %d characters", path, *s.MinLength)
+	case s.MaxLength != nil && length > *s.MaxLength:
+		return fmt.Errorf("%s must be at most %d characters", path, *s.MaxLength)
+	case s.pattern != nil && !s.pattern.MatchString(v):
+		return fmt.Errorf("%s must match %q", path, s.Pattern)
+	}
+	return nil
+}
+
+func (s *jsonSchema) validateArray(v []interface{}, path string) error {
+	switch {
+	case s.MinItems != nil && len(v) < *s.MinItems:
+		return fmt.Errorf("%s must have at least %d items", path, *s.MinItems)
+	case s.MaxItems != nil && len(v) > *s.MaxItems:
+		return fmt.Errorf("%s must have at most %d items", path, *s.MaxItems)
+	}
+	if s.Items != nil {
+		for i, item := range v {
+			if err := s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *jsonSchema) validateObject(v map[string]interface{}, path string) error {
+	switch {
+	case s.MinProperties != nil && len(v) < *s.MinProperties:
+		return fmt.Errorf("%s must have at least %d properties", path, *s.MinProperties)
+	case s.MaxProperties != nil && len(v) > *s.MaxProperties:
+		return fmt.Errorf("%s must have at most %d properties", path, *s.MaxProperties)
+	}
+	for _, name := range s.Required {
+		if _, ok := v[name]; !ok {
+			return fmt.Errorf("%s.%s is required", path, name)
+		}
+	}
+
+	// Sorted, so the error reported for several invalid properties is stable
+	names := make([]string, 0, len(v))
+	for name := range v {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		schema, ok := s.Properties[name]
+		if !ok {
+			if s.noAdditional {
+				return fmt.Errorf("%s.%s is not allowed", path, name)
+			}
+			schema = s.additional
+		}
+		if schema == nil {
+			continue
+		}
+		if err := schema.validate(v[name], path+"."+name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package pingauthorize
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCompileJSONSchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  string
+		wantErr bool
+	}{
+		{"empty", `{}`, false},
+		{"type list", `{"type":["string","null"]}`, false},
+		{"nested", `{"type":"object","properties":{"q":{"type":"string","pattern":"^a"}},"additionalProperties":{"type":"number"}}`, false},
+		{"not json", `{`, true},
+		{"unknown type", `{"type":"text"}`, true},
+		{"bad type", `{"type":1}`, true},
+		{"bad pattern", `{"type":"string","pattern":"("}`, true},
+		{"bad nested pattern", `{"items":{"pattern":"["}}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := compileJSONSchema(tt.schema)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("compileJSONSchema(%s) error = %v, wantErr %v", tt.schema, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestJSONSchemaValidate(t *testing.T) {
+	const schema = `{
+		"type": "object",
+		"required": ["query"],
+		"properties": {
+			"query": {"type": "string", "minLength": 1, "maxLength": 10, "pattern": "^[a-z ]+$"},
+			"limit": {"type": "integer", "minimum": 1, "maximum": 100},
+			"score": {"type": "number", "exclusiveMinimum": 0, "exclusiveMaximum": 1},
+			"mode": {"enum": ["fast", "exact"]},
+			"version": {"const": 2},
+			"tags": {"type": "array", "minItems": 1, "maxItems": 2, "items": {"type": "string"}},
+			"filter": {"type": ["object", "null"], "maxProperties": 1, "additionalProperties": {"type": "boolean"}}
+		},
+		"additionalProperties": false
+	}`
+	compiled, err := compileJSONSchema(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		args    string
+		wantErr string
+	}{
+		{"valid", `{"query":"cats","limit":5,"score":0.5,"mode":"fast","version":2,"tags":["a"],"filter":{"new":true}}`, ""},
+		{"null union", `{"query":"cats","filter":null}`, ""},
+		{"not an object", `[]`, "arguments must be of type object"},
+		{"missing required", `{}`, "arguments.query is required"},
+		{"additional property", `{"query":"cats","extra":1}`, "arguments.extra is not allowed"},
+		{"wrong type", `{"query":1}`, "arguments.query must be of type string"},
+		{"too short", `{"query":""}`, "arguments.query must be at least 1 characters"},
+		{"too long", `{"query":"abcdefghijk"}`, "arguments.query must be at most 10 characters"},
+		{"pattern", `{"query":"DROP"}`, `arguments.query must match "^[a-z ]+$"`},
+		{"not integer", `{"query":"a","limit":1.5}`, "arguments.limit must be of type integer"},
+		{"below minimum", `{"query":"a","limit":0}`, "arguments.limit must be >= 1"},
+		{"above maximum", `{"query":"a","limit":101}`, "arguments.limit must be <= 100"},
+		{"exclusive minimum", `{"query":"a","score":0}`, "arguments.score must be > 0"},
+		{"exclusive maximum", `{"query":"a","score":1}`, "arguments.score must be < 1"},
+		{"enum", `{"query":"a","mode":"slow"}`, "arguments.mode must be one of the enumerated values"},
+		{"const", `{"query":"a","version":3}`, "arguments.version must be 2"},
+		{"too few items", `{"query":"a","tags":[]}`, "arguments.tags must have at least 1 items"},
+		{"too many items", `{"query":"a","tags":["a","b","c"]}`, "arguments.tags must have at most 2 items"},
+		{"item type", `{"query":"a","tags":["a",2]}`, "arguments.tags[1] must be of type string"},
+		{"additional schema", `{"query":"a","filter":{"new":"yes"}}`, "arguments.filter.new must be of type boolean"},
+		{"too many properties", `{"query":"a","filter":{"a":true,"b":true}}`, "arguments.filter must have at most 1 properties"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v interface{}
+			if err := json.Unmarshal([]byte(tt.args), &v); err != nil {
+				t.Fatal(err)
+			}
+			err := compiled.validate(v, "arguments")
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
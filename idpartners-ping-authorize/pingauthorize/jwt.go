@@ -0,0 +1,337 @@
+package pingauthorize
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// jwksCacheTTL is how long a fetched JWKS is used before it is fetched again.
+	jwksCacheTTL = 10 * time.Minute
+	// jwksMinRefreshInterval bounds how often an unknown kid triggers a JWKS fetch.
+	jwksMinRefreshInterval = 30 * time.Second
+	// maxJWKSResponseBytes caps the size of a JWKS response.
+	maxJWKSResponseBytes = 1 << 20
+	// jwtClockSkew is the leeway applied to exp and nbf when tokens are verified.
+	jwtClockSkew = 30 * time.Second
+)
+
+// jwtAlgorithms maps the JWS algorithms accepted for verified tokens to their hash and whether
+// they are RSA-PSS. HMAC and none are never accepted.
+var jwtAlgorithms = map[string]struct {
+	hash crypto.Hash
+	pss  bool
+}{
+	"RS256": {crypto.SHA256, false}, "RS384": {crypto.SHA384, false}, "RS512": {crypto.SHA512, false},
+	"PS256": {crypto.SHA256, true}, "PS384": {crypto.SHA384, true}, "PS512": {crypto.SHA512, true},
+	"ES256": {crypto.SHA256, false}, "ES384": {crypto.SHA384, false}, "ES512": {crypto.SHA512, false},
+}
+
+// jwtToken is a parsed, not yet verified, compact JWS.
+type jwtToken struct {
+	header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	claims       map[string]interface{}
+	signingInput string
+	signature    []byte
+}
+
+// parseJWT decodes a compact JWS without verifying it. Claims keep numbers as json.Number.
+func parseJWT(token string) (*jwtToken, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("token is not a compact JWS")
+	}
+	t := &jwtToken{signingInput: parts[0] + "." + parts[1]}
+
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid token header: %w", err)
+	}
+	if err := json.Unmarshal(header, &t.header); err != nil {
+		return nil, fmt.Errorf("invalid token header: %w", err)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid token payload: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	if err := dec.Decode(&t.claims); err != nil || t.claims == nil {
+		return nil, fmt.Errorf("token payload is not a JSON object")
+	}
+	if t.signature, err = base64.RawURLEncoding.DecodeString(parts[2]); err != nil {
+		return nil, fmt.Errorf("invalid token signature: %w", err)
+	}
+	return t, nil
+}
+
+// verify checks the signature of the token with key and its exp and nbf claims against now.
+func (t *jwtToken) verify(key crypto.PublicKey, now time.Time) error {
+	alg, ok := jwtAlgorithms[t.header.Alg]
+	if !ok {
+		return fmt.Errorf("unsupported alg %q", t.header.Alg)
+	}
+	h := alg.hash.New()
+	h.Write([]byte(t.signingInput))
+	digest := h.Sum(nil)
+
+	var err error
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		switch {
+		case t.header.Alg[0] != 'R' && t.header.Alg[0] != 'P':
+			err = fmt.Errorf("alg %s does not match an RSA key", t.header.Alg)
+		case alg.pss:
+			err = rsa.VerifyPSS(k, alg.hash, digest, t.signature, nil)
+		default:
+			err = rsa.VerifyPKCS1v15(k, alg.hash, digest, t.signature)
+		}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if t.header.Alg[0] != 'E' || len(t.signature) != 2*size {
+			return errors.New("invalid ECDSA signature")
+		}
+		r, s := new(big.Int).SetBytes(t.signature[:size]), new(big.Int).SetBytes(t.signature[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			err = errors.New("invalid ECDSA signature")
+		}
+	default:
+		err = errors.New("unsupported key type")
+	}
+	if err != nil {
+		return fmt.Errorf("signature verification failed: %w", err)
+	}
+
+	if exp, ok := t.numericClaim("exp"); ok && now.After(exp.Add(jwtClockSkew)) {
+		return errors.New("token has expired")
+	}
+	if nbf, ok := t.numericClaim("nbf"); ok && now.Add(jwtClockSkew).Before(nbf) {
+		return errors.New("token is not valid yet")
+	}
+	return nil
+}
+
+// numericClaim returns a NumericDate claim such as exp as a time.
+func (t *jwtToken) numericClaim(name string) (time.Time, bool) {
+	n, ok := t.claims[name].(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	secs, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(secs), 0), true
+}
+
+// jwksCache fetches and caches the public keys of a JWKS URL, by kid.
+type jwksCache struct {
+	url    string
+	client *http.Client
+	now    func() time.Time
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetched   time.Time // last successful fetch
+	attempted time.Time // last fetch attempt
+}
+
+func newJWKSCache(config *Config) *jwksCache {
+	return &jwksCache{
+		url: config.JWTJWKSURL,
+		client: &http.Client{
+			Timeout: time.Duration(config.ConnectionTimeoutMs) * time.Millisecond,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: !config.VerifyServiceCert},
+			},
+		},
+		now: time.Now,
+	}
+}
+
+// key returns the key with the given kid, fetching the JWKS when the cache is older than
+// jwksCacheTTL or kid is unknown. Fetches are at least jwksMinRefreshInterval apart; when one
+// fails, the keys fetched before are kept. An empty kid matches the only key of a single-key set.
+func (c *jwksCache) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	key, found := c.lookup(kid)
+	stale := !found || now.Sub(c.fetched) > jwksCacheTTL
+	if stale && (c.attempted.IsZero() || now.Sub(c.attempted) > jwksMinRefreshInterval) {
+		c.attempted = now
+		keys, err := c.fetch(ctx)
+		if err != nil {
+			NewPluginLogger(nil, "access", "").Warn("Failed to fetch JWKS", "url", c.url, "error", err.Error())
+		} else {
+			c.keys, c.fetched = keys, now
+		}
+		key, found = c.lookup(kid)
+	}
+	if !found {
+		return nil, fmt.Errorf("no JWKS key for kid %q", kid)
+	}
+	return key, nil
+}
+
+func (c *jwksCache) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(c.keys) == 1 {
+		for _, k := range c.keys {
+			return k, true
+		}
+	}
+	k, ok := c.keys[kid]
+	return k, ok
+}
+
+// fetch requests the JWKS and parses its RSA and EC signing keys. Other keys are ignored.
+func (c *jwksCache) fetch(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", fmt.Sprintf("Kong/%s", Version))
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS endpoint returned %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxJWKSResponseBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWKS: %w", err)
+	}
+
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Use string `json:"use"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(body, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			curve := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}[k.Crv]
+			x, errX := base64.RawURLEncoding.DecodeString(k.X)
+			y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+			if curve == nil || errX != nil || errY != nil {
+				continue
+			}
+			pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+			if !curve.IsOnCurve(pub.X, pub.Y) {
+				continue
+			}
+			keys[k.Kid] = pub
+		}
+	}
+	return keys, nil
+}
+
+// getJWKS returns the JWKS cache of jwt_jwks_url, or nil when it is not set.
+func (c *Config) getJWKS() *jwksCache {
+	c.jwksOnce.Do(func() {
+		if c.JWTJWKSURL != "" {
+			c.jwks = newJWKSCache(c)
+		}
+	})
+	return c.jwks
+}
+
+// resolveTokenClaims sets the token_claims of payload. It runs once the request is to be evaluated
+// rather than when the payload is composed, since verifying the token may fetch jwt_jwks_url.
+func resolveTokenClaims(ctx context.Context, conf *Config, payload *SidebandAccessRequest) {
+	payload.TokenClaims, _ = extractTokenClaims(ctx, conf, payload.requestHeaders)
+}
+
+// extractTokenClaims returns the jwt_claims_to_forward claims of the bearer token in the
+// Authorization header. The token is verified against jwt_jwks_url when it is set. Requests
+// without a bearer token, or whose token is malformed or fails verification, get no claims.
+func extractTokenClaims(ctx context.Context, conf *Config, headers map[string][]string) (map[string]interface{}, error) {
+	if len(conf.JWTClaimsToForward) == 0 {
+		return nil, nil
+	}
+	token := bearerToken(headers)
+	if token == "" {
+		return nil, nil
+	}
+
+	parsed, err := parseJWT(token)
+	if err != nil {
+		return nil, err
+	}
+	if jwks := conf.getJWKS(); jwks != nil {
+		key, err := jwks.key(ctx, parsed.header.Kid)
+		if err != nil {
+			return nil, err
+		}
+		if err := parsed.verify(key, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+
+	claims := make(map[string]interface{}, len(conf.JWTClaimsToForward))
+	for _, name := range conf.JWTClaimsToForward {
+		if v, ok := parsed.claims[name]; ok {
+			claims[name] = v
+		}
+	}
+	if len(claims) == 0 {
+		return nil, nil
+	}
+	return claims, nil
+}
+
+// bearerToken returns the token of a Bearer Authorization header, or "".
+func bearerToken(headers map[string][]string) string {
+	for name, values := range headers {
+		if strings.EqualFold(name, "Authorization") && len(values) > 0 {
+			if scheme, value, ok := strings.Cut(values[0], " "); ok && strings.EqualFold(scheme, "Bearer") {
+				return strings.TrimSpace(value)
+			}
+		}
+	}
+	return ""
+}
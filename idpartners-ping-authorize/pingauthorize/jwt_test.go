@@ -0,0 +1,282 @@
+package pingauthorize
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// signJWT builds a compact JWS over claims with the given alg, kid and key.
+func signJWT(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(input))
+
+	var sig []byte
+	var err error
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if strings.HasPrefix(alg, "PS") {
+			sig, err = rsa.SignPSS(rand.Reader, k, crypto.SHA256, digest[:], nil)
+		} else {
+			sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		}
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k, digest[:])
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// jwksServer serves the public keys of keys by kid and counts the requests.
+func jwksServer(t *testing.T, keys map[string]crypto.Signer, fetches *atomic.Int64) *httptest.Server {
+	t.Helper()
+	b64 := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	var set []map[string]string
+	for kid, key := range keys {
+		switch k := key.Public().(type) {
+		case *rsa.PublicKey:
+			set = append(set, map[string]string{"kid": kid, "kty": "RSA", "use": "sig", "n": b64(k.N.Bytes()), "e": b64(big.NewInt(int64(k.E)).Bytes())})
+		case *ecdsa.PublicKey:
+			set = append(set, map[string]string{"kid": kid, "kty": "EC", "crv": "P-256", "x": b64(k.X.FillBytes(make([]byte, 32))), "y": b64(k.Y.FillBytes(make([]byte, 32)))})
+		}
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": set})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestJWTVerify(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	now := time.Now()
+	valid := map[string]interface{}{"sub": "alice", "exp": now.Add(time.Hour).Unix()}
+
+	tests := []struct {
+		name    string
+		token   string
+		key     crypto.PublicKey
+		wantErr string
+	}{
+		{"RS256", signJWT(t, "RS256", "", rsaKey, valid), rsaKey.Public(), ""},
+		{"PS256", signJWT(t, "PS256", "", rsaKey, valid), rsaKey.Public(), ""},
+		{"ES256", signJWT(t, "ES256", "", ecKey, valid), ecKey.Public(), ""},
+		{"wrong key", signJWT(t, "RS256", "", rsaKey, valid), otherKey.Public(), "signature"},
+		{"alg mismatch", signJWT(t, "RS256", "", rsaKey, valid), ecKey.Public(), "signature"},
+		{"none", signJWT(t, "none", "", rsaKey, valid), rsaKey.Public(), "unsupported alg"},
+		{"HS256", signJWT(t, "HS256", "", rsaKey, valid), rsaKey.Public(), "unsupported alg"},
+		{"expired", signJWT(t, "RS256", "", rsaKey, map[string]interface{}{"exp": now.Add(-time.Minute).Unix()}), rsaKey.Public(), "expired"},
+		{"within skew", signJWT(t, "RS256", "", rsaKey, map[string]interface{}{"exp": now.Add(-10 * time.Second).Unix()}), rsaKey.Public(), ""},
+		{"not yet valid", signJWT(t, "RS256", "", rsaKey, map[string]interface{}{"nbf": now.Add(time.Minute).Unix()}), rsaKey.Public(), "not valid yet"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, err := parseJWT(tt.token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = parsed.verify(tt.key, now)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	for _, token := range []string{"abc", "a.b", "!.e30.", "e30.bm90LWpzb24.", "e30.W10."} {
+		if _, err := parseJWT(token); err == nil {
+			t.Errorf("parseJWT(%q): expected error", token)
+		}
+	}
+}
+
+func TestJWKSCache(t *testing.T) {
+	key1, _ := rsa.GenerateKey(rand.Reader, 2048)
+	var fetches atomic.Int64
+	server := jwksServer(t, map[string]crypto.Signer{"k1": key1}, &fetches)
+
+	now := time.Now()
+	cache := newJWKSCache(&Config{JWTJWKSURL: server.URL, ConnectionTimeoutMs: 1000})
+	cache.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	if _, err := cache.key(ctx, "k1"); err != nil || fetches.Load() != 1 {
+		t.Fatalf("first lookup: err=%v fetches=%d", err, fetches.Load())
+	}
+	if _, err := cache.key(ctx, ""); err != nil || fetches.Load() != 1 {
+		t.Errorf("empty kid should match the only key from the cache: err=%v fetches=%d", err, fetches.Load())
+	}
+	if _, err := cache.key(ctx, "k2"); err == nil || fetches.Load() != 1 {
+		t.Errorf("unknown kid should not refetch within the refresh interval: err=%v fetches=%d", err, fetches.Load())
+	}
+	now = now.Add(jwksMinRefreshInterval + time.Second)
+	cache.key(ctx, "k2")
+	cache.key(ctx, "k2")
+	if fetches.Load() != 2 {
+		t.Errorf("unknown kid should refetch once per refresh interval, got %d fetches", fetches.Load())
+	}
+	now = now.Add(jwksCacheTTL + time.Second)
+	if _, err := cache.key(ctx, "k1"); err != nil || fetches.Load() != 3 {
+		t.Errorf("stale cache should be refetched: err=%v fetches=%d", err, fetches.Load())
+	}
+
+	server.Close()
+	now = now.Add(jwksCacheTTL + time.Second)
+	if _, err := cache.key(ctx, "k1"); err != nil {
+		t.Errorf("keys should be kept when a refresh fails: %v", err)
+	}
+}
+
+func TestExtractTokenClaims(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	forged, _ := rsa.GenerateKey(rand.Reader, 2048)
+	var fetches atomic.Int64
+	server := jwksServer(t, map[string]crypto.Signer{"k1": key}, &fetches)
+
+	claims := map[string]interface{}{"sub": "alice", "scope": "read write", "org_id": 12345678901234567, "email": "a@example.com"}
+	token := signJWT(t, "RS256", "k1", key, claims)
+	forward := []string{"sub", "scope", "org_id", "missing"}
+
+	tests := []struct {
+		name          string
+		conf          *Config
+		authorization string
+		want          string
+	}{
+		{"unverified", &Config{JWTClaimsToForward: forward}, "Bearer " + token, `{"org_id":12345678901234567,"scope":"read write","sub":"alice"}`},
+		{"verified", &Config{JWTClaimsToForward: forward, JWTJWKSURL: server.URL}, "bearer " + token, `{"org_id":12345678901234567,"scope":"read write","sub":"alice"}`},
+		{"forged", &Config{JWTClaimsToForward: forward, JWTJWKSURL: server.URL}, "Bearer " + signJWT(t, "RS256", "k1", forged, claims), `null`},
+		{"forged unverified", &Config{JWTClaimsToForward: forward}, "Bearer " + signJWT(t, "RS256", "k1", forged, claims), `{"org_id":12345678901234567,"scope":"read write","sub":"alice"}`},
+		{"basic auth", &Config{JWTClaimsToForward: forward}, "Basic YWxpY2U6c2VjcmV0", `null`},
+		{"no matching claims", &Config{JWTClaimsToForward: []string{"tenant"}}, "Bearer " + token, `null`},
+		{"not configured", &Config{}, "Bearer " + token, `null`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := extractTokenClaims(context.Background(), tt.conf, map[string][]string{"Authorization": {tt.authorization}})
+			if data, _ := json.Marshal(got); string(data) != tt.want {
+				t.Errorf("claims = %s, want %s", data, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateJWTClaims(t *testing.T) {
+	tests := []struct {
+		name    string
+		claims  []string
+		jwksURL string
+		wantErr string
+	}{
+		{"claims only", []string{"sub"}, "", ""},
+		{"verified", []string{"sub"}, "https://idp.example.com/jwks", ""},
+		{"empty claim", []string{""}, "", "jwt_claims_to_forward"},
+		{"jwks without claims", nil, "https://idp.example.com/jwks", "jwt_claims_to_forward is required"},
+		{"bad jwks url", []string{"sub"}, "ftp://idp.example.com/jwks", "jwt_jwks_url"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := NewConfig()
+			conf.ServiceURL = "https://paz.example.com"
+			conf.SharedSecret = "secret"
+			conf.SecretHeaderName = "X-Secret"
+			conf.JWTClaimsToForward, conf.JWTJWKSURL = tt.claims, tt.jwksURL
+			err := conf.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMiddleware_TokenClaims(t *testing.T) {
+	var seen map[string]json.RawMessage
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&seen)
+		json.NewEncoder(w).Encode(SidebandAccessResponse{Response: &DenyResponse{ResponseCode: "403"}})
+	})
+	defer server.Close()
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	var fetches atomic.Int64
+	jwks := jwksServer(t, map[string]crypto.Signer{"ec": key}, &fetches)
+
+	m := newTestMiddleware(t, server.URL, false)
+	m.conf.JWTClaimsToForward = []string{"sub", "scope"}
+	m.conf.JWTJWKSURL = jwks.URL
+	calls := 0
+	h := m.Handler(upstreamEcho(t, &calls))
+
+	req := httptest.NewRequest("GET", "http://api.example.com/accounts", nil)
+	req.Header.Set("Authorization", "Bearer "+signJWT(t, "ES256", "ec", key, map[string]interface{}{"sub": "alice", "scope": "read", "iss": "idp"}))
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if want := `{"scope":"read","sub":"alice"}`; string(seen["token_claims"]) != want {
+		t.Errorf("token_claims = %s, want %s", seen["token_claims"], want)
+	}
+}
+
+func TestMiddleware_TokenClaimsSkipped(t *testing.T) {
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("skipped requests must not be evaluated")
+	})
+	defer server.Close()
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	var fetches atomic.Int64
+	jwks := jwksServer(t, map[string]crypto.Signer{"ec": key}, &fetches)
+
+	m := newTestMiddleware(t, server.URL, false)
+	m.conf.JWTClaimsToForward = []string{"sub"}
+	m.conf.JWTJWKSURL = jwks.URL
+	m.conf.SkipExpression = "request.method == 'OPTIONS'"
+	calls := 0
+	h := m.Handler(upstreamEcho(t, &calls))
+
+	req := httptest.NewRequest("OPTIONS", "http://api.example.com/accounts", nil)
+	req.Header.Set("Authorization", "Bearer "+signJWT(t, "ES256", "ec", key, map[string]interface{}{"sub": "alice"}))
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if calls != 1 {
+		t.Errorf("expected the skipped request to reach the upstream, got %d calls", calls)
+	}
+	if fetches.Load() != 0 {
+		t.Errorf("expected no JWKS fetch for a skipped request, got %d", fetches.Load())
+	}
+}
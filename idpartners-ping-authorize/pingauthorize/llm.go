@@ -0,0 +1,165 @@
+package pingauthorize
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/url"
+	"regexp"
+)
+
+// trafficTypeLLM is the traffic_type of LLM API requests.
+const trafficTypeLLM = "llm"
+
+// maxLLMBodyBytes caps the request body size inspected for LLM API requests.
+const maxLLMBodyBytes = 4 << 20
+
+// LLM API formats reported in LLMContext.Format.
+const (
+	llmFormatOpenAI    = "openai"
+	llmFormatAnthropic = "anthropic"
+)
+
+// LLMContext describes a chat completion request to an LLM API, sent as the llm block of the
+// sideband payload.
+type LLMContext struct {
+	// Format is openai for the OpenAI Chat Completions format, anthropic for the Anthropic
+	// Messages format.
+	Format string `json:"format"`
+	Model  string `json:"model"`
+	// Messages is the number of messages, and Roles their distinct roles in order of first
+	// appearance. An Anthropic system prompt counts as a system message.
+	Messages int      `json:"messages"`
+	Roles    []string `json:"roles,omitempty"`
+	// Tools lists the names of the tools offered to the model.
+	Tools []string `json:"tools,omitempty"`
+	// MaxTokens is max_tokens, or max_completion_tokens in the OpenAI format.
+	MaxTokens *int `json:"max_tokens,omitempty"`
+	Stream    bool `json:"stream,omitempty"`
+}
+
+// llmRequest holds the members of both formats the llm block is built from.
+type llmRequest struct {
+	Model               string          `json:"model"`
+	Messages            []llmMessage    `json:"messages"`
+	System              json.RawMessage `json:"system"`
+	MaxTokens           *int            `json:"max_tokens"`
+	MaxCompletionTokens *int            `json:"max_completion_tokens"`
+	Stream              bool            `json:"stream"`
+	Tools               []struct {
+		// Name and InputSchema are set for Anthropic tools, Function for OpenAI ones.
+		Name        string          `json:"name"`
+		InputSchema json.RawMessage `json:"input_schema"`
+		Function    *struct {
+			Name string `json:"name"`
+		} `json:"function"`
+	} `json:"tools"`
+	// Functions is the deprecated OpenAI predecessor of tools.
+	Functions []struct {
+		Name string `json:"name"`
+	} `json:"functions"`
+}
+
+type llmMessage struct {
+	Role string `json:"role"`
+}
+
+// enrichLLM sets traffic_type and the llm block of the payload for POSTs to a path matching
+// llm_enrichment_paths whose application/json body is a chat completion request: an object with
+// a model and a messages array. Requests with an anthropic-version header, a system member or
+// Anthropic tool definitions are read in the Anthropic Messages format, others in the OpenAI
+// Chat Completions format. Other bodies are left alone.
+func enrichLLM(conf *Config, payload *SidebandAccessRequest) {
+	if len(payload.Body) > maxLLMBodyBytes || !llmRoute(conf, payload.Method, payload.URL) {
+		return
+	}
+	headers := FlattenHeaders(payload.Headers)
+	mediaType, _, _ := mime.ParseMediaType(firstValue(headers["content-type"]))
+	if mediaType != "application/json" {
+		return
+	}
+	llm := parseLLMRequest([]byte(payload.Body), firstValue(headers["anthropic-version"]) != "")
+	if llm == nil {
+		return
+	}
+	payload.TrafficType = trafficTypeLLM
+	payload.LLM = llm
+}
+
+// llmRoute reports whether a request is a POST to a path matching llm_enrichment_paths.
+func llmRoute(conf *Config, method, rawURL string) bool {
+	patterns := conf.getLLMEnrichmentPaths()
+	if len(patterns) == 0 || method != "POST" {
+		return false
+	}
+	u, err := url.Parse(rawURL)
+	return err == nil && matchAnyPattern(patterns, u.Path)
+}
+
+// parseLLMRequest parses a chat completion request body, or returns nil when body is none.
+func parseLLMRequest(body []byte, anthropic bool) *LLMContext {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 || body[0] != '{' || jsonDepthExceeds(body, maxJSONDepth) {
+		return nil
+	}
+	var req llmRequest
+	if json.Unmarshal(body, &req) != nil || req.Model == "" || req.Messages == nil {
+		return nil
+	}
+
+	llm := &LLMContext{Format: llmFormatOpenAI, Model: req.Model, Messages: len(req.Messages), Stream: req.Stream}
+	seen := make(map[string]bool)
+	addRole := func(role string) {
+		if role != "" && !seen[role] {
+			seen[role] = true
+			llm.Roles = append(llm.Roles, role)
+		}
+	}
+	if len(req.System) > 0 && string(req.System) != "null" {
+		anthropic = true
+		llm.Messages++
+		addRole("system")
+	}
+	for _, m := range req.Messages {
+		addRole(m.Role)
+	}
+	for _, tool := range req.Tools {
+		switch {
+		case tool.Function != nil:
+			llm.Tools = append(llm.Tools, tool.Function.Name)
+		case tool.Name != "":
+			anthropic = anthropic || len(tool.InputSchema) > 0
+			llm.Tools = append(llm.Tools, tool.Name)
+		}
+	}
+	for _, f := range req.Functions {
+		llm.Tools = append(llm.Tools, f.Name)
+	}
+
+	llm.MaxTokens = req.MaxTokens
+	if anthropic {
+		llm.Format = llmFormatAnthropic
+	} else if req.MaxCompletionTokens != nil {
+		llm.MaxTokens = req.MaxCompletionTokens
+	}
+	return llm
+}
+
+// matchAnyPattern reports whether path matches one of patterns.
+func matchAnyPattern(patterns []*regexp.Regexp, path string) bool {
+	for _, re := range patterns {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// getLLMEnrichmentPaths returns the compiled llm_enrichment_paths. Invalid patterns are rejected
+// by Validate, so they are treated as none here.
+func (c *Config) getLLMEnrichmentPaths() []*regexp.Regexp {
+	c.llmPathsOnce.Do(func() {
+		c.llmPaths, _ = compilePathPatterns(c.LLMEnrichmentPaths)
+	})
+	return c.llmPaths
+}
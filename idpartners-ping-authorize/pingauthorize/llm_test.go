@@ -0,0 +1,99 @@
+package pingauthorize
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParseLLMRequest(t *testing.T) {
+	intPtr := func(n int) *int { return &n }
+	tests := []struct {
+		name      string
+		body      string
+		anthropic bool
+		want      *LLMContext
+	}{
+		{"openai", `{"model":"gpt-4o-mini","messages":[{"role":"system","content":"be brief"},{"role":"user","content":"hi"},{"role":"user","content":"again"}],
+			"tools":[{"type":"function","function":{"name":"search","parameters":{}}}],"max_tokens":256,"stream":true}`, false,
+			&LLMContext{Format: "openai", Model: "gpt-4o-mini", Messages: 3, Roles: []string{"system", "user"}, Tools: []string{"search"}, MaxTokens: intPtr(256), Stream: true}},
+		{"openai max_completion_tokens", `{"model":"o1","messages":[{"role":"user","content":"hi"}],"max_completion_tokens":1024,"functions":[{"name":"lookup"}]}`, false,
+			&LLMContext{Format: "openai", Model: "o1", Messages: 1, Roles: []string{"user"}, Tools: []string{"lookup"}, MaxTokens: intPtr(1024)}},
+		{"anthropic system", `{"model":"claude-sonnet","system":"be brief","messages":[{"role":"user","content":"hi"},{"role":"assistant","content":"hello"}],
+			"tools":[{"name":"get_weather","input_schema":{"type":"object"}}],"max_tokens":512}`, false,
+			&LLMContext{Format: "anthropic", Model: "claude-sonnet", Messages: 3, Roles: []string{"system", "user", "assistant"}, Tools: []string{"get_weather"}, MaxTokens: intPtr(512)}},
+		{"anthropic tools", `{"model":"claude-haiku","messages":[{"role":"user","content":"hi"}],"tools":[{"name":"calc","input_schema":{}}],"max_completion_tokens":9}`, false,
+			&LLMContext{Format: "anthropic", Model: "claude-haiku", Messages: 1, Roles: []string{"user"}, Tools: []string{"calc"}}},
+		{"anthropic header", `{"model":"claude-haiku","messages":[],"max_tokens":64}`, true,
+			&LLMContext{Format: "anthropic", Model: "claude-haiku", MaxTokens: intPtr(64)}},
+		{"no model", `{"messages":[{"role":"user","content":"hi"}]}`, false, nil},
+		{"no messages", `{"model":"text-embedding-3-small","input":"hi"}`, false, nil},
+		{"mcp", `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`, false, nil},
+		{"array", `[{"model":"gpt-4o","messages":[]}]`, false, nil},
+		{"invalid", `{"model":`, false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseLLMRequest([]byte(tt.body), tt.anthropic); !reflect.DeepEqual(got, tt.want) {
+				gotJSON, _ := json.Marshal(got)
+				wantJSON, _ := json.Marshal(tt.want)
+				t.Errorf("parseLLMRequest() = %s, want %s", gotJSON, wantJSON)
+			}
+		})
+	}
+}
+
+func TestEnrichLLM(t *testing.T) {
+	conf := &Config{LLMEnrichmentPaths: []string{"/v1/chat/completions", "~^/anthropic/v1/messages$"}}
+	jsonHeaders := []map[string]string{{"content-type": "application/json; charset=utf-8"}}
+	body := `{"model":"gpt-4o-mini","messages":[{"role":"user","content":"hi"}]}`
+
+	tests := []struct {
+		name       string
+		conf       *Config
+		payload    *SidebandAccessRequest
+		wantFormat string
+	}{
+		{"openai", conf, &SidebandAccessRequest{Method: "POST", URL: "https://llm.example.com/v1/chat/completions?x=1", Headers: jsonHeaders, Body: body}, "openai"},
+		{"anthropic version header", conf, &SidebandAccessRequest{Method: "POST", URL: "https://llm.example.com/anthropic/v1/messages",
+			Headers: []map[string]string{{"content-type": "application/json"}, {"anthropic-version": "2023-06-01"}}, Body: body}, "anthropic"},
+		{"other path", conf, &SidebandAccessRequest{Method: "POST", URL: "https://llm.example.com/v1/embeddings", Headers: jsonHeaders, Body: body}, ""},
+		{"get", conf, &SidebandAccessRequest{Method: "GET", URL: "https://llm.example.com/v1/chat/completions", Headers: jsonHeaders, Body: body}, ""},
+		{"not json", conf, &SidebandAccessRequest{Method: "POST", URL: "https://llm.example.com/v1/chat/completions",
+			Headers: []map[string]string{{"content-type": "text/plain"}}, Body: body}, ""},
+		{"not chat", conf, &SidebandAccessRequest{Method: "POST", URL: "https://llm.example.com/v1/chat/completions", Headers: jsonHeaders, Body: `{"prompt":"hi"}`}, ""},
+		{"disabled", &Config{}, &SidebandAccessRequest{Method: "POST", URL: "https://llm.example.com/v1/chat/completions", Headers: jsonHeaders, Body: body}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enrichLLM(tt.conf, tt.payload)
+			if tt.wantFormat == "" {
+				if tt.payload.TrafficType != "" || tt.payload.LLM != nil {
+					t.Errorf("expected no llm enrichment, got %q %+v", tt.payload.TrafficType, tt.payload.LLM)
+				}
+				return
+			}
+			if tt.payload.TrafficType != trafficTypeLLM || tt.payload.LLM == nil {
+				t.Fatalf("expected llm enrichment, got %q %+v", tt.payload.TrafficType, tt.payload.LLM)
+			}
+			if tt.payload.LLM.Format != tt.wantFormat || tt.payload.LLM.Model != "gpt-4o-mini" {
+				t.Errorf("got %+v, want %s gpt-4o-mini", tt.payload.LLM, tt.wantFormat)
+			}
+		})
+	}
+}
+
+func TestConfig_ValidateLLMEnrichmentPaths(t *testing.T) {
+	conf := NewConfig()
+	conf.ServiceURL = "https://paz.example.com"
+	conf.SharedSecret = "secret"
+	conf.SecretHeaderName = "X-Secret"
+	conf.LLMEnrichmentPaths = []string{"~("}
+	if err := conf.Validate(); err == nil {
+		t.Error("expected an invalid llm_enrichment_paths pattern to be rejected")
+	}
+	conf.LLMEnrichmentPaths = []string{"/v1/**"}
+	if err := conf.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
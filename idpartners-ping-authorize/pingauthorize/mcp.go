@@ -0,0 +1,566 @@
+package pingauthorize
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// mcpMethods lists the JSON-RPC methods recognized as MCP traffic.
+var mcpMethods = map[string]bool{
+	"initialize":                true,
+	"ping":                      true,
+	"tools/list":                true,
+	"tools/call":                true,
+	"resources/list":            true,
+	"resources/read":            true,
+	"resources/subscribe":       true,
+	"resources/unsubscribe":     true,
+	"prompts/list":              true,
+	"prompts/get":               true,
+	"completion/complete":       true,
+	"roots/list":                true,
+	"sampling/createMessage":    true,
+	"notifications/initialized": true,
+	"notifications/cancelled":   true,
+	"notifications/progress":    true,
+}
+
+const (
+	// maxMCPBodyBytes caps the request body size inspected for MCP traffic. Larger bodies are not parsed.
+	maxMCPBodyBytes = 4 << 20
+	// maxJSONDepth caps the nesting depth of attacker-controlled JSON the plugin parses.
+	maxJSONDepth = 64
+	// maxMCPBatchEntries caps the entries of a JSON-RPC batch recognized as MCP traffic.
+	maxMCPBatchEntries = 100
+)
+
+// jsonRPCRequest is the minimal structure for parsing JSON-RPC 2.0 requests.
+type jsonRPCRequest struct {
+	Jsonrpc string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// JsonRPCError is the JSON-RPC 2.0 error response format.
+type JsonRPCError struct {
+	Jsonrpc string             `json:"jsonrpc"`
+	ID      json.RawMessage    `json:"id"`
+	Error   JsonRPCErrorDetail `json:"error"`
+}
+
+// JsonRPCErrorDetail is the error member of a JSON-RPC 2.0 error response.
+type JsonRPCErrorDetail struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// Reasons of MCPDenyData for denials of the policy provider. Local denials use the
+// decisionctx Reason constants.
+const (
+	mcpDenyReasonPolicy         = "policy"
+	mcpDenyReasonResponsePolicy = "response_policy"
+)
+
+// MCPDenyData is the data member of the JSON-RPC errors the plugin sends for denied MCP requests,
+// so clients and agents can act on a denial without parsing its message.
+type MCPDenyData struct {
+	// Status is the HTTP status of the denial.
+	Status int `json:"status"`
+	// Reason names the check that denied the request: a decisionctx Reason for local denials,
+	// "policy" or "response_policy" for denials of the policy provider.
+	Reason string `json:"reason,omitempty"`
+	// DecisionID is the id PingAuthorize returned with a denial.
+	DecisionID string `json:"decision_id,omitempty"`
+	// CorrelationID is the audit_log_request_id_header value of the client request.
+	CorrelationID string `json:"correlation_id,omitempty"`
+}
+
+// newMCPDenyData returns the deny data for status and reason, with the correlation id taken from
+// the headers of payload.
+func newMCPDenyData(conf *Config, payload *SidebandAccessRequest, status int, reason string) MCPDenyData {
+	data := MCPDenyData{Status: status, Reason: reason}
+	if conf.AuditLogRequestIDHeader != "" {
+		data.CorrelationID = firstValue(FlattenHeaders(payload.Headers)[strings.ToLower(conf.AuditLogRequestIDHeader)])
+	}
+	return data
+}
+
+// policyDecisionID returns the id member of a JSON deny body of PingAuthorize, or "".
+func policyDecisionID(body string) string {
+	var parsed struct {
+		ID string `json:"id"`
+	}
+	if json.Unmarshal([]byte(body), &parsed) != nil {
+		return ""
+	}
+	return parsed.ID
+}
+
+// notification reports whether req is a JSON-RPC notification, a request without an id that gets
+// no response.
+func (req *jsonRPCRequest) notification() bool {
+	return len(req.ID) == 0
+}
+
+// MCPContext describes the MCP request in the mcp member of the payload.
+type MCPContext struct {
+	Method string          `json:"method"`
+	ID     json.RawMessage `json:"id,omitempty"`
+	Tool   string          `json:"tool,omitempty"`
+	// ArgumentsStripped is set when mcp_tool_policies removed the tool arguments from the body.
+	ArgumentsStripped bool `json:"arguments_stripped,omitempty"`
+	// Notification is set for notifications, such as notifications/progress, which have no id.
+	Notification bool `json:"notification,omitempty"`
+	// Resource is the URI of a resources/read, resources/subscribe or resources/unsubscribe request.
+	Resource string `json:"resource,omitempty"`
+	// Completion describes a completion/complete request.
+	Completion *MCPCompletion `json:"completion,omitempty"`
+	// Sampling describes a sampling/createMessage request.
+	Sampling *MCPSampling `json:"sampling,omitempty"`
+	// Session is the Mcp-Session-Id of the request.
+	Session string `json:"session,omitempty"`
+	// ProtocolVersion and Client are the protocol version and clientInfo of an initialize request,
+	// or of the initialize request of the session.
+	ProtocolVersion string         `json:"protocol_version,omitempty"`
+	Client          *MCPClientInfo `json:"client,omitempty"`
+	// PromptInjection reports the prompt_injection_screening rules the arguments matched.
+	PromptInjection *MCPPromptInjection `json:"prompt_injection,omitempty"`
+}
+
+// MCPCompletion describes the argument a completion/complete request asks completions for: the
+// prompt (Ref "ref/prompt", Name) or resource template (Ref "ref/resource", URI) it belongs to and
+// its name. The partial value typed so far is left out.
+type MCPCompletion struct {
+	Ref      string `json:"ref"`
+	Name     string `json:"name,omitempty"`
+	URI      string `json:"uri,omitempty"`
+	Argument string `json:"argument,omitempty"`
+}
+
+// MCPSampling describes the LLM call a sampling/createMessage request asks for.
+type MCPSampling struct {
+	// ModelPreferences is the modelPreferences member as sent: hints and priorities.
+	ModelPreferences json.RawMessage      `json:"model_preferences,omitempty"`
+	SystemPrompt     string               `json:"system_prompt,omitempty"`
+	IncludeContext   string               `json:"include_context,omitempty"`
+	MaxTokens        int                  `json:"max_tokens,omitempty"`
+	Messages         []MCPSamplingMessage `json:"messages,omitempty"`
+}
+
+// MCPSamplingMessage is one message of a sampling request. Text is set for text content and
+// MimeType for image and audio content, whose data is left out.
+type MCPSamplingMessage struct {
+	Role     string `json:"role"`
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	MimeType string `json:"mime_type,omitempty"`
+}
+
+// enrichMCP describes the MCP request of the payload in its mcp member.
+func enrichMCP(payload *SidebandAccessRequest) {
+	if req := parseMCPRequest([]byte(payload.Body)); req != nil {
+		payload.MCP = &MCPContext{Method: req.Method, ID: req.ID, Tool: mcpToolName(req), Notification: req.notification()}
+		payload.MCP.Resource = mcpResource(req)
+		payload.MCP.Completion = mcpCompletion(req)
+		payload.MCP.Sampling = mcpSampling(req)
+		if client := mcpInitialize(req); client != nil {
+			payload.MCP.ProtocolVersion, payload.MCP.Client = client.ProtocolVersion, client.Info
+		}
+	}
+}
+
+// mcpResource returns the uri param of a request for a resource, or "" for other requests.
+func mcpResource(req *jsonRPCRequest) string {
+	switch req.Method {
+	case "resources/read", "resources/subscribe", "resources/unsubscribe":
+	default:
+		return ""
+	}
+	var params struct {
+		URI string `json:"uri"`
+	}
+	json.Unmarshal(req.Params, &params)
+	return params.URI
+}
+
+// mcpCompletion returns the params of a completion/complete request, or nil for other requests and
+// params that do not decode.
+func mcpCompletion(req *jsonRPCRequest) *MCPCompletion {
+	if req.Method != "completion/complete" {
+		return nil
+	}
+	var params struct {
+		Ref struct {
+			Type string `json:"type"`
+			Name string `json:"name"`
+			URI  string `json:"uri"`
+		} `json:"ref"`
+		Argument struct {
+			Name string `json:"name"`
+		} `json:"argument"`
+	}
+	if err := json.Unmarshal(req.Params, &params); err != nil || params.Ref.Type == "" {
+		return nil
+	}
+	return &MCPCompletion{Ref: params.Ref.Type, Name: params.Ref.Name, URI: params.Ref.URI, Argument: params.Argument.Name}
+}
+
+// mcpSessionHeader carries the MCP session id of the streamable HTTP transport: the server assigns it
+// in its response to initialize, and the client sends it on every later request.
+const mcpSessionHeader = "mcp-session-id"
+
+// enrichMCPSession sets the MCP session id of the request in the payload and its mcp member.
+func enrichMCPSession(payload *SidebandAccessRequest) {
+	session := firstValue(FlattenHeaders(payload.Headers)[mcpSessionHeader])
+	if session == "" {
+		return
+	}
+	payload.MCPSessionID = session
+	if payload.MCP != nil {
+		payload.MCP.Session = session
+	}
+}
+
+// responseMCPSession returns the MCP session id of a response: the one the server assigned in
+// headers, or else requestSession.
+func responseMCPSession(headers []map[string]string, requestSession string) string {
+	if session := firstValue(FlattenHeaders(headers)[mcpSessionHeader]); session != "" {
+		return session
+	}
+	return requestSession
+}
+
+// mcpSampling returns the sampling parameters of a sampling/createMessage request, or nil for
+// other requests and params that do not decode.
+func mcpSampling(req *jsonRPCRequest) *MCPSampling {
+	if req.Method != "sampling/createMessage" {
+		return nil
+	}
+	var params struct {
+		Messages []struct {
+			Role    string `json:"role"`
+			Content struct {
+				Type     string `json:"type"`
+				Text     string `json:"text"`
+				MimeType string `json:"mimeType"`
+			} `json:"content"`
+		} `json:"messages"`
+		ModelPreferences json.RawMessage `json:"modelPreferences"`
+		SystemPrompt     string          `json:"systemPrompt"`
+		IncludeContext   string          `json:"includeContext"`
+		MaxTokens        int             `json:"maxTokens"`
+	}
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return nil
+	}
+	if string(params.ModelPreferences) == "null" {
+		params.ModelPreferences = nil
+	}
+	sampling := &MCPSampling{
+		ModelPreferences: params.ModelPreferences,
+		SystemPrompt:     params.SystemPrompt,
+		IncludeContext:   params.IncludeContext,
+		MaxTokens:        params.MaxTokens,
+	}
+	for _, msg := range params.Messages {
+		sampling.Messages = append(sampling.Messages, MCPSamplingMessage{
+			Role:     msg.Role,
+			Type:     msg.Content.Type,
+			Text:     msg.Content.Text,
+			MimeType: msg.Content.MimeType,
+		})
+	}
+	return sampling
+}
+
+// parseMCPRequest parses body as a JSON-RPC 2.0 request for a recognized MCP method.
+// Returns nil for non-JSON, non-JSON-RPC and unrecognized bodies, for bodies over maxMCPBodyBytes
+// or nested deeper than maxJSONDepth, and for ids that are not a string, number or null.
+func parseMCPRequest(body []byte) *jsonRPCRequest {
+	if len(body) > maxMCPBodyBytes {
+		return nil
+	}
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return nil
+	}
+	if jsonDepthExceeds(trimmed, maxJSONDepth) {
+		return nil
+	}
+
+	var req jsonRPCRequest
+	if err := json.Unmarshal(trimmed, &req); err != nil {
+		return nil
+	}
+	if req.Jsonrpc != "2.0" || !mcpMethods[req.Method] || !validJSONRPCID(req.ID) {
+		return nil
+	}
+	return &req
+}
+
+// parseMCPBatch parses body as a JSON-RPC 2.0 batch: an array of requests and notifications with
+// valid ids, at least one of them for a recognized MCP method. Returns nil otherwise, and for
+// batches over maxMCPBatchEntries.
+func parseMCPBatch(body []byte) []*jsonRPCRequest {
+	if len(body) > maxMCPBodyBytes {
+		return nil
+	}
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 || trimmed[0] != '[' || jsonDepthExceeds(trimmed, maxJSONDepth) {
+		return nil
+	}
+
+	var batch []*jsonRPCRequest
+	if err := json.Unmarshal(trimmed, &batch); err != nil || len(batch) == 0 || len(batch) > maxMCPBatchEntries {
+		return nil
+	}
+	mcp := false
+	for _, req := range batch {
+		if req == nil || req.Jsonrpc != "2.0" || req.Method == "" || !validJSONRPCID(req.ID) {
+			return nil
+		}
+		mcp = mcp || mcpMethods[req.Method]
+	}
+	if !mcp {
+		return nil
+	}
+	return batch
+}
+
+// MCPBatchEntry describes one request of a JSON-RPC batch in the mcp_batch member of the payload.
+type MCPBatchEntry struct {
+	ID           json.RawMessage `json:"id,omitempty"`
+	Method       string          `json:"method"`
+	Tool         string          `json:"tool,omitempty"`
+	Notification bool            `json:"notification,omitempty"`
+	// PromptInjection reports the matches of prompt_injection_screening in the arguments.
+	PromptInjection *MCPPromptInjection `json:"prompt_injection,omitempty"`
+}
+
+// enrichMCPBatch lists the requests of an MCP batch in the payload, so policies can decide on every
+// method and tool without parsing the body.
+func enrichMCPBatch(payload *SidebandAccessRequest) {
+	batch := parseMCPBatch([]byte(payload.Body))
+	if batch == nil {
+		return
+	}
+	payload.MCPBatch = make([]MCPBatchEntry, len(batch))
+	for i, req := range batch {
+		payload.MCPBatch[i] = MCPBatchEntry{ID: req.ID, Method: req.Method, Tool: mcpToolName(req), Notification: req.notification()}
+	}
+}
+
+// validJSONRPCID reports whether id is absent or a JSON string, number or null, the only id
+// types JSON-RPC 2.0 allows. Object and array ids are rejected so they are never echoed back.
+func validJSONRPCID(id json.RawMessage) bool {
+	if len(id) == 0 {
+		return true
+	}
+	switch id[0] {
+	case '{', '[', 't', 'f':
+		return false
+	}
+	return true
+}
+
+// jsonDepthExceeds reports whether the array/object nesting of data is deeper than max.
+// It only tracks brackets outside of strings and does not validate the document.
+func jsonDepthExceeds(data []byte, max int) bool {
+	depth := 0
+	inString := false
+	escaped := false
+	for _, c := range data {
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '{', '[':
+			depth++
+			if depth > max {
+				return true
+			}
+		case '}', ']':
+			depth--
+		}
+	}
+	return false
+}
+
+// IsMCPRequest reports whether body is a JSON-RPC 2.0 request for a recognized MCP method, or a
+// batch holding one. Non-JSON and non-JSON-RPC bodies return false.
+func IsMCPRequest(body []byte) bool {
+	return parseMCPRequest(body) != nil || parseMCPBatch(body) != nil
+}
+
+// mcpToolName returns the tool name of a tools/call request, or "" for other requests.
+func mcpToolName(req *jsonRPCRequest) string {
+	if req == nil || req.Method != "tools/call" {
+		return ""
+	}
+	var params struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return ""
+	}
+	return params.Name
+}
+
+// httpStatusToJsonRPCError maps an HTTP status code to a JSON-RPC 2.0 error code.
+func httpStatusToJsonRPCError(statusCode int) int {
+	switch {
+	case statusCode == 404:
+		return -32601 // Method not found
+	case statusCode == 429, statusCode == 502, statusCode == 503:
+		return -32000 // Server error
+	case statusCode >= 500:
+		return -32603 // Internal error
+	default:
+		return -32600 // Invalid Request
+	}
+}
+
+// formatMCPDenyResponse builds a JSON-RPC 2.0 error body for a locally or policy-denied MCP request.
+// A missing id is encoded as null per the JSON-RPC 2.0 spec.
+func formatMCPDenyResponse(statusCode int, message string, jsonrpcID json.RawMessage) []byte {
+	return formatMCPDenyError(message, jsonrpcID, MCPDenyData{Status: statusCode})
+}
+
+// formatMCPDenyError builds the JSON-RPC 2.0 error body of a denied MCP request with data as its
+// data member. The error code follows data.Status.
+func formatMCPDenyError(message string, jsonrpcID json.RawMessage, data MCPDenyData) []byte {
+	if len(jsonrpcID) == 0 {
+		jsonrpcID = json.RawMessage("null")
+	}
+	raw, _ := json.Marshal(data)
+	body, _ := json.Marshal(JsonRPCError{
+		Jsonrpc: "2.0",
+		ID:      jsonrpcID,
+		Error:   JsonRPCErrorDetail{Code: httpStatusToJsonRPCError(data.Status), Message: message, Data: raw},
+	})
+	return body
+}
+
+// formatJSONRPCError builds a JSON-RPC 2.0 error body with code. A missing id is encoded as null.
+func formatJSONRPCError(code int, message string, jsonrpcID json.RawMessage) []byte {
+	if len(jsonrpcID) == 0 {
+		jsonrpcID = json.RawMessage("null")
+	}
+	resp := JsonRPCError{
+		Jsonrpc: "2.0",
+		ID:      jsonrpcID,
+		Error: JsonRPCErrorDetail{
+			Code:    code,
+			Message: message,
+		},
+	}
+	body, _ := json.Marshal(resp)
+	return body
+}
+
+// formatMCPBatchDenyResponse builds the JSON-RPC 2.0 batch response denying entries: an error for
+// each request, with data as its data member. Notifications have no id and get no response.
+func formatMCPBatchDenyResponse(message string, entries []*jsonRPCRequest, data MCPDenyData) []byte {
+	raw, _ := json.Marshal(data)
+	errs := make([]JsonRPCError, 0, len(entries))
+	for _, req := range entries {
+		if req.notification() {
+			continue
+		}
+		errs = append(errs, JsonRPCError{
+			Jsonrpc: "2.0",
+			ID:      req.ID,
+			Error:   JsonRPCErrorDetail{Code: httpStatusToJsonRPCError(data.Status), Message: message, Data: raw},
+		})
+	}
+	body, _ := json.Marshal(errs)
+	return body
+}
+
+// mcpDenyBody builds the JSON-RPC 2.0 body of a local deny of an MCP request or batch, or returns
+// nil when body is neither.
+func mcpDenyBody(message string, body []byte, data MCPDenyData) []byte {
+	if req := parseMCPRequest(body); req != nil {
+		return formatMCPDenyError(message, req.ID, data)
+	}
+	if batch := parseMCPBatch(body); batch != nil {
+		return formatMCPBatchDenyResponse(message, batch, data)
+	}
+	return nil
+}
+
+// mcpBatchDenials compares an MCP batch with the body the policy allowed. Requests whose id the
+// policy removed from the batch are denied: it returns a JSON-RPC error for each of them, and
+// all=true when the policy removed every entry. Returns nil when body is not an MCP batch, the
+// policy left it unchanged or did not return a batch.
+func mcpBatchDenials(body string, allowed *string) (errs []byte, all bool) {
+	if allowed == nil || *allowed == body {
+		return nil, false
+	}
+	batch := parseMCPBatch([]byte(body))
+	if batch == nil {
+		return nil, false
+	}
+	var kept []struct {
+		ID json.RawMessage `json:"id"`
+	}
+	if err := json.Unmarshal([]byte(*allowed), &kept); err != nil {
+		return nil, false
+	}
+	keptIDs := make(map[string]bool, len(kept))
+	for _, req := range kept {
+		keptIDs[string(bytes.TrimSpace(req.ID))] = true
+	}
+
+	var denied []*jsonRPCRequest
+	for _, req := range batch {
+		if !req.notification() && !keptIDs[string(bytes.TrimSpace(req.ID))] {
+			denied = append(denied, req)
+		}
+	}
+	if len(denied) == 0 && len(kept) > 0 {
+		return nil, false
+	}
+	data := MCPDenyData{Status: http.StatusForbidden, Reason: mcpDenyReasonPolicy}
+	return formatMCPBatchDenyResponse("Request denied by policy", denied, data), len(kept) == 0
+}
+
+// appendMCPBatchErrors adds the errors of denied batch entries to the upstream response to the
+// rest of the batch. body is returned unchanged when it is not JSON.
+func appendMCPBatchErrors(body, errs []byte) []byte {
+	var responses []json.RawMessage
+	switch trimmed := bytes.TrimSpace(body); {
+	case len(trimmed) == 0:
+	case trimmed[0] == '[':
+		if err := json.Unmarshal(trimmed, &responses); err != nil {
+			return body
+		}
+	case trimmed[0] == '{':
+		responses = []json.RawMessage{trimmed}
+	default:
+		return body
+	}
+	var extra []json.RawMessage
+	if err := json.Unmarshal(errs, &extra); err != nil {
+		return body
+	}
+	merged, err := json.Marshal(append(responses, extra...))
+	if err != nil {
+		return body
+	}
+	return merged
+}
@@ -0,0 +1,129 @@
+package pingauthorize
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/Kong/go-pdk"
+)
+
+// maxTrackedMCPSessions caps the number of MCP sessions whose client is remembered.
+const maxTrackedMCPSessions = 10000
+
+// mcpProtocolVersionHeader carries the negotiated protocol version on the requests that follow
+// initialize in the streamable HTTP transport.
+const mcpProtocolVersionHeader = "mcp-protocol-version"
+
+// MCPClientInfo is the clientInfo an MCP client sends in its initialize request.
+type MCPClientInfo struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+// mcpClient is what the initialize request of a session told about the client.
+type mcpClient struct {
+	ProtocolVersion string         `json:"protocol_version,omitempty"`
+	Info            *MCPClientInfo `json:"client,omitempty"`
+}
+
+// mcpClientTracker remembers the client of each MCP session, from the initialize request the
+// server answered with the session id.
+type mcpClientTracker struct {
+	mu       sync.Mutex
+	sessions map[string]mcpClient
+}
+
+// globalMCPClients is shared by all plugin configs: session ids are assigned by the MCP server and
+// unique across them.
+var globalMCPClients = &mcpClientTracker{sessions: make(map[string]mcpClient)}
+
+// remember records client for session. Once full, an arbitrary session is evicted to make room.
+func (t *mcpClientTracker) remember(session string, client mcpClient) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.sessions[session]; !ok && len(t.sessions) >= maxTrackedMCPSessions {
+		for k := range t.sessions {
+			delete(t.sessions, k)
+			break
+		}
+	}
+	t.sessions[session] = client
+}
+
+func (t *mcpClientTracker) lookup(session string) (mcpClient, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	client, ok := t.sessions[session]
+	return client, ok
+}
+
+// mcpInitialize returns the protocol version and client info of an initialize request, or nil for
+// other requests and params that do not decode.
+func mcpInitialize(req *jsonRPCRequest) *mcpClient {
+	if req.Method != "initialize" {
+		return nil
+	}
+	var params struct {
+		ProtocolVersion string         `json:"protocolVersion"`
+		ClientInfo      *MCPClientInfo `json:"clientInfo"`
+	}
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return nil
+	}
+	if params.ClientInfo != nil && *params.ClientInfo == (MCPClientInfo{}) {
+		params.ClientInfo = nil
+	}
+	return &mcpClient{ProtocolVersion: params.ProtocolVersion, Info: params.ClientInfo}
+}
+
+// enrichMCPClient sets the protocol version and client of the MCP session in the mcp member of the
+// payload, for the requests that follow initialize. The MCP-Protocol-Version header, when sent,
+// takes precedence over the version the client asked for in initialize.
+func enrichMCPClient(payload *SidebandAccessRequest) {
+	if payload.MCP == nil || payload.MCP.Method == "initialize" {
+		return
+	}
+	if payload.MCP.Session != "" {
+		if client, ok := globalMCPClients.lookup(payload.MCP.Session); ok {
+			payload.MCP.ProtocolVersion, payload.MCP.Client = client.ProtocolVersion, client.Info
+		}
+	}
+	if version := firstValue(FlattenHeaders(payload.Headers)[mcpProtocolVersionHeader]); version != "" {
+		payload.MCP.ProtocolVersion = version
+	}
+}
+
+// initializeClient returns the client an initialize request describes, or nil for other requests.
+// Safe to call on nil.
+func (c *MCPContext) initializeClient() *mcpClient {
+	if c == nil || c.Method != "initialize" {
+		return nil
+	}
+	return &mcpClient{ProtocolVersion: c.ProtocolVersion, Info: c.Client}
+}
+
+// rememberMCPClient records the client of an initialize request for the session the server
+// assigned in its response. Ignored when client is nil or the response has no session.
+func rememberMCPClient(client *mcpClient, session string) {
+	if client == nil || session == "" {
+		return
+	}
+	globalMCPClients.remember(session, *client)
+}
+
+// rememberKongMCPClient records the client of a Kong initialize request, stored by the access
+// phase, for the session in the upstream response.
+func rememberKongMCPClient(kong *pdk.PDK) {
+	data, err := kong.Ctx.GetSharedString("paz_mcp_initialize")
+	if err != nil || data == "" {
+		return
+	}
+	session, err := kong.ServiceResponse.GetHeader("Mcp-Session-Id")
+	if err != nil {
+		return
+	}
+	var client mcpClient
+	if json.Unmarshal([]byte(data), &client) == nil {
+		rememberMCPClient(&client, session)
+	}
+}
@@ -0,0 +1,98 @@
+package pingauthorize
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMCPInitialize(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{
+			`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-06-18","capabilities":{},"clientInfo":{"name":"claude-desktop","version":"1.2.0"}}}`,
+			`{"method":"initialize","id":1,"protocol_version":"2025-06-18","client":{"name":"claude-desktop","version":"1.2.0"}}`,
+		},
+		{`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2024-11-05","clientInfo":{}}}`, `{"method":"initialize","id":1,"protocol_version":"2024-11-05"}`},
+		{`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":1}}`, `{"method":"initialize","id":1}`},
+		{`{"jsonrpc":"2.0","id":1,"method":"tools/list","params":{"protocolVersion":"2025-06-18"}}`, `{"method":"tools/list","id":1}`},
+	}
+	for _, tt := range tests {
+		payload := &SidebandAccessRequest{Body: tt.body}
+		enrichMCP(payload)
+		if got, _ := json.Marshal(payload.MCP); string(got) != tt.want {
+			t.Errorf("enrichMCP(%s) = %s, want %s", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestEnrichMCPClient(t *testing.T) {
+	globalMCPClients.remember("s-known", mcpClient{ProtocolVersion: "2025-03-26", Info: &MCPClientInfo{Name: "cursor", Version: "0.50"}})
+
+	tests := []struct {
+		name    string
+		session string
+		version string
+		want    string
+	}{
+		{"remembered", "s-known", "", `{"method":"tools/list","id":2,"session":"s-known","protocol_version":"2025-03-26","client":{"name":"cursor","version":"0.50"}}`},
+		{"header wins", "s-known", "2025-06-18", `{"method":"tools/list","id":2,"session":"s-known","protocol_version":"2025-06-18","client":{"name":"cursor","version":"0.50"}}`},
+		{"unknown session", "s-unknown", "", `{"method":"tools/list","id":2,"session":"s-unknown"}`},
+		{"header only", "", "2025-06-18", `{"method":"tools/list","id":2,"protocol_version":"2025-06-18"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := map[string][]string{}
+			if tt.session != "" {
+				headers["Mcp-Session-Id"] = []string{tt.session}
+			}
+			if tt.version != "" {
+				headers["MCP-Protocol-Version"] = []string{tt.version}
+			}
+			formatted, _ := FormatHeaders(headers)
+			payload := &SidebandAccessRequest{Body: `{"jsonrpc":"2.0","id":2,"method":"tools/list"}`, Headers: formatted}
+			enrichMCP(payload)
+			enrichMCPSession(payload)
+			enrichMCPClient(payload)
+			if got, _ := json.Marshal(payload.MCP); string(got) != tt.want {
+				t.Errorf("mcp = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMiddleware_MCPClientInfo(t *testing.T) {
+	var accessPayload SidebandAccessRequest
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		accessPayload = SidebandAccessRequest{}
+		json.NewDecoder(r.Body).Decode(&accessPayload)
+		json.NewEncoder(w).Encode(SidebandAccessResponse{Method: accessPayload.Method, URL: accessPayload.URL, Headers: accessPayload.Headers})
+	})
+	defer server.Close()
+
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Mcp-Session-Id") == "" {
+			w.Header().Set("Mcp-Session-Id", "s-client-info")
+		}
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":{}}`))
+	})
+	h := newTestMiddleware(t, server.URL, false).Handler(upstream)
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "http://api.example.com/mcp", strings.NewReader(
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-06-18","clientInfo":{"name":"inspector","version":"0.14"}}}`)))
+	if accessPayload.MCP == nil || accessPayload.MCP.ProtocolVersion != "2025-06-18" || accessPayload.MCP.Client == nil || accessPayload.MCP.Client.Name != "inspector" {
+		t.Fatalf("initialize mcp = %+v", accessPayload.MCP)
+	}
+
+	req := httptest.NewRequest("POST", "http://api.example.com/mcp", strings.NewReader(`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"search"}}`))
+	req.Header.Set("Mcp-Session-Id", "s-client-info")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	got := accessPayload.MCP
+	if got == nil || got.ProtocolVersion != "2025-06-18" || got.Client == nil || *got.Client != (MCPClientInfo{Name: "inspector", Version: "0.14"}) {
+		t.Errorf("tools/call mcp = %+v, want the client of the session", got)
+	}
+}
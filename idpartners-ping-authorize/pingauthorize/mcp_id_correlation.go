@@ -0,0 +1,65 @@
+package pingauthorize
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// jsonRPCInternalError is the JSON-RPC 2.0 error code of internal errors.
+const jsonRPCInternalError = -32603
+
+var (
+	idMismatchCounterOnce sync.Once
+	idMismatchCounter     metric.Int64Counter
+)
+
+// mcpIDMismatchCounter returns the id mismatch counter from the global meter provider (a no-op until OTel is initialized).
+func mcpIDMismatchCounter() metric.Int64Counter {
+	idMismatchCounterOnce.Do(func() {
+		idMismatchCounter, _ = otel.Meter(PluginName).Int64Counter("ping_authorize_mcp_id_mismatch_total",
+			metric.WithDescription("MCP responses whose JSON-RPC id does not match the request"))
+	})
+	return idMismatchCounter
+}
+
+// responseIDMismatch reports whether resp answers another request than the one with requestID.
+// Error responses with a null id, which servers send when they could not read the request id, are
+// accepted. Requests without an id, such as notifications and batches, are not checked.
+func responseIDMismatch(requestID json.RawMessage, resp *MCPResponseContext) bool {
+	if len(requestID) == 0 || resp == nil {
+		return false
+	}
+	if len(resp.ID) == 0 {
+		return resp.Error == nil
+	}
+	var want, got bytes.Buffer
+	if json.Compact(&want, requestID) != nil || json.Compact(&got, resp.ID) != nil {
+		return true
+	}
+	return !bytes.Equal(want.Bytes(), got.Bytes())
+}
+
+// checkMCPResponseID compares the JSON-RPC id of the response in the payload, or of the final
+// message of an SSE stream, with requestID. A mismatch is logged and counted and, with
+// block_mcp_id_mismatch, answered with a 502 JSON-RPC error in place of the upstream response.
+func checkMCPResponseID(conf *Config, requestID json.RawMessage, payload *SidebandResponsePayload, logger *PluginLogger) (int, []byte, map[string][]string, bool) {
+	if !responseIDMismatch(requestID, payload.MCP) {
+		return 0, nil, nil, false
+	}
+	logger.Warn("MCP response id does not match the request", "audit", true,
+		"request_id", string(requestID), "response_id", string(payload.MCP.ID), "blocked", conf.BlockMCPIDMismatch)
+	mcpIDMismatchCounter().Add(context.Background(), 1, metric.WithAttributes(
+		attribute.String("service_url", conf.ServiceURL), attribute.Bool("blocked", conf.BlockMCPIDMismatch)))
+	if !conf.BlockMCPIDMismatch {
+		return 0, nil, nil, false
+	}
+	body := formatJSONRPCError(jsonRPCInternalError, "Upstream response does not match the request", requestID)
+	return http.StatusBadGateway, body, map[string][]string{"Content-Type": {"application/json"}}, true
+}
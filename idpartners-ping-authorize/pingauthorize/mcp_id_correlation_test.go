@@ -0,0 +1,88 @@
+package pingauthorize
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/idpartners/idpartners-ping-authorize/pingauthorize/decisionctx"
+)
+
+func TestResponseIDMismatch(t *testing.T) {
+	tests := []struct {
+		name      string
+		requestID string
+		body      string
+		want      bool
+	}{
+		{"same number", "1", `{"jsonrpc":"2.0","id":1,"result":{}}`, false},
+		{"same string", `"a"`, `{"jsonrpc":"2.0","id":"a","result":{}}`, false},
+		{"other number", "1", `{"jsonrpc":"2.0","id":2,"result":{}}`, true},
+		{"string for number", "1", `{"jsonrpc":"2.0","id":"1","result":{}}`, true},
+		{"missing id", "1", `{"jsonrpc":"2.0","result":{}}`, true},
+		{"null id error", "1", `{"jsonrpc":"2.0","id":null,"error":{"code":-32700,"message":"Parse error"}}`, false},
+		{"other id error", "1", `{"jsonrpc":"2.0","id":2,"error":{"code":-32601,"message":"x"}}`, true},
+		{"notification", "", `{"jsonrpc":"2.0","id":2,"result":{}}`, false},
+		{"not json-rpc", "1", `{"ok":true}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := parseMCPResponse([]byte(tt.body), "application/json")
+			if got := responseIDMismatch(json.RawMessage(tt.requestID), resp); got != tt.want {
+				t.Errorf("responseIDMismatch() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMiddleware_MCPResponseIDMismatch(t *testing.T) {
+	var evaluated int
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/sideband/response") {
+			evaluated++
+			var payload SidebandResponsePayload
+			json.NewDecoder(r.Body).Decode(&payload)
+			json.NewEncoder(w).Encode(SidebandResponseResult{ResponseCode: payload.ResponseCode, Body: payload.Body, Headers: payload.Headers})
+			return
+		}
+		var req SidebandAccessRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(SidebandAccessResponse{Method: req.Method, URL: req.URL, Headers: req.Headers})
+	})
+	defer server.Close()
+
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"jsonrpc\":\"2.0\",\"id\":99,\"result\":{}}\n\n"))
+	})
+	request := `{"jsonrpc":"2.0","id":7,"method":"tools/list"}`
+
+	for _, block := range []bool{false, true} {
+		evaluated = 0
+		m := newTestMiddleware(t, server.URL, true)
+		m.conf.BlockMCPIDMismatch = block
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "http://api.example.com/mcp", strings.NewReader(request))
+		ctx, record := decisionctx.NewContext(r.Context())
+		m.Handler(upstream).ServeHTTP(rec, r.WithContext(ctx))
+
+		if !block {
+			if rec.Code != http.StatusOK || evaluated != 1 {
+				t.Errorf("expected the response to be evaluated and passed through, got %d after %d evaluations", rec.Code, evaluated)
+			}
+			continue
+		}
+		if rec.Code != http.StatusBadGateway || evaluated != 0 {
+			t.Fatalf("expected 502 without evaluation, got %d after %d evaluations", rec.Code, evaluated)
+		}
+		var resp JsonRPCError
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || string(resp.ID) != "7" || resp.Error.Code != jsonRPCInternalError {
+			t.Errorf("unexpected error response %s", rec.Body.String())
+		}
+		if record.Response == nil || record.Response.Reason != decisionctx.ReasonResponseIDMismatch {
+			t.Errorf("unexpected response phase %+v", record.Response)
+		}
+	}
+}
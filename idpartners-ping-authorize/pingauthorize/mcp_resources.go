@@ -0,0 +1,110 @@
+package pingauthorize
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/idpartners/idpartners-ping-authorize/pingauthorize/decisionctx"
+)
+
+// validateMCPResources validates mcp_resource_schemes and mcp_resource_paths.
+func validateMCPResources(c *Config) error {
+	for _, scheme := range c.MCPResourceSchemes {
+		if scheme == "" {
+			return fmt.Errorf("mcp_resource_schemes must not contain empty schemes")
+		}
+	}
+	if _, err := compilePathPatterns(c.MCPResourcePaths); err != nil {
+		return fmt.Errorf("mcp_resource_paths: %w", err)
+	}
+	return nil
+}
+
+// getMCPResourcePaths returns the compiled mcp_resource_paths. Invalid patterns are rejected by
+// Validate, so they are treated as none here.
+func (c *Config) getMCPResourcePaths() []*regexp.Regexp {
+	c.resourcePathsOnce.Do(func() {
+		c.resourcePaths, _ = compilePathPatterns(c.MCPResourcePaths)
+	})
+	return c.resourcePaths
+}
+
+// mcpResourceAllowed reports whether a resources/read URI is allowed by mcp_resource_schemes and
+// mcp_resource_paths. Paths are matched against the URI without its scheme: the host and the
+// cleaned path, so "file:///srv/data/../../etc/passwd" is matched as "/etc/passwd". URIs that do
+// not parse are not allowed.
+func mcpResourceAllowed(conf *Config, uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme == "" {
+		return false
+	}
+	if len(conf.MCPResourceSchemes) > 0 {
+		allowed := false
+		for _, scheme := range conf.MCPResourceSchemes {
+			if strings.EqualFold(scheme, u.Scheme) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+
+	patterns := conf.getMCPResourcePaths()
+	if len(patterns) == 0 {
+		return true
+	}
+	target := u.Opaque
+	if target == "" {
+		target = u.Host
+		if u.Path != "" {
+			target += path.Clean("/" + u.Path)
+		}
+	}
+	for _, re := range patterns {
+		if re.MatchString(target) {
+			return true
+		}
+	}
+	return false
+}
+
+// mcpResourceURI returns the uri param of a resources/read request, and ok=false for other requests.
+func mcpResourceURI(req *jsonRPCRequest) (uri string, ok bool) {
+	if req.Method != "resources/read" {
+		return "", false
+	}
+	var params struct {
+		URI string `json:"uri"`
+	}
+	json.Unmarshal(req.Params, &params)
+	return params.URI, true
+}
+
+// resourceDenial builds the response for an MCP request or batch reading a resource that
+// mcp_resource_schemes and mcp_resource_paths do not allow, or returns ok=false when every
+// resources/read URI is allowed. A batch is denied as a whole.
+func resourceDenial(conf *Config, payload *SidebandAccessRequest) (status int, body []byte, headers map[string][]string, ok bool) {
+	if len(conf.MCPResourceSchemes) == 0 && len(conf.MCPResourcePaths) == 0 {
+		return 0, nil, nil, false
+	}
+	reqs := parseMCPBatch([]byte(payload.Body))
+	if req := parseMCPRequest([]byte(payload.Body)); req != nil {
+		reqs = []*jsonRPCRequest{req}
+	}
+	for _, req := range reqs {
+		if uri, ok := mcpResourceURI(req); ok && !mcpResourceAllowed(conf, uri) {
+			status = http.StatusForbidden
+			data := newMCPDenyData(conf, payload, status, decisionctx.ReasonResource)
+			body = mcpDenyBody("Resource not allowed", []byte(payload.Body), data)
+			return status, body, map[string][]string{"Content-Type": {"application/json"}}, true
+		}
+	}
+	return 0, nil, nil, false
+}
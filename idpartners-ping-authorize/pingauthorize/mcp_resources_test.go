@@ -0,0 +1,143 @@
+package pingauthorize
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMCPResourceAllowed(t *testing.T) {
+	conf := &Config{
+		MCPResourceSchemes: []string{"file", "HTTPS", "db"},
+		MCPResourcePaths:   []string{"/srv/data/**", "docs.example.com/**", "~^main/[a-z]+$"},
+	}
+
+	tests := []struct {
+		uri  string
+		want bool
+	}{
+		{"file:///srv/data/report.csv", true},
+		{"file:///srv/data/2024/q1.csv", true},
+		{"file:///etc/passwd", false},
+		{"file:///srv/data/../../etc/passwd", false},
+		{"file:///srv/data/%2e%2e/%2e%2e/etc/passwd", false},
+		{"https://docs.example.com/guide", true},
+		{"https://evil.example.com/guide", false},
+		{"http://docs.example.com/guide", false},
+		{"db://main/users", true},
+		{"db://main/users/1", false},
+		{"custom:thing", false},
+		{"/srv/data/report.csv", false},
+		{"::", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			if got := mcpResourceAllowed(conf, tt.uri); got != tt.want {
+				t.Errorf("mcpResourceAllowed(%q) = %v, want %v", tt.uri, got, tt.want)
+			}
+		})
+	}
+
+	schemesOnly := &Config{MCPResourceSchemes: []string{"file"}}
+	if !mcpResourceAllowed(schemesOnly, "file:///etc/passwd") || mcpResourceAllowed(schemesOnly, "https://example.com/") {
+		t.Error("expected schemes alone to restrict only the scheme")
+	}
+}
+
+func TestResourceDenial(t *testing.T) {
+	conf := &Config{MCPResourcePaths: []string{"/srv/**"}}
+
+	tests := []struct {
+		name     string
+		body     string
+		wantDeny bool
+	}{
+		{"allowed", `{"jsonrpc":"2.0","id":1,"method":"resources/read","params":{"uri":"file:///srv/a"}}`, false},
+		{"denied", `{"jsonrpc":"2.0","id":1,"method":"resources/read","params":{"uri":"file:///etc/passwd"}}`, true},
+		{"missing uri", `{"jsonrpc":"2.0","id":1,"method":"resources/read","params":{}}`, true},
+		{"other method", `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"file:///etc/passwd"}}`, false},
+		{"batch", `[{"jsonrpc":"2.0","id":1,"method":"resources/read","params":{"uri":"file:///srv/a"}},` +
+			`{"jsonrpc":"2.0","id":2,"method":"resources/read","params":{"uri":"file:///etc/passwd"}}]`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body, _, ok := resourceDenial(conf, &SidebandAccessRequest{Body: tt.body})
+			if ok != tt.wantDeny {
+				t.Fatalf("denied = %v, want %v", ok, tt.wantDeny)
+			}
+			if ok && (status != http.StatusForbidden || !strings.Contains(string(body), "Resource not allowed")) {
+				t.Errorf("got %d %s", status, body)
+			}
+		})
+	}
+
+	if _, _, _, ok := resourceDenial(&Config{}, &SidebandAccessRequest{Body: tests[1].body}); ok {
+		t.Error("expected no restriction without configuration")
+	}
+}
+
+func TestValidate_MCPResources(t *testing.T) {
+	tests := []struct {
+		name    string
+		schemes []string
+		paths   []string
+		wantErr string
+	}{
+		{"valid", []string{"file"}, []string{"/srv/**"}, ""},
+		{"empty scheme", []string{""}, nil, "mcp_resource_schemes must not contain empty schemes"},
+		{"bad pattern", nil, []string{"~("}, "mcp_resource_paths: invalid pattern"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := &Config{ServiceURL: "http://localhost:8080", SharedSecret: "secret", SecretHeaderName: "CLIENT-TOKEN",
+				MCPResourceSchemes: tt.schemes, MCPResourcePaths: tt.paths}
+			_, err := NewMiddleware(conf)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMiddleware_MCPResourceRestrictions(t *testing.T) {
+	var evaluated int
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		evaluated++
+		var req SidebandAccessRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(SidebandAccessResponse{Method: req.Method, URL: req.URL, Headers: req.Headers})
+	})
+	defer server.Close()
+
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":5,"result":{"contents":[]}}`))
+	})
+	m := newTestMiddleware(t, server.URL, false)
+	m.conf.MCPResourceSchemes = []string{"file"}
+	m.conf.MCPResourcePaths = []string{"/srv/data/**"}
+	h := m.Handler(upstream)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "http://api.example.com/mcp",
+		strings.NewReader(`{"jsonrpc":"2.0","id":5,"method":"resources/read","params":{"uri":"file:///etc/passwd"}}`)))
+	var resp JsonRPCError
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if rec.Code != http.StatusForbidden || evaluated != 0 || string(resp.ID) != "5" {
+		t.Fatalf("got %d %s after %d evaluations, want 403 without evaluation", rec.Code, rec.Body.String(), evaluated)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "http://api.example.com/mcp",
+		strings.NewReader(`{"jsonrpc":"2.0","id":5,"method":"resources/read","params":{"uri":"file:///srv/data/a.txt"}}`)))
+	if rec.Code != http.StatusOK || evaluated != 1 {
+		t.Errorf("got %d after %d evaluations, want 200 after one", rec.Code, evaluated)
+	}
+}
@@ -0,0 +1,127 @@
+package pingauthorize
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// MCPResponseContext describes the JSON-RPC response of the upstream in the mcp member of the
+// response payload: the result of a tool call or resource read, or the error.
+type MCPResponseContext struct {
+	ID json.RawMessage `json:"id,omitempty"`
+	// IsError is the isError flag of a tools/call result: the tool ran and reported a failure.
+	IsError bool `json:"is_error,omitempty"`
+	// Content holds the content blocks of a tools/call or prompts/get result.
+	Content []MCPContentBlock `json:"content,omitempty"`
+	// StructuredContent is the structuredContent member of a tools/call result as sent.
+	StructuredContent json.RawMessage `json:"structured_content,omitempty"`
+	// Contents holds the contents of a resources/read result.
+	Contents []MCPResourceContents `json:"contents,omitempty"`
+	// Error is the error member of an error response.
+	Error *JsonRPCErrorDetail `json:"error,omitempty"`
+}
+
+// MCPContentBlock is one content block of a result. Text is set for text content and the text of
+// embedded resources, URI for resource links and embedded resources. The data of image and audio
+// content is left out.
+type MCPContentBlock struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	MimeType string `json:"mime_type,omitempty"`
+	URI      string `json:"uri,omitempty"`
+}
+
+// MCPResourceContents is one entry of a resources/read result. The data of blob contents is left out.
+type MCPResourceContents struct {
+	URI      string `json:"uri"`
+	MimeType string `json:"mime_type,omitempty"`
+	Text     string `json:"text,omitempty"`
+	Blob     bool   `json:"blob,omitempty"`
+}
+
+// enrichMCPResponse describes the JSON-RPC response in the body of the response payload, or the
+// last one of an SSE stream, in its mcp member.
+func enrichMCPResponse(payload *SidebandResponsePayload) {
+	contentType := firstValue(FlattenHeaders(payload.Headers)["content-type"])
+	payload.MCP = parseMCPResponse([]byte(payload.Body), contentType)
+}
+
+// parseMCPResponse parses a JSON-RPC 2.0 response, which may be an SSE stream. Returns nil when
+// body is no JSON-RPC response, is larger than maxMCPBodyBytes or nests too deeply.
+func parseMCPResponse(body []byte, contentType string) *MCPResponseContext {
+	if len(body) > maxMCPBodyBytes {
+		return nil
+	}
+	body = bytes.TrimSpace(ParseSSEFinalMessage(body, contentType))
+	if len(body) == 0 || body[0] != '{' || jsonDepthExceeds(body, maxJSONDepth) {
+		return nil
+	}
+
+	var resp struct {
+		Jsonrpc string              `json:"jsonrpc"`
+		ID      json.RawMessage     `json:"id"`
+		Error   *JsonRPCErrorDetail `json:"error"`
+		Result  *struct {
+			IsError           bool            `json:"isError"`
+			StructuredContent json.RawMessage `json:"structuredContent"`
+			Content           []mcpContent    `json:"content"`
+			Contents          []struct {
+				URI      string  `json:"uri"`
+				MimeType string  `json:"mimeType"`
+				Text     string  `json:"text"`
+				Blob     *string `json:"blob"`
+			} `json:"contents"`
+			// prompts/get results carry a content block in each message
+			Messages []struct {
+				Content mcpContent `json:"content"`
+			} `json:"messages"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil || resp.Jsonrpc != "2.0" || (resp.Result == nil && resp.Error == nil) {
+		return nil
+	}
+
+	ctx := &MCPResponseContext{ID: resp.ID, Error: resp.Error}
+	if string(ctx.ID) == "null" {
+		ctx.ID = nil
+	}
+	result := resp.Result
+	if result == nil {
+		return ctx
+	}
+	ctx.IsError = result.IsError
+	if string(result.StructuredContent) != "null" {
+		ctx.StructuredContent = result.StructuredContent
+	}
+	for _, c := range result.Content {
+		ctx.Content = append(ctx.Content, c.block())
+	}
+	for _, m := range result.Messages {
+		ctx.Content = append(ctx.Content, m.Content.block())
+	}
+	for _, c := range result.Contents {
+		ctx.Contents = append(ctx.Contents, MCPResourceContents{URI: c.URI, MimeType: c.MimeType, Text: c.Text, Blob: c.Blob != nil})
+	}
+	return ctx
+}
+
+// mcpContent is a content block as sent by an MCP server.
+type mcpContent struct {
+	Type     string `json:"type"`
+	Text     string `json:"text"`
+	MimeType string `json:"mimeType"`
+	URI      string `json:"uri"`
+	Resource *struct {
+		URI      string `json:"uri"`
+		MimeType string `json:"mimeType"`
+		Text     string `json:"text"`
+	} `json:"resource"`
+}
+
+func (c *mcpContent) block() MCPContentBlock {
+	block := MCPContentBlock{Type: c.Type, Text: c.Text, MimeType: c.MimeType, URI: c.URI}
+	if c.Resource != nil {
+		block.URI, block.MimeType, block.Text = c.Resource.URI, c.Resource.MimeType, c.Resource.Text
+	}
+	return block
+}
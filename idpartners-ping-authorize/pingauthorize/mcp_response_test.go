@@ -0,0 +1,111 @@
+package pingauthorize
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMCPResponse(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		contentType string
+		want        *MCPResponseContext
+	}{
+		{
+			"tool result",
+			`{"jsonrpc":"2.0","id":1,"result":{"isError":true,"content":[` +
+				`{"type":"text","text":"failed: 555-12-3456"},` +
+				`{"type":"image","data":"iVBORw0K","mimeType":"image/png"},` +
+				`{"type":"resource_link","uri":"file:///srv/a.txt","name":"a"},` +
+				`{"type":"resource","resource":{"uri":"file:///srv/b.txt","mimeType":"text/plain","text":"b"}}],` +
+				`"structuredContent":{"ok":false}}}`,
+			"application/json",
+			&MCPResponseContext{
+				ID:      json.RawMessage("1"),
+				IsError: true,
+				Content: []MCPContentBlock{
+					{Type: "text", Text: "failed: 555-12-3456"},
+					{Type: "image", MimeType: "image/png"},
+					{Type: "resource_link", URI: "file:///srv/a.txt"},
+					{Type: "resource", URI: "file:///srv/b.txt", MimeType: "text/plain", Text: "b"},
+				},
+				StructuredContent: json.RawMessage(`{"ok":false}`),
+			},
+		},
+		{
+			"resource contents",
+			`{"jsonrpc":"2.0","id":"r","result":{"contents":[{"uri":"file:///a","mimeType":"text/plain","text":"hi"},{"uri":"file:///b","blob":"AAAA"}]}}`,
+			"application/json",
+			&MCPResponseContext{
+				ID:       json.RawMessage(`"r"`),
+				Contents: []MCPResourceContents{{URI: "file:///a", MimeType: "text/plain", Text: "hi"}, {URI: "file:///b", Blob: true}},
+			},
+		},
+		{
+			"prompt messages",
+			`{"jsonrpc":"2.0","id":2,"result":{"messages":[{"role":"user","content":{"type":"text","text":"Summarize"}}]}}`,
+			"application/json",
+			&MCPResponseContext{ID: json.RawMessage("2"), Content: []MCPContentBlock{{Type: "text", Text: "Summarize"}}},
+		},
+		{
+			"error",
+			`{"jsonrpc":"2.0","id":null,"error":{"code":-32601,"message":"Method not found"}}`,
+			"application/json",
+			&MCPResponseContext{Error: &JsonRPCErrorDetail{Code: -32601, Message: "Method not found"}},
+		},
+		{
+			"sse",
+			"event: message\ndata: {\"jsonrpc\":\"2.0\",\"method\":\"notifications/progress\",\"params\":{}}\n\n" +
+				"event: message\ndata: {\"jsonrpc\":\"2.0\",\"id\":3,\"result\":{\"content\":[{\"type\":\"text\",\"text\":\"done\"}]}}\n\n",
+			"text/event-stream",
+			&MCPResponseContext{ID: json.RawMessage("3"), Content: []MCPContentBlock{{Type: "text", Text: "done"}}},
+		},
+		{"not json-rpc", `{"result":{"content":[]}}`, "application/json", nil},
+		{"no result", `{"jsonrpc":"2.0","id":1}`, "application/json", nil},
+		{"batch", `[{"jsonrpc":"2.0","id":1,"result":{}}]`, "application/json", nil},
+		{"not json", `hello`, "text/plain", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseMCPResponse([]byte(tt.body), tt.contentType)
+			if !reflect.DeepEqual(got, tt.want) {
+				gotJSON, _ := json.Marshal(got)
+				wantJSON, _ := json.Marshal(tt.want)
+				t.Errorf("got %s, want %s", gotJSON, wantJSON)
+			}
+		})
+	}
+}
+
+func TestMiddleware_MCPResponseContext(t *testing.T) {
+	var responsePayload SidebandResponsePayload
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/sideband/response") {
+			json.NewDecoder(r.Body).Decode(&responsePayload)
+			json.NewEncoder(w).Encode(SidebandResponseResult{ResponseCode: responsePayload.ResponseCode, Body: responsePayload.Body, Headers: responsePayload.Headers})
+			return
+		}
+		var req SidebandAccessRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(SidebandAccessResponse{Method: req.Method, URL: req.URL, Headers: req.Headers})
+	})
+	defer server.Close()
+
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"jsonrpc":"2.0","id":9,"result":{"content":[{"type":"text","text":"42"}]}}`))
+	})
+	rec := httptest.NewRecorder()
+	newTestMiddleware(t, server.URL, true).Handler(upstream).ServeHTTP(rec, httptest.NewRequest("POST", "http://api.example.com/mcp",
+		strings.NewReader(`{"jsonrpc":"2.0","id":9,"method":"tools/call","params":{"name":"calc"}}`)))
+
+	want := &MCPResponseContext{ID: json.RawMessage("9"), Content: []MCPContentBlock{{Type: "text", Text: "42"}}}
+	if rec.Code != http.StatusOK || !reflect.DeepEqual(responsePayload.MCP, want) {
+		t.Errorf("got %d, mcp = %+v, want %+v", rec.Code, responsePayload.MCP, want)
+	}
+}
@@ -0,0 +1,416 @@
+package pingauthorize
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIsMCPRequest(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want bool
+	}{
+		{"tools/call", `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"search"}}`, true},
+		{"tools/list", `{"jsonrpc":"2.0","id":"a","method":"tools/list"}`, true},
+		{"initialize", ` {"jsonrpc":"2.0","id":0,"method":"initialize","params":{}}`, true},
+		{"ping", `{"jsonrpc":"2.0","id":2,"method":"ping"}`, true},
+		{"notification", `{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"requestId":1}}`, true},
+		{"unknown notification", `{"jsonrpc":"2.0","method":"notifications/unknown"}`, false},
+		{"unknown method", `{"jsonrpc":"2.0","id":1,"method":"foo/bar"}`, false},
+		{"missing jsonrpc", `{"id":1,"method":"tools/list"}`, false},
+		{"wrong version", `{"jsonrpc":"1.0","id":1,"method":"tools/list"}`, false},
+		{"rest json", `{"name":"widget"}`, false},
+		{"batch", `[{"jsonrpc":"2.0","id":1,"method":"tools/list"},{"jsonrpc":"2.0","method":"notifications/initialized"}]`, true},
+		{"batch without MCP method", `[{"jsonrpc":"2.0","id":1,"method":"foo/bar"}]`, false},
+		{"batch with invalid entry", `[{"jsonrpc":"2.0","id":1,"method":"tools/list"},{"id":2}]`, false},
+		{"empty batch", `[]`, false},
+		{"not json", `hello`, false},
+		{"empty", ``, false},
+		{"object id", `{"jsonrpc":"2.0","id":{"a":1},"method":"tools/list"}`, false},
+		{"array id", `{"jsonrpc":"2.0","id":[1],"method":"tools/list"}`, false},
+		{"null id", `{"jsonrpc":"2.0","id":null,"method":"tools/list"}`, true},
+		{"too deep", `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":` + strings.Repeat("[", 100) + strings.Repeat("]", 100) + `}`, false},
+		{"too large", `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":"` + strings.Repeat("x", maxMCPBodyBytes) + `"}`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsMCPRequest([]byte(tt.body)); got != tt.want {
+				t.Errorf("IsMCPRequest(%s) = %v, want %v", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnrichMCP(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"search"}}`, `{"method":"tools/call","id":1,"tool":"search"}`},
+		{`{"jsonrpc":"2.0","id":"p","method":"ping"}`, `{"method":"ping","id":"p"}`},
+		{`{"jsonrpc":"2.0","method":"notifications/initialized"}`, `{"method":"notifications/initialized","notification":true}`},
+		{`{"jsonrpc":"2.0","method":"notifications/progress","params":{"progressToken":"t","progress":50}}`, `{"method":"notifications/progress","notification":true}`},
+		{
+			`{"jsonrpc":"2.0","id":3,"method":"sampling/createMessage","params":{"messages":[` +
+				`{"role":"user","content":{"type":"text","text":"Summarize account 4111"}},` +
+				`{"role":"user","content":{"type":"image","data":"iVBORw0KGgo=","mimeType":"image/png"}}],` +
+				`"modelPreferences":{"hints":[{"name":"claude"}],"costPriority":0.3},"systemPrompt":"You are helpful","includeContext":"thisServer","maxTokens":100}}`,
+			`{"method":"sampling/createMessage","id":3,"sampling":{"model_preferences":{"hints":[{"name":"claude"}],"costPriority":0.3},` +
+				`"system_prompt":"You are helpful","include_context":"thisServer","max_tokens":100,"messages":[` +
+				`{"role":"user","type":"text","text":"Summarize account 4111"},{"role":"user","type":"image","mime_type":"image/png"}]}}`,
+		},
+		{`{"jsonrpc":"2.0","id":4,"method":"sampling/createMessage","params":{"messages":"bad"}}`, `{"method":"sampling/createMessage","id":4}`},
+		{`{"jsonrpc":"2.0","id":5,"method":"resources/subscribe","params":{"uri":"file:///srv/log"}}`, `{"method":"resources/subscribe","id":5,"resource":"file:///srv/log"}`},
+		{`{"jsonrpc":"2.0","id":6,"method":"resources/unsubscribe","params":{"uri":"file:///srv/log"}}`, `{"method":"resources/unsubscribe","id":6,"resource":"file:///srv/log"}`},
+		{`{"jsonrpc":"2.0","id":7,"method":"resources/read","params":{"uri":"db://main"}}`, `{"method":"resources/read","id":7,"resource":"db://main"}`},
+		{`{"jsonrpc":"2.0","id":8,"method":"roots/list"}`, `{"method":"roots/list","id":8}`},
+		{
+			`{"jsonrpc":"2.0","id":9,"method":"completion/complete","params":{"ref":{"type":"ref/prompt","name":"review"},"argument":{"name":"language","value":"py"}}}`,
+			`{"method":"completion/complete","id":9,"completion":{"ref":"ref/prompt","name":"review","argument":"language"}}`,
+		},
+		{
+			`{"jsonrpc":"2.0","id":10,"method":"completion/complete","params":{"ref":{"type":"ref/resource","uri":"file:///{path}"},"argument":{"name":"path","value":"/sr"}}}`,
+			`{"method":"completion/complete","id":10,"completion":{"ref":"ref/resource","uri":"file:///{path}","argument":"path"}}`,
+		},
+		{`{"jsonrpc":"2.0","id":11,"method":"completion/complete","params":{}}`, `{"method":"completion/complete","id":11}`},
+		{`{"name":"widget"}`, `null`},
+	}
+	for _, tt := range tests {
+		payload := &SidebandAccessRequest{Body: tt.body}
+		enrichMCP(payload)
+		if got, _ := json.Marshal(payload.MCP); string(got) != tt.want {
+			t.Errorf("enrichMCP(%s) = %s, want %s", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestEnrichMCPBatch(t *testing.T) {
+	payload := &SidebandAccessRequest{Body: `[{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"search"}},` +
+		`{"jsonrpc":"2.0","id":"b","method":"resources/read"},{"jsonrpc":"2.0","method":"notifications/initialized"}]`}
+	enrichMCPBatch(payload)
+
+	got, _ := json.Marshal(payload.MCPBatch)
+	want := `[{"id":1,"method":"tools/call","tool":"search"},{"id":"b","method":"resources/read"},{"method":"notifications/initialized","notification":true}]`
+	if string(got) != want {
+		t.Errorf("mcp_batch = %s, want %s", got, want)
+	}
+
+	single := &SidebandAccessRequest{Body: `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`}
+	if enrichMCPBatch(single); single.MCPBatch != nil {
+		t.Errorf("expected no mcp_batch for a single request, got %+v", single.MCPBatch)
+	}
+}
+
+func TestMCPBatchDenials(t *testing.T) {
+	batch := `[{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"search"}},` +
+		`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"shell"}},` +
+		`{"jsonrpc":"2.0","method":"notifications/initialized"}]`
+	str := func(s string) *string { return &s }
+
+	tests := []struct {
+		name    string
+		body    string
+		allowed *string
+		want    string
+		wantAll bool
+	}{
+		{"unchanged", batch, str(batch), "", false},
+		{"no body", batch, nil, "", false},
+		{"not a batch", `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`, str(`{}`), "", false},
+		{"rewritten, nothing removed", batch, str(`[{"jsonrpc":"2.0","id":1,"method":"tools/call"},{"jsonrpc":"2.0","id":2,"method":"tools/call"}]`), "", false},
+		{"entry removed", batch, str(`[{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"search"}}]`),
+			`[{"jsonrpc":"2.0","id":2,"error":{"code":-32600,"message":"Request denied by policy","data":{"status":403,"reason":"policy"}}}]`, false},
+		{"all removed", batch, str(`[]`),
+			`[{"jsonrpc":"2.0","id":1,"error":{"code":-32600,"message":"Request denied by policy","data":{"status":403,"reason":"policy"}}},{"jsonrpc":"2.0","id":2,"error":{"code":-32600,"message":"Request denied by policy","data":{"status":403,"reason":"policy"}}}]`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs, all := mcpBatchDenials(tt.body, tt.allowed)
+			if string(errs) != tt.want || all != tt.wantAll {
+				t.Errorf("got %s, %v; want %s, %v", errs, all, tt.want, tt.wantAll)
+			}
+		})
+	}
+}
+
+func TestAppendMCPBatchErrors(t *testing.T) {
+	errs := []byte(`[{"jsonrpc":"2.0","id":2,"error":{"code":-32600,"message":"denied"}}]`)
+	tests := []struct {
+		name, body, want string
+	}{
+		{"batch response", `[{"jsonrpc":"2.0","id":1,"result":{}}]`, `[{"jsonrpc":"2.0","id":1,"result":{}},{"jsonrpc":"2.0","id":2,"error":{"code":-32600,"message":"denied"}}]`},
+		{"single response", `{"jsonrpc":"2.0","id":1,"result":{}}`, `[{"jsonrpc":"2.0","id":1,"result":{}},{"jsonrpc":"2.0","id":2,"error":{"code":-32600,"message":"denied"}}]`},
+		{"empty", ``, `[{"jsonrpc":"2.0","id":2,"error":{"code":-32600,"message":"denied"}}]`},
+		{"event stream", "data: {}\n\n", "data: {}\n\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := appendMCPBatchErrors([]byte(tt.body), errs); string(got) != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHttpStatusToJsonRPCError(t *testing.T) {
+	tests := []struct {
+		status int
+		want   int
+	}{
+		{400, -32600},
+		{401, -32600},
+		{403, -32600},
+		{404, -32601},
+		{429, -32000},
+		{500, -32603},
+		{502, -32000},
+		{503, -32000},
+	}
+
+	for _, tt := range tests {
+		if got := httpStatusToJsonRPCError(tt.status); got != tt.want {
+			t.Errorf("httpStatusToJsonRPCError(%d) = %d, want %d", tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestFormatMCPDenyResponse(t *testing.T) {
+	body := formatMCPDenyResponse(401, "Client certificate required", json.RawMessage(`"req-7"`))
+
+	var resp JsonRPCError
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if resp.Jsonrpc != "2.0" {
+		t.Errorf("jsonrpc: got %q", resp.Jsonrpc)
+	}
+	if string(resp.ID) != `"req-7"` {
+		t.Errorf("id: got %s", resp.ID)
+	}
+	if resp.Error.Code != -32600 || resp.Error.Message != "Client certificate required" {
+		t.Errorf("unexpected error: %+v", resp.Error)
+	}
+}
+
+func TestFormatMCPDenyResponse_NullID(t *testing.T) {
+	body := formatMCPDenyResponse(403, "denied", nil)
+	if !strings.Contains(string(body), `"id":null`) {
+		t.Errorf("expected null id, got %s", body)
+	}
+}
+
+func TestFormatMCPDenyError_Data(t *testing.T) {
+	conf := NewConfig()
+	payload := &SidebandAccessRequest{Headers: []map[string]string{{"X-Request-Id": "corr-1"}}}
+	data := newMCPDenyData(conf, payload, 429, "policy")
+	data.DecisionID = policyDecisionID(`{"id":"dec-9","message":"slow down"}`)
+	body := formatMCPDenyError("Too many requests", json.RawMessage(`3`), data)
+
+	want := `{"jsonrpc":"2.0","id":3,"error":{"code":-32000,"message":"Too many requests","data":{"status":429,"reason":"policy","decision_id":"dec-9","correlation_id":"corr-1"}}}`
+	if string(body) != want {
+		t.Errorf("got %s, want %s", body, want)
+	}
+
+	conf.AuditLogRequestIDHeader = ""
+	if got := newMCPDenyData(conf, payload, 403, ""); got != (MCPDenyData{Status: 403}) {
+		t.Errorf("expected no correlation id without audit_log_request_id_header, got %+v", got)
+	}
+	if id := policyDecisionID("denied"); id != "" {
+		t.Errorf("expected no decision id for a plain body, got %q", id)
+	}
+}
+
+func TestJSONDepthExceeds(t *testing.T) {
+	tests := []struct {
+		data string
+		max  int
+		want bool
+	}{
+		{`{"a":[1,2]}`, 2, false},
+		{`{"a":[{"b":1}]}`, 2, true},
+		{`{"a":"[[[[[[["}`, 2, false},
+		{`{"a":"\\"}`, 1, false},
+		{`{"a":"\"[["}`, 1, false},
+	}
+	for _, tt := range tests {
+		if got := jsonDepthExceeds([]byte(tt.data), tt.max); got != tt.want {
+			t.Errorf("jsonDepthExceeds(%s, %d) = %v, want %v", tt.data, tt.max, got, tt.want)
+		}
+	}
+}
+
+func FuzzParseMCPRequest(f *testing.F) {
+	f.Add([]byte(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"search"}}`))
+	f.Add([]byte(`{"jsonrpc":"2.0","id":"abc","method":"initialize"}`))
+	f.Add([]byte(`{"jsonrpc":"2.0","id":{"x":[1]},"method":"tools/list"}`))
+	f.Add([]byte(`[[[[{"jsonrpc":"2.0"}]]]]`))
+	f.Add([]byte(`{"jsonrpc":"2.0","method":"tools/call","params":"\u0000\"}`))
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		req := parseMCPRequest(body)
+		if IsMCPRequest(body) != (req != nil) {
+			t.Fatal("IsMCPRequest disagrees with parseMCPRequest")
+		}
+		if req == nil {
+			return
+		}
+		if req.Jsonrpc != "2.0" || !mcpMethods[req.Method] || !validJSONRPCID(req.ID) {
+			t.Fatalf("accepted invalid request: %+v", req)
+		}
+		deny := formatMCPDenyResponse(403, "denied", req.ID)
+		if !json.Valid(deny) {
+			t.Fatalf("deny response is not valid JSON: %s", deny)
+		}
+	})
+}
+
+func TestMiddleware_MCPBatch(t *testing.T) {
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		var req SidebandAccessRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		// Deny every tools/call for the shell tool, keep the rest of the batch
+		var kept []json.RawMessage
+		var entries []json.RawMessage
+		json.Unmarshal([]byte(req.Body), &entries)
+		for i, entry := range req.MCPBatch {
+			if entry.Tool != "shell" {
+				kept = append(kept, entries[i])
+			}
+		}
+		body, _ := json.Marshal(kept)
+		if len(kept) == 0 {
+			body = []byte(`[]`)
+		}
+		allowed := string(body)
+		json.NewEncoder(w).Encode(SidebandAccessResponse{Method: req.Method, URL: req.URL, Headers: req.Headers, Body: &allowed})
+	})
+	defer server.Close()
+
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []jsonRPCRequest
+		json.NewDecoder(r.Body).Decode(&batch)
+		var replies []string
+		for _, req := range batch {
+			replies = append(replies, `{"jsonrpc":"2.0","id":`+string(req.ID)+`,"result":{}}`)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("[" + strings.Join(replies, ",") + "]"))
+	})
+
+	call := func(id int, tool string) string {
+		return fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":"tools/call","params":{"name":%q}}`, id, tool)
+	}
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		want       string
+	}{
+		{
+			"entry denied",
+			"[" + call(1, "search") + "," + call(2, "shell") + "]",
+			200,
+			`[{"jsonrpc":"2.0","id":1,"result":{}},{"jsonrpc":"2.0","id":2,"error":{"code":-32600,"message":"Request denied by policy","data":{"status":403,"reason":"policy"}}}]`,
+		},
+		{
+			"batch denied",
+			"[" + call(1, "shell") + "," + call(2, "shell") + "]",
+			403,
+			`[{"jsonrpc":"2.0","id":1,"error":{"code":-32600,"message":"Request denied by policy","data":{"status":403,"reason":"policy"}}},{"jsonrpc":"2.0","id":2,"error":{"code":-32600,"message":"Request denied by policy","data":{"status":403,"reason":"policy"}}}]`,
+		},
+	}
+	for _, tt := range tests {
+		for _, responsePhase := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/response_phase=%v", tt.name, responsePhase), func(t *testing.T) {
+				m := newTestMiddleware(t, server.URL, responsePhase)
+				if responsePhase {
+					// Echo the upstream response back from the response phase
+					m.provider = echoResponseProvider{m.provider}
+				}
+				rec := httptest.NewRecorder()
+				m.Handler(upstream).ServeHTTP(rec, httptest.NewRequest("POST", "http://api.example.com/mcp", strings.NewReader(tt.body)))
+
+				if rec.Code != tt.wantStatus || rec.Body.String() != tt.want {
+					t.Errorf("got %d %s, want %d %s", rec.Code, rec.Body.String(), tt.wantStatus, tt.want)
+				}
+			})
+		}
+	}
+}
+
+// echoResponseProvider answers the response phase with the upstream response unchanged.
+type echoResponseProvider struct {
+	PolicyProvider
+}
+
+func (echoResponseProvider) EvaluateResponse(ctx context.Context, req *SidebandResponsePayload) (*SidebandResponseResult, error) {
+	return &SidebandResponseResult{ResponseCode: req.ResponseCode, Body: req.Body, Headers: req.Headers}, nil
+}
+
+func TestResponseMCPSession(t *testing.T) {
+	tests := []struct {
+		headers []map[string]string
+		request string
+		want    string
+	}{
+		{[]map[string]string{{"mcp-session-id": "assigned"}}, "", "assigned"},
+		{[]map[string]string{{"content-type": "application/json"}}, "sent", "sent"},
+		{nil, "", ""},
+	}
+	for _, tt := range tests {
+		if got := responseMCPSession(tt.headers, tt.request); got != tt.want {
+			t.Errorf("responseMCPSession(%v, %q) = %q, want %q", tt.headers, tt.request, got, tt.want)
+		}
+	}
+}
+
+func TestMiddleware_MCPSession(t *testing.T) {
+	var accessPayload SidebandAccessRequest
+	var responseSessions []string
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/sideband/response") {
+			var payload SidebandResponsePayload
+			json.NewDecoder(r.Body).Decode(&payload)
+			responseSessions = append(responseSessions, payload.MCPSessionID)
+			json.NewEncoder(w).Encode(SidebandResponseResult{ResponseCode: payload.ResponseCode, Body: payload.Body, Headers: payload.Headers})
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&accessPayload)
+		json.NewEncoder(w).Encode(SidebandAccessResponse{Method: accessPayload.Method, URL: accessPayload.URL, Headers: accessPayload.Headers})
+	})
+	defer server.Close()
+
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Mcp-Session-Id") == "" {
+			w.Header().Set("Mcp-Session-Id", "s-1")
+		}
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":{}}`))
+	})
+	h := newTestMiddleware(t, server.URL, true).Handler(upstream)
+
+	// initialize: the server assigns the session in its response
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "http://api.example.com/mcp",
+		strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`)))
+	if accessPayload.MCPSessionID != "" {
+		t.Errorf("initialize should have no session, got %q", accessPayload.MCPSessionID)
+	}
+
+	req := httptest.NewRequest("POST", "http://api.example.com/mcp",
+		strings.NewReader(`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"search"}}`))
+	req.Header.Set("Mcp-Session-Id", "s-1")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if accessPayload.MCPSessionID != "s-1" || accessPayload.MCP == nil || accessPayload.MCP.Session != "s-1" {
+		t.Errorf("expected session s-1 in the access payload, got %q %+v", accessPayload.MCPSessionID, accessPayload.MCP)
+	}
+
+	if len(responseSessions) != 2 || responseSessions[0] != "s-1" || responseSessions[1] != "s-1" {
+		t.Errorf("response payload sessions = %q, want [s-1 s-1]", responseSessions)
+	}
+}
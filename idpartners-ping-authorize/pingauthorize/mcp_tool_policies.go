@@ -0,0 +1,184 @@
+package pingauthorize
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/idpartners/idpartners-ping-authorize/pingauthorize/decisionctx"
+)
+
+// Tool policy actions (mcp_tool_policies values).
+const (
+	toolPolicyAllow           = "allow"
+	toolPolicyDeny            = "deny"
+	toolPolicyRequireSideband = "require_sideband"
+	toolPolicyStripArguments  = "strip_arguments"
+)
+
+// toolPolicies holds the compiled mcp_tool_policies: exact tool names, and globs ordered from the
+// longest pattern, the most specific, to the shortest.
+type toolPolicies struct {
+	exact map[string]string
+	globs []toolPolicyGlob
+}
+
+type toolPolicyGlob struct {
+	pattern string
+	re      *regexp.Regexp
+	action  string
+}
+
+// compileToolPolicies compiles mcp_tool_policies. Keys holding "*" or "?" are globs, where "*"
+// matches any run of characters and "?" any one character. Returns nil when there are none.
+func compileToolPolicies(policies map[string]string) (*toolPolicies, error) {
+	if len(policies) == 0 {
+		return nil, nil
+	}
+	p := &toolPolicies{exact: make(map[string]string)}
+	for pattern, action := range policies {
+		switch action {
+		case toolPolicyAllow, toolPolicyDeny, toolPolicyRequireSideband, toolPolicyStripArguments:
+		default:
+			return nil, fmt.Errorf("mcp_tool_policies[%q]: action must be allow, deny, require_sideband or strip_arguments, got %q", pattern, action)
+		}
+		if pattern == "" {
+			return nil, fmt.Errorf("mcp_tool_policies must not contain empty tool names")
+		}
+		if !strings.ContainsAny(pattern, "*?") {
+			p.exact[pattern] = action
+			continue
+		}
+		expr := regexp.QuoteMeta(pattern)
+		expr = strings.NewReplacer(`\*`, ".*", `\?`, ".").Replace(expr)
+		p.globs = append(p.globs, toolPolicyGlob{pattern: pattern, re: regexp.MustCompile("^" + expr + "$"), action: action})
+	}
+	sort.Slice(p.globs, func(i, j int) bool {
+		if len(p.globs[i].pattern) != len(p.globs[j].pattern) {
+			return len(p.globs[i].pattern) > len(p.globs[j].pattern)
+		}
+		return p.globs[i].pattern < p.globs[j].pattern
+	})
+	return p, nil
+}
+
+// action returns the action for tool: its exact entry, or else that of the most specific glob
+// matching it. Returns "" when no entry matches.
+func (p *toolPolicies) action(tool string) string {
+	if action, ok := p.exact[tool]; ok {
+		return action
+	}
+	for _, g := range p.globs {
+		if g.re.MatchString(tool) {
+			return g.action
+		}
+	}
+	return ""
+}
+
+// getToolPolicies returns the compiled mcp_tool_policies, or nil when there are none. Invalid
+// entries are rejected by Validate, so they are treated as none here.
+func (c *Config) getToolPolicies() *toolPolicies {
+	c.toolPoliciesOnce.Do(func() {
+		c.toolPolicies, _ = compileToolPolicies(c.MCPToolPolicies)
+	})
+	return c.toolPolicies
+}
+
+// applyToolPolicy returns the mcp_tool_policies action for a tools/call request, or
+// require_sideband when no entry matches or the request is no tools/call. With strip_arguments,
+// the arguments are removed from the body of the payload the policy provider is sent. A batch is
+// denied when the tool of any entry is, and allowed only when every entry is an allowed tools/call.
+func applyToolPolicy(conf *Config, payload *SidebandAccessRequest, logger *PluginLogger) string {
+	policies := conf.getToolPolicies()
+	if policies == nil {
+		return toolPolicyRequireSideband
+	}
+	if payload.MCP == nil {
+		return batchToolPolicy(policies, payload, logger)
+	}
+	if payload.MCP.Method != "tools/call" {
+		return toolPolicyRequireSideband
+	}
+	action := policies.action(payload.MCP.Tool)
+	switch action {
+	case "":
+		return toolPolicyRequireSideband
+	case toolPolicyStripArguments:
+		if body, ok := stripToolArguments(payload.Body); ok {
+			payload.Body = body
+			payload.MCP.ArgumentsStripped = true
+		}
+	}
+	logger.Debug("MCP tool policy applied", "tool", payload.MCP.Tool, "action", action)
+	return action
+}
+
+// batchToolPolicy returns the mcp_tool_policies action for an MCP batch: deny when any entry calls
+// a denied tool, allow when every entry calls an allowed one, and require_sideband otherwise.
+// Arguments are not stripped from batch entries; they are evaluated with them.
+func batchToolPolicy(policies *toolPolicies, payload *SidebandAccessRequest, logger *PluginLogger) string {
+	batch := parseMCPBatch([]byte(payload.Body))
+	if batch == nil {
+		return toolPolicyRequireSideband
+	}
+	allowed := true
+	for _, req := range batch {
+		tool := mcpToolName(req)
+		action := ""
+		if req.Method == "tools/call" {
+			action = policies.action(tool)
+		}
+		if action == toolPolicyDeny {
+			logger.Debug("MCP tool policy applied to batch", "tool", tool, "action", action)
+			return toolPolicyDeny
+		}
+		allowed = allowed && action == toolPolicyAllow
+	}
+	if allowed {
+		logger.Debug("MCP tool policy applied to batch", "action", toolPolicyAllow)
+		return toolPolicyAllow
+	}
+	return toolPolicyRequireSideband
+}
+
+// stripToolArguments returns the tools/call request body without params.arguments.
+func stripToolArguments(body string) (string, bool) {
+	var req map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		return "", false
+	}
+	var params map[string]json.RawMessage
+	if err := json.Unmarshal(req["params"], &params); err != nil {
+		return "", false
+	}
+	if _, ok := params["arguments"]; !ok {
+		return "", false
+	}
+	delete(params, "arguments")
+	req["params"], _ = json.Marshal(params)
+	stripped, err := json.Marshal(req)
+	if err != nil {
+		return "", false
+	}
+	return string(stripped), true
+}
+
+// ignoreStrippedArguments drops a policy response body that merely echoes a body sent without its
+// tool arguments, so the original request is forwarded upstream with them.
+func ignoreStrippedArguments(payload *SidebandAccessRequest, resp *SidebandAccessResponse) {
+	if payload.MCP != nil && payload.MCP.ArgumentsStripped && resp.Body != nil && *resp.Body == payload.Body {
+		resp.Body = nil
+	}
+}
+
+// toolPolicyDenial builds the local response for a tools/call request, or a batch holding one, of a
+// tool mcp_tool_policies denies.
+func toolPolicyDenial(conf *Config, payload *SidebandAccessRequest) (int, []byte, map[string][]string) {
+	status := http.StatusForbidden
+	body := mcpDenyBody("Tool not allowed", []byte(payload.Body), newMCPDenyData(conf, payload, status, decisionctx.ReasonToolPolicy))
+	return status, body, map[string][]string{"Content-Type": {"application/json"}}
+}
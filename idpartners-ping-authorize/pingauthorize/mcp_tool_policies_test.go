@@ -0,0 +1,210 @@
+package pingauthorize
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/idpartners/idpartners-ping-authorize/pingauthorize/decisionctx"
+)
+
+func TestToolPoliciesAction(t *testing.T) {
+	policies, err := compileToolPolicies(map[string]string{
+		"search":       toolPolicyAllow,
+		"fs_*":         toolPolicyDeny,
+		"fs_read_*":    toolPolicyRequireSideband,
+		"crm_?":        toolPolicyStripArguments,
+		"*":            toolPolicyRequireSideband,
+		"exec.shell.*": toolPolicyDeny,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		tool string
+		want string
+	}{
+		{"search", toolPolicyAllow},
+		{"fs_delete", toolPolicyDeny},
+		{"fs_read_file", toolPolicyRequireSideband},
+		{"crm_a", toolPolicyStripArguments},
+		{"crm_ab", toolPolicyRequireSideband},
+		{"exec.shell.bash", toolPolicyDeny},
+		{"execXshellXbash", toolPolicyRequireSideband},
+		{"searching", toolPolicyRequireSideband},
+	}
+	for _, tt := range tests {
+		if got := policies.action(tt.tool); got != tt.want {
+			t.Errorf("action(%q) = %q, want %q", tt.tool, got, tt.want)
+		}
+	}
+
+	exactOnly, _ := compileToolPolicies(map[string]string{"search": toolPolicyDeny})
+	if got := exactOnly.action("other"); got != "" {
+		t.Errorf("action for an unlisted tool = %q, want none", got)
+	}
+}
+
+func TestCompileToolPolicies_Invalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		policies map[string]string
+		wantErr  string
+	}{
+		{"unknown action", map[string]string{"search": "skip"}, "action must be allow, deny, require_sideband or strip_arguments"},
+		{"empty name", map[string]string{"": toolPolicyDeny}, "mcp_tool_policies must not contain empty tool names"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := compileToolPolicies(tt.policies); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestStripToolArguments(t *testing.T) {
+	got, ok := stripToolArguments(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"crm","arguments":{"ssn":"555-12-3456"}}}`)
+	if !ok || got != `{"id":1,"jsonrpc":"2.0","method":"tools/call","params":{"name":"crm"}}` {
+		t.Errorf("got %s, %v", got, ok)
+	}
+	if _, ok := stripToolArguments(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"crm"}}`); ok {
+		t.Error("expected no change without arguments")
+	}
+}
+
+func TestMiddleware_MCPToolPolicies(t *testing.T) {
+	var evaluatedBody string
+	var evaluated int
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		evaluated++
+		var req SidebandAccessRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		evaluatedBody = req.Body
+		json.NewEncoder(w).Encode(SidebandAccessResponse{Method: req.Method, URL: req.URL, Headers: req.Headers, Body: &req.Body})
+	})
+	defer server.Close()
+
+	var forwardedBody string
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		forwardedBody = string(data)
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":{}}`))
+	})
+	m := newTestMiddleware(t, server.URL, false)
+	m.conf.MCPToolPolicies = map[string]string{
+		"search":  toolPolicyAllow,
+		"shell_*": toolPolicyDeny,
+		"crm":     toolPolicyStripArguments,
+	}
+	h := m.Handler(upstream)
+	call := func(tool string) string {
+		return `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"` + tool + `","arguments":{"q":"x"}}}`
+	}
+
+	tests := []struct {
+		tool          string
+		wantStatus    int
+		wantEvaluated bool
+		wantSent      string
+	}{
+		{"search", http.StatusOK, false, ""},
+		{"shell_exec", http.StatusForbidden, false, ""},
+		{"crm", http.StatusOK, true, `{"id":1,"jsonrpc":"2.0","method":"tools/call","params":{"name":"crm"}}`},
+		{"other", http.StatusOK, true, call("other")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tool, func(t *testing.T) {
+			evaluated, evaluatedBody, forwardedBody = 0, "", ""
+			rec := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "http://api.example.com/mcp", strings.NewReader(call(tt.tool)))
+			ctx, record := decisionctx.NewContext(r.Context())
+			h.ServeHTTP(rec, r.WithContext(ctx))
+
+			if rec.Code != tt.wantStatus || (evaluated > 0) != tt.wantEvaluated {
+				t.Fatalf("got %d after %d evaluations, want %d, evaluated %v", rec.Code, evaluated, tt.wantStatus, tt.wantEvaluated)
+			}
+			if tt.wantStatus == http.StatusForbidden {
+				if !strings.Contains(rec.Body.String(), "Tool not allowed") || forwardedBody != "" {
+					t.Errorf("unexpected deny %s, forwarded %q", rec.Body.String(), forwardedBody)
+				}
+				var resp JsonRPCError
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				if string(resp.Error.Data) != `{"status":403,"reason":"tool_policy"}` {
+					t.Errorf("unexpected error data %s", resp.Error.Data)
+				}
+				if record.Access.Reason != decisionctx.ReasonToolPolicy {
+					t.Errorf("reason = %q, want %q", record.Access.Reason, decisionctx.ReasonToolPolicy)
+				}
+				return
+			}
+			if evaluatedBody != tt.wantSent {
+				t.Errorf("policy was sent %s, want %s", evaluatedBody, tt.wantSent)
+			}
+			if forwardedBody != call(tt.tool) {
+				t.Errorf("upstream got %s, want the original request", forwardedBody)
+			}
+		})
+	}
+}
+
+func TestMiddleware_MCPToolPoliciesBatch(t *testing.T) {
+	var evaluated int
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		evaluated++
+		var req SidebandAccessRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(SidebandAccessResponse{Method: req.Method, URL: req.URL, Headers: req.Headers, Body: &req.Body})
+	})
+	defer server.Close()
+
+	forwarded := false
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = true
+		w.Write([]byte(`[]`))
+	})
+	m := newTestMiddleware(t, server.URL, false)
+	m.conf.MCPToolPolicies = map[string]string{"search": toolPolicyAllow, "shell_*": toolPolicyDeny}
+	h := m.Handler(upstream)
+	call := func(id int, tool string) string {
+		return fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":"tools/call","params":{"name":"%s"}}`, id, tool)
+	}
+
+	tests := []struct {
+		name          string
+		batch         string
+		wantStatus    int
+		wantEvaluated bool
+	}{
+		{"denied tool", "[" + call(1, "search") + "," + call(2, "shell_exec") + "]", http.StatusForbidden, false},
+		{"all allowed", "[" + call(1, "search") + "," + call(2, "search") + "]", http.StatusOK, false},
+		{"unlisted tool", "[" + call(1, "search") + "," + call(2, "other") + "]", http.StatusOK, true},
+		{"other method", "[" + call(1, "search") + `,{"jsonrpc":"2.0","id":2,"method":"tools/list"}]`, http.StatusOK, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			evaluated, forwarded = 0, false
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("POST", "http://api.example.com/mcp", strings.NewReader(tt.batch)))
+
+			if rec.Code != tt.wantStatus || (evaluated > 0) != tt.wantEvaluated {
+				t.Fatalf("got %d after %d evaluations, want %d, evaluated %v", rec.Code, evaluated, tt.wantStatus, tt.wantEvaluated)
+			}
+			if tt.wantStatus != http.StatusForbidden {
+				return
+			}
+			var errs []JsonRPCError
+			if err := json.Unmarshal(rec.Body.Bytes(), &errs); err != nil || len(errs) != 2 || forwarded {
+				t.Fatalf("expected a batch of errors without forwarding, got %s (forwarded %v)", rec.Body.String(), forwarded)
+			}
+			if errs[0].Error.Message != "Tool not allowed" {
+				t.Errorf("unexpected error %+v", errs[0].Error)
+			}
+		})
+	}
+}
@@ -0,0 +1,777 @@
+package pingauthorize
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/idpartners/idpartners-ping-authorize/pingauthorize/decisionctx"
+)
+
+// Middleware enforces PingAuthorize sideband policies in front of a standard net/http handler.
+// It applies the same configuration model and decision logic as the Kong access and response phases.
+type Middleware struct {
+	conf     *Config
+	provider PolicyProvider
+}
+
+// NewMiddleware validates conf and creates a middleware bound to its policy provider.
+// Fields left at their zero value receive the same defaults Kong would apply.
+func NewMiddleware(conf *Config) (*Middleware, error) {
+	conf.applyDefaults()
+	if conf.ProviderType == "" {
+		conf.ProviderType = ProviderSideband
+	}
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+
+	parsedURL, err := ParseURL(conf.ServiceURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse service URL: %w", err)
+	}
+
+	provider, err := newProvider(conf, conf.getHTTPClient(), parsedURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create policy provider: %w", err)
+	}
+
+	return &Middleware{conf: conf, provider: provider}, nil
+}
+
+// Handler wraps next so that every request is evaluated by the policy provider before it is
+// forwarded, and (unless skip_response_phase is set) every response is evaluated before it is
+// written to the client.
+func (m *Middleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.serveHTTP(w, r, next)
+	})
+}
+
+func (m *Middleware) serveHTTP(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	conf := m.conf
+	logger := NewPluginLogger(nil, "access", conf.ServiceURL)
+
+	defer func() {
+		if rec := recover(); rec != nil {
+			logger.Err("Unexpected panic in middleware", "panic", fmt.Sprint(rec))
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}()
+
+	// Only the middleware may mark requests as allowed by fail-open
+	if conf.FailOpenHeader != "" {
+		r.Header.Del(conf.FailOpenHeader)
+	}
+
+	if conf.getBypassRules().match(r.Method, r.URL.Path) {
+		logger.Debug("Request bypassed by bypass_paths or bypass_methods", "method", r.Method, "path", r.URL.Path)
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	var rawBody []byte
+	if !skipsBody(conf, r.Method) {
+		var err error
+		rawBody, err = readRequestBody(w, r, conf)
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				logger.Info("Request body exceeds max_decompressed_body_bytes, rejecting request", "limit", tooLarge.Limit)
+				w.WriteHeader(http.StatusRequestEntityTooLarge)
+				return
+			}
+			logger.Err("Failed to read request body", "error", err.Error())
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(rawBody))
+	}
+
+	payload, err := composeHTTPAccessPayload(r, conf, rawBody)
+	if err != nil {
+		logger.Err("Failed to compose access payload", "error", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	payload.bodyNotRead = skipsBody(conf, r.Method)
+	if client := payload.MCP.initializeClient(); client != nil {
+		// The session is assigned in the response to initialize
+		defer func() { rememberMCPClient(client, w.Header().Get("Mcp-Session-Id")) }()
+	}
+
+	if !sampleDebugPayloads(conf, logger, r.Header.Get) {
+		// Also left out of the response phase and WebSocket messages
+		r = r.WithContext(context.WithValue(r.Context(), debugSkipKey{}, true))
+	}
+
+	record := decisionctx.FromContext(r.Context())
+	switch {
+	case record != nil:
+		*record = *newDecisionRecord(payload, consumerFromContext(r.Context()), r.Header.Get("Mcp-Session-Id"))
+	case conf.AuditLogSink != "" || conf.DenyWebhookURL != "":
+		// Not requested, filled in for the audit log and deny events
+		record = newDecisionRecord(payload, consumerFromContext(r.Context()), r.Header.Get("Mcp-Session-Id"))
+	default:
+		record = &decisionctx.Record{} // not requested, filled in and discarded
+		if conf.EnableOtel {
+			record.MCP = newDecisionRecord(payload, nil, "").MCP // for the MCP request metrics
+		}
+	}
+	phase := &record.Access
+	phase.Shadow = conf.ShadowMode
+	defer conf.metrics().recordDecision(conf.ServiceURL, "access", phase, record.MCP, conf.MCPMetricsMaxLabelValues)
+	// The access span ends and the decision is audited before the request is passed on, so the
+	// span does not include the handler and the audit log stays in order
+	_, span := startPhaseSpan(r.Context(), conf, "access", func() http.Header { return r.Header })
+	endAccess := sync.OnceFunc(func() {
+		endPhaseSpan(span, phase)
+		conf.publishDecision("access", record, phase, r.Header.Get)
+	})
+	defer endAccess()
+	// end answers the client with the decision, or only records it in shadow mode
+	end := func(status int, body []byte, headers map[string][]string) {
+		if !conf.ShadowMode {
+			writeResponse(w, status, body, headers)
+			return
+		}
+		logger.Debug("Shadow mode, decision not enforced", "status_code", status)
+		endAccess()
+		if ws, ok := w.(*wsInterceptor); ok {
+			w = ws.ResponseWriter // not evaluated, like a skipped upgrade
+		}
+		next.ServeHTTP(w, r)
+	}
+
+	host := &httpAccessHost{
+		end:      end,
+		pass:     func() { endAccess(); next.ServeHTTP(w, r) },
+		ctx:      trace.ContextWithSpan(m.forwardHeadersContext(r), span),
+		policies: m.provider,
+	}
+	resp, evaluated, err := evaluateAccess(conf, host, payload, consumerFromContext(r.Context()), phase, logger)
+	if !evaluated {
+		return
+	}
+
+	if conf.MCPWebSocket && isWebSocketUpgrade(r) {
+		// Messages are evaluated against the upgrade request as the client sent it, for as long as
+		// the connection lives rather than the handler call
+		w = &wsInterceptor{ResponseWriter: w, m: m, r: r.Clone(context.WithoutCancel(r.Context()))}
+	}
+
+	if err != nil {
+		if status, body, headers, handled := sidebandFailure(conf, err, logger); handled {
+			phase.Decision, phase.StatusCode = decisionctx.DecisionError, status
+			end(status, body, headers)
+			return
+		}
+		logger.Warn("PingAuthorize unreachable, fail-open enabled, allowing request", "fail_open", true)
+		phase.Decision, phase.FailOpen = decisionctx.DecisionAllow, true
+		if conf.FailOpenHeader != "" {
+			r.Header.Set(conf.FailOpenHeader, "true")
+		}
+		if conf.FailOpenResponseHeader != "" {
+			w.Header().Set(conf.FailOpenResponseHeader, "true")
+		}
+		endAccess()
+		m.forward(w, r, next, record, payload, nil, rawBody)
+		return
+	}
+
+	DebugLogPayload(logger, "Received sideband response", resp, conf)
+	ignoreOmittedBody(payload, resp)
+	ignoreStrippedArguments(payload, resp)
+	phase.Cached = resp.FromCache
+
+	if resp.Response != nil {
+		statusCode, err := strconv.Atoi(resp.Response.ResponseCode)
+		if err != nil {
+			statusCode = 403
+		}
+		logger.Info("Request denied by policy provider", "status_code", statusCode)
+		phase.Decision, phase.StatusCode = decisionctx.DecisionDeny, statusCode
+		body, headers := renderDeny(conf, statusCode, resp.Response, r.Header.Get, logger)
+		end(statusCode, body, headers)
+		return
+	}
+
+	batchErrors, batchDenied := mcpBatchDenials(payload.Body, resp.Body)
+	if batchDenied {
+		logger.Info("MCP batch denied by policy provider", "status_code", http.StatusForbidden)
+		phase.Decision, phase.StatusCode = decisionctx.DecisionDeny, http.StatusForbidden
+		end(http.StatusForbidden, batchErrors, map[string][]string{"Content-Type": {"application/json"}})
+		return
+	}
+
+	phase.Decision, phase.Obligations = decisionctx.DecisionAllow, requestObligations(payload, resp)
+	if !conf.ShadowMode {
+		rawBody = applyHTTPRequestModifications(r, conf, restoreRequestCoding(payload, resp), payload, rawBody, logger)
+		payload.mcpBatchErrors = batchErrors
+	}
+	endAccess()
+	m.forward(w, r, next, record, payload, resp.State, rawBody)
+}
+
+// forward passes the (possibly modified) request to next. When the response phase is enabled the
+// upstream response is buffered so that it can be evaluated before being written to the client.
+// Intercepted WebSocket upgrades are not buffered; their messages are evaluated as they are relayed.
+func (m *Middleware) forward(w http.ResponseWriter, r *http.Request, next http.Handler, record *decisionctx.Record, payload *SidebandAccessRequest, state []byte, rawBody []byte) {
+	if _, ok := w.(*wsInterceptor); ok {
+		// Frames are inspected uncompressed, so no extension may be negotiated
+		r.Header.Del("Sec-WebSocket-Extensions")
+		next.ServeHTTP(w, r)
+		return
+	}
+	if m.conf.SkipResponsePhase {
+		if payload.mcpBatchErrors == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// Buffered only to answer the denied batch entries
+		rec := newResponseRecorder()
+		next.ServeHTTP(rec, r)
+		rec.appendMCPBatchErrors(payload.mcpBatchErrors)
+		rec.flush(w)
+		return
+	}
+	phase := &decisionctx.Phase{Shadow: m.conf.ShadowMode}
+	record.Response = phase
+	defer m.conf.metrics().recordDecision(m.conf.ServiceURL, "response", phase, record.MCP, m.conf.MCPMetricsMaxLabelValues)
+	defer m.conf.publishDecision("response", record, phase, r.Header.Get)
+	if m.conf.ResponsePhaseMCPOnly && !IsMCPRequest(rawBody) {
+		phase.Decision = decisionctx.DecisionSkip
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	rec := newResponseRecorder()
+	rec.start = time.Now()
+	next.ServeHTTP(rec, r)
+	rec.end = time.Now()
+	rec.appendMCPBatchErrors(payload.mcpBatchErrors)
+
+	if len(m.conf.ResponsePhaseStatusCodes) > 0 && !matchStatusCode(rec.status, m.conf.ResponsePhaseStatusCodes) {
+		phase.Decision = decisionctx.DecisionSkip
+		rec.flush(w)
+		return
+	}
+
+	m.evaluateResponse(w, r, rec, payload, state, rawBody, phase, accessLatency(record.Access.LatencyMs))
+}
+
+// evaluateResponse sends the buffered upstream response to the policy provider and writes the result.
+// spent is the duration of the access phase sideband call, charged against request_budget_ms.
+func (m *Middleware) evaluateResponse(w http.ResponseWriter, r *http.Request, rec *responseRecorder, originalRequest *SidebandAccessRequest, state []byte, rawBody []byte, phase *decisionctx.Phase, spent time.Duration) {
+	conf := m.conf
+	logger := NewPluginLogger(nil, "response", conf.ServiceURL)
+	logger.skipPayloads = debugSkipped(r.Context())
+	_, span := startPhaseSpan(r.Context(), conf, "response", func() http.Header { return r.Header })
+	defer func() { endPhaseSpan(span, phase) }()
+	// end sends the response of the decision, or the upstream response in shadow mode
+	end := func(status int, body []byte, headers map[string][]string) {
+		if !conf.ShadowMode {
+			writeResponse(w, status, body, headers)
+			return
+		}
+		logger.Debug("Shadow mode, decision not enforced", "status_code", status)
+		rec.flush(w)
+	}
+
+	payload, encoded, err := composeHTTPResponsePayload(r, conf, rec.status, rec.header, rec.body.Bytes(), originalRequest, state, logger)
+	if err != nil {
+		logger.Err("Failed to format response headers", "error", err.Error())
+		phase.Decision, phase.StatusCode, phase.Error = decisionctx.DecisionError, http.StatusInternalServerError, err.Error()
+		end(http.StatusInternalServerError, nil, nil)
+		return
+	}
+	if conf.IncludeUpstreamTiming {
+		payload.UpstreamTiming = measuredUpstreamTiming(rec.start, rec.headerAt, rec.end)
+	}
+	var requestID json.RawMessage
+	if originalRequest.MCP != nil {
+		requestID = originalRequest.MCP.ID
+	}
+	if status, body, headers, blocked := checkMCPResponseID(conf, requestID, payload, logger); blocked {
+		phase.Decision, phase.Reason, phase.StatusCode = decisionctx.DecisionDeny, decisionctx.ReasonResponseIDMismatch, status
+		end(status, body, headers)
+		return
+	}
+
+	DebugLogPayload(logger, "Sending sideband response", payload, conf)
+
+	ctx, cancel := phaseContext(trace.ContextWithSpan(m.forwardHeadersContext(r), span), conf, "response", spent)
+	defer cancel()
+	start := time.Now()
+	result, err := evaluateResponse(ctx, conf, m.provider, payload, logger)
+	phase.LatencyMs = latencyMs(time.Since(start))
+	if err != nil {
+		recordSidebandFailure(phase, err)
+		if status, body, headers, handled := sidebandFailure(conf, err, logger); handled {
+			phase.Decision, phase.StatusCode = decisionctx.DecisionError, status
+			end(status, body, headers)
+			return
+		}
+		logger.Warn("PingAuthorize unreachable during response phase, fail-open, passing upstream response through")
+		phase.Decision, phase.FailOpen, phase.StatusCode = decisionctx.DecisionAllow, true, rec.status
+		rec.flush(w)
+		return
+	}
+
+	DebugLogPayload(logger, "Received sideband response result", result, conf)
+
+	headers := FlattenHeaders(result.Headers)
+	checkToolsDrift(conf, r.Host+r.URL.Path, r.Header.Get("Mcp-Session-Id"), rawBody, []byte(result.Body), firstValue(headers["content-type"]), logger)
+
+	statusCode, err := strconv.Atoi(result.ResponseCode)
+	if err != nil {
+		statusCode = 200
+	}
+	logger.Info("Response phase complete", "status_code", statusCode)
+	phase.Decision, phase.StatusCode, phase.Obligations = decisionctx.DecisionAllow, statusCode, responseObligations(payload, result)
+	body, headers := reframeSSEResponse(rec.header.Get("Content-Type"), []byte(result.Body), headers)
+	body, headers = encoded.restore(conf, body, headers)
+	end(statusCode, body, headers)
+}
+
+// sidebandFailure maps a provider error to the response the client of a net/http or proxy-wasm
+// host should receive. It returns handled=false when fail_open allows the request to continue.
+func sidebandFailure(conf *Config, err error, logger *PluginLogger) (int, []byte, map[string][]string, bool) {
+	if cbErr, ok := err.(*CircuitBreakerOpenError); ok {
+		if cbErr.Trigger == Trigger429 {
+			body, headers := rateLimitedResponse(cbErr)
+			return 429, body, headers, true
+		}
+	} else if thErr, ok := err.(*SidebandThrottledError); ok {
+		if conf.SidebandRateLimitFailOpen {
+			logger.Warn("Sideband call throttled by sideband_rate_limit, allowing request")
+			return 0, nil, nil, false
+		}
+		body, headers := throttledResponse(thErr)
+		return 429, body, headers, true
+	} else if httpErr, ok := err.(*sidebandHTTPError); ok {
+		if isPassthroughCode(httpErr.StatusCode, conf) {
+			return httpErr.StatusCode, httpErr.Body, map[string][]string{"Content-Type": {"application/json"}}, true
+		}
+		logger.Warn("Sideband request failed", "status", httpErr.StatusCode, "message", httpErr.Message, "id", httpErr.ID)
+	} else {
+		logger.Err("PingAuthorize unreachable", "error", err.Error())
+	}
+
+	if conf.failOpen() {
+		return 0, nil, nil, false
+	}
+	return http.StatusBadGateway, nil, nil, true
+}
+
+// readRequestBody reads and closes the body of r, failing with an *http.MaxBytesError when it
+// exceeds max_decompressed_body_bytes. The middleware buffers the body to evaluate it, so it is
+// bounded like a decompressed one. w may be nil.
+func readRequestBody(w http.ResponseWriter, r *http.Request, conf *Config) ([]byte, error) {
+	maxBytes := conf.MaxDecompressedBodyBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxDecompressedBodyBytes
+	}
+	body := http.MaxBytesReader(w, r.Body, int64(maxBytes))
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+// httpAccessHost runs the access phase evaluation in the net/http middleware.
+type httpAccessHost struct {
+	end      func(status int, body []byte, headers map[string][]string)
+	pass     func()
+	ctx      context.Context
+	policies PolicyProvider
+}
+
+func (h *httpAccessHost) deny(status int, body []byte, headers map[string][]string) {
+	h.end(status, body, headers)
+}
+
+func (h *httpAccessHost) skip() {
+	h.pass()
+}
+
+func (h *httpAccessHost) sidebandContext() context.Context {
+	return h.ctx
+}
+
+func (h *httpAccessHost) provider() (PolicyProvider, error) {
+	return h.policies, nil
+}
+
+// forwardHeadersContext returns a context carrying the configured forward_headers from r.
+func (m *Middleware) forwardHeadersContext(r *http.Request) context.Context {
+	ctx := r.Context()
+	if len(m.conf.ForwardHeaders) == 0 {
+		return ctx
+	}
+	return WithForwardHeaders(ctx, SelectForwardHeaders(r.Header, m.conf.ForwardHeaders))
+}
+
+// composeHTTPAccessPayload builds the /sideband/request payload from a net/http request.
+func composeHTTPAccessPayload(r *http.Request, conf *Config, rawBody []byte) (*SidebandAccessRequest, error) {
+	sourceIP, sourcePort, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse remote address: %w", err)
+	}
+
+	headers := requestHeaders(r)
+	rawBody, payloadHeaders, encoded, err := decodeRequestBody(conf, headers, rawBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress request body: %w", err)
+	}
+	formattedHeaders, err := FormatHeaders(payloadHeaders)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &SidebandAccessRequest{
+		SourceIP:    sourceIP,
+		SourcePort:  sourcePort,
+		Method:      r.Method,
+		URL:         requestURL(r),
+		Body:        string(rawBody),
+		Headers:     formattedHeaders,
+		HTTPVersion: httpVersion(r),
+		encoded:     encoded,
+
+		requestHeaders: headers,
+	}
+
+	if len(conf.ExtractHeaders) > 0 {
+		req.ExtractedHeaders = ExtractHeaders(headers, conf.ExtractHeaders)
+	}
+	if len(conf.ExtractBodyFields) > 0 {
+		req.ExtractedAttrs = ExtractBodyFields(rawBody, conf.ExtractBodyFields)
+	}
+
+	enrichGraphQL(conf, req)
+	enrichSOAP(conf, req)
+	enrichGRPC(conf, req)
+	enrichLLM(conf, req)
+	enrichWebSocket(req)
+	enrichMCP(req)
+	enrichMCPBatch(req)
+	enrichMCPSession(req)
+	enrichMCPClient(req)
+	countRequestTokens(conf, req)
+
+	if len(conf.AttributeMappings) > 0 {
+		req.Attributes = ResolveAttributes(conf.AttributeMappings, &AttributeInput{
+			Headers:  headers,
+			RawQuery: r.URL.RawQuery,
+			Body:     rawBody,
+		})
+	}
+
+	var certPEM bytes.Buffer
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		for _, cert := range r.TLS.PeerCertificates {
+			pem.Encode(&certPEM, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+		}
+	} else {
+		xfcc, err := xfccClientCertPEM(conf, headers)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s header: %w", conf.ClientCertHeader, err)
+		}
+		certPEM.WriteString(xfcc)
+	}
+	if certPEM.Len() > 0 {
+		jwk, err := ExtractClientCertJWK(certPEM.String(), conf.IncludeFullCertChain)
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract client certificate JWK: %w", err)
+		}
+		req.ClientCertificate = jwk
+	}
+
+	return req, nil
+}
+
+// composeHTTPResponsePayload builds the /sideband/response payload for the upstream response to r.
+// The returned encodedBody is non-nil when the upstream body was decompressed for the payload.
+func composeHTTPResponsePayload(r *http.Request, conf *Config, status int, header http.Header, body []byte, originalRequest *SidebandAccessRequest, state []byte, logger *PluginLogger) (*SidebandResponsePayload, *encodedBody, error) {
+	body, decodedHeader, encoded, err := decodeResponseBody(conf, header, body)
+	if err != nil {
+		logger.Warn("Failed to decompress upstream response body, sending it as received", "error", err.Error())
+	}
+
+	formattedHeaders, err := FormatHeaders(decodedHeader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	payload := &SidebandResponsePayload{
+		Method:         r.Method,
+		URL:            requestURL(r),
+		Body:           string(body),
+		ResponseCode:   strconv.Itoa(status),
+		ResponseStatus: getStatusString(status),
+		Headers:        formattedHeaders,
+		HTTPVersion:    httpVersion(r),
+		MCPSessionID:   responseMCPSession(formattedHeaders, r.Header.Get("Mcp-Session-Id")),
+	}
+	enrichMCPResponse(payload)
+	countResponseTokens(conf, payload)
+	if conf.ToolsListCacheVersionHeader != "" {
+		payload.cacheVersion = r.Header.Get(conf.ToolsListCacheVersionHeader)
+	}
+	if conf.ToolsListCacheTTLMs > 0 {
+		payload.cacheCaller = toolsListCacheCaller(consumerFromContext(r.Context()), r.Header.Get("Authorization"))
+	}
+	if len(conf.ExtractHeaders) > 0 {
+		payload.ExtractedHeaders = ExtractHeaders(requestHeaders(r), conf.ExtractHeaders)
+	}
+	if len(conf.AttributeMappings) > 0 {
+		payload.Attributes = ResolveAttributes(conf.AttributeMappings, &AttributeInput{
+			Headers:  requestHeaders(r),
+			RawQuery: r.URL.RawQuery,
+			Body:     body,
+		})
+	}
+	// state and request are mutually exclusive
+	if len(state) > 0 {
+		payload.State = state
+	} else {
+		payload.Request = originalRequest
+	}
+	return payload, encoded, nil
+}
+
+// ComposeAccessPayload builds the /sideband/request payload the plugin would send for r, including
+// extracted headers, attribute mappings and derived attributes. It consumes r.Body, unless
+// skip_body_methods lists the request method, and reports skip=true when evaluation_rules or
+// skip_expression match (the plugin would not call PingAuthorize).
+// The client certificate is taken from r.TLS.PeerCertificates.
+func ComposeAccessPayload(r *http.Request, conf *Config) (payload *SidebandAccessRequest, skip bool, err error) {
+	var rawBody []byte
+	if r.Body != nil && !skipsBody(conf, r.Method) {
+		if rawBody, err = readRequestBody(nil, r, conf); err != nil {
+			return nil, false, fmt.Errorf("failed to read request body: %w", err)
+		}
+	}
+
+	payload, err = composeHTTPAccessPayload(r, conf, rawBody)
+	if err != nil {
+		return nil, false, err
+	}
+	payload.bodyNotRead = skipsBody(conf, r.Method)
+	logger := NewPluginLogger(nil, "access", conf.ServiceURL)
+	if action, _ := applyEvaluationRules(conf, payload, consumerFromContext(r.Context()), logger); action != ruleActionEvaluate {
+		return payload, true, nil
+	}
+	skip, err = applyExpressions(conf, payload, logger)
+	if err != nil {
+		return nil, false, err
+	}
+	if !skip {
+		resolveTokenClaims(r.Context(), conf, payload)
+		resolveTokenSubject(r.Context(), conf, payload, logger)
+	}
+	return payload, skip, nil
+}
+
+// ComposeResponsePayload builds the /sideband/response payload the plugin would send for the
+// upstream response to r. state is the access phase state; when empty, originalRequest is sent instead.
+func ComposeResponsePayload(r *http.Request, conf *Config, status int, header http.Header, body []byte, originalRequest *SidebandAccessRequest, state json.RawMessage) (*SidebandResponsePayload, error) {
+	payload, _, err := composeHTTPResponsePayload(r, conf, status, header, body, originalRequest, state, NewPluginLogger(nil, "response", conf.ServiceURL))
+	return payload, err
+}
+
+// requestHeaders returns the request headers including Host, which net/http stores separately.
+func requestHeaders(r *http.Request) map[string][]string {
+	headers := make(map[string][]string, len(r.Header)+1)
+	for name, values := range r.Header {
+		headers[name] = values
+	}
+	if r.Host != "" {
+		headers["Host"] = []string{r.Host}
+	}
+	return headers
+}
+
+// requestURL reconstructs the full request URL in the same form as the Kong access phase.
+func requestURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	host, port, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+		port = "80"
+		if scheme == "https" {
+			port = "443"
+		}
+	}
+
+	reqURL := fmt.Sprintf("%s://%s:%s%s", scheme, host, port, r.URL.EscapedPath())
+	if q := encodeForwardedQuery(r.URL.RawQuery); q != "" {
+		reqURL = reqURL + "?" + q
+	}
+	return reqURL
+}
+
+// httpVersion returns the protocol version of r as a string like "1.1".
+func httpVersion(r *http.Request) string {
+	if r.ProtoMajor >= 2 {
+		return strconv.Itoa(r.ProtoMajor)
+	}
+	return fmt.Sprintf("%d.%d", r.ProtoMajor, r.ProtoMinor)
+}
+
+// applyHTTPRequestModifications applies the allowed-request modifications from the policy provider
+// to r and returns the body that will be forwarded.
+func applyHTTPRequestModifications(r *http.Request, conf *Config, resp *SidebandAccessResponse, payload *SidebandAccessRequest, rawBody []byte, logger *PluginLogger) []byte {
+	// The policy response carries the complete header set; headers it omits are removed
+	r.Header = make(http.Header)
+	for name, values := range FlattenHeaders(resp.Headers) {
+		if name == "host" {
+			r.Host = values[0]
+			continue
+		}
+		for _, v := range values {
+			r.Header.Add(name, v)
+		}
+	}
+
+	if conf.StripAcceptEncoding {
+		r.Header.Del("Accept-Encoding")
+	}
+
+	if resp.Method != "" && resp.Method != r.Method {
+		r.Method = resp.Method
+	}
+
+	if resp.URL != "" && resp.URL != payload.URL {
+		updateHTTPRequestURL(r, resp.URL, payload.URL, logger)
+	}
+
+	if resp.Body != nil && *resp.Body != string(rawBody) {
+		rawBody = []byte(*resp.Body)
+		r.Body = io.NopCloser(bytes.NewReader(rawBody))
+		r.ContentLength = int64(len(rawBody))
+		r.Header.Del("Content-Length")
+	}
+
+	return rawBody
+}
+
+// updateHTTPRequestURL applies URL modifications from the policy provider to r.
+func updateHTTPRequestURL(r *http.Request, newURL, currentURL string, logger *PluginLogger) {
+	newParsed, err := url.Parse(newURL)
+	if err != nil {
+		logger.Warn("Failed to parse new URL", "url", newURL, "error", err.Error())
+		return
+	}
+
+	currentParsed, err := url.Parse(currentURL)
+	if err != nil {
+		logger.Warn("Failed to parse current URL", "url", currentURL, "error", err.Error())
+		return
+	}
+
+	if newParsed.Scheme != currentParsed.Scheme {
+		logger.Warn("Scheme change not supported", "from", currentParsed.Scheme, "to", newParsed.Scheme)
+	}
+	if newParsed.Host != currentParsed.Host {
+		r.Host = newParsed.Host
+	}
+	if newParsed.Path != currentParsed.Path {
+		r.URL.Path = newParsed.Path
+		r.URL.RawPath = newParsed.RawPath
+	}
+	if newParsed.RawQuery != currentParsed.RawQuery {
+		r.URL.RawQuery = newParsed.RawQuery
+	}
+}
+
+// writeResponse writes a complete response with the given status, body and headers.
+func writeResponse(w http.ResponseWriter, status int, body []byte, headers map[string][]string) {
+	for name, values := range headers {
+		if preservedResponseHeaders[strings.ToLower(name)] {
+			continue
+		}
+		for _, v := range values {
+			w.Header().Add(name, v)
+		}
+	}
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// responseRecorder buffers the upstream response for response phase evaluation.
+type responseRecorder struct {
+	header      http.Header
+	body        bytes.Buffer
+	status      int
+	wroteHeader bool
+
+	// start and end bracket the upstream handler; headerAt is when it first wrote its response
+	start, headerAt, end time.Time
+}
+
+func newResponseRecorder() *responseRecorder {
+	return &responseRecorder{header: make(http.Header), status: http.StatusOK}
+}
+
+func (rr *responseRecorder) Header() http.Header {
+	return rr.header
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	if rr.wroteHeader {
+		return
+	}
+	rr.status = status
+	rr.markHeader()
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	rr.markHeader()
+	return rr.body.Write(b)
+}
+
+func (rr *responseRecorder) markHeader() {
+	if !rr.wroteHeader {
+		rr.wroteHeader = true
+		rr.headerAt = time.Now()
+	}
+}
+
+// appendMCPBatchErrors adds the errors of the MCP batch entries the policy denied to the buffered
+// response, see appendMCPBatchErrors.
+func (rr *responseRecorder) appendMCPBatchErrors(errs []byte) {
+	if errs == nil {
+		return
+	}
+	if merged := appendMCPBatchErrors(rr.body.Bytes(), errs); !bytes.Equal(merged, rr.body.Bytes()) {
+		rr.body.Reset()
+		rr.body.Write(merged)
+		rr.header.Del("Content-Length")
+	}
+}
+
+// flush writes the buffered upstream response unmodified.
+func (rr *responseRecorder) flush(w http.ResponseWriter) {
+	for name, values := range rr.header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(rr.status)
+	w.Write(rr.body.Bytes())
+}
@@ -0,0 +1,438 @@
+package pingauthorize
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/idpartners/idpartners-ping-authorize/pingauthorize/decisionctx"
+)
+
+// newTestMiddleware creates a middleware pointed at server with response phase disabled unless enabled.
+func newTestMiddleware(t *testing.T, serverURL string, responsePhase bool) *Middleware {
+	t.Helper()
+	conf := &Config{
+		ServiceURL:        serverURL,
+		SharedSecret:      "test-secret",
+		SecretHeaderName:  "X-Secret",
+		SkipResponsePhase: !responsePhase,
+	}
+	m, err := NewMiddleware(conf)
+	if err != nil {
+		t.Fatalf("NewMiddleware() error: %v", err)
+	}
+	return m
+}
+
+func upstreamEcho(t *testing.T, calls *int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Upstream-Path", r.URL.Path)
+		w.Header().Set("X-Upstream-User", r.Header.Get("X-User"))
+		w.WriteHeader(200)
+		w.Write(body)
+	})
+}
+
+func TestNewMiddleware_InvalidConfig(t *testing.T) {
+	_, err := NewMiddleware(&Config{ServiceURL: "ftp://bad", SharedSecret: "s", SecretHeaderName: "X-Secret"})
+	if err == nil {
+		t.Fatal("expected validation error")
+	}
+}
+
+func TestMiddleware_AllowWithModifications(t *testing.T) {
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		var req SidebandAccessRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Method != "POST" || req.Body != "hello" {
+			t.Errorf("unexpected payload: method=%q body=%q", req.Method, req.Body)
+		}
+		if !strings.HasSuffix(req.URL, "/orig?a=1") {
+			t.Errorf("unexpected url: %q", req.URL)
+		}
+		body := "rewritten"
+		json.NewEncoder(w).Encode(SidebandAccessResponse{
+			Method:  "POST",
+			URL:     strings.Replace(req.URL, "/orig", "/rewritten", 1),
+			Body:    &body,
+			Headers: append(req.Headers, map[string]string{"x-user": "alice"}),
+		})
+	})
+	defer server.Close()
+
+	calls := 0
+	h := newTestMiddleware(t, server.URL, false).Handler(upstreamEcho(t, &calls))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "http://api.example.com/orig?a=1", strings.NewReader("hello")))
+
+	if calls != 1 {
+		t.Fatalf("expected upstream to be called once, got %d", calls)
+	}
+	if rec.Code != 200 {
+		t.Errorf("expected 200, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("X-Upstream-Path"); got != "/rewritten" {
+		t.Errorf("expected rewritten path, got %q", got)
+	}
+	if got := rec.Header().Get("X-Upstream-User"); got != "alice" {
+		t.Errorf("expected injected header, got %q", got)
+	}
+	if rec.Body.String() != "rewritten" {
+		t.Errorf("expected rewritten body, got %q", rec.Body.String())
+	}
+}
+
+func TestMiddleware_Deny(t *testing.T) {
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(SidebandAccessResponse{
+			Response: &DenyResponse{
+				ResponseCode: "403",
+				Body:         `{"error":"forbidden"}`,
+				Headers:      []map[string]string{{"content-type": "application/json"}},
+			},
+		})
+	})
+	defer server.Close()
+
+	calls := 0
+	h := newTestMiddleware(t, server.URL, false).Handler(upstreamEcho(t, &calls))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "http://api.example.com/x", nil))
+
+	if calls != 0 {
+		t.Error("upstream must not be called for a denied request")
+	}
+	if rec.Code != 403 || rec.Body.String() != `{"error":"forbidden"}` {
+		t.Errorf("unexpected deny response: %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestMiddleware_ShadowMode(t *testing.T) {
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/sideband/request") {
+			var req SidebandAccessRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			if req.Method == "DELETE" {
+				json.NewEncoder(w).Encode(SidebandAccessResponse{Response: &DenyResponse{ResponseCode: "403", Body: "forbidden"}})
+				return
+			}
+			body := "rewritten"
+			json.NewEncoder(w).Encode(SidebandAccessResponse{
+				Method:  req.Method,
+				URL:     strings.Replace(req.URL, "/orig", "/rewritten", 1),
+				Body:    &body,
+				Headers: append(req.Headers, map[string]string{"x-user": "alice"}),
+			})
+			return
+		}
+		json.NewEncoder(w).Encode(SidebandResponseResult{ResponseCode: "502", Body: "filtered"})
+	})
+	defer server.Close()
+
+	m := newTestMiddleware(t, server.URL, true)
+	m.conf.ShadowMode = true
+	calls := 0
+	h := m.Handler(upstreamEcho(t, &calls))
+
+	tests := []struct {
+		name              string
+		method            string
+		wantDecision      string
+		wantStatusCode    int
+		wantResponsePhase bool
+	}{
+		{"deny passed through", "DELETE", decisionctx.DecisionDeny, 403, false},
+		{"modifications not applied", "POST", decisionctx.DecisionAllow, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls = 0
+			r := httptest.NewRequest(tt.method, "http://api.example.com/orig", strings.NewReader("hello"))
+			ctx, record := decisionctx.NewContext(r.Context())
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r.WithContext(ctx))
+
+			if calls != 1 || rec.Code != 200 || rec.Body.String() != "hello" ||
+				rec.Header().Get("X-Upstream-Path") != "/orig" || rec.Header().Get("X-Upstream-User") != "" {
+				t.Errorf("expected the unmodified upstream response, got %d %q headers=%v", rec.Code, rec.Body.String(), rec.Header())
+			}
+			if record.Access.Decision != tt.wantDecision || record.Access.StatusCode != tt.wantStatusCode || !record.Access.Shadow {
+				t.Errorf("unexpected access phase: %+v", record.Access)
+			}
+			if (record.Response != nil) != tt.wantResponsePhase {
+				t.Fatalf("unexpected response phase: %+v", record.Response)
+			}
+			if tt.wantResponsePhase && (record.Response.StatusCode != 502 || !record.Response.Shadow) {
+				t.Errorf("unexpected response phase: %+v", record.Response)
+			}
+		})
+	}
+}
+
+func TestMiddleware_ResponsePhase(t *testing.T) {
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/sideband/request") {
+			var req SidebandAccessRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			json.NewEncoder(w).Encode(SidebandAccessResponse{
+				Method:  req.Method,
+				URL:     req.URL,
+				Headers: req.Headers,
+				State:   json.RawMessage(`{"s":1}`),
+			})
+			return
+		}
+		var payload SidebandResponsePayload
+		json.NewDecoder(r.Body).Decode(&payload)
+		if string(payload.State) != `{"s":1}` || payload.Request != nil {
+			t.Errorf("expected state only, got state=%s request=%v", payload.State, payload.Request)
+		}
+		if payload.ResponseCode != "200" || payload.Body != "upstream" {
+			t.Errorf("unexpected upstream response in payload: %q %q", payload.ResponseCode, payload.Body)
+		}
+		json.NewEncoder(w).Encode(SidebandResponseResult{
+			ResponseCode: "200",
+			Body:         "filtered",
+			Headers:      []map[string]string{{"x-filtered": "true"}},
+		})
+	})
+	defer server.Close()
+
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("upstream"))
+	})
+	h := newTestMiddleware(t, server.URL, true).Handler(upstream)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "http://api.example.com/x", nil))
+
+	if rec.Body.String() != "filtered" || rec.Header().Get("X-Filtered") != "true" {
+		t.Errorf("expected policy response, got %q headers=%v", rec.Body.String(), rec.Header())
+	}
+}
+
+func TestMiddleware_Unreachable(t *testing.T) {
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {})
+	server.Close()
+
+	tests := []struct {
+		name       string
+		failOpen   bool
+		wantStatus int
+		wantCalls  int
+	}{
+		{"fail closed", false, 502, 0},
+		{"fail open", true, 200, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := NewMiddleware(&Config{
+				ServiceURL:        server.URL,
+				SharedSecret:      "s",
+				SecretHeaderName:  "X-Secret",
+				SkipResponsePhase: true,
+				FailOpen:          tt.failOpen,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			calls := 0
+			rec := httptest.NewRecorder()
+			m.Handler(upstreamEcho(t, &calls)).ServeHTTP(rec, httptest.NewRequest("GET", "http://api.example.com/x", nil))
+			if rec.Code != tt.wantStatus || calls != tt.wantCalls {
+				t.Errorf("got status %d calls %d, want %d %d", rec.Code, calls, tt.wantStatus, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestMiddleware_FailOpenHeaders(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		var req SidebandAccessRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		body := req.Body
+		json.NewEncoder(w).Encode(SidebandAccessResponse{Method: req.Method, URL: req.URL, Headers: req.Headers, Body: &body})
+	})
+	defer server.Close()
+
+	m, err := NewMiddleware(&Config{
+		ServiceURL:             server.URL,
+		SharedSecret:           "s",
+		SecretHeaderName:       "X-Secret",
+		SkipResponsePhase:      true,
+		FailOpen:               true,
+		FailOpenHeader:         "X-Paz-Failopen",
+		FailOpenResponseHeader: "X-Paz-Degraded",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var upstream string
+	h := m.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstream = r.Header.Get("X-Paz-Failopen")
+	}))
+	serve := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "http://api.example.com/x", nil)
+		req.Header.Set("X-Paz-Failopen", "forged")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := serve(); upstream != "true" || rec.Header().Get("X-Paz-Degraded") != "true" {
+		t.Errorf("expected fail-open markers, got upstream %q response %q", upstream, rec.Header().Get("X-Paz-Degraded"))
+	}
+	status = http.StatusOK
+	if rec := serve(); upstream != "" || rec.Header().Get("X-Paz-Degraded") != "" {
+		t.Errorf("expected no markers on policy-approved requests, got upstream %q response %q", upstream, rec.Header().Get("X-Paz-Degraded"))
+	}
+}
+
+func TestMiddleware_RequireClientCertificate(t *testing.T) {
+	m, err := NewMiddleware(&Config{
+		ServiceURL:               "http://127.0.0.1:1",
+		SharedSecret:             "s",
+		SecretHeaderName:         "X-Secret",
+		RequireClientCertificate: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	rec := httptest.NewRecorder()
+	m.Handler(upstreamEcho(t, &calls)).ServeHTTP(rec, httptest.NewRequest("GET", "http://api.example.com/x", nil))
+	if rec.Code != 401 || !strings.Contains(rec.Body.String(), "CLIENT_CERTIFICATE_REQUIRED") {
+		t.Errorf("unexpected response: %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRequestURL(t *testing.T) {
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{"http://api.example.com/a/b?x=1", "http://api.example.com:80/a/b?x=1"},
+		{"http://api.example.com:8080/", "http://api.example.com:8080/"},
+		{"http://api.example.com/a?&", "http://api.example.com:80/a"},
+	}
+	for _, tt := range tests {
+		if got := requestURL(httptest.NewRequest("GET", tt.target, nil)); got != tt.want {
+			t.Errorf("requestURL(%q) = %q, want %q", tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestComposeAccessPayload(t *testing.T) {
+	conf := &Config{
+		ExtractHeaders:    []string{"x-tenant"},
+		ExtractBodyFields: []string{"$.amount", "$.account.id"},
+		SkipExpression:    "request.method == 'OPTIONS'",
+		DerivedAttributes: []DerivedAttribute{{Name: "big", Expression: "body.amount > 100"}},
+	}
+	r := httptest.NewRequest("POST", "http://api.example.com/pay", strings.NewReader(`{"amount":500}`))
+	r.Header.Set("X-Tenant", "acme")
+
+	payload, skip, err := ComposeAccessPayload(r, conf)
+	if err != nil {
+		t.Fatalf("ComposeAccessPayload() error: %v", err)
+	}
+	if skip {
+		t.Error("expected no skip")
+	}
+	if payload.URL != "http://api.example.com:80/pay" || payload.Body != `{"amount":500}` {
+		t.Errorf("unexpected payload: url=%q body=%q", payload.URL, payload.Body)
+	}
+	if payload.ExtractedHeaders["x-tenant"] != "acme" {
+		t.Errorf("expected extracted header, got %v", payload.ExtractedHeaders)
+	}
+	if len(payload.ExtractedAttrs) != 1 || payload.ExtractedAttrs["$.amount"] != float64(500) {
+		t.Errorf("expected extracted body field, got %v", payload.ExtractedAttrs)
+	}
+	if payload.Attributes["big"] != true {
+		t.Errorf("expected derived attribute, got %v", payload.Attributes)
+	}
+
+	_, skip, err = ComposeAccessPayload(httptest.NewRequest("OPTIONS", "http://api.example.com/pay", nil), conf)
+	if err != nil || !skip {
+		t.Errorf("expected skip for OPTIONS, got skip=%v err=%v", skip, err)
+	}
+}
+
+func TestComposeResponsePayload_StateReplacesRequest(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://api.example.com/a", nil)
+	original := &SidebandAccessRequest{Method: "GET"}
+
+	payload, err := ComposeResponsePayload(r, &Config{}, 404, http.Header{"X-A": {"1"}}, []byte("nope"), original, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload.Request != original || payload.ResponseCode != "404" || payload.ResponseStatus != "NOT FOUND" {
+		t.Errorf("unexpected payload: %+v", payload)
+	}
+
+	payload, _ = ComposeResponsePayload(r, &Config{}, 200, nil, nil, original, json.RawMessage(`{"s":1}`))
+	if payload.Request != nil || string(payload.State) != `{"s":1}` {
+		t.Errorf("expected state only, got request=%v state=%s", payload.Request, payload.State)
+	}
+}
+
+func TestComposeResponsePayload_AttributeMappings(t *testing.T) {
+	conf := &Config{AttributeMappings: []AttributeMapping{
+		{Name: "tenant", Source: "header:x-tenant-id"},
+		{Name: "account", Source: "query:account"},
+		{Name: "balance", Source: "body:$.balance"},
+		{Name: "missing", Source: "body:$.nope"},
+	}}
+	r := httptest.NewRequest("GET", "http://api.example.com/accounts?account=42", nil)
+	r.Header.Set("X-Tenant-Id", "acme")
+
+	payload, err := ComposeResponsePayload(r, conf, 200, http.Header{"Content-Type": {"application/json"}}, []byte(`{"balance":10}`), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"tenant": "acme", "account": "42", "balance": float64(10)}
+	if !reflect.DeepEqual(payload.Attributes, want) {
+		t.Errorf("attributes = %v, want %v", payload.Attributes, want)
+	}
+}
+
+func TestMiddleware_BodyTooLarge(t *testing.T) {
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("oversized requests must not be evaluated")
+	})
+	defer server.Close()
+
+	m := newTestMiddleware(t, server.URL, false)
+	m.conf.MaxDecompressedBodyBytes = 16
+	calls := 0
+	h := m.Handler(upstreamEcho(t, &calls))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "http://api.example.com/a", strings.NewReader(strings.Repeat("x", 17))))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413, got %d", rec.Code)
+	}
+	if calls != 0 {
+		t.Errorf("expected the upstream not to be called, got %d calls", calls)
+	}
+
+	_, _, err := ComposeAccessPayload(httptest.NewRequest("POST", "http://api.example.com/a", strings.NewReader(strings.Repeat("x", 17))), m.conf)
+	var tooLarge *http.MaxBytesError
+	if !errors.As(err, &tooLarge) {
+		t.Errorf("expected ComposeAccessPayload to fail with a MaxBytesError, got %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+package pingauthorize
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+)
+
+// defaultMultipartTextFieldMaxBytes is the text field size cap used when
+// multipart_text_field_max_bytes is unset.
+const defaultMultipartTextFieldMaxBytes = 1024
+
+// maxMultipartParts caps the parts listed in a MultipartSummary.
+const maxMultipartParts = 100
+
+// MultipartSummary describes a multipart/form-data request body sent in place of the raw body.
+type MultipartSummary struct {
+	Parts []MultipartPart `json:"parts"`
+	// Truncated is set when the body had more than maxMultipartParts parts.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// MultipartPart describes one part of a multipart/form-data body. Value holds the content of text
+// fields (parts without a filename) up to multipart_text_field_max_bytes.
+type MultipartPart struct {
+	Name        string  `json:"name"`
+	Filename    string  `json:"filename,omitempty"`
+	ContentType string  `json:"content_type,omitempty"`
+	Size        int     `json:"size"`
+	Value       *string `json:"value,omitempty"`
+}
+
+// summarizeMultipart replaces a multipart/form-data payload body with a MultipartSummary and a
+// BodyDigest when summarize_multipart_body is enabled. Bodies that fail to parse are left in place.
+// Returns whether the body was summarized.
+func summarizeMultipart(conf *Config, payload *SidebandAccessRequest, logger *PluginLogger) bool {
+	if !conf.SummarizeMultipartBody || payload.Body == "" {
+		return false
+	}
+	mediaType, params, err := mime.ParseMediaType(firstValue(FlattenHeaders(payload.Headers)["content-type"]))
+	if err != nil || mediaType != "multipart/form-data" || params["boundary"] == "" {
+		return false
+	}
+
+	maxValue := conf.MultipartTextFieldMaxBytes
+	if maxValue <= 0 {
+		maxValue = defaultMultipartTextFieldMaxBytes
+	}
+	summary, err := parseMultipartSummary([]byte(payload.Body), params["boundary"], maxValue)
+	if err != nil {
+		logger.Warn("Failed to parse multipart body, sending it as received", "error", err.Error())
+		return false
+	}
+
+	omitBody(payload)
+	payload.MultipartSummary = summary
+	logger.Debug("Multipart body summarized in sideband payload", "parts", len(summary.Parts),
+		"length", payload.BodyDigest.Length)
+	return true
+}
+
+// parseMultipartSummary reads the parts of a multipart body with the given boundary.
+func parseMultipartSummary(body []byte, boundary string, maxValue int) (*MultipartSummary, error) {
+	reader := multipart.NewReader(bytes.NewReader(body), boundary)
+	summary := &MultipartSummary{Parts: []MultipartPart{}}
+	for {
+		part, err := reader.NextRawPart()
+		if errors.Is(err, io.EOF) && len(summary.Parts) > 0 {
+			return summary, nil
+		}
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("no parts found for boundary %q", boundary)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(summary.Parts) == maxMultipartParts {
+			summary.Truncated = true
+			return summary, nil
+		}
+
+		var value bytes.Buffer
+		size, err := io.Copy(&value, io.LimitReader(part, int64(maxValue)+1))
+		if err == nil {
+			var rest int64
+			rest, err = io.Copy(io.Discard, part)
+			size += rest
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read part %q: %w", part.FormName(), err)
+		}
+
+		p := MultipartPart{
+			Name:        part.FormName(),
+			Filename:    part.FileName(),
+			ContentType: part.Header.Get("Content-Type"),
+			Size:        int(size),
+		}
+		if p.Filename == "" && size <= int64(maxValue) {
+			v := value.String()
+			p.Value = &v
+		}
+		summary.Parts = append(summary.Parts, p)
+	}
+}
@@ -0,0 +1,129 @@
+package pingauthorize
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+// multipartBody builds a multipart/form-data body with a text field, a long text field and a file.
+func multipartBody(t *testing.T) (string, string) {
+	t.Helper()
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	w.WriteField("title", "Quarterly report")
+	w.WriteField("notes", strings.Repeat("n", 20))
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Disposition", `form-data; name="file"; filename="report.pdf"`)
+	h.Set("Content-Type", "application/pdf")
+	part, err := w.CreatePart(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(bytes.Repeat([]byte{0x25}, 4096))
+	w.Close()
+	return buf.String(), w.FormDataContentType()
+}
+
+func TestSummarizeMultipart(t *testing.T) {
+	body, contentType := multipartBody(t)
+	conf := &Config{SummarizeMultipartBody: true, MultipartTextFieldMaxBytes: 16}
+
+	tests := []struct {
+		name          string
+		conf          *Config
+		contentType   string
+		body          string
+		wantSummarize bool
+	}{
+		{"multipart", conf, contentType, body, true},
+		{"disabled", &Config{}, contentType, body, false},
+		{"other content type", conf, "application/json", `{"a":1}`, false},
+		{"missing boundary", conf, "multipart/form-data", body, false},
+		{"malformed", conf, contentType, "--not-the-boundary\r\n", false},
+		{"empty body", conf, contentType, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := &SidebandAccessRequest{Body: tt.body, Headers: []map[string]string{{"content-type": tt.contentType}}}
+			got := summarizeMultipart(tt.conf, payload, NewPluginLogger(nil, "access", ""))
+			if got != tt.wantSummarize {
+				t.Fatalf("summarized = %v, want %v", got, tt.wantSummarize)
+			}
+			if !got {
+				if payload.Body != tt.body || payload.MultipartSummary != nil {
+					t.Errorf("body should be forwarded unchanged: %+v", payload)
+				}
+				return
+			}
+			if payload.Body != "" || payload.BodyDigest == nil || payload.BodyDigest.Length != len(tt.body) {
+				t.Errorf("expected a digest instead of the body, got %+v", payload)
+			}
+		})
+	}
+}
+
+func TestParseMultipartSummary(t *testing.T) {
+	body, contentType := multipartBody(t)
+	boundary := strings.TrimPrefix(contentType, "multipart/form-data; boundary=")
+
+	summary, err := parseMultipartSummary([]byte(body), boundary, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(summary)
+	want := `{"parts":[` +
+		`{"name":"title","size":16,"value":"Quarterly report"},` +
+		`{"name":"notes","size":20},` +
+		`{"name":"file","filename":"report.pdf","content_type":"application/pdf","size":4096}]}`
+	if string(data) != want {
+		t.Errorf("got %s, want %s", data, want)
+	}
+
+	var many bytes.Buffer
+	w := multipart.NewWriter(&many)
+	for i := 0; i <= maxMultipartParts; i++ {
+		w.WriteField("f", "v")
+	}
+	w.Close()
+	summary, err = parseMultipartSummary(many.Bytes(), w.Boundary(), 16)
+	if err != nil || len(summary.Parts) != maxMultipartParts || !summary.Truncated {
+		t.Errorf("expected %d parts and truncated, got %d parts, truncated=%v, err=%v", maxMultipartParts, len(summary.Parts), summary.Truncated, err)
+	}
+}
+
+func TestMiddleware_SummarizesMultipart(t *testing.T) {
+	var seen SidebandAccessRequest
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		seen = SidebandAccessRequest{}
+		json.NewDecoder(r.Body).Decode(&seen)
+		json.NewEncoder(w).Encode(SidebandAccessResponse{Method: seen.Method, URL: seen.URL, Body: &seen.Body, Headers: seen.Headers})
+	})
+	defer server.Close()
+
+	m := newTestMiddleware(t, server.URL, false)
+	m.conf.SummarizeMultipartBody = true
+	var upstreamBody string
+	h := m.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		upstreamBody = string(b)
+	}))
+
+	body, contentType := multipartBody(t)
+	req := httptest.NewRequest("POST", "http://api.example.com/upload", strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if seen.Body != "" || seen.MultipartSummary == nil || len(seen.MultipartSummary.Parts) != 3 {
+		t.Errorf("expected a multipart summary instead of the body, got %+v", seen)
+	}
+	if upstreamBody != body {
+		t.Error("upstream should receive the original multipart body")
+	}
+}
@@ -0,0 +1,492 @@
+package pingauthorize
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"golang.org/x/net/http2"
+)
+
+// SidebandHTTPClient wraps an HTTP client with retry and circuit breaker support.
+type SidebandHTTPClient struct {
+	client  *http.Client
+	cb      *circuitBreakers
+	limiter *tokenBucket      // nil unless sideband_rate_limit is set
+	retries *retryBudget      // nil unless retry_budget_percent is set
+	slo     *sloTracker       // nil unless slo_enabled is set
+	tokens  *oauthTokenSource // nil unless sideband_oauth_token_url is set
+	secret  *secretRef        // nil unless shared_secret is set
+	backup  *secretRef        // nil unless shared_secret_secondary is set
+	health  *healthChecker    // nil unless health_check_path is set and the client is shared
+	metrics *PluginMetrics    // nil unless enable_otel is set
+	config  *Config
+}
+
+// NewSidebandHTTPClient creates a new HTTP client configured for sideband communication.
+// The client owns its transport, circuit breaker and token source; use the endpoint registry to
+// share them.
+func NewSidebandHTTPClient(config *Config) *SidebandHTTPClient {
+	return newSidebandHTTPClient(config, newSidebandTransport(config), newCircuitBreakers(config), newOAuthTokenSource(config))
+}
+
+// sidebandTransport is the transport of a sideband client: an *http.Transport, or an
+// *http2.Transport for HTTP/2 over cleartext.
+type sidebandTransport interface {
+	http.RoundTripper
+	CloseIdleConnections()
+}
+
+// newSidebandTransport creates the HTTP transport for the sideband endpoint.
+// With sideband_client_cert set, the client authenticates with mutual TLS. With sideband_http2
+// set, HTTP/2 is negotiated with ALPN for https service URLs and spoken without TLS (h2c, prior
+// knowledge) for http ones; requests are then multiplexed over a single connection per endpoint.
+func newSidebandTransport(config *Config) sidebandTransport {
+	idleTimeout := time.Duration(config.ConnectionKeepaliveMs) * time.Millisecond
+	if config.SidebandHTTP2 && strings.HasPrefix(strings.ToLower(config.ServiceURL), "http:") {
+		return &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			},
+			IdleConnTimeout: idleTimeout,
+		}
+	}
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: !config.VerifyServiceCert,
+	}
+	if config.SidebandClientCert != "" {
+		tlsConfig.GetClientCertificate = newClientCertLoader(config).GetClientCertificate
+	}
+	return &http.Transport{
+		TLSClientConfig:     tlsConfig,
+		IdleConnTimeout:     idleTimeout,
+		MaxIdleConnsPerHost: 10,
+		ForceAttemptHTTP2:   config.SidebandHTTP2,
+	}
+}
+
+// newSidebandHTTPClient wraps an existing transport, circuit breakers and token source with the
+// per-config timeout and retry settings.
+func newSidebandHTTPClient(config *Config, transport sidebandTransport, cb *circuitBreakers, tokens *oauthTokenSource) *SidebandHTTPClient {
+	client := &http.Client{
+		Timeout:   time.Duration(config.ConnectionTimeoutMs) * time.Millisecond,
+		Transport: transport,
+	}
+
+	c := &SidebandHTTPClient{
+		client:  client,
+		cb:      cb,
+		tokens:  tokens,
+		metrics: config.metrics(),
+		config:  config,
+	}
+	if config.SidebandRateLimit > 0 {
+		c.limiter = newTokenBucket(config.SidebandRateLimit, config.SidebandRateBurst)
+	}
+	if config.SLOEnabled {
+		c.slo = newSLOTracker(config)
+	}
+	c.retries = newRetryBudget(config.RetryBudgetPercent)
+	if config.SharedSecret != "" {
+		c.secret = newSecretRef(config.SharedSecret, config.ServiceURL)
+	}
+	if config.SharedSecretSecondary != "" {
+		c.backup = newSecretRef(config.SharedSecretSecondary, config.ServiceURL)
+	}
+	return c
+}
+
+type forwardHeadersKey struct{}
+
+// WithForwardHeaders returns a context carrying client headers to copy onto outbound sideband requests.
+func WithForwardHeaders(ctx context.Context, headers map[string][]string) context.Context {
+	if len(headers) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, forwardHeadersKey{}, headers)
+}
+
+// forwardHeadersFromContext returns the headers stored by WithForwardHeaders, if any.
+func forwardHeadersFromContext(ctx context.Context) map[string][]string {
+	headers, _ := ctx.Value(forwardHeadersKey{}).(map[string][]string)
+	return headers
+}
+
+// Execute sends a POST request to the given path with the provided JSON body.
+// It applies sideband_rate_limit (one token per call; retries and hedges take none), checks the
+// circuit breaker, applies retries, and trips the breaker on final failure.
+// Returns the response status code, headers, body, and any error.
+func (c *SidebandHTTPClient) Execute(ctx context.Context, requestURL string, body []byte, parsedURL *ParsedURL) (int, http.Header, []byte, error) {
+	path := urlPath(requestURL)
+	if c.limiter != nil {
+		maxWait := time.Duration(c.config.SidebandRateLimitQueueMs) * time.Millisecond
+		if err := c.limiter.wait(ctx, maxWait); err != nil {
+			c.metrics.recordSideband(c.config.ServiceURL, path, 0, err, 0)
+			return 0, nil, nil, err
+		}
+	}
+
+	start := time.Now()
+	statusCode, headers, body, err := c.execute(ctx, requestURL, body, parsedURL)
+	elapsed := time.Since(start)
+	c.recordSLO(statusCode, err, elapsed)
+	c.metrics.recordSideband(c.config.ServiceURL, path, statusCode, err, elapsed)
+	if c.config.CircuitBreakerEnabled {
+		c.metrics.recordBreakerState(c.config.ServiceURL, path, !c.cb.get(path).IsClosed())
+	}
+	return statusCode, headers, body, err
+}
+
+// execute performs the call with circuit breaker and retries. Endpoints marked down by active
+// health checks fail immediately.
+func (c *SidebandHTTPClient) execute(ctx context.Context, requestURL string, body []byte, parsedURL *ParsedURL) (int, http.Header, []byte, error) {
+	if err := c.health.check(); err != nil {
+		return 0, nil, nil, err
+	}
+
+	// Check the circuit breaker of this sideband path
+	cb := c.cb.forURL(requestURL)
+	attempts, breaker := 0, breakerClosed
+	defer func() {
+		c.annotateCall(ctx, attempts, breaker)
+		if breaker == breakerTripped {
+			c.config.publishBreakerOpen(urlPath(requestURL), cb)
+		}
+	}()
+	ok, cbErr := cb.Allow()
+	if !ok {
+		breaker = breakerOpen
+		return 0, nil, nil, cbErr
+	}
+
+	var lastErr error
+	var lastStatus int
+	var lastHeaders http.Header
+	var lastBody []byte
+
+	maxAttempts := 1 + c.config.MaxRetries
+	c.retries.record()
+
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if attempt > 0 {
+			if !c.retries.withdraw() {
+				c.recordRetryBudgetExhausted()
+				break
+			}
+			if err := sleepContext(ctx, time.Duration(c.config.RetryBackoffMs)*time.Millisecond); err != nil {
+				lastErr, lastStatus, lastHeaders, lastBody = err, 0, nil, nil
+				break
+			}
+		}
+		// The phase deadline of the caller (access_timeout_ms, response_timeout_ms or
+		// request_budget_ms) may already have passed
+		if err := ctx.Err(); err != nil {
+			lastErr, lastStatus, lastHeaders, lastBody = err, 0, nil, nil
+			break
+		}
+
+		attempts++
+		attemptCtx, span := c.startAttemptSpan(ctx, requestURL, attempt)
+		statusCode, respHeaders, respBody, err := c.doHedgedRequest(attemptCtx, requestURL, body, parsedURL)
+		endAttemptSpan(span, statusCode, err)
+
+		if err != nil {
+			lastErr = err
+			lastStatus = 0
+			lastHeaders = nil
+			lastBody = nil
+			continue // retry on connection errors
+		}
+
+		// HTTP 429 — do NOT retry, trip circuit breaker immediately
+		if statusCode == 429 {
+			// A far-future Retry-After must not lock the gateway out for hours
+			retryAfter := parseRetryAfter(respHeaders, time.Now())
+			if limit := c.maxRetryAfter(); retryAfter > limit {
+				retryAfter = limit
+			}
+			cb.Trip(Trigger429, retryAfter)
+			breaker = breakerTripped
+			return statusCode, respHeaders, respBody, nil
+		}
+
+		// 5xx — retry
+		if statusCode >= 500 {
+			lastErr = fmt.Errorf("sideband returned %d", statusCode)
+			lastStatus = statusCode
+			lastHeaders = respHeaders
+			lastBody = respBody
+			continue
+		}
+
+		// Success or 4xx — no retry
+		cb.RecordSuccess()
+		return statusCode, respHeaders, respBody, nil
+	}
+
+	// All retries exhausted
+	if lastErr != nil {
+		// Trip circuit breaker on connection failure or 5xx
+		if lastStatus >= 500 {
+			cb.RecordFailure(Trigger5xx, defaultRetryAfterSec)
+		} else if lastStatus == 0 && ctx.Err() == nil {
+			// Connection error/timeout. Calls cut short by the caller's own deadline say nothing
+			// about the endpoint, so they are not held against its breaker.
+			cb.RecordFailure(TriggerTimeout, defaultRetryAfterSec)
+		}
+		if !cb.IsClosed() {
+			breaker = breakerTripped
+		}
+	}
+
+	// If we had an HTTP response (5xx), return it
+	if lastStatus > 0 {
+		return lastStatus, lastHeaders, lastBody, lastErr
+	}
+
+	return 0, nil, nil, lastErr
+}
+
+// sleepContext waits for d, or returns the error of ctx if it is done first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// maxSidebandResponseBytes caps the size of a sideband response body read into memory.
+const maxSidebandResponseBytes = 16 << 20
+
+// doRequest performs a single HTTP POST request. With OAuth, a 401 is retried once with a new
+// access token, since the cached one may have been revoked before it expired. During shared
+// secret rotation, a 401 or 403 with shared_secret is retried with shared_secret_secondary.
+func (c *SidebandHTTPClient) doRequest(ctx context.Context, requestURL string, body []byte, parsedURL *ParsedURL) (int, http.Header, []byte, error) {
+	statusCode, headers, respBody, token, err := c.send(ctx, requestURL, body, parsedURL, c.secret)
+	if err == nil && statusCode == http.StatusUnauthorized && token != "" {
+		c.tokens.Invalidate(token)
+		statusCode, headers, respBody, _, err = c.send(ctx, requestURL, body, parsedURL, c.secret)
+	}
+
+	secret := secretPrimary
+	if err == nil && isAuthRejection(statusCode) && c.backup != nil {
+		secret = secretSecondary
+		statusCode, headers, respBody, _, err = c.send(ctx, requestURL, body, parsedURL, c.backup)
+	}
+	if err == nil && c.secret != nil && !isAuthRejection(statusCode) && c.config.EnableOtel {
+		sharedSecretCounter().Add(ctx, 1, metric.WithAttributes(
+			attribute.String("service_url", c.config.ServiceURL), attribute.String("secret", secret)))
+	}
+	return statusCode, headers, respBody, err
+}
+
+// Values of the secret attribute of ping_authorize_shared_secret_total.
+const (
+	secretPrimary   = "primary"
+	secretSecondary = "secondary"
+)
+
+// isAuthRejection reports whether PingAuthorize rejected the sideband credentials.
+func isAuthRejection(statusCode int) bool {
+	return statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden
+}
+
+var (
+	sharedSecretCounterOnce sync.Once
+	sharedSecretTotal       metric.Int64Counter
+)
+
+// sharedSecretCounter returns the counter of sideband calls accepted with each shared secret,
+// created on first use.
+func sharedSecretCounter() metric.Int64Counter {
+	sharedSecretCounterOnce.Do(func() {
+		sharedSecretTotal, _ = otel.Meter(PluginName).Int64Counter("ping_authorize_shared_secret_total",
+			metric.WithDescription("Sideband calls accepted by PingAuthorize, by the shared secret used"))
+	})
+	return sharedSecretTotal
+}
+
+// send performs the POST with the given shared secret (nil for none) and returns the access
+// token it used, if any.
+func (c *SidebandHTTPClient) send(ctx context.Context, requestURL string, body []byte, parsedURL *ParsedURL, secretRef *secretRef) (int, http.Header, []byte, string, error) {
+	var secret, token string
+	if secretRef != nil {
+		var err error
+		if secret, err = secretRef.Value(); err != nil {
+			return 0, nil, nil, "", err
+		}
+	}
+	if c.tokens != nil {
+		var err error
+		if token, err = c.tokens.Token(ctx); err != nil {
+			return 0, nil, nil, "", err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, requestURL, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, nil, "", fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Set headers per the sideband protocol
+	hostHeader := parsedURL.Host
+	if parsedURL.Port > 0 {
+		hostHeader = fmt.Sprintf("%s:%d", parsedURL.Host, parsedURL.Port)
+	}
+
+	// Forwarded client headers go first so the protocol headers below always win
+	for name, values := range forwardHeadersFromContext(ctx) {
+		if reservedSidebandHeaders[strings.ToLower(name)] {
+			continue
+		}
+		for _, v := range values {
+			req.Header.Add(name, v)
+		}
+	}
+
+	req.Host = hostHeader
+	req.Header.Set("Connection", "Keep-Alive")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", fmt.Sprintf("Kong/%s", Version))
+	req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	if secret != "" {
+		req.Header.Set(c.config.SecretHeaderName, secret)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	c.injectTraceContext(ctx, req.Header)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, nil, nil, "", err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxSidebandResponseBytes+1))
+	if err != nil {
+		return 0, nil, nil, "", fmt.Errorf("failed to read response body: %w", err)
+	}
+	if len(respBody) > maxSidebandResponseBytes {
+		return 0, nil, nil, "", fmt.Errorf("response body exceeds %d bytes", maxSidebandResponseBytes)
+	}
+
+	return resp.StatusCode, resp.Header, respBody, token, nil
+}
+
+// parseRetryAfter parses the Retry-After header value, either delay-seconds or an HTTP-date
+// relative to now, as seconds. Dates in the past give 1 second.
+// Returns defaultRetryAfterSec if the header is missing or invalid.
+func parseRetryAfter(headers http.Header, now time.Time) int {
+	val := strings.TrimSpace(headers.Get("Retry-After"))
+	if val == "" {
+		return defaultRetryAfterSec
+	}
+	if secs, err := strconv.Atoi(val); err == nil {
+		if secs <= 0 {
+			return defaultRetryAfterSec
+		}
+		return secs
+	}
+	date, err := http.ParseTime(val)
+	if err != nil {
+		return defaultRetryAfterSec
+	}
+	wait := date.Sub(now)
+	if wait < time.Second {
+		return 1
+	}
+	return int((wait + time.Second - 1) / time.Second)
+}
+
+// maxRetryAfter returns circuit_breaker_max_open_sec, or its default if it is not set.
+func (c *SidebandHTTPClient) maxRetryAfter() int {
+	if c.config.CircuitBreakerMaxOpenSec > 0 {
+		return c.config.CircuitBreakerMaxOpenSec
+	}
+	return defaultCircuitBreakerMaxOpenSec
+}
+
+// ParseURL parses a raw URL string into a ParsedURL struct.
+// Sets default ports (80 for http, 443 for https) and default path "/".
+func ParseURL(rawURL string) (*ParsedURL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL %q: %w", rawURL, err)
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	if scheme != "http" && scheme != "https" {
+		return nil, fmt.Errorf("URL scheme must be http or https, got %q", u.Scheme)
+	}
+
+	host := u.Hostname()
+	if host == "" {
+		return nil, fmt.Errorf("URL must have a host")
+	}
+
+	port := 0
+	if u.Port() != "" {
+		port, err = strconv.Atoi(u.Port())
+		if err != nil {
+			return nil, fmt.Errorf("invalid port in URL: %w", err)
+		}
+	} else {
+		if scheme == "https" {
+			port = 443
+		} else {
+			port = 80
+		}
+	}
+
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+
+	return &ParsedURL{
+		Scheme: scheme,
+		Host:   host,
+		Port:   port,
+		Path:   path,
+		Query:  u.RawQuery,
+	}, nil
+}
+
+// BuildSidebandURL constructs the full URL for a sideband endpoint.
+func BuildSidebandURL(parsedURL *ParsedURL, sidebandPath string) string {
+	// Ensure single / separator between path and sideband endpoint
+	basePath := strings.TrimRight(parsedURL.Path, "/")
+	return fmt.Sprintf("%s://%s:%d%s%s", parsedURL.Scheme, parsedURL.Host, parsedURL.Port, basePath, sidebandPath)
+}
+
+// isTimeout checks if an error is a timeout error.
+func isTimeout(err error) bool {
+	if err == nil {
+		return false
+	}
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
@@ -1,11 +1,16 @@
-package main
+package pingauthorize
 
 import (
 	"context"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 func TestParseURL_Basic(t *testing.T) {
@@ -193,7 +198,7 @@ func TestExecute_RetryExhausted(t *testing.T) {
 	}
 
 	// Circuit breaker should be tripped
-	if client.cb.IsClosed() {
+	if client.cb.get("/sideband/request").IsClosed() {
 		t.Error("expected circuit breaker to be open after exhausting retries")
 	}
 }
@@ -229,7 +234,7 @@ func TestExecute_CircuitBreakerTripsOn429(t *testing.T) {
 	}
 
 	// Circuit breaker should be open
-	if client.cb.IsClosed() {
+	if client.cb.get("/sideband/request").IsClosed() {
 		t.Error("expected circuit breaker to be open after 429")
 	}
 
@@ -280,3 +285,166 @@ func TestExecute_NoRetryOn4xx(t *testing.T) {
 		t.Errorf("expected 1 attempt (no retry on 4xx), got %d", atomic.LoadInt32(&attempts))
 	}
 }
+
+func TestExecute_ForwardHeaders(t *testing.T) {
+	var gotTenant, gotSecret, gotContentType string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotTenant = r.Header.Get("X-Tenant-Id")
+		gotSecret = r.Header.Get("X-Secret")
+		gotContentType = r.Header.Get("Content-Type")
+		w.WriteHeader(200)
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	parsed, _ := ParseURL(server.URL)
+	config := &Config{
+		ServiceURL:            server.URL,
+		SharedSecret:          "secret",
+		SecretHeaderName:      "X-Secret",
+		ConnectionTimeoutMs:   5000,
+		ConnectionKeepaliveMs: 60000,
+		RetryBackoffMs:        10,
+	}
+
+	client := NewSidebandHTTPClient(config)
+
+	ctx := WithForwardHeaders(context.Background(), map[string][]string{
+		"x-tenant-id":  {"acme"},
+		"x-secret":     {"spoofed"},
+		"content-type": {"text/plain"},
+	})
+	if _, _, _, err := client.Execute(ctx, server.URL+"/sideband/request", []byte(`{}`), parsed); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if gotTenant != "acme" {
+		t.Errorf("expected forwarded X-Tenant-Id, got %q", gotTenant)
+	}
+	if gotSecret != "secret" {
+		t.Errorf("forwarded header must not override shared secret, got %q", gotSecret)
+	}
+	if gotContentType != "application/json" {
+		t.Errorf("reserved header must not be forwarded, got %q", gotContentType)
+	}
+}
+
+func TestExecute_HTTP2(t *testing.T) {
+	var mu sync.Mutex
+	protos := map[string]bool{}
+	conns := map[string]bool{}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		protos[r.Proto] = true
+		conns[r.RemoteAddr] = true
+		mu.Unlock()
+		w.Write([]byte(`{}`))
+	})
+
+	tls := httptest.NewUnstartedServer(handler)
+	tls.EnableHTTP2 = true
+	tls.StartTLS()
+	defer tls.Close()
+	cleartext := httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+	defer cleartext.Close()
+
+	tests := []struct {
+		name      string
+		serverURL string
+		http2     bool
+		wantProto string
+	}{
+		{"https default", tls.URL, false, "HTTP/1.1"},
+		{"https http2", tls.URL, true, "HTTP/2.0"},
+		{"h2c", cleartext.URL, true, "HTTP/2.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			protos, conns = map[string]bool{}, map[string]bool{}
+			parsed, _ := ParseURL(tt.serverURL)
+			client := NewSidebandHTTPClient(&Config{
+				ServiceURL:            tt.serverURL,
+				SharedSecret:          "secret",
+				SecretHeaderName:      "X-Secret",
+				ConnectionTimeoutMs:   5000,
+				ConnectionKeepaliveMs: 60000,
+				RetryBackoffMs:        10,
+				SidebandHTTP2:         tt.http2,
+			})
+
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if _, _, _, err := client.Execute(context.Background(), tt.serverURL+"/sideband/request", []byte(`{}`), parsed); err != nil {
+						t.Errorf("unexpected error: %v", err)
+					}
+				}()
+			}
+			wg.Wait()
+
+			if len(protos) != 1 || !protos[tt.wantProto] {
+				t.Errorf("expected %s, got %v", tt.wantProto, protos)
+			}
+			if tt.http2 && len(conns) != 1 {
+				t.Errorf("expected concurrent calls to share one connection, got %d", len(conns))
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  int
+	}{
+		{"", defaultRetryAfterSec},
+		{"5", 5},
+		{"0", defaultRetryAfterSec},
+		{"-3", defaultRetryAfterSec},
+		{"soon", defaultRetryAfterSec},
+		{"Sun, 01 Mar 2026 12:01:30 GMT", 90},
+		{"Sunday, 01-Mar-26 12:00:10 GMT", 10},
+		{"Sun Mar  1 12:00:05 2026", 5},
+		{"Sun, 01 Mar 2026 11:00:00 GMT", 1},
+	}
+	for _, tt := range tests {
+		headers := http.Header{}
+		if tt.value != "" {
+			headers.Set("Retry-After", tt.value)
+		}
+		if got := parseRetryAfter(headers, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestExecute_RetryAfterClamped(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", time.Now().Add(24*time.Hour).UTC().Format(http.TimeFormat))
+		w.WriteHeader(429)
+	}))
+	defer server.Close()
+
+	parsed, _ := ParseURL(server.URL)
+	client := NewSidebandHTTPClient(&Config{
+		ServiceURL:               server.URL,
+		SharedSecret:             "secret",
+		SecretHeaderName:         "X-Secret",
+		ConnectionTimeoutMs:      5000,
+		ConnectionKeepaliveMs:    60000,
+		CircuitBreakerEnabled:    true,
+		CircuitBreakerMaxOpenSec: 120,
+		RetryBackoffMs:           10,
+	})
+
+	client.Execute(context.Background(), server.URL+"/sideband/request", []byte(`{}`), parsed)
+	_, _, _, err := client.Execute(context.Background(), server.URL+"/sideband/request", []byte(`{}`), parsed)
+	cbErr, ok := err.(*CircuitBreakerOpenError)
+	if !ok || cbErr.RetryAfterSec != 120 {
+		t.Errorf("expected the breaker to open for 120s, got %v", err)
+	}
+}
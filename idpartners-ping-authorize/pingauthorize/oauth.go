@@ -0,0 +1,173 @@
+package pingauthorize
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultOAuthTokenLifetime is assumed when the token response has no expires_in.
+	defaultOAuthTokenLifetime = 5 * time.Minute
+	// maxOAuthRefreshSkew bounds how long before expiry a token is refreshed.
+	maxOAuthRefreshSkew = 30 * time.Second
+	// maxOAuthTokenResponseBytes caps the size of a token endpoint response.
+	maxOAuthTokenResponseBytes = 1 << 20
+)
+
+// oauthTokenSource obtains sideband access tokens with the OAuth2 client credentials grant and
+// caches them until shortly before they expire. Concurrent callers share a single token request.
+type oauthTokenSource struct {
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	scopes       []string
+	client       *http.Client
+	now          func() time.Time
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// newOAuthTokenSource creates a token source for the sideband_oauth_* settings of config, or
+// returns nil if sideband_oauth_token_url is not set.
+func newOAuthTokenSource(config *Config) *oauthTokenSource {
+	if config.SidebandOAuthTokenURL == "" {
+		return nil
+	}
+	return &oauthTokenSource{
+		tokenURL:     config.SidebandOAuthTokenURL,
+		clientID:     config.SidebandOAuthClientID,
+		clientSecret: config.SidebandOAuthClientSecret,
+		scopes:       config.SidebandOAuthScopes,
+		client: &http.Client{
+			Timeout: time.Duration(config.ConnectionTimeoutMs) * time.Millisecond,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: !config.VerifyServiceCert},
+			},
+		},
+		now: time.Now,
+	}
+}
+
+// Token returns a cached access token, requesting a new one if there is none or it is about
+// to expire.
+func (s *oauthTokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" && s.now().Before(s.expires) {
+		return s.token, nil
+	}
+	token, lifetime, err := s.fetch(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to obtain sideband access token: %w", err)
+	}
+
+	skew := lifetime / 10
+	if skew > maxOAuthRefreshSkew {
+		skew = maxOAuthRefreshSkew
+	}
+	s.token, s.expires = token, s.now().Add(lifetime-skew)
+	return token, nil
+}
+
+// Invalidate drops token from the cache so the next call requests a new one. Tokens other than
+// the cached one are ignored, so a stale rejection cannot discard a token that was just refreshed.
+func (s *oauthTokenSource) Invalidate(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token == token {
+		s.token = ""
+	}
+}
+
+// fetch requests a token from the token endpoint, authenticating with HTTP Basic
+// (client_secret_basic).
+func (s *oauthTokenSource) fetch(ctx context.Context) (string, time.Duration, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(s.scopes) > 0 {
+		form.Set("scope", strings.Join(s.scopes, " "))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", fmt.Sprintf("Kong/%s", Version))
+	req.SetBasicAuth(url.QueryEscape(s.clientID), url.QueryEscape(s.clientSecret))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxOAuthTokenResponseBytes))
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to read token response: %w", err)
+	}
+
+	var result struct {
+		AccessToken      string `json:"access_token"`
+		TokenType        string `json:"token_type"`
+		ExpiresIn        int64  `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil && resp.StatusCode == http.StatusOK {
+		return "", 0, fmt.Errorf("failed to parse token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		if result.Error != "" {
+			return "", 0, fmt.Errorf("token endpoint returned %d: %s %s", resp.StatusCode, result.Error, result.ErrorDescription)
+		}
+		return "", 0, fmt.Errorf("token endpoint returned %d", resp.StatusCode)
+	}
+	if result.AccessToken == "" {
+		return "", 0, fmt.Errorf("token response has no access_token")
+	}
+	if result.TokenType != "" && !strings.EqualFold(result.TokenType, "bearer") {
+		return "", 0, fmt.Errorf("unsupported token_type %q", result.TokenType)
+	}
+
+	lifetime := time.Duration(result.ExpiresIn) * time.Second
+	if lifetime <= 0 {
+		lifetime = defaultOAuthTokenLifetime
+	}
+	return result.AccessToken, lifetime, nil
+}
+
+// validateSidebandOAuth checks the sideband_oauth_* settings.
+func validateSidebandOAuth(c *Config) error {
+	if c.SidebandOAuthTokenURL == "" {
+		if c.SidebandOAuthClientID != "" || c.SidebandOAuthClientSecret != "" || len(c.SidebandOAuthScopes) > 0 {
+			return fmt.Errorf("sideband_oauth_token_url is required when other sideband_oauth_* fields are set")
+		}
+		return nil
+	}
+	if err := validateServiceURL("sideband_oauth_token_url", c.SidebandOAuthTokenURL); err != nil {
+		return err
+	}
+	if c.SidebandOAuthClientID == "" || c.SidebandOAuthClientSecret == "" {
+		return fmt.Errorf("sideband_oauth_client_id and sideband_oauth_client_secret are required with sideband_oauth_token_url")
+	}
+	if strings.EqualFold(c.SecretHeaderName, "Authorization") && c.SharedSecret != "" {
+		return fmt.Errorf("secret_header_name must not be Authorization when sideband_oauth_token_url is set")
+	}
+	for _, scope := range c.SidebandOAuthScopes {
+		if scope == "" || strings.ContainsAny(scope, " \"\\") {
+			return fmt.Errorf("sideband_oauth_scopes entries must be non-empty and must not contain spaces, quotes or backslashes, got %q", scope)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package pingauthorize
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// tokenServer is a client credentials token endpoint issuing token-1, token-2, ...
+type tokenServer struct {
+	*httptest.Server
+	expiresIn int
+
+	mu       sync.Mutex
+	issued   int
+	lastForm map[string]string
+}
+
+func newTokenServer(t *testing.T, expiresIn int) *tokenServer {
+	t.Helper()
+	s := &tokenServer{expiresIn: expiresIn}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		r.ParseForm()
+		if id != "kong" || secret != "s3cret" || r.PostForm.Get("grant_type") != "client_credentials" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"invalid_client","error_description":"bad credentials"}`))
+			return
+		}
+		s.mu.Lock()
+		s.issued++
+		s.lastForm = map[string]string{"scope": r.PostForm.Get("scope")}
+		token := fmt.Sprint("token-", s.issued)
+		s.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": token, "token_type": "Bearer", "expires_in": s.expiresIn})
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *tokenServer) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.issued
+}
+
+func oauthTestConfig(tokenURL string) *Config {
+	conf := NewConfig()
+	conf.SidebandOAuthTokenURL = tokenURL
+	conf.SidebandOAuthClientID = "kong"
+	conf.SidebandOAuthClientSecret = "s3cret"
+	conf.SidebandOAuthScopes = []string{"paz.decide", "paz.read"}
+	return conf
+}
+
+func TestOAuthTokenSource_CachesAndRefreshes(t *testing.T) {
+	ts := newTokenServer(t, 100)
+	s := newOAuthTokenSource(oauthTestConfig(ts.URL))
+	now := time.Unix(1000, 0)
+	s.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if token, err := s.Token(ctx); err != nil || token != "token-1" {
+			t.Fatalf("Token() = %q, %v", token, err)
+		}
+	}
+	if ts.count() != 1 || ts.lastForm["scope"] != "paz.decide paz.read" {
+		t.Errorf("expected one token request with scopes, got %d %v", ts.count(), ts.lastForm)
+	}
+
+	// Refreshed 10% of the lifetime before expiry
+	now = now.Add(91 * time.Second)
+	if token, _ := s.Token(ctx); token != "token-2" {
+		t.Errorf("expected a refreshed token, got %q", token)
+	}
+
+	s.Invalidate("token-1")
+	if token, _ := s.Token(ctx); token != "token-2" {
+		t.Errorf("a stale token should not invalidate the current one, got %q", token)
+	}
+	s.Invalidate("token-2")
+	if token, _ := s.Token(ctx); token != "token-3" {
+		t.Errorf("expected a new token after invalidation, got %q", token)
+	}
+}
+
+func TestOAuthTokenSource_Errors(t *testing.T) {
+	ts := newTokenServer(t, 100)
+	conf := oauthTestConfig(ts.URL)
+	conf.SidebandOAuthClientSecret = "wrong"
+	_, err := newOAuthTokenSource(conf).Token(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "invalid_client") {
+		t.Errorf("expected token endpoint error, got %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		body    string
+		wantErr string
+	}{
+		{"no token", `{"token_type":"Bearer"}`, "no access_token"},
+		{"wrong type", `{"access_token":"a","token_type":"mac"}`, "unsupported token_type"},
+		{"not JSON", `<html>`, "failed to parse"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+			_, err := newOAuthTokenSource(oauthTestConfig(server.URL)).Token(context.Background())
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExecute_OAuthBearerToken(t *testing.T) {
+	ts := newTokenServer(t, 3600)
+	var mu sync.Mutex
+	var seen []string
+	revoked := "token-1"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		auth := r.Header.Get("Authorization")
+		seen = append(seen, auth)
+		if auth == "Bearer "+revoked || r.Header.Get("X-Secret") != "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	conf := oauthTestConfig(ts.URL)
+	conf.ServiceURL = server.URL
+	conf.SecretHeaderName = "X-Secret"
+	client := NewSidebandHTTPClient(conf)
+	parsed, _ := ParseURL(server.URL)
+
+	for i := 0; i < 2; i++ {
+		status, _, _, err := client.Execute(context.Background(), server.URL+"/sideband/request", []byte(`{}`), parsed)
+		if err != nil || status != 200 {
+			t.Fatalf("unexpected result: %d %v", status, err)
+		}
+	}
+	want := []string{"Bearer token-1", "Bearer token-2", "Bearer token-2"}
+	if strings.Join(seen, ",") != strings.Join(want, ",") {
+		t.Errorf("expected a rejected token to be replaced once, got %v", seen)
+	}
+	if ts.count() != 2 {
+		t.Errorf("expected 2 token requests, got %d", ts.count())
+	}
+}
+
+func TestValidateSidebandOAuth(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(c *Config)
+		wantErr string
+	}{
+		{"oauth without shared secret", func(c *Config) { c.SharedSecret, c.SecretHeaderName = "", "" }, ""},
+		{"oauth and shared secret", func(c *Config) {}, ""},
+		{"no credentials", func(c *Config) {
+			c.SharedSecret, c.SidebandOAuthTokenURL, c.SidebandOAuthClientID, c.SidebandOAuthClientSecret, c.SidebandOAuthScopes = "", "", "", "", nil
+		}, "shared_secret is required"},
+		{"client id without token url", func(c *Config) { c.SidebandOAuthTokenURL = "" }, "sideband_oauth_token_url is required"},
+		{"invalid token url", func(c *Config) { c.SidebandOAuthTokenURL = "ftp://idp" }, "sideband_oauth_token_url scheme"},
+		{"missing client secret", func(c *Config) { c.SidebandOAuthClientSecret = "" }, "sideband_oauth_client_secret"},
+		{"secret in authorization header", func(c *Config) { c.SecretHeaderName = "authorization" }, "secret_header_name must not be Authorization"},
+		{"scope with space", func(c *Config) { c.SidebandOAuthScopes = []string{"a b"} }, "sideband_oauth_scopes"},
+		{"forwarded authorization", func(c *Config) { c.ForwardHeaders = []string{"Authorization"} }, "reserved header"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := oauthTestConfig("https://idp.example.com/token")
+			conf.ServiceURL = "https://paz.example.com"
+			conf.SharedSecret = "secret"
+			conf.SecretHeaderName = "X-Secret"
+			tt.modify(conf)
+			err := conf.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,428 @@
+package pingauthorize
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Kong/go-pdk"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+
+	"github.com/idpartners/idpartners-ping-authorize/pingauthorize/decisionctx"
+)
+
+// PluginLogger wraps Kong PDK log with structured fields.
+// A logger created with a nil PDK (e.g. by the net/http middleware) writes to the standard log package.
+type PluginLogger struct {
+	kong       *pdk.PDK
+	phase      string
+	serviceURL string
+	// skipPayloads is set when the request is not sampled for payload debug logging.
+	skipPayloads bool
+}
+
+// NewPluginLogger creates a logger with standard plugin context fields.
+func NewPluginLogger(kong *pdk.PDK, phase, serviceURL string) *PluginLogger {
+	return &PluginLogger{
+		kong:       kong,
+		phase:      phase,
+		serviceURL: serviceURL,
+	}
+}
+
+func (l *PluginLogger) formatMsg(level, msg string, kvs ...interface{}) string {
+	entry := map[string]interface{}{
+		"plugin":      PluginName,
+		"phase":       l.phase,
+		"service_url": l.serviceURL,
+		"level":       level,
+		"msg":         msg,
+	}
+	for i := 0; i+1 < len(kvs); i += 2 {
+		key, ok := kvs[i].(string)
+		if ok {
+			entry[key] = kvs[i+1]
+		}
+	}
+	b, _ := json.Marshal(entry)
+	return string(b)
+}
+
+// Debug logs at debug level.
+func (l *PluginLogger) Debug(msg string, kvs ...interface{}) {
+	if l.kong == nil {
+		log.Print(l.formatMsg("debug", msg, kvs...))
+		return
+	}
+	l.kong.Log.Debug(l.formatMsg("debug", msg, kvs...))
+}
+
+// Info logs at info level.
+func (l *PluginLogger) Info(msg string, kvs ...interface{}) {
+	if l.kong == nil {
+		log.Print(l.formatMsg("info", msg, kvs...))
+		return
+	}
+	l.kong.Log.Info(l.formatMsg("info", msg, kvs...))
+}
+
+// Warn logs at warn level.
+func (l *PluginLogger) Warn(msg string, kvs ...interface{}) {
+	if l.kong == nil {
+		log.Print(l.formatMsg("warn", msg, kvs...))
+		return
+	}
+	l.kong.Log.Warn(l.formatMsg("warn", msg, kvs...))
+}
+
+// Err logs at error level.
+func (l *PluginLogger) Err(msg string, kvs ...interface{}) {
+	if l.kong == nil {
+		log.Print(l.formatMsg("error", msg, kvs...))
+		return
+	}
+	l.kong.Log.Err(l.formatMsg("error", msg, kvs...))
+}
+
+// PluginMetrics holds pre-created OTel instruments.
+type PluginMetrics struct {
+	SidebandDuration metric.Float64Histogram
+	SidebandTotal    metric.Int64Counter
+	CircuitBreakerSt metric.Int64Gauge
+	PolicyDecisions  metric.Int64Counter
+	MCPRequests      metric.Int64Counter
+	MCPToolCalls     metric.Int64Counter
+	MCPDenied        metric.Int64Counter
+	Tokens           metric.Int64Counter
+
+	// mcpMethods and mcpTools bound the method and tool label values of the MCP counters.
+	mcpMethods *labelValues
+	mcpTools   *labelValues
+}
+
+// defaultMCPMetricsMaxLabelValues is the default number of distinct MCP methods, and of tools,
+// labelled in the MCP counters.
+const defaultMCPMetricsMaxLabelValues = 100
+
+// otherLabelValue replaces label values past the mcp_metrics_max_label_values limit.
+const otherLabelValue = "_other"
+
+// labelValues tracks the distinct values of a metric label, which come from client requests.
+type labelValues struct {
+	mu   sync.Mutex
+	seen map[string]struct{}
+}
+
+// value returns v while fewer than max values have been seen, or v was seen before, and
+// otherLabelValue otherwise.
+func (l *labelValues) value(v string, max int) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.seen[v]; ok {
+		return v
+	}
+	if len(l.seen) >= max {
+		return otherLabelValue
+	}
+	l.seen[v] = struct{}{}
+	return v
+}
+
+var (
+	pluginMetricsOnce sync.Once
+	defaultMetrics    *PluginMetrics
+)
+
+// pluginMetrics returns the request path instruments, created on first use from the global meter
+// provider so that they follow the provider installed by InitOTel.
+func pluginMetrics() *PluginMetrics {
+	pluginMetricsOnce.Do(func() {
+		defaultMetrics = newPluginMetrics(otel.Meter(PluginName))
+	})
+	return defaultMetrics
+}
+
+// newPluginMetrics creates the instruments of meter.
+func newPluginMetrics(meter metric.Meter) *PluginMetrics {
+	sidebandDuration, _ := meter.Float64Histogram("ping_authorize_sideband_duration_ms",
+		metric.WithDescription("Sideband call latency in milliseconds"))
+	sidebandTotal, _ := meter.Int64Counter("ping_authorize_sideband_total",
+		metric.WithDescription("Total sideband calls"))
+	cbState, _ := meter.Int64Gauge("ping_authorize_circuit_breaker_state",
+		metric.WithDescription("Circuit breaker state: 0=closed, 1=open"))
+	policyDecisions, _ := meter.Int64Counter("ping_authorize_policy_decisions_total",
+		metric.WithDescription("Policy decision counts"))
+	mcpRequests, _ := meter.Int64Counter("ping_authorize_mcp_requests_total",
+		metric.WithDescription("MCP requests evaluated in the access phase, by JSON-RPC method and decision"))
+	mcpToolCalls, _ := meter.Int64Counter("ping_authorize_mcp_tool_calls_total",
+		metric.WithDescription("MCP tools/call requests evaluated in the access phase, by tool and decision"))
+	mcpDenied, _ := meter.Int64Counter("ping_authorize_mcp_denied_total",
+		metric.WithDescription("MCP requests denied, by JSON-RPC method and reason"))
+	tokens, _ := meter.Int64Counter("ping_authorize_tokens_total",
+		metric.WithDescription("Approximate tokens in LLM and MCP request and response bodies, by phase and traffic type"))
+
+	return &PluginMetrics{
+		SidebandDuration: sidebandDuration,
+		SidebandTotal:    sidebandTotal,
+		CircuitBreakerSt: cbState,
+		PolicyDecisions:  policyDecisions,
+		MCPRequests:      mcpRequests,
+		MCPToolCalls:     mcpToolCalls,
+		MCPDenied:        mcpDenied,
+		Tokens:           tokens,
+		mcpMethods:       &labelValues{seen: make(map[string]struct{})},
+		mcpTools:         &labelValues{seen: make(map[string]struct{})},
+	}
+}
+
+// recordSideband records the duration and result of a sideband call. Safe on a nil receiver.
+func (m *PluginMetrics) recordSideband(serviceURL, path string, status int, err error, d time.Duration) {
+	if m == nil {
+		return
+	}
+	attrs := metric.WithAttributes(attribute.String("service_url", serviceURL), attribute.String("path", path),
+		attribute.String("result", sidebandResult(status, err)))
+	m.SidebandDuration.Record(context.Background(), float64(d)/float64(time.Millisecond), attrs)
+	m.SidebandTotal.Add(context.Background(), 1, attrs)
+}
+
+// sidebandResult classifies the outcome of a sideband call for metrics.
+func sidebandResult(status int, err error) string {
+	switch err.(type) {
+	case nil:
+	case *CircuitBreakerOpenError:
+		return "circuit_open"
+	case *SidebandThrottledError:
+		return "throttled"
+	case *SidebandEndpointDownError:
+		return "endpoint_down"
+	default:
+		if status == 0 {
+			return "error"
+		}
+	}
+	switch {
+	case status == http.StatusTooManyRequests:
+		return "rate_limited"
+	case status >= 500:
+		return "server_error"
+	default:
+		return "success"
+	}
+}
+
+// recordBreakerState records whether the circuit breaker of a sideband path is open. Safe on a nil
+// receiver.
+func (m *PluginMetrics) recordBreakerState(serviceURL, path string, open bool) {
+	if m == nil {
+		return
+	}
+	var state int64
+	if open {
+		state = 1
+	}
+	m.CircuitBreakerSt.Record(context.Background(), state, metric.WithAttributes(
+		attribute.String("service_url", serviceURL), attribute.String("path", path)))
+}
+
+// recordDecision counts the decision of an access or response phase, and of the MCP request:
+// every MCP request and tool call in the access phase, and the MCP requests denied in either
+// phase. Requests the policy denied have the reason "policy", or "response_policy" in the response
+// phase. At most maxLabelValues methods, and tools, are labelled; the rest are counted as "_other".
+// Phases that ended before reaching a decision are not counted. Safe on a nil receiver.
+func (m *PluginMetrics) recordDecision(serviceURL, phaseName string, phase *decisionctx.Phase, mcp *decisionctx.MCP, maxLabelValues int) {
+	if m == nil || phase == nil || phase.Decision == "" {
+		return
+	}
+	m.PolicyDecisions.Add(context.Background(), 1, metric.WithAttributes(attribute.String("service_url", serviceURL),
+		attribute.String("phase", phaseName), attribute.String("decision", phase.Decision),
+		attribute.Bool("cached", phase.Cached), attribute.Bool("fail_open", phase.FailOpen),
+		attribute.Bool("shadow", phase.Shadow)))
+	if mcp == nil {
+		return
+	}
+	method := m.mcpMethods.value(mcp.Method, maxLabelValues)
+	if phaseName == "access" {
+		m.MCPRequests.Add(context.Background(), 1, metric.WithAttributes(attribute.String("service_url", serviceURL),
+			attribute.String("method", method), attribute.String("decision", phase.Decision)))
+		if mcp.Method == "tools/call" && mcp.Tool != "" {
+			m.MCPToolCalls.Add(context.Background(), 1, metric.WithAttributes(attribute.String("service_url", serviceURL),
+				attribute.String("tool", m.mcpTools.value(mcp.Tool, maxLabelValues)), attribute.String("decision", phase.Decision)))
+		}
+	}
+	if phase.Decision == decisionctx.DecisionDeny {
+		reason := phase.Reason
+		if reason == "" {
+			reason = "policy"
+			if phaseName == "response" {
+				reason = "response_policy"
+			}
+		}
+		m.MCPDenied.Add(context.Background(), 1, metric.WithAttributes(attribute.String("service_url", serviceURL),
+			attribute.String("method", method), attribute.String("reason", reason)))
+	}
+}
+
+// metrics returns the request path instruments when enable_otel is set, and nil otherwise.
+func (c *Config) metrics() *PluginMetrics {
+	if !c.EnableOtel {
+		return nil
+	}
+	return pluginMetrics()
+}
+
+// InitOTel initializes OpenTelemetry trace and metric providers. Metrics are exported over OTLP
+// and to any additional readers, such as the reader of a PrometheusExporter.
+func InitOTel(ctx context.Context, readers ...sdkmetric.Reader) (func(context.Context) error, *PluginMetrics, error) {
+	res, err := resource.New(ctx, resource.WithAttributes(pluginResourceAttributes()...))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create OTel resource: %w", err)
+	}
+
+	// Trace exporter
+	traceExporter, err := otlptracegrpc.New(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create trace exporter: %w", err)
+	}
+
+	tracerProvider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(traceExporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tracerProvider)
+
+	// Metric exporter
+	metricExporter, err := otlpmetricgrpc.New(ctx)
+	if err != nil {
+		tracerProvider.Shutdown(ctx)
+		return nil, nil, fmt.Errorf("failed to create metric exporter: %w", err)
+	}
+
+	meterProvider := newMeterProvider(res, append([]sdkmetric.Reader{sdkmetric.NewPeriodicReader(metricExporter)}, readers...)...)
+	otel.SetMeterProvider(meterProvider)
+
+	// The request path records to the instruments of the global provider
+	metrics := pluginMetrics()
+
+	shutdown := func(ctx context.Context) error {
+		var errs []error
+		if err := tracerProvider.Shutdown(ctx); err != nil {
+			errs = append(errs, err)
+		}
+		if err := meterProvider.Shutdown(ctx); err != nil {
+			errs = append(errs, err)
+		}
+		if len(errs) > 0 {
+			return errs[0]
+		}
+		return nil
+	}
+
+	return shutdown, metrics, nil
+}
+
+// pluginResourceAttributes identifies the plugin in exported telemetry.
+func pluginResourceAttributes() []attribute.KeyValue {
+	return []attribute.KeyValue{
+		semconv.ServiceNameKey.String(PluginName),
+		semconv.ServiceVersionKey.String(Version),
+	}
+}
+
+// newMeterProvider creates a meter provider exporting to readers.
+func newMeterProvider(res *resource.Resource, readers ...sdkmetric.Reader) *sdkmetric.MeterProvider {
+	opts := []sdkmetric.Option{sdkmetric.WithResource(res)}
+	for _, reader := range readers {
+		opts = append(opts, sdkmetric.WithReader(reader))
+	}
+	return sdkmetric.NewMeterProvider(opts...)
+}
+
+// RedactHeaders replaces values of sensitive headers with [REDACTED].
+// The secretHeaderName is always redacted regardless of the redact set.
+func RedactHeaders(headers []map[string]string, redactSet map[string]bool, secretHeaderName string) []map[string]string {
+	if len(headers) == 0 {
+		return headers
+	}
+
+	result := make([]map[string]string, len(headers))
+	secretLower := strings.ToLower(secretHeaderName)
+
+	for i, entry := range headers {
+		newEntry := make(map[string]string)
+		for name, value := range entry {
+			lower := strings.ToLower(name)
+			if redactSet[lower] || lower == secretLower {
+				newEntry[name] = "[REDACTED]"
+			} else {
+				newEntry[name] = value
+			}
+		}
+		result[i] = newEntry
+	}
+	return result
+}
+
+// TruncateBody truncates a body string if it exceeds maxBytes.
+// If maxBytes is 0, no truncation is performed.
+func TruncateBody(body string, maxBytes int) string {
+	if maxBytes <= 0 || len(body) <= maxBytes {
+		return body
+	}
+	return body[:maxBytes] + fmt.Sprintf("... [truncated, %d bytes]", len(body))
+}
+
+// debugSkipKey marks the request context of the net/http middleware when the payloads of the
+// request are not debug logged.
+type debugSkipKey struct{}
+
+// sampleDebugPayloads decides whether the sideband payloads of a request are debug logged: always
+// for requests carrying debug_trigger_header, otherwise with probability debug_sample_rate. When
+// they are not, it marks logger to leave them out and returns false.
+func sampleDebugPayloads(conf *Config, logger *PluginLogger, header func(string) string) bool {
+	if !conf.EnableDebugLogging || conf.DebugSampleRate >= 1 {
+		return true
+	}
+	if conf.DebugTriggerHeader != "" && header(conf.DebugTriggerHeader) != "" {
+		return true
+	}
+	if sampleRoll() < conf.DebugSampleRate*100 {
+		return true
+	}
+	logger.skipPayloads = true
+	return false
+}
+
+// debugSkipped reports whether the middleware request of ctx was left out by sampleDebugPayloads.
+func debugSkipped(ctx context.Context) bool {
+	return ctx.Value(debugSkipKey{}) != nil
+}
+
+// DebugLogPayload logs a sideband payload with redaction and truncation.
+func DebugLogPayload(logger *PluginLogger, direction string, payload interface{}, config *Config) {
+	if !config.EnableDebugLogging || logger.skipPayloads {
+		return
+	}
+
+	b, err := json.Marshal(payload)
+	if err != nil {
+		logger.Debug("Failed to marshal payload for debug logging", "error", err.Error())
+		return
+	}
+
+	body := TruncateBody(string(b), config.DebugBodyMaxBytes)
+	logger.Debug(direction, "payload", body)
+}
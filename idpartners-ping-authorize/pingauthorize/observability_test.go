@@ -0,0 +1,361 @@
+package pingauthorize
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+
+	"github.com/idpartners/idpartners-ping-authorize/pingauthorize/decisionctx"
+)
+
+func TestRedactHeaders_Basic(t *testing.T) {
+	headers := []map[string]string{
+		{"authorization": "Bearer token123"},
+		{"cookie": "session=abc"},
+		{"content-type": "application/json"},
+	}
+
+	redactSet := map[string]bool{
+		"authorization": true,
+		"cookie":        true,
+	}
+
+	result := RedactHeaders(headers, redactSet, "x-secret")
+
+	for _, entry := range result {
+		if v, ok := entry["authorization"]; ok && v != "[REDACTED]" {
+			t.Errorf("expected authorization to be redacted, got %q", v)
+		}
+		if v, ok := entry["cookie"]; ok && v != "[REDACTED]" {
+			t.Errorf("expected cookie to be redacted, got %q", v)
+		}
+		if v, ok := entry["content-type"]; ok && v != "application/json" {
+			t.Errorf("expected content-type to be preserved, got %q", v)
+		}
+	}
+}
+
+func TestRedactHeaders_SecretHeaderAlwaysRedacted(t *testing.T) {
+	headers := []map[string]string{
+		{"x-ping-secret": "my-secret-value"},
+		{"content-type": "application/json"},
+	}
+
+	// Secret header name is not in the redact set, but should still be redacted
+	redactSet := map[string]bool{}
+
+	result := RedactHeaders(headers, redactSet, "X-Ping-Secret")
+
+	for _, entry := range result {
+		if v, ok := entry["x-ping-secret"]; ok && v != "[REDACTED]" {
+			t.Errorf("expected secret header to be redacted, got %q", v)
+		}
+	}
+}
+
+func TestRedactHeaders_CaseInsensitive(t *testing.T) {
+	headers := []map[string]string{
+		{"Authorization": "Bearer token"},
+	}
+
+	redactSet := map[string]bool{
+		"authorization": true,
+	}
+
+	result := RedactHeaders(headers, redactSet, "")
+
+	for _, entry := range result {
+		if v, ok := entry["Authorization"]; ok && v != "[REDACTED]" {
+			t.Errorf("expected case-insensitive redaction, got %q", v)
+		}
+	}
+}
+
+func TestRedactHeaders_Empty(t *testing.T) {
+	result := RedactHeaders(nil, map[string]bool{}, "")
+	if result != nil {
+		t.Errorf("expected nil for nil input, got %v", result)
+	}
+}
+
+func TestTruncateBody_NoTruncation(t *testing.T) {
+	body := "short body"
+	result := TruncateBody(body, 100)
+	if result != body {
+		t.Errorf("expected no truncation, got %q", result)
+	}
+}
+
+func TestTruncateBody_Truncated(t *testing.T) {
+	body := "this is a long body that should be truncated"
+	result := TruncateBody(body, 10)
+	if !strings.HasPrefix(result, "this is a ") {
+		t.Errorf("unexpected truncation prefix: %q", result)
+	}
+	if !strings.Contains(result, "[truncated,") {
+		t.Errorf("expected truncation marker, got %q", result)
+	}
+}
+
+func TestTruncateBody_ZeroDisablesTruncation(t *testing.T) {
+	body := "this body should not be truncated regardless of length"
+	result := TruncateBody(body, 0)
+	if result != body {
+		t.Errorf("expected zero to disable truncation, got %q", result)
+	}
+}
+
+func TestTruncateBody_ExactBoundary(t *testing.T) {
+	body := "1234567890"
+	result := TruncateBody(body, 10)
+	if result != body {
+		t.Errorf("expected no truncation at exact boundary, got %q", result)
+	}
+}
+
+func TestTruncateBody_OneByteTooLong(t *testing.T) {
+	body := "12345678901"
+	result := TruncateBody(body, 10)
+	if !strings.HasPrefix(result, "1234567890") {
+		t.Errorf("unexpected prefix: %q", result)
+	}
+	if !strings.Contains(result, "[truncated,") {
+		t.Errorf("expected truncation marker: %q", result)
+	}
+}
+
+// collectSums returns the counter totals of reader by metric name and attribute set.
+func collectSums(t *testing.T, reader *sdkmetric.ManualReader) map[string]int64 {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	sums := make(map[string]int64)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			switch data := m.Data.(type) {
+			case metricdata.Sum[int64]:
+				for _, dp := range data.DataPoints {
+					sums[m.Name+" "+dp.Attributes.Encoded(attribute.DefaultEncoder())] += dp.Value
+				}
+			case metricdata.Gauge[int64]:
+				for _, dp := range data.DataPoints {
+					sums[m.Name+" "+dp.Attributes.Encoded(attribute.DefaultEncoder())] = dp.Value
+				}
+			case metricdata.Histogram[float64]:
+				for _, dp := range data.DataPoints {
+					sums[m.Name+" "+dp.Attributes.Encoded(attribute.DefaultEncoder())] += int64(dp.Count)
+				}
+			}
+		}
+	}
+	return sums
+}
+
+func TestPluginMetrics_SidebandCalls(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/sideband/response" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	reader := sdkmetric.NewManualReader()
+	conf := NewConfig()
+	conf.ServiceURL = server.URL
+	conf.SharedSecret = "secret"
+	conf.SecretHeaderName = "X-Secret"
+	client := NewSidebandHTTPClient(conf)
+	client.metrics = newPluginMetrics(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter(PluginName))
+	parsed, _ := ParseURL(server.URL)
+
+	for _, path := range []string{"/sideband/request", "/sideband/request", "/sideband/response", "/sideband/response"} {
+		client.Execute(context.Background(), server.URL+path, []byte(`{}`), parsed)
+	}
+
+	sums := collectSums(t, reader)
+	for key, want := range map[string]int64{
+		"ping_authorize_sideband_total path=/sideband/request,result=success,service_url=" + server.URL:       2,
+		"ping_authorize_sideband_total path=/sideband/response,result=server_error,service_url=" + server.URL: 1,
+		"ping_authorize_sideband_total path=/sideband/response,result=circuit_open,service_url=" + server.URL: 1,
+		"ping_authorize_sideband_duration_ms path=/sideband/request,result=success,service_url=" + server.URL: 2,
+		"ping_authorize_circuit_breaker_state path=/sideband/request,service_url=" + server.URL:               0,
+		"ping_authorize_circuit_breaker_state path=/sideband/response,service_url=" + server.URL:              1,
+	} {
+		if got, ok := sums[key]; !ok || got != want {
+			t.Errorf("%s = %d (recorded %v), want %d", key, got, ok, want)
+		}
+	}
+}
+
+func TestPluginMetrics_RecordDecision(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	m := newPluginMetrics(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter(PluginName))
+
+	m.recordDecision("https://paz", "access", &decisionctx.Phase{Decision: decisionctx.DecisionAllow}, &decisionctx.MCP{Method: "tools/call"}, 100)
+	m.recordDecision("https://paz", "access", &decisionctx.Phase{Decision: decisionctx.DecisionDeny}, nil, 100)
+	m.recordDecision("https://paz", "response", &decisionctx.Phase{Decision: decisionctx.DecisionAllow, FailOpen: true}, &decisionctx.MCP{Method: "tools/call"}, 100)
+	m.recordDecision("https://paz", "response", &decisionctx.Phase{}, nil, 100)
+	var none *PluginMetrics
+	none.recordDecision("https://paz", "access", &decisionctx.Phase{Decision: decisionctx.DecisionAllow}, nil, 100)
+
+	sums := collectSums(t, reader)
+	for key, want := range map[string]int64{
+		"ping_authorize_policy_decisions_total cached=false,decision=allow,fail_open=false,phase=access,service_url=https://paz,shadow=false":  1,
+		"ping_authorize_policy_decisions_total cached=false,decision=deny,fail_open=false,phase=access,service_url=https://paz,shadow=false":   1,
+		"ping_authorize_policy_decisions_total cached=false,decision=allow,fail_open=true,phase=response,service_url=https://paz,shadow=false": 1,
+		"ping_authorize_mcp_requests_total decision=allow,method=tools/call,service_url=https://paz":                                           1,
+	} {
+		if got := sums[key]; got != want {
+			t.Errorf("%s = %d, want %d", key, got, want)
+		}
+	}
+	if len(sums) != 4 {
+		t.Errorf("expected 4 series, got %v", sums)
+	}
+}
+
+func TestPluginMetrics_RecordMCP(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	m := newPluginMetrics(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter(PluginName))
+
+	allow := &decisionctx.Phase{Decision: decisionctx.DecisionAllow}
+	policyDeny := &decisionctx.Phase{Decision: decisionctx.DecisionDeny}
+	toolDeny := &decisionctx.Phase{Decision: decisionctx.DecisionDeny, Reason: decisionctx.ReasonToolPolicy}
+	m.recordDecision("https://paz", "access", allow, &decisionctx.MCP{Method: "tools/call", Tool: "search"}, 2)
+	m.recordDecision("https://paz", "access", toolDeny, &decisionctx.MCP{Method: "tools/call", Tool: "shell"}, 2)
+	m.recordDecision("https://paz", "access", policyDeny, &decisionctx.MCP{Method: "tools/call", Tool: "fetch"}, 2)
+	m.recordDecision("https://paz", "access", allow, &decisionctx.MCP{Method: "tools/list"}, 2)
+	m.recordDecision("https://paz", "access", allow, &decisionctx.MCP{Method: "prompts/list"}, 2)
+	m.recordDecision("https://paz", "response", policyDeny, &decisionctx.MCP{Method: "tools/list"}, 2)
+
+	sums := collectSums(t, reader)
+	for key, want := range map[string]int64{
+		"ping_authorize_mcp_requests_total decision=allow,method=tools/call,service_url=https://paz":       1,
+		"ping_authorize_mcp_requests_total decision=deny,method=tools/call,service_url=https://paz":        2,
+		"ping_authorize_mcp_requests_total decision=allow,method=tools/list,service_url=https://paz":       1,
+		"ping_authorize_mcp_requests_total decision=allow,method=_other,service_url=https://paz":           1,
+		"ping_authorize_mcp_tool_calls_total decision=allow,service_url=https://paz,tool=search":           1,
+		"ping_authorize_mcp_tool_calls_total decision=deny,service_url=https://paz,tool=shell":             1,
+		"ping_authorize_mcp_tool_calls_total decision=deny,service_url=https://paz,tool=_other":            1,
+		"ping_authorize_mcp_denied_total method=tools/call,reason=tool_policy,service_url=https://paz":     1,
+		"ping_authorize_mcp_denied_total method=tools/call,reason=policy,service_url=https://paz":          1,
+		"ping_authorize_mcp_denied_total method=tools/list,reason=response_policy,service_url=https://paz": 1,
+	} {
+		if got := sums[key]; got != want {
+			t.Errorf("%s = %d, want %d", key, got, want)
+		}
+	}
+}
+
+func TestSidebandResult(t *testing.T) {
+	tests := []struct {
+		status int
+		err    error
+		want   string
+	}{
+		{200, nil, "success"},
+		{403, nil, "success"},
+		{429, nil, "rate_limited"},
+		{503, fmt.Errorf("sideband returned 503"), "server_error"},
+		{0, fmt.Errorf("connection refused"), "error"},
+		{0, &CircuitBreakerOpenError{}, "circuit_open"},
+		{0, &SidebandThrottledError{}, "throttled"},
+		{0, &SidebandEndpointDownError{}, "endpoint_down"},
+	}
+	for _, tt := range tests {
+		if got := sidebandResult(tt.status, tt.err); got != tt.want {
+			t.Errorf("sidebandResult(%d, %v) = %q, want %q", tt.status, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestSampleDebugPayloads(t *testing.T) {
+	tests := []struct {
+		name        string
+		enabled     bool
+		rate        float64
+		trigger     string
+		header      http.Header
+		roll        float64
+		wantSampled bool
+	}{
+		{"debug logging disabled", false, 0.1, "", nil, 99, true},
+		{"rate 1", true, 1, "", nil, 99, true},
+		{"rolled in", true, 0.1, "", nil, 5, true},
+		{"rolled out", true, 0.1, "", nil, 10, false},
+		{"trigger header", true, 0.1, "X-Debug", http.Header{"X-Debug": {"1"}}, 99, true},
+		{"trigger header absent", true, 0.1, "X-Debug", http.Header{"X-Other": {"1"}}, 99, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withSampleRoll(t, tt.roll)
+			conf := &Config{EnableDebugLogging: tt.enabled, DebugSampleRate: tt.rate, DebugTriggerHeader: tt.trigger}
+			logger := NewPluginLogger(nil, "access", "")
+			if got := sampleDebugPayloads(conf, logger, tt.header.Get); got != tt.wantSampled || logger.skipPayloads == got {
+				t.Errorf("sampled = %v, skipPayloads = %v, want sampled %v", got, logger.skipPayloads, tt.wantSampled)
+			}
+		})
+	}
+}
+
+func TestMiddleware_DebugSampling(t *testing.T) {
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/sideband/request") {
+			var req SidebandAccessRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			json.NewEncoder(w).Encode(SidebandAccessResponse{Method: req.Method, URL: req.URL, Headers: req.Headers})
+			return
+		}
+		json.NewEncoder(w).Encode(SidebandResponseResult{ResponseCode: "200", Body: "upstream"})
+	})
+	defer server.Close()
+
+	conf := NewConfig()
+	conf.ServiceURL = server.URL
+	conf.SharedSecret = "secret"
+	conf.SecretHeaderName = "X-Secret"
+	conf.EnableDebugLogging = true
+	conf.DebugSampleRate = 0.01
+	conf.DebugTriggerHeader = "X-Paz-Debug"
+	m, err := NewMiddleware(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := m.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("upstream"))
+	}))
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	withSampleRoll(t, 50)
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/sampled-out", nil))
+	if strings.Contains(buf.String(), "payload") {
+		t.Errorf("expected no payloads for a request sampled out, got %s", buf.String())
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/triggered", nil)
+	req.Header.Set("X-Paz-Debug", "1")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	for _, msg := range []string{"Sending sideband request", "Received sideband response", "Sending sideband response", "Received sideband response result"} {
+		if !strings.Contains(buf.String(), `"msg":"`+msg+`"`) {
+			t.Errorf("expected %q to be logged for a triggered request", msg)
+		}
+	}
+}
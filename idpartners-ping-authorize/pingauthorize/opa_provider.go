@@ -0,0 +1,204 @@
+package pingauthorize
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// ProviderOPA is the name of the built-in Open Policy Agent provider.
+	ProviderOPA = "opa"
+
+	defaultOPAPackage = "kong/authz"
+)
+
+func init() {
+	RegisterProvider(ProviderOPA, func(config *Config, httpClient *SidebandHTTPClient, parsedURL *ParsedURL) (PolicyProvider, error) {
+		return NewOPAProvider(config, httpClient, parsedURL), nil
+	})
+	RegisterProviderValidator(ProviderOPA, validateOPAConfig)
+}
+
+// validateOPAConfig checks the opa_* settings.
+func validateOPAConfig(config *Config) error {
+	if strings.ContainsAny(config.OPAPackage, " \t?#") {
+		return fmt.Errorf("opa_package must be a package path such as kong/authz, got %q", config.OPAPackage)
+	}
+	return nil
+}
+
+// OPAInput is the input document sent to the OPA Data API. Headers are flattened with lowercase
+// names so policies can index them directly.
+type OPAInput struct {
+	Method            string                 `json:"method"`
+	URL               string                 `json:"url"`
+	Path              string                 `json:"path"`
+	Host              string                 `json:"host"`
+	SourceIP          string                 `json:"source_ip"`
+	SourcePort        string                 `json:"source_port"`
+	HTTPVersion       string                 `json:"http_version"`
+	Headers           map[string][]string    `json:"headers"`
+	Body              string                 `json:"body"`
+	BodyDigest        *BodyDigest            `json:"body_digest,omitempty"`
+	ClientCertificate *JWK                   `json:"client_certificate,omitempty"`
+	ExtractedHeaders  map[string]string      `json:"extracted_headers,omitempty"`
+	Attributes        map[string]interface{} `json:"attributes,omitempty"`
+	MCP               *OPAMCPInput           `json:"mcp,omitempty"`
+}
+
+// OPAMCPInput holds the JSON-RPC fields of an MCP request.
+type OPAMCPInput struct {
+	Method       string          `json:"method"`
+	ID           json.RawMessage `json:"id,omitempty"`
+	Tool         string          `json:"tool,omitempty"`
+	Params       json.RawMessage `json:"params,omitempty"`
+	Notification bool            `json:"notification,omitempty"`
+}
+
+// OPADecision is the result document the provider understands. A policy may instead return a
+// bare boolean, which is treated as Allow.
+type OPADecision struct {
+	Allow bool `json:"allow"`
+	// Headers are set on the upstream request on allow and on the client response on deny.
+	Headers map[string]string `json:"headers,omitempty"`
+	// RemoveHeaders are removed from the upstream request on allow.
+	RemoveHeaders []string `json:"remove_headers,omitempty"`
+	// Status is the deny status code; 403 when unset.
+	Status int    `json:"status,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// OPAProvider implements PolicyProvider using the Open Policy Agent Data API.
+// OPA has no response evaluation, so upstream responses are passed through unchanged.
+type OPAProvider struct {
+	httpClient *SidebandHTTPClient
+	config     *Config
+	parsedURL  *ParsedURL
+}
+
+// NewOPAProvider creates a new OPAProvider.
+func NewOPAProvider(config *Config, httpClient *SidebandHTTPClient, parsedURL *ParsedURL) *OPAProvider {
+	return &OPAProvider{
+		httpClient: httpClient,
+		config:     config,
+		parsedURL:  parsedURL,
+	}
+}
+
+// EvaluateRequest POSTs the access phase payload as input to /v1/data/<opa_package> and maps the
+// result document to an allow or deny.
+func (p *OPAProvider) EvaluateRequest(ctx context.Context, req *SidebandAccessRequest) (*SidebandAccessResponse, error) {
+	body, err := json.Marshal(map[string]interface{}{"input": buildOPAInput(req)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode OPA input: %w", err)
+	}
+
+	requestURL := BuildSidebandURL(p.parsedURL, opaDataPath(p.config.OPAPackage))
+	statusCode, _, respBody, err := p.httpClient.Execute(ctx, requestURL, body, p.parsedURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if statusCode >= 400 {
+		var errResp SidebandErrorResponse
+		json.Unmarshal(respBody, &errResp)
+		return nil, &sidebandHTTPError{
+			StatusCode: statusCode,
+			Body:       respBody,
+			Message:    errResp.Message,
+			ID:         errResp.ID,
+		}
+	}
+
+	decision, err := parseOPAResult(respBody)
+	if err != nil {
+		return nil, err
+	}
+	return opaDecision(req, decision), nil
+}
+
+// EvaluateResponse returns the upstream response unchanged without calling OPA.
+func (p *OPAProvider) EvaluateResponse(ctx context.Context, req *SidebandResponsePayload) (*SidebandResponseResult, error) {
+	return &SidebandResponseResult{
+		ResponseCode: req.ResponseCode,
+		Body:         req.Body,
+		Headers:      req.Headers,
+	}, nil
+}
+
+// opaDataPath returns the Data API path of a package, accepting "kong/authz" or "kong.authz".
+func opaDataPath(pkg string) string {
+	if pkg == "" {
+		pkg = defaultOPAPackage
+	}
+	pkg = strings.Trim(strings.ReplaceAll(pkg, ".", "/"), "/")
+	return "/v1/data/" + pkg
+}
+
+// buildOPAInput maps a sideband access payload to the OPA input document.
+func buildOPAInput(req *SidebandAccessRequest) *OPAInput {
+	input := &OPAInput{
+		Method:            req.Method,
+		URL:               req.URL,
+		Path:              "/",
+		SourceIP:          req.SourceIP,
+		SourcePort:        req.SourcePort,
+		HTTPVersion:       req.HTTPVersion,
+		Headers:           FlattenHeaders(req.Headers),
+		Body:              req.Body,
+		BodyDigest:        req.BodyDigest,
+		ClientCertificate: req.ClientCertificate,
+		ExtractedHeaders:  req.ExtractedHeaders,
+		Attributes:        req.Attributes,
+	}
+	if u, err := url.Parse(req.URL); err == nil {
+		if u.Path != "" {
+			input.Path = u.Path
+		}
+		input.Host = u.Hostname()
+	}
+	if mcpReq := parseMCPRequest([]byte(req.Body)); mcpReq != nil {
+		input.MCP = &OPAMCPInput{Method: mcpReq.Method, ID: mcpReq.ID, Tool: mcpToolName(mcpReq), Params: mcpReq.Params, Notification: mcpReq.notification()}
+	}
+	return input
+}
+
+// parseOPAResult decodes a Data API response. An undefined result (no "result" member) denies,
+// since OPA returns it when the package or rule does not exist.
+func parseOPAResult(body []byte) (*OPADecision, error) {
+	var resp struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("failed to decode OPA response: %w", err)
+	}
+	if len(resp.Result) == 0 || string(resp.Result) == "null" {
+		return &OPADecision{Reason: "Policy decision is undefined"}, nil
+	}
+
+	var allow bool
+	if err := json.Unmarshal(resp.Result, &allow); err == nil {
+		return &OPADecision{Allow: allow}, nil
+	}
+	var decision OPADecision
+	if err := json.Unmarshal(resp.Result, &decision); err != nil {
+		return nil, fmt.Errorf("failed to decode OPA result: %w", err)
+	}
+	return &decision, nil
+}
+
+// opaDecision maps an OPA decision to a sideband access response.
+func opaDecision(req *SidebandAccessRequest, decision *OPADecision) *SidebandAccessResponse {
+	if !decision.Allow {
+		status := decision.Status
+		if status < 400 || status > 599 {
+			status = http.StatusForbidden
+		}
+		return policyDenyResponse(status, decision.Reason, decision.Headers)
+	}
+	return policyPermitResponse(req, decision.Headers, decision.RemoveHeaders)
+}
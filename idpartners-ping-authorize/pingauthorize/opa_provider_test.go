@@ -0,0 +1,172 @@
+package pingauthorize
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOPADataPath(t *testing.T) {
+	tests := []struct {
+		pkg, want string
+	}{
+		{"", "/v1/data/kong/authz"},
+		{"httpapi/authz", "/v1/data/httpapi/authz"},
+		{"httpapi.authz.decision", "/v1/data/httpapi/authz/decision"},
+		{"/httpapi/authz/", "/v1/data/httpapi/authz"},
+	}
+	for _, tt := range tests {
+		if got := opaDataPath(tt.pkg); got != tt.want {
+			t.Errorf("opaDataPath(%q) = %q, want %q", tt.pkg, got, tt.want)
+		}
+	}
+}
+
+func TestBuildOPAInput(t *testing.T) {
+	input := buildOPAInput(&SidebandAccessRequest{
+		SourceIP: "10.0.0.1",
+		Method:   "POST",
+		URL:      "https://api.example.com:443/mcp?x=1",
+		Body:     `{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"name":"search"}}`,
+		Headers:  []map[string]string{{"X-Tenant": "a"}},
+	})
+	if input.Path != "/mcp" || input.Host != "api.example.com" || input.Headers["x-tenant"][0] != "a" {
+		t.Errorf("unexpected input: %+v", input)
+	}
+	if input.MCP == nil || input.MCP.Method != "tools/call" || input.MCP.Tool != "search" || string(input.MCP.ID) != "7" {
+		t.Errorf("unexpected mcp input: %+v", input.MCP)
+	}
+
+	input = buildOPAInput(&SidebandAccessRequest{Method: "GET", URL: "http://api.example.com:80"})
+	if input.Path != "/" || input.MCP != nil {
+		t.Errorf("unexpected input for plain request: %+v", input)
+	}
+}
+
+func TestParseOPAResult(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		allow   bool
+		wantErr bool
+	}{
+		{"boolean allow", `{"result":true}`, true, false},
+		{"boolean deny", `{"result":false}`, false, false},
+		{"object", `{"result":{"allow":true,"headers":{"x-a":"b"}}}`, true, false},
+		{"undefined", `{}`, false, false},
+		{"unexpected type", `{"result":"yes"}`, false, true},
+		{"not JSON", `oops`, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseOPAResult([]byte(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.Allow != tt.allow {
+				t.Errorf("allow = %v, want %v", got.Allow, tt.allow)
+			}
+		})
+	}
+}
+
+func TestOPADecision(t *testing.T) {
+	req := &SidebandAccessRequest{
+		Method:  "GET",
+		URL:     "http://api.example.com:80/",
+		Headers: []map[string]string{{"host": "api.example.com"}, {"cookie": "s=1"}},
+	}
+
+	t.Run("allow", func(t *testing.T) {
+		resp := opaDecision(req, &OPADecision{Allow: true, Headers: map[string]string{"X-User": "alice"}, RemoveHeaders: []string{"Cookie"}})
+		if resp.Response != nil {
+			t.Fatal("allow should not produce a deny response")
+		}
+		headers := FlattenHeaders(resp.Headers)
+		if headers["x-user"][0] != "alice" || headers["cookie"] != nil || headers["host"][0] != "api.example.com" {
+			t.Errorf("unexpected headers: %v", headers)
+		}
+	})
+
+	t.Run("deny with status", func(t *testing.T) {
+		resp := opaDecision(req, &OPADecision{Status: 429, Reason: "slow down", Headers: map[string]string{"Retry-After": "5"}})
+		if resp.Response == nil || resp.Response.ResponseCode != "429" || resp.Response.ResponseStatus != "TOO_MANY_REQUESTS" {
+			t.Fatalf("expected 429 deny, got %+v", resp.Response)
+		}
+		if !strings.Contains(resp.Response.Body, "slow down") || FlattenHeaders(resp.Response.Headers)["retry-after"][0] != "5" {
+			t.Errorf("unexpected deny response: %+v", resp.Response)
+		}
+	})
+
+	t.Run("deny with invalid status", func(t *testing.T) {
+		resp := opaDecision(req, &OPADecision{Status: 200})
+		if resp.Response == nil || resp.Response.ResponseCode != "403" {
+			t.Errorf("expected 403 deny, got %+v", resp.Response)
+		}
+	})
+}
+
+func TestMiddleware_OPAProvider(t *testing.T) {
+	var gotPath string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		var body struct {
+			Input OPAInput `json:"input"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body.Input.MCP != nil && body.Input.MCP.Tool == "shell" {
+			w.Write([]byte(`{"result":{"allow":false,"reason":"shell is not allowed"}}`))
+			return
+		}
+		w.Write([]byte(`{"result":{"allow":true,"headers":{"x-user":"` + firstValue(body.Input.Headers["x-consumer-username"]) + `"}}}`))
+	}))
+	defer server.Close()
+
+	m, err := NewMiddleware(&Config{
+		ServiceURL:       server.URL,
+		SharedSecret:     "test-secret",
+		SecretHeaderName: "X-Secret",
+		ProviderType:     ProviderOPA,
+		OPAPackage:       "httpapi.authz.decision",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	h := m.Handler(upstreamEcho(t, &calls))
+
+	r := httptest.NewRequest("POST", "http://api.example.com/mcp", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"search"}}`))
+	r.Header.Set("X-Consumer-Username", "alice")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	if gotPath != "/v1/data/httpapi/authz/decision" {
+		t.Errorf("unexpected path %q", gotPath)
+	}
+	if rec.Code != 200 || rec.Header().Get("X-Upstream-User") != "alice" {
+		t.Errorf("expected allowed request with policy header, got %d %v %s", rec.Code, rec.Header(), rec.Body.String())
+	}
+
+	r = httptest.NewRequest("POST", "http://api.example.com/mcp", strings.NewReader(`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"shell"}}`))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	if rec.Code != 403 || !strings.Contains(rec.Body.String(), "shell is not allowed") || calls != 1 {
+		t.Errorf("expected 403 deny, got %d %s (%d upstream calls)", rec.Code, rec.Body.String(), calls)
+	}
+}
+
+func TestValidateOPAConfig(t *testing.T) {
+	conf := NewConfig()
+	conf.ServiceURL = "http://localhost:8181"
+	conf.SharedSecret = "secret"
+	conf.SecretHeaderName = "Authorization"
+	conf.ProviderType = ProviderOPA
+	if err := conf.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conf.OPAPackage = "kong/authz?pretty=true"
+	if err := conf.Validate(); err == nil {
+		t.Error("expected error for opa_package with a query string")
+	}
+}
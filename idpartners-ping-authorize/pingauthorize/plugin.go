@@ -0,0 +1,113 @@
+// Package pingauthorize implements the PingAuthorize Sideband API integration used by the
+// idpartners-ping-authorize Kong plugin.
+//
+// The package can be embedded outside Kong: Config, NewSidebandHTTPClient, NewSidebandProvider,
+// the payload types and the MCP helpers have no dependency on a running gateway. Access and
+// Response are the Kong phase handlers and require a go-pdk PDK.
+package pingauthorize
+
+import (
+	"fmt"
+
+	"github.com/Kong/go-pdk"
+)
+
+const (
+	PluginName = "idpartners-ping-authorize"
+	Version    = "2.0.0"
+	Priority   = 999
+)
+
+// NewConfig returns a plugin configuration populated with the default values.
+func NewConfig() *Config {
+	return &Config{
+		// Defaults that match DESIGN.md §3.1
+		ConnectionTimeoutMs:         10000,
+		ConnectionKeepaliveMs:       60000,
+		VerifyServiceCert:           true,
+		PassthroughStatusCodes:      []int{413},
+		RetryBackoffMs:              500,
+		CircuitBreakerEnabled:       true,
+		CircuitBreakerMaxOpenSec:    defaultCircuitBreakerMaxOpenSec,
+		CircuitBreakerMode:          CircuitBreakerModeFirstFailure,
+		CircuitBreakerWindowSize:    defaultCircuitBreakerWindowSize,
+		CircuitBreakerFailureRate:   defaultCircuitBreakerFailureRate,
+		CircuitBreakerMinCalls:      defaultCircuitBreakerMinCalls,
+		CircuitBreakerStore:         storeMemory,
+		StripAcceptEncoding:         true,
+		DecompressRequestBody:       true,
+		DecompressResponseBody:      true,
+		MaxDecompressedBodyBytes:    defaultMaxDecompressedBodyBytes,
+		RecompressResponseBody:      true,
+		BodySamplePercent:           100,
+		EvaluationPercentage:        100,
+		EvaluationKey:               evaluationKeyClientIP,
+		SLOTarget:                   defaultSLOTarget,
+		SLOWindowSeconds:            defaultSLOWindowSeconds,
+		SLOMinCalls:                 defaultSLOMinCalls,
+		RedactHeaders:               []string{"authorization", "cookie"},
+		SkipBodyMethods:             []string{"GET", "HEAD"},
+		SummarizeMultipartBody:      true,
+		GraphQLEnrichment:           true,
+		GRPCEnrichment:              true,
+		MultipartTextFieldMaxBytes:  defaultMultipartTextFieldMaxBytes,
+		DebugBodyMaxBytes:           8192,
+		DebugSampleRate:             1,
+		ClientCertificateDenyStatus: 401,
+		ProviderType:                ProviderSideband,
+		AuthZenEvaluationPath:       defaultAuthZenEvaluationPath,
+		AuthZenSubjectHeader:        defaultAuthZenSubjectHeader,
+		AuthZenSubjectType:          defaultAuthZenSubjectType,
+		OPAPackage:                  defaultOPAPackage,
+		DecisionCacheKey:            defaultDecisionCacheKey,
+		DecisionCacheKeyHeaders:     defaultDecisionCacheKeyHeaders,
+		DecisionCacheMaxEntries:     defaultDecisionCacheMaxEntries,
+		DecisionCacheStore:          storeMemory,
+		StateStore:                  storeKong,
+		RedisTLSVerify:              true,
+		RedisKeyPrefix:              defaultRedisKeyPrefix,
+		RedisTimeoutMs:              defaultRedisTimeoutMs,
+		RedisStateTTLMs:             defaultRedisStateTTLMs,
+
+		HealthCheckIntervalMs:         defaultHealthCheckIntervalMs,
+		HealthCheckTimeoutMs:          defaultHealthCheckTimeoutMs,
+		HealthCheckUnhealthyThreshold: defaultHealthCheckUnhealthyThreshold,
+		HealthCheckHealthyThreshold:   defaultHealthCheckHealthyThreshold,
+		CircuitBreakerSyncIntervalMs:  defaultCircuitBreakerSyncIntervalMs,
+		AuditLogMaxSizeMB:             defaultAuditLogMaxSizeMB,
+		AuditLogMaxBackups:            defaultAuditLogMaxBackups,
+		AuditLogRequestIDHeader:       defaultAuditLogRequestIDHeader,
+		DenyWebhookRetries:            defaultWebhookRetries,
+		PromptInjectionPatterns:       defaultPromptInjectionPatterns,
+		PromptInjectionAction:         PromptInjectionActionAnnotate,
+		PromptInjectionBlockScore:     1,
+		MCPMetricsMaxLabelValues:      defaultMCPMetricsMaxLabelValues,
+	}
+}
+
+// Access is the Kong access phase handler.
+func (conf *Config) Access(kong *pdk.PDK) {
+	defer func() {
+		if r := recover(); r != nil {
+			kong.Log.Err(fmt.Sprintf("[%s] Unexpected panic in access phase: %v", PluginName, r))
+			kong.Response.Exit(500, nil, nil)
+		}
+	}()
+	executeAccess(kong, conf)
+}
+
+// Response is the Kong response phase handler.
+func (conf *Config) Response(kong *pdk.PDK) {
+	defer func() {
+		if r := recover(); r != nil {
+			kong.Log.Err(fmt.Sprintf("[%s] Unexpected panic in response phase: %v", PluginName, r))
+			kong.Response.Exit(500, nil, nil)
+		}
+	}()
+	rememberKongMCPClient(kong)
+	if conf.SkipResponsePhase {
+		passThroughMCPBatchErrors(kong, conf)
+		return
+	}
+	executeResponse(kong, conf)
+}
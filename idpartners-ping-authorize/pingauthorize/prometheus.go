@@ -0,0 +1,163 @@
+package pingauthorize
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// prometheusContentType is the Prometheus text exposition format served by PrometheusExporter.
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// PrometheusExporter serves the plugin metrics in the Prometheus text format. Its reader must be
+// registered with the meter provider, see InitOTel and InitMetrics; metrics are collected on
+// each scrape.
+type PrometheusExporter struct {
+	reader *sdkmetric.ManualReader
+}
+
+// NewPrometheusExporter creates an exporter with a reader that is not yet registered.
+func NewPrometheusExporter() *PrometheusExporter {
+	return &PrometheusExporter{reader: sdkmetric.NewManualReader()}
+}
+
+// Reader returns the metric reader to register with the meter provider.
+func (e *PrometheusExporter) Reader() sdkmetric.Reader {
+	return e.reader
+}
+
+// ServeHTTP collects the metrics and writes them in the Prometheus text format.
+func (e *PrometheusExporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var rm metricdata.ResourceMetrics
+	if err := e.reader.Collect(r.Context(), &rm); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", prometheusContentType)
+	bw := bufio.NewWriter(w)
+	writePrometheus(bw, &rm)
+	bw.Flush()
+}
+
+// InitMetrics installs a meter provider with the given readers, for exporting metrics without an
+// OTLP collector. Use InitOTel instead when OTLP is configured. The returned function shuts the
+// provider down.
+func InitMetrics(readers ...sdkmetric.Reader) func(context.Context) error {
+	meterProvider := newMeterProvider(resource.NewSchemaless(pluginResourceAttributes()...), readers...)
+	otel.SetMeterProvider(meterProvider)
+	return meterProvider.Shutdown
+}
+
+// writePrometheus writes the metrics of rm, sorted by name. Monotonic sums are counters, other
+// sums and gauges are gauges, and histograms keep their explicit buckets.
+func writePrometheus(w *bufio.Writer, rm *metricdata.ResourceMetrics) {
+	var metrics []metricdata.Metrics
+	for _, sm := range rm.ScopeMetrics {
+		metrics = append(metrics, sm.Metrics...)
+	}
+	sort.SliceStable(metrics, func(i, j int) bool { return metrics[i].Name < metrics[j].Name })
+
+	for _, m := range metrics {
+		name := prometheusName(m.Name)
+		switch data := m.Data.(type) {
+		case metricdata.Sum[int64]:
+			writePrometheusHeader(w, name, m.Description, sumType(data.IsMonotonic))
+			for _, dp := range data.DataPoints {
+				writePrometheusSample(w, name, dp.Attributes, "", "", float64(dp.Value))
+			}
+		case metricdata.Sum[float64]:
+			writePrometheusHeader(w, name, m.Description, sumType(data.IsMonotonic))
+			for _, dp := range data.DataPoints {
+				writePrometheusSample(w, name, dp.Attributes, "", "", dp.Value)
+			}
+		case metricdata.Gauge[int64]:
+			writePrometheusHeader(w, name, m.Description, "gauge")
+			for _, dp := range data.DataPoints {
+				writePrometheusSample(w, name, dp.Attributes, "", "", float64(dp.Value))
+			}
+		case metricdata.Gauge[float64]:
+			writePrometheusHeader(w, name, m.Description, "gauge")
+			for _, dp := range data.DataPoints {
+				writePrometheusSample(w, name, dp.Attributes, "", "", dp.Value)
+			}
+		case metricdata.Histogram[float64]:
+			writePrometheusHeader(w, name, m.Description, "histogram")
+			for _, dp := range data.DataPoints {
+				var cumulative uint64
+				for i, bound := range dp.Bounds {
+					cumulative += dp.BucketCounts[i]
+					writePrometheusSample(w, name+"_bucket", dp.Attributes, "le", formatPrometheusValue(bound), float64(cumulative))
+				}
+				writePrometheusSample(w, name+"_bucket", dp.Attributes, "le", "+Inf", float64(dp.Count))
+				writePrometheusSample(w, name+"_sum", dp.Attributes, "", "", dp.Sum)
+				writePrometheusSample(w, name+"_count", dp.Attributes, "", "", float64(dp.Count))
+			}
+		}
+	}
+}
+
+func sumType(monotonic bool) string {
+	if monotonic {
+		return "counter"
+	}
+	return "gauge"
+}
+
+func writePrometheusHeader(w *bufio.Writer, name, description, kind string) {
+	if description != "" {
+		fmt.Fprintf(w, "# HELP %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(description))
+	}
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+// writePrometheusSample writes one sample with the attributes of set as labels, plus extraName
+// when it is not empty.
+func writePrometheusSample(w *bufio.Writer, name string, set attribute.Set, extraName, extraValue string, value float64) {
+	w.WriteString(name)
+	labels := make([]string, 0, set.Len()+1)
+	for _, kv := range set.ToSlice() {
+		labels = append(labels, prometheusName(string(kv.Key))+`="`+escapePrometheusLabel(kv.Value.Emit())+`"`)
+	}
+	if extraName != "" {
+		labels = append(labels, extraName+`="`+extraValue+`"`)
+	}
+	if len(labels) > 0 {
+		w.WriteString("{" + strings.Join(labels, ",") + "}")
+	}
+	w.WriteString(" " + formatPrometheusValue(value) + "\n")
+}
+
+// prometheusName replaces the characters Prometheus does not allow in metric and label names.
+func prometheusName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r == ':' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+func escapePrometheusLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatPrometheusValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
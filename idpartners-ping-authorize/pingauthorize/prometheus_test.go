@@ -0,0 +1,69 @@
+package pingauthorize
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+)
+
+func TestPrometheusExporter(t *testing.T) {
+	prom := NewPrometheusExporter()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(prom.Reader()))
+	defer provider.Shutdown(context.Background())
+	meter := provider.Meter("test")
+	ctx := context.Background()
+
+	counter, _ := meter.Int64Counter("ping_authorize_sideband_total", metric.WithDescription("Sideband calls"))
+	counter.Add(ctx, 2, metric.WithAttributes(attribute.String("service_url", `https://paz"1`), attribute.String("path", "/sideband/request")))
+	gauge, _ := meter.Int64UpDownCounter("ping_authorize_circuit_breaker_state")
+	gauge.Add(ctx, -1)
+	histogram, _ := meter.Float64Histogram("ping_authorize_sideband_duration_ms",
+		metric.WithExplicitBucketBoundaries(10, 100))
+	histogram.Record(ctx, 5)
+	histogram.Record(ctx, 50)
+	histogram.Record(ctx, 500)
+
+	rec := httptest.NewRecorder()
+	prom.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != prometheusContentType {
+		t.Fatalf("unexpected response %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	body, _ := io.ReadAll(rec.Body)
+
+	want := []string{
+		"# TYPE ping_authorize_circuit_breaker_state gauge\nping_authorize_circuit_breaker_state -1\n",
+		"# TYPE ping_authorize_sideband_duration_ms histogram\n" +
+			`ping_authorize_sideband_duration_ms_bucket{le="10"} 1` + "\n" +
+			`ping_authorize_sideband_duration_ms_bucket{le="100"} 2` + "\n" +
+			`ping_authorize_sideband_duration_ms_bucket{le="+Inf"} 3` + "\n" +
+			"ping_authorize_sideband_duration_ms_sum 555\nping_authorize_sideband_duration_ms_count 3\n",
+		"# HELP ping_authorize_sideband_total Sideband calls\n# TYPE ping_authorize_sideband_total counter\n" +
+			`ping_authorize_sideband_total{path="/sideband/request",service_url="https://paz\"1"} 2` + "\n",
+	}
+	if got := string(body); got != strings.Join(want, "") {
+		t.Errorf("unexpected exposition:\n%s", got)
+	}
+}
+
+func TestPrometheusName(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"ping_authorize_sideband_total", "ping_authorize_sideband_total"},
+		{"http.server.duration", "http_server_duration"},
+		{"ns:metric-name", "ns:metric_name"},
+	}
+	for _, tt := range tests {
+		if got := prometheusName(tt.in); got != tt.want {
+			t.Errorf("prometheusName(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
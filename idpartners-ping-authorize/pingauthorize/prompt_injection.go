@@ -0,0 +1,200 @@
+package pingauthorize
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/idpartners/idpartners-ping-authorize/pingauthorize/decisionctx"
+)
+
+// Actions selectable with prompt_injection_action.
+const (
+	PromptInjectionActionAnnotate = "annotate"
+	PromptInjectionActionBlock    = "block"
+)
+
+// defaultPromptInjectionPatterns are screened for when prompt_injection_patterns is not configured.
+// They match common instruction-override phrasings.
+var defaultPromptInjectionPatterns = []string{
+	`\b(ignore|disregard|forget|override)\s+(all\s+|any\s+)?(the\s+)?(previous|prior|above|earlier|preceding)\s+(instructions|prompts|rules|directions)`,
+	`\b(reveal|print|show|repeat|output)\s+(me\s+)?(your|the)\s+(system\s+prompt|hidden\s+instructions|initial\s+instructions)`,
+	`\byou\s+are\s+now\s+(in\s+)?(developer|jailbreak|dan|unrestricted)\b`,
+	`\bdo\s+anything\s+now\b`,
+	`</?\s*(system|assistant|im_start|im_end)\s*>`,
+	`\bnew\s+instructions\s*:`,
+}
+
+// MCPPromptInjection reports the prompt-injection rules the arguments of a tools/call or
+// prompts/get request matched. Score is the number of distinct rules matched.
+type MCPPromptInjection struct {
+	Score     int      `json:"score"`
+	Rules     []string `json:"rules"`
+	Arguments []string `json:"arguments"`
+}
+
+// promptInjectionRule is a compiled prompt_injection_patterns or prompt_injection_keywords entry.
+type promptInjectionRule struct {
+	name    string
+	re      *regexp.Regexp
+	keyword string
+}
+
+func (r *promptInjectionRule) matches(text, lower string) bool {
+	if r.re != nil {
+		return r.re.MatchString(text)
+	}
+	return strings.Contains(lower, r.keyword)
+}
+
+// compilePromptInjectionRules compiles prompt_injection_patterns, case-insensitive, and
+// prompt_injection_keywords.
+func compilePromptInjectionRules(patterns, keywords []string) ([]promptInjectionRule, error) {
+	rules := make([]promptInjectionRule, 0, len(patterns)+len(keywords))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			return nil, fmt.Errorf("prompt_injection_patterns: invalid pattern %q: %w", pattern, err)
+		}
+		rules = append(rules, promptInjectionRule{name: pattern, re: re})
+	}
+	for _, keyword := range keywords {
+		if keyword == "" {
+			return nil, fmt.Errorf("prompt_injection_keywords must not contain empty keywords")
+		}
+		rules = append(rules, promptInjectionRule{name: keyword, keyword: strings.ToLower(keyword)})
+	}
+	return rules, nil
+}
+
+// validatePromptInjection validates the prompt-injection screening settings.
+func validatePromptInjection(c *Config) error {
+	switch c.PromptInjectionAction {
+	case "", PromptInjectionActionAnnotate, PromptInjectionActionBlock:
+	default:
+		return fmt.Errorf("prompt_injection_action must be annotate or block, got %q", c.PromptInjectionAction)
+	}
+	if c.PromptInjectionBlockScore < 0 {
+		return fmt.Errorf("prompt_injection_block_score must be >= 0")
+	}
+	_, err := compilePromptInjectionRules(c.PromptInjectionPatterns, c.PromptInjectionKeywords)
+	return err
+}
+
+// getPromptInjectionRules returns the compiled screening rules. Invalid rules are rejected by
+// Validate, so they are treated as none here.
+func (c *Config) getPromptInjectionRules() []promptInjectionRule {
+	c.promptInjectionOnce.Do(func() {
+		c.promptInjectionRules, _ = compilePromptInjectionRules(c.PromptInjectionPatterns, c.PromptInjectionKeywords)
+	})
+	return c.promptInjectionRules
+}
+
+// screenPromptInjection screens the string arguments of a tools/call or prompts/get request with
+// prompt_injection_screening. Matches are reported in the prompt_injection member of the mcp
+// context, or of the mcp_batch entry. With prompt_injection_action block, a request scoring
+// prompt_injection_block_score or more, or a batch holding one, is denied instead: blocked is true
+// and status, body and headers are the response.
+func screenPromptInjection(conf *Config, payload *SidebandAccessRequest, logger *PluginLogger) (status int, body []byte, headers map[string][]string, blocked bool) {
+	if !conf.PromptInjectionScreening {
+		return 0, nil, nil, false
+	}
+	block := false
+	screen := func(req *jsonRPCRequest) *MCPPromptInjection {
+		result := screenPromptInjectionRequest(conf, req)
+		if result != nil {
+			logger.Info("MCP arguments matched prompt injection rules", "score", result.Score, "method", req.Method)
+			block = block || conf.PromptInjectionAction == PromptInjectionActionBlock && result.Score >= conf.PromptInjectionBlockScore
+		}
+		return result
+	}
+
+	if payload.MCP != nil {
+		req := parseMCPRequest([]byte(payload.Body))
+		if req == nil {
+			return 0, nil, nil, false
+		}
+		payload.MCP.PromptInjection = screen(req)
+	} else {
+		batch := parseMCPBatch([]byte(payload.Body))
+		if batch == nil || len(batch) != len(payload.MCPBatch) {
+			return 0, nil, nil, false
+		}
+		for i, req := range batch {
+			payload.MCPBatch[i].PromptInjection = screen(req)
+		}
+	}
+	if !block {
+		return 0, nil, nil, false
+	}
+	status = http.StatusForbidden
+	data := newMCPDenyData(conf, payload, status, decisionctx.ReasonPromptInjection)
+	body = mcpDenyBody("Request blocked by prompt injection screening", []byte(payload.Body), data)
+	return status, body, map[string][]string{"Content-Type": {"application/json"}}, true
+}
+
+// screenPromptInjectionRequest matches the arguments of a tools/call or prompts/get request
+// against the screening rules. Returns nil for other requests and when none matches.
+func screenPromptInjectionRequest(conf *Config, req *jsonRPCRequest) *MCPPromptInjection {
+	if req.Method != "tools/call" && req.Method != "prompts/get" {
+		return nil
+	}
+	var params struct {
+		Arguments interface{} `json:"arguments"`
+	}
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return nil
+	}
+	return matchPromptInjection(conf.getPromptInjectionRules(), params.Arguments)
+}
+
+// matchPromptInjection matches every string in arguments against rules. Returns nil when none
+// matches.
+func matchPromptInjection(rules []promptInjectionRule, arguments interface{}) *MCPPromptInjection {
+	matched := make(map[string]bool)
+	var paths []string
+	var walk func(v interface{}, path string)
+	walk = func(v interface{}, path string) {
+		switch v := v.(type) {
+		case string:
+			lower := strings.ToLower(v)
+			hit := false
+			for i := range rules {
+				if rules[i].matches(v, lower) {
+					matched[rules[i].name] = true
+					hit = true
+				}
+			}
+			if hit {
+				paths = append(paths, path)
+			}
+		case []interface{}:
+			for i, item := range v {
+				walk(item, fmt.Sprintf("%s[%d]", path, i))
+			}
+		case map[string]interface{}:
+			names := make([]string, 0, len(v))
+			for name := range v {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				walk(v[name], path+"."+name)
+			}
+		}
+	}
+	walk(arguments, "arguments")
+
+	if len(matched) == 0 {
+		return nil
+	}
+	result := &MCPPromptInjection{Score: len(matched), Arguments: paths}
+	for name := range matched {
+		result.Rules = append(result.Rules, name)
+	}
+	sort.Strings(result.Rules)
+	return result
+}
@@ -0,0 +1,66 @@
+package pingauthorize
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// PolicyProvider abstracts the sideband communication protocol.
+// SidebandProvider implements the PingAuthorize Sideband API, AuthZenProvider the OpenID
+// AuthZEN Access Evaluation API and OPAProvider the Open Policy Agent Data API.
+type PolicyProvider interface {
+	// EvaluateRequest sends the client request for policy evaluation (access phase).
+	EvaluateRequest(ctx context.Context, req *SidebandAccessRequest) (*SidebandAccessResponse, error)
+
+	// EvaluateResponse sends the upstream response for final evaluation (response phase).
+	EvaluateResponse(ctx context.Context, req *SidebandResponsePayload) (*SidebandResponseResult, error)
+}
+
+// policyDenyResponse builds the deny response of a provider whose PDP returns a decision rather
+// than a complete response: a JSON {"code","message"} body with status and the extra headers.
+func policyDenyResponse(status int, message string, extra map[string]string) *SidebandAccessResponse {
+	code := strings.ToUpper(strings.ReplaceAll(http.StatusText(status), " ", "_"))
+	if message == "" {
+		message = "Access denied"
+	}
+	body, _ := json.Marshal(map[string]string{"code": code, "message": message})
+	headers := []map[string]string{{"content-type": "application/json"}}
+	for _, name := range sortedKeys(extra) {
+		headers = append(headers, map[string]string{strings.ToLower(name): extra[name]})
+	}
+	return &SidebandAccessResponse{Response: &DenyResponse{
+		ResponseCode:   strconv.Itoa(status),
+		ResponseStatus: code,
+		Body:           string(body),
+		Headers:        headers,
+	}}
+}
+
+// policyPermitResponse builds the allow response of a provider whose PDP returns a decision
+// rather than a complete request: the request unchanged apart from the set and removed headers.
+func policyPermitResponse(req *SidebandAccessRequest, set map[string]string, remove []string) *SidebandAccessResponse {
+	// The sideband response carries the complete header set, so the request headers are echoed
+	headers := req.Headers
+	if len(set) > 0 || len(remove) > 0 {
+		flat := FlattenHeaders(req.Headers)
+		for _, name := range remove {
+			delete(flat, strings.ToLower(name))
+		}
+		for name, value := range set {
+			flat[strings.ToLower(name)] = []string{value}
+		}
+		headers, _ = FormatHeaders(flat)
+	}
+	body := req.Body
+	return &SidebandAccessResponse{
+		SourceIP:   req.SourceIP,
+		SourcePort: req.SourcePort,
+		Method:     req.Method,
+		URL:        req.URL,
+		Body:       &body,
+		Headers:    headers,
+	}
+}
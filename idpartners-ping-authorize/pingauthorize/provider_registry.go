@@ -0,0 +1,132 @@
+package pingauthorize
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ProviderSideband is the name of the built-in PingAuthorize Sideband API provider.
+const ProviderSideband = "sideband"
+
+// ProviderFactory creates a PolicyProvider for a plugin configuration.
+// httpClient is the shared sideband client for the config's service_url (retries and circuit breaker included).
+type ProviderFactory func(config *Config, httpClient *SidebandHTTPClient, parsedURL *ParsedURL) (PolicyProvider, error)
+
+// ProviderValidator checks the Config settings a provider reads, such as its own fields or
+// provider_options. Config.Validate runs it when provider_type selects the provider.
+type ProviderValidator func(config *Config) error
+
+type providerEntry struct {
+	factory  ProviderFactory
+	validate ProviderValidator
+}
+
+var (
+	providersMu sync.RWMutex
+	providers   = make(map[string]*providerEntry)
+)
+
+func init() {
+	RegisterProvider(ProviderSideband, func(config *Config, httpClient *SidebandHTTPClient, parsedURL *ParsedURL) (PolicyProvider, error) {
+		return NewSidebandProvider(config, httpClient, parsedURL), nil
+	})
+}
+
+// RegisterProvider makes a PolicyProvider available under name for selection via provider_type.
+// It is intended to be called from an init function; it panics if name is empty, the factory
+// is nil, or name is already registered.
+func RegisterProvider(name string, factory ProviderFactory) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+
+	if name == "" {
+		panic("pingauthorize: RegisterProvider name is empty")
+	}
+	if factory == nil {
+		panic("pingauthorize: RegisterProvider factory is nil for " + name)
+	}
+	if _, dup := providers[name]; dup {
+		panic("pingauthorize: RegisterProvider called twice for " + name)
+	}
+	providers[name] = &providerEntry{factory: factory}
+}
+
+// RegisterProviderValidator attaches a config check to the provider registered under name.
+// It is intended to be called from an init function after RegisterProvider; it panics if validate
+// is nil, name is not registered, or the provider already has a validator.
+func RegisterProviderValidator(name string, validate ProviderValidator) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+
+	if validate == nil {
+		panic("pingauthorize: RegisterProviderValidator validator is nil for " + name)
+	}
+	entry, ok := providers[name]
+	if !ok {
+		panic("pingauthorize: RegisterProviderValidator called for unregistered provider " + name)
+	}
+	if entry.validate != nil {
+		panic("pingauthorize: RegisterProviderValidator called twice for " + name)
+	}
+	entry.validate = validate
+}
+
+// RegisteredProviders returns the sorted names of all registered providers.
+func RegisteredProviders() []string {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookupProvider returns the provider registered under name (the sideband provider if name is empty).
+func lookupProvider(name string) (*providerEntry, error) {
+	if name == "" {
+		name = ProviderSideband
+	}
+
+	providersMu.RLock()
+	entry, ok := providers[name]
+	providersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown provider_type %q (registered: %v)", name, RegisteredProviders())
+	}
+	return entry, nil
+}
+
+// validateProvider checks that the config's provider_type is registered and runs its validator.
+func validateProvider(config *Config) error {
+	entry, err := lookupProvider(config.ProviderType)
+	if err != nil {
+		return err
+	}
+	if entry.validate == nil {
+		return nil
+	}
+	return entry.validate(config)
+}
+
+// newProvider creates the PolicyProvider selected by the config's provider_type, backed by the
+// fallback_provider and fallback_rules and fronted by the decision and tools/list caches if they
+// are configured.
+func newProvider(config *Config, httpClient *SidebandHTTPClient, parsedURL *ParsedURL) (PolicyProvider, error) {
+	entry, err := lookupProvider(config.ProviderType)
+	if err != nil {
+		return nil, err
+	}
+	provider, err := entry.factory(config, httpClient, parsedURL)
+	if err != nil {
+		return nil, err
+	}
+	provider, err = withFallback(config, provider)
+	if err != nil {
+		return nil, err
+	}
+	return withToolsListCache(config, withDecisionCache(config, withFallbackRules(config, provider))), nil
+}
@@ -0,0 +1,140 @@
+package pingauthorize
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type stubProvider struct{}
+
+func (stubProvider) EvaluateRequest(ctx context.Context, req *SidebandAccessRequest) (*SidebandAccessResponse, error) {
+	return &SidebandAccessResponse{}, nil
+}
+
+func (stubProvider) EvaluateResponse(ctx context.Context, req *SidebandResponsePayload) (*SidebandResponseResult, error) {
+	return &SidebandResponseResult{ResponseCode: "200"}, nil
+}
+
+func TestRegisterProvider_SelectByName(t *testing.T) {
+	RegisterProvider("test-stub", func(config *Config, httpClient *SidebandHTTPClient, parsedURL *ParsedURL) (PolicyProvider, error) {
+		return stubProvider{}, nil
+	})
+
+	provider, err := newProvider(&Config{ProviderType: "test-stub"}, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := provider.(stubProvider); !ok {
+		t.Errorf("expected stubProvider, got %T", provider)
+	}
+
+	found := false
+	for _, name := range RegisteredProviders() {
+		if name == "test-stub" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("expected test-stub in RegisteredProviders")
+	}
+}
+
+func TestNewProvider_DefaultsToSideband(t *testing.T) {
+	conf := &Config{ServiceURL: "https://paz.example.com"}
+	parsed, _ := ParseURL(conf.ServiceURL)
+
+	provider, err := newProvider(conf, NewSidebandHTTPClient(conf), parsed)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := provider.(*SidebandProvider); !ok {
+		t.Errorf("expected *SidebandProvider, got %T", provider)
+	}
+}
+
+func TestNewProvider_Unknown(t *testing.T) {
+	if _, err := newProvider(&Config{ProviderType: "does-not-exist"}, nil, nil); err == nil {
+		t.Fatal("expected error for unknown provider")
+	}
+}
+
+func TestRegisterProvider_Panics(t *testing.T) {
+	factory := func(config *Config, httpClient *SidebandHTTPClient, parsedURL *ParsedURL) (PolicyProvider, error) {
+		return stubProvider{}, nil
+	}
+
+	tests := []struct {
+		name     string
+		register func()
+	}{
+		{"empty name", func() { RegisterProvider("", factory) }},
+		{"nil factory", func() { RegisterProvider("nil-factory", nil) }},
+		{"duplicate", func() { RegisterProvider(ProviderSideband, factory) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("expected panic")
+				}
+			}()
+			tt.register()
+		})
+	}
+}
+
+func TestRegisterProviderValidator(t *testing.T) {
+	RegisterProvider("test-validated", func(config *Config, httpClient *SidebandHTTPClient, parsedURL *ParsedURL) (PolicyProvider, error) {
+		return stubProvider{}, nil
+	})
+	RegisterProviderValidator("test-validated", func(config *Config) error {
+		if config.ProviderOptions["tenant"] == "" {
+			return fmt.Errorf("provider_options.tenant is required")
+		}
+		return nil
+	})
+
+	conf := NewConfig()
+	conf.ServiceURL = "https://paz.example.com"
+	conf.SharedSecret = "secret"
+	conf.SecretHeaderName = "X-Secret"
+	if err := conf.Validate(); err != nil {
+		t.Fatalf("validator must only run for the selected provider: %v", err)
+	}
+
+	conf.ProviderType = "test-validated"
+	if err := conf.Validate(); err == nil || !strings.Contains(err.Error(), "tenant") {
+		t.Errorf("expected provider validation error, got %v", err)
+	}
+	conf.ProviderOptions = map[string]string{"tenant": "acme"}
+	if err := conf.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestRegisterProviderValidator_Panics(t *testing.T) {
+	validate := func(config *Config) error { return nil }
+
+	tests := []struct {
+		name     string
+		register func()
+	}{
+		{"nil validator", func() { RegisterProviderValidator(ProviderSideband, nil) }},
+		{"unregistered", func() { RegisterProviderValidator("does-not-exist", validate) }},
+		{"duplicate", func() { RegisterProviderValidator(ProviderAuthZen, validate) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("expected panic")
+				}
+			}()
+			tt.register()
+		})
+	}
+}
@@ -0,0 +1,355 @@
+package pingauthorize
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRedisKeyPrefix  = "paz:"
+	defaultRedisTimeoutMs  = 250
+	defaultRedisStateTTLMs = 300000
+
+	// redisMaxIdleConns is the number of idle connections kept per client.
+	redisMaxIdleConns = 8
+	// redisMaxBulkBytes bounds the size of a bulk string reply.
+	redisMaxBulkBytes = 16 << 20
+)
+
+// Stores selectable with decision_cache_store, state_store and circuit_breaker_store.
+const (
+	storeMemory = "memory"
+	storeKong   = "kong"
+	storeRedis  = "redis"
+)
+
+// redisError is an error reply from Redis.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// redisClient is a minimal RESP2 client for the GET, SET and DEL commands the plugin needs,
+// with a small pool of connections authenticated and switched to the configured database.
+type redisClient struct {
+	addr      string
+	tlsConfig *tls.Config
+	username  string
+	password  string
+	database  int
+	timeout   time.Duration
+
+	mu   sync.Mutex
+	idle []*redisConn
+}
+
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// newRedisClient creates a client for the redis_* settings of config.
+func newRedisClient(config *Config) *redisClient {
+	c := &redisClient{
+		addr:     config.RedisAddress,
+		username: config.RedisUsername,
+		password: config.RedisPassword,
+		database: config.RedisDatabase,
+		timeout:  time.Duration(config.RedisTimeoutMs) * time.Millisecond,
+	}
+	if c.timeout <= 0 {
+		c.timeout = defaultRedisTimeoutMs * time.Millisecond
+	}
+	if config.RedisTLS {
+		host, _, _ := net.SplitHostPort(config.RedisAddress)
+		c.tlsConfig = &tls.Config{ServerName: host, InsecureSkipVerify: !config.RedisTLSVerify}
+	}
+	return c
+}
+
+// get returns the value of key, or nil if it does not exist.
+func (c *redisClient) get(ctx context.Context, key string) ([]byte, error) {
+	reply, err := c.do(ctx, "GET", key)
+	if err != nil {
+		return nil, err
+	}
+	value, _ := reply.([]byte)
+	return value, nil
+}
+
+// set stores value under key, expiring after ttl.
+func (c *redisClient) set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	ms := ttl.Milliseconds()
+	if ms < 1 {
+		ms = 1
+	}
+	_, err := c.do(ctx, "SET", key, string(value), "PX", strconv.FormatInt(ms, 10))
+	return err
+}
+
+// del removes key.
+func (c *redisClient) del(ctx context.Context, key string) error {
+	_, err := c.do(ctx, "DEL", key)
+	return err
+}
+
+// do sends one command and reads its reply. Connections that fail are closed rather than
+// returned to the pool; error replies leave the connection usable.
+func (c *redisClient) do(ctx context.Context, args ...string) (interface{}, error) {
+	conn, err := c.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := conn.roundTrip(c.deadline(ctx), args...)
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		conn.Close()
+		return nil, err
+	}
+	c.release(conn)
+	return reply, err
+}
+
+// deadline returns the earlier of the client timeout and the context deadline.
+func (c *redisClient) deadline(ctx context.Context) time.Time {
+	deadline := time.Now().Add(c.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		return d
+	}
+	return deadline
+}
+
+// conn returns an idle connection or dials a new one.
+func (c *redisClient) conn(ctx context.Context) (*redisConn, error) {
+	c.mu.Lock()
+	if n := len(c.idle); n > 0 {
+		conn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return conn, nil
+	}
+	c.mu.Unlock()
+
+	dialer := &net.Dialer{Deadline: c.deadline(ctx)}
+	var raw net.Conn
+	var err error
+	if c.tlsConfig != nil {
+		raw, err = (&tls.Dialer{NetDialer: dialer, Config: c.tlsConfig}).DialContext(ctx, "tcp", c.addr)
+	} else {
+		raw, err = dialer.DialContext(ctx, "tcp", c.addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("redis: failed to connect to %s: %w", c.addr, err)
+	}
+	conn := &redisConn{Conn: raw, r: bufio.NewReader(raw)}
+
+	if c.password != "" {
+		args := []string{"AUTH", c.password}
+		if c.username != "" {
+			args = []string{"AUTH", c.username, c.password}
+		}
+		if _, err := conn.roundTrip(c.deadline(ctx), args...); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if c.database != 0 {
+		if _, err := conn.roundTrip(c.deadline(ctx), "SELECT", strconv.Itoa(c.database)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// release returns conn to the pool, closing it if the pool is full.
+func (c *redisClient) release(conn *redisConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.idle) >= redisMaxIdleConns {
+		conn.Close()
+		return
+	}
+	c.idle = append(c.idle, conn)
+}
+
+// roundTrip writes a command as a RESP array of bulk strings and reads the reply.
+func (conn *redisConn) roundTrip(deadline time.Time, args ...string) (interface{}, error) {
+	conn.SetDeadline(deadline)
+	buf := make([]byte, 0, 64)
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+	if _, err := conn.Write(buf); err != nil {
+		return nil, err
+	}
+	return readRedisReply(conn.r)
+}
+
+// readRedisReply reads one RESP2 reply: a string for simple strings, int64 for integers,
+// []byte (nil for a null bulk string) for bulk strings and []interface{} for arrays.
+func readRedisReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, line := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return line, nil
+	case '-':
+		return nil, redisError(line)
+	case ':':
+		return strconv.ParseInt(line, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line)
+		if err != nil || n > redisMaxBulkBytes {
+			return nil, fmt.Errorf("redis: invalid bulk length %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid array length %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		var items []interface{}
+		for i := 0; i < n; i++ {
+			item, err := readRedisReply(r)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply type %q", kind)
+	}
+}
+
+// redisState is the per-request context stored by the redis and cookie state stores.
+type redisState struct {
+	Request *SidebandAccessRequest `json:"request"`
+	State   json.RawMessage        `json:"state,omitempty"`
+}
+
+// storeRedisState stores the original request and state for the response phase and returns the key.
+func storeRedisState(ctx context.Context, conf *Config, originalRequest *SidebandAccessRequest, state json.RawMessage) (string, error) {
+	data, err := json.Marshal(&redisState{Request: originalRequest, State: state})
+	if err != nil {
+		return "", err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	key := conf.RedisKeyPrefix + "state:" + hex.EncodeToString(id)
+	ttl := time.Duration(conf.RedisStateTTLMs) * time.Millisecond
+	if ttl <= 0 {
+		ttl = defaultRedisStateTTLMs * time.Millisecond
+	}
+	if err := conf.getRedisClient().set(ctx, key, data, ttl); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// loadRedisState loads the per-request context stored under key and deletes it in the background.
+func loadRedisState(ctx context.Context, conf *Config, key string) (*SidebandAccessRequest, json.RawMessage, error) {
+	client := conf.getRedisClient()
+	data, err := client.get(ctx, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load state from Redis: %w", err)
+	}
+	if data == nil {
+		return nil, nil, fmt.Errorf("state %s not found in Redis (expired?)", key)
+	}
+	go client.del(context.Background(), key)
+
+	var s redisState
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal state from Redis: %w", err)
+	}
+	if s.Request == nil {
+		s.Request = &SidebandAccessRequest{}
+	}
+	return s.Request, s.State, nil
+}
+
+// getRedisClient returns the lazily-created Redis client of the config.
+func (c *Config) getRedisClient() *redisClient {
+	c.redisOnce.Do(func() {
+		c.redis = newRedisClient(c)
+	})
+	return c.redis
+}
+
+// validateRedis checks the decision_cache_store, state_store, circuit_breaker_store and redis_*
+// settings.
+func validateRedis(c *Config) error {
+	switch c.DecisionCacheStore {
+	case "", storeMemory, storeRedis:
+	default:
+		return fmt.Errorf("decision_cache_store must be memory or redis, got %q", c.DecisionCacheStore)
+	}
+	switch c.StateStore {
+	case "", storeKong, storeMemory, storeRedis:
+	case storeCookie:
+		if c.StateStoreSecret == "" {
+			return fmt.Errorf("state_store_secret is required when state_store is cookie")
+		}
+	default:
+		return fmt.Errorf("state_store must be kong, memory, redis or cookie, got %q", c.StateStore)
+	}
+	switch c.CircuitBreakerStore {
+	case "", storeMemory, storeRedis:
+	default:
+		return fmt.Errorf("circuit_breaker_store must be memory or redis, got %q", c.CircuitBreakerStore)
+	}
+	if c.DecisionCacheStore != storeRedis && c.StateStore != storeRedis && c.CircuitBreakerStore != storeRedis {
+		return nil
+	}
+	if c.RedisAddress == "" {
+		return fmt.Errorf("redis_address is required when decision_cache_store, state_store or circuit_breaker_store is redis")
+	}
+	if _, _, err := net.SplitHostPort(c.RedisAddress); err != nil {
+		return fmt.Errorf("redis_address must be host:port: %w", err)
+	}
+	if c.RedisDatabase < 0 {
+		return fmt.Errorf("redis_database must be >= 0, got %d", c.RedisDatabase)
+	}
+	if c.RedisTimeoutMs < 0 || c.RedisStateTTLMs < 0 {
+		return fmt.Errorf("redis_timeout_ms and redis_state_ttl_ms must be >= 0")
+	}
+	return nil
+}
@@ -0,0 +1,299 @@
+package pingauthorize
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is an in-process server for the commands redisClient sends.
+type fakeRedis struct {
+	ln       net.Listener
+	password string
+
+	mu       sync.Mutex
+	data     map[string]string
+	ttls     map[string]string
+	commands []string
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{ln: ln, password: password, data: make(map[string]string), ttls: make(map[string]string)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return f
+}
+
+func (f *fakeRedis) addr() string { return f.ln.Addr().String() }
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	authed := f.password == ""
+	for {
+		reply, err := readRedisReply(r)
+		if err != nil {
+			return
+		}
+		items, _ := reply.([]interface{})
+		args := make([]string, len(items))
+		for i, item := range items {
+			args[i] = string(item.([]byte))
+		}
+
+		f.mu.Lock()
+		f.commands = append(f.commands, args[0])
+		var out string
+		switch {
+		case args[0] == "AUTH":
+			if args[len(args)-1] == f.password {
+				authed, out = true, "+OK\r\n"
+			} else {
+				out = "-WRONGPASS invalid password\r\n"
+			}
+		case !authed:
+			out = "-NOAUTH Authentication required.\r\n"
+		case args[0] == "SELECT":
+			out = "+OK\r\n"
+		case args[0] == "GET":
+			if v, ok := f.data[args[1]]; ok {
+				out = fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+			} else {
+				out = "$-1\r\n"
+			}
+		case args[0] == "SET":
+			f.data[args[1]] = args[2]
+			f.ttls[args[1]] = args[4]
+			out = "+OK\r\n"
+		case args[0] == "DEL":
+			delete(f.data, args[1])
+			out = ":1\r\n"
+		default:
+			out = "-ERR unknown command\r\n"
+		}
+		f.mu.Unlock()
+		conn.Write([]byte(out))
+	}
+}
+
+func TestReadRedisReply(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    interface{}
+		wantErr bool
+	}{
+		{"+OK\r\n", "OK", false},
+		{":42\r\n", int64(42), false},
+		{"$5\r\nhello\r\n", []byte("hello"), false},
+		{"$-1\r\n", nil, false},
+		{"*2\r\n$1\r\na\r\n:1\r\n", []interface{}{[]byte("a"), int64(1)}, false},
+		{"-ERR bad\r\n", nil, true},
+		{"$99999999999\r\n", nil, true},
+		{"?x\r\n", nil, true},
+		{"+OK\n", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := readRedisReply(bufio.NewReader(strings.NewReader(tt.in)))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: err = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRedisClient(t *testing.T) {
+	f := newFakeRedis(t, "s3cret")
+	ctx := context.Background()
+
+	conf := NewConfig()
+	conf.RedisAddress = f.addr()
+	conf.RedisUsername = "paz"
+	conf.RedisPassword = "s3cret"
+	conf.RedisDatabase = 2
+	c := newRedisClient(conf)
+
+	if err := c.set(ctx, "k", []byte("v"), 1500*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	v, err := c.get(ctx, "k")
+	if err != nil || string(v) != "v" {
+		t.Fatalf("get = %q, %v", v, err)
+	}
+	if v, err := c.get(ctx, "missing"); err != nil || v != nil {
+		t.Errorf("expected nil for a missing key, got %q, %v", v, err)
+	}
+	if err := c.del(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+
+	f.mu.Lock()
+	commands := strings.Join(f.commands, " ")
+	ttl := f.ttls["k"]
+	f.mu.Unlock()
+	if commands != "AUTH SELECT SET GET GET DEL" {
+		t.Errorf("expected one authenticated connection to be reused, got %s", commands)
+	}
+	if ttl != "1500" {
+		t.Errorf("expected PX 1500, got %s", ttl)
+	}
+
+	conf.RedisPassword = "wrong"
+	if _, err := newRedisClient(conf).get(ctx, "k"); err == nil || !strings.Contains(err.Error(), "WRONGPASS") {
+		t.Errorf("expected auth error, got %v", err)
+	}
+}
+
+func TestValidateRedis(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(c *Config)
+		wantErr string
+	}{
+		{"defaults", func(c *Config) {}, ""},
+		{"redis stores", func(c *Config) {
+			c.DecisionCacheStore, c.StateStore, c.RedisAddress = storeRedis, storeRedis, "redis:6379"
+		}, ""},
+		{"unknown cache store", func(c *Config) { c.DecisionCacheStore = "memcached" }, "decision_cache_store"},
+		{"unknown state store", func(c *Config) { c.StateStore = "memcached" }, "state_store"},
+		{"memory state store", func(c *Config) { c.StateStore = storeMemory }, ""},
+		{"cookie state store", func(c *Config) { c.StateStore, c.StateStoreSecret = storeCookie, "k" }, ""},
+		{"cookie without secret", func(c *Config) { c.StateStore = storeCookie }, "state_store_secret is required"},
+		{"missing address", func(c *Config) { c.StateStore = storeRedis }, "redis_address is required"},
+		{"address without port", func(c *Config) {
+			c.StateStore, c.RedisAddress = storeRedis, "redis"
+		}, "redis_address must be host:port"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := NewConfig()
+			conf.ServiceURL = "https://paz.example.com"
+			conf.SharedSecret = "secret"
+			conf.SecretHeaderName = "Authorization"
+			tt.modify(conf)
+			err := conf.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRedisState(t *testing.T) {
+	f := newFakeRedis(t, "")
+	conf := NewConfig()
+	conf.RedisAddress = f.addr()
+	conf.RedisKeyPrefix = "test:"
+	ctx := context.Background()
+
+	req := &SidebandAccessRequest{Method: "GET", URL: "http://api.example.com:80/"}
+	key, err := storeRedisState(ctx, conf, req, json.RawMessage(`{"s":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(key, "test:state:") {
+		t.Errorf("unexpected key %q", key)
+	}
+
+	got, state, err := loadRedisState(ctx, conf, key)
+	if err != nil || got.URL != req.URL || string(state) != `{"s":1}` {
+		t.Fatalf("unexpected state: %+v %s %v", got, state, err)
+	}
+
+	if _, _, err := loadRedisState(ctx, conf, "test:state:missing"); err == nil {
+		t.Error("expected error for a missing key")
+	}
+}
+
+func TestMiddleware_RedisDecisionCache(t *testing.T) {
+	f := newFakeRedis(t, "")
+	sidebandCalls := 0
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		sidebandCalls++
+		var req SidebandAccessRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		headers := append(req.Headers, map[string]string{"x-user": "alice"})
+		json.NewEncoder(w).Encode(SidebandAccessResponse{Method: req.Method, URL: req.URL, Body: &req.Body, Headers: headers})
+	})
+	defer server.Close()
+
+	// Two middlewares with the same config stand in for two Kong nodes
+	node := func() http.Handler {
+		m, err := NewMiddleware(&Config{
+			ServiceURL:         server.URL,
+			SharedSecret:       "test-secret",
+			SecretHeaderName:   "X-Secret",
+			SkipResponsePhase:  true,
+			DecisionCacheTTLMs: 60000,
+			DecisionCacheStore: storeRedis,
+			RedisAddress:       f.addr(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		calls := 0
+		return m.Handler(upstreamEcho(t, &calls))
+	}
+	first, second := node(), node()
+
+	for _, h := range []http.Handler{first, second} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "http://api.example.com/orders", nil))
+		if rec.Code != 200 || rec.Header().Get("X-Upstream-User") != "alice" {
+			t.Errorf("expected allowed request with policy header, got %d %v", rec.Code, rec.Header())
+		}
+	}
+	if sidebandCalls != 1 {
+		t.Errorf("expected the decision to be shared through Redis, got %d sideband calls", sidebandCalls)
+	}
+
+	// A Redis outage degrades to uncached evaluation
+	f.ln.Close()
+	f.mu.Lock()
+	f.data = map[string]string{}
+	f.mu.Unlock()
+	m, _ := NewMiddleware(&Config{
+		ServiceURL:         server.URL,
+		SharedSecret:       "test-secret",
+		SecretHeaderName:   "X-Secret",
+		SkipResponsePhase:  true,
+		DecisionCacheTTLMs: 60000,
+		DecisionCacheStore: storeRedis,
+		RedisAddress:       f.addr(),
+	})
+	calls := 0
+	rec := httptest.NewRecorder()
+	m.Handler(upstreamEcho(t, &calls)).ServeHTTP(rec, httptest.NewRequest("GET", "http://api.example.com/orders", nil))
+	if rec.Code != 200 || sidebandCalls != 2 {
+		t.Errorf("expected evaluation without Redis, got %d (%d sideband calls)", rec.Code, sidebandCalls)
+	}
+}
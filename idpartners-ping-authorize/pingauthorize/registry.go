@@ -0,0 +1,142 @@
+package pingauthorize
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// endpointKey identifies sideband connections that can share a transport and circuit breaker.
+// Configs with the same key talk to the same endpoint with the same TLS and auth settings.
+type endpointKey struct {
+	serviceURL       string
+	verifyCert       bool
+	http2            bool
+	keepaliveMs      int
+	secretHeaderName string
+	secretHash       [sha256.Size]byte
+	clientCertHash   [sha256.Size]byte
+	oauthHash        [sha256.Size]byte
+	breakerEnabled   bool
+	breakerMode      string
+	breakerStore     [sha256.Size]byte
+	healthCheck      string
+}
+
+// newEndpointKey derives the registry key for a config.
+func newEndpointKey(config *Config) endpointKey {
+	oauth := append([]string{config.SidebandOAuthTokenURL, config.SidebandOAuthClientID, config.SidebandOAuthClientSecret},
+		config.SidebandOAuthScopes...)
+	return endpointKey{
+		serviceURL:       strings.TrimRight(config.ServiceURL, "/"),
+		verifyCert:       config.VerifyServiceCert,
+		http2:            config.SidebandHTTP2,
+		keepaliveMs:      config.ConnectionKeepaliveMs,
+		secretHeaderName: strings.ToLower(config.SecretHeaderName),
+		secretHash:       sha256.Sum256([]byte(config.SharedSecret + "\x00" + config.SharedSecretSecondary)),
+		clientCertHash:   sha256.Sum256([]byte(config.SidebandClientCert + "\x00" + config.SidebandClientKey)),
+		oauthHash:        sha256.Sum256([]byte(strings.Join(oauth, "\x00"))),
+		breakerEnabled:   config.CircuitBreakerEnabled,
+		breakerMode: fmt.Sprint(config.CircuitBreakerMode, config.CircuitBreakerWindowSize, config.CircuitBreakerFailureRate,
+			config.CircuitBreakerMinCalls),
+		breakerStore: sha256.Sum256([]byte(fmt.Sprint(config.CircuitBreakerStore, config.CircuitBreakerSyncIntervalMs, config.RedisAddress,
+			config.RedisUsername, config.RedisPassword, config.RedisDatabase, config.RedisTLS, config.RedisTLSVerify, config.RedisKeyPrefix,
+			config.RedisTimeoutMs))),
+		healthCheck: fmt.Sprint(config.HealthCheckPath, config.HealthCheckIntervalMs, config.HealthCheckTimeoutMs,
+			config.HealthCheckUnhealthyThreshold, config.HealthCheckHealthyThreshold),
+	}
+}
+
+// sharedEndpoint is the transport, circuit breakers, access token source and health checker shared
+// by all configs with the same key.
+type sharedEndpoint struct {
+	transport sidebandTransport
+	cb        *circuitBreakers
+	tokens    *oauthTokenSource // nil unless sideband_oauth_token_url is set
+	health    *healthChecker    // nil unless health_check_path is set
+	refs      int
+}
+
+// endpointRegistry is a process-level, reference-counted registry of shared endpoints.
+type endpointRegistry struct {
+	mu      sync.Mutex
+	entries map[endpointKey]*sharedEndpoint
+}
+
+// globalEndpoints is shared by all plugin instances in the plugin server process.
+var globalEndpoints = newEndpointRegistry()
+
+func newEndpointRegistry() *endpointRegistry {
+	return &endpointRegistry{entries: make(map[endpointKey]*sharedEndpoint)}
+}
+
+// endpointLease releases a registry reference when the owning config is garbage collected.
+// It deliberately holds no reference to the Config so the finalizer can run.
+type endpointLease struct {
+	registry *endpointRegistry
+	key      endpointKey
+	once     sync.Once
+}
+
+// Release drops the lease's reference. Safe to call more than once.
+func (l *endpointLease) Release() {
+	l.once.Do(func() { l.registry.release(l.key) })
+}
+
+// newClient returns a client for config backed by the shared transport and breaker for its endpoint.
+// Kong offers no hook when a plugin config is removed, so the reference is released by a finalizer
+// on the returned lease, which the config keeps alive for as long as it is in use.
+func (r *endpointRegistry) newClient(config *Config) (*SidebandHTTPClient, *endpointLease) {
+	key := newEndpointKey(config)
+
+	r.mu.Lock()
+	entry, ok := r.entries[key]
+	if !ok {
+		entry = &sharedEndpoint{
+			transport: newSidebandTransport(config),
+			cb:        newCircuitBreakers(config),
+			tokens:    newOAuthTokenSource(config),
+		}
+		if entry.health = newHealthChecker(config, entry.transport); entry.health != nil {
+			entry.health.start()
+		}
+		r.entries[key] = entry
+	}
+	entry.refs++
+	r.mu.Unlock()
+
+	lease := &endpointLease{registry: r, key: key}
+	runtime.SetFinalizer(lease, (*endpointLease).Release)
+
+	client := newSidebandHTTPClient(config, entry.transport, entry.cb, entry.tokens)
+	client.health = entry.health
+	return client, lease
+}
+
+// release drops one reference to key, closing idle connections when the last user goes away.
+func (r *endpointRegistry) release(key endpointKey) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.entries[key]
+	if !ok {
+		return
+	}
+	entry.refs--
+	if entry.refs <= 0 {
+		entry.transport.CloseIdleConnections()
+		if entry.health != nil {
+			entry.health.close()
+		}
+		delete(r.entries, key)
+	}
+}
+
+// size returns the number of shared endpoints currently registered.
+func (r *endpointRegistry) size() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.entries)
+}
@@ -0,0 +1,102 @@
+package pingauthorize
+
+import (
+	"testing"
+)
+
+func registryTestConfig(url string) *Config {
+	return &Config{
+		ServiceURL:            url,
+		SharedSecret:          "secret",
+		SecretHeaderName:      "X-Secret",
+		ConnectionTimeoutMs:   5000,
+		ConnectionKeepaliveMs: 60000,
+		CircuitBreakerEnabled: true,
+		RetryBackoffMs:        10,
+	}
+}
+
+func TestEndpointRegistry_SharesByKey(t *testing.T) {
+	r := newEndpointRegistry()
+
+	a := registryTestConfig("https://paz.example.com")
+	b := registryTestConfig("https://paz.example.com/")
+	b.MaxRetries = 3
+
+	clientA, leaseA := r.newClient(a)
+	clientB, leaseB := r.newClient(b)
+
+	if clientA.cb != clientB.cb {
+		t.Error("expected configs with the same endpoint to share a circuit breaker")
+	}
+	if clientA.client.Transport != clientB.client.Transport {
+		t.Error("expected configs with the same endpoint to share a transport")
+	}
+	if clientB.config.MaxRetries != 3 {
+		t.Error("expected per-config retry settings to be kept")
+	}
+	if r.size() != 1 {
+		t.Errorf("expected 1 shared endpoint, got %d", r.size())
+	}
+
+	leaseA.Release()
+	if r.size() != 1 {
+		t.Error("endpoint should stay registered while still referenced")
+	}
+	leaseB.Release()
+	if r.size() != 0 {
+		t.Errorf("expected endpoint to be removed after last release, got %d", r.size())
+	}
+}
+
+func TestEndpointRegistry_DistinctKeys(t *testing.T) {
+	r := newEndpointRegistry()
+
+	base := registryTestConfig("https://paz.example.com")
+	otherSecret := registryTestConfig("https://paz.example.com")
+	otherSecret.SharedSecret = "other"
+	verifyCert := registryTestConfig("https://paz.example.com")
+	verifyCert.VerifyServiceCert = true
+	otherURL := registryTestConfig("https://paz2.example.com")
+
+	c1, _ := r.newClient(base)
+	c2, _ := r.newClient(otherSecret)
+	c3, _ := r.newClient(verifyCert)
+	c4, _ := r.newClient(otherURL)
+
+	if c1.cb == c2.cb || c1.cb == c3.cb || c1.cb == c4.cb {
+		t.Error("expected distinct breakers for distinct endpoint keys")
+	}
+	if r.size() != 4 {
+		t.Errorf("expected 4 shared endpoints, got %d", r.size())
+	}
+}
+
+func TestEndpointRegistry_SharedBreakerTrips(t *testing.T) {
+	r := newEndpointRegistry()
+
+	clientA, _ := r.newClient(registryTestConfig("https://paz.example.com"))
+	clientB, _ := r.newClient(registryTestConfig("https://paz.example.com"))
+
+	clientA.cb.get("/sideband/request").Trip(Trigger5xx, 30)
+	if clientB.cb.get("/sideband/request").IsClosed() {
+		t.Error("expected trip via one config to be visible to the other")
+	}
+}
+
+func TestEndpointLease_ReleaseIdempotent(t *testing.T) {
+	r := newEndpointRegistry()
+
+	_, lease1 := r.newClient(registryTestConfig("https://paz.example.com"))
+	_, lease2 := r.newClient(registryTestConfig("https://paz.example.com"))
+
+	lease1.Release()
+	lease1.Release()
+	if r.size() != 1 {
+		t.Error("double release must not drop another config's reference")
+	}
+	lease2.Release()
+	if r.size() != 0 {
+		t.Errorf("expected empty registry, got %d", r.size())
+	}
+}
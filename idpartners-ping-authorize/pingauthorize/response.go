@@ -0,0 +1,489 @@
+package pingauthorize
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Kong/go-pdk"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/idpartners/idpartners-ping-authorize/pingauthorize/decisionctx"
+)
+
+// Status code to status string mapping per DESIGN.md §4.3.2
+var statusStrings = map[int]string{
+	200: "OK",
+	400: "BAD REQUEST",
+	401: "UNAUTHORIZED",
+	404: "NOT FOUND",
+	413: "PAYLOAD TOO LARGE",
+	429: "TOO MANY REQUESTS",
+	500: "INTERNAL SERVER ERROR",
+	503: "SERVICE UNAVAILABLE",
+}
+
+// Headers that are always preserved from the upstream response, even if not in PingAuthorize's response.
+var preservedResponseHeaders = map[string]bool{
+	"content-length": true,
+	"date":           true,
+	"connection":     true,
+	"vary":           true,
+}
+
+// getStatusString returns the human-readable status text for a status code.
+func getStatusString(code int) string {
+	if s, ok := statusStrings[code]; ok {
+		return s
+	}
+	return ""
+}
+
+// executeResponse implements the response phase logic.
+func executeResponse(kong *pdk.PDK, conf *Config) {
+	logger := NewPluginLogger(kong, "response", conf.ServiceURL)
+
+	// Requests skipped in the access phase are not evaluated in the response phase either
+	if skipped, _ := kong.Ctx.GetSharedString("paz_skipped"); skipped == "true" {
+		return
+	}
+	if conf.EnableDebugLogging {
+		skipped, _ := kong.Ctx.GetSharedString("paz_debug_skipped")
+		logger.skipPayloads = skipped == "true"
+	}
+
+	phase := &decisionctx.Phase{Shadow: conf.ShadowMode}
+	var mcp *decisionctx.MCP
+	record := loadDecisionContext(kong)
+	if record != nil {
+		record.Response = phase
+		mcp = record.MCP
+		defer storeDecisionContext(kong, record)
+	}
+	defer conf.metrics().recordDecision(conf.ServiceURL, "response", phase, mcp, conf.MCPMetricsMaxLabelValues)
+	defer conf.publishDecision("response", record, phase, kongHeader(kong))
+	_, span := startPhaseSpan(context.Background(), conf, "response", kongTraceHeaders(kong))
+	defer func() { endPhaseSpan(span, phase) }()
+	defer func() {
+		if phase.Decision == decisionctx.DecisionSkip || phase.FailOpen {
+			passThroughMCPBatchErrors(kong, conf)
+		}
+	}()
+	fail := func(status int, err error) {
+		phase.Decision, phase.StatusCode, phase.Error = decisionctx.DecisionError, status, err.Error()
+		exitKong(kong, conf, status, nil, nil)
+	}
+
+	if len(conf.ResponsePhaseStatusCodes) > 0 {
+		status, err := kong.ServiceResponse.GetStatus()
+		if err != nil {
+			logger.Err("Failed to get upstream response status", "error", err.Error())
+			fail(500, err)
+			return
+		}
+		if !matchStatusCode(status, conf.ResponsePhaseStatusCodes) {
+			logger.Debug("Upstream status not in response_phase_status_codes, skipping response phase", "status", status)
+			phase.Decision = decisionctx.DecisionSkip
+			return
+		}
+	}
+
+	if conf.ResponsePhaseMCPOnly {
+		requestBody, err := kong.Request.GetRawBody()
+		if err != nil {
+			logger.Err("Failed to get request body", "error", err.Error())
+			fail(500, err)
+			return
+		}
+		if !IsMCPRequest(requestBody) {
+			logger.Debug("Non-MCP request, skipping response phase")
+			phase.Decision = decisionctx.DecisionSkip
+			return
+		}
+	}
+
+	parsedURL, err := ParseURL(conf.ServiceURL)
+	if err != nil {
+		logger.Err("Failed to parse service URL", "error", err.Error())
+		fail(500, err)
+		return
+	}
+
+	originalRequest, state, err := loadPerRequestContext(kong, conf)
+	if err != nil {
+		logger.Err("Failed to load per-request context", "error", err.Error())
+		fail(500, err)
+		return
+	}
+
+	payload, encoded, err := composeResponsePayload(kong, conf, originalRequest, state, parsedURL, logger)
+	if err != nil {
+		logger.Err("Failed to compose response payload", "error", err.Error())
+		fail(500, err)
+		return
+	}
+
+	var requestID json.RawMessage
+	if mcp != nil {
+		requestID = mcp.ID
+	}
+	if status, body, headers, blocked := checkMCPResponseID(conf, requestID, payload, logger); blocked {
+		phase.Decision, phase.Reason, phase.StatusCode = decisionctx.DecisionDeny, decisionctx.ReasonResponseIDMismatch, status
+		exitKong(kong, conf, status, body, headers)
+		return
+	}
+
+	DebugLogPayload(logger, "Sending sideband response", payload, conf)
+
+	httpClient := conf.getHTTPClient()
+	provider, err := newProvider(conf, httpClient, parsedURL)
+	if err != nil {
+		logger.Err("Failed to create policy provider", "error", err.Error())
+		fail(500, err)
+		return
+	}
+
+	var spent time.Duration
+	if record != nil {
+		spent = accessLatency(record.Access.LatencyMs)
+	}
+	ctx, cancel := phaseContext(trace.ContextWithSpan(forwardHeadersContext(kong, conf), span), conf, "response", spent)
+	defer cancel()
+	start := time.Now()
+	result, err := evaluateResponse(ctx, conf, provider, payload, logger)
+	phase.LatencyMs = latencyMs(time.Since(start))
+	if err != nil {
+		recordSidebandFailure(phase, err)
+
+		// Check circuit breaker error
+		if cbErr, ok := err.(*CircuitBreakerOpenError); ok {
+			if status := handleCircuitBreakerErrorResponse(kong, cbErr, conf); status != 0 {
+				phase.Decision, phase.StatusCode = decisionctx.DecisionError, status
+			} else {
+				phase.Decision, phase.FailOpen = decisionctx.DecisionAllow, true
+			}
+			return
+		}
+
+		if thErr, ok := err.(*SidebandThrottledError); ok {
+			if conf.SidebandRateLimitFailOpen {
+				logger.Warn("Sideband call throttled by sideband_rate_limit, passing upstream response through")
+				phase.Decision, phase.FailOpen = decisionctx.DecisionAllow, true
+				return
+			}
+			body, headers := throttledResponse(thErr)
+			phase.Decision, phase.StatusCode = decisionctx.DecisionError, 429
+			exitKong(kong, conf, 429, body, headers)
+			return
+		}
+
+		// Check passthrough
+		if httpErr, ok := err.(*sidebandHTTPError); ok {
+			if isPassthroughCode(httpErr.StatusCode, conf) {
+				phase.Decision, phase.StatusCode = decisionctx.DecisionError, httpErr.StatusCode
+				exitKong(kong, conf, httpErr.StatusCode, httpErr.Body,
+					map[string][]string{"Content-Type": {"application/json"}})
+				return
+			}
+			logger.Warn("Sideband response failed", "status", httpErr.StatusCode, "message", httpErr.Message, "id", httpErr.ID)
+		} else {
+			logger.Err("PingAuthorize unreachable during response phase", "error", err.Error())
+		}
+
+		if conf.failOpen() {
+			logger.Warn("PingAuthorize unreachable during response phase, fail-open, passing upstream response through")
+			phase.Decision, phase.FailOpen = decisionctx.DecisionAllow, true
+			return // pass upstream response through unmodified
+		}
+		phase.Decision, phase.StatusCode = decisionctx.DecisionError, 502
+		exitKong(kong, conf, 502, nil, nil)
+		return
+	}
+
+	DebugLogPayload(logger, "Received sideband response result", result, conf)
+
+	if conf.ToolsDriftDetection {
+		checkKongToolsDrift(kong, conf, result, logger)
+	}
+
+	phase.Decision, phase.Obligations = decisionctx.DecisionAllow, responseObligations(payload, result)
+	phase.StatusCode = handleResponseResult(kong, conf, result, encoded, logger)
+}
+
+// composeResponsePayload builds the JSON payload for the /sideband/response call.
+// The returned encodedBody is non-nil when the upstream body was decompressed for the payload.
+func composeResponsePayload(kong *pdk.PDK, conf *Config, originalRequest *SidebandAccessRequest, state json.RawMessage, parsedURL *ParsedURL, logger *PluginLogger) (*SidebandResponsePayload, *encodedBody, error) {
+	method, err := kong.Request.GetMethod()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get method: %w", err)
+	}
+
+	reqURL, err := buildForwardedURL(kong)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to build URL: %w", err)
+	}
+
+	// Get upstream response body (returns []byte)
+	responseBodyBytes, err := kong.ServiceResponse.GetRawBody()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get response body: %w", err)
+	}
+
+	// Get upstream response status code
+	statusCode, err := kong.ServiceResponse.GetStatus()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get response status: %w", err)
+	}
+
+	// Get upstream response headers (returns map[string][]string)
+	responseHeaders, err := kong.ServiceResponse.GetHeaders(-1)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get response headers: %w", err)
+	}
+
+	responseBodyBytes, responseHeaders, encoded, err := decodeResponseBody(conf, responseHeaders, responseBodyBytes)
+	if err != nil {
+		logger.Warn("Failed to decompress upstream response body, sending it as received", "error", err.Error())
+	}
+	if errs, _ := kong.Ctx.GetSharedString("paz_mcp_batch_errors"); errs != "" {
+		responseBodyBytes = appendMCPBatchErrors(responseBodyBytes, []byte(errs))
+	}
+
+	formattedHeaders, err := FormatHeaders(responseHeaders)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	httpVersion, err := getHTTPVersion(kong)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get HTTP version: %w", err)
+	}
+
+	payload := &SidebandResponsePayload{
+		Method:         method,
+		URL:            reqURL,
+		Body:           string(responseBodyBytes),
+		ResponseCode:   strconv.Itoa(statusCode),
+		ResponseStatus: getStatusString(statusCode),
+		Headers:        formattedHeaders,
+		HTTPVersion:    httpVersion,
+	}
+
+	if len(conf.ExtractHeaders) > 0 || len(conf.AttributeMappings) > 0 {
+		requestHeaders, err := kong.Request.GetHeaders(-1)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get request headers: %w", err)
+		}
+		if len(conf.ExtractHeaders) > 0 {
+			payload.ExtractedHeaders = ExtractHeaders(requestHeaders, conf.ExtractHeaders)
+		}
+		if len(conf.AttributeMappings) > 0 {
+			rawQuery, err := kong.Request.GetRawQuery()
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to get query: %w", err)
+			}
+			payload.Attributes = ResolveAttributes(conf.AttributeMappings, &AttributeInput{
+				Headers:  requestHeaders,
+				RawQuery: rawQuery,
+				Body:     responseBodyBytes,
+			})
+		}
+	}
+
+	if conf.IncludeUpstreamTiming {
+		payload.UpstreamTiming = kongUpstreamTiming(kong)
+	}
+	session, _ := kong.Ctx.GetSharedString("paz_mcp_session_id")
+	payload.MCPSessionID = responseMCPSession(formattedHeaders, session)
+	enrichMCPResponse(payload)
+	countResponseTokens(conf, payload)
+	if conf.ToolsListCacheVersionHeader != "" {
+		payload.cacheVersion, _ = kong.Request.GetHeader(conf.ToolsListCacheVersionHeader)
+	}
+	if conf.ToolsListCacheTTLMs > 0 {
+		authorization, _ := kong.Request.GetHeader("Authorization")
+		payload.cacheCaller = toolsListCacheCaller(kongConsumerIDs(kong), authorization)
+	}
+
+	// state and request are mutually exclusive
+	if len(state) > 0 {
+		payload.State = state
+	} else if originalRequest != nil {
+		payload.Request = originalRequest
+	}
+
+	return payload, encoded, nil
+}
+
+// checkKongToolsDrift runs tools/list drift detection for the current Kong route and MCP session.
+func checkKongToolsDrift(kong *pdk.PDK, conf *Config, result *SidebandResponseResult, logger *PluginLogger) {
+	requestBody, err := kong.Request.GetRawBody()
+	if err != nil {
+		return
+	}
+	var routeID string
+	if route, err := kong.Router.GetRoute(); err == nil {
+		routeID = route.Id
+	}
+	session, _ := kong.Request.GetHeader("Mcp-Session-Id")
+	contentType := FlattenHeaders(result.Headers)["content-type"]
+	checkToolsDrift(conf, routeID, session, requestBody, []byte(result.Body), firstValue(contentType), logger)
+}
+
+// firstValue returns the first element of values, or "".
+func firstValue(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// handleResponseResult processes the response from /sideband/response and returns the status sent.
+func handleResponseResult(kong *pdk.PDK, conf *Config, result *SidebandResponseResult, encoded *encodedBody, logger *PluginLogger) int {
+	statusCode, err := strconv.Atoi(result.ResponseCode)
+	if err != nil {
+		statusCode = 200
+	}
+
+	// Flatten response headers from PingAuthorize
+	policyHeaders := FlattenHeaders(result.Headers)
+
+	// Get current upstream response headers to remove those not in policy response
+	upstreamHeaders, err := kong.ServiceResponse.GetHeaders(-1)
+	if err == nil {
+		for name := range upstreamHeaders {
+			lowerName := strings.ToLower(name)
+			if preservedResponseHeaders[lowerName] {
+				continue
+			}
+			if _, inPolicy := policyHeaders[lowerName]; !inPolicy {
+				// Header is in upstream but not in policy response — it will be excluded
+				// since we're building a complete new response via kong.Response.Exit
+			}
+		}
+	}
+
+	logger.Info("Response phase complete", "status_code", statusCode)
+
+	body := []byte(result.Body)
+	if upstreamContentType, err := kong.ServiceResponse.GetHeader("Content-Type"); err == nil {
+		body, policyHeaders = reframeSSEResponse(upstreamContentType, body, policyHeaders)
+	}
+	body, policyHeaders = encoded.restore(conf, body, policyHeaders)
+	exitKong(kong, conf, statusCode, body, policyHeaders)
+	return statusCode
+}
+
+// passThroughMCPBatchErrors sends the upstream response with the errors of the MCP batch entries
+// the access phase denied added to it. It does nothing when no entry was denied or the upstream
+// response is not JSON.
+func passThroughMCPBatchErrors(kong *pdk.PDK, conf *Config) {
+	errs, err := kong.Ctx.GetSharedString("paz_mcp_batch_errors")
+	if err != nil || errs == "" {
+		return
+	}
+	body, err := kong.ServiceResponse.GetRawBody()
+	if err != nil {
+		return
+	}
+	merged := appendMCPBatchErrors(body, []byte(errs))
+	if bytes.Equal(merged, body) {
+		return
+	}
+	status, err := kong.ServiceResponse.GetStatus()
+	if err != nil {
+		return
+	}
+	headers, err := kong.ServiceResponse.GetHeaders(-1)
+	if err != nil {
+		return
+	}
+	for name := range headers {
+		if strings.EqualFold(name, "Content-Length") {
+			delete(headers, name)
+		}
+	}
+	exitKong(kong, conf, status, merged, headers)
+}
+
+// validStatusCodePattern reports whether p is a three-digit status code (100-599) or a class like "2xx".
+func validStatusCodePattern(p string) bool {
+	p = strings.ToLower(p)
+	if len(p) != 3 || p[0] < '1' || p[0] > '5' {
+		return false
+	}
+	if p[1:] == "xx" {
+		return true
+	}
+	_, err := strconv.Atoi(p)
+	return err == nil
+}
+
+// matchStatusCode reports whether code matches any of the status code patterns.
+func matchStatusCode(code int, patterns []string) bool {
+	codeStr := strconv.Itoa(code)
+	for _, p := range patterns {
+		p = strings.ToLower(p)
+		if p == codeStr {
+			return true
+		}
+		if len(p) == 3 && p[1:] == "xx" && len(codeStr) == 3 && p[0] == codeStr[0] {
+			return true
+		}
+	}
+	return false
+}
+
+// loadPerRequestContext retrieves the original request and state from Kong's per-request context,
+// or from the state_store if the access phase stored them there.
+func loadPerRequestContext(kong *pdk.PDK, conf *Config) (*SidebandAccessRequest, json.RawMessage, error) {
+	if key, err := kong.Ctx.GetSharedString("paz_state_key"); err == nil && key != "" {
+		store := conf.getStateStore()
+		if store == nil {
+			return nil, nil, fmt.Errorf("state %s stored, but state_store is %s", key, conf.StateStore)
+		}
+		return store.load(context.Background(), key)
+	}
+
+	reqStr, err := kong.Ctx.GetSharedString("paz_original_request")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get original request from context: %w", err)
+	}
+
+	var req SidebandAccessRequest
+	if reqStr != "" {
+		if err := json.Unmarshal([]byte(reqStr), &req); err != nil {
+			return nil, nil, fmt.Errorf("failed to unmarshal original request: %w", err)
+		}
+	}
+
+	stateStr, err := kong.Ctx.GetSharedString("paz_state")
+	var state json.RawMessage
+	if err == nil && stateStr != "" {
+		if state, err = decodeKongState(stateStr); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return &req, state, nil
+}
+
+// handleCircuitBreakerErrorResponse handles circuit breaker errors in the response phase.
+// It returns the status sent, or 0 if fail-open passed the upstream response through.
+func handleCircuitBreakerErrorResponse(kong *pdk.PDK, cbErr *CircuitBreakerOpenError, conf *Config) int {
+	if cbErr.Trigger == Trigger429 {
+		body, headers := rateLimitedResponse(cbErr)
+		exitKong(kong, conf, 429, body, headers)
+		return 429
+	}
+
+	if conf.failOpen() {
+		return 0 // pass upstream response through
+	}
+	exitKong(kong, conf, 502, nil, nil)
+	return 502
+}
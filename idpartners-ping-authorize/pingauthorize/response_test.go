@@ -1,4 +1,4 @@
-package main
+package pingauthorize
 
 import (
 	"encoding/json"
@@ -152,3 +152,45 @@ func TestPreservedResponseHeaders(t *testing.T) {
 		t.Error("x-custom should not be preserved")
 	}
 }
+
+func TestValidStatusCodePattern(t *testing.T) {
+	valid := []string{"200", "404", "2xx", "5XX", "100"}
+	invalid := []string{"", "20", "2000", "6xx", "0xx", "abc", "2x0", "x00"}
+
+	for _, p := range valid {
+		if !validStatusCodePattern(p) {
+			t.Errorf("expected %q to be valid", p)
+		}
+	}
+	for _, p := range invalid {
+		if validStatusCodePattern(p) {
+			t.Errorf("expected %q to be invalid", p)
+		}
+	}
+}
+
+func TestMatchStatusCode(t *testing.T) {
+	patterns := []string{"2xx", "304"}
+
+	tests := []struct {
+		code int
+		want bool
+	}{
+		{200, true},
+		{204, true},
+		{304, true},
+		{301, false},
+		{404, false},
+		{500, false},
+	}
+
+	for _, tt := range tests {
+		if got := matchStatusCode(tt.code, patterns); got != tt.want {
+			t.Errorf("matchStatusCode(%d) = %v, want %v", tt.code, got, tt.want)
+		}
+	}
+
+	if matchStatusCode(200, nil) {
+		t.Error("empty pattern list should not match")
+	}
+}
@@ -0,0 +1,88 @@
+package pingauthorize
+
+import (
+	"context"
+	"math"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// retryBudgetMaxTokens is the number of retries the budget allows in a burst, e.g. after a
+// quiet period. It also bounds how much earlier traffic counts towards the budget.
+const retryBudgetMaxTokens = 10
+
+// retryBudgetScale is the number of units per token; balances are kept in integer units so that
+// e.g. five deposits of 20% make exactly one retry.
+const retryBudgetScale = 10000
+
+// retryBudget caps retries to a fraction of the call volume: every call deposits ratio tokens,
+// every retry withdraws one. When PingAuthorize is degraded and most calls fail, retries stop
+// once the budget is spent instead of multiplying the load by 1+max_retries.
+type retryBudget struct {
+	mu      sync.Mutex
+	deposit int64 // units per call
+	balance int64 // units
+}
+
+// newRetryBudget creates a full budget allowing retries for percent of calls, or returns nil if
+// percent is not positive.
+func newRetryBudget(percent float64) *retryBudget {
+	if percent <= 0 {
+		return nil
+	}
+	return &retryBudget{
+		deposit: int64(math.Round(percent / 100 * retryBudgetScale)),
+		balance: retryBudgetMaxTokens * retryBudgetScale,
+	}
+}
+
+// record records a call.
+func (b *retryBudget) record() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.balance += b.deposit
+	if b.balance > retryBudgetMaxTokens*retryBudgetScale {
+		b.balance = retryBudgetMaxTokens * retryBudgetScale
+	}
+}
+
+// withdraw takes a token for a retry, reporting false if the budget is spent.
+func (b *retryBudget) withdraw() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.balance < retryBudgetScale {
+		return false
+	}
+	b.balance -= retryBudgetScale
+	return true
+}
+
+var (
+	retryBudgetCounterOnce sync.Once
+	retryBudgetExhausted   metric.Int64Counter
+)
+
+// retryBudgetCounter returns the counter of retries skipped for lack of budget, created on first use.
+func retryBudgetCounter() metric.Int64Counter {
+	retryBudgetCounterOnce.Do(func() {
+		retryBudgetExhausted, _ = otel.Meter(PluginName).Int64Counter("ping_authorize_retry_budget_exhausted_total",
+			metric.WithDescription("Sideband retries skipped because retry_budget_percent was spent"))
+	})
+	return retryBudgetExhausted
+}
+
+// recordRetryBudgetExhausted counts a retry skipped for lack of budget.
+func (c *SidebandHTTPClient) recordRetryBudgetExhausted() {
+	if c.config.EnableOtel {
+		retryBudgetCounter().Add(context.Background(), 1, metric.WithAttributes(attribute.String("service_url", c.config.ServiceURL)))
+	}
+}
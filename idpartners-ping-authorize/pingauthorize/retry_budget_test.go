@@ -0,0 +1,78 @@
+package pingauthorize
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRetryBudget(t *testing.T) {
+	if newRetryBudget(0) != nil {
+		t.Error("expected no budget for 0%")
+	}
+	var unlimited *retryBudget
+	unlimited.record()
+	if !unlimited.withdraw() {
+		t.Error("a nil budget should allow every retry")
+	}
+
+	b := newRetryBudget(20)
+	for i := 0; i < retryBudgetMaxTokens; i++ {
+		if !b.withdraw() {
+			t.Fatalf("expected the initial burst to allow retry %d", i)
+		}
+	}
+	if b.withdraw() {
+		t.Fatal("expected the budget to be spent")
+	}
+
+	// Five calls at 20% earn one retry
+	for i := 0; i < 4; i++ {
+		b.record()
+	}
+	if b.withdraw() {
+		t.Error("four calls should not earn a retry")
+	}
+	b.record()
+	if !b.withdraw() {
+		t.Error("five calls should earn a retry")
+	}
+
+	for i := 0; i < 1000; i++ {
+		b.record()
+	}
+	if b.balance != retryBudgetMaxTokens*retryBudgetScale {
+		t.Errorf("expected the budget to be capped at %d retries, got %d units", retryBudgetMaxTokens, b.balance)
+	}
+}
+
+func TestExecute_RetryBudget(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(503)
+	}))
+	defer server.Close()
+
+	parsed, _ := ParseURL(server.URL)
+	client := NewSidebandHTTPClient(&Config{
+		ServiceURL:            server.URL,
+		SharedSecret:          "secret",
+		SecretHeaderName:      "X-Secret",
+		ConnectionTimeoutMs:   5000,
+		ConnectionKeepaliveMs: 60000,
+		MaxRetries:            2,
+		RetryBackoffMs:        1,
+		RetryBudgetPercent:    20,
+	})
+
+	for i := 0; i < 50; i++ {
+		client.Execute(context.Background(), server.URL+"/sideband/request", []byte(`{}`), parsed)
+	}
+	// 50 calls, plus at most the initial burst of 10 retries and 20% of 50 calls
+	if got := calls.Load(); got > 50+retryBudgetMaxTokens+10 {
+		t.Errorf("expected retries to be capped by the budget, got %d sideband calls", got)
+	}
+}
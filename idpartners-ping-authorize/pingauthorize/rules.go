@@ -0,0 +1,150 @@
+package pingauthorize
+
+import (
+	"fmt"
+
+	"github.com/google/cel-go/cel"
+	"github.com/idpartners/idpartners-ping-authorize/pingauthorize/decisionctx"
+)
+
+// Evaluation rule actions (evaluation_rules[].action and evaluation_rules_default).
+const (
+	ruleActionEvaluate = "evaluate"
+	ruleActionSkip     = "skip"
+	ruleActionDeny     = "deny"
+)
+
+const defaultRuleDenyMessage = "Request denied"
+
+// EvaluationRule decides what happens to the requests its CEL expression matches: evaluate sends
+// them to the policy provider, skip passes them through unevaluated, and deny rejects them
+// locally. Rules see the variables of ExpressionSet plus consumer, the consumer username, custom
+// id or id ("" when there is none).
+type EvaluationRule struct {
+	Expression string `json:"expression"`
+	Action     string `json:"action"`
+	// DenyStatus and DenyMessage make up the response of deny rules, 403 "Request denied" by default.
+	DenyStatus  int    `json:"deny_status"`
+	DenyMessage string `json:"deny_message"`
+}
+
+// ruleSet holds the compiled evaluation_rules of one plugin configuration.
+type ruleSet struct {
+	rules    []compiledRule
+	fallback EvaluationRule
+}
+
+type compiledRule struct {
+	EvaluationRule
+	program cel.Program
+}
+
+// compileEvaluationRules compiles evaluation_rules. fallback is the action of requests no rule
+// matches ("" for evaluate). Returns nil when there are no rules and requests are evaluated.
+func compileEvaluationRules(rules []EvaluationRule, fallback string) (*ruleSet, error) {
+	if fallback == "" {
+		fallback = ruleActionEvaluate
+	}
+	if err := validateRuleAction(fallback); err != nil {
+		return nil, fmt.Errorf("evaluation_rules_default: %w", err)
+	}
+	if len(rules) == 0 && fallback == ruleActionEvaluate {
+		return nil, nil
+	}
+
+	env, err := newExpressionEnv(cel.Variable("consumer", cel.StringType))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CEL environment: %w", err)
+	}
+	set := &ruleSet{fallback: EvaluationRule{Action: fallback}}
+	for i, rule := range rules {
+		if err := validateRuleAction(rule.Action); err != nil {
+			return nil, fmt.Errorf("evaluation_rules[%d]: %w", i, err)
+		}
+		if rule.DenyStatus != 0 && (rule.DenyStatus < 400 || rule.DenyStatus > 599) {
+			return nil, fmt.Errorf("evaluation_rules[%d]: deny_status must be a 4xx or 5xx status, got %d", i, rule.DenyStatus)
+		}
+		prg, err := compileExpression(env, rule.Expression, cel.BoolType)
+		if err != nil {
+			return nil, fmt.Errorf("evaluation_rules[%d]: %w", i, err)
+		}
+		set.rules = append(set.rules, compiledRule{EvaluationRule: rule, program: prg})
+	}
+	return set, nil
+}
+
+func validateRuleAction(action string) error {
+	switch action {
+	case ruleActionEvaluate, ruleActionSkip, ruleActionDeny:
+		return nil
+	}
+	return fmt.Errorf("action must be evaluate, skip or deny, got %q", action)
+}
+
+// match returns the first rule matching the request, or the default rule. A rule that fails to
+// evaluate (e.g. a missing map key) does not match and is reported in errs.
+func (s *ruleSet) match(req *SidebandAccessRequest, consumer string) (rule *EvaluationRule, errs []error) {
+	activation := expressionActivation(req)
+	activation["consumer"] = consumer
+	for i := range s.rules {
+		out, _, err := s.rules[i].program.Eval(activation)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("evaluation_rules[%d]: %w", i, err))
+			continue
+		}
+		if matched, _ := out.Value().(bool); matched {
+			return &s.rules[i].EvaluationRule, errs
+		}
+	}
+	return &s.fallback, errs
+}
+
+// getEvaluationRules returns the compiled evaluation rules of the config, or nil when every
+// request is evaluated. Invalid rules are rejected by Validate, so they are treated as none here.
+func (c *Config) getEvaluationRules() *ruleSet {
+	c.rulesOnce.Do(func() {
+		c.rules, _ = compileEvaluationRules(c.EvaluationRules, c.EvaluationRulesDefault)
+	})
+	return c.rules
+}
+
+// applyEvaluationRules returns the action evaluation_rules take for the request, and the rule
+// that matched.
+func applyEvaluationRules(conf *Config, payload *SidebandAccessRequest, consumerIDs []string, logger *PluginLogger) (string, *EvaluationRule) {
+	rules := conf.getEvaluationRules()
+	if rules == nil {
+		return ruleActionEvaluate, nil
+	}
+	rule, errs := rules.match(payload, consumerName(consumerIDs))
+	for _, err := range errs {
+		logger.Warn("Evaluation rule failed, skipping it", "error", err.Error())
+	}
+	if rule.Action != ruleActionEvaluate {
+		logger.Debug("Request not evaluated by evaluation_rules", "action", rule.Action)
+	}
+	return rule.Action, rule
+}
+
+// denial returns the status and message of a deny rule, with their defaults applied.
+func (r *EvaluationRule) denial() (int, string) {
+	status, message := r.DenyStatus, r.DenyMessage
+	if status == 0 {
+		status = 403
+	}
+	if message == "" {
+		message = defaultRuleDenyMessage
+	}
+	return status, message
+}
+
+// ruleDenial builds the local response of a deny rule, as a JSON-RPC error for MCP requests.
+func ruleDenial(conf *Config, rule *EvaluationRule, payload *SidebandAccessRequest) (int, []byte, map[string][]string) {
+	status, message := rule.denial()
+	headers := map[string][]string{"Content-Type": {"application/json"}}
+	data := newMCPDenyData(conf, payload, status, decisionctx.ReasonEvaluationRule)
+	if body := mcpDenyBody(message, []byte(payload.Body), data); body != nil {
+		return status, body, headers
+	}
+	body := fmt.Sprintf(`{"code":"REQUEST_DENIED","message":%q}`, message)
+	return status, []byte(body), headers
+}
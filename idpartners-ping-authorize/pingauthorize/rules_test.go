@@ -0,0 +1,131 @@
+package pingauthorize
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompileEvaluationRules(t *testing.T) {
+	tests := []struct {
+		name     string
+		rules    []EvaluationRule
+		fallback string
+		wantNil  bool
+		wantErr  string
+	}{
+		{"none", nil, "", true, ""},
+		{"evaluate default only", nil, "evaluate", true, ""},
+		{"skip default only", nil, "skip", false, ""},
+		{"valid", []EvaluationRule{{Expression: "request.method == 'POST'", Action: "deny", DenyStatus: 405}}, "", false, ""},
+		{"unknown action", []EvaluationRule{{Expression: "true", Action: "allow"}}, "", false, "evaluation_rules[0]: action"},
+		{"unknown default", nil, "allow", false, "evaluation_rules_default"},
+		{"not bool", []EvaluationRule{{Expression: "headers['x-tenant']", Action: "skip"}}, "", false, "evaluation_rules[0]"},
+		{"empty expression", []EvaluationRule{{Action: "skip"}}, "", false, "evaluation_rules[0]"},
+		{"bad status", []EvaluationRule{{Expression: "true", Action: "deny", DenyStatus: 200}}, "", false, "deny_status"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set, err := compileEvaluationRules(tt.rules, tt.fallback)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if (set == nil) != tt.wantNil {
+				t.Errorf("rule set = %v, want nil %v", set, tt.wantNil)
+			}
+		})
+	}
+}
+
+func TestRuleSet_Match(t *testing.T) {
+	set, err := compileEvaluationRules([]EvaluationRule{
+		{Expression: "request.path.startsWith('/admin') && consumer != 'root'", Action: "deny", DenyStatus: 404},
+		{Expression: "headers['x-tenant'] == 'internal'", Action: "skip"},
+		{Expression: "request.method in ['POST', 'PUT'] && request.path.startsWith('/accounts/')", Action: "evaluate"},
+	}, "skip")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		headers    []map[string]string
+		consumer   string
+		wantAction string
+		wantErrs   int
+	}{
+		{"denied", "GET", "/admin/users", nil, "alice", "deny", 0},
+		{"consumer exempt", "GET", "/admin/users", nil, "root", "skip", 1},
+		{"evaluated", "POST", "/accounts/42", nil, "", "evaluate", 1},
+		{"default", "GET", "/accounts/42", nil, "", "skip", 1},
+		{"header match", "POST", "/accounts/42", []map[string]string{{"x-tenant": "internal"}}, "", "skip", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &SidebandAccessRequest{Method: tt.method, URL: "https://api.example.com" + tt.path, Headers: tt.headers}
+			rule, errs := set.match(req, tt.consumer)
+			if rule.Action != tt.wantAction || len(errs) != tt.wantErrs {
+				t.Errorf("match() = %q with errors %v, want %q with %d errors", rule.Action, errs, tt.wantAction, tt.wantErrs)
+			}
+		})
+	}
+}
+
+func TestMiddleware_EvaluationRules(t *testing.T) {
+	sidebandCalls := 0
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		sidebandCalls++
+		var req SidebandAccessRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(SidebandAccessResponse{Method: req.Method, URL: req.URL, Headers: req.Headers})
+	})
+	defer server.Close()
+
+	m := newTestMiddleware(t, server.URL, false)
+	m.conf.EvaluationRules = []EvaluationRule{
+		{Expression: "request.path == '/internal'", Action: "deny", DenyMessage: "Not exposed"},
+		{Expression: "request.method in ['POST', 'PUT']", Action: "evaluate"},
+	}
+	m.conf.EvaluationRulesDefault = "skip"
+	calls := 0
+	h := m.Handler(upstreamEcho(t, &calls))
+
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		body         string
+		wantCode     int
+		wantBody     string
+		wantSideband int
+		wantUpstream int
+	}{
+		{"evaluated", "POST", "/accounts", "", 200, "", 1, 1},
+		{"skipped", "GET", "/accounts", "", 200, "", 0, 1},
+		{"denied", "GET", "/internal", "", 403, `{"code":"REQUEST_DENIED","message":"Not exposed"}`, 0, 0},
+		{"denied mcp", "POST", "/internal", `{"jsonrpc":"2.0","id":3,"method":"tools/list"}`, 403, `"id":3`, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls, sidebandCalls = 0, 0
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(tt.method, "http://api.example.com"+tt.path, strings.NewReader(tt.body)))
+			if rec.Code != tt.wantCode || !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("got %d %q, want %d %q", rec.Code, rec.Body.String(), tt.wantCode, tt.wantBody)
+			}
+			if sidebandCalls != tt.wantSideband || calls != tt.wantUpstream {
+				t.Errorf("got %d sideband and %d upstream calls, want %d and %d", sidebandCalls, calls, tt.wantSideband, tt.wantUpstream)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package pingauthorize
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash/fnv"
+	"math/rand"
+	"strings"
+)
+
+// BodyDigest summarizes a request body that was left out of the sideband payload by body sampling.
+type BodyDigest struct {
+	SHA256 string `json:"sha256"`
+	Length int    `json:"length"`
+}
+
+// sampleRoll returns a value in [0, 100) used to decide whether a body is forwarded. Replaced in tests.
+var sampleRoll = func() float64 { return rand.Float64() * 100 }
+
+type consumerKey struct{}
+
+// WithConsumer returns a context identifying the authenticated consumer of a request (username,
+// custom id or id) for body_sample_consumers. The Kong plugin reads the consumer from Kong instead.
+func WithConsumer(ctx context.Context, ids ...string) context.Context {
+	if len(ids) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, consumerKey{}, ids)
+}
+
+// consumerFromContext returns the consumer ids stored by WithConsumer, if any.
+func consumerFromContext(ctx context.Context) []string {
+	ids, _ := ctx.Value(consumerKey{}).([]string)
+	return ids
+}
+
+// consumerName returns the first non-empty consumer id (username, custom id or id), or "".
+func consumerName(ids []string) string {
+	for _, id := range ids {
+		if id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+// applyBodySampling replaces the payload body with a BodyDigest unless the request is sampled in
+// by body_sample_percent, is made by one of body_sample_consumers, or is an MCP tools/call for one
+// of body_sample_tools. Returns true if the body was left out.
+func applyBodySampling(conf *Config, payload *SidebandAccessRequest, consumerIDs []string, logger *PluginLogger) bool {
+	if !conf.BodySamplingEnabled || payload.Body == "" {
+		return false
+	}
+	if matchesAny(consumerIDs, conf.BodySampleConsumers) {
+		return false
+	}
+	if len(conf.BodySampleTools) > 0 {
+		if tool := mcpToolName(parseMCPRequest([]byte(payload.Body))); tool != "" && matchesAny([]string{tool}, conf.BodySampleTools) {
+			return false
+		}
+	}
+	if sampleRoll() < conf.BodySamplePercent {
+		return false
+	}
+
+	omitBody(payload)
+	logger.Debug("Request body left out of sideband payload by body sampling", "length", payload.BodyDigest.Length)
+	return true
+}
+
+// applyBodyContentTypes replaces the payload body with a BodyDigest when body_content_types is set
+// and the request Content-Type matches none of them. Returns true if the body was left out.
+func applyBodyContentTypes(conf *Config, payload *SidebandAccessRequest, logger *PluginLogger) bool {
+	if len(conf.BodyContentTypes) == 0 || payload.Body == "" {
+		return false
+	}
+	contentType := firstValue(FlattenHeaders(payload.Headers)["content-type"])
+	if matchContentType(contentType, conf.BodyContentTypes) {
+		return false
+	}
+
+	omitBody(payload)
+	logger.Debug("Request body left out of sideband payload by body_content_types", "content_type", contentType,
+		"length", payload.BodyDigest.Length)
+	return true
+}
+
+// matchContentType reports whether the media type of contentType is one of patterns, which are
+// media types like application/json or ranges like image/*. Parameters are ignored.
+func matchContentType(contentType string, patterns []string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	if mediaType == "" {
+		return false
+	}
+	for _, p := range patterns {
+		p = strings.ToLower(p)
+		if p == mediaType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(p, "/*"); ok && strings.HasPrefix(mediaType, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// omitBody moves the payload body into a BodyDigest.
+func omitBody(payload *SidebandAccessRequest) {
+	sum := sha256.Sum256([]byte(payload.Body))
+	payload.BodyDigest = &BodyDigest{SHA256: hex.EncodeToString(sum[:]), Length: len(payload.Body)}
+	payload.Body = ""
+}
+
+// Keys hashed to select requests for evaluation (evaluation_key).
+const (
+	evaluationKeyClientIP = "client_ip"
+	evaluationKeyConsumer = "consumer"
+)
+
+// inEvaluationPercentage reports whether the request is one of the evaluation_percentage share of
+// requests sent to the policy provider. Requests are bucketed by a hash of evaluation_key, so a
+// client is either always or never evaluated at a given percentage, and stays evaluated as the
+// percentage is raised. Requests without a consumer are bucketed by client IP.
+func inEvaluationPercentage(conf *Config, payload *SidebandAccessRequest, consumerIDs []string) bool {
+	if conf.EvaluationPercentage >= 100 {
+		return true
+	}
+	key := payload.SourceIP
+	if consumer := consumerName(consumerIDs); conf.EvaluationKey == evaluationKeyConsumer && consumer != "" {
+		key = consumer
+	}
+	return evaluationBucket(key) < conf.EvaluationPercentage
+}
+
+// evaluationBucket maps key to a value in [0, 100) in steps of 0.01.
+func evaluationBucket(key string) float64 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return float64(h.Sum32()%10000) / 100
+}
+
+// skipsBody reports whether the body of requests with method is left unread by skip_body_methods.
+func skipsBody(conf *Config, method string) bool {
+	for _, m := range conf.SkipBodyMethods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// ignoreOmittedBody drops a policy response body that merely echoes the empty body sent in place
+// of a sampled-out or unread request body, so the original body is forwarded upstream unchanged.
+func ignoreOmittedBody(payload *SidebandAccessRequest, resp *SidebandAccessResponse) {
+	if (payload.BodyDigest != nil || payload.bodyNotRead) && resp.Body != nil && *resp.Body == "" {
+		resp.Body = nil
+	}
+}
+
+// matchesAny reports whether any of values is in list.
+func matchesAny(values, list []string) bool {
+	for _, v := range values {
+		if v == "" {
+			continue
+		}
+		for _, item := range list {
+			if v == item {
+				return true
+			}
+		}
+	}
+	return false
+}
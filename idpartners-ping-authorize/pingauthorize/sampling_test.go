@@ -0,0 +1,291 @@
+package pingauthorize
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func withSampleRoll(t *testing.T, roll float64) {
+	t.Helper()
+	orig := sampleRoll
+	sampleRoll = func() float64 { return roll }
+	t.Cleanup(func() { sampleRoll = orig })
+}
+
+func TestApplyBodySampling(t *testing.T) {
+	toolCall := `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"export_all"}}`
+	conf := &Config{
+		BodySamplingEnabled: true,
+		BodySamplePercent:   10,
+		BodySampleConsumers: []string{"auditor"},
+		BodySampleTools:     []string{"export_all"},
+	}
+
+	tests := []struct {
+		name     string
+		conf     *Config
+		body     string
+		consumer []string
+		roll     float64
+		wantOmit bool
+	}{
+		{"sampled in", conf, "hello", nil, 5, false},
+		{"sampled out", conf, "hello", nil, 50, true},
+		{"flagged consumer", conf, "hello", []string{"", "", "auditor"}, 50, false},
+		{"other consumer", conf, "hello", []string{"guest"}, 50, true},
+		{"flagged tool", conf, toolCall, nil, 50, false},
+		{"other tool", conf, strings.Replace(toolCall, "export_all", "search", 1), nil, 50, true},
+		{"empty body", conf, "", nil, 50, false},
+		{"disabled", &Config{BodySamplePercent: 0}, "hello", nil, 50, false},
+		{"zero percent", &Config{BodySamplingEnabled: true}, "hello", nil, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withSampleRoll(t, tt.roll)
+			payload := &SidebandAccessRequest{Body: tt.body}
+			omitted := applyBodySampling(tt.conf, payload, tt.consumer, NewPluginLogger(nil, "access", ""))
+			if omitted != tt.wantOmit {
+				t.Fatalf("omitted = %v, want %v", omitted, tt.wantOmit)
+			}
+			if omitted {
+				if payload.Body != "" || payload.BodyDigest == nil || payload.BodyDigest.Length != len(tt.body) {
+					t.Errorf("unexpected payload: %+v", payload)
+				}
+			} else if payload.Body != tt.body || payload.BodyDigest != nil {
+				t.Errorf("body should be forwarded unchanged: %+v", payload)
+			}
+		})
+	}
+}
+
+func TestApplyBodySampling_Digest(t *testing.T) {
+	withSampleRoll(t, 99)
+	payload := &SidebandAccessRequest{Body: "hello"}
+	applyBodySampling(&Config{BodySamplingEnabled: true, BodySamplePercent: 50}, payload, nil, NewPluginLogger(nil, "access", ""))
+
+	data, _ := json.Marshal(payload)
+	want := `"body_digest":{"sha256":"2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824","length":5}`
+	if !strings.Contains(string(data), want) {
+		t.Errorf("expected %s in %s", want, data)
+	}
+}
+
+func TestApplyBodyContentTypes(t *testing.T) {
+	conf := &Config{BodyContentTypes: []string{"application/json", "Application/XML", "text/*"}}
+
+	tests := []struct {
+		name        string
+		conf        *Config
+		contentType string
+		body        string
+		wantOmit    bool
+	}{
+		{"listed", conf, "application/json", "{}", false},
+		{"parameters and case", conf, "application/XML; charset=utf-8", "<a/>", false},
+		{"range", conf, "text/plain", "hello", false},
+		{"binary", conf, "image/png", "\x89PNG", true},
+		{"json suffix not listed", conf, "application/problem+json", "{}", true},
+		{"no content type", conf, "", "hello", true},
+		{"empty body", conf, "image/png", "", false},
+		{"not configured", &Config{}, "image/png", "\x89PNG", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := &SidebandAccessRequest{Body: tt.body}
+			if tt.contentType != "" {
+				payload.Headers = []map[string]string{{"content-type": tt.contentType}}
+			}
+			omitted := applyBodyContentTypes(tt.conf, payload, NewPluginLogger(nil, "access", ""))
+			if omitted != tt.wantOmit {
+				t.Fatalf("omitted = %v, want %v", omitted, tt.wantOmit)
+			}
+			if omitted && (payload.Body != "" || payload.BodyDigest == nil || payload.BodyDigest.Length != len(tt.body)) {
+				t.Errorf("unexpected payload: %+v", payload)
+			}
+		})
+	}
+}
+
+func TestIgnoreOmittedBody(t *testing.T) {
+	empty, rewritten := "", "rewritten"
+
+	resp := &SidebandAccessResponse{Body: &empty}
+	ignoreOmittedBody(&SidebandAccessRequest{BodyDigest: &BodyDigest{}}, resp)
+	if resp.Body != nil {
+		t.Error("echoed empty body should be ignored for a sampled-out request")
+	}
+
+	resp = &SidebandAccessResponse{Body: &rewritten}
+	ignoreOmittedBody(&SidebandAccessRequest{BodyDigest: &BodyDigest{}}, resp)
+	if resp.Body == nil {
+		t.Error("a rewritten body should still be applied")
+	}
+
+	resp = &SidebandAccessResponse{Body: &empty}
+	ignoreOmittedBody(&SidebandAccessRequest{}, resp)
+	if resp.Body == nil {
+		t.Error("an empty body should be applied when the request body was forwarded")
+	}
+}
+
+func TestMiddleware_BodySampling(t *testing.T) {
+	withSampleRoll(t, 50)
+	var seen SidebandAccessRequest
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		seen = SidebandAccessRequest{}
+		json.NewDecoder(r.Body).Decode(&seen)
+		json.NewEncoder(w).Encode(SidebandAccessResponse{Method: seen.Method, URL: seen.URL, Body: &seen.Body, Headers: seen.Headers})
+	})
+	defer server.Close()
+
+	conf := &Config{
+		ServiceURL:          server.URL,
+		SharedSecret:        "test-secret",
+		SecretHeaderName:    "X-Secret",
+		SkipResponsePhase:   true,
+		BodySamplingEnabled: true,
+		BodySamplePercent:   25,
+		BodySampleConsumers: []string{"auditor"},
+	}
+	m, err := NewMiddleware(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var upstreamBody string
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		upstreamBody = string(b)
+	})
+
+	m.Handler(upstream).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "http://api.example.com/", strings.NewReader("payload")))
+	if seen.Body != "" || seen.BodyDigest == nil {
+		t.Errorf("expected a digest instead of the body, got %+v", seen)
+	}
+	if upstreamBody != "payload" {
+		t.Errorf("upstream should receive the original body, got %q", upstreamBody)
+	}
+
+	req := httptest.NewRequest("POST", "http://api.example.com/", strings.NewReader("payload"))
+	req = req.WithContext(WithConsumer(context.Background(), "auditor"))
+	m.Handler(upstream).ServeHTTP(httptest.NewRecorder(), req)
+	if seen.Body != "payload" || seen.BodyDigest != nil {
+		t.Errorf("flagged consumer should forward the full body, got %+v", seen)
+	}
+}
+
+func TestInEvaluationPercentage(t *testing.T) {
+	payload := func(ip string) *SidebandAccessRequest { return &SidebandAccessRequest{SourceIP: ip} }
+
+	selected := 0
+	for i := 0; i < 10000; i++ {
+		ip := fmt.Sprintf("10.0.%d.%d", i/256, i%256)
+		in10 := inEvaluationPercentage(&Config{EvaluationPercentage: 10}, payload(ip), nil)
+		in50 := inEvaluationPercentage(&Config{EvaluationPercentage: 50}, payload(ip), nil)
+		if in10 && !in50 {
+			t.Fatalf("%s evaluated at 10%% but not at 50%%", ip)
+		}
+		if in50 {
+			selected++
+		}
+	}
+	if selected < 4700 || selected > 5300 {
+		t.Errorf("%d of 10000 clients evaluated at 50%%", selected)
+	}
+
+	alice := evaluationBucket("alice")
+	tests := []struct {
+		name     string
+		conf     *Config
+		consumer []string
+		want     bool
+	}{
+		{"all", &Config{EvaluationPercentage: 100}, nil, true},
+		{"none", &Config{EvaluationPercentage: 0}, nil, false},
+		{"consumer in", &Config{EvaluationPercentage: alice + 0.01, EvaluationKey: evaluationKeyConsumer}, []string{"alice"}, true},
+		{"consumer out", &Config{EvaluationPercentage: alice, EvaluationKey: evaluationKeyConsumer}, []string{"alice"}, false},
+		{"no consumer", &Config{EvaluationPercentage: evaluationBucket("10.0.0.1") + 0.01, EvaluationKey: evaluationKeyConsumer}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 3; i++ {
+				if got := inEvaluationPercentage(tt.conf, payload("10.0.0.1"), tt.consumer); got != tt.want {
+					t.Fatalf("inEvaluationPercentage() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestMiddleware_EvaluationPercentage(t *testing.T) {
+	sidebandCalls := 0
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		sidebandCalls++
+		json.NewEncoder(w).Encode(SidebandAccessResponse{Response: &DenyResponse{ResponseCode: "403"}})
+	})
+	defer server.Close()
+
+	m := newTestMiddleware(t, server.URL, false)
+	calls := 0
+	h := m.Handler(upstreamEcho(t, &calls))
+	bucket := evaluationBucket("192.0.2.1") // httptest.NewRequest's client
+
+	for _, tt := range []struct {
+		percentage float64
+		wantCode   int
+	}{{bucket, 200}, {bucket + 0.01, 403}} {
+		m.conf.EvaluationPercentage = tt.percentage
+		calls, sidebandCalls = 0, 0
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "http://api.example.com/x", nil))
+		if rec.Code != tt.wantCode || calls+sidebandCalls != 1 {
+			t.Errorf("at %g%%: status %d, %d upstream and %d sideband calls", tt.percentage, rec.Code, calls, sidebandCalls)
+		}
+	}
+}
+
+func TestMiddleware_SkipBodyMethods(t *testing.T) {
+	var seen SidebandAccessRequest
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		seen = SidebandAccessRequest{}
+		json.NewDecoder(r.Body).Decode(&seen)
+		json.NewEncoder(w).Encode(SidebandAccessResponse{Method: seen.Method, URL: seen.URL, Body: &seen.Body, Headers: seen.Headers})
+	})
+	defer server.Close()
+
+	m := newTestMiddleware(t, server.URL, false)
+	var upstreamBody string
+	h := m.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		upstreamBody = string(b)
+	}))
+
+	tests := []struct {
+		name         string
+		methods      []string
+		method       string
+		wantSideband string
+	}{
+		{"default skips GET", m.conf.SkipBodyMethods, "GET", ""},
+		{"case-insensitive", []string{"get"}, "GET", ""},
+		{"POST read", m.conf.SkipBodyMethods, "POST", "payload"},
+		{"empty list reads all", []string{}, "GET", "payload"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m.conf.SkipBodyMethods = tt.methods
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tt.method, "http://api.example.com/", strings.NewReader("payload")))
+			if seen.Body != tt.wantSideband || seen.BodyDigest != nil {
+				t.Errorf("sideband body = %q (%+v), want %q", seen.Body, seen.BodyDigest, tt.wantSideband)
+			}
+			if upstreamBody != "payload" {
+				t.Errorf("upstream should receive the original body, got %q", upstreamBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package pingauthorize
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reference prefixes accepted for shared_secret.
+const (
+	secretRefEnv  = "env://"
+	secretRefFile = "file://"
+)
+
+// secretFileCheckInterval is how often a file:// secret is checked for changes.
+const secretFileCheckInterval = 10 * time.Second
+
+// secretRef resolves a shared_secret value that may be an env://NAME or file:///path reference.
+// Literal values are returned as is. Environment variables are read on every call; files are
+// checked for changes every secretFileCheckInterval, so a rotated Kubernetes secret is picked up
+// without reconfiguring the plugin.
+type secretRef struct {
+	ref        string
+	serviceURL string
+	now        func() time.Time
+
+	mu      sync.Mutex
+	value   string
+	modTime time.Time
+	checked time.Time
+}
+
+func newSecretRef(ref, serviceURL string) *secretRef {
+	return &secretRef{ref: ref, serviceURL: serviceURL, now: time.Now}
+}
+
+// Value returns the current secret.
+func (s *secretRef) Value() (string, error) {
+	switch {
+	case strings.HasPrefix(s.ref, secretRefEnv):
+		name := strings.TrimPrefix(s.ref, secretRefEnv)
+		value := os.Getenv(name)
+		if value == "" {
+			return "", fmt.Errorf("shared_secret: environment variable %s is not set", name)
+		}
+		return value, nil
+	case strings.HasPrefix(s.ref, secretRefFile):
+		return s.fileValue(strings.TrimPrefix(s.ref, secretRefFile))
+	default:
+		return s.ref, nil
+	}
+}
+
+// fileValue returns the secret read from path, re-reading it when its modification time
+// changes. A file that becomes unreadable keeps the last value it had.
+func (s *secretRef) fileValue(path string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if s.value != "" && now.Sub(s.checked) < secretFileCheckInterval {
+		return s.value, nil
+	}
+	s.checked = now
+
+	value, modTime, err := s.readFile(path)
+	if err != nil {
+		if s.value == "" {
+			return "", err
+		}
+		NewPluginLogger(nil, "secret", s.serviceURL).Warn("Failed to re-read shared_secret file, keeping the previous value", "error", err.Error())
+		return s.value, nil
+	}
+	s.value, s.modTime = value, modTime
+	return value, nil
+}
+
+// readFile reads path unless its modification time is unchanged. Trailing newlines are removed.
+func (s *secretRef) readFile(path string) (string, time.Time, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("shared_secret: %w", err)
+	}
+	if s.value != "" && info.ModTime().Equal(s.modTime) {
+		return s.value, s.modTime, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("shared_secret: %w", err)
+	}
+	value := strings.TrimRight(string(data), "\r\n")
+	if value == "" {
+		return "", time.Time{}, fmt.Errorf("shared_secret: %s is empty", path)
+	}
+	return value, info.ModTime(), nil
+}
+
+// validateSecretRef checks the syntax of an env:// or file:// reference in field. The variable
+// or file itself is resolved at runtime, since it may only exist on the data plane.
+func validateSecretRef(field, ref string) error {
+	switch {
+	case strings.HasPrefix(ref, secretRefEnv):
+		if strings.TrimPrefix(ref, secretRefEnv) == "" {
+			return fmt.Errorf("%s must name an environment variable, e.g. env://PAZ_SHARED_SECRET", field)
+		}
+	case strings.HasPrefix(ref, secretRefFile):
+		if !filepath.IsAbs(strings.TrimPrefix(ref, secretRefFile)) {
+			return fmt.Errorf("%s must be an absolute file:// path, e.g. file:///etc/secrets/paz, got %q", field, ref)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package pingauthorize
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSecretRef_Literal(t *testing.T) {
+	if v, err := newSecretRef("plain", "").Value(); err != nil || v != "plain" {
+		t.Errorf("Value() = %q, %v", v, err)
+	}
+}
+
+func TestSecretRef_Env(t *testing.T) {
+	t.Setenv("PAZ_TEST_SECRET", "from-env")
+	if v, err := newSecretRef("env://PAZ_TEST_SECRET", "").Value(); err != nil || v != "from-env" {
+		t.Errorf("Value() = %q, %v", v, err)
+	}
+	if _, err := newSecretRef("env://PAZ_TEST_SECRET_UNSET", "").Value(); err == nil {
+		t.Error("expected an error for an unset variable")
+	}
+}
+
+func TestSecretRef_FileReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret")
+	write := func(value string, mod time.Time) {
+		if err := os.WriteFile(path, []byte(value), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, mod, mod)
+	}
+
+	s := newSecretRef("file://"+path, "")
+	now := time.Unix(1000, 0)
+	s.now = func() time.Time { return now }
+
+	if _, err := s.Value(); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+
+	write("first\n", time.Unix(1, 0))
+	if v, err := s.Value(); err != nil || v != "first" {
+		t.Fatalf("Value() = %q, %v", v, err)
+	}
+
+	write("second\n", time.Unix(2, 0))
+	if v, _ := s.Value(); v != "first" {
+		t.Errorf("expected the cached value within the check interval, got %q", v)
+	}
+	now = now.Add(secretFileCheckInterval)
+	if v, _ := s.Value(); v != "second" {
+		t.Errorf("expected the rotated value, got %q", v)
+	}
+
+	os.Remove(path)
+	now = now.Add(secretFileCheckInterval)
+	if v, err := s.Value(); err != nil || v != "second" {
+		t.Errorf("expected the last value to be kept, got %q, %v", v, err)
+	}
+}
+
+func TestValidateSecretRef(t *testing.T) {
+	tests := []struct {
+		ref     string
+		wantErr string
+	}{
+		{"plain", ""},
+		{"env://PAZ_SECRET", ""},
+		{"file:///var/run/secrets/paz", ""},
+		{"env://", "must name an environment variable"},
+		{"file://relative/path", "absolute file:// path"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			conf := NewConfig()
+			conf.ServiceURL = "https://paz.example.com"
+			conf.SharedSecret = tt.ref
+			conf.SecretHeaderName = "X-Secret"
+			err := conf.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExecute_SharedSecretFromEnv(t *testing.T) {
+	t.Setenv("PAZ_TEST_SECRET", "rotated")
+	var got string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("X-Secret")
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	conf := NewConfig()
+	conf.ServiceURL = server.URL
+	conf.SharedSecret = "env://PAZ_TEST_SECRET"
+	conf.SecretHeaderName = "X-Secret"
+	parsed, _ := ParseURL(server.URL)
+	if _, _, _, err := NewSidebandHTTPClient(conf).Execute(context.Background(), server.URL, []byte(`{}`), parsed); err != nil {
+		t.Fatal(err)
+	}
+	if got != "rotated" {
+		t.Errorf("expected the resolved secret to be sent, got %q", got)
+	}
+}
+
+func TestExecute_SecondarySharedSecret(t *testing.T) {
+	tests := []struct {
+		name       string
+		accepted   string
+		rejectWith int
+		secondary  string
+		wantStatus int
+		wantSent   string
+	}{
+		{"primary accepted", "new", 401, "old", 200, "new"},
+		{"secondary after 401", "old", 401, "old", 200, "new,old"},
+		{"secondary after 403", "old", 403, "old", 200, "new,old"},
+		{"both rejected", "other", 403, "old", 403, "new,old"},
+		{"no secondary", "old", 401, "", 401, "new"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sent []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				secret := r.Header.Get("X-Secret")
+				sent = append(sent, secret)
+				if secret != tt.accepted {
+					w.WriteHeader(tt.rejectWith)
+					return
+				}
+				w.Write([]byte(`{}`))
+			}))
+			defer server.Close()
+
+			conf := NewConfig()
+			conf.ServiceURL = server.URL
+			conf.SharedSecret = "new"
+			conf.SharedSecretSecondary = tt.secondary
+			conf.SecretHeaderName = "X-Secret"
+			parsed, _ := ParseURL(server.URL)
+			status, _, _, err := NewSidebandHTTPClient(conf).Execute(context.Background(), server.URL, []byte(`{}`), parsed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status != tt.wantStatus || strings.Join(sent, ",") != tt.wantSent {
+				t.Errorf("got %d with secrets %v, want %d with %s", status, sent, tt.wantStatus, tt.wantSent)
+			}
+		})
+	}
+
+	conf := NewConfig()
+	conf.ServiceURL = "https://paz.example.com"
+	conf.SharedSecretSecondary = "old"
+	conf.SidebandOAuthTokenURL = "https://idp.example.com/token"
+	conf.SidebandOAuthClientID, conf.SidebandOAuthClientSecret = "kong", "s3cret"
+	if err := conf.Validate(); err == nil || !strings.Contains(err.Error(), "requires shared_secret") {
+		t.Errorf("expected shared_secret_secondary without shared_secret to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,175 @@
+package pingauthorize
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SidebandProvider implements PolicyProvider using the PingAuthorize Sideband API.
+type SidebandProvider struct {
+	httpClient *SidebandHTTPClient
+	config     *Config
+	parsedURL  *ParsedURL
+}
+
+// NewSidebandProvider creates a new SidebandProvider.
+func NewSidebandProvider(config *Config, httpClient *SidebandHTTPClient, parsedURL *ParsedURL) *SidebandProvider {
+	return &SidebandProvider{
+		httpClient: httpClient,
+		config:     config,
+		parsedURL:  parsedURL,
+	}
+}
+
+// EvaluateRequest sends the access phase payload to /sideband/request and returns the parsed response.
+func (p *SidebandProvider) EvaluateRequest(ctx context.Context, req *SidebandAccessRequest) (*SidebandAccessResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode access request: %w", err)
+	}
+
+	requestURL := BuildSidebandURL(p.parsedURL, "/sideband/request")
+	statusCode, respHeaders, respBody, err := p.httpClient.Execute(ctx, requestURL, body, p.parsedURL)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := decodeAccessCall(statusCode, respBody)
+	if err != nil {
+		return nil, err
+	}
+	resp.CacheTTL = decisionCacheTTL(resp.TTL, respHeaders)
+	return resp, nil
+}
+
+// decodeAccessCall parses the result of a /sideband/request call, failing with a
+// *sidebandHTTPError when PingAuthorize answered with a 4xx or 5xx status.
+func decodeAccessCall(statusCode int, respBody []byte) (*SidebandAccessResponse, error) {
+	if statusCode >= 400 {
+		var errResp SidebandErrorResponse
+		json.Unmarshal(respBody, &errResp)
+		return nil, &sidebandHTTPError{
+			StatusCode: statusCode,
+			Body:       respBody,
+			Message:    errResp.Message,
+			ID:         errResp.ID,
+		}
+	}
+	return decodeAccessResponse(respBody)
+}
+
+// EvaluateResponse sends the response phase payload to /sideband/response and returns the parsed result.
+func (p *SidebandProvider) EvaluateResponse(ctx context.Context, req *SidebandResponsePayload) (*SidebandResponseResult, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode response payload: %w", err)
+	}
+
+	requestURL := BuildSidebandURL(p.parsedURL, "/sideband/response")
+	statusCode, _, respBody, err := p.httpClient.Execute(ctx, requestURL, body, p.parsedURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check for failed request (4xx/5xx from PingAuthorize)
+	if statusCode >= 400 {
+		var errResp SidebandErrorResponse
+		json.Unmarshal(respBody, &errResp)
+		return nil, &sidebandHTTPError{
+			StatusCode: statusCode,
+			Body:       respBody,
+			Message:    errResp.Message,
+			ID:         errResp.ID,
+		}
+	}
+
+	return decodeResponseResult(respBody)
+}
+
+// decodeAccessResponse parses a /sideband/request response body.
+func decodeAccessResponse(body []byte) (*SidebandAccessResponse, error) {
+	var resp SidebandAccessResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("failed to decode access response: %w", err)
+	}
+	return &resp, nil
+}
+
+// decodeResponseResult parses a /sideband/response response body.
+func decodeResponseResult(body []byte) (*SidebandResponseResult, error) {
+	var result SidebandResponseResult
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to decode response result: %w", err)
+	}
+	return &result, nil
+}
+
+// maxCacheHintTTL caps the cache lifetime a policy response can ask for.
+const maxCacheHintTTL = 24 * time.Hour
+
+// decisionCacheTTL returns the cache lifetime a policy response asks for. An explicit ttl field
+// (seconds) takes precedence over Cache-Control; s-maxage is preferred over max-age since decisions
+// are shared between clients. no-store, no-cache and private mean the decision must not be cached.
+// Returns nil when the response carries no usable hint.
+func decisionCacheTTL(ttl *float64, headers http.Header) *time.Duration {
+	if ttl != nil {
+		d := time.Duration(0)
+		if *ttl > 0 {
+			d = maxCacheHintTTL
+			if *ttl < maxCacheHintTTL.Seconds() {
+				d = time.Duration(*ttl * float64(time.Second))
+			}
+		}
+		return &d
+	}
+
+	var maxAge, sMaxAge *time.Duration
+	for _, value := range headers.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			switch strings.ToLower(name) {
+			case "no-store", "no-cache", "private":
+				zero := time.Duration(0)
+				return &zero
+			case "max-age":
+				maxAge = parseDeltaSeconds(arg)
+			case "s-maxage":
+				sMaxAge = parseDeltaSeconds(arg)
+			}
+		}
+	}
+	if sMaxAge != nil {
+		return sMaxAge
+	}
+	return maxAge
+}
+
+// parseDeltaSeconds parses a Cache-Control delta-seconds argument, ignoring invalid values.
+func parseDeltaSeconds(arg string) *time.Duration {
+	secs, err := strconv.ParseInt(strings.Trim(arg, `"`), 10, 64)
+	if err != nil || secs < 0 {
+		return nil
+	}
+	d := maxCacheHintTTL
+	if secs < int64(maxCacheHintTTL/time.Second) {
+		d = time.Duration(secs) * time.Second
+	}
+	return &d
+}
+
+// sidebandHTTPError represents an HTTP error response from PingAuthorize.
+type sidebandHTTPError struct {
+	StatusCode int
+	Body       []byte
+	Message    string
+	ID         string
+}
+
+func (e *sidebandHTTPError) Error() string {
+	return fmt.Sprintf("sideband request failed with status %d: %s", e.StatusCode, e.Message)
+}
@@ -0,0 +1,108 @@
+package pingauthorize
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func FuzzDecodeAccessResponse(f *testing.F) {
+	f.Add([]byte(`{"method":"GET","url":"http://a:80/","headers":[{"x":"y"}],"state":{"s":1}}`))
+	f.Add([]byte(`{"response":{"response_code":"403","body":"no","headers":[{"a":"b"}]}}`))
+	f.Add([]byte(`{"body":null,"headers":null,"client_certificate":{"kty":"RSA","x5c":[]}}`))
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		resp, err := decodeAccessResponse(body)
+		if err != nil {
+			return
+		}
+		FlattenHeaders(resp.Headers)
+		if resp.Response != nil {
+			strconv.Atoi(resp.Response.ResponseCode)
+			FlattenHeaders(resp.Response.Headers)
+		}
+	})
+}
+
+func FuzzDecodeResponseResult(f *testing.F) {
+	f.Add([]byte(`{"response_code":"200","body":"ok","headers":[{"content-type":"text/plain"}]}`))
+	f.Add([]byte(`{"response_code":"x","headers":[{}]}`))
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		result, err := decodeResponseResult(body)
+		if err != nil {
+			return
+		}
+		entries := 0
+		for _, entry := range result.Headers {
+			entries += len(entry)
+		}
+		values := 0
+		for _, v := range FlattenHeaders(result.Headers) {
+			values += len(v)
+		}
+		if values != entries {
+			t.Fatalf("FlattenHeaders lost values: %d != %d", values, entries)
+		}
+	})
+}
+
+func TestDecisionCacheTTL(t *testing.T) {
+	ttl := func(f float64) *float64 { return &f }
+
+	tests := []struct {
+		name    string
+		ttl     *float64
+		control []string
+		want    string
+	}{
+		{"no hint", nil, nil, "none"},
+		{"max-age", nil, []string{"max-age=30"}, "30s"},
+		{"s-maxage preferred", nil, []string{"max-age=30, s-maxage=5"}, "5s"},
+		{"quoted", nil, []string{`max-age="12"`}, "12s"},
+		{"multiple headers", nil, []string{"public", "max-age=7"}, "7s"},
+		{"no-store", nil, []string{"max-age=30, no-store"}, "0s"},
+		{"private", nil, []string{"Private"}, "0s"},
+		{"invalid max-age", nil, []string{"max-age=soon"}, "none"},
+		{"capped", nil, []string{"max-age=999999999999"}, "24h0m0s"},
+		{"ttl field wins", ttl(2.5), []string{"max-age=30"}, "2.5s"},
+		{"negative ttl", ttl(-1), nil, "0s"},
+		{"huge ttl", ttl(1e300), nil, "24h0m0s"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := http.Header{}
+			for _, v := range tt.control {
+				headers.Add("Cache-Control", v)
+			}
+			got := "none"
+			if d := decisionCacheTTL(tt.ttl, headers); d != nil {
+				got = d.String()
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSidebandProvider_CacheTTL(t *testing.T) {
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte(`{"method":"GET","url":"http://a:80/","headers":[]}`))
+	})
+	defer server.Close()
+
+	conf := &Config{ServiceURL: server.URL, SharedSecret: "s", SecretHeaderName: "X-Secret"}
+	conf.applyDefaults()
+	parsedURL, _ := ParseURL(server.URL)
+	resp, err := NewSidebandProvider(conf, conf.getHTTPClient(), parsedURL).EvaluateRequest(context.Background(), &SidebandAccessRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.CacheTTL == nil || *resp.CacheTTL != time.Minute {
+		t.Errorf("expected 1m cache TTL, got %v", resp.CacheTTL)
+	}
+}
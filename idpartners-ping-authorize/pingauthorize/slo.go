@@ -0,0 +1,165 @@
+package pingauthorize
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	// sloBuckets is the number of buckets the SLO window is divided into.
+	sloBuckets = 10
+
+	defaultSLOTarget        = 0.99
+	defaultSLOWindowSeconds = 300
+	defaultSLOMinCalls      = 20
+)
+
+// SLOEvent reports the plugin degrading to fail-open because the sideband error budget is exhausted,
+// or restoring enforcement once it recovers. It is logged and posted to slo_webhook_url.
+type SLOEvent struct {
+	Event      string    `json:"event"` // "slo_degraded" or "slo_restored"
+	ServiceURL string    `json:"service_url"`
+	Calls      int       `json:"calls"`
+	BadCalls   int       `json:"bad_calls"`
+	Target     float64   `json:"target"`
+	Time       time.Time `json:"time"`
+}
+
+type sloBucket struct {
+	index      int64
+	calls, bad int
+}
+
+// sloTracker keeps a sliding window of sideband call outcomes and reports when the share of bad
+// calls exceeds the error budget (1 - slo_target).
+type sloTracker struct {
+	mu       sync.Mutex
+	target   float64
+	latency  time.Duration
+	bucket   time.Duration
+	minCalls int
+	buckets  [sloBuckets]sloBucket
+	degraded bool
+	now      func() time.Time
+}
+
+func newSLOTracker(config *Config) *sloTracker {
+	window := time.Duration(config.SLOWindowSeconds) * time.Second
+	if window <= 0 {
+		window = time.Duration(defaultSLOWindowSeconds) * time.Second
+	}
+	return &sloTracker{
+		target:   config.SLOTarget,
+		latency:  time.Duration(config.SLOLatencyMs) * time.Millisecond,
+		bucket:   window / sloBuckets,
+		minCalls: config.SLOMinCalls,
+		now:      time.Now,
+	}
+}
+
+// record adds a call outcome and returns an event when the degraded state changes.
+// A call is bad if it failed, returned 429 or 5xx, or took longer than slo_latency_ms.
+func (t *sloTracker) record(failed bool, elapsed time.Duration) (event *SLOEvent) {
+	bad := failed || (t.latency > 0 && elapsed > t.latency)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	index := t.now().UnixNano() / int64(t.bucket)
+	b := &t.buckets[index%sloBuckets]
+	if b.index != index {
+		*b = sloBucket{index: index}
+	}
+	b.calls++
+	if bad {
+		b.bad++
+	}
+
+	calls, badCalls := 0, 0
+	for _, b := range t.buckets {
+		if b.index > index-sloBuckets {
+			calls += b.calls
+			badCalls += b.bad
+		}
+	}
+	exhausted := calls >= t.minCalls && float64(badCalls) > (1-t.target)*float64(calls)
+	if exhausted == t.degraded {
+		return nil
+	}
+	t.degraded = exhausted
+
+	event = &SLOEvent{Event: "slo_restored", Calls: calls, BadCalls: badCalls, Target: t.target, Time: t.now().UTC()}
+	if exhausted {
+		event.Event = "slo_degraded"
+	}
+	return event
+}
+
+// isDegraded reports whether the error budget is currently exhausted.
+func (t *sloTracker) isDegraded() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.degraded
+}
+
+var (
+	sloGaugeOnce sync.Once
+	sloGauge     metric.Int64Gauge
+)
+
+// sloDegradedGauge returns the degraded-state gauge from the global meter provider (a no-op until
+// OTel is initialized).
+func sloDegradedGauge() metric.Int64Gauge {
+	sloGaugeOnce.Do(func() {
+		sloGauge, _ = otel.Meter(PluginName).Int64Gauge("ping_authorize_slo_degraded",
+			metric.WithDescription("Sideband error budget exhausted and plugin degraded to fail-open: 0=no, 1=yes"))
+	})
+	return sloGauge
+}
+
+// recordSLO feeds a finished sideband call into the SLO tracker and reports state changes.
+func (c *SidebandHTTPClient) recordSLO(statusCode int, err error, elapsed time.Duration) {
+	if c.slo == nil {
+		return
+	}
+	if _, ok := err.(*SidebandThrottledError); ok {
+		return // throttled locally, PingAuthorize was not called
+	}
+	failed := err != nil || statusCode == 429 || statusCode >= 500
+	event := c.slo.record(failed, elapsed)
+	if event == nil {
+		return
+	}
+	event.ServiceURL = c.config.ServiceURL
+
+	logger := NewPluginLogger(nil, "sideband", c.config.ServiceURL)
+	state := int64(0)
+	if event.Event == "slo_degraded" {
+		state = 1
+		logger.Warn("Sideband error budget exhausted, degrading to fail-open", "audit", true,
+			"calls", event.Calls, "bad_calls", event.BadCalls, "target", event.Target)
+	} else {
+		logger.Warn("Sideband error budget recovered, restoring enforcement", "audit", true,
+			"calls", event.Calls, "bad_calls", event.BadCalls, "target", event.Target)
+	}
+	sloDegradedGauge().Record(context.Background(), state, metric.WithAttributes(attribute.String("service_url", c.config.ServiceURL)))
+	if c.config.SLOWebhookURL != "" {
+		go postWebhook(c.config.SLOWebhookURL, event, logger)
+	}
+}
+
+// sloDegraded reports whether the SLO tracker has degraded this client to fail-open.
+func (c *SidebandHTTPClient) sloDegraded() bool {
+	return c.slo != nil && c.slo.isDegraded()
+}
+
+// failOpen reports whether sideband failures should let traffic through: fail_open is set, or the
+// sideband error budget is exhausted and slo_enabled degraded the plugin to fail-open.
+func (c *Config) failOpen() bool {
+	return c.FailOpen || (c.SLOEnabled && c.getHTTPClient().sloDegraded())
+}
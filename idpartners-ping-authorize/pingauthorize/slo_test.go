@@ -0,0 +1,127 @@
+package pingauthorize
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestSLOTracker(target float64, latencyMs, minCalls int) (*sloTracker, *time.Time) {
+	now := time.Unix(1000, 0)
+	t := newSLOTracker(&Config{SLOTarget: target, SLOLatencyMs: latencyMs, SLOWindowSeconds: 10, SLOMinCalls: minCalls})
+	t.now = func() time.Time { return now }
+	return t, &now
+}
+
+func TestSLOTracker_DegradesAndRestores(t *testing.T) {
+	tracker, now := newTestSLOTracker(0.9, 0, 10)
+
+	for i := 0; i < 9; i++ {
+		if ev := tracker.record(true, 0); ev != nil {
+			t.Fatalf("should not degrade below slo_min_calls, got %+v at call %d", ev, i)
+		}
+	}
+	ev := tracker.record(true, 0)
+	if ev == nil || ev.Event != "slo_degraded" || ev.Calls != 10 || ev.BadCalls != 10 {
+		t.Fatalf("expected degradation, got %+v", ev)
+	}
+	if !tracker.isDegraded() {
+		t.Error("tracker should report degraded")
+	}
+
+	// The failures age out of the window; good calls restore enforcement.
+	*now = now.Add(11 * time.Second)
+	for i := 0; i < 9; i++ {
+		if ev := tracker.record(false, 0); ev != nil && ev.Event != "slo_restored" {
+			t.Fatalf("unexpected event %+v", ev)
+		}
+	}
+	if tracker.isDegraded() {
+		t.Error("tracker should restore once the budget recovers")
+	}
+}
+
+func TestSLOTracker_Budget(t *testing.T) {
+	tracker, _ := newTestSLOTracker(0.9, 0, 1)
+	for i := 0; i < 19; i++ {
+		tracker.record(false, 0)
+	}
+	if ev := tracker.record(true, 0); ev != nil {
+		t.Errorf("1 bad call in 20 is within a 10%% budget, got %+v", ev)
+	}
+	for i := 0; i < 2; i++ {
+		tracker.record(true, 0)
+	}
+	if !tracker.isDegraded() {
+		t.Error("3 bad calls in 22 should exhaust a 10% budget")
+	}
+}
+
+func TestSLOTracker_Latency(t *testing.T) {
+	tracker, _ := newTestSLOTracker(0.5, 100, 2)
+	tracker.record(false, 50*time.Millisecond)
+	tracker.record(false, 150*time.Millisecond)
+	if tracker.isDegraded() {
+		t.Error("half slow calls are within a 50% budget")
+	}
+	tracker.record(false, 150*time.Millisecond)
+	if !tracker.isDegraded() {
+		t.Error("slow calls over slo_latency_ms should count against the budget")
+	}
+}
+
+func TestMiddleware_SLODegradesToFailOpen(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(500)
+	}))
+	defer server.Close()
+
+	events := make(chan SLOEvent, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev SLOEvent
+		json.NewDecoder(r.Body).Decode(&ev)
+		events <- ev
+	}))
+	defer hook.Close()
+
+	conf := &Config{
+		ServiceURL:            server.URL,
+		SharedSecret:          "test-secret",
+		SecretHeaderName:      "X-Secret",
+		SkipResponsePhase:     true,
+		CircuitBreakerEnabled: false,
+		SLOEnabled:            true,
+		SLOMinCalls:           3,
+		SLOWebhookURL:         hook.URL,
+	}
+	m, err := NewMiddleware(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	h := m.Handler(upstreamEcho(t, &calls))
+
+	codes := make([]int, 4)
+	for i := range codes {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "http://api.example.com/", nil))
+		codes[i] = rec.Code
+	}
+	if codes[0] != 502 || codes[1] != 502 {
+		t.Errorf("expected fail-closed before the budget is exhausted, got %v", codes)
+	}
+	if codes[2] != 200 || codes[3] != 200 {
+		t.Errorf("expected fail-open once degraded, got %v", codes)
+	}
+
+	select {
+	case ev := <-events:
+		if ev.Event != "slo_degraded" || ev.ServiceURL != server.URL {
+			t.Errorf("unexpected event: %+v", ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("slo webhook was not called")
+	}
+}
@@ -0,0 +1,95 @@
+package pingauthorize
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+)
+
+// trafficTypeSOAP is the traffic_type of SOAP requests.
+const trafficTypeSOAP = "soap"
+
+// maxSOAPBodyBytes caps the request body size inspected for SOAP envelopes.
+const maxSOAPBodyBytes = 1 << 20
+
+// SOAP envelope namespaces by SOAP version.
+var soapEnvelopeVersions = map[string]string{
+	"http://schemas.xmlsoap.org/soap/envelope/": "1.1",
+	"http://www.w3.org/2003/05/soap-envelope":   "1.2",
+}
+
+// SOAPContext describes the SOAP operation of a request, sent as the soap block of the sideband
+// payload.
+type SOAPContext struct {
+	// Version is the SOAP version of the envelope, 1.1 or 1.2.
+	Version string `json:"version"`
+	// Action is the SOAPAction header (SOAP 1.1) or the action parameter of the content type
+	// (SOAP 1.2), unquoted.
+	Action string `json:"action,omitempty"`
+	// Operation and Namespace are the local name and namespace of the first element in the body.
+	Operation string `json:"operation"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// enrichSOAP sets traffic_type and the soap block of the payload when soap_enrichment is enabled
+// and the request is a POST whose text/xml or application/soap+xml body is a SOAP envelope.
+// Requests whose body is not a well-formed envelope up to its first body element are left alone.
+func enrichSOAP(conf *Config, payload *SidebandAccessRequest) {
+	if !conf.SOAPEnrichment || payload.Method != "POST" || payload.Body == "" || len(payload.Body) > maxSOAPBodyBytes {
+		return
+	}
+	headers := FlattenHeaders(payload.Headers)
+	mediaType, params, err := mime.ParseMediaType(firstValue(headers["content-type"]))
+	if err != nil || (mediaType != "text/xml" && mediaType != "application/soap+xml") {
+		return
+	}
+
+	soap, err := parseSOAPEnvelope(payload.Body)
+	if err != nil {
+		return
+	}
+	soap.Action = params["action"]
+	if action := firstValue(headers["soapaction"]); action != "" {
+		soap.Action = strings.Trim(action, `"`)
+	}
+	payload.TrafficType = trafficTypeSOAP
+	payload.SOAP = soap
+}
+
+// parseSOAPEnvelope reads a SOAP envelope up to the first element of its body. The rest of the
+// document is not read. encoding/xml does not resolve external entities or DTDs.
+func parseSOAPEnvelope(body string) (*SOAPContext, error) {
+	dec := xml.NewDecoder(strings.NewReader(body))
+	var soap *SOAPContext
+	var inBody bool
+	for depth := 0; ; {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, fmt.Errorf("no SOAP operation found: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			switch {
+			case depth == 1:
+				version, ok := soapEnvelopeVersions[t.Name.Space]
+				if !ok || t.Name.Local != "Envelope" {
+					return nil, errors.New("root element is not a SOAP envelope")
+				}
+				soap = &SOAPContext{Version: version}
+			case depth == 2 && t.Name.Local == "Body" && soapEnvelopeVersions[t.Name.Space] != "":
+				inBody = true
+			case depth == 3 && inBody:
+				soap.Operation, soap.Namespace = t.Name.Local, t.Name.Space
+				return soap, nil
+			}
+		case xml.EndElement:
+			depth--
+			if inBody && depth == 1 {
+				return nil, errors.New("SOAP body is empty")
+			}
+		}
+	}
+}
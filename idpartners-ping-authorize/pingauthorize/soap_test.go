@@ -0,0 +1,115 @@
+package pingauthorize
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const soap11Envelope = `<?xml version="1.0"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/">
+  <soap:Header><auth:Token xmlns:auth="urn:auth">abc</auth:Token></soap:Header>
+  <soap:Body>
+    <m:TransferFunds xmlns:m="urn:bank"><m:Amount>100</m:Amount></m:TransferFunds>
+  </soap:Body>
+</soap:Envelope>`
+
+const soap12Envelope = `<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope">
+  <env:Body><GetBalance xmlns="urn:bank"/></env:Body>
+</env:Envelope>`
+
+func TestParseSOAPEnvelope(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    SOAPContext
+		wantErr bool
+	}{
+		{"soap 1.1", soap11Envelope, SOAPContext{Version: "1.1", Operation: "TransferFunds", Namespace: "urn:bank"}, false},
+		{"soap 1.2", soap12Envelope, SOAPContext{Version: "1.2", Operation: "GetBalance", Namespace: "urn:bank"}, false},
+		{"truncated after operation", strings.Split(soap11Envelope, "<m:Amount>")[0], SOAPContext{Version: "1.1", Operation: "TransferFunds", Namespace: "urn:bank"}, false},
+		{"plain xml", `<order><id>1</id></order>`, SOAPContext{}, true},
+		{"empty body", `<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body></soap:Body></soap:Envelope>`, SOAPContext{}, true},
+		{"malformed", `<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body>&bogus;`, SOAPContext{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			soap, err := parseSOAPEnvelope(tt.body)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", soap)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if *soap != tt.want {
+				t.Errorf("got %+v, want %+v", *soap, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnrichSOAP(t *testing.T) {
+	conf := &Config{SOAPEnrichment: true}
+
+	tests := []struct {
+		name       string
+		conf       *Config
+		headers    map[string]string
+		body       string
+		wantAction string
+		wantSOAP   bool
+	}{
+		{"soap 1.1 action header", conf, map[string]string{"content-type": "text/xml; charset=utf-8", "soapaction": `"urn:bank#TransferFunds"`}, soap11Envelope, "urn:bank#TransferFunds", true},
+		{"soap 1.2 action parameter", conf, map[string]string{"content-type": `application/soap+xml; action="urn:bank#GetBalance"`}, soap12Envelope, "urn:bank#GetBalance", true},
+		{"json", conf, map[string]string{"content-type": "application/json"}, `{}`, "", false},
+		{"xml but not soap", conf, map[string]string{"content-type": "text/xml"}, `<order/>`, "", false},
+		{"disabled", &Config{}, map[string]string{"content-type": "text/xml"}, soap11Envelope, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := &SidebandAccessRequest{Method: "POST", Body: tt.body, Headers: []map[string]string{tt.headers}}
+			enrichSOAP(tt.conf, payload)
+			if !tt.wantSOAP {
+				if payload.TrafficType != "" || payload.SOAP != nil {
+					t.Errorf("expected no soap enrichment, got %q %+v", payload.TrafficType, payload.SOAP)
+				}
+				return
+			}
+			if payload.TrafficType != trafficTypeSOAP || payload.SOAP == nil || payload.SOAP.Action != tt.wantAction {
+				t.Errorf("got %q %+v, want action %q", payload.TrafficType, payload.SOAP, tt.wantAction)
+			}
+		})
+	}
+}
+
+func TestMiddleware_SOAPEnrichment(t *testing.T) {
+	var seen map[string]json.RawMessage
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&seen)
+		json.NewEncoder(w).Encode(SidebandAccessResponse{Response: &DenyResponse{ResponseCode: "403"}})
+	})
+	defer server.Close()
+
+	m := newTestMiddleware(t, server.URL, false)
+	m.conf.SOAPEnrichment = true
+	calls := 0
+	h := m.Handler(upstreamEcho(t, &calls))
+
+	req := httptest.NewRequest("POST", "http://api.example.com/bank", strings.NewReader(soap11Envelope))
+	req.Header.Set("Content-Type", "text/xml")
+	req.Header.Set("SOAPAction", "urn:bank#TransferFunds")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if string(seen["traffic_type"]) != `"soap"` {
+		t.Errorf("traffic_type = %s, want \"soap\"", seen["traffic_type"])
+	}
+	want := `{"version":"1.1","action":"urn:bank#TransferFunds","operation":"TransferFunds","namespace":"urn:bank"}`
+	if string(seen["soap"]) != want {
+		t.Errorf("soap = %s, want %s", seen["soap"], want)
+	}
+}
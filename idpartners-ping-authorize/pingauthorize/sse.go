@@ -0,0 +1,205 @@
+package pingauthorize
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// maxSSEEvents caps the number of events ParseSSEFinalMessage inspects in a single stream.
+const maxSSEEvents = 10000
+
+// jsonRPCResponse is the minimal structure for recognizing a JSON-RPC 2.0 response.
+type jsonRPCResponse struct {
+	Jsonrpc string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result"`
+	Error   json.RawMessage `json:"error"`
+}
+
+// ParseSSEFinalMessage extracts the last JSON-RPC response from an SSE stream body.
+// SSE format: lines prefixed with "data:", events separated by blank lines; multiple data lines
+// in one event are joined with "\n". Returns the body unchanged if contentType is not
+// text/event-stream or the stream holds no JSON-RPC response.
+func ParseSSEFinalMessage(body []byte, contentType string) []byte {
+	if !isEventStream(contentType) {
+		return body
+	}
+
+	var final []byte
+	var data []byte
+	hasData := false
+	events := 0
+
+	dispatch := func() {
+		if hasData && isJSONRPCResponse(data) {
+			final = append(final[:0], data...)
+		}
+		data = data[:0]
+		hasData = false
+		events++
+	}
+
+	rest := body
+	for len(rest) > 0 && events < maxSSEEvents {
+		line := rest
+		if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+			line, rest = rest[:i], rest[i+1:]
+		} else {
+			rest = nil
+		}
+		line = bytes.TrimSuffix(line, []byte("\r"))
+
+		if len(line) == 0 {
+			dispatch()
+			continue
+		}
+
+		field, value, found := bytes.Cut(line, []byte(":"))
+		if !found || string(field) != "data" {
+			continue // comments, other fields and malformed lines are ignored
+		}
+		value = bytes.TrimPrefix(value, []byte(" "))
+		if hasData {
+			data = append(data, '\n')
+		}
+		data = append(data, value...)
+		hasData = true
+	}
+	if events < maxSSEEvents {
+		dispatch() // a stream may end without a trailing blank line
+	}
+
+	if final == nil {
+		return body
+	}
+	return final
+}
+
+// isEventStream reports whether contentType is text/event-stream.
+func isEventStream(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	return strings.EqualFold(strings.TrimSpace(mediaType), "text/event-stream")
+}
+
+// sseEvent is one event of an SSE stream. Data holds the data lines joined with "\n".
+type sseEvent struct {
+	Event   string
+	ID      string
+	Retry   string
+	Data    string
+	HasData bool
+}
+
+// parseSSEEvents splits an SSE stream body into events. Comments, unknown fields and events
+// past maxSSEEvents are dropped; a stream may end without a trailing blank line.
+func parseSSEEvents(body []byte) []sseEvent {
+	var events []sseEvent
+	var current sseEvent
+	pending := false
+
+	rest := body
+	for len(rest) > 0 && len(events) < maxSSEEvents {
+		line := rest
+		if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+			line, rest = rest[:i], rest[i+1:]
+		} else {
+			rest = nil
+		}
+		line = bytes.TrimSuffix(line, []byte("\r"))
+
+		if len(line) == 0 {
+			if pending {
+				events = append(events, current)
+			}
+			current, pending = sseEvent{}, false
+			continue
+		}
+
+		field, value, _ := bytes.Cut(line, []byte(":"))
+		value = bytes.TrimPrefix(value, []byte(" "))
+		switch string(field) {
+		case "event":
+			current.Event = string(value)
+		case "id":
+			current.ID = string(value)
+		case "retry":
+			current.Retry = string(value)
+		case "data":
+			if current.HasData {
+				current.Data += "\n"
+			}
+			current.Data += string(value)
+			current.HasData = true
+		default:
+			continue // comments and unknown fields
+		}
+		pending = true
+	}
+	if pending && len(events) < maxSSEEvents {
+		events = append(events, current)
+	}
+	return events
+}
+
+// writeSSEEvent appends the wire form of e to buf, terminated by a blank line.
+func writeSSEEvent(buf *bytes.Buffer, e sseEvent) {
+	writeField := func(name, value string) {
+		buf.WriteString(name)
+		buf.WriteString(": ")
+		buf.WriteString(value)
+		buf.WriteByte('\n')
+	}
+	if e.Event != "" {
+		writeField("event", e.Event)
+	}
+	if e.ID != "" {
+		writeField("id", e.ID)
+	}
+	if e.Retry != "" {
+		writeField("retry", e.Retry)
+	}
+	if e.HasData {
+		for _, line := range strings.Split(e.Data, "\n") {
+			writeField("data", line)
+		}
+	}
+	buf.WriteByte('\n')
+}
+
+// reframeSSEResponse re-frames a JSON body the policy returned for a text/event-stream upstream
+// response as a single SSE event and restores upstreamContentType, so streaming clients can read
+// the modified message. Other bodies, such as the stream passed through or re-framed by
+// sse_event_filtering, are returned unchanged.
+func reframeSSEResponse(upstreamContentType string, body []byte, headers map[string][]string) ([]byte, map[string][]string) {
+	trimmed := bytes.TrimSpace(body)
+	if !isEventStream(upstreamContentType) || len(trimmed) == 0 || (trimmed[0] != '{' && trimmed[0] != '[') || !json.Valid(trimmed) {
+		return body, headers
+	}
+	var compact bytes.Buffer
+	json.Compact(&compact, trimmed)
+	var buf bytes.Buffer
+	writeSSEEvent(&buf, sseEvent{Event: "message", Data: compact.String(), HasData: true})
+
+	reframed := make(map[string][]string, len(headers)+1)
+	for name, values := range headers {
+		if !strings.EqualFold(name, "Content-Type") && !strings.EqualFold(name, "Content-Length") {
+			reframed[name] = values
+		}
+	}
+	reframed["content-type"] = []string{upstreamContentType}
+	return buf.Bytes(), reframed
+}
+
+// isJSONRPCResponse reports whether data is a JSON-RPC 2.0 response (result or error member).
+func isJSONRPCResponse(data []byte) bool {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || trimmed[0] != '{' || jsonDepthExceeds(trimmed, maxJSONDepth) {
+		return false
+	}
+	var resp jsonRPCResponse
+	if err := json.Unmarshal(trimmed, &resp); err != nil {
+		return false
+	}
+	return resp.Jsonrpc == "2.0" && (resp.Result != nil || resp.Error != nil)
+}
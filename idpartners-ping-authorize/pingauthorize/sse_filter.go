@@ -0,0 +1,76 @@
+package pingauthorize
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+)
+
+// maxSSEFilterEvents caps the events of a response sse_event_filtering evaluates one by one. A
+// response with more data events is evaluated as a whole, so one response cannot turn into
+// thousands of sideband calls.
+const maxSSEFilterEvents = 100
+
+// evaluateResponse sends payload to the response phase of provider. With sse_event_filtering, a
+// text/event-stream response is evaluated event by event instead of as a whole.
+func evaluateResponse(ctx context.Context, conf *Config, provider PolicyProvider, payload *SidebandResponsePayload, logger *PluginLogger) (*SidebandResponseResult, error) {
+	if conf.SSEEventFiltering && isEventStream(firstValue(FlattenHeaders(payload.Headers)["content-type"])) {
+		return filterSSEEvents(ctx, provider, payload, logger)
+	}
+	return provider.EvaluateResponse(ctx, payload)
+}
+
+// filterSSEEvents evaluates every event of an SSE response that carries data, with the event data
+// as the payload body, and returns the upstream response with the stream re-framed from the
+// permitted events. An event is permitted when the policy answers with a status below 400 and a
+// non-empty body, which replaces the event data; event, id and retry fields are kept. Events
+// without data are kept unevaluated. A failed call fails the whole response. Responses with more
+// than maxSSEFilterEvents data events are evaluated as a whole.
+func filterSSEEvents(ctx context.Context, provider PolicyProvider, payload *SidebandResponsePayload, logger *PluginLogger) (*SidebandResponseResult, error) {
+	events := parseSSEEvents([]byte(payload.Body))
+	dataEvents := 0
+	for _, event := range events {
+		if event.HasData {
+			dataEvents++
+		}
+	}
+	if dataEvents > maxSSEFilterEvents {
+		logger.Warn("SSE response has too many events to filter, evaluating it as a whole", "events", dataEvents, "max_events", maxSSEFilterEvents)
+		return provider.EvaluateResponse(ctx, payload)
+	}
+
+	var buf bytes.Buffer
+	dropped := 0
+	for _, event := range events {
+		if event.HasData {
+			eventPayload := *payload
+			eventPayload.Body = event.Data
+			result, err := provider.EvaluateResponse(ctx, &eventPayload)
+			if err != nil {
+				return nil, err
+			}
+			status, err := strconv.Atoi(result.ResponseCode)
+			if (err == nil && status >= 400) || result.Body == "" {
+				dropped++
+				continue
+			}
+			event.Data = result.Body
+		}
+		writeSSEEvent(&buf, event)
+	}
+	if dropped > 0 {
+		logger.Info("SSE events removed by policy", "dropped", dropped)
+	}
+
+	headers := make([]map[string]string, 0, len(payload.Headers))
+	for _, entry := range payload.Headers {
+		if _, ok := entry["content-length"]; !ok {
+			headers = append(headers, entry)
+		}
+	}
+	return &SidebandResponseResult{
+		ResponseCode: payload.ResponseCode,
+		Body:         buf.String(),
+		Headers:      headers,
+	}, nil
+}
@@ -0,0 +1,160 @@
+package pingauthorize
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseSSEEvents(t *testing.T) {
+	body := ": keep-alive\n\n" +
+		"retry: 3000\n\n" +
+		"event: message\r\nid: 1\r\ndata: {\"a\":1}\r\n\r\n" +
+		"data: line1\ndata:line2\nunknown: x\n\n" +
+		"id: 2\ndata: last"
+
+	want := []sseEvent{
+		{Retry: "3000"},
+		{Event: "message", ID: "1", Data: `{"a":1}`, HasData: true},
+		{Data: "line1\nline2", HasData: true},
+		{ID: "2", Data: "last", HasData: true},
+	}
+	got := parseSSEEvents([]byte(body))
+	if len(got) != len(want) {
+		t.Fatalf("got %d events %+v, want %d", len(got), got, len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("event %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestWriteSSEEvent(t *testing.T) {
+	var buf bytes.Buffer
+	writeSSEEvent(&buf, sseEvent{Event: "message", ID: "7", Retry: "100", Data: "a\nb", HasData: true})
+	writeSSEEvent(&buf, sseEvent{Retry: "5"})
+
+	want := "event: message\nid: 7\nretry: 100\ndata: a\ndata: b\n\nretry: 5\n\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+	if events := parseSSEEvents(buf.Bytes()); len(events) != 2 || events[0].Data != "a\nb" {
+		t.Errorf("round trip = %+v", events)
+	}
+}
+
+func TestMiddleware_SSEEventFiltering(t *testing.T) {
+	stream := "retry: 1000\n\n" +
+		"event: message\nid: 1\ndata: public\n\n" +
+		"event: message\nid: 2\ndata: secret\n\n" +
+		"id: 3\ndata: redact me\n\n"
+
+	var evaluated []string
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/sideband/request") {
+			var req SidebandAccessRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			json.NewEncoder(w).Encode(SidebandAccessResponse{Method: req.Method, URL: req.URL, Headers: req.Headers})
+			return
+		}
+		var payload SidebandResponsePayload
+		json.NewDecoder(r.Body).Decode(&payload)
+		evaluated = append(evaluated, payload.Body)
+		switch payload.Body {
+		case "secret":
+			json.NewEncoder(w).Encode(SidebandResponseResult{ResponseCode: "403", Body: `{"message":"denied"}`})
+		case "redact me":
+			json.NewEncoder(w).Encode(SidebandResponseResult{ResponseCode: "200", Body: "redacted"})
+		default:
+			json.NewEncoder(w).Encode(SidebandResponseResult{ResponseCode: "200", Body: payload.Body})
+		}
+	})
+	defer server.Close()
+
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Write([]byte(stream))
+	})
+
+	tests := []struct {
+		name          string
+		filtering     bool
+		wantBody      string
+		wantEvaluated int
+	}{
+		{
+			"enabled",
+			true,
+			"retry: 1000\n\nevent: message\nid: 1\ndata: public\n\nid: 3\ndata: redacted\n\n",
+			3,
+		},
+		{"disabled", false, "", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			evaluated = nil
+			m := newTestMiddleware(t, server.URL, true)
+			m.conf.SSEEventFiltering = tt.filtering
+			rec := httptest.NewRecorder()
+			m.Handler(upstream).ServeHTTP(rec, httptest.NewRequest("GET", "http://api.example.com/events", nil))
+
+			if len(evaluated) != tt.wantEvaluated {
+				t.Errorf("evaluated %q, want %d calls", evaluated, tt.wantEvaluated)
+			}
+			if !tt.filtering {
+				return
+			}
+			if rec.Code != 200 || rec.Body.String() != tt.wantBody {
+				t.Errorf("got %d %q, want %q", rec.Code, rec.Body.String(), tt.wantBody)
+			}
+			if rec.Header().Get("Content-Type") != "text/event-stream" || rec.Header().Get("Cache-Control") != "no-cache" {
+				t.Errorf("upstream headers not kept: %v", rec.Header())
+			}
+		})
+	}
+}
+
+// responseCountingProvider counts response phase calls and echoes the payload.
+type responseCountingProvider struct {
+	countingProvider
+	responses []string
+}
+
+func (p *responseCountingProvider) EvaluateResponse(ctx context.Context, req *SidebandResponsePayload) (*SidebandResponseResult, error) {
+	p.responses = append(p.responses, req.Body)
+	return &SidebandResponseResult{ResponseCode: req.ResponseCode, Body: req.Body, Headers: req.Headers}, nil
+}
+
+func TestFilterSSEEvents_MaxEvents(t *testing.T) {
+	tests := []struct {
+		name      string
+		events    int
+		wantCalls int
+	}{
+		{"at the cap", maxSSEFilterEvents, maxSSEFilterEvents},
+		{"over the cap", maxSSEFilterEvents + 1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := strings.Repeat("data: x\n\n", tt.events)
+			payload := &SidebandResponsePayload{ResponseCode: "200", Body: stream, Headers: []map[string]string{{"content-type": "text/event-stream"}}}
+			provider := &responseCountingProvider{}
+			result, err := filterSSEEvents(context.Background(), provider, payload, NewPluginLogger(nil, "response", ""))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(provider.responses) != tt.wantCalls {
+				t.Errorf("made %d calls, want %d", len(provider.responses), tt.wantCalls)
+			}
+			if tt.wantCalls == 1 && (provider.responses[0] != stream || result.Body != stream) {
+				t.Errorf("expected the whole stream to be evaluated, got %q", provider.responses[0])
+			}
+		})
+	}
+}
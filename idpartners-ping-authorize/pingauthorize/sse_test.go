@@ -0,0 +1,187 @@
+package pingauthorize
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const sseContentType = "text/event-stream"
+
+func TestParseSSEFinalMessage(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		contentType string
+		want        string
+	}{
+		{
+			name:        "single event",
+			body:        "data: {\"jsonrpc\":\"2.0\",\"id\":1,\"result\":{}}\n\n",
+			contentType: sseContentType,
+			want:        `{"jsonrpc":"2.0","id":1,"result":{}}`,
+		},
+		{
+			name: "last response wins",
+			body: "data: {\"jsonrpc\":\"2.0\",\"id\":1,\"result\":{\"n\":1}}\n\n" +
+				"data: {\"jsonrpc\":\"2.0\",\"id\":2,\"result\":{\"n\":2}}\n\n",
+			contentType: sseContentType,
+			want:        `{"jsonrpc":"2.0","id":2,"result":{"n":2}}`,
+		},
+		{
+			name: "notifications before response",
+			body: "event: message\ndata: {\"jsonrpc\":\"2.0\",\"method\":\"notifications/progress\"}\n\n" +
+				"id: 9\ndata: {\"jsonrpc\":\"2.0\",\"id\":1,\"error\":{\"code\":-32000,\"message\":\"x\"}}\n\n" +
+				"data: {\"jsonrpc\":\"2.0\",\"method\":\"notifications/message\"}\n\n",
+			contentType: sseContentType,
+			want:        `{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"x"}}`,
+		},
+		{
+			name:        "multi-line data and CRLF",
+			body:        "data: {\"jsonrpc\":\"2.0\",\r\ndata: \"id\":1,\"result\":true}\r\n\r\n",
+			contentType: "text/event-stream; charset=utf-8",
+			want:        "{\"jsonrpc\":\"2.0\",\n\"id\":1,\"result\":true}",
+		},
+		{
+			name:        "no trailing blank line",
+			body:        "data:{\"jsonrpc\":\"2.0\",\"id\":1,\"result\":{}}",
+			contentType: sseContentType,
+			want:        `{"jsonrpc":"2.0","id":1,"result":{}}`,
+		},
+		{
+			name:        "non-JSON data skipped",
+			body:        "data: {\"jsonrpc\":\"2.0\",\"id\":1,\"result\":{}}\n\ndata: not json\n\n",
+			contentType: sseContentType,
+			want:        `{"jsonrpc":"2.0","id":1,"result":{}}`,
+		},
+		{
+			name:        "application/json returned as-is",
+			body:        `{"jsonrpc":"2.0","id":1,"result":{}}`,
+			contentType: "application/json",
+			want:        `{"jsonrpc":"2.0","id":1,"result":{}}`,
+		},
+		{
+			name:        "empty body",
+			body:        "",
+			contentType: sseContentType,
+			want:        "",
+		},
+		{
+			name:        "malformed stream returned as-is",
+			body:        "{\"jsonrpc\":\"2.0\",\"id\":1,\"result\":{}}\n\n",
+			contentType: sseContentType,
+			want:        "{\"jsonrpc\":\"2.0\",\"id\":1,\"result\":{}}\n\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ParseSSEFinalMessage([]byte(tt.body), tt.contentType)
+			if string(got) != tt.want {
+				t.Errorf("ParseSSEFinalMessage() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSSEFinalMessage_ManyEvents(t *testing.T) {
+	var b strings.Builder
+	for i := 0; i < 5000; i++ {
+		fmt.Fprintf(&b, "data: {\"jsonrpc\":\"2.0\",\"id\":%d,\"result\":{}}\n\n", i)
+	}
+	got := ParseSSEFinalMessage([]byte(b.String()), sseContentType)
+	if string(got) != `{"jsonrpc":"2.0","id":4999,"result":{}}` {
+		t.Errorf("expected final event, got %q", got)
+	}
+}
+
+func TestReframeSSEResponse(t *testing.T) {
+	headers := map[string][]string{"content-type": {"application/json"}, "content-length": {"42"}, "x-policy": {"1"}}
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantBody    string
+		wantType    string
+	}{
+		{"json for sse", "text/event-stream; charset=utf-8", "{\n  \"jsonrpc\": \"2.0\", \"id\": 1, \"result\": {}\n}",
+			"event: message\ndata: {\"jsonrpc\":\"2.0\",\"id\":1,\"result\":{}}\n\n", "text/event-stream; charset=utf-8"},
+		{"sse passed through", sseContentType, "data: {}\n\n", "data: {}\n\n", "application/json"},
+		{"json upstream", "application/json", `{"jsonrpc":"2.0","id":1,"result":{}}`, `{"jsonrpc":"2.0","id":1,"result":{}}`, "application/json"},
+		{"invalid json", sseContentType, `{"jsonrpc"`, `{"jsonrpc"`, "application/json"},
+		{"empty", sseContentType, "", "", "application/json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, got := reframeSSEResponse(tt.contentType, []byte(tt.body), headers)
+			if string(body) != tt.wantBody {
+				t.Errorf("body = %q, want %q", body, tt.wantBody)
+			}
+			if got["content-type"][0] != tt.wantType || got["x-policy"][0] != "1" {
+				t.Errorf("unexpected headers %v", got)
+			}
+			if _, ok := got["content-length"]; ok == (tt.wantBody != tt.body) {
+				t.Errorf("content-length kept = %v for body %q", ok, body)
+			}
+		})
+	}
+}
+
+func TestMiddleware_ReframeModifiedSSEResponse(t *testing.T) {
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/sideband/response") {
+			json.NewEncoder(w).Encode(SidebandResponseResult{
+				ResponseCode: "200",
+				Body:         `{"jsonrpc":"2.0","id":1,"result":{"content":[{"type":"text","text":"[redacted]"}]}}`,
+				Headers:      []map[string]string{{"content-type": "application/json"}},
+			})
+			return
+		}
+		var req SidebandAccessRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(SidebandAccessResponse{Method: req.Method, URL: req.URL, Headers: req.Headers})
+	})
+	defer server.Close()
+
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", sseContentType)
+		w.Write([]byte("data: {\"jsonrpc\":\"2.0\",\"id\":1,\"result\":{\"content\":[{\"type\":\"text\",\"text\":\"secret\"}]}}\n\n"))
+	})
+	m := newTestMiddleware(t, server.URL, true)
+	rec := httptest.NewRecorder()
+	body := `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"search"}}`
+	m.Handler(upstream).ServeHTTP(rec, httptest.NewRequest("POST", "http://api.example.com/mcp", strings.NewReader(body)))
+
+	if got := rec.Header().Get("Content-Type"); got != sseContentType {
+		t.Errorf("Content-Type = %q, want %q", got, sseContentType)
+	}
+	final := ParseSSEFinalMessage(rec.Body.Bytes(), sseContentType)
+	if !strings.Contains(string(final), "[redacted]") || !strings.HasSuffix(rec.Body.String(), "\n\n") {
+		t.Errorf("expected the modified message as an SSE event, got %q", rec.Body.String())
+	}
+}
+
+func FuzzParseSSEFinalMessage(f *testing.F) {
+	f.Add([]byte("data: {\"jsonrpc\":\"2.0\",\"id\":1,\"result\":{}}\n\n"))
+	f.Add([]byte("event: x\r\ndata: {\"jsonrpc\":\"2.0\",\r\ndata: \"id\":1,\"error\":{}}\r\n\r\n"))
+	f.Add([]byte(":comment\ndata\ndata:\n\n"))
+	f.Add([]byte("data: " + strings.Repeat("[", 100)))
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		original := string(body)
+		got := ParseSSEFinalMessage(body, sseContentType)
+		if string(body) != original {
+			t.Fatal("input was modified")
+		}
+		if string(got) == original {
+			return
+		}
+		var resp jsonRPCResponse
+		if err := json.Unmarshal(got, &resp); err != nil || resp.Jsonrpc != "2.0" {
+			t.Fatalf("extracted message is not a JSON-RPC response: %q", got)
+		}
+	})
+}
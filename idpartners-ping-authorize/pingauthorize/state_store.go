@@ -0,0 +1,229 @@
+package pingauthorize
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// storeCookie selects the state_store that seals the per-request context into Kong's context.
+const storeCookie = "cookie"
+
+// maxMemoryStateEntries bounds the per-request contexts kept by the memory state store. Entries
+// are removed when the response phase reads them, so only requests whose response phase never
+// runs stay until they expire.
+const maxMemoryStateEntries = 100000
+
+// minCompressedStateBytes is the size from which the state kept in Kong's context is compressed.
+const minCompressedStateBytes = 1024
+
+// gzipStatePrefix marks compressed state in Kong's context. State is JSON, so it never starts
+// with the prefix itself.
+const gzipStatePrefix = "gzip:"
+
+// stateStore keeps the original request and state of the access phase for the response phase.
+// Only the reference returned by save is kept in Kong's per-request context.
+type stateStore interface {
+	save(ctx context.Context, originalRequest *SidebandAccessRequest, state json.RawMessage) (string, error)
+	load(ctx context.Context, ref string) (*SidebandAccessRequest, json.RawMessage, error)
+}
+
+// redisStateStore keeps per-request contexts in Redis.
+type redisStateStore struct {
+	conf *Config
+}
+
+func (s *redisStateStore) save(ctx context.Context, originalRequest *SidebandAccessRequest, state json.RawMessage) (string, error) {
+	return storeRedisState(ctx, s.conf, originalRequest, state)
+}
+
+func (s *redisStateStore) load(ctx context.Context, ref string) (*SidebandAccessRequest, json.RawMessage, error) {
+	return loadRedisState(ctx, s.conf, ref)
+}
+
+// memoryStateStore keeps per-request contexts in the memory of the plugin server process, which
+// runs both phases of a request.
+type memoryStateStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryStateEntry
+	ttl     time.Duration
+	now     func() time.Time
+}
+
+type memoryStateEntry struct {
+	request *SidebandAccessRequest
+	state   json.RawMessage
+	expires time.Time
+}
+
+func newMemoryStateStore(ttl time.Duration) *memoryStateStore {
+	return &memoryStateStore{entries: make(map[string]memoryStateEntry), ttl: ttl, now: time.Now}
+}
+
+func (s *memoryStateStore) save(_ context.Context, originalRequest *SidebandAccessRequest, state json.RawMessage) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	key := hex.EncodeToString(id)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if len(s.entries) >= maxMemoryStateEntries {
+		for k, e := range s.entries {
+			if now.After(e.expires) {
+				delete(s.entries, k)
+			}
+		}
+		if len(s.entries) >= maxMemoryStateEntries {
+			return "", fmt.Errorf("memory state store is full (%d entries)", len(s.entries))
+		}
+	}
+	s.entries[key] = memoryStateEntry{request: originalRequest, state: state, expires: now.Add(s.ttl)}
+	return key, nil
+}
+
+func (s *memoryStateStore) load(_ context.Context, ref string) (*SidebandAccessRequest, json.RawMessage, error) {
+	s.mu.Lock()
+	e, ok := s.entries[ref]
+	delete(s.entries, ref)
+	s.mu.Unlock()
+	if !ok || s.now().After(e.expires) {
+		return nil, nil, fmt.Errorf("state %s not found in memory (expired?)", ref)
+	}
+	if e.request == nil {
+		e.request = &SidebandAccessRequest{}
+	}
+	return e.request, e.state, nil
+}
+
+// cookieStateStore seals per-request contexts with AES-256-GCM into the reference itself, like an
+// encrypted session cookie, so that other plugins and logs reading Kong's context only see
+// ciphertext, and tampering is detected when the response phase opens it.
+type cookieStateStore struct {
+	aead cipher.AEAD
+}
+
+// newCookieStateStore creates a cookie state store with a key derived from secret.
+func newCookieStateStore(secret string) (*cookieStateStore, error) {
+	key := sha256.Sum256([]byte("paz-state:" + secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &cookieStateStore{aead: aead}, nil
+}
+
+func (s *cookieStateStore) save(_ context.Context, originalRequest *SidebandAccessRequest, state json.RawMessage) (string, error) {
+	data, err := json.Marshal(&redisState{Request: originalRequest, State: state})
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, s.aead.NonceSize(), s.aead.NonceSize()+len(data)+s.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(s.aead.Seal(nonce, nonce, data, nil)), nil
+}
+
+func (s *cookieStateStore) load(_ context.Context, ref string) (*SidebandAccessRequest, json.RawMessage, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(ref)
+	if err != nil || len(sealed) < s.aead.NonceSize() {
+		return nil, nil, fmt.Errorf("malformed sealed state")
+	}
+	nonce, ciphertext := sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():]
+	data, err := s.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open sealed state: %w", err)
+	}
+
+	var st redisState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal sealed state: %w", err)
+	}
+	if st.Request == nil {
+		st.Request = &SidebandAccessRequest{}
+	}
+	return st.Request, st.State, nil
+}
+
+// getStateStore returns the store of the config's state_store, or nil for kong, which keeps the
+// per-request context in Kong's context directly.
+func (c *Config) getStateStore() stateStore {
+	c.stateStoreOnce.Do(func() {
+		switch c.StateStore {
+		case storeRedis:
+			c.stateStore = &redisStateStore{conf: c}
+		case storeMemory:
+			ttl := time.Duration(c.RedisStateTTLMs) * time.Millisecond
+			if ttl <= 0 {
+				ttl = defaultRedisStateTTLMs * time.Millisecond
+			}
+			c.stateStore = newMemoryStateStore(ttl)
+		case storeCookie:
+			// The secret is checked by Validate; a failure leaves the store unset, using Kong's context.
+			if s, err := newCookieStateStore(c.StateStoreSecret); err == nil {
+				c.stateStore = s
+			}
+		}
+	})
+	return c.stateStore
+}
+
+// encodeKongState returns the value of paz_state for state: the JSON itself, or gzipStatePrefix
+// and the base64 of its gzip compression when that is smaller. Returns an error when the value
+// exceeds max_state_bytes.
+func encodeKongState(conf *Config, state json.RawMessage) (string, error) {
+	value := string(state)
+	if len(state) >= minCompressedStateBytes {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(state)
+		zw.Close()
+		if compressed := gzipStatePrefix + base64.StdEncoding.EncodeToString(buf.Bytes()); len(compressed) < len(value) {
+			value = compressed
+		}
+	}
+	if conf.MaxStateBytes > 0 && len(value) > conf.MaxStateBytes {
+		return "", fmt.Errorf("state of %d bytes (%d stored) exceeds max_state_bytes %d", len(state), len(value), conf.MaxStateBytes)
+	}
+	return value, nil
+}
+
+// decodeKongState reverses encodeKongState.
+func decodeKongState(value string) (json.RawMessage, error) {
+	encoded, ok := strings.CutPrefix(value, gzipStatePrefix)
+	if !ok {
+		return json.RawMessage(value), nil
+	}
+	compressed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode compressed state: %w", err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress state: %w", err)
+	}
+	state, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress state: %w", err)
+	}
+	return state, nil
+}
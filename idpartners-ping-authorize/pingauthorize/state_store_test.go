@@ -0,0 +1,157 @@
+package pingauthorize
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStateStores_RoundTrip(t *testing.T) {
+	cookie, err := newCookieStateStore("state-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stores := map[string]stateStore{
+		storeMemory: newMemoryStateStore(time.Minute),
+		storeCookie: cookie,
+	}
+	ctx := context.Background()
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			req := &SidebandAccessRequest{Method: "POST", URL: "https://api.example.com/pay", Body: `{"card":"4111"}`}
+			ref, err := store.save(ctx, req, json.RawMessage(`{"s":1}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(ref, "4111") {
+				t.Errorf("reference %q exposes the request", ref)
+			}
+			got, state, err := store.load(ctx, ref)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Method != "POST" || got.Body != req.Body || string(state) != `{"s":1}` {
+				t.Errorf("unexpected state: %+v %s", got, state)
+			}
+
+			if _, _, err := store.load(ctx, "bogus"); err == nil {
+				t.Error("expected an unknown reference to fail")
+			}
+		})
+	}
+}
+
+func TestMemoryStateStore_Expiry(t *testing.T) {
+	now := time.Unix(1000, 0)
+	store := newMemoryStateStore(time.Second)
+	store.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	ref, _ := store.save(ctx, nil, nil)
+	if got, _, err := store.load(ctx, ref); err != nil || got == nil {
+		t.Fatalf("load() = %v, %v", got, err)
+	}
+	if _, _, err := store.load(ctx, ref); err == nil {
+		t.Error("expected state to be deleted once loaded")
+	}
+
+	ref, _ = store.save(ctx, nil, nil)
+	now = now.Add(2 * time.Second)
+	if _, _, err := store.load(ctx, ref); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("expected expired state to fail, got %v", err)
+	}
+}
+
+func TestCookieStateStore_Tampering(t *testing.T) {
+	store, _ := newCookieStateStore("state-secret")
+	ctx := context.Background()
+	ref, err := store.save(ctx, &SidebandAccessRequest{Method: "GET"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := []byte(ref)
+	mid := len(tampered) / 2
+	if tampered[mid] == 'A' {
+		tampered[mid] = 'B'
+	} else {
+		tampered[mid] = 'A'
+	}
+	if _, _, err := store.load(ctx, string(tampered)); err == nil {
+		t.Error("expected tampered state to fail")
+	}
+
+	other, _ := newCookieStateStore("other-secret")
+	if _, _, err := other.load(ctx, ref); err == nil {
+		t.Error("expected state sealed with another secret to fail")
+	}
+}
+
+func TestConfig_GetStateStore(t *testing.T) {
+	tests := []struct {
+		conf *Config
+		want string
+	}{
+		{&Config{StateStore: storeKong}, ""},
+		{&Config{StateStore: storeMemory}, "*pingauthorize.memoryStateStore"},
+		{&Config{StateStore: storeRedis}, "*pingauthorize.redisStateStore"},
+		{&Config{StateStore: storeCookie, StateStoreSecret: "k"}, "*pingauthorize.cookieStateStore"},
+	}
+	for _, tt := range tests {
+		store := tt.conf.getStateStore()
+		got := ""
+		if store != nil {
+			got = fmt.Sprintf("%T", store)
+		}
+		if got != tt.want {
+			t.Errorf("state_store %s: got %s, want %s", tt.conf.StateStore, got, tt.want)
+		}
+	}
+}
+
+func TestEncodeKongState(t *testing.T) {
+	small := json.RawMessage(`{"s":1}`)
+	large := json.RawMessage(`{"items":"` + strings.Repeat("abcdef", 1000) + `"}`)
+
+	tests := []struct {
+		name           string
+		state          json.RawMessage
+		maxStateBytes  int
+		wantCompressed bool
+		wantErr        bool
+	}{
+		{"small", small, 0, false, false},
+		{"large", large, 0, true, false},
+		{"large within cap", large, 1000, true, false},
+		{"over cap", large, 10, false, true},
+		{"small over cap", small, 4, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := encodeKongState(&Config{MaxStateBytes: tt.maxStateBytes}, tt.state)
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "max_state_bytes") {
+					t.Fatalf("expected max_state_bytes error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if compressed := strings.HasPrefix(value, gzipStatePrefix); compressed != tt.wantCompressed {
+				t.Errorf("compressed = %v, want %v (%d bytes)", compressed, tt.wantCompressed, len(value))
+			}
+			state, err := decodeKongState(value)
+			if err != nil || string(state) != string(tt.state) {
+				t.Errorf("decodeKongState() = %.40s, %v", state, err)
+			}
+		})
+	}
+
+	if _, err := decodeKongState(gzipStatePrefix + "!!"); err == nil {
+		t.Error("expected malformed compressed state to fail")
+	}
+}
@@ -0,0 +1,85 @@
+package pingauthorize
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// SidebandThrottledError is returned when sideband_rate_limit leaves no capacity for a call within
+// sideband_rate_limit_queue_ms. Wait is how long the caller would have had to wait.
+type SidebandThrottledError struct {
+	Wait time.Duration
+}
+
+func (e *SidebandThrottledError) Error() string {
+	return "sideband call rate limit exceeded"
+}
+
+// tokenBucket is a token bucket limiter. Waiting callers reserve a token up front so that queued
+// calls are released in arrival order at the configured rate.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+// newTokenBucket creates a full bucket. A burst of 0 defaults to the rate rounded up (at least 1).
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	b := float64(burst)
+	if burst <= 0 {
+		b = math.Max(1, math.Ceil(rate))
+	}
+	return &tokenBucket{rate: rate, burst: b, tokens: b, last: time.Now(), now: time.Now}
+}
+
+// reserve takes a token, returning how long the caller must wait before using it. If that is longer
+// than maxWait no token is taken and ok is false.
+func (b *tokenBucket) reserve(maxWait time.Duration) (wait time.Duration, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, true
+	}
+	wait = time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	if wait > maxWait {
+		return wait, false
+	}
+	b.tokens--
+	return wait, true
+}
+
+// wait blocks until a token is available, for at most maxWait. It returns a *SidebandThrottledError
+// when the bucket cannot supply a token in time, or the context error if ctx ends first.
+func (b *tokenBucket) wait(ctx context.Context, maxWait time.Duration) error {
+	d, ok := b.reserve(maxWait)
+	if !ok {
+		return &SidebandThrottledError{Wait: d}
+	}
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// throttledResponse builds the 429 returned for a call rejected by sideband_rate_limit.
+func throttledResponse(err *SidebandThrottledError) ([]byte, map[string][]string) {
+	return limitExceededResponse(err.Wait.Milliseconds())
+}
@@ -0,0 +1,139 @@
+package pingauthorize
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestBucket(rate float64, burst int) (*tokenBucket, *time.Time) {
+	now := time.Unix(1000, 0)
+	b := newTokenBucket(rate, burst)
+	b.now = func() time.Time { return now }
+	b.last = now
+	return b, &now
+}
+
+func TestTokenBucket_Reserve(t *testing.T) {
+	b, now := newTestBucket(10, 2)
+
+	for i := 0; i < 2; i++ {
+		if wait, ok := b.reserve(0); !ok || wait != 0 {
+			t.Fatalf("burst token %d: wait=%v ok=%v", i, wait, ok)
+		}
+	}
+	if wait, ok := b.reserve(0); ok || wait != 100*time.Millisecond {
+		t.Errorf("expected rejection with 100ms wait, got wait=%v ok=%v", wait, ok)
+	}
+	if wait, ok := b.reserve(time.Second); !ok || wait != 100*time.Millisecond {
+		t.Errorf("expected queued reservation of 100ms, got wait=%v ok=%v", wait, ok)
+	}
+	if wait, ok := b.reserve(time.Second); !ok || wait != 200*time.Millisecond {
+		t.Errorf("queued callers should be spaced at the rate, got wait=%v ok=%v", wait, ok)
+	}
+
+	*now = now.Add(10 * time.Second)
+	if _, ok := b.reserve(0); !ok {
+		t.Error("bucket should refill over time")
+	}
+	if b.tokens > b.burst {
+		t.Errorf("tokens %v exceed burst %v", b.tokens, b.burst)
+	}
+}
+
+func TestNewTokenBucket_DefaultBurst(t *testing.T) {
+	if b := newTokenBucket(0.5, 0); b.burst != 1 {
+		t.Errorf("expected burst 1, got %v", b.burst)
+	}
+	if b := newTokenBucket(2.5, 0); b.burst != 3 {
+		t.Errorf("expected burst 3, got %v", b.burst)
+	}
+}
+
+func TestTokenBucket_WaitContextCancelled(t *testing.T) {
+	b, _ := newTestBucket(1, 1)
+	b.reserve(0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := b.wait(ctx, time.Hour); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestExecute_Throttled(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	parsed, _ := ParseURL(server.URL)
+	config := &Config{
+		ServiceURL:            server.URL,
+		SharedSecret:          "secret",
+		SecretHeaderName:      "X-Secret",
+		ConnectionTimeoutMs:   5000,
+		ConnectionKeepaliveMs: 60000,
+		RetryBackoffMs:        100,
+		SidebandRateLimit:     0.001,
+		SidebandRateBurst:     1,
+	}
+	client := NewSidebandHTTPClient(config)
+
+	if _, _, _, err := client.Execute(context.Background(), server.URL+"/sideband/request", []byte(`{}`), parsed); err != nil {
+		t.Fatalf("first call should pass: %v", err)
+	}
+	_, _, _, err := client.Execute(context.Background(), server.URL+"/sideband/request", []byte(`{}`), parsed)
+	if _, ok := err.(*SidebandThrottledError); !ok {
+		t.Fatalf("expected *SidebandThrottledError, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("throttled call should not reach the server, got %d calls", calls)
+	}
+}
+
+func TestMiddleware_Throttled(t *testing.T) {
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"method":"GET","url":"http://api.example.com:80/","headers":[]}`))
+	})
+	defer server.Close()
+
+	for _, failOpen := range []bool{false, true} {
+		conf := &Config{
+			ServiceURL:                server.URL,
+			SharedSecret:              "test-secret",
+			SecretHeaderName:          "X-Secret",
+			SkipResponsePhase:         true,
+			SidebandRateLimit:         0.001,
+			SidebandRateBurst:         1,
+			SidebandRateLimitFailOpen: failOpen,
+		}
+		m, err := NewMiddleware(conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		calls := 0
+		h := m.Handler(upstreamEcho(t, &calls))
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://api.example.com/", nil))
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "http://api.example.com/", nil))
+		if failOpen {
+			if rec.Code != 200 || calls != 2 {
+				t.Errorf("fail-open: expected request to be allowed, got %d with %d upstream calls", rec.Code, calls)
+			}
+			continue
+		}
+		if rec.Code != 429 || rec.Header().Get("Retry-After") == "" || !strings.Contains(rec.Body.String(), "LIMIT_EXCEEDED") {
+			t.Errorf("expected 429 LIMIT_EXCEEDED, got %d %v %s", rec.Code, rec.Header(), rec.Body.String())
+		}
+		if calls != 1 {
+			t.Errorf("throttled request should not reach upstream, got %d calls", calls)
+		}
+	}
+}
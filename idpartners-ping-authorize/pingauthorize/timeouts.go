@@ -0,0 +1,46 @@
+package pingauthorize
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// phaseContext returns ctx with the deadline of a sideband call in the named phase: the phase's
+// access_timeout_ms or response_timeout_ms, shortened to what request_budget_ms leaves after
+// spent, the time earlier sideband calls of the request took. With neither set, the call is only
+// bounded by connection_timeout_ms, and ctx is returned as is.
+func phaseContext(ctx context.Context, conf *Config, phaseName string, spent time.Duration) (context.Context, context.CancelFunc) {
+	timeout := time.Duration(conf.AccessTimeoutMs) * time.Millisecond
+	if phaseName == "response" {
+		timeout = time.Duration(conf.ResponseTimeoutMs) * time.Millisecond
+	}
+	if conf.RequestBudgetMs > 0 {
+		remaining := time.Duration(conf.RequestBudgetMs)*time.Millisecond - spent
+		if timeout <= 0 || remaining < timeout {
+			// A spent budget leaves an expired deadline, failing the call like a timeout
+			timeout = max(remaining, 0)
+		}
+	}
+	if timeout <= 0 && conf.RequestBudgetMs <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// accessLatency returns the duration of the access phase sideband call of a request, as recorded
+// in its decision context, to be charged against request_budget_ms in the response phase.
+func accessLatency(access *float64) time.Duration {
+	if access == nil {
+		return 0
+	}
+	return time.Duration(*access * float64(time.Millisecond))
+}
+
+// validateTimeouts checks access_timeout_ms, response_timeout_ms and request_budget_ms.
+func validateTimeouts(c *Config) error {
+	if c.AccessTimeoutMs < 0 || c.ResponseTimeoutMs < 0 || c.RequestBudgetMs < 0 {
+		return fmt.Errorf("access_timeout_ms, response_timeout_ms and request_budget_ms must be >= 0")
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package pingauthorize
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPhaseContext(t *testing.T) {
+	tests := []struct {
+		name      string
+		conf      *Config
+		phaseName string
+		spent     time.Duration
+		want      time.Duration // 0 means no deadline, -1 an expired one
+	}{
+		{"none", &Config{}, "access", 0, 0},
+		{"access", &Config{AccessTimeoutMs: 200, ResponseTimeoutMs: 500}, "access", 0, 200 * time.Millisecond},
+		{"response", &Config{AccessTimeoutMs: 200, ResponseTimeoutMs: 500}, "response", 0, 500 * time.Millisecond},
+		{"budget only", &Config{RequestBudgetMs: 800}, "response", 300 * time.Millisecond, 500 * time.Millisecond},
+		{"budget shortens phase", &Config{ResponseTimeoutMs: 500, RequestBudgetMs: 600}, "response", 400 * time.Millisecond, 200 * time.Millisecond},
+		{"phase within budget", &Config{AccessTimeoutMs: 100, RequestBudgetMs: 600}, "access", 0, 100 * time.Millisecond},
+		{"budget spent", &Config{ResponseTimeoutMs: 500, RequestBudgetMs: 300}, "response", 400 * time.Millisecond, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := time.Now()
+			ctx, cancel := phaseContext(context.Background(), tt.conf, tt.phaseName, tt.spent)
+			defer cancel()
+			after := time.Now()
+			deadline, ok := ctx.Deadline()
+			switch {
+			case tt.want == 0:
+				if ok {
+					t.Errorf("expected no deadline, got %v", deadline.Sub(before))
+				}
+			case tt.want < 0:
+				if ctx.Err() == nil {
+					t.Errorf("expected an expired deadline, got %v", deadline.Sub(before))
+				}
+			default:
+				if !ok || deadline.Before(before.Add(tt.want)) || deadline.After(after.Add(tt.want)) {
+					t.Errorf("deadline in %v, want %v", deadline.Sub(before), tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestAccessLatency(t *testing.T) {
+	latency := 12.5
+	if got := accessLatency(&latency); got != 12500*time.Microsecond {
+		t.Errorf("accessLatency() = %v, want 12.5ms", got)
+	}
+	if got := accessLatency(nil); got != 0 {
+		t.Errorf("accessLatency(nil) = %v, want 0", got)
+	}
+}
+
+func TestMiddleware_AccessTimeout(t *testing.T) {
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(300 * time.Millisecond):
+		case <-r.Context().Done():
+			return
+		}
+		var req SidebandAccessRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(SidebandAccessResponse{Method: req.Method, URL: req.URL, Headers: req.Headers})
+	})
+	defer server.Close()
+
+	m := newTestMiddleware(t, server.URL, false)
+	m.conf.AccessTimeoutMs = 50
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("upstream must not be called after the access phase timed out")
+	})
+
+	start := time.Now()
+	rec := httptest.NewRecorder()
+	m.Handler(upstream).ServeHTTP(rec, httptest.NewRequest("GET", "http://api.example.com/a", nil))
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Errorf("access phase took %v, want it cut at access_timeout_ms", elapsed)
+	}
+	if rec.Code < 500 {
+		t.Errorf("expected a sideband failure status, got %d", rec.Code)
+	}
+}
+
+func TestExecute_ExpiredDeadline(t *testing.T) {
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	parsed, _ := ParseURL(server.URL)
+	client := NewSidebandHTTPClient(&Config{
+		ServiceURL:            server.URL,
+		SharedSecret:          "secret",
+		SecretHeaderName:      "X-Secret",
+		ConnectionTimeoutMs:   5000,
+		ConnectionKeepaliveMs: 60000,
+		CircuitBreakerEnabled: true,
+		MaxRetries:            2,
+		RetryBackoffMs:        500,
+		RequestBudgetMs:       100,
+	})
+	requestURL := server.URL + "/sideband/request"
+
+	ctx, cancel := phaseContext(context.Background(), client.config, "response", 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, _, _, err := client.Execute(ctx, requestURL, []byte(`{}`), parsed)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("spent budget took %v, want no retry backoff", elapsed)
+	}
+	if calls != 0 {
+		t.Errorf("expected no sideband call, got %d", calls)
+	}
+	if !client.cb.get("/sideband/request").IsClosed() {
+		t.Fatal("expected a spent budget not to open the circuit breaker")
+	}
+
+	status, _, _, err := client.Execute(context.Background(), requestURL, []byte(`{}`), parsed)
+	if err != nil || status != http.StatusOK {
+		t.Errorf("expected the next call to go through, got %d %v", status, err)
+	}
+}
+
+func TestExecute_DeadlineDuringBackoff(t *testing.T) {
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(500)
+	}))
+	defer server.Close()
+
+	parsed, _ := ParseURL(server.URL)
+	client := NewSidebandHTTPClient(&Config{
+		ServiceURL:            server.URL,
+		SharedSecret:          "secret",
+		SecretHeaderName:      "X-Secret",
+		ConnectionTimeoutMs:   5000,
+		ConnectionKeepaliveMs: 60000,
+		CircuitBreakerEnabled: true,
+		MaxRetries:            2,
+		RetryBackoffMs:        1000,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, _, _, err := client.Execute(ctx, server.URL+"/sideband/request", []byte(`{}`), parsed)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("backoff ran past the deadline: %v", elapsed)
+	}
+	if calls != 1 {
+		t.Errorf("expected 1 sideband call, got %d", calls)
+	}
+	if !client.cb.get("/sideband/request").IsClosed() {
+		t.Error("expected a deadline during backoff not to open the circuit breaker")
+	}
+}
@@ -0,0 +1,80 @@
+package pingauthorize
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Kong/go-pdk"
+)
+
+// kongUpstreamTiming collects upstream latencies for the current request. Kong's own waiting and
+// receive times (ngx.ctx KONG_WAITING_TIME and KONG_RECEIVE_TIME) are preferred; the nginx
+// $upstream_*_time variables fill in the rest.
+func kongUpstreamTiming(kong *pdk.PDK) *UpstreamTiming {
+	timing := &UpstreamTiming{
+		ConnectMs: nginxTimeVar(kong, "upstream_connect_time"),
+		WaitingMs: kongCtxMs(kong, "KONG_WAITING_TIME"),
+		ReceiveMs: kongCtxMs(kong, "KONG_RECEIVE_TIME"),
+		TotalMs:   nginxTimeVar(kong, "upstream_response_time"),
+	}
+	if timing.WaitingMs == nil {
+		timing.WaitingMs = nginxTimeVar(kong, "upstream_header_time")
+	}
+	if timing.TotalMs == nil && timing.WaitingMs != nil && timing.ReceiveMs != nil {
+		total := *timing.WaitingMs + *timing.ReceiveMs
+		timing.TotalMs = &total
+	}
+	if *timing == (UpstreamTiming{}) {
+		return nil
+	}
+	return timing
+}
+
+// kongCtxMs reads a millisecond value from ngx.ctx, returning nil if it is not set.
+func kongCtxMs(kong *pdk.PDK, key string) *float64 {
+	v, err := kong.Nginx.GetCtxFloat(key)
+	if err != nil || v < 0 {
+		return nil
+	}
+	return &v
+}
+
+// nginxTimeVar reads an $upstream_*_time variable as milliseconds.
+func nginxTimeVar(kong *pdk.PDK, name string) *float64 {
+	v, err := kong.Nginx.GetVar(name)
+	if err != nil {
+		return nil
+	}
+	return parseNginxTime(v)
+}
+
+// parseNginxTime converts an nginx upstream time (seconds with millisecond resolution) to
+// milliseconds. When several upstreams were tried the value lists each attempt ("0.010, 0.004" or
+// "0.010 : 0.004"); the last attempt, which produced the response, is used. "-" yields nil.
+func parseNginxTime(v string) *float64 {
+	if i := strings.LastIndexAny(v, ",:"); i >= 0 {
+		v = v[i+1:]
+	}
+	secs, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil || secs < 0 {
+		return nil
+	}
+	ms := secs * 1000
+	return &ms
+}
+
+// measuredUpstreamTiming builds the timing of an upstream handler that started at start, wrote its
+// response header at headerAt (zero if it never wrote) and returned at end.
+func measuredUpstreamTiming(start, headerAt, end time.Time) *UpstreamTiming {
+	ms := func(d time.Duration) *float64 {
+		v := float64(d) / float64(time.Millisecond)
+		return &v
+	}
+	timing := &UpstreamTiming{TotalMs: ms(end.Sub(start))}
+	if !headerAt.IsZero() {
+		timing.WaitingMs = ms(headerAt.Sub(start))
+		timing.ReceiveMs = ms(end.Sub(headerAt))
+	}
+	return timing
+}
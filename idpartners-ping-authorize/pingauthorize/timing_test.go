@@ -0,0 +1,92 @@
+package pingauthorize
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseNginxTime(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float64
+		ok   bool
+	}{
+		{"0.012", 12, true},
+		{"1.500", 1500, true},
+		{"0.010, 0.004", 4, true},
+		{"0.010 : 0.250", 250, true},
+		{"-", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		got := parseNginxTime(tt.in)
+		if (got != nil) != tt.ok || (got != nil && *got != tt.want) {
+			t.Errorf("parseNginxTime(%q) = %v, want %v (ok=%v)", tt.in, got, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestMeasuredUpstreamTiming(t *testing.T) {
+	start := time.Unix(0, 0)
+	timing := measuredUpstreamTiming(start, start.Add(30*time.Millisecond), start.Add(45*time.Millisecond))
+	if *timing.WaitingMs != 30 || *timing.ReceiveMs != 15 || *timing.TotalMs != 45 || timing.ConnectMs != nil {
+		t.Errorf("unexpected timing: %+v", timing)
+	}
+
+	timing = measuredUpstreamTiming(start, time.Time{}, start.Add(5*time.Millisecond))
+	if timing.WaitingMs != nil || timing.ReceiveMs != nil || *timing.TotalMs != 5 {
+		t.Errorf("handler that never wrote should only report total: %+v", timing)
+	}
+}
+
+func TestMiddleware_UpstreamTiming(t *testing.T) {
+	for _, include := range []bool{false, true} {
+		var raw map[string]json.RawMessage
+		server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasSuffix(r.URL.Path, "/sideband/response") {
+				json.NewDecoder(r.Body).Decode(&raw)
+				json.NewEncoder(w).Encode(SidebandResponseResult{ResponseCode: "200"})
+				return
+			}
+			var req SidebandAccessRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			json.NewEncoder(w).Encode(SidebandAccessResponse{Method: req.Method, URL: req.URL, Headers: req.Headers})
+		})
+
+		conf := &Config{
+			ServiceURL:            server.URL,
+			SharedSecret:          "test-secret",
+			SecretHeaderName:      "X-Secret",
+			IncludeUpstreamTiming: include,
+		}
+		m, err := NewMiddleware(conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(5 * time.Millisecond)
+			w.WriteHeader(200)
+			w.Write([]byte("ok"))
+		})
+		m.Handler(upstream).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://api.example.com/", nil))
+		server.Close()
+
+		if !include {
+			if _, ok := raw["upstream_timing"]; ok {
+				t.Error("upstream_timing should be omitted unless include_upstream_timing is set")
+			}
+			continue
+		}
+		var timing UpstreamTiming
+		if err := json.Unmarshal(raw["upstream_timing"], &timing); err != nil {
+			t.Fatalf("missing upstream_timing: %v", err)
+		}
+		if timing.WaitingMs == nil || *timing.WaitingMs < 5 || timing.TotalMs == nil || *timing.TotalMs < *timing.WaitingMs {
+			t.Errorf("unexpected timing: %+v", timing)
+		}
+	}
+}
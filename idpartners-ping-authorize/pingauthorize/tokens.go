@@ -0,0 +1,77 @@
+package pingauthorize
+
+import (
+	"context"
+	"unicode"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// trafficTypeMCP labels the token counts of MCP requests and responses. MCP payloads carry an mcp
+// block rather than a traffic_type.
+const trafficTypeMCP = "mcp"
+
+// approximateTokens estimates the number of tokens a BPE tokenizer splits s into, without a
+// vocabulary: runs of letters and digits count one token per four characters, every other
+// non-space character counts one token. CJK characters are single tokens, like punctuation.
+func approximateTokens(s string) int {
+	tokens, run := 0, 0
+	for _, r := range s {
+		switch {
+		case (unicode.IsLetter(r) || unicode.IsDigit(r)) && r < 0x2E80:
+			run++
+			continue
+		case !unicode.IsSpace(r):
+			tokens++
+		}
+		tokens += (run + 3) / 4
+		run = 0
+	}
+	return tokens + (run+3)/4
+}
+
+// countRequestTokens sets body_tokens on the access payload of LLM and MCP requests when
+// token_counting is enabled, and records it in the token metrics.
+func countRequestTokens(conf *Config, payload *SidebandAccessRequest) {
+	if !conf.TokenCounting || payload.Body == "" {
+		return
+	}
+	trafficType := trafficTypeMCP
+	switch {
+	case payload.LLM != nil:
+		trafficType = trafficTypeLLM
+	case payload.MCP == nil && payload.MCPBatch == nil:
+		return
+	}
+	payload.BodyTokens = approximateTokens(payload.Body)
+	conf.metrics().recordTokens(conf.ServiceURL, "access", trafficType, payload.BodyTokens)
+}
+
+// countResponseTokens sets body_tokens on the response payload of MCP responses, and of responses
+// to requests on llm_enrichment_paths, when token_counting is enabled, and records it in the
+// token metrics.
+func countResponseTokens(conf *Config, payload *SidebandResponsePayload) {
+	if !conf.TokenCounting || payload.Body == "" {
+		return
+	}
+	trafficType := trafficTypeMCP
+	if payload.MCP == nil {
+		if !llmRoute(conf, payload.Method, payload.URL) {
+			return
+		}
+		trafficType = trafficTypeLLM
+	}
+	payload.BodyTokens = approximateTokens(payload.Body)
+	conf.metrics().recordTokens(conf.ServiceURL, "response", trafficType, payload.BodyTokens)
+}
+
+// recordTokens adds the approximate token count of a request or response body to the token
+// counter. Safe on a nil receiver.
+func (m *PluginMetrics) recordTokens(serviceURL, phaseName, trafficType string, tokens int) {
+	if m == nil || tokens == 0 {
+		return
+	}
+	m.Tokens.Add(context.Background(), int64(tokens), metric.WithAttributes(attribute.String("service_url", serviceURL),
+		attribute.String("phase", phaseName), attribute.String("traffic_type", trafficType)))
+}
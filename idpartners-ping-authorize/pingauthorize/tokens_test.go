@@ -0,0 +1,102 @@
+package pingauthorize
+
+import (
+	"testing"
+
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+)
+
+func TestApproximateTokens(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want int
+	}{
+		{"empty", "", 0},
+		{"words", "hello world", 4},
+		{"punctuation", "a, b.", 4},
+		{"json", `{"model":"gpt-4o"}`, 12},
+		{"accented", "naïve", 2},
+		{"cjk", "日本語", 3},
+		{"whitespace", " \n\t ", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := approximateTokens(tt.s); got != tt.want {
+				t.Errorf("approximateTokens(%q) = %d, want %d", tt.s, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCountRequestTokens(t *testing.T) {
+	enabled := &Config{TokenCounting: true}
+	body := `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`
+
+	tests := []struct {
+		name    string
+		conf    *Config
+		payload *SidebandAccessRequest
+		want    int
+	}{
+		{"mcp", enabled, &SidebandAccessRequest{Body: body, MCP: &MCPContext{Method: "tools/list"}}, approximateTokens(body)},
+		{"mcp batch", enabled, &SidebandAccessRequest{Body: "[" + body + "]", MCPBatch: []MCPBatchEntry{{}}}, approximateTokens("[" + body + "]")},
+		{"llm", enabled, &SidebandAccessRequest{Body: `{"model":"m","messages":[]}`, LLM: &LLMContext{}}, approximateTokens(`{"model":"m","messages":[]}`)},
+		{"other traffic", enabled, &SidebandAccessRequest{Body: body}, 0},
+		{"disabled", &Config{}, &SidebandAccessRequest{Body: body, MCP: &MCPContext{Method: "tools/list"}}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			countRequestTokens(tt.conf, tt.payload)
+			if tt.payload.BodyTokens != tt.want {
+				t.Errorf("body_tokens = %d, want %d", tt.payload.BodyTokens, tt.want)
+			}
+		})
+	}
+}
+
+func TestCountResponseTokens(t *testing.T) {
+	conf := &Config{TokenCounting: true, LLMEnrichmentPaths: []string{"/v1/chat/completions"}}
+	mcpBody := `{"jsonrpc":"2.0","id":1,"result":{}}`
+	llmBody := `{"choices":[{"message":{"role":"assistant","content":"hi there"}}]}`
+
+	tests := []struct {
+		name    string
+		payload *SidebandResponsePayload
+		want    int
+	}{
+		{"mcp", &SidebandResponsePayload{Method: "POST", URL: "https://api.example.com/mcp", Body: mcpBody,
+			MCP: &MCPResponseContext{}}, approximateTokens(mcpBody)},
+		{"llm route", &SidebandResponsePayload{Method: "POST", URL: "https://api.example.com/v1/chat/completions", Body: llmBody}, approximateTokens(llmBody)},
+		{"other route", &SidebandResponsePayload{Method: "POST", URL: "https://api.example.com/v1/files", Body: llmBody}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			countResponseTokens(conf, tt.payload)
+			if tt.payload.BodyTokens != tt.want {
+				t.Errorf("body_tokens = %d, want %d", tt.payload.BodyTokens, tt.want)
+			}
+		})
+	}
+}
+
+func TestPluginMetrics_RecordTokens(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	m := newPluginMetrics(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter(PluginName))
+
+	m.recordTokens("https://paz", "access", trafficTypeLLM, 120)
+	m.recordTokens("https://paz", "access", trafficTypeLLM, 30)
+	m.recordTokens("https://paz", "response", trafficTypeMCP, 7)
+	m.recordTokens("https://paz", "response", trafficTypeMCP, 0)
+	(*PluginMetrics)(nil).recordTokens("https://paz", "access", trafficTypeLLM, 1)
+
+	sums := collectSums(t, reader)
+	for key, want := range map[string]int64{
+		"ping_authorize_tokens_total phase=access,service_url=https://paz,traffic_type=llm":   150,
+		"ping_authorize_tokens_total phase=response,service_url=https://paz,traffic_type=mcp": 7,
+	} {
+		if sums[key] != want {
+			t.Errorf("%s = %d, want %d", key, sums[key], want)
+		}
+	}
+}
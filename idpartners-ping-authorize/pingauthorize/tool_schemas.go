@@ -0,0 +1,121 @@
+package pingauthorize
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// jsonRPCInvalidParams is the JSON-RPC 2.0 error code for invalid method parameters.
+const jsonRPCInvalidParams = -32602
+
+// compileToolArgumentSchemas compiles tool_argument_schemas. Returns nil when none are configured.
+func compileToolArgumentSchemas(schemas map[string]string) (map[string]*jsonSchema, error) {
+	if len(schemas) == 0 {
+		return nil, nil
+	}
+	compiled := make(map[string]*jsonSchema, len(schemas))
+	for tool, text := range schemas {
+		if tool == "" {
+			return nil, fmt.Errorf("tool_argument_schemas must not contain empty tool names")
+		}
+		schema, err := compileJSONSchema(text)
+		if err != nil {
+			return nil, fmt.Errorf("tool_argument_schemas[%q]: %w", tool, err)
+		}
+		compiled[tool] = schema
+	}
+	return compiled, nil
+}
+
+// getToolArgumentSchemas returns the compiled tool_argument_schemas. Invalid schemas are rejected
+// by Validate, so they are treated as none here.
+func (c *Config) getToolArgumentSchemas() map[string]*jsonSchema {
+	c.toolSchemasOnce.Do(func() {
+		c.toolSchemas, _ = compileToolArgumentSchemas(c.ToolArgumentSchemas)
+	})
+	return c.toolSchemas
+}
+
+// toolArgumentsError validates the arguments of an MCP tools/call request against the schema
+// configured for the tool, or the "*" schema. Returns the JSON-RPC invalid params error to answer
+// with when they do not match, or nil when they do, no schema applies or body is not a tools/call
+// request. Missing arguments are validated as an empty object. In a batch every tools/call entry is
+// validated, and when one does not match the whole batch is answered with an invalid params error
+// for each request.
+func toolArgumentsError(conf *Config, body []byte) []byte {
+	schemas := conf.getToolArgumentSchemas()
+	if schemas == nil {
+		return nil
+	}
+	if req := parseMCPRequest(body); req != nil {
+		if message := toolArgumentsMismatch(schemas, req); message != "" {
+			return formatJSONRPCError(jsonRPCInvalidParams, message, req.ID)
+		}
+		return nil
+	}
+
+	batch := parseMCPBatch(body)
+	messages := make([]string, len(batch))
+	invalid := false
+	for i, req := range batch {
+		messages[i] = toolArgumentsMismatch(schemas, req)
+		invalid = invalid || messages[i] != ""
+	}
+	if !invalid {
+		return nil
+	}
+	var errs []json.RawMessage
+	for i, req := range batch {
+		if req.notification() {
+			continue
+		}
+		if messages[i] == "" {
+			messages[i] = "Invalid params: another request of the batch has invalid arguments"
+		}
+		errs = append(errs, formatJSONRPCError(jsonRPCInvalidParams, messages[i], req.ID))
+	}
+	if errs == nil {
+		errs = []json.RawMessage{}
+	}
+	resp, _ := json.Marshal(errs)
+	return resp
+}
+
+// toolArgumentsMismatch returns the invalid params message for a tools/call request whose
+// arguments do not match their schema, or "" when they do or req is no tools/call.
+func toolArgumentsMismatch(schemas map[string]*jsonSchema, req *jsonRPCRequest) string {
+	if req.Method != "tools/call" {
+		return ""
+	}
+	var params struct {
+		Name      string      `json:"name"`
+		Arguments interface{} `json:"arguments"`
+	}
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return "Invalid params: params must be an object"
+	}
+	schema, ok := schemas[params.Name]
+	if !ok {
+		if schema, ok = schemas["*"]; !ok {
+			return ""
+		}
+	}
+	if params.Arguments == nil {
+		params.Arguments = map[string]interface{}{}
+	}
+	if err := schema.validate(params.Arguments, "arguments"); err != nil {
+		return "Invalid params: " + err.Error()
+	}
+	return ""
+}
+
+// toolArgumentsDenial builds the response for a tools/call request, or a batch, whose arguments do
+// not match their schema, or returns ok=false when they do.
+func toolArgumentsDenial(conf *Config, payload *SidebandAccessRequest) (status int, body []byte, headers map[string][]string, ok bool) {
+	body = toolArgumentsError(conf, []byte(payload.Body))
+	if body == nil {
+		return 0, nil, nil, false
+	}
+	return http.StatusBadRequest, body, map[string][]string{"Content-Type": {"application/json"}}, true
+}
@@ -0,0 +1,152 @@
+package pingauthorize
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestToolArgumentsError(t *testing.T) {
+	conf := &Config{ToolArgumentSchemas: map[string]string{
+		"search": `{"type":"object","required":["query"],"properties":{"query":{"type":"string","maxLength":5}}}`,
+		"*":      `{"type":"object","maxProperties":1}`,
+	}}
+
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+		wantMsg  string
+	}{
+		{"valid", `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"search","arguments":{"query":"cats"}}}`, 0, ""},
+		{"invalid", `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"search","arguments":{"query":"too long"}}}`,
+			-32602, "Invalid params: arguments.query must be at most 5 characters"},
+		{"missing arguments", `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"search"}}`,
+			-32602, "Invalid params: arguments.query is required"},
+		{"default schema", `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"other","arguments":{"a":1,"b":2}}}`,
+			-32602, "Invalid params: arguments must have at most 1 properties"},
+		{"params not an object", `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":[1]}`,
+			-32602, "Invalid params: params must be an object"},
+		{"other method", `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`, 0, ""},
+		{"not mcp", `{"query":"too long"}`, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := toolArgumentsError(conf, []byte(tt.body))
+			if tt.wantCode == 0 {
+				if body != nil {
+					t.Errorf("unexpected error %s", body)
+				}
+				return
+			}
+			var resp JsonRPCError
+			if err := json.Unmarshal(body, &resp); err != nil {
+				t.Fatalf("invalid error body %q: %v", body, err)
+			}
+			if string(resp.ID) != "1" || resp.Error.Code != tt.wantCode || resp.Error.Message != tt.wantMsg {
+				t.Errorf("got %s, want code %d message %q", body, tt.wantCode, tt.wantMsg)
+			}
+		})
+	}
+
+	if body := toolArgumentsError(&Config{}, []byte(tests[1].body)); body != nil {
+		t.Errorf("expected no validation without schemas, got %s", body)
+	}
+}
+
+func TestToolArgumentsError_Batch(t *testing.T) {
+	conf := &Config{ToolArgumentSchemas: map[string]string{
+		"search": `{"type":"object","properties":{"query":{"type":"string","maxLength":5}}}`,
+	}}
+	call := func(id, query string) string {
+		return `{"jsonrpc":"2.0",` + id + `"method":"tools/call","params":{"name":"search","arguments":{"query":"` + query + `"}}}`
+	}
+
+	valid := "[" + call(`"id":1,`, "cats") + `,{"jsonrpc":"2.0","id":2,"method":"tools/list"}]`
+	if body := toolArgumentsError(conf, []byte(valid)); body != nil {
+		t.Errorf("unexpected error %s", body)
+	}
+
+	invalid := "[" + call(`"id":1,`, "cats") + "," + call(`"id":2,`, "too long") + "," + call("", "too long") + "]"
+	var errs []JsonRPCError
+	if err := json.Unmarshal(toolArgumentsError(conf, []byte(invalid)), &errs); err != nil || len(errs) != 2 {
+		t.Fatalf("expected an error for each request, got %v %+v", err, errs)
+	}
+	want := []struct{ id, message string }{
+		{"1", "Invalid params: another request of the batch has invalid arguments"},
+		{"2", "Invalid params: arguments.query must be at most 5 characters"},
+	}
+	for i, w := range want {
+		if string(errs[i].ID) != w.id || errs[i].Error.Code != jsonRPCInvalidParams || errs[i].Error.Message != w.message {
+			t.Errorf("error %d = %+v, want id %s message %q", i, errs[i], w.id, w.message)
+		}
+	}
+}
+
+func TestValidate_ToolArgumentSchemas(t *testing.T) {
+	tests := []struct {
+		name    string
+		schemas map[string]string
+		wantErr string
+	}{
+		{"valid", map[string]string{"search": `{"type":"object"}`}, ""},
+		{"invalid json", map[string]string{"search": `{`}, `tool_argument_schemas["search"]`},
+		{"empty tool name", map[string]string{"": `{}`}, "tool_argument_schemas must not contain empty tool names"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := &Config{ServiceURL: "http://localhost:8080", SharedSecret: "secret", SecretHeaderName: "CLIENT-TOKEN", ToolArgumentSchemas: tt.schemas}
+			_, err := NewMiddleware(conf)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMiddleware_ToolArgumentSchemas(t *testing.T) {
+	var evaluated int
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		evaluated++
+		var req SidebandAccessRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(SidebandAccessResponse{Method: req.Method, URL: req.URL, Headers: req.Headers, Body: &req.Body})
+	})
+	defer server.Close()
+
+	var forwarded int
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded++
+		w.Write([]byte(`{"jsonrpc":"2.0","id":7,"result":{}}`))
+	})
+	m := newTestMiddleware(t, server.URL, false)
+	m.conf.ToolArgumentSchemas = map[string]string{"search": `{"type":"object","properties":{"limit":{"type":"integer","maximum":10}}}`}
+	h := m.Handler(upstream)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "http://api.example.com/mcp",
+		strings.NewReader(`{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"name":"search","arguments":{"limit":1000}}}`)))
+	if rec.Code != http.StatusBadRequest || evaluated != 0 || forwarded != 0 {
+		t.Fatalf("got %d after %d evaluations and %d forwards, want 400 without either", rec.Code, evaluated, forwarded)
+	}
+	var resp JsonRPCError
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if string(resp.ID) != "7" || resp.Error.Code != -32602 {
+		t.Errorf("unexpected error body %s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "http://api.example.com/mcp",
+		strings.NewReader(`{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"name":"search","arguments":{"limit":5}}}`)))
+	if rec.Code != http.StatusOK || evaluated != 1 || forwarded != 1 {
+		t.Errorf("got %d after %d evaluations and %d forwards, want 200 after one of each", rec.Code, evaluated, forwarded)
+	}
+}
@@ -0,0 +1,185 @@
+package pingauthorize
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// maxToolsListCacheEntries caps the number of filtered tools/list results one plugin config keeps.
+const maxToolsListCacheEntries = 10000
+
+// toolsListCache holds filtered tools/list results until they expire.
+type toolsListCache struct {
+	mu      sync.Mutex
+	entries map[string]toolsListEntry
+	now     func() time.Time
+}
+
+type toolsListEntry struct {
+	result  SidebandResponseResult
+	expires time.Time
+}
+
+func newToolsListCache() *toolsListCache {
+	return &toolsListCache{entries: make(map[string]toolsListEntry), now: time.Now}
+}
+
+// get returns a copy of the unexpired result stored under key, or nil.
+func (c *toolsListCache) get(key string) *SidebandResponseResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	if !c.now().Before(e.expires) {
+		delete(c.entries, key)
+		return nil
+	}
+	result := e.result
+	result.Headers = cloneHeaderList(e.result.Headers)
+	return &result
+}
+
+// put stores a copy of result under key for ttl. When the cache is full, expired entries are
+// dropped first and then an arbitrary one.
+func (c *toolsListCache) put(key string, result *SidebandResponseResult, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= maxToolsListCacheEntries {
+		for k, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		for k := range c.entries {
+			if len(c.entries) < maxToolsListCacheEntries {
+				break
+			}
+			delete(c.entries, k)
+		}
+	}
+	stored := *result
+	stored.Headers = cloneHeaderList(result.Headers)
+	c.entries[key] = toolsListEntry{result: stored, expires: now.Add(ttl)}
+}
+
+func cloneHeaderList(headers []map[string]string) []map[string]string {
+	if headers == nil {
+		return nil
+	}
+	cloned := make([]map[string]string, len(headers))
+	for i, h := range headers {
+		cloned[i] = make(map[string]string, len(h))
+		for k, v := range h {
+			cloned[i][k] = v
+		}
+	}
+	return cloned
+}
+
+// toolsListCachingProvider serves the response phase of tools/list requests from a toolsListCache:
+// the policy filters the same tool set the same way for a session until the policy version changes.
+type toolsListCachingProvider struct {
+	PolicyProvider
+	cache  *toolsListCache
+	config *Config
+}
+
+// EvaluateResponse implements PolicyProvider.
+func (p *toolsListCachingProvider) EvaluateResponse(ctx context.Context, req *SidebandResponsePayload) (*SidebandResponseResult, error) {
+	key, id, ok := toolsListCacheKey(req)
+	if !ok {
+		return p.PolicyProvider.EvaluateResponse(ctx, req)
+	}
+	logger := NewPluginLogger(nil, "response", p.config.ServiceURL)
+	if result := p.cache.get(key); result != nil {
+		if body, ok := withJSONRPCID([]byte(result.Body), id); ok {
+			logger.Debug("Filtered tools/list result served from cache")
+			result.Body = string(body)
+			return result, nil
+		}
+	}
+
+	result, err := p.PolicyProvider.EvaluateResponse(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if result.ResponseCode == "200" {
+		p.cache.put(key, result, time.Duration(p.config.ToolsListCacheTTLMs)*time.Millisecond)
+	}
+	return result, nil
+}
+
+// toolsListCacheKey returns the cache key of a JSON tools/list response and its JSON-RPC id. The key
+// covers the URL, the caller, the MCP session, the tools_list_cache_version_header value and the
+// tools the upstream returned, so a changed tool set is filtered anew and the result filtered for
+// one caller is never served to another, including on servers without sessions. ok is false for other responses,
+// including SSE streams.
+func toolsListCacheKey(req *SidebandResponsePayload) (key string, id json.RawMessage, ok bool) {
+	contentType := firstValue(FlattenHeaders(req.Headers)["content-type"])
+	if isEventStream(contentType) {
+		return "", nil, false
+	}
+	set, ok := parseToolsListResult([]byte(req.Body), contentType)
+	if !ok {
+		return "", nil, false
+	}
+	var resp struct {
+		ID     json.RawMessage `json:"id"`
+		Result struct {
+			Tools json.RawMessage `json:"tools"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal([]byte(req.Body), &resp); err != nil || len(resp.ID) == 0 || resp.Result.Tools == nil {
+		return "", nil, false
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%s\x00%s\x00%s", req.URL, req.cacheCaller, req.MCPSessionID, req.cacheVersion, set.hash)))
+	return hex.EncodeToString(sum[:]), resp.ID, true
+}
+
+// toolsListCacheCaller returns the caller part of the tools/list cache key: the first consumer id,
+// as in the decision cache key, and the Authorization header.
+func toolsListCacheCaller(consumerIDs []string, authorization string) string {
+	consumer := ""
+	for _, id := range consumerIDs {
+		if id != "" {
+			consumer = id
+			break
+		}
+	}
+	return fmt.Sprintf("%d:%s\x00%s", len(consumer), consumer, authorization)
+}
+
+// withJSONRPCID returns the JSON-RPC response body with its id replaced by id.
+func withJSONRPCID(body []byte, id json.RawMessage) ([]byte, bool) {
+	var resp map[string]json.RawMessage
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, false
+	}
+	resp["id"] = id
+	out, err := json.Marshal(resp)
+	return out, err == nil
+}
+
+// getToolsListCache returns the lazily-created tools/list cache of the config.
+func (c *Config) getToolsListCache() *toolsListCache {
+	c.toolsListCacheOnce.Do(func() {
+		c.toolsListCache = newToolsListCache()
+	})
+	return c.toolsListCache
+}
+
+// withToolsListCache wraps provider with the tools/list cache when tools_list_cache_ttl_ms is set.
+func withToolsListCache(config *Config, provider PolicyProvider) PolicyProvider {
+	if config.ToolsListCacheTTLMs <= 0 {
+		return provider
+	}
+	return &toolsListCachingProvider{PolicyProvider: provider, cache: config.getToolsListCache(), config: config}
+}
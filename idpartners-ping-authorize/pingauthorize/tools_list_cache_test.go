@@ -0,0 +1,139 @@
+package pingauthorize
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func toolsListResponse(id, tools string) *SidebandResponsePayload {
+	return &SidebandResponsePayload{
+		Method:       "POST",
+		URL:          "http://gw/mcp",
+		Body:         `{"jsonrpc":"2.0","id":` + id + `,"result":{"tools":[` + tools + `]}}`,
+		ResponseCode: "200",
+		Headers:      []map[string]string{{"content-type": "application/json"}},
+		MCPSessionID: "s1",
+	}
+}
+
+func TestToolsListCacheKey(t *testing.T) {
+	base := toolsListResponse("1", `{"name":"a"}`)
+	key, _, ok := toolsListCacheKey(base)
+	if !ok {
+		t.Fatal("expected a tools/list response to be cacheable")
+	}
+
+	tests := []struct {
+		name      string
+		modify    func(p *SidebandResponsePayload)
+		cacheable bool
+		sameKey   bool
+	}{
+		{"other id", func(p *SidebandResponsePayload) { p.Body = strings.Replace(p.Body, `"id":1`, `"id":"x"`, 1) }, true, true},
+		{"other session", func(p *SidebandResponsePayload) { p.MCPSessionID = "s2" }, true, false},
+		{"other version", func(p *SidebandResponsePayload) { p.cacheVersion = "v2" }, true, false},
+		{"other url", func(p *SidebandResponsePayload) { p.URL = "http://gw/other" }, true, false},
+		{"other caller", func(p *SidebandResponsePayload) { p.cacheCaller = toolsListCacheCaller(nil, "Bearer bob") }, true, false},
+		{"other consumer", func(p *SidebandResponsePayload) { p.cacheCaller = toolsListCacheCaller([]string{"c2"}, "") }, true, false},
+		{"other tools", func(p *SidebandResponsePayload) { p.Body = toolsListResponse("1", `{"name":"b"}`).Body }, true, false},
+		{"sse", func(p *SidebandResponsePayload) {
+			p.Headers = []map[string]string{{"content-type": "text/event-stream"}}
+			p.Body = "data: " + p.Body + "\n\n"
+		}, false, false},
+		{"not tools/list", func(p *SidebandResponsePayload) { p.Body = `{"jsonrpc":"2.0","id":1,"result":{}}` }, false, false},
+		{"notification", func(p *SidebandResponsePayload) { p.Body = `{"jsonrpc":"2.0","result":{"tools":[]}}` }, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := toolsListResponse("1", `{"name":"a"}`)
+			tt.modify(p)
+			k, _, ok := toolsListCacheKey(p)
+			if ok != tt.cacheable {
+				t.Fatalf("cacheable = %v, want %v", ok, tt.cacheable)
+			}
+			if ok && (k == key) != tt.sameKey {
+				t.Errorf("same key = %v, want %v", k == key, tt.sameKey)
+			}
+		})
+	}
+}
+
+// filteringProvider answers every response-phase request with an empty tools/list result and counts the calls.
+type filteringProvider struct {
+	countingProvider
+	code      string
+	responses int
+}
+
+func (p *filteringProvider) EvaluateResponse(ctx context.Context, req *SidebandResponsePayload) (*SidebandResponseResult, error) {
+	p.responses++
+	return &SidebandResponseResult{
+		ResponseCode: p.code,
+		Body:         `{"jsonrpc":"2.0","id":1,"result":{"tools":[]}}`,
+		Headers:      []map[string]string{{"content-type": "application/json"}},
+	}, nil
+}
+
+func TestToolsListCachingProvider(t *testing.T) {
+	inner := &filteringProvider{code: "200"}
+	conf := NewConfig()
+	conf.ToolsListCacheTTLMs = 60000
+	p := withToolsListCache(conf, inner)
+
+	first, _ := p.EvaluateResponse(context.Background(), toolsListResponse("1", `{"name":"a"}`))
+	second, _ := p.EvaluateResponse(context.Background(), toolsListResponse(`"req-2"`, `{"name":"a"}`))
+	if inner.responses != 1 {
+		t.Fatalf("expected the second tools/list to be served from cache, got %d calls", inner.responses)
+	}
+	if first.Body != `{"jsonrpc":"2.0","id":1,"result":{"tools":[]}}` {
+		t.Errorf("unexpected first body %s", first.Body)
+	}
+	if !strings.Contains(second.Body, `"id":"req-2"`) {
+		t.Errorf("expected the cached body to carry the new id, got %s", second.Body)
+	}
+	second.Headers[0]["content-type"] = "changed"
+	third, _ := p.EvaluateResponse(context.Background(), toolsListResponse("3", `{"name":"a"}`))
+	if third.Headers[0]["content-type"] != "application/json" {
+		t.Error("expected cached headers to be copied")
+	}
+
+	other := toolsListResponse("4", `{"name":"a"}`)
+	other.MCPSessionID = "s2"
+	p.EvaluateResponse(context.Background(), other)
+	if inner.responses != 2 {
+		t.Errorf("expected another session to miss the cache, got %d calls", inner.responses)
+	}
+
+	tp := p.(*toolsListCachingProvider)
+	now := time.Now()
+	tp.cache.now = func() time.Time { return now.Add(2 * time.Minute) }
+	p.EvaluateResponse(context.Background(), toolsListResponse("5", `{"name":"a"}`))
+	if inner.responses != 3 {
+		t.Errorf("expected an expired entry to miss the cache, got %d calls", inner.responses)
+	}
+}
+
+func TestToolsListCachingProvider_NotCached(t *testing.T) {
+	inner := &filteringProvider{code: "403"}
+	conf := NewConfig()
+	conf.ToolsListCacheTTLMs = 60000
+	p := withToolsListCache(conf, inner)
+	p.EvaluateResponse(context.Background(), toolsListResponse("1", `{"name":"a"}`))
+	p.EvaluateResponse(context.Background(), toolsListResponse("2", `{"name":"a"}`))
+	if inner.responses != 2 {
+		t.Errorf("expected a non-200 result not to be cached, got %d calls", inner.responses)
+	}
+
+	if _, ok := withToolsListCache(NewConfig(), inner).(*toolsListCachingProvider); ok {
+		t.Error("expected no cache without tools_list_cache_ttl_ms")
+	}
+}
+
+func TestToolsListCacheTTLValidation(t *testing.T) {
+	_, err := NewMiddleware(&Config{ServiceURL: "http://localhost:8080", SharedSecret: "secret", SecretHeaderName: "CLIENT-TOKEN", ToolsListCacheTTLMs: -1})
+	if err == nil || !strings.Contains(err.Error(), "tools_list_cache_ttl_ms") {
+		t.Errorf("expected tools_list_cache_ttl_ms error, got %v", err)
+	}
+}
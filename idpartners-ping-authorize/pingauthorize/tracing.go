@@ -0,0 +1,172 @@
+package pingauthorize
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/Kong/go-pdk"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"github.com/idpartners/idpartners-ping-authorize/pingauthorize/decisionctx"
+)
+
+// traceContext reads and writes W3C traceparent and tracestate headers. It is used instead of
+// the global propagator, which is a no-op unless the host application configures one.
+var traceContext = propagation.TraceContext{}
+
+// tracer returns the plugin tracer of the global tracer provider installed by InitOTel.
+func tracer() trace.Tracer {
+	return otel.Tracer(PluginName)
+}
+
+// startPhaseSpan starts the ping-authorize.<phase> span when enable_otel is set, and returns a
+// no-op span otherwise. The span is a child of the span in ctx or, when there is none, continues
+// the trace of the client request from the headers returned by clientHeaders: W3C traceparent,
+// or B3 when the request has no valid traceparent.
+func startPhaseSpan(ctx context.Context, conf *Config, phase string, clientHeaders func() http.Header) (context.Context, trace.Span) {
+	if !conf.EnableOtel {
+		return ctx, noop.Span{}
+	}
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		header := clientHeaders()
+		ctx = traceContext.Extract(ctx, propagation.HeaderCarrier(header))
+		if sc := extractB3(header); sc.IsValid() && !trace.SpanContextFromContext(ctx).IsValid() {
+			ctx = trace.ContextWithRemoteSpanContext(ctx, sc)
+		}
+	}
+	return tracer().Start(ctx, "ping-authorize."+phase, trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(attribute.String("ping_authorize.service_url", conf.ServiceURL)))
+}
+
+// endPhaseSpan records the outcome of phase on span and ends it.
+func endPhaseSpan(span trace.Span, phase *decisionctx.Phase) {
+	if phase.Decision != "" {
+		span.SetAttributes(attribute.String("ping_authorize.decision", phase.Decision))
+	}
+	if phase.StatusCode != 0 {
+		span.SetAttributes(attribute.Int("http.response.status_code", phase.StatusCode))
+	}
+	if phase.Cached || phase.FailOpen {
+		span.SetAttributes(attribute.Bool("ping_authorize.cached", phase.Cached), attribute.Bool("ping_authorize.fail_open", phase.FailOpen))
+	}
+	if phase.Decision == decisionctx.DecisionError {
+		span.SetStatus(codes.Error, phase.Error)
+	}
+	span.End()
+}
+
+// kongTraceHeaders returns the headers of the client request in Kong, read only when a phase span
+// is started.
+func kongTraceHeaders(kong *pdk.PDK) func() http.Header {
+	return func() http.Header {
+		header := http.Header{}
+		headers, err := kong.Request.GetHeaders(-1)
+		if err != nil {
+			return header
+		}
+		for name, values := range headers {
+			for _, v := range values {
+				header.Add(name, v)
+			}
+		}
+		return header
+	}
+}
+
+// extractB3 returns the remote span context of B3 headers, in the single b3 header format
+// ({trace id}-{span id}[-{sampled}[-{parent span id}]]) or the multi-header X-B3-* format. 64-bit
+// trace IDs are left-padded to 128 bits. A request without a sampling decision is not sampled.
+func extractB3(header http.Header) trace.SpanContext {
+	traceID, spanID, sampled := header.Get("X-B3-TraceId"), header.Get("X-B3-SpanId"), header.Get("X-B3-Sampled")
+	if header.Get("X-B3-Flags") == "1" {
+		sampled = "d"
+	}
+	if single := header.Get("b3"); single != "" {
+		parts := strings.Split(single, "-")
+		if len(parts) < 2 {
+			return trace.SpanContext{} // sampling decision only
+		}
+		traceID, spanID, sampled = parts[0], parts[1], ""
+		if len(parts) > 2 {
+			sampled = parts[2]
+		}
+	}
+
+	if len(traceID) == 16 {
+		traceID = strings.Repeat("0", 16) + traceID
+	}
+	tid, err := trace.TraceIDFromHex(traceID)
+	if err != nil {
+		return trace.SpanContext{}
+	}
+	sid, err := trace.SpanIDFromHex(spanID)
+	if err != nil {
+		return trace.SpanContext{}
+	}
+	var flags trace.TraceFlags
+	switch sampled {
+	case "1", "d", "true":
+		flags = trace.FlagsSampled
+	}
+	return trace.NewSpanContext(trace.SpanContextConfig{TraceID: tid, SpanID: sid, TraceFlags: flags, Remote: true})
+}
+
+// startAttemptSpan starts the client span of one sideband attempt when enable_otel is set.
+func (c *SidebandHTTPClient) startAttemptSpan(ctx context.Context, requestURL string, attempt int) (context.Context, trace.Span) {
+	if !c.config.EnableOtel {
+		return ctx, noop.Span{}
+	}
+	return tracer().Start(ctx, "ping-authorize.sideband", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", http.MethodPost),
+			attribute.String("url.full", requestURL),
+			attribute.Int("http.request.resend_count", attempt),
+		))
+}
+
+// endAttemptSpan records the result of a sideband attempt on span and ends it.
+func endAttemptSpan(span trace.Span, statusCode int, err error) {
+	if statusCode != 0 {
+		span.SetAttributes(attribute.Int("http.response.status_code", statusCode))
+	}
+	switch {
+	case err != nil:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	case statusCode >= 500:
+		span.SetStatus(codes.Error, http.StatusText(statusCode))
+	}
+	span.End()
+}
+
+// Circuit breaker outcomes of a sideband call recorded on the phase span.
+const (
+	breakerClosed  = "closed"  // the call was allowed and the breaker stayed closed
+	breakerOpen    = "open"    // the call was rejected by an open breaker
+	breakerTripped = "tripped" // the call opened the breaker
+)
+
+// annotateCall records the attempts and circuit breaker outcome of a sideband call on the phase
+// span in ctx.
+func (c *SidebandHTTPClient) annotateCall(ctx context.Context, attempts int, breaker string) {
+	if !c.config.EnableOtel {
+		return
+	}
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.Int("ping_authorize.sideband.retries", max(attempts-1, 0)),
+		attribute.String("ping_authorize.circuit_breaker", breaker))
+}
+
+// injectTraceContext adds the traceparent and tracestate of the span in ctx to a sideband request,
+// so that the trace continues in PingAuthorize.
+func (c *SidebandHTTPClient) injectTraceContext(ctx context.Context, header http.Header) {
+	if c.config.EnableOtel {
+		traceContext.Inject(ctx, propagation.HeaderCarrier(header))
+	}
+}
@@ -0,0 +1,222 @@
+package pingauthorize
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// recordSpans installs a global tracer provider that records ended spans for the test.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+// spanAttrs returns the attributes of span by key.
+func spanAttrs(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func TestMiddleware_TraceSpans(t *testing.T) {
+	recorder := recordSpans(t)
+
+	var mu sync.Mutex
+	var traceparents []string
+	requestCalls := 0
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		traceparents = append(traceparents, r.Header.Get("traceparent"))
+		mu.Unlock()
+		if strings.HasSuffix(r.URL.Path, "/sideband/request") {
+			if requestCalls++; requestCalls == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			var req SidebandAccessRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			json.NewEncoder(w).Encode(SidebandAccessResponse{Method: req.Method, URL: req.URL, Headers: req.Headers})
+			return
+		}
+		json.NewEncoder(w).Encode(SidebandResponseResult{ResponseCode: "200", Body: "upstream"})
+	})
+	defer server.Close()
+
+	conf := NewConfig()
+	conf.ServiceURL = server.URL
+	conf.SharedSecret = "secret"
+	conf.SecretHeaderName = "X-Secret"
+	conf.MaxRetries = 1
+	conf.RetryBackoffMs = 1
+	conf.EnableOtel = true
+	m, err := NewMiddleware(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := m.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("upstream"))
+	}))
+
+	const clientTrace = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodGet, "/resource", nil)
+	req.Header.Set("traceparent", "00-"+clientTrace+"-00f067aa0ba902b7-01")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != 200 {
+		t.Fatalf("unexpected status %d", rec.Code)
+	}
+
+	byName := make(map[string][]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().TraceID().String() != clientTrace {
+			t.Errorf("span %s is not in the client trace", span.Name())
+		}
+		byName[span.Name()] = append(byName[span.Name()], span)
+	}
+	if len(byName["ping-authorize.access"]) != 1 || len(byName["ping-authorize.response"]) != 1 || len(byName["ping-authorize.sideband"]) != 3 {
+		t.Fatalf("unexpected spans %v", byName)
+	}
+
+	access := byName["ping-authorize.access"][0]
+	if access.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("expected the access span to continue the client span, parent %s", access.Parent().SpanID())
+	}
+	attrs := spanAttrs(access)
+	if attrs["ping_authorize.decision"].AsString() != "allow" || attrs["ping_authorize.sideband.retries"].AsInt64() != 1 ||
+		attrs["ping_authorize.circuit_breaker"].AsString() != "closed" {
+		t.Errorf("unexpected access span attributes %v", attrs)
+	}
+
+	sideband := byName["ping-authorize.sideband"]
+	for i, want := range []struct {
+		parent string
+		resend int64
+		status int64
+	}{
+		{access.SpanContext().SpanID().String(), 0, 503},
+		{access.SpanContext().SpanID().String(), 1, 200},
+		{byName["ping-authorize.response"][0].SpanContext().SpanID().String(), 0, 200},
+	} {
+		attrs := spanAttrs(sideband[i])
+		if sideband[i].Parent().SpanID().String() != want.parent || attrs["http.request.resend_count"].AsInt64() != want.resend ||
+			attrs["http.response.status_code"].AsInt64() != want.status {
+			t.Errorf("attempt %d: unexpected span parent %s attributes %v", i, sideband[i].Parent().SpanID(), attrs)
+		}
+		wantHeader := "00-" + clientTrace + "-" + sideband[i].SpanContext().SpanID().String() + "-01"
+		if traceparents[i] != wantHeader {
+			t.Errorf("attempt %d: traceparent = %q, want %q", i, traceparents[i], wantHeader)
+		}
+	}
+}
+
+func TestExecute_NoTraceHeadersWithoutOtel(t *testing.T) {
+	recordSpans(t)
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	conf := NewConfig()
+	conf.ServiceURL = server.URL
+	conf.SharedSecret = "secret"
+	conf.SecretHeaderName = "X-Secret"
+	ctx, span := otel.Tracer("test").Start(context.Background(), "caller")
+	defer span.End()
+	parsed, _ := ParseURL(server.URL)
+	if _, _, _, err := NewSidebandHTTPClient(conf).Execute(ctx, server.URL+"/sideband/request", []byte(`{}`), parsed); err != nil {
+		t.Fatal(err)
+	}
+	if traceparent != "" {
+		t.Errorf("expected no traceparent without enable_otel, got %q", traceparent)
+	}
+}
+
+func TestExtractB3(t *testing.T) {
+	tests := []struct {
+		name        string
+		header      http.Header
+		wantTrace   string
+		wantSpan    string
+		wantSampled bool
+	}{
+		{"single", http.Header{"B3": {"80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1-05e3ac9a4f6e3b90"}},
+			"80f198ee56343ba864fe8b2a57d3eff7", "e457b5a2e4d86bd1", true},
+		{"single 64-bit trace id", http.Header{"B3": {"64fe8b2a57d3eff7-e457b5a2e4d86bd1-0"}},
+			"000000000000000064fe8b2a57d3eff7", "e457b5a2e4d86bd1", false},
+		{"single debug", http.Header{"B3": {"80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-d"}},
+			"80f198ee56343ba864fe8b2a57d3eff7", "e457b5a2e4d86bd1", true},
+		{"single deferred", http.Header{"B3": {"80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1"}},
+			"80f198ee56343ba864fe8b2a57d3eff7", "e457b5a2e4d86bd1", false},
+		{"multi", http.Header{"X-B3-Traceid": {"80f198ee56343ba864fe8b2a57d3eff7"}, "X-B3-Spanid": {"e457b5a2e4d86bd1"}, "X-B3-Sampled": {"1"}},
+			"80f198ee56343ba864fe8b2a57d3eff7", "e457b5a2e4d86bd1", true},
+		{"multi debug flag", http.Header{"X-B3-Traceid": {"80f198ee56343ba864fe8b2a57d3eff7"}, "X-B3-Spanid": {"e457b5a2e4d86bd1"}, "X-B3-Flags": {"1"}},
+			"80f198ee56343ba864fe8b2a57d3eff7", "e457b5a2e4d86bd1", true},
+		{"sampling only", http.Header{"B3": {"0"}}, "", "", false},
+		{"invalid span id", http.Header{"B3": {"80f198ee56343ba864fe8b2a57d3eff7-xyz-1"}}, "", "", false},
+		{"none", http.Header{}, "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc := extractB3(tt.header)
+			if tt.wantTrace == "" {
+				if sc.IsValid() {
+					t.Errorf("expected no span context, got %v", sc)
+				}
+				return
+			}
+			if sc.TraceID().String() != tt.wantTrace || sc.SpanID().String() != tt.wantSpan || sc.IsSampled() != tt.wantSampled || !sc.IsRemote() {
+				t.Errorf("got trace %s span %s sampled %v", sc.TraceID(), sc.SpanID(), sc.IsSampled())
+			}
+		})
+	}
+}
+
+func TestStartPhaseSpan_Parent(t *testing.T) {
+	recorder := recordSpans(t)
+	conf := NewConfig()
+	conf.EnableOtel = true
+	const b3 = "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1"
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	tests := []struct {
+		name       string
+		header     http.Header
+		wantParent string
+	}{
+		{"b3", http.Header{"B3": {b3}}, "e457b5a2e4d86bd1"},
+		{"traceparent wins over b3", http.Header{"B3": {b3}, "Traceparent": {traceparent}}, "00f067aa0ba902b7"},
+		{"new trace", http.Header{}, "0000000000000000"},
+	}
+	for _, tt := range tests {
+		_, span := startPhaseSpan(context.Background(), conf, "access", func() http.Header { return tt.header })
+		span.End()
+		ended := recorder.Ended()
+		if got := ended[len(ended)-1].Parent().SpanID().String(); got != tt.wantParent {
+			t.Errorf("%s: parent = %s, want %s", tt.name, got, tt.wantParent)
+		}
+	}
+
+	conf.EnableOtel = false
+	called := false
+	startPhaseSpan(context.Background(), conf, "access", func() http.Header { called = true; return nil })
+	if called {
+		t.Error("expected client headers not to be read without enable_otel")
+	}
+}
@@ -0,0 +1,180 @@
+package pingauthorize
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// SidebandAccessRequest is the payload sent to POST /sideband/request during the access phase.
+type SidebandAccessRequest struct {
+	SourceIP          string                 `json:"source_ip"`
+	SourcePort        string                 `json:"source_port"`
+	Method            string                 `json:"method"`
+	URL               string                 `json:"url"`
+	Body              string                 `json:"body"`
+	BodyDigest        *BodyDigest            `json:"body_digest,omitempty"`
+	BodyTokens        int                    `json:"body_tokens,omitempty"`
+	MultipartSummary  *MultipartSummary      `json:"multipart_summary,omitempty"`
+	Headers           []map[string]string    `json:"headers"`
+	HTTPVersion       string                 `json:"http_version"`
+	ClientCertificate *JWK                   `json:"client_certificate,omitempty"`
+	ExtractedHeaders  map[string]string      `json:"extracted_headers,omitempty"`
+	ExtractedAttrs    map[string]interface{} `json:"extracted_attributes,omitempty"`
+	Attributes        map[string]interface{} `json:"attributes,omitempty"`
+	TrafficType       string                 `json:"traffic_type,omitempty"`
+	GraphQL           *GraphQLContext        `json:"graphql,omitempty"`
+	SOAP              *SOAPContext           `json:"soap,omitempty"`
+	GRPC              *GRPCContext           `json:"grpc,omitempty"`
+	LLM               *LLMContext            `json:"llm,omitempty"`
+	TokenClaims       map[string]interface{} `json:"token_claims,omitempty"`
+	Subject           *TokenSubject          `json:"subject,omitempty"`
+	Consumer          *KongConsumer          `json:"consumer,omitempty"`
+	Route             *KongRoute             `json:"route,omitempty"`
+	MCP               *MCPContext            `json:"mcp,omitempty"`
+	MCPBatch          []MCPBatchEntry        `json:"mcp_batch,omitempty"`
+	MCPSessionID      string                 `json:"mcp_session_id,omitempty"`
+
+	// bodyNotRead is set when skip_body_methods kept the body from being read.
+	bodyNotRead bool
+	// encoded is set when Body was decompressed by decompress_request_body.
+	encoded *encodedBody
+	// requestHeaders are the request headers the bearer token is read from once the request is
+	// to be evaluated.
+	requestHeaders map[string][]string
+	// mcpBatchErrors holds the JSON-RPC errors of batch entries the policy denied, added to the
+	// upstream response by the net/http middleware.
+	mcpBatchErrors []byte
+}
+
+// SidebandAccessResponse is the response from POST /sideband/request.
+// If Response is non-nil, the request was denied.
+// If Response is nil, the request is allowed and may contain modifications + state.
+type SidebandAccessResponse struct {
+	SourceIP          string              `json:"source_ip"`
+	SourcePort        string              `json:"source_port"`
+	Method            string              `json:"method"`
+	URL               string              `json:"url"`
+	Body              *string             `json:"body"`
+	Headers           []map[string]string `json:"headers"`
+	ClientCertificate *JWK                `json:"client_certificate,omitempty"`
+	State             json.RawMessage     `json:"state,omitempty"`
+	Response          *DenyResponse       `json:"response,omitempty"`
+	TTL               *float64            `json:"ttl,omitempty"`
+
+	// CacheTTL is how long the decision may be cached, taken from the ttl field or the
+	// Cache-Control header of the policy response. nil means no hint; zero means do not cache.
+	CacheTTL *time.Duration `json:"-"`
+	// FromCache is set when the decision was served from the decision cache.
+	FromCache bool `json:"-"`
+}
+
+// DenyResponse represents a denial decision from PingAuthorize.
+type DenyResponse struct {
+	ResponseCode   string              `json:"response_code"`
+	ResponseStatus string              `json:"response_status"`
+	Body           string              `json:"body,omitempty"`
+	Headers        []map[string]string `json:"headers,omitempty"`
+}
+
+// SidebandResponsePayload is the payload sent to POST /sideband/response during the response phase.
+type SidebandResponsePayload struct {
+	Method           string                 `json:"method"`
+	URL              string                 `json:"url"`
+	Body             string                 `json:"body"`
+	BodyTokens       int                    `json:"body_tokens,omitempty"`
+	ResponseCode     string                 `json:"response_code"`
+	ResponseStatus   string                 `json:"response_status"`
+	Headers          []map[string]string    `json:"headers"`
+	HTTPVersion      string                 `json:"http_version"`
+	State            json.RawMessage        `json:"state,omitempty"`
+	Request          *SidebandAccessRequest `json:"request,omitempty"`
+	ExtractedHeaders map[string]string      `json:"extracted_headers,omitempty"`
+	Attributes       map[string]interface{} `json:"attributes,omitempty"`
+	UpstreamTiming   *UpstreamTiming        `json:"upstream_timing,omitempty"`
+	MCPSessionID     string                 `json:"mcp_session_id,omitempty"`
+	MCP              *MCPResponseContext    `json:"mcp,omitempty"`
+
+	// cacheVersion is the tools_list_cache_version_header value of the client request.
+	cacheVersion string
+	// cacheCaller identifies the caller in the tools/list cache key: the consumer and the
+	// Authorization header of the client request.
+	cacheCaller string
+}
+
+// UpstreamTiming holds upstream latencies in milliseconds for the response phase payload.
+// Values the gateway could not measure are omitted.
+type UpstreamTiming struct {
+	ConnectMs *float64 `json:"connect_ms,omitempty"`
+	WaitingMs *float64 `json:"waiting_ms,omitempty"`
+	ReceiveMs *float64 `json:"receive_ms,omitempty"`
+	TotalMs   *float64 `json:"total_ms,omitempty"`
+}
+
+// SidebandResponseResult is the response from POST /sideband/response.
+type SidebandResponseResult struct {
+	ResponseCode string              `json:"response_code"`
+	Body         string              `json:"body,omitempty"`
+	Headers      []map[string]string `json:"headers"`
+	Message      string              `json:"message,omitempty"`
+	ID           string              `json:"id,omitempty"`
+}
+
+// SidebandErrorResponse is used to parse error responses from PingAuthorize.
+type SidebandErrorResponse struct {
+	Message string `json:"message,omitempty"`
+	ID      string `json:"id,omitempty"`
+}
+
+// ParsedURL holds a parsed URL broken into its components.
+type ParsedURL struct {
+	Scheme string
+	Host   string
+	Port   int
+	Path   string
+	Query  string
+}
+
+// KongConsumer identifies the Kong consumer and credential authenticated by an auth plugin such
+// as key-auth, jwt or oauth2 ahead of this one.
+type KongConsumer struct {
+	ID           string `json:"id,omitempty"`
+	Username     string `json:"username,omitempty"`
+	CustomID     string `json:"custom_id,omitempty"`
+	CredentialID string `json:"credential_id,omitempty"`
+}
+
+// KongRoute identifies the Kong route and service that matched the request.
+type KongRoute struct {
+	RouteID     string   `json:"route_id,omitempty"`
+	RouteName   string   `json:"route_name,omitempty"`
+	RouteTags   []string `json:"route_tags,omitempty"`
+	ServiceID   string   `json:"service_id,omitempty"`
+	ServiceName string   `json:"service_name,omitempty"`
+}
+
+// JWK represents a JSON Web Key for client certificate public keys.
+type JWK struct {
+	Kty string   `json:"kty"`
+	N   string   `json:"n,omitempty"`   // RSA modulus
+	E   string   `json:"e,omitempty"`   // RSA exponent
+	Crv string   `json:"crv,omitempty"` // EC curve / Ed25519
+	X   string   `json:"x,omitempty"`   // EC x-coordinate / Ed25519 public key
+	Y   string   `json:"y,omitempty"`   // EC y-coordinate
+	X5C []string `json:"x5c"`           // Certificate chain (base64 DER)
+
+	// Leaf certificate details, for policies that match on the certificate rather than the key
+	X5TS256   string           `json:"x5t#S256,omitempty"` // SHA-256 thumbprint (base64url DER digest, RFC 8705)
+	Subject   string           `json:"subject,omitempty"`  // Subject DN (RFC 2253)
+	Issuer    string           `json:"issuer,omitempty"`   // Issuer DN (RFC 2253)
+	SAN       *CertificateSANs `json:"san,omitempty"`      // Subject alternative names
+	NotBefore *time.Time       `json:"not_before,omitempty"`
+	NotAfter  *time.Time       `json:"not_after,omitempty"`
+}
+
+// CertificateSANs lists the subject alternative names of a certificate by type.
+type CertificateSANs struct {
+	DNS   []string `json:"dns,omitempty"`
+	Email []string `json:"email,omitempty"`
+	IP    []string `json:"ip,omitempty"`
+	URI   []string `json:"uri,omitempty"`
+}
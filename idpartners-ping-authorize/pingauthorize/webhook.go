@@ -0,0 +1,256 @@
+package pingauthorize
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/idpartners/idpartners-ping-authorize/pingauthorize/decisionctx"
+)
+
+// webhookTimeout bounds a single webhook delivery.
+const webhookTimeout = 5 * time.Second
+
+const (
+	// webhookBatchSize and webhookFlushInterval bound how many events a webhookBatcher sends per
+	// POST and how long an event waits to be sent.
+	webhookBatchSize     = 100
+	webhookFlushInterval = time.Second
+	// webhookQueueSize is the number of events a webhookBatcher buffers; events beyond it are dropped.
+	webhookQueueSize      = 10000
+	defaultWebhookRetries = 3
+)
+
+// postWebhook delivers event as a JSON POST to url. Failures are logged and not retried.
+// It is meant to run in its own goroutine; since the delivery outlives the request, logger must
+// not be bound to a Kong PDK.
+func postWebhook(target string, event interface{}, logger *PluginLogger) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		logger.Warn("Failed to create webhook request", "error", err.Error())
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", PluginName+"/"+Version)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		logger.Warn("Webhook delivery failed", "url", target, "error", err.Error())
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		logger.Warn("Webhook rejected", "url", target, "status", resp.StatusCode)
+	}
+}
+
+// validWebhookURL reports whether u is an absolute http or https URL.
+func validWebhookURL(u string) bool {
+	parsed, err := url.Parse(u)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
+// webhookBatcher queues JSON events and posts them to url in batches from its own goroutine, so
+// that requests never wait for delivery. A batch is sent when it is full or interval after the
+// previous one. Batches failing with a connection error, 429 or 5xx are retried up to retries
+// times, waiting interval and doubling it after each attempt; events are dropped while the queue
+// is full.
+type webhookBatcher struct {
+	url      string
+	ndjson   bool // newline-terminated events posted as is, instead of a JSON array
+	retries  int
+	interval time.Duration
+	events   chan []byte
+	dropped  atomic.Int64
+}
+
+func newWebhookBatcher(url string, ndjson bool, retries int, interval time.Duration) *webhookBatcher {
+	b := &webhookBatcher{url: url, ndjson: ndjson, retries: retries, interval: interval,
+		events: make(chan []byte, webhookQueueSize)}
+	go b.run()
+	return b
+}
+
+// enqueue queues an encoded event without blocking.
+func (b *webhookBatcher) enqueue(event []byte) {
+	select {
+	case b.events <- event:
+	default:
+		b.dropped.Add(1)
+	}
+}
+
+func (b *webhookBatcher) run() {
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	var batch [][]byte
+	for {
+		select {
+		case event := <-b.events:
+			if batch = append(batch, event); len(batch) < webhookBatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		b.send(batch)
+		batch = nil
+	}
+}
+
+// send posts one batch, retrying failed deliveries.
+func (b *webhookBatcher) send(batch [][]byte) {
+	logger := NewPluginLogger(nil, "webhook", "")
+	if dropped := b.dropped.Swap(0); dropped > 0 {
+		logger.Warn("Webhook queue full, events dropped", "url", b.url, "dropped", dropped)
+	}
+	var body []byte
+	if b.ndjson {
+		body = bytes.Join(batch, nil)
+	} else {
+		body = append(append([]byte{'['}, bytes.Join(batch, []byte{','})...), ']')
+	}
+
+	backoff := b.interval
+	for attempt := 0; ; attempt++ {
+		retry, err := b.post(body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt >= b.retries {
+			logger.Warn("Webhook delivery failed", "url", b.url, "events", len(batch), "attempts", attempt+1, "error", err.Error())
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// post delivers body once and reports whether a failure may be retried.
+func (b *webhookBatcher) post(body []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if b.ndjson {
+		req.Header.Set("Content-Type", "application/x-ndjson")
+	}
+	req.Header.Set("User-Agent", PluginName+"/"+Version)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, fmt.Errorf("webhook returned %d", resp.StatusCode)
+	}
+	return false, nil
+}
+
+// webhookRegistry shares batchers between configs posting to the same URL. Batchers run for the
+// lifetime of the process.
+type webhookRegistry struct {
+	mu       sync.Mutex
+	batchers map[string]*webhookBatcher
+}
+
+var globalWebhooks = &webhookRegistry{batchers: make(map[string]*webhookBatcher)}
+
+// get returns the batcher posting to url, creating it on first use.
+func (r *webhookRegistry) get(url string, ndjson bool, retries int) *webhookBatcher {
+	key := fmt.Sprint(url, " ", ndjson, " ", retries)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, ok := r.batchers[key]
+	if !ok {
+		b = newWebhookBatcher(url, ndjson, retries, webhookFlushInterval)
+		r.batchers[key] = b
+	}
+	return b
+}
+
+// Events posted to deny_webhook_url.
+const (
+	eventDeny               = "deny"
+	eventCircuitBreakerOpen = "circuit_breaker_open"
+)
+
+// DenyEvent is posted to deny_webhook_url, in JSON arrays of up to 100 events, when a policy
+// denies a request or response, or when a sideband call opens a circuit breaker.
+type DenyEvent struct {
+	Event      string    `json:"event"` // "deny" or "circuit_breaker_open"
+	ServiceURL string    `json:"service_url"`
+	Time       time.Time `json:"time"`
+
+	// Set for deny events
+	Phase      string               `json:"phase,omitempty"`
+	StatusCode int                  `json:"status_code,omitempty"`
+	Request    *decisionctx.Request `json:"request,omitempty"`
+	MCP        *decisionctx.MCP     `json:"mcp,omitempty"`
+	Shadow     bool                 `json:"shadow,omitempty"`
+
+	// Set for circuit_breaker_open events
+	Path         string `json:"path,omitempty"`
+	Trigger      string `json:"trigger,omitempty"`
+	RetryAfterMs int64  `json:"retry_after_ms,omitempty"`
+}
+
+// getDenyWebhook returns the batcher of deny_webhook_url, or nil when it is not set.
+func (c *Config) getDenyWebhook() *webhookBatcher {
+	c.denyWebhookOnce.Do(func() {
+		if c.DenyWebhookURL != "" {
+			c.denyWebhook = globalWebhooks.get(c.DenyWebhookURL, false, c.DenyWebhookRetries)
+		}
+	})
+	return c.denyWebhook
+}
+
+// publishDeny queues a deny event for the decision of phase when it is a denial.
+func (c *Config) publishDeny(phaseName string, record *decisionctx.Record, phase *decisionctx.Phase) {
+	if phase.Decision != decisionctx.DecisionDeny || c.getDenyWebhook() == nil {
+		return
+	}
+	event := DenyEvent{Event: eventDeny, ServiceURL: c.ServiceURL, Time: time.Now().UTC(), Phase: phaseName, StatusCode: phase.StatusCode,
+		Shadow: phase.Shadow}
+	if record != nil {
+		event.Request, event.MCP = &record.Request, record.MCP
+	}
+	c.publishEvent(event)
+}
+
+// publishBreakerOpen queues a circuit_breaker_open event for the breaker of path.
+func (c *Config) publishBreakerOpen(path string, cb *CircuitBreaker) {
+	if c.getDenyWebhook() == nil {
+		return
+	}
+	st := cb.status()
+	c.publishEvent(DenyEvent{Event: eventCircuitBreakerOpen, ServiceURL: c.ServiceURL, Time: time.Now().UTC(), Path: path,
+		Trigger: st.Trigger, RetryAfterMs: st.RemainingMs})
+}
+
+func (c *Config) publishEvent(event DenyEvent) {
+	data, err := json.Marshal(event)
+	if err == nil {
+		c.getDenyWebhook().enqueue(data)
+	}
+}
@@ -0,0 +1,146 @@
+package pingauthorize
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookReceiver records the bodies posted to it, responding with statuses in turn and 200 once
+// they run out.
+type webhookReceiver struct {
+	mu          sync.Mutex
+	statuses    []int
+	bodies      []string
+	contentType string
+}
+
+func (rcv *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	rcv.bodies = append(rcv.bodies, string(body))
+	rcv.contentType = r.Header.Get("Content-Type")
+	if len(rcv.statuses) > 0 {
+		w.WriteHeader(rcv.statuses[0])
+		rcv.statuses = rcv.statuses[1:]
+	}
+}
+
+// waitFor polls the received bodies until done returns true or the test times out.
+func (rcv *webhookReceiver) waitFor(t *testing.T, done func(bodies []string) bool) []string {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		rcv.mu.Lock()
+		bodies := append([]string(nil), rcv.bodies...)
+		rcv.mu.Unlock()
+		if done(bodies) {
+			return bodies
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for webhook deliveries, got %q", bodies)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWebhookBatcher(t *testing.T) {
+	tests := []struct {
+		name            string
+		ndjson          bool
+		retries         int
+		statuses        []int
+		events          []string
+		wantBodies      []string
+		wantContentType string
+	}{
+		{"json array", false, 0, nil, []string{`{"n":1}`, `{"n":2}`},
+			[]string{`[{"n":1},{"n":2}]`}, "application/json"},
+		{"ndjson", true, 0, nil, []string{`{"n":1}` + "\n", `{"n":2}` + "\n"},
+			[]string{`{"n":1}` + "\n" + `{"n":2}` + "\n"}, "application/x-ndjson"},
+		{"retried after 503", false, 2, []int{503, 503}, []string{`{"n":1}`},
+			[]string{`[{"n":1}]`, `[{"n":1}]`, `[{"n":1}]`}, "application/json"},
+		{"retries exhausted", false, 1, []int{503, 503, 503}, []string{`{"n":1}`},
+			[]string{`[{"n":1}]`, `[{"n":1}]`}, "application/json"},
+		{"client error not retried", false, 2, []int{400}, []string{`{"n":1}`},
+			[]string{`[{"n":1}]`}, "application/json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rcv := &webhookReceiver{statuses: tt.statuses}
+			server := httptest.NewServer(rcv)
+			defer server.Close()
+
+			b := newWebhookBatcher(server.URL, tt.ndjson, tt.retries, 5*time.Millisecond)
+			for _, event := range tt.events {
+				b.enqueue([]byte(event))
+			}
+			rcv.waitFor(t, func(bodies []string) bool { return len(bodies) >= len(tt.wantBodies) })
+			time.Sleep(50 * time.Millisecond) // no further attempts
+
+			rcv.mu.Lock()
+			defer rcv.mu.Unlock()
+			if strings.Join(rcv.bodies, "|") != strings.Join(tt.wantBodies, "|") || rcv.contentType != tt.wantContentType {
+				t.Errorf("received %q as %q, want %q as %q", rcv.bodies, rcv.contentType, tt.wantBodies, tt.wantContentType)
+			}
+		})
+	}
+}
+
+func TestMiddleware_DenyWebhook(t *testing.T) {
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		var req SidebandAccessRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Method == http.MethodPut {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		json.NewEncoder(w).Encode(SidebandAccessResponse{Response: &DenyResponse{ResponseCode: "403"}})
+	})
+	defer server.Close()
+	rcv := &webhookReceiver{}
+	receiver := httptest.NewServer(rcv)
+	defer receiver.Close()
+
+	conf := NewConfig()
+	conf.ServiceURL = server.URL
+	conf.SharedSecret = "secret"
+	conf.SecretHeaderName = "X-Secret"
+	conf.SkipResponsePhase = true
+	conf.DenyWebhookURL = receiver.URL
+	conf.denyWebhookOnce.Do(func() { conf.denyWebhook = newWebhookBatcher(receiver.URL, false, 0, 5*time.Millisecond) })
+	m, err := NewMiddleware(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := m.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/resource", nil))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPut, "/resource", nil))
+
+	var events []DenyEvent
+	rcv.waitFor(t, func(bodies []string) bool {
+		events = nil
+		for _, body := range bodies {
+			var batch []DenyEvent
+			json.Unmarshal([]byte(body), &batch)
+			events = append(events, batch...)
+		}
+		return len(events) >= 2
+	})
+	deny, breaker := events[0], events[1]
+	if deny.Event != eventDeny || deny.Phase != "access" || deny.StatusCode != 403 || deny.Request == nil ||
+		deny.Request.Method != http.MethodGet || deny.ServiceURL != server.URL {
+		t.Errorf("unexpected deny event %+v", deny)
+	}
+	if breaker.Event != eventCircuitBreakerOpen || breaker.Path != "/sideband/request" || breaker.Trigger != "rate_limited" ||
+		breaker.RetryAfterMs <= 0 {
+		t.Errorf("unexpected circuit breaker event %+v", breaker)
+	}
+}
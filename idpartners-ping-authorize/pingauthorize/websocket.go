@@ -0,0 +1,627 @@
+package pingauthorize
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/idpartners/idpartners-ping-authorize/pingauthorize/decisionctx"
+)
+
+// WebSocket opcodes (RFC 6455 section 5.2).
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+)
+
+const (
+	// wsCloseProtocolError and wsCloseMessageTooBig are close status codes sent to the client
+	// when a connection is torn down by the plugin.
+	wsCloseProtocolError = 1002
+	wsCloseMessageTooBig = 1009
+
+	// maxWSPendingRequests caps the JSON-RPC requests a connection tracks while awaiting responses.
+	maxWSPendingRequests = 1000
+)
+
+var (
+	errWSMessageTooBig = errors.New("websocket message exceeds size limit")
+	errWSProtocol      = errors.New("websocket protocol error")
+)
+
+// wsFrame is a single WebSocket frame with its payload unmasked.
+type wsFrame struct {
+	fin     bool
+	rsv     byte
+	opcode  byte
+	payload []byte
+}
+
+// readWSFrame reads one frame from r, unmasking the payload if needed. Frames with a payload
+// larger than maxPayload are rejected with errWSMessageTooBig.
+func readWSFrame(r *bufio.Reader, maxPayload int) (*wsFrame, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, err
+	}
+	f := &wsFrame{fin: head[0]&0x80 != 0, rsv: head[0] & 0x70, opcode: head[0] & 0x0f}
+	masked := head[1]&0x80 != 0
+
+	length := uint64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > uint64(maxPayload) {
+		return nil, errWSMessageTooBig
+	}
+
+	var key [4]byte
+	if masked {
+		if _, err := io.ReadFull(r, key[:]); err != nil {
+			return nil, err
+		}
+	}
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		return nil, err
+	}
+	if masked {
+		for i := range f.payload {
+			f.payload[i] ^= key[i%4]
+		}
+	}
+	return f, nil
+}
+
+// writeWSFrame writes f to w as a single frame. Frames sent towards a server must be masked.
+func writeWSFrame(w io.Writer, f *wsFrame, mask bool) error {
+	buf := make([]byte, 0, 14+len(f.payload))
+	b0 := f.rsv | f.opcode
+	if f.fin {
+		b0 |= 0x80
+	}
+	buf = append(buf, b0)
+
+	var b1 byte
+	if mask {
+		b1 = 0x80
+	}
+	switch n := len(f.payload); {
+	case n < 126:
+		buf = append(buf, b1|byte(n))
+	case n <= 0xffff:
+		buf = append(buf, b1|126)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(n))
+	default:
+		buf = append(buf, b1|127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(n))
+	}
+
+	if !mask {
+		_, err := w.Write(append(buf, f.payload...))
+		return err
+	}
+	var key [4]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return err
+	}
+	buf = append(buf, key[:]...)
+	for i, c := range f.payload {
+		buf = append(buf, c^key[i%4])
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// wsMessageReader reassembles fragmented data messages. Control frames, which may arrive between
+// fragments, are returned as they are read.
+type wsMessageReader struct {
+	r        *bufio.Reader
+	max      int
+	opcode   byte
+	fragment []byte
+}
+
+// next returns the next control frame or complete data message.
+func (mr *wsMessageReader) next() (*wsFrame, error) {
+	for {
+		f, err := readWSFrame(mr.r, mr.max)
+		if err != nil {
+			return nil, err
+		}
+		// Extensions are stripped from the handshake, so no RSV bits may be set
+		if f.rsv != 0 {
+			return nil, errWSProtocol
+		}
+		if f.opcode >= wsOpClose {
+			if !f.fin {
+				return nil, errWSProtocol
+			}
+			return f, nil
+		}
+
+		if f.opcode == wsOpContinuation {
+			if mr.opcode == 0 {
+				return nil, errWSProtocol
+			}
+		} else {
+			if mr.opcode != 0 {
+				return nil, errWSProtocol
+			}
+			mr.opcode = f.opcode
+		}
+		if len(mr.fragment)+len(f.payload) > mr.max {
+			return nil, errWSMessageTooBig
+		}
+		mr.fragment = append(mr.fragment, f.payload...)
+		if !f.fin {
+			continue
+		}
+
+		msg := &wsFrame{fin: true, opcode: mr.opcode, payload: mr.fragment}
+		mr.opcode, mr.fragment = 0, nil
+		return msg, nil
+	}
+}
+
+// trafficTypeWebSocket is the traffic_type of WebSocket upgrade requests, and of the messages of
+// connections intercepted with mcp_websocket.
+const trafficTypeWebSocket = "websocket"
+
+// isWebSocketUpgrade reports whether r asks to upgrade the connection to WebSocket.
+func isWebSocketUpgrade(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket") && hasToken(r.Header.Values("Connection"), "upgrade")
+}
+
+// enrichWebSocket sets traffic_type when the payload is a WebSocket upgrade request (RFC 6455).
+func enrichWebSocket(payload *SidebandAccessRequest) {
+	if payload.Method != "GET" || payload.TrafficType != "" {
+		return
+	}
+	headers := FlattenHeaders(payload.Headers)
+	if strings.EqualFold(firstValue(headers["upgrade"]), "websocket") && hasToken(headers["connection"], "upgrade") {
+		payload.TrafficType = trafficTypeWebSocket
+	}
+}
+
+// hasToken reports whether any of the comma-separated header values contains token.
+func hasToken(values []string, token string) bool {
+	for _, v := range values {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsInterceptor is the ResponseWriter handed to the upstream handler for a WebSocket upgrade when
+// mcp_websocket is set. When the handler hijacks the connection it receives one end of a pipe;
+// a wsSession relays frames between the pipe and the client, evaluating every message.
+type wsInterceptor struct {
+	http.ResponseWriter
+	m *Middleware
+	r *http.Request
+}
+
+// Hijack takes over the client connection and returns the handler's end of the relay.
+func (w *wsInterceptor) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	client, clientRW, err := hj.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	handlerConn, relayConn := net.Pipe()
+	s := &wsSession{
+		m:        w.m,
+		r:        w.r,
+		client:   client,
+		clientRd: clientRW.Reader,
+		upstream: relayConn,
+		pending:  make(map[string]wsPending),
+		upgraded: make(chan bool, 1),
+		logger:   NewPluginLogger(nil, "websocket", w.m.conf.ServiceURL),
+	}
+	s.logger.skipPayloads = debugSkipped(w.r.Context())
+	go s.relayUpstream()
+	go s.relayClient()
+	return handlerConn, bufio.NewReadWriter(bufio.NewReader(handlerConn), bufio.NewWriter(handlerConn)), nil
+}
+
+// Flush forwards to the underlying writer so non-upgrade responses can still be streamed.
+func (w *wsInterceptor) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// wsPending is a client request awaiting its response from the upstream.
+type wsPending struct {
+	payload *SidebandAccessRequest
+	state   []byte
+	body    []byte
+	mcp     bool
+	// unsampled is set when websocket_message_sample_percent let the request through unevaluated
+	unsampled bool
+}
+
+// wsSession relays one intercepted WebSocket connection. Client messages are evaluated like
+// access phase requests and upstream messages like response phase responses, each as its own
+// sideband call. Denied requests are answered with a JSON-RPC error frame and never forwarded.
+type wsSession struct {
+	m        *Middleware
+	r        *http.Request
+	client   net.Conn
+	clientRd *bufio.Reader
+	upstream net.Conn
+	logger   *PluginLogger
+
+	// clientMu serializes writes to the client, which both relay directions make
+	clientMu sync.Mutex
+
+	pendingMu sync.Mutex
+	pending   map[string]wsPending
+
+	// upgraded receives whether the upstream accepted the upgrade, before client frames are read
+	upgraded  chan bool
+	closeOnce sync.Once
+}
+
+func (s *wsSession) close() {
+	s.closeOnce.Do(func() {
+		s.client.Close()
+		s.upstream.Close()
+	})
+}
+
+// fail tears the connection down, telling the client why when the error is one it caused.
+func (s *wsSession) fail(err error) {
+	if errors.Is(err, errWSMessageTooBig) {
+		s.closeClient(wsCloseMessageTooBig, "message too big")
+	} else if errors.Is(err, errWSProtocol) {
+		s.closeClient(wsCloseProtocolError, "protocol error")
+	}
+	s.close()
+}
+
+func (s *wsSession) recoverPanic() {
+	if rec := recover(); rec != nil {
+		s.logger.Err("Unexpected panic in WebSocket relay", "panic", fmt.Sprint(rec))
+		s.close()
+	}
+}
+
+func (s *wsSession) writeClient(f *wsFrame) error {
+	s.clientMu.Lock()
+	defer s.clientMu.Unlock()
+	return writeWSFrame(s.client, f, false)
+}
+
+func (s *wsSession) closeClient(code int, reason string) {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	s.writeClient(&wsFrame{fin: true, opcode: wsOpClose, payload: append(payload, reason...)})
+}
+
+// relayUpstream copies the handshake response to the client, then evaluates and relays upstream
+// messages until either side closes.
+func (s *wsSession) relayUpstream() {
+	defer s.close()
+	defer s.recoverPanic()
+
+	br := bufio.NewReader(s.upstream)
+	var head bytes.Buffer
+	for {
+		line, err := br.ReadSlice('\n')
+		head.Write(line)
+		if err != nil {
+			s.upgraded <- false
+			return
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			break
+		}
+	}
+	s.clientMu.Lock()
+	_, err := s.client.Write(head.Bytes())
+	s.clientMu.Unlock()
+	if err != nil {
+		s.upgraded <- false
+		return
+	}
+
+	// Anything other than 101 Switching Protocols is relayed as is
+	statusLine, _, _ := bytes.Cut(head.Bytes(), []byte("\n"))
+	if fields := strings.Fields(string(statusLine)); len(fields) < 2 || fields[1] != "101" {
+		s.upgraded <- false
+		io.Copy(s.client, br)
+		return
+	}
+	s.upgraded <- true
+
+	mr := &wsMessageReader{r: br, max: maxMCPBodyBytes}
+	for {
+		msg, err := mr.next()
+		if err != nil {
+			s.fail(err)
+			return
+		}
+		if msg.opcode == wsOpText || msg.opcode == wsOpBinary {
+			payload, ok := s.evaluateUpstreamMessage(msg.payload)
+			if s.m.conf.ShadowMode {
+				// Evaluated for the logs and metrics only, relayed as received
+				payload, ok = msg.payload, true
+			}
+			if !ok {
+				continue
+			}
+			msg.payload = payload
+		}
+		if err := s.writeClient(msg); err != nil {
+			return
+		}
+	}
+}
+
+// relayClient evaluates client messages once the upgrade is accepted and relays the allowed ones.
+func (s *wsSession) relayClient() {
+	defer s.close()
+	defer s.recoverPanic()
+
+	if !<-s.upgraded {
+		io.Copy(s.upstream, s.clientRd)
+		return
+	}
+
+	mr := &wsMessageReader{r: s.clientRd, max: maxMCPBodyBytes}
+	for {
+		msg, err := mr.next()
+		if err != nil {
+			s.fail(err)
+			return
+		}
+		if msg.opcode == wsOpText || msg.opcode == wsOpBinary {
+			payload, deny := s.evaluateClientMessage(msg.payload)
+			if s.m.conf.ShadowMode {
+				// Evaluated for the logs and metrics only, relayed as sent
+				payload, deny = msg.payload, nil
+			}
+			if deny != nil {
+				if err := s.writeClient(&wsFrame{fin: true, opcode: wsOpText, payload: deny}); err != nil {
+					return
+				}
+				continue
+			}
+			msg.payload = payload
+		}
+		if err := writeWSFrame(s.upstream, msg, true); err != nil {
+			return
+		}
+	}
+}
+
+// evaluateClientMessage runs the access phase for one client message. It returns the message to
+// forward, or a JSON-RPC error body to send back to the client instead.
+func (s *wsSession) evaluateClientMessage(msg []byte) (forward, deny []byte) {
+	conf := s.m.conf
+	logger := s.logger
+
+	mcpReq := parseMCPRequest(msg)
+	var id json.RawMessage
+	if mcpReq != nil {
+		id = mcpReq.ID
+	}
+
+	payload, err := composeHTTPAccessPayload(s.r, conf, msg)
+	if err != nil {
+		logger.Err("Failed to compose access payload", "error", err.Error())
+		return nil, formatMCPDenyResponse(http.StatusBadRequest, "Invalid request", id)
+	}
+
+	if body := toolArgumentsError(conf, msg); body != nil {
+		logger.Info("MCP tool arguments do not match the configured schema, denying message")
+		return nil, body
+	}
+	if _, body, _, blocked := screenPromptInjection(conf, payload, logger); blocked {
+		return nil, body
+	}
+	if _, body, _, ok := resourceDenial(conf, payload); ok {
+		logger.Info("MCP resource URI not allowed, denying message")
+		return nil, body
+	}
+
+	switch action, rule := applyEvaluationRules(conf, payload, consumerFromContext(s.r.Context()), logger); action {
+	case ruleActionSkip:
+		return msg, nil
+	case ruleActionDeny:
+		status, message := rule.denial()
+		return nil, formatMCPDenyError(message, id, newMCPDenyData(conf, payload, status, decisionctx.ReasonEvaluationRule))
+	}
+
+	switch applyToolPolicy(conf, payload, logger) {
+	case toolPolicyAllow:
+		return msg, nil
+	case toolPolicyDeny:
+		return nil, mcpDenyBody("Tool not allowed", msg, newMCPDenyData(conf, payload, http.StatusForbidden, decisionctx.ReasonToolPolicy))
+	}
+
+	skip, err := applyExpressions(conf, payload, logger)
+	if err != nil {
+		logger.Err("Failed to compile CEL expressions", "error", err.Error())
+		return nil, formatMCPDenyResponse(http.StatusInternalServerError, "Internal error", id)
+	}
+	if skip {
+		return msg, nil
+	}
+	if sampleRoll() >= conf.WebSocketMessageSamplePercent {
+		logger.Debug("WebSocket message not sampled, forwarding without evaluation")
+		s.track(id, wsPending{unsampled: true})
+		return msg, nil
+	}
+
+	ctx, cancel := phaseContext(s.m.forwardHeadersContext(s.r), conf, "access", 0)
+	defer cancel()
+	resolveTokenClaims(ctx, conf, payload)
+	resolveTokenSubject(ctx, conf, payload, logger)
+
+	applyBodySampling(conf, payload, consumerFromContext(s.r.Context()), logger)
+
+	DebugLogPayload(logger, "Sending sideband request", payload, conf)
+
+	resp, err := s.m.provider.EvaluateRequest(ctx, payload)
+	if err != nil {
+		if status, _, _, handled := sidebandFailure(conf, err, logger); handled {
+			return nil, formatMCPDenyResponse(status, http.StatusText(status), id)
+		}
+		logger.Warn("PingAuthorize unreachable, fail-open enabled, allowing message")
+		s.track(id, wsPending{payload: payload, body: msg, mcp: mcpReq != nil})
+		return msg, nil
+	}
+
+	DebugLogPayload(logger, "Received sideband response", resp, conf)
+	ignoreOmittedBody(payload, resp)
+	ignoreStrippedArguments(payload, resp)
+
+	if resp.Response != nil {
+		statusCode, err := strconv.Atoi(resp.Response.ResponseCode)
+		if err != nil {
+			statusCode = 403
+		}
+		logger.Info("WebSocket message denied by policy provider", "status_code", statusCode)
+		data := newMCPDenyData(conf, payload, statusCode, mcpDenyReasonPolicy)
+		data.DecisionID = policyDecisionID(resp.Response.Body)
+		return nil, formatMCPDenyError("Request denied by policy", id, data)
+	}
+
+	if resp.Body != nil && *resp.Body != string(msg) {
+		msg = []byte(*resp.Body)
+	}
+	s.track(id, wsPending{payload: payload, state: resp.State, body: msg, mcp: mcpReq != nil})
+	return msg, nil
+}
+
+// track remembers an allowed request so its response can be evaluated against it.
+func (s *wsSession) track(id json.RawMessage, p wsPending) {
+	if len(id) == 0 || string(id) == "null" || s.m.conf.SkipResponsePhase {
+		return
+	}
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	if len(s.pending) >= maxWSPendingRequests {
+		for k := range s.pending {
+			delete(s.pending, k)
+			break
+		}
+	}
+	s.pending[string(id)] = p
+}
+
+// evaluateUpstreamMessage runs the response phase for one upstream message. It returns the
+// message to send to the client, and false if nothing should be sent.
+func (s *wsSession) evaluateUpstreamMessage(msg []byte) ([]byte, bool) {
+	conf := s.m.conf
+	if conf.SkipResponsePhase {
+		return msg, true
+	}
+
+	var envelope struct {
+		ID     json.RawMessage `json:"id"`
+		Method string          `json:"method"`
+	}
+	var pending wsPending
+	var tracked bool
+	if json.Unmarshal(msg, &envelope) == nil && envelope.Method == "" && len(envelope.ID) > 0 {
+		s.pendingMu.Lock()
+		pending, tracked = s.pending[string(envelope.ID)]
+		delete(s.pending, string(envelope.ID))
+		s.pendingMu.Unlock()
+	}
+
+	if pending.unsampled || (conf.ResponsePhaseMCPOnly && !pending.mcp) {
+		return msg, true
+	}
+	if len(conf.ResponsePhaseStatusCodes) > 0 && !matchStatusCode(http.StatusOK, conf.ResponsePhaseStatusCodes) {
+		return msg, true
+	}
+
+	logger := s.logger
+	original := pending.payload
+	if original == nil {
+		var err error
+		if original, err = composeHTTPAccessPayload(s.r, conf, nil); err != nil {
+			logger.Err("Failed to compose access payload", "error", err.Error())
+			return nil, false
+		}
+		resolveTokenClaims(s.r.Context(), conf, original)
+		resolveTokenSubject(s.r.Context(), conf, original, logger)
+	}
+
+	header := http.Header{"Content-Type": {"application/json"}}
+	payload, _, err := composeHTTPResponsePayload(s.r, conf, http.StatusOK, header, msg, original, pending.state, logger)
+	if err != nil {
+		logger.Err("Failed to format response headers", "error", err.Error())
+		return nil, false
+	}
+
+	DebugLogPayload(logger, "Sending sideband response", payload, conf)
+
+	ctx, cancel := phaseContext(s.m.forwardHeadersContext(s.r), conf, "response", 0)
+	defer cancel()
+	result, err := s.m.provider.EvaluateResponse(ctx, payload)
+	if err != nil {
+		if status, _, _, handled := sidebandFailure(conf, err, logger); handled {
+			if !tracked {
+				return nil, false
+			}
+			return formatMCPDenyResponse(status, http.StatusText(status), envelope.ID), true
+		}
+		logger.Warn("PingAuthorize unreachable during response phase, fail-open, passing message through")
+		return msg, true
+	}
+
+	DebugLogPayload(logger, "Received sideband response result", result, conf)
+
+	checkToolsDrift(conf, s.r.Host+s.r.URL.Path, s.r.Header.Get("Mcp-Session-Id"), pending.body, []byte(result.Body), "application/json", logger)
+
+	if statusCode, err := strconv.Atoi(result.ResponseCode); err == nil && statusCode >= 400 {
+		logger.Info("WebSocket message denied by policy provider during response phase", "status_code", statusCode)
+		if !tracked {
+			return nil, false
+		}
+		data := newMCPDenyData(conf, original, statusCode, mcpDenyReasonResponsePolicy)
+		if data.DecisionID = result.ID; data.DecisionID == "" {
+			data.DecisionID = policyDecisionID(result.Body)
+		}
+		return formatMCPDenyError("Response denied by policy", envelope.ID, data), true
+	}
+	return []byte(result.Body), true
+}
@@ -0,0 +1,372 @@
+package pingauthorize
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWSFrame_RoundTrip(t *testing.T) {
+	for _, size := range []int{0, 5, 125, 126, 200, 70000} {
+		for _, mask := range []bool{false, true} {
+			payload := bytes.Repeat([]byte("x"), size)
+			var buf bytes.Buffer
+			if err := writeWSFrame(&buf, &wsFrame{fin: true, opcode: wsOpText, payload: payload}, mask); err != nil {
+				t.Fatal(err)
+			}
+			if masked := buf.Bytes()[1]&0x80 != 0; masked != mask {
+				t.Errorf("size %d: mask bit = %v, want %v", size, masked, mask)
+			}
+			f, err := readWSFrame(bufio.NewReader(&buf), 1<<20)
+			if err != nil {
+				t.Fatalf("size %d mask %v: %v", size, mask, err)
+			}
+			if !f.fin || f.opcode != wsOpText || !bytes.Equal(f.payload, payload) {
+				t.Errorf("size %d mask %v: frame did not round-trip", size, mask)
+			}
+		}
+	}
+}
+
+func TestWSMessageReader(t *testing.T) {
+	frames := func(fs ...*wsFrame) *wsMessageReader {
+		var buf bytes.Buffer
+		for _, f := range fs {
+			writeWSFrame(&buf, f, true)
+		}
+		return &wsMessageReader{r: bufio.NewReader(&buf), max: 16}
+	}
+
+	t.Run("fragmented with interleaved ping", func(t *testing.T) {
+		mr := frames(
+			&wsFrame{opcode: wsOpText, payload: []byte("hel")},
+			&wsFrame{fin: true, opcode: wsOpPing, payload: []byte("p")},
+			&wsFrame{fin: true, opcode: wsOpContinuation, payload: []byte("lo")},
+		)
+		ping, err := mr.next()
+		if err != nil || ping.opcode != wsOpPing {
+			t.Fatalf("expected ping first, got %+v %v", ping, err)
+		}
+		msg, err := mr.next()
+		if err != nil || msg.opcode != wsOpText || string(msg.payload) != "hello" {
+			t.Fatalf("expected reassembled text message, got %+v %v", msg, err)
+		}
+	})
+
+	t.Run("too big", func(t *testing.T) {
+		mr := frames(
+			&wsFrame{opcode: wsOpText, payload: bytes.Repeat([]byte("a"), 10)},
+			&wsFrame{fin: true, opcode: wsOpContinuation, payload: bytes.Repeat([]byte("a"), 10)},
+		)
+		if _, err := mr.next(); err != errWSMessageTooBig {
+			t.Errorf("expected errWSMessageTooBig, got %v", err)
+		}
+	})
+
+	t.Run("compressed frame", func(t *testing.T) {
+		mr := frames(&wsFrame{fin: true, rsv: 0x40, opcode: wsOpText, payload: []byte("x")})
+		if _, err := mr.next(); err != errWSProtocol {
+			t.Errorf("expected errWSProtocol, got %v", err)
+		}
+	})
+
+	t.Run("unexpected continuation", func(t *testing.T) {
+		mr := frames(&wsFrame{fin: true, opcode: wsOpContinuation, payload: []byte("x")})
+		if _, err := mr.next(); err != errWSProtocol {
+			t.Errorf("expected errWSProtocol, got %v", err)
+		}
+	})
+}
+
+func TestIsWebSocketUpgrade(t *testing.T) {
+	tests := []struct {
+		upgrade, connection string
+		want                bool
+	}{
+		{"websocket", "Upgrade", true},
+		{"WebSocket", "keep-alive, upgrade", true},
+		{"websocket", "keep-alive", false},
+		{"h2c", "Upgrade", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "http://api.example.com/mcp", nil)
+		r.Header.Set("Upgrade", tt.upgrade)
+		r.Header.Set("Connection", tt.connection)
+		if got := isWebSocketUpgrade(r); got != tt.want {
+			t.Errorf("Upgrade=%q Connection=%q: got %v, want %v", tt.upgrade, tt.connection, got, tt.want)
+		}
+	}
+}
+
+// wsEchoUpstream accepts a WebSocket upgrade and answers every JSON-RPC request with a result
+// naming its method.
+func wsEchoUpstream(t *testing.T, messages *int32, extensions *atomic.Value) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		extensions.Store(r.Header.Get("Sec-WebSocket-Extensions"))
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("hijack: %v", err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		rw.Flush()
+
+		mr := &wsMessageReader{r: rw.Reader, max: 1 << 20}
+		for {
+			msg, err := mr.next()
+			if err != nil {
+				return
+			}
+			if msg.opcode != wsOpText {
+				continue
+			}
+			atomic.AddInt32(messages, 1)
+			var req jsonRPCRequest
+			json.Unmarshal(msg.payload, &req)
+			reply := `{"jsonrpc":"2.0","id":` + string(req.ID) + `,"result":{"echo":"` + req.Method + `"}}`
+			if err := writeWSFrame(conn, &wsFrame{fin: true, opcode: wsOpText, payload: []byte(reply)}, false); err != nil {
+				return
+			}
+		}
+	})
+}
+
+func TestMiddleware_WebSocket(t *testing.T) {
+	var responseCalls int32
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/sideband/response" {
+			atomic.AddInt32(&responseCalls, 1)
+			var payload SidebandResponsePayload
+			json.NewDecoder(r.Body).Decode(&payload)
+			json.NewEncoder(w).Encode(SidebandResponseResult{
+				ResponseCode: "200",
+				Body:         strings.Replace(payload.Body, "echo", "checked", 1),
+			})
+			return
+		}
+		var req SidebandAccessRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if strings.Contains(req.Body, `"shell"`) {
+			w.Write([]byte(`{"response":{"response_code":"403","response_status":"FORBIDDEN"}}`))
+			return
+		}
+		json.NewEncoder(w).Encode(SidebandAccessResponse{Method: req.Method, URL: req.URL, Body: &req.Body, Headers: req.Headers})
+	})
+	defer server.Close()
+
+	m, err := NewMiddleware(&Config{
+		ServiceURL:       server.URL,
+		SharedSecret:     "test-secret",
+		SecretHeaderName: "X-Secret",
+		MCPWebSocket:     true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var messages int32
+	var extensions atomic.Value
+	proxy := httptest.NewServer(m.Handler(wsEchoUpstream(t, &messages, &extensions)))
+	defer proxy.Close()
+
+	conn, err := net.Dial("tcp", proxy.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	conn.Write([]byte("GET /mcp HTTP/1.1\r\nHost: api.example.com\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Extensions: permessage-deflate\r\n\r\n"))
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected 101, got %d", resp.StatusCode)
+	}
+	if ext, _ := extensions.Load().(string); ext != "" {
+		t.Errorf("Sec-WebSocket-Extensions should be stripped, upstream saw %q", ext)
+	}
+
+	roundTrip := func(msg string) map[string]interface{} {
+		t.Helper()
+		if err := writeWSFrame(conn, &wsFrame{fin: true, opcode: wsOpText, payload: []byte(msg)}, true); err != nil {
+			t.Fatal(err)
+		}
+		f, err := readWSFrame(br, 1<<20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var reply map[string]interface{}
+		if err := json.Unmarshal(f.payload, &reply); err != nil {
+			t.Fatalf("reply is not JSON: %s", f.payload)
+		}
+		return reply
+	}
+
+	reply := roundTrip(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"search"}}`)
+	if reply["id"] != float64(1) || reply["result"].(map[string]interface{})["checked"] != "tools/call" {
+		t.Errorf("expected response-phase result for id 1, got %v", reply)
+	}
+
+	reply = roundTrip(`{"jsonrpc":"2.0","id":"two","method":"tools/call","params":{"name":"shell"}}`)
+	errObj, _ := reply["error"].(map[string]interface{})
+	if reply["id"] != "two" || errObj == nil || errObj["code"] != float64(-32600) {
+		t.Errorf("expected JSON-RPC error for denied call, got %v", reply)
+	}
+
+	if n := atomic.LoadInt32(&messages); n != 1 {
+		t.Errorf("denied message should not reach upstream, got %d messages", n)
+	}
+	if n := atomic.LoadInt32(&responseCalls); n != 1 {
+		t.Errorf("expected 1 response phase call, got %d", n)
+	}
+}
+
+func TestMiddleware_WebSocketMessageTooBig(t *testing.T) {
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"method":"GET","url":"http://api.example.com:80/mcp","headers":[]}`))
+	})
+	defer server.Close()
+
+	m, err := NewMiddleware(&Config{
+		ServiceURL:        server.URL,
+		SharedSecret:      "test-secret",
+		SecretHeaderName:  "X-Secret",
+		SkipResponsePhase: true,
+		MCPWebSocket:      true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var messages int32
+	var extensions atomic.Value
+	proxy := httptest.NewServer(m.Handler(wsEchoUpstream(t, &messages, &extensions)))
+	defer proxy.Close()
+
+	conn, err := net.Dial("tcp", proxy.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	conn.Write([]byte("GET /mcp HTTP/1.1\r\nHost: api.example.com\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n"))
+	br := bufio.NewReader(conn)
+	if _, err := http.ReadResponse(br, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the header is sent; the declared length alone exceeds the limit
+	conn.Write([]byte{0x81, 0xff, 0, 0, 0, 0, 0x10, 0, 0, 0, 0, 0, 0, 0})
+	f, err := readWSFrame(br, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.opcode != wsOpClose || len(f.payload) < 2 || int(f.payload[0])<<8|int(f.payload[1]) != wsCloseMessageTooBig {
+		t.Errorf("expected close 1009, got opcode %d payload %v", f.opcode, f.payload)
+	}
+}
+
+func TestEnrichWebSocket(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload SidebandAccessRequest
+		want    string
+	}{
+		{"upgrade", SidebandAccessRequest{Method: "GET", Headers: []map[string]string{{"upgrade": "websocket"}, {"connection": "keep-alive, Upgrade"}}}, trafficTypeWebSocket},
+		{"no connection token", SidebandAccessRequest{Method: "GET", Headers: []map[string]string{{"upgrade": "websocket"}, {"connection": "keep-alive"}}}, ""},
+		{"other protocol", SidebandAccessRequest{Method: "GET", Headers: []map[string]string{{"upgrade": "h2c"}, {"connection": "Upgrade"}}}, ""},
+		{"post", SidebandAccessRequest{Method: "POST", Headers: []map[string]string{{"upgrade": "websocket"}, {"connection": "Upgrade"}}}, ""},
+		{"already typed", SidebandAccessRequest{Method: "GET", TrafficType: trafficTypeGraphQL, Headers: []map[string]string{{"upgrade": "websocket"}, {"connection": "Upgrade"}}}, trafficTypeGraphQL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enrichWebSocket(&tt.payload)
+			if tt.payload.TrafficType != tt.want {
+				t.Errorf("traffic_type = %q, want %q", tt.payload.TrafficType, tt.want)
+			}
+		})
+	}
+}
+
+func TestMiddleware_WebSocketMessageSampling(t *testing.T) {
+	var requestCalls, responseCalls int32
+	var trafficTypes []string
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/sideband/response" {
+			atomic.AddInt32(&responseCalls, 1)
+			json.NewEncoder(w).Encode(SidebandResponseResult{ResponseCode: "200", Body: `{}`})
+			return
+		}
+		atomic.AddInt32(&requestCalls, 1)
+		var req SidebandAccessRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		trafficTypes = append(trafficTypes, req.TrafficType)
+		json.NewEncoder(w).Encode(SidebandAccessResponse{Method: req.Method, URL: req.URL, Headers: req.Headers})
+	})
+	defer server.Close()
+
+	m, err := NewMiddleware(&Config{
+		ServiceURL:                    server.URL,
+		SharedSecret:                  "test-secret",
+		SecretHeaderName:              "X-Secret",
+		MCPWebSocket:                  true,
+		WebSocketMessageSamplePercent: 50,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	withSampleRoll(t, 60)
+	var messages int32
+	var extensions atomic.Value
+	proxy := httptest.NewServer(m.Handler(wsEchoUpstream(t, &messages, &extensions)))
+	defer proxy.Close()
+
+	conn, err := net.Dial("tcp", proxy.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	conn.Write([]byte("GET /mcp HTTP/1.1\r\nHost: api.example.com\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n"))
+	br := bufio.NewReader(conn)
+	if _, err := http.ReadResponse(br, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	writeWSFrame(conn, &wsFrame{fin: true, opcode: wsOpText, payload: []byte(`{"jsonrpc":"2.0","id":1,"method":"tools/list"}`)}, true)
+	f, err := readWSFrame(br, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(f.payload), `"echo":"tools/list"`) {
+		t.Errorf("expected the unevaluated upstream reply, got %s", f.payload)
+	}
+	if n := atomic.LoadInt32(&requestCalls); n != 1 || trafficTypes[0] != trafficTypeWebSocket {
+		t.Errorf("expected only the handshake to be evaluated as websocket, got %d calls %q", n, trafficTypes)
+	}
+	if n := atomic.LoadInt32(&responseCalls); n != 0 {
+		t.Errorf("expected no response phase call for an unsampled message, got %d", n)
+	}
+}
+
+func TestValidate_WebSocketMessageSamplePercent(t *testing.T) {
+	_, err := NewMiddleware(&Config{ServiceURL: "http://paz", SharedSecret: "s", SecretHeaderName: "X-Secret", WebSocketMessageSamplePercent: 101})
+	if err == nil || !strings.Contains(err.Error(), "websocket_message_sample_percent") {
+		t.Errorf("expected websocket_message_sample_percent error, got %v", err)
+	}
+}
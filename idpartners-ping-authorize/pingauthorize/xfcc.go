@@ -0,0 +1,97 @@
+package pingauthorize
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// xfccClientCertPEM returns the client certificate PEM carried by the client_cert_header header,
+// in the X-Forwarded-Client-Cert format of Envoy and Istio. Returns "" when the header is not
+// configured or not present, or its first element has neither a Chain nor a Cert.
+func xfccClientCertPEM(conf *Config, headers map[string][]string) (string, error) {
+	if conf.ClientCertHeader == "" {
+		return "", nil
+	}
+	value := ExtractHeaders(headers, []string{conf.ClientCertHeader})[strings.ToLower(conf.ClientCertHeader)]
+	if value == "" {
+		return "", nil
+	}
+	return parseXFCC(value)
+}
+
+// parseXFCC returns the URL-decoded Chain, or else Cert, of the first element of an XFCC value.
+// Each proxy appends an element, so the first one describes the original client.
+func parseXFCC(value string) (string, error) {
+	elements := splitXFCC(value, ',')
+	if len(elements) == 0 {
+		return "", nil
+	}
+
+	var cert, chain string
+	for _, pair := range splitXFCC(elements[0], ';') {
+		key, val, ok := strings.Cut(pair, "=")
+		if !ok {
+			return "", fmt.Errorf("invalid XFCC field %q", pair)
+		}
+		switch {
+		case strings.EqualFold(strings.TrimSpace(key), "Cert"):
+			cert = unquoteXFCC(val)
+		case strings.EqualFold(strings.TrimSpace(key), "Chain"):
+			chain = unquoteXFCC(val)
+		}
+	}
+	encoded := chain
+	if encoded == "" {
+		encoded = cert
+	}
+	if encoded == "" {
+		return "", nil
+	}
+	// PathUnescape, unlike QueryUnescape, keeps '+' of unescaped base64
+	pemData, err := url.PathUnescape(encoded)
+	if err != nil {
+		return "", fmt.Errorf("invalid XFCC certificate encoding: %w", err)
+	}
+	return pemData, nil
+}
+
+// splitXFCC splits s on sep outside double-quoted values. Empty parts are dropped.
+func splitXFCC(s string, sep byte) []string {
+	var parts []string
+	start, quoted := 0, false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quoted:
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			if part := strings.TrimSpace(s[start:i]); part != "" {
+				parts = append(parts, part)
+			}
+			start = i + 1
+		}
+	}
+	if part := strings.TrimSpace(s[start:]); part != "" {
+		parts = append(parts, part)
+	}
+	return parts
+}
+
+// unquoteXFCC removes the quotes of a quoted XFCC value and its backslash escapes.
+func unquoteXFCC(v string) string {
+	v = strings.TrimSpace(v)
+	if len(v) < 2 || v[0] != '"' || v[len(v)-1] != '"' {
+		return v
+	}
+	v = v[1 : len(v)-1]
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		if v[i] == '\\' && i+1 < len(v) {
+			i++
+		}
+		b.WriteByte(v[i])
+	}
+	return b.String()
+}
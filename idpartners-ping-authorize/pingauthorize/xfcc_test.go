@@ -0,0 +1,92 @@
+package pingauthorize
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestParseXFCC(t *testing.T) {
+	pemData := "-----BEGIN CERTIFICATE-----\nMIIB+a==\n-----END CERTIFICATE-----\n"
+	chainData := pemData + pemData
+	cert := url.PathEscape(pemData)
+	chain := url.PathEscape(chainData)
+
+	tests := []struct {
+		name    string
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{"cert", `By=spiffe://cluster.local/ns/api/sa/gw;Hash=abc;Cert="` + cert + `";Subject="CN=client,O=Acme"`, pemData, false},
+		{"chain preferred", `Hash=abc;Cert="` + cert + `";Chain="` + chain + `"`, chainData, false},
+		{"unquoted", `Cert=` + cert, pemData, false},
+		{"first element", `Cert="` + cert + `",By=spiffe://b;Cert="` + url.PathEscape("other") + `"`, pemData, false},
+		{"quoted separators", `Subject="CN=a,O=\"b;c\"";URI=spiffe://a;Cert="` + cert + `"`, pemData, false},
+		{"no cert", `By=spiffe://a;Hash=abc;URI=spiffe://b`, "", false},
+		{"empty", ``, "", false},
+		{"invalid field", `Cert`, "", true},
+		{"invalid encoding", `Cert="%zz"`, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseXFCC(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("pem = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMiddleware_XFCCClientCertificate(t *testing.T) {
+	var seen SidebandAccessRequest
+	server := mockPingAuthorize(func(w http.ResponseWriter, r *http.Request) {
+		seen = SidebandAccessRequest{}
+		json.NewDecoder(r.Body).Decode(&seen)
+		json.NewEncoder(w).Encode(SidebandAccessResponse{Response: &DenyResponse{ResponseCode: "403"}})
+	})
+	defer server.Close()
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	pemData, err := generateSelfSignedCert(key, &key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xfcc := `Hash=abc;Cert="` + url.PathEscape(pemData) + `";URI=spiffe://cluster.local/ns/web/sa/client`
+
+	tests := []struct {
+		name     string
+		header   string
+		wantCert bool
+	}{
+		{"configured", "X-Forwarded-Client-Cert", true},
+		{"not configured", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestMiddleware(t, server.URL, false)
+			m.conf.ClientCertHeader = tt.header
+			calls := 0
+			h := m.Handler(upstreamEcho(t, &calls))
+
+			req := httptest.NewRequest("GET", "http://api.example.com/accounts", nil)
+			req.Header.Set("X-Forwarded-Client-Cert", xfcc)
+			h.ServeHTTP(httptest.NewRecorder(), req)
+
+			if got := seen.ClientCertificate != nil; got != tt.wantCert {
+				t.Fatalf("client_certificate present = %v, want %v", got, tt.wantCert)
+			}
+			if tt.wantCert && (seen.ClientCertificate.Kty != "EC" || seen.ClientCertificate.Crv != "P-256") {
+				t.Errorf("unexpected client_certificate: %+v", seen.ClientCertificate)
+			}
+		})
+	}
+}